@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"server/api/cmd/service"
+	apiservice "server/api/pkg/service"
+	"server/kit/trace"
 )
 
 var httpAddr = flag.String("httpAddr", "idlp_api:8888", "http service address")
@@ -13,9 +15,23 @@ var amqpAddr = flag.String("amqpAddr", "idlp_rabbitmq:5672", "amqp service addre
 var amqpUser = flag.String("amqpUser", "guest", "amqp service user")
 var amqpPass = flag.String("amqpPass", "guest", "amqp service password")
 var oteProblemsPath = flag.String("oteProblemsPath", "/ote/pytorch_toolkit", "problem folder path")
+var apiTokensPath = flag.String("apiTokensPath", "", "api tokens file, authentication is disabled when empty")
+var downstreamReadyz = flag.String(
+	"downstreamReadyz",
+	"db=http://idlp_db:2112/readyz,problem=http://idlp_problem:2112/readyz,model=http://idlp_model:2112/readyz,build=http://idlp_build:2112/readyz,cvat_task=http://idlp_cvat_task:2112/readyz,asset=http://idlp_asset:2112/readyz,train_worker=http://idlp_train_worker:2112/readyz",
+	"comma separated name=url readiness endpoints of the services, aggregated by /readyz",
+)
+var otlpEndpoint = flag.String("otlpEndpoint", "", "OTLP/HTTP collector receiving the traces, e.g. http://otel-collector:4318, disabled when empty")
+var traceSampleRatio = flag.Float64("traceSampleRatio", 0.1, "share of the traces started here that are recorded")
+var rateLimitRps = flag.Float64("rateLimitRps", 20, "requests per second allowed to each user and each address, unlimited when 0")
+var rateLimitBurst = flag.Int("rateLimitBurst", 40, "requests a user or an address may send at once")
+var maxBodyBytes = flag.Int64("maxBodyBytes", 1<<20, "maximum size of a rest request body, unlimited when 0")
+var maxWsMessageBytes = flag.Int64("maxWsMessageBytes", 1<<20, "maximum size of a websocket message, unlimited when 0")
+var maxStreamsPerClient = flag.Int("maxStreamsPerClient", 16, "operations a user or an address may have open at once, unlimited when 0")
 
 func main() {
 	flag.Parse()
+	trace.Init("api", *otlpEndpoint, *traceSampleRatio)
 	go NeverExit("API")
 	select {}
 }
@@ -29,5 +45,11 @@ func NeverExit(serviceName string) {
 			go NeverExit(serviceName) // restart
 		}
 	}()
-	service.Run(*httpAddr, *amqpUser, *amqpPass, *amqpAddr, *oteProblemsPath)
+	service.Run(*httpAddr, *amqpUser, *amqpPass, *amqpAddr, *oteProblemsPath, *apiTokensPath, *downstreamReadyz, apiservice.LimitSettings{
+		RequestsPerSecond:   *rateLimitRps,
+		Burst:               *rateLimitBurst,
+		MaxBodyBytes:        *maxBodyBytes,
+		MaxWsMessageBytes:   *maxWsMessageBytes,
+		MaxStreamsPerClient: *maxStreamsPerClient,
+	})
 }
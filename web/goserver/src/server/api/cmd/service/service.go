@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	fp "path/filepath"
+	"strings"
 
 	"github.com/gorilla/websocket"
 	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
@@ -17,6 +18,9 @@ import (
 	t "server/db/pkg/types"
 	modelUpdateFromLocal "server/domains/model/pkg/handler/update_from_local"
 	problemUpdateFromLocal "server/domains/problem/pkg/handler/update_from_local"
+	"server/kit/auth"
+	"server/kit/health"
+	"server/kit/trace"
 
 	kitutils "server/kit/utils"
 	"server/kit/utils/basic/arrays"
@@ -30,13 +34,16 @@ var (
 	rabbitCloseError chan *amqp.Error
 )
 
-func Run(httpAddr, amqpUser, amqpPass, amqpAddr, oteProblemsPath string) {
+func Run(httpAddr, amqpUser, amqpPass, amqpAddr, oteProblemsPath, apiTokensPath, downstreamReadyz string, limitSettings service.LimitSettings) {
 	log.Println("API Started")
+	authenticator := loadAuthenticator(apiTokensPath)
 	amqpUrl := fmt.Sprintf("amqp://%s:%s@%s/", amqpUser, amqpPass, amqpAddr)
 	conn, err := rabbitmq.Dial(amqpUrl)
 	if err != nil {
 		log.Panic(err)
 	}
+	health.Set(health.CheckAmqp, health.Amqp(conn))
+	setDownstreamChecks(downstreamReadyz)
 	defer conn.Close()
 	ch, err := conn.Channel()
 	if err != nil {
@@ -50,13 +57,46 @@ func Run(httpAddr, amqpUser, amqpPass, amqpAddr, oteProblemsPath string) {
 	upgrader.CheckOrigin = func(r *http.Request) bool {
 		return true
 	}
-	wsHandler := makeWsHandler(conn)
-	http.HandleFunc("/api/ws", wsHandler)
+	limits := service.NewLimits(limitSettings)
+	wsHandler := makeWsHandler(conn, limits)
+	http.Handle("/api/ws", service.Authenticate(authenticator, service.Limit(limits, http.HandlerFunc(wsHandler))))
+	http.Handle("/api/v1/", trace.Handler("api", service.Authenticate(authenticator, service.Limit(limits, service.NewRestHandler(conn, limits)))))
+	health.Handle(http.DefaultServeMux)
 	log.Fatal(http.ListenAndServe(httpAddr, nil))
 	log.Println("THE END")
 }
 
-func makeWsHandler(conn *rabbitmq.Connection) func(http.ResponseWriter, *http.Request) {
+// loadAuthenticator reads the api tokens file. Without one the api stays open
+// and the services skip the access checks.
+func loadAuthenticator(path string) auth.Authenticator {
+	if path == "" {
+		log.Println("api tokens are not set, authentication is disabled")
+		return nil
+	}
+	tokens, err := auth.LoadStaticTokens(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	return tokens
+}
+
+// setDownstreamChecks adds the readiness of the services listed in
+// downstream, comma separated name=url pairs, to the readiness of the api.
+func setDownstreamChecks(downstream string) {
+	for _, pair := range strings.Split(downstream, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			log.Panic("downstreamReadyz: expected name=url, got ", pair)
+		}
+		health.Set(pair[:i], health.Downstream(pair[i+1:]))
+	}
+}
+
+func makeWsHandler(conn *rabbitmq.Connection, limits *service.Limits) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Println("open WS")
 		wsConn, err := upgrader.Upgrade(w, r, nil)
@@ -72,6 +112,8 @@ func makeWsHandler(conn *rabbitmq.Connection) func(http.ResponseWriter, *http.Re
 			conn,
 			servicesPubQueues,
 			// serviceSubQueue,
+			limits,
+			service.ClientKeys(r),
 		}
 		ctx := r.Context()
 		go proxy.WSRead(ctx)
@@ -135,14 +177,24 @@ func searchModels(conn *rabbitmq.Connection, root string) {
 
 }
 
-func updateModel(conn *rabbitmq.Connection, path string) t.Model {
-	modelRes := <-modelUpdateFromLocal.Send(
+// updateModel waits for the terminal response, which summarizes the models
+// imported from the template.
+func updateModel(conn *rabbitmq.Connection, path string) (result modelUpdateFromLocal.SummaryData) {
+	modelResChan := modelUpdateFromLocal.Send(
 		context.TODO(),
 		conn,
 		modelUpdateFromLocal.RequestData{
 			Path: path,
 		},
 	)
-	model := modelRes.Data.(modelUpdateFromLocal.ResponseData)
-	return model
+	for modelRes := range modelResChan {
+		if modelRes.IsLast {
+			result = modelRes.Data.(modelUpdateFromLocal.SummaryData)
+			break
+		}
+	}
+	for _, failure := range result.Failed {
+		log.Println("api.cmd.servive.service.updateModel", path, failure.Name, failure.Message)
+	}
+	return result
 }
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"net/http"
+
+	"server/kit/auth"
+	longendpoint "server/kit/endpoint"
+)
+
+// Authenticate resolves the api token of the request to the caller identity
+// and passes it on in the request context. The token is read from the
+// "Authorization: Bearer" header or, for websocket clients that cannot set
+// headers, from the token query parameter. A nil authenticator disables
+// authentication.
+func Authenticate(a auth.Authenticator, next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := auth.BearerToken(r.Header.Get("Authorization"))
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		identity, err := a.Authenticate(token)
+		if err != nil {
+			writeError(w, longendpoint.Error{Code: longendpoint.ErrCodeUnauthenticated, Message: err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+	})
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"server/kit/auth"
+	longendpoint "server/kit/endpoint"
+	"server/kit/ratelimit"
+)
+
+// LimitSettings bounds what a single client may ask of the gateway. A client
+// is both its user, once authenticated, and its address: each gets
+// RequestsPerSecond requests with bursts of Burst, http requests and
+// websocket messages alike. Zero values disable a limit.
+type LimitSettings struct {
+	RequestsPerSecond   float64
+	Burst               int
+	MaxBodyBytes        int64
+	MaxWsMessageBytes   int64
+	MaxStreamsPerClient int
+}
+
+// Quota is the rate limit state of a client, sent along with the responses
+// so that clients can throttle themselves. RetryAfter is in seconds.
+type Quota struct {
+	Limit      int `json:"limit"`
+	Remaining  int `json:"remaining"`
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+// Limits enforces LimitSettings. A nil *Limits allows everything.
+type Limits struct {
+	settings LimitSettings
+	requests *ratelimit.Limiter
+
+	mu      sync.Mutex
+	streams map[string]int
+}
+
+func NewLimits(settings LimitSettings) *Limits {
+	l := &Limits{settings: settings, streams: make(map[string]int)}
+	if settings.RequestsPerSecond > 0 {
+		l.requests = ratelimit.New(settings.RequestsPerSecond, settings.Burst)
+	}
+	return l
+}
+
+// Limit refuses the requests of the clients over their rate limit and caps
+// the size of the request bodies. It runs after Authenticate, so that
+// authenticated clients are limited by user as well as by address.
+func Limit(l *Limits, next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		quota, ok := l.allow(ClientKeys(r))
+		if quota != nil {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(quota.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(quota.Remaining))
+		}
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(quota.RetryAfter))
+			writeError(w, rateLimitedError(quota))
+			return
+		}
+		if l.settings.MaxBodyBytes > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, l.settings.MaxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientKeys names the limits of the client of r, the user first when there
+// is one.
+func ClientKeys(r *http.Request) []string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	keys := []string{"ip:" + host}
+	if identity, ok := auth.FromContext(r.Context()); ok {
+		keys = append([]string{"user:" + identity.User}, keys...)
+	}
+	return keys
+}
+
+// allow takes a request from the quota of every key. The returned quota is
+// the tightest of them, nil when requests are not limited.
+func (l *Limits) allow(keys []string) (*Quota, bool) {
+	if l == nil || l.requests == nil {
+		return nil, true
+	}
+	now := time.Now()
+	quota := &Quota{Limit: l.requests.Burst(), Remaining: l.requests.Burst()}
+	allowed := true
+	for _, key := range keys {
+		ok, remaining, wait := l.requests.Allow(key, now)
+		if !ok {
+			allowed = false
+			if seconds := int((wait + time.Second - 1) / time.Second); seconds > quota.RetryAfter {
+				quota.RetryAfter = seconds
+			}
+		}
+		if remaining < quota.Remaining {
+			quota.Remaining = remaining
+		}
+	}
+	if allowed {
+		quota.RetryAfter = 0
+	}
+	return quota, allowed
+}
+
+// acquireStream counts a streaming operation of the client named by key. The
+// returned func ends it.
+func (l *Limits) acquireStream(key string) (func(), error) {
+	if l == nil || l.settings.MaxStreamsPerClient <= 0 {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.streams[key] >= l.settings.MaxStreamsPerClient {
+		return nil, fmt.Errorf("%d operations are already open, wait for one of them to end", l.streams[key])
+	}
+	l.streams[key]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.streams[key]--
+			if l.streams[key] <= 0 {
+				delete(l.streams, key)
+			}
+		})
+	}, nil
+}
+
+// maxWsMessageBytes is the read limit of the websocket connections, zero
+// when it is not limited.
+func (l *Limits) maxWsMessageBytes() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.settings.MaxWsMessageBytes
+}
+
+func rateLimitedError(quota *Quota) longendpoint.Error {
+	return longendpoint.Error{
+		Code:    longendpoint.ErrCodeRateLimited,
+		Message: fmt.Sprintf("too many requests, retry in %d seconds", quota.RetryAfter),
+	}
+}
+
+func tooManyStreamsError(err error) longendpoint.Error {
+	return longendpoint.Error{Code: longendpoint.ErrCodeRateLimited, Message: err.Error()}
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFineTune "server/domains/model/pkg/handler/fine_tune"
+	modelGetDetails "server/domains/model/pkg/handler/get_details"
+	modelList "server/domains/model/pkg/handler/list"
+	modelUpdateFromLocal "server/domains/model/pkg/handler/update_from_local"
+	longendpoint "server/kit/endpoint"
+	kithandler "server/kit/handler"
+)
+
+const restModelsPath = "/api/v1/models"
+
+// RestProxy exposes the model service over plain HTTP for clients that do not
+// speak the websocket protocol. Single responses are written as JSON, streams
+// as Server-Sent Events.
+type RestProxy struct {
+	Conn   *rabbitmq.Connection
+	Limits *Limits
+}
+
+func NewRestHandler(conn *rabbitmq.Connection, limits *Limits) http.Handler {
+	p := &RestProxy{conn, limits}
+	mux := http.NewServeMux()
+	mux.HandleFunc(restModelsPath, p.models)
+	mux.HandleFunc(restModelsPath+"/", p.model)
+	mux.HandleFunc(restAuditPath, p.audit)
+	return mux
+}
+
+// models serves GET /api/v1/models?problemId=...&page=...&size=...&tags=a,b&includeArchived=true
+func (p *RestProxy) models(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	query := r.URL.Query()
+	req := modelList.RequestData{}
+	if problemId := query.Get("problemId"); problemId != "" {
+		id, err := primitive.ObjectIDFromHex(problemId)
+		if err != nil {
+			writeInvalidArgument(w, fmt.Sprintf("invalid problemId %q", problemId))
+			return
+		}
+		req.ProblemId = id
+	}
+	var err error
+	if req.Page, err = parseQueryInt(query.Get("page")); err != nil {
+		writeInvalidArgument(w, fmt.Sprintf("invalid page %q", query.Get("page")))
+		return
+	}
+	if req.Size, err = parseQueryInt(query.Get("size")); err != nil {
+		writeInvalidArgument(w, fmt.Sprintf("invalid size %q", query.Get("size")))
+		return
+	}
+	if tags := query.Get("tags"); tags != "" {
+		req.Tags = strings.Split(tags, ",")
+	}
+	if includeArchived := query.Get("includeArchived"); includeArchived != "" {
+		if req.IncludeArchived, err = strconv.ParseBool(includeArchived); err != nil {
+			writeInvalidArgument(w, fmt.Sprintf("invalid includeArchived %q", includeArchived))
+			return
+		}
+	}
+	writeSingle(w, p.sendEvent(r.Context(), modelList.Event, req))
+}
+
+// model serves POST /api/v1/models/import, GET /api/v1/models/{id},
+// POST /api/v1/models/{id}/train, GET /api/v1/models/{id}/readme,
+// GET /api/v1/models/{id}/snapshot and GET /api/v1/models/{id}/previews/{name}.
+func (p *RestProxy) model(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, restModelsPath), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "import":
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w)
+			return
+		}
+		p.importModel(w, r)
+	case len(parts) == 1 && parts[0] != "":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w)
+			return
+		}
+		p.getModel(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "train":
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w)
+			return
+		}
+		p.trainModel(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "readme":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w)
+			return
+		}
+		p.getReadme(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "snapshot":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w)
+			return
+		}
+		p.getSnapshot(w, r, parts[0])
+	case len(parts) > 2 && parts[1] == "previews":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w)
+			return
+		}
+		p.getPreview(w, r, parts[0], strings.Join(parts[2:], "/"))
+	default:
+		writeError(w, longendpoint.Error{Code: longendpoint.ErrCodeNotFound, Message: "route not found"})
+	}
+}
+
+func (p *RestProxy) importModel(w http.ResponseWriter, r *http.Request) {
+	var req modelUpdateFromLocal.RequestData
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidArgument(w, err.Error())
+		return
+	}
+	if req.Path == "" {
+		writeInvalidArgument(w, "path is required")
+		return
+	}
+	release, err := p.acquireStream(r)
+	if err != nil {
+		writeError(w, tooManyStreamsError(err))
+		return
+	}
+	defer release()
+	writeSingle(w, modelUpdateFromLocal.Send(r.Context(), p.Conn, req))
+}
+
+func (p *RestProxy) getModel(w http.ResponseWriter, r *http.Request, modelId string) {
+	id, err := primitive.ObjectIDFromHex(modelId)
+	if err != nil {
+		writeInvalidArgument(w, fmt.Sprintf("invalid model id %q", modelId))
+		return
+	}
+	writeSingle(w, p.sendEvent(r.Context(), modelGetDetails.Event, modelGetDetails.RequestData{ModelId: id}))
+}
+
+func (p *RestProxy) trainModel(w http.ResponseWriter, r *http.Request, modelId string) {
+	if _, err := primitive.ObjectIDFromHex(modelId); err != nil {
+		writeInvalidArgument(w, fmt.Sprintf("invalid model id %q", modelId))
+		return
+	}
+	var req modelFineTune.RequestData
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidArgument(w, err.Error())
+		return
+	}
+	req.ParentModelId = modelId
+	release, err := p.acquireStream(r)
+	if err != nil {
+		writeError(w, tooManyStreamsError(err))
+		return
+	}
+	defer release()
+	writeStream(w, r, p.sendEvent(r.Context(), modelFineTune.Event, req))
+}
+
+// acquireStream counts the long-running request r against the open
+// operations of its client.
+func (p *RestProxy) acquireStream(r *http.Request) (func(), error) {
+	return p.Limits.acquireStream(ClientKeys(r)[0])
+}
+
+// sendEvent publishes the request the same way the websocket proxy does, so
+// the services handle REST calls with their UI event handlers.
+func (p *RestProxy) sendEvent(ctx context.Context, event string, data interface{}) chan longendpoint.Response {
+	return kithandler.SendRequest(
+		ctx,
+		p.Conn,
+		getPublishKey(event),
+		WSRequest{Event: event, Data: data},
+		PubRequestEncode,
+		PubResponseDecode,
+		true,
+	)
+}
+
+// writeSingle replies with the terminal response of resChan.
+func writeSingle(w http.ResponseWriter, resChan chan longendpoint.Response) {
+	for res := range resChan {
+		if res.IsLast {
+			writeResponse(w, res)
+			return
+		}
+	}
+	writeError(w, longendpoint.Error{Code: longendpoint.ErrCodeUnknown, Message: "no response"})
+}
+
+// writeStream forwards every response of resChan as a Server-Sent Event,
+// "progress" for intermediate ones and "result" or "error" for the last.
+func writeStream(w http.ResponseWriter, r *http.Request, resChan chan longendpoint.Response) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, longendpoint.Error{Code: longendpoint.ErrCodeUnknown, Message: "streaming unsupported"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case res, ok := <-resChan:
+			if !ok {
+				return
+			}
+			event := "progress"
+			if res.IsLast && res.Err.Code != longendpoint.ErrCodeOk {
+				event = "error"
+			} else if res.IsLast {
+				event = "result"
+			}
+			b, err := json.Marshal(res)
+			if err != nil {
+				log.Println("api.pkg.service.rest.writeStream.json.Marshal", err)
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+			flusher.Flush()
+			if res.IsLast {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeResponse(w http.ResponseWriter, res longendpoint.Response) {
+	if res.Err.Code != longendpoint.ErrCodeOk {
+		writeError(w, res.Err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res.Data)
+}
+
+func writeError(w http.ResponseWriter, err longendpoint.Error) {
+	writeJSON(w, httpStatus(err.Code), struct {
+		Err longendpoint.Error `json:"err"`
+	}{err})
+}
+
+func writeInvalidArgument(w http.ResponseWriter, message string) {
+	writeError(w, longendpoint.Error{Code: longendpoint.ErrCodeInvalidArgument, Message: message})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeJSON(w, http.StatusMethodNotAllowed, struct {
+		Err longendpoint.Error `json:"err"`
+	}{longendpoint.Error{Code: longendpoint.ErrCodeInvalidArgument, Message: "method not allowed"}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("api.pkg.service.rest.writeJSON", err)
+	}
+}
+
+// httpStatus maps the error codes of the services to HTTP statuses.
+func httpStatus(code int) int {
+	switch code {
+	case longendpoint.ErrCodeOk:
+		return http.StatusOK
+	case longendpoint.ErrCodeBusy:
+		return http.StatusServiceUnavailable
+	case longendpoint.ErrCodeQuotaExceeded:
+		return http.StatusInsufficientStorage
+	case longendpoint.ErrCodeNotFound:
+		return http.StatusNotFound
+	case longendpoint.ErrCodeInvalidArgument:
+		return http.StatusBadRequest
+	case longendpoint.ErrCodeUnauthenticated:
+		return http.StatusUnauthorized
+	case longendpoint.ErrCodeForbidden, longendpoint.ErrCodePathViolation:
+		return http.StatusForbidden
+	case longendpoint.ErrCodeRateLimited:
+		return http.StatusTooManyRequests
+	case longendpoint.ErrCodeCancelled, longendpoint.ErrCodeConflict:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func parseQueryInt(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelGetPreview "server/domains/model/pkg/handler/get_preview"
+	modelGetReadme "server/domains/model/pkg/handler/get_readme"
+	longendpoint "server/kit/endpoint"
+)
+
+// readmePolicy keeps the rendered readme from running anything, its images
+// may come from the previews or from other sites.
+const readmePolicy = "default-src 'none'; img-src 'self' https: http:; style-src 'unsafe-inline'"
+
+// getReadme serves the readme of a model as HTML. The assets are revalidated
+// on every use, answering 304 while their ETag matches.
+func (p *RestProxy) getReadme(w http.ResponseWriter, r *http.Request, modelId string) {
+	id, err := primitive.ObjectIDFromHex(modelId)
+	if err != nil {
+		writeInvalidArgument(w, fmt.Sprintf("invalid model id %q", modelId))
+		return
+	}
+	req := modelGetReadme.RequestData{ModelId: id, ETag: ifNoneMatch(r)}
+	var data modelGetReadme.ResponseData
+	if !p.getAsset(w, r, modelGetReadme.Event, req, &data) {
+		return
+	}
+	if writeNotModified(w, data.ETag, data.NotModified) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", readmePolicy)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(data.Html)); err != nil {
+		log.Println("api.pkg.service.rest_assets.getReadme.w.Write", err)
+	}
+}
+
+func (p *RestProxy) getPreview(w http.ResponseWriter, r *http.Request, modelId, name string) {
+	id, err := primitive.ObjectIDFromHex(modelId)
+	if err != nil {
+		writeInvalidArgument(w, fmt.Sprintf("invalid model id %q", modelId))
+		return
+	}
+	req := modelGetPreview.RequestData{ModelId: id, Name: name, ETag: ifNoneMatch(r)}
+	var data modelGetPreview.ResponseData
+	if !p.getAsset(w, r, modelGetPreview.Event, req, &data) {
+		return
+	}
+	if writeNotModified(w, data.ETag, data.NotModified) {
+		return
+	}
+	w.Header().Set("Content-Type", data.ContentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data.Content); err != nil {
+		log.Println("api.pkg.service.rest_assets.getPreview.w.Write", err)
+	}
+}
+
+// getAsset sends the request for an asset and decodes the data of the
+// response into v, it writes the error and returns false otherwise.
+func (p *RestProxy) getAsset(w http.ResponseWriter, r *http.Request, event string, req interface{}, v interface{}) bool {
+	for res := range p.sendEvent(r.Context(), event, req) {
+		if !res.IsLast {
+			continue
+		}
+		if res.Err.Code != longendpoint.ErrCodeOk {
+			writeError(w, res.Err)
+			return false
+		}
+		b, err := json.Marshal(res.Data)
+		if err == nil {
+			err = json.Unmarshal(b, v)
+		}
+		if err != nil {
+			writeError(w, longendpoint.Error{Code: longendpoint.ErrCodeUnknown, Message: err.Error()})
+			return false
+		}
+		return true
+	}
+	writeError(w, longendpoint.Error{Code: longendpoint.ErrCodeUnknown, Message: "no response"})
+	return false
+}
+
+// ifNoneMatch is the ETag the client has, without quotes.
+func ifNoneMatch(r *http.Request) string {
+	return strings.Trim(strings.TrimPrefix(r.Header.Get("If-None-Match"), "W/"), `"`)
+}
+
+// writeNotModified sets the caching headers of an asset, and answers 304 when
+// the client already has it.
+func writeNotModified(w http.ResponseWriter, etag string, notModified bool) bool {
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
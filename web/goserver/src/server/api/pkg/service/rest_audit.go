@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	auditFind "server/db/pkg/handler/audit/find"
+	"server/kit/auth"
+	longendpoint "server/kit/endpoint"
+)
+
+const restAuditPath = "/api/v1/audit"
+
+// audit serves GET /api/v1/audit?user=...&problemId=...&action=...&from=...&to=...&page=...&size=...
+// with from and to in RFC 3339. Only admins may read the log once
+// authentication is enabled.
+func (p *RestProxy) audit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if identity, ok := auth.FromContext(r.Context()); ok && !identity.HasRole(auth.RoleAdmin) {
+		writeError(w, longendpoint.Error{Code: longendpoint.ErrCodeForbidden, Message: "the audit log is only readable by admins"})
+		return
+	}
+	query := r.URL.Query()
+	req := auditFind.RequestData{
+		Action: query.Get("action"),
+		User:   query.Get("user"),
+	}
+	if problemId := query.Get("problemId"); problemId != "" {
+		id, err := primitive.ObjectIDFromHex(problemId)
+		if err != nil {
+			writeInvalidArgument(w, fmt.Sprintf("invalid problemId %q", problemId))
+			return
+		}
+		req.ProblemId = id
+	}
+	var err error
+	if req.From, err = parseQueryTime(query.Get("from")); err != nil {
+		writeInvalidArgument(w, fmt.Sprintf("invalid from %q", query.Get("from")))
+		return
+	}
+	if req.To, err = parseQueryTime(query.Get("to")); err != nil {
+		writeInvalidArgument(w, fmt.Sprintf("invalid to %q", query.Get("to")))
+		return
+	}
+	if req.Page, err = parseQueryInt(query.Get("page")); err != nil {
+		writeInvalidArgument(w, fmt.Sprintf("invalid page %q", query.Get("page")))
+		return
+	}
+	if req.Size, err = parseQueryInt(query.Get("size")); err != nil {
+		writeInvalidArgument(w, fmt.Sprintf("invalid size %q", query.Get("size")))
+		return
+	}
+	writeResponse(w, <-auditFind.Send(r.Context(), p.Conn, req))
+}
+
+func parseQueryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelDownloadSnapshot "server/domains/model/pkg/handler/download_snapshot"
+	"server/domains/model/pkg/service"
+	longendpoint "server/kit/endpoint"
+)
+
+// getSnapshot serves the snapshot of a model as an attachment, the IR models
+// as a zip of their xml and bin. A single byte range is honoured, so that an
+// interrupted download can be resumed, If-Range falling back to the whole
+// snapshot once it changed. The content is written as the service streams
+// it: an error past the headers aborts the connection, the client seeing a
+// short body rather than a complete one.
+func (p *RestProxy) getSnapshot(w http.ResponseWriter, r *http.Request, modelId string) {
+	id, err := primitive.ObjectIDFromHex(modelId)
+	if err != nil {
+		writeInvalidArgument(w, fmt.Sprintf("invalid model id %q", modelId))
+		return
+	}
+	req := modelDownloadSnapshot.RequestData{ModelId: id}
+	if offset, length, ok := parseRange(r.Header.Get("Range")); ok {
+		req.Offset, req.Length = offset, length
+		req.IfRange = strings.Trim(r.Header.Get("If-Range"), `"`)
+	}
+	release, err := p.acquireStream(r)
+	if err != nil {
+		writeError(w, tooManyStreamsError(err))
+		return
+	}
+	defer release()
+	started := false
+	for res := range p.sendEvent(r.Context(), modelDownloadSnapshot.Event, req) {
+		if res.Err.Code != longendpoint.ErrCodeOk {
+			if started {
+				log.Println("api.pkg.service.rest_snapshot.getSnapshot", modelId, res.Err.Message)
+				panic(http.ErrAbortHandler)
+			}
+			var data modelDownloadSnapshot.ResponseData
+			if decodeData(res.Data, &data) == nil && data.Unsatisfiable {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", data.Size))
+				writeJSON(w, http.StatusRequestedRangeNotSatisfiable, struct {
+					Err longendpoint.Error `json:"err"`
+				}{res.Err})
+				return
+			}
+			writeError(w, res.Err)
+			return
+		}
+		if !started {
+			var data modelDownloadSnapshot.ResponseData
+			if err := decodeData(res.Data, &data); err != nil {
+				writeError(w, longendpoint.Error{Code: longendpoint.ErrCodeUnknown, Message: err.Error()})
+				return
+			}
+			writeSnapshotHeader(w, data)
+			started = true
+			continue
+		}
+		if res.IsLast {
+			return
+		}
+		var chunk service.SnapshotChunk
+		if err := decodeData(res.Data, &chunk); err != nil {
+			log.Println("api.pkg.service.rest_snapshot.getSnapshot.decodeData", modelId, err)
+			panic(http.ErrAbortHandler)
+		}
+		if _, err := w.Write(chunk.Content); err != nil {
+			log.Println("api.pkg.service.rest_snapshot.getSnapshot.w.Write", modelId, err)
+			return
+		}
+	}
+	if !started {
+		writeError(w, longendpoint.Error{Code: longendpoint.ErrCodeUnknown, Message: "no response"})
+		return
+	}
+	log.Println("api.pkg.service.rest_snapshot.getSnapshot", modelId, "stream ended before the snapshot")
+	panic(http.ErrAbortHandler)
+}
+
+// writeSnapshotHeader writes the status and the headers of the snapshot
+// described by data.
+func writeSnapshotHeader(w http.ResponseWriter, data modelDownloadSnapshot.ResponseData) {
+	h := w.Header()
+	h.Set("Content-Type", data.ContentType)
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", data.Name))
+	h.Set("Content-Length", strconv.FormatInt(data.Length, 10))
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("ETag", `"`+data.Sha256+`"`)
+	h.Set("Cache-Control", "private, no-cache")
+	h.Set("X-Content-Type-Options", "nosniff")
+	if sum, err := hex.DecodeString(data.Sha256); err == nil {
+		h.Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+	}
+	if !data.Partial {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", data.Offset, data.Offset+data.Length-1, data.Size))
+	w.WriteHeader(http.StatusPartialContent)
+}
+
+// parseRange reads a Range header of a single range from a given byte,
+// "bytes=100-" or "bytes=100-199", into the offset and length of the
+// request, 0 being to the end. The other ranges are ignored, the whole
+// snapshot being sent, as HTTP allows.
+func parseRange(header string) (offset, length int64, ok bool) {
+	spec := strings.TrimSpace(header)
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(spec, "bytes="), "-", 2)
+	if len(bounds) != 2 || bounds[0] == "" {
+		return 0, 0, false
+	}
+	first, err := strconv.ParseInt(strings.TrimSpace(bounds[0]), 10, 64)
+	if err != nil || first < 0 {
+		return 0, 0, false
+	}
+	if strings.TrimSpace(bounds[1]) == "" {
+		return first, 0, true
+	}
+	last, err := strconv.ParseInt(strings.TrimSpace(bounds[1]), 10, 64)
+	if err != nil || last < first {
+		return 0, 0, false
+	}
+	return first, last - first + 1, true
+}
+
+// decodeData decodes the data of a response into v.
+func decodeData(data interface{}, v interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
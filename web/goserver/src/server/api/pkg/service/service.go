@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 
@@ -11,7 +12,9 @@ import (
 	"github.com/streadway/amqp"
 
 	n "server/common/names"
+	"server/kit/auth"
 	longendpoint "server/kit/endpoint"
+	"server/kit/trace"
 	kittransportamqp "server/kit/transport/amqp"
 )
 
@@ -22,6 +25,8 @@ type BasicProxy struct {
 	WsResponse        chan WSResponse
 	Conn              *rabbitmq.Connection
 	ServicesPubQueues map[string]*amqp.Queue // map[qName] requestQueue
+	Limits            *Limits
+	ClientKeys        []string // limits of the client, see ClientKeys
 }
 
 type Proxy interface {
@@ -35,9 +40,12 @@ type WSRequest struct {
 }
 
 type WSResponse struct {
-	Event string      `json:"event"`
-	Data  interface{} `json:"data"`
-	Err   interface{} `json:"err,omitempty"`
+	Event       string      `json:"event"`
+	Data        interface{} `json:"data"`
+	Err         interface{} `json:"err,omitempty"`
+	OperationId string      `json:"operationId,omitempty"`
+	TraceId     string      `json:"traceId,omitempty"`
+	RateLimit   *Quota      `json:"rateLimit,omitempty"`
 }
 
 func PubRequestEncode(_ context.Context, pub *amqp.Publishing, req interface{}) error {
@@ -61,11 +69,13 @@ func getPublishKey(eventName string) string {
 	return events[eventName]
 }
 
-func (p *BasicProxy) requestEndpoint(ctx context.Context, request WSRequest) {
+func (p *BasicProxy) requestEndpoint(ctx context.Context, request WSRequest, quota *Quota) {
 	publishKey := getPublishKey(request.Event)
 	ctx = context.WithValue(ctx, kittransportamqp.ContextKeyAutoAck, true)
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	ctx, span := trace.Start(ctx, "ws "+request.Event)
+	defer span.End()
 	ch, err := p.Conn.Channel()
 	if err != nil {
 		log.Println("Channel", err)
@@ -90,6 +100,8 @@ func (p *BasicProxy) requestEndpoint(ctx context.Context, request WSRequest) {
 		PubResponseDecode,
 		kittransportamqp.PublisherBefore(
 			kittransportamqp.SetPublishKey(publishKey),
+			auth.PublishIdentity(),
+			trace.PublishTrace(),
 		),
 	)
 	respChan := pub.Endpoint()(ctx, request)
@@ -99,8 +111,11 @@ func (p *BasicProxy) requestEndpoint(ctx context.Context, request WSRequest) {
 			if ok == false {
 				return
 			}
-			p.WsResponse <- WSResponse{request.Event, res.Data, res.Err}
+			p.WsResponse <- WSResponse{request.Event, res.Data, res.Err, res.OperationId, span.TraceId(), quota}
 			if res.IsLast == true {
+				if res.Err.Code != longendpoint.ErrCodeOk {
+					span.SetError(errors.New(res.Err.Message))
+				}
 				return
 			}
 		case <-ctx.Done():
@@ -108,9 +123,9 @@ func (p *BasicProxy) requestEndpoint(ctx context.Context, request WSRequest) {
 			// err := p.unsubscribe(request.Event)
 			if err != nil {
 				fmt.Println("unsubscribe", err)
-				p.WsResponse <- WSResponse{request.Event, ctx.Err(), err.Error()}
+				p.WsResponse <- WSResponse{request.Event, ctx.Err(), err.Error(), "", span.TraceId(), quota}
 			}
-			p.WsResponse <- WSResponse{request.Event, ctx.Err(), nil}
+			p.WsResponse <- WSResponse{request.Event, ctx.Err(), nil, "", span.TraceId(), quota}
 
 			return
 		}
@@ -121,6 +136,9 @@ func (p *BasicProxy) WSRead(ctx context.Context) {
 	var request WSRequest
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	if max := p.Limits.maxWsMessageBytes(); max > 0 {
+		p.WsConn.SetReadLimit(max)
+	}
 	for {
 		err := p.WsConn.ReadJSON(&request)
 		if err != nil {
@@ -139,10 +157,26 @@ func (p *BasicProxy) WSRead(ctx context.Context) {
 				request.Event,
 				"Event Not Exists",
 				err,
+				"",
+				"",
+				nil,
 			}
 			continue
 		}
-		go p.requestEndpoint(ctx, request)
+		quota, ok := p.Limits.allow(p.ClientKeys)
+		if !ok {
+			p.WsResponse <- WSResponse{request.Event, nil, rateLimitedError(quota), "", "", quota}
+			continue
+		}
+		release, err := p.Limits.acquireStream(p.ClientKeys[0])
+		if err != nil {
+			p.WsResponse <- WSResponse{request.Event, nil, tooManyStreamsError(err), "", "", quota}
+			continue
+		}
+		go func(request WSRequest) {
+			defer release()
+			p.requestEndpoint(ctx, request, quota)
+		}(request)
 	}
 }
 
@@ -3,23 +3,70 @@ package names
 // Websocket request/response event
 // Communication between Client <-> API Service <-> Other services
 const (
-	EAssetDumpAnnotation = "ASSET_DUMP_ANNOTATION"
-	EAssetFindInFolder   = "ASSET_FIND_IN_FOLDER"
-	EAssetSetupToCvat    = "ASSET_SETUP_TO_CVAT"
+	EAssetDatasetStats         = "ASSET_DATASET_STATS"
+	EAssetDumpAnnotation       = "ASSET_DUMP_ANNOTATION"
+	EAssetExportDataset        = "ASSET_EXPORT_DATASET"
+	EAssetFindInFolder         = "ASSET_FIND_IN_FOLDER"
+	EAssetImportDataset        = "ASSET_IMPORT_DATASET"
+	EAssetListFlaggedImages    = "ASSET_LIST_FLAGGED_IMAGES"
+	EAssetResolveFlaggedImages = "ASSET_RESOLVE_FLAGGED_IMAGES"
+	EAssetSetupToCvat          = "ASSET_SETUP_TO_CVAT"
 
 	EBuildCreate           = "BUILD_CREATE"
+	EBuildGenerateSplit    = "BUILD_GENERATE_SPLIT"
 	EBuildList             = "BUILD_LIST"
 	EBuildUpdateAssetState = "BUILD_UPDATE_ASSET_STATE"
 
-	EModelDelete   = "MODEL_DELETE"
-	EModelEvaluate = "MODEL_EVALUATE"
-	EModelFineTune = "MODEL_FINE_TUNE"
-	EModelList     = "MODEL_LIST"
-
-	EProblemCreate  = "PROBLEM_CREATE"
-	EProblemDelete  = "PROBLEM_DELETE"
-	EProblemDetails = "PROBLEM_DETAILS"
-	EProblemList    = "PROBLEM_LIST"
+	EModelArchive                = "MODEL_ARCHIVE"
+	EModelBulkUpdate             = "MODEL_BULK_UPDATE"
+	EModelCacheStats             = "MODEL_CACHE_STATS"
+	EModelCancelOperation        = "MODEL_CANCEL_OPERATION"
+	EModelCleanup                = "MODEL_CLEANUP"
+	EModelClone                  = "MODEL_CLONE"
+	EModelCollectSnapshots       = "MODEL_COLLECT_SNAPSHOTS"
+	EModelCompare                = "MODEL_COMPARE"
+	EModelCreateWebhook          = "MODEL_CREATE_WEBHOOK"
+	EModelDelete                 = "MODEL_DELETE"
+	EModelDeleteWebhook          = "MODEL_DELETE_WEBHOOK"
+	EModelDismissStale           = "MODEL_DISMISS_STALE"
+	EModelEvaluate               = "MODEL_EVALUATE"
+	EModelFineTune               = "MODEL_FINE_TUNE"
+	EModelGetDetails             = "MODEL_GET_DETAILS"
+	EModelGetOperation           = "MODEL_GET_OPERATION"
+	EModelGetPreview             = "MODEL_GET_PREVIEW"
+	EModelGetReadme              = "MODEL_GET_README"
+	EModelGetTemplate            = "MODEL_GET_TEMPLATE"
+	EModelImportDirectory        = "MODEL_IMPORT_DIRECTORY"
+	EModelList                   = "MODEL_LIST"
+	EModelListWebhookDeadLetters = "MODEL_LIST_WEBHOOK_DEAD_LETTERS"
+	EModelListWebhooks           = "MODEL_LIST_WEBHOOKS"
+	EModelMaterialize            = "MODEL_MATERIALIZE"
+	EModelPruneCache             = "MODEL_PRUNE_CACHE"
+	EModelMigrateStoragePaths    = "MODEL_MIGRATE_STORAGE_PATHS"
+	EModelCheckFolderCollisions  = "MODEL_CHECK_FOLDER_COLLISIONS"
+	EModelReEvaluateStale        = "MODEL_RE_EVALUATE_STALE"
+	EModelRegenerateMetricsFile  = "MODEL_REGENERATE_METRICS_FILE"
+	EModelResumeOperation        = "MODEL_RESUME_OPERATION"
+	EModelSelfTest               = "MODEL_SELF_TEST"
+	EModelSetTags                = "MODEL_SET_TAGS"
+	EModelTestWebhook            = "MODEL_TEST_WEBHOOK"
+	EModelUnarchive              = "MODEL_UNARCHIVE"
+	EModelUpdateDependency       = "MODEL_UPDATE_DEPENDENCY"
+	EModelUpdateEvaluateResult   = "MODEL_UPDATE_EVALUATE_RESULT"
+	EModelValidateTemplate       = "MODEL_VALIDATE_TEMPLATE"
+	EModelValidateTemplates      = "MODEL_VALIDATE_TEMPLATES"
+	EModelVerify                 = "MODEL_VERIFY"
+	EModelNormalizePermissions   = "MODEL_NORMALIZE_PERMISSIONS"
+	EModelDownloadSnapshot       = "MODEL_DOWNLOAD_SNAPSHOT"
+	EModelWatchOperation         = "MODEL_WATCH_OPERATION"
+
+	EProblemAddClasses = "PROBLEM_ADD_CLASSES"
+	EProblemCreate     = "PROBLEM_CREATE"
+	EProblemDelete     = "PROBLEM_DELETE"
+	EProblemDetails    = "PROBLEM_DETAILS"
+	EProblemList       = "PROBLEM_LIST"
+	EProblemUpdate     = "PROBLEM_UPDATE"
+	EProblemUsage      = "PROBLEM_USAGE"
 
 	EUnsubscribe = "UNSUBSCRIBE"
 )
@@ -38,11 +85,20 @@ const (
 
 // Mongodb collections names
 const (
-	CAsset    = "asset"
-	CBuild    = "build"
-	CCvatTask = "cvatTask"
-	CProblem  = "problem"
-	CModel    = "model"
+	CAnnotation        = "annotation"
+	CAnnotationVersion = "annotationVersion"
+	CAsset             = "asset"
+	CAudit             = "audit"
+	CBuild             = "build"
+	CCvatTask          = "cvatTask"
+	CProblem           = "problem"
+	CProblemUsage      = "problemUsage"
+	CLock              = "lock"
+	CModel             = "model"
+	COperation         = "operation"
+	CSnapshot          = "snapshot"
+	CWebhook           = "webhook"
+	CWebhookDeadLetter = "webhookDeadLetter"
 )
 
 // AMQP requests events
@@ -52,10 +108,19 @@ const (
 	RBuildCreateEmpty = "BUILD_CREATE_EMPTY"
 	RBuildUpdateTmps  = "BUILD_UPDATE_TMPS"
 
+	RDBAnnotationDelete       = "DB_ANNOTATION_DELETE"
+	RDBAnnotationFind         = "DB_ANNOTATION_FIND"
+	RDBAnnotationRenameLabel  = "DB_ANNOTATION_RENAME_LABEL"
+	RDBAnnotationUpdateUpsert = "DB_ANNOTATION_UPDATE_UPSERT"
+	RDBAnnotationVersion      = "DB_ANNOTATION_VERSION"
+
 	RDBAssetFindOne      = "DB_ASSET_FIND_ONE"
 	RDBAssetFind         = "DB_ASSET_FIND"
 	RDBAssetUpdateUpsert = "DB_ASSET_UPDATE_UPSERT"
 
+	RDBAuditFind      = "DB_AUDIT_FIND"
+	RDBAuditInsertOne = "DB_AUDIT_INSERT_ONE"
+
 	RDBBuildFind      = "DB_BUILD_FIND"
 	RDBBuildFindOne   = "DB_BUILD_FIND_ONE"
 	RDBBuildInsertOne = "DB_BUILD_INSERT_ONE"
@@ -69,16 +134,48 @@ const (
 	RDBProblemDelete       = "DB_PROBLEM_DELETE"
 	RDBProblemFind         = "DB_PROBLEM_FIND"
 	RDBProblemFindOne      = "DB_PROBLEM_FIND_ONE"
+	RDBProblemUpdateOne    = "DB_PROBLEM_UPDATE_ONE"
 	RDBProblemUpdateUpsert = "DB_PROBLEM_UPDATE_UPSERT"
 
+	RDBProblemUsageAdd  = "DB_PROBLEM_USAGE_ADD"
+	RDBProblemUsageFind = "DB_PROBLEM_USAGE_FIND"
+	RDBProblemUsageSet  = "DB_PROBLEM_USAGE_SET"
+
+	RDBLockAcquire = "DB_LOCK_ACQUIRE"
+	RDBLockRefresh = "DB_LOCK_REFRESH"
+	RDBLockRelease = "DB_LOCK_RELEASE"
+
+	RDBOperationClaim     = "DB_OPERATION_CLAIM"
+	RDBOperationFindOne   = "DB_OPERATION_FIND_ONE"
+	RDBOperationHeartbeat = "DB_OPERATION_HEARTBEAT"
+	RDBOperationInsertOne = "DB_OPERATION_INSERT_ONE"
+	RDBOperationInterrupt = "DB_OPERATION_INTERRUPT"
+	RDBOperationStall     = "DB_OPERATION_STALL"
+	RDBOperationUpdateOne = "DB_OPERATION_UPDATE_ONE"
+
+	RDBModelBulkUpdate   = "DB_MODEL_BULK_UPDATE"
 	RDBModelDelete       = "DB_MODEL_DELETE"
 	RDBModelFind         = "DB_MODEL_FIND"
 	RDBModelFindOne      = "DB_MODEL_FIND_ONE"
 	RDBModelInsertOne    = "DB_MODEL_INSERT_ONE"
+	RDBModelMarkStale    = "DB_MODEL_MARK_STALE"
+	RDBModelUpdateFields = "DB_MODEL_UPDATE_FIELDS"
 	RDBModelUpdateOne    = "DB_MODEL_UPDATE_ONE"
+	RDBModelUpdateStatus = "DB_MODEL_UPDATE_STATUS"
 	RDBModelUpdateUpsert = "DB_MODEL_UPDATE_UPSERT"
 
+	RDBSnapshotDelete  = "DB_SNAPSHOT_DELETE"
+	RDBSnapshotFind    = "DB_SNAPSHOT_FIND"
+	RDBSnapshotSetRefs = "DB_SNAPSHOT_SET_REFS"
+
+	RDBWebhookDelete              = "DB_WEBHOOK_DELETE"
+	RDBWebhookFind                = "DB_WEBHOOK_FIND"
+	RDBWebhookInsertOne           = "DB_WEBHOOK_INSERT_ONE"
+	RDBWebhookDeadLetterFind      = "DB_WEBHOOK_DEAD_LETTER_FIND"
+	RDBWebhookDeadLetterInsertOne = "DB_WEBHOOK_DEAD_LETTER_INSERT_ONE"
+
 	RModelCreateFromGeneric = "MODEL_CREATE_FROM_GENERIC"
+	RModelHealthCheck       = "MODEL_HEALTH_CHECK"
 	RModelUpdateFromLocal   = "MODEL_UPDATE_FROM_LOCAL"
 
 	RProblemUpdateFromLocal = "PROBLEM_UPDATE_FROM_LOCAL"
@@ -89,19 +186,66 @@ const (
 
 func GetEvents() map[string]string {
 	return map[string]string{
-		EAssetDumpAnnotation:   QCvatTask,
-		EAssetFindInFolder:     QCvatTask,
-		EAssetSetupToCvat:      QCvatTask,
-		EBuildCreate:           QBuild,
-		EBuildList:             QBuild,
-		EBuildUpdateAssetState: QBuild,
-		EModelDelete:           QModel,
-		EModelEvaluate:         QModel,
-		EModelList:             QModel,
-		EModelFineTune:         QModel,
-		EProblemCreate:         QProblem,
-		EProblemDelete:         QProblem,
-		EProblemDetails:        QProblem,
-		EProblemList:           QProblem,
+		EAssetDatasetStats:           QAsset,
+		EAssetDumpAnnotation:         QCvatTask,
+		EAssetFindInFolder:           QCvatTask,
+		EAssetExportDataset:          QAsset,
+		EAssetImportDataset:          QAsset,
+		EAssetListFlaggedImages:      QAsset,
+		EAssetResolveFlaggedImages:   QAsset,
+		EAssetSetupToCvat:            QCvatTask,
+		EBuildCreate:                 QBuild,
+		EBuildGenerateSplit:          QBuild,
+		EBuildList:                   QBuild,
+		EBuildUpdateAssetState:       QBuild,
+		EModelCleanup:                QModel,
+		EModelClone:                  QModel,
+		EModelCollectSnapshots:       QModel,
+		EModelCompare:                QModel,
+		EModelCreateWebhook:          QModel,
+		EModelDeleteWebhook:          QModel,
+		EModelDismissStale:           QModel,
+		EModelArchive:                QModel,
+		EModelBulkUpdate:             QModel,
+		EModelCacheStats:             QModel,
+		EModelCancelOperation:        QModel,
+		EModelGetDetails:             QModel,
+		EModelGetTemplate:            QModel,
+		EModelImportDirectory:        QModel,
+		EModelDelete:                 QModel,
+		EModelEvaluate:               QModel,
+		EModelList:                   QModel,
+		EModelListWebhookDeadLetters: QModel,
+		EModelListWebhooks:           QModel,
+		EModelMaterialize:            QModel,
+		EModelPruneCache:             QModel,
+		EModelMigrateStoragePaths:    QModel,
+		EModelCheckFolderCollisions:  QModel,
+		EModelRegenerateMetricsFile:  QModel,
+		EModelResumeOperation:        QModel,
+		EModelReEvaluateStale:        QModel,
+		EModelFineTune:               QModel,
+		EModelGetOperation:           QModel,
+		EModelGetPreview:             QModel,
+		EModelGetReadme:              QModel,
+		EModelSelfTest:               QModel,
+		EModelTestWebhook:            QModel,
+		EModelSetTags:                QModel,
+		EModelUnarchive:              QModel,
+		EModelUpdateDependency:       QModel,
+		EModelUpdateEvaluateResult:   QModel,
+		EModelValidateTemplate:       QModel,
+		EModelValidateTemplates:      QModel,
+		EModelVerify:                 QModel,
+		EModelNormalizePermissions:   QModel,
+		EModelDownloadSnapshot:       QModel,
+		EModelWatchOperation:         QModel,
+		EProblemAddClasses:           QProblem,
+		EProblemCreate:               QProblem,
+		EProblemDelete:               QProblem,
+		EProblemDetails:              QProblem,
+		EProblemList:                 QProblem,
+		EProblemUpdate:               QProblem,
+		EProblemUsage:                QProblem,
 	}
 }
@@ -15,9 +15,16 @@ import (
 	n "server/common/names"
 	t "server/common/types"
 	"server/db/pkg/endpoint"
+	annotationDelete "server/db/pkg/handler/annotation/delete"
+	annotationFind "server/db/pkg/handler/annotation/find"
+	annotationRenameLabel "server/db/pkg/handler/annotation/rename_label"
+	annotationUpdateUpsert "server/db/pkg/handler/annotation/update_upsert"
+	annotationVersion "server/db/pkg/handler/annotation/version"
 	assetFind "server/db/pkg/handler/asset/find"
 	assetFindOne "server/db/pkg/handler/asset/find_one"
 	assetUpdateUpsert "server/db/pkg/handler/asset/update_upsert"
+	auditFind "server/db/pkg/handler/audit/find"
+	auditInsertOne "server/db/pkg/handler/audit/insert_one"
 	buildFind "server/db/pkg/handler/build/find"
 	buildFindOne "server/db/pkg/handler/build/find_one"
 	buildInsertOne "server/db/pkg/handler/build/insert_one"
@@ -26,18 +33,45 @@ import (
 	cvatTaskFindOne "server/db/pkg/handler/cvat_task/find_one"
 	cvatTaskInsertOne "server/db/pkg/handler/cvat_task/insert_one"
 	cvatTaskUpdateOne "server/db/pkg/handler/cvat_task/update_one"
+	lockAcquire "server/db/pkg/handler/lock/acquire"
+	lockRefresh "server/db/pkg/handler/lock/refresh"
+	lockRelease "server/db/pkg/handler/lock/release"
+	modelBulkUpdate "server/db/pkg/handler/model/bulk_update"
 	modelDelete "server/db/pkg/handler/model/delete"
 	modelFind "server/db/pkg/handler/model/find"
 	modelFindOne "server/db/pkg/handler/model/find_one"
 	modelInsertOne "server/db/pkg/handler/model/insert_one"
+	modelMarkStale "server/db/pkg/handler/model/mark_stale"
+	modelUpdateFields "server/db/pkg/handler/model/update_fields"
 	modelUpdateOne "server/db/pkg/handler/model/update_one"
+	modelUpdateStatus "server/db/pkg/handler/model/update_status"
 	modelUpdateUpsert "server/db/pkg/handler/model/update_upsert"
+	operationClaim "server/db/pkg/handler/operation/claim"
+	operationFindOne "server/db/pkg/handler/operation/find_one"
+	operationHeartbeat "server/db/pkg/handler/operation/heartbeat"
+	operationInsertOne "server/db/pkg/handler/operation/insert_one"
+	operationInterrupt "server/db/pkg/handler/operation/interrupt"
+	operationStall "server/db/pkg/handler/operation/stall"
+	operationUpdateOne "server/db/pkg/handler/operation/update_one"
 	problemDelete "server/db/pkg/handler/problem/delete"
 	problemFind "server/db/pkg/handler/problem/find"
 	problemFindOne "server/db/pkg/handler/problem/find_one"
+	problemUpdateOne "server/db/pkg/handler/problem/update_one"
 	problemUpdateUpsert "server/db/pkg/handler/problem/update_upsert"
+	problemUsageAdd "server/db/pkg/handler/problem_usage/add"
+	problemUsageFind "server/db/pkg/handler/problem_usage/find"
+	problemUsageSet "server/db/pkg/handler/problem_usage/set"
+	snapshotDelete "server/db/pkg/handler/snapshot/delete"
+	snapshotFind "server/db/pkg/handler/snapshot/find"
+	snapshotSetRefs "server/db/pkg/handler/snapshot/set_refs"
+	webhookDelete "server/db/pkg/handler/webhook/delete"
+	webhookFind "server/db/pkg/handler/webhook/find"
+	webhookInsertOne "server/db/pkg/handler/webhook/insert_one"
+	webhookDeadLetterFind "server/db/pkg/handler/webhook_dead_letter/find"
+	webhookDeadLetterInsertOne "server/db/pkg/handler/webhook_dead_letter/insert_one"
 	"server/db/pkg/service"
 	longendpoint "server/kit/endpoint"
+	"server/kit/health"
 	kitutils "server/kit/utils"
 )
 
@@ -48,11 +82,15 @@ func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass, mongoAddr *strin
 	if err != nil {
 		log.Panic(err)
 	}
+	health.Set(health.CheckMongo, func(ctx context.Context) error {
+		return client.Ping(ctx, nil)
+	})
 	amqpUrl := fmt.Sprintf("amqp://%s:%s@%s/", *amqpUser, *amqpPass, *amqpAddr)
 	conn, err := rabbitmq.Dial(amqpUrl)
 	if err != nil {
 		log.Panic(err)
 	}
+	health.Set(health.CheckAmqp, health.Amqp(conn))
 	defer conn.Close()
 	ch, err := conn.Channel()
 	if err != nil {
@@ -94,6 +132,17 @@ func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass, mongoAddr *strin
 			}
 			fmt.Println(req.Request)
 			switch req.Request {
+			case annotationDelete.Request:
+				go annotationDelete.Handle(eps, conn, msg)
+			case annotationFind.Request:
+				go annotationFind.Handle(eps, conn, msg)
+			case annotationRenameLabel.Request:
+				go annotationRenameLabel.Handle(eps, conn, msg)
+			case annotationUpdateUpsert.Request:
+				go annotationUpdateUpsert.Handle(eps, conn, msg)
+			case annotationVersion.Request:
+				go annotationVersion.Handle(eps, conn, msg)
+
 			case assetFind.Request:
 				go assetFind.Handle(eps, conn, msg)
 			case assetFindOne.Request:
@@ -101,6 +150,11 @@ func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass, mongoAddr *strin
 			case assetUpdateUpsert.Request:
 				go assetUpdateUpsert.Handle(eps, conn, msg)
 
+			case auditFind.Request:
+				go auditFind.Handle(eps, conn, msg)
+			case auditInsertOne.Request:
+				go auditInsertOne.Handle(eps, conn, msg)
+
 			case buildFind.Request:
 				go buildFind.Handle(eps, conn, msg)
 			case buildFindOne.Request:
@@ -119,12 +173,35 @@ func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass, mongoAddr *strin
 			case cvatTaskUpdateOne.Request:
 				go cvatTaskUpdateOne.Handle(eps, conn, msg)
 
+			case operationClaim.Request:
+				go operationClaim.Handle(eps, conn, msg)
+			case operationFindOne.Request:
+				go operationFindOne.Handle(eps, conn, msg)
+			case operationInsertOne.Request:
+				go operationInsertOne.Handle(eps, conn, msg)
+			case operationHeartbeat.Request:
+				go operationHeartbeat.Handle(eps, conn, msg)
+			case operationInterrupt.Request:
+				go operationInterrupt.Handle(eps, conn, msg)
+			case operationStall.Request:
+				go operationStall.Handle(eps, conn, msg)
+			case operationUpdateOne.Request:
+				go operationUpdateOne.Handle(eps, conn, msg)
+
 			case problemDelete.Request:
 				go problemDelete.Handle(eps, conn, msg)
 			case problemFind.Request:
 				go problemFind.Handle(eps, conn, msg)
 			case problemFindOne.Request:
 				go problemFindOne.Handle(eps, conn, msg)
+			case problemUsageAdd.Request:
+				go problemUsageAdd.Handle(eps, conn, msg)
+			case problemUsageFind.Request:
+				go problemUsageFind.Handle(eps, conn, msg)
+			case problemUsageSet.Request:
+				go problemUsageSet.Handle(eps, conn, msg)
+			case problemUpdateOne.Request:
+				go problemUpdateOne.Handle(eps, conn, msg)
 			case problemUpdateUpsert.Request:
 				go problemUpdateUpsert.Handle(eps, conn, msg)
 
@@ -138,8 +215,39 @@ func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass, mongoAddr *strin
 				go modelInsertOne.Handle(eps, conn, msg)
 			case modelUpdateOne.Request:
 				go modelUpdateOne.Handle(eps, conn, msg)
+			case modelMarkStale.Request:
+				go modelMarkStale.Handle(eps, conn, msg)
+			case modelBulkUpdate.Request:
+				go modelBulkUpdate.Handle(eps, conn, msg)
+			case modelUpdateStatus.Request:
+				go modelUpdateStatus.Handle(eps, conn, msg)
+			case modelUpdateFields.Request:
+				go modelUpdateFields.Handle(eps, conn, msg)
 			case modelUpdateUpsert.Request:
 				go modelUpdateUpsert.Handle(eps, conn, msg)
+
+			case lockAcquire.Request:
+				go lockAcquire.Handle(eps, conn, msg)
+			case lockRefresh.Request:
+				go lockRefresh.Handle(eps, conn, msg)
+			case lockRelease.Request:
+				go lockRelease.Handle(eps, conn, msg)
+			case snapshotDelete.Request:
+				go snapshotDelete.Handle(eps, conn, msg)
+			case snapshotFind.Request:
+				go snapshotFind.Handle(eps, conn, msg)
+			case snapshotSetRefs.Request:
+				go snapshotSetRefs.Handle(eps, conn, msg)
+			case webhookDelete.Request:
+				go webhookDelete.Handle(eps, conn, msg)
+			case webhookFind.Request:
+				go webhookFind.Handle(eps, conn, msg)
+			case webhookInsertOne.Request:
+				go webhookInsertOne.Handle(eps, conn, msg)
+			case webhookDeadLetterFind.Request:
+				go webhookDeadLetterFind.Handle(eps, conn, msg)
+			case webhookDeadLetterInsertOne.Request:
+				go webhookDeadLetterInsertOne.Handle(eps, conn, msg)
 			default:
 				log.Println("UNKNOWN REQUEST", req.Request)
 			}
@@ -178,6 +286,21 @@ func initMongoIndexes(db *mongo.Database) error {
 	if err := createModelIndex(db); err != nil {
 		return err
 	}
+	if err := createAnnotationIndex(db); err != nil {
+		return err
+	}
+	if err := createOperationIndex(db); err != nil {
+		return err
+	}
+	if err := createAuditIndex(db); err != nil {
+		return err
+	}
+	if err := createWebhookIndex(db); err != nil {
+		return err
+	}
+	if err := createLockIndex(db); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -217,15 +340,20 @@ func createAssetIndex(db *mongo.Database) error {
 	return nil
 }
 
+// createModelIndex keeps the names of the models unique by problem and
+// workspace. The index by problem and name of the servers before the
+// workspaces is dropped, under either order of its keys.
 func createModelIndex(db *mongo.Database) error {
 	indexes := mongo.IndexModel{
-		Keys: bson.M{
-			"name":      1,
-			"problemId": 1,
-		},
+		Keys:    bson.D{{Key: "problemId", Value: 1}, {Key: "workspace", Value: 1}, {Key: "name", Value: 1}},
 		Options: options.Index().SetUnique(true),
 	}
 	col := db.Collection(n.CModel)
+	for _, name := range []string{"name_1_problemId_1", "problemId_1_name_1"} {
+		if _, err := col.Indexes().DropOne(context.TODO(), name); err == nil {
+			log.Println("DropOne() index:", name)
+		}
+	}
 	ind, err := col.Indexes().CreateOne(context.TODO(), indexes)
 	log.Println("CreateOne() index:", ind)
 	log.Println("CreateOne() type:", reflect.TypeOf(ind), "\n")
@@ -234,3 +362,95 @@ func createModelIndex(db *mongo.Database) error {
 	}
 	return nil
 }
+
+func createAnnotationIndex(db *mongo.Database) error {
+	indexes := mongo.IndexModel{
+		Keys: bson.M{
+			"assetId":   1,
+			"imagePath": 1,
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	col := db.Collection(n.CAnnotation)
+	ind, err := col.Indexes().CreateOne(context.TODO(), indexes)
+	log.Println("CreateOne() index:", ind)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+const operationTTL = 7 * 24 * 60 * 60
+
+// createOperationIndex expires operations a week after their last update,
+// and serves the lookup of the stalled ones.
+func createOperationIndex(db *mongo.Database) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys: bson.M{
+				"updatedAt": 1,
+			},
+			Options: options.Index().SetExpireAfterSeconds(operationTTL),
+		},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "heartbeatAt", Value: 1}}},
+	}
+	col := db.Collection(n.COperation)
+	ind, err := col.Indexes().CreateMany(context.TODO(), indexes)
+	log.Println("CreateMany() index:", ind)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func createAuditIndex(db *mongo.Database) error {
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"createdAt": -1}},
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "problemId", Value: 1}, {Key: "createdAt", Value: -1}}},
+	}
+	col := db.Collection(n.CAudit)
+	ind, err := col.Indexes().CreateMany(context.TODO(), indexes)
+	log.Println("CreateMany() index:", ind)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func createWebhookIndex(db *mongo.Database) error {
+	ind, err := db.Collection(n.CWebhook).Indexes().CreateOne(context.TODO(), mongo.IndexModel{Keys: bson.M{"problemId": 1}})
+	log.Println("CreateOne() index:", ind)
+	if err != nil {
+		return err
+	}
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "webhookId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "problemId", Value: 1}, {Key: "createdAt", Value: -1}}},
+	}
+	col := db.Collection(n.CWebhookDeadLetter)
+	inds, err := col.Indexes().CreateMany(context.TODO(), indexes)
+	log.Println("CreateMany() index:", inds)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// createLockIndex removes the expired locks, those of the replicas that
+// died holding them, the lock owners taking them over meanwhile.
+func createLockIndex(db *mongo.Database) error {
+	indexes := mongo.IndexModel{
+		Keys: bson.M{
+			"expiresAt": 1,
+		},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	col := db.Collection(n.CLock)
+	ind, err := col.Indexes().CreateOne(context.TODO(), indexes)
+	log.Println("CreateOne() index:", ind)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -12,10 +12,19 @@ import (
 // meant to be used as a helper struct, to collect all of the endpoints into a
 // single parameter.
 type Endpoints struct {
+	AnnotationDelete       kitendpoint.Endpoint
+	AnnotationFind         kitendpoint.Endpoint
+	AnnotationRenameLabel  kitendpoint.Endpoint
+	AnnotationUpdateUpsert kitendpoint.Endpoint
+	AnnotationVersion      kitendpoint.Endpoint
+
 	AssetFind         kitendpoint.Endpoint
 	AssetFindOne      kitendpoint.Endpoint
 	AssetUpdateUpsert kitendpoint.Endpoint
 
+	AuditFind      kitendpoint.Endpoint
+	AuditInsertOne kitendpoint.Endpoint
+
 	BuildFind      kitendpoint.Endpoint
 	BuildFindOne   kitendpoint.Endpoint
 	BuildInsertOne kitendpoint.Endpoint
@@ -26,27 +35,66 @@ type Endpoints struct {
 	CvatTaskInsertOne kitendpoint.Endpoint
 	CvatTaskUpdateOne kitendpoint.Endpoint
 
+	OperationClaim     kitendpoint.Endpoint
+	OperationFindOne   kitendpoint.Endpoint
+	OperationInsertOne kitendpoint.Endpoint
+	OperationHeartbeat kitendpoint.Endpoint
+	OperationInterrupt kitendpoint.Endpoint
+	OperationStall     kitendpoint.Endpoint
+	OperationUpdateOne kitendpoint.Endpoint
+
 	ProblemDelete       kitendpoint.Endpoint
 	ProblemFind         kitendpoint.Endpoint
 	ProblemFindOne      kitendpoint.Endpoint
+	ProblemUsageAdd     kitendpoint.Endpoint
+	ProblemUsageFind    kitendpoint.Endpoint
+	ProblemUsageSet     kitendpoint.Endpoint
+	ProblemUpdateOne    kitendpoint.Endpoint
 	ProblemUpdateUpsert kitendpoint.Endpoint
 
+	LockAcquire kitendpoint.Endpoint
+	LockRefresh kitendpoint.Endpoint
+	LockRelease kitendpoint.Endpoint
+
 	ModelDelete       kitendpoint.Endpoint
 	ModelFind         kitendpoint.Endpoint
 	ModelFindOne      kitendpoint.Endpoint
 	ModelInsertOne    kitendpoint.Endpoint
+	ModelMarkStale    kitendpoint.Endpoint
+	ModelBulkUpdate   kitendpoint.Endpoint
 	ModelUpdateOne    kitendpoint.Endpoint
+	ModelUpdateStatus kitendpoint.Endpoint
+	ModelUpdateFields kitendpoint.Endpoint
 	ModelUpdateUpsert kitendpoint.Endpoint
+
+	SnapshotDelete  kitendpoint.Endpoint
+	SnapshotFind    kitendpoint.Endpoint
+	SnapshotSetRefs kitendpoint.Endpoint
+
+	WebhookDelete              kitendpoint.Endpoint
+	WebhookFind                kitendpoint.Endpoint
+	WebhookInsertOne           kitendpoint.Endpoint
+	WebhookDeadLetterFind      kitendpoint.Endpoint
+	WebhookDeadLetterInsertOne kitendpoint.Endpoint
 }
 
 // New returns a Endpoints struct that wraps the provided service, and wires in all of the
 // expected endpoint middlewares
 func New(s service.DatabaseService /*, mdw map[string][]endpoint.Middleware*/) Endpoints {
 	eps := Endpoints{
+		AnnotationDelete:       MakeAnnotationDeleteEndpoint(s),
+		AnnotationFind:         MakeAnnotationFindEndpoint(s),
+		AnnotationRenameLabel:  MakeAnnotationRenameLabelEndpoint(s),
+		AnnotationUpdateUpsert: MakeAnnotationUpdateUpsertEndpoint(s),
+		AnnotationVersion:      MakeAnnotationVersionEndpoint(s),
+
 		AssetFind:         MakeAssetFindEndpoint(s),
 		AssetFindOne:      MakeAssetFindOneEndpoint(s),
 		AssetUpdateUpsert: MakeAssetUpdateUpsertEndpoint(s),
 
+		AuditFind:      MakeAuditFindEndpoint(s),
+		AuditInsertOne: MakeAuditInsertOneEndpoint(s),
+
 		BuildFind:      MakeBuildFindEndpoint(s),
 		BuildFindOne:   MakeBuildFindOneEndpoint(s),
 		BuildInsertOne: MakeBuildInsertOneEndpoint(s),
@@ -57,21 +105,139 @@ func New(s service.DatabaseService /*, mdw map[string][]endpoint.Middleware*/) E
 		CvatTaskInsertOne: MakeCvatTaskInsertOneEndpoint(s),
 		CvatTaskUpdateOne: MakeCvatTaskUpdateOneEndpoint(s),
 
+		OperationClaim:     MakeOperationClaimEndpoint(s),
+		OperationFindOne:   MakeOperationFindOneEndpoint(s),
+		OperationInsertOne: MakeOperationInsertOneEndpoint(s),
+		OperationHeartbeat: MakeOperationHeartbeatEndpoint(s),
+		OperationInterrupt: MakeOperationInterruptEndpoint(s),
+		OperationStall:     MakeOperationStallEndpoint(s),
+		OperationUpdateOne: MakeOperationUpdateOneEndpoint(s),
+
 		ProblemDelete:       MakeProblemDeleteEndpoint(s),
 		ProblemFind:         MakeProblemFindEndpoint(s),
 		ProblemFindOne:      MakeProblemFindOneEndpoint(s),
+		ProblemUsageAdd:     MakeProblemUsageAddEndpoint(s),
+		ProblemUsageFind:    MakeProblemUsageFindEndpoint(s),
+		ProblemUsageSet:     MakeProblemUsageSetEndpoint(s),
+		ProblemUpdateOne:    MakeProblemUpdateOneEndpoint(s),
 		ProblemUpdateUpsert: MakeProblemUpdateUpsertEndpoint(s),
 
+		LockAcquire: MakeLockAcquireEndpoint(s),
+		LockRefresh: MakeLockRefreshEndpoint(s),
+		LockRelease: MakeLockReleaseEndpoint(s),
+
 		ModelDelete:       MakeModelDeleteEndpoint(s),
 		ModelFind:         MakeModelFindEndpoint(s),
 		ModelFindOne:      MakeModelFindOneEndpoint(s),
 		ModelInsertOne:    MakeModelInsertOneEndpoint(s),
+		ModelMarkStale:    MakeModelMarkStaleEndpoint(s),
+		ModelBulkUpdate:   MakeModelBulkUpdateEndpoint(s),
 		ModelUpdateOne:    MakeModelUpdateOneEndpoint(s),
+		ModelUpdateStatus: MakeModelUpdateStatusEndpoint(s),
+		ModelUpdateFields: MakeModelUpdateFieldsEndpoint(s),
 		ModelUpdateUpsert: MakeModelUpdateUpsertEndpoint(s),
+
+		SnapshotDelete:  MakeSnapshotDeleteEndpoint(s),
+		SnapshotFind:    MakeSnapshotFindEndpoint(s),
+		SnapshotSetRefs: MakeSnapshotSetRefsEndpoint(s),
+
+		WebhookDelete:              MakeWebhookDeleteEndpoint(s),
+		WebhookFind:                MakeWebhookFindEndpoint(s),
+		WebhookInsertOne:           MakeWebhookInsertOneEndpoint(s),
+		WebhookDeadLetterFind:      MakeWebhookDeadLetterFindEndpoint(s),
+		WebhookDeadLetterInsertOne: MakeWebhookDeadLetterInsertOneEndpoint(s),
 	}
 	return eps
 }
 
+func MakeAnnotationDeleteEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp := s.AnnotationDelete(ctx, req.(service.AnnotationDeleteRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeAnnotationFindEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp := s.AnnotationFind(ctx, req.(service.AnnotationFindRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeAnnotationVersionEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp := s.AnnotationVersion(ctx, req.(service.AnnotationVersionRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeAnnotationRenameLabelEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.AnnotationRenameLabel(ctx, req.(service.AnnotationRenameLabelRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeAnnotationUpdateUpsertEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp := s.AnnotationUpdateUpsert(ctx, req.(service.AnnotationUpdateUpsertRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
 func MakeAssetFindEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
 	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
 		returnChan := make(chan kitendpoint.Response)
@@ -120,6 +286,54 @@ func MakeAssetUpdateUpsertEndpoint(s service.DatabaseService) kitendpoint.Endpoi
 	}
 }
 
+func MakeAuditFindEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.AuditFind(ctx, req.(service.AuditFindRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+			} else {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 0},
+					IsLast: true,
+				}
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeAuditInsertOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.AuditInsertOne(ctx, req.(service.AuditInsertOneRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+			} else {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 0},
+					IsLast: true,
+				}
+			}
+		}()
+		return returnChan
+	}
+}
+
 func MakeBuildFindEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
 	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
 		returnChan := make(chan kitendpoint.Response)
@@ -309,28 +523,36 @@ func MakeProblemFindOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint
 	}
 }
 
-func MakeProblemUpdateUpsertEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+func MakeOperationClaimEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
 	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
 		returnChan := make(chan kitendpoint.Response)
 		go func() {
 			defer close(returnChan)
-			resp := s.ProblemUpdateUpsert(ctx, req.(service.ProblemUpdateUpsertRequestData))
-			returnChan <- kitendpoint.Response{
-				Data:   resp,
-				Err:    kitendpoint.Error{Code: 0},
-				IsLast: true,
+			resp, err := s.OperationClaim(ctx, req.(service.OperationClaimRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+			} else {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 0},
+					IsLast: true,
+				}
 			}
 		}()
 		return returnChan
 	}
 }
 
-func MakeModelDeleteEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+func MakeOperationFindOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
 	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
 		returnChan := make(chan kitendpoint.Response)
 		go func() {
 			defer close(returnChan)
-			resp := s.ModelDelete(ctx, req.(service.ModelDeleteRequestData))
+			resp := s.OperationFindOne(ctx, req.(service.OperationFindOneRequestData))
 			returnChan <- kitendpoint.Response{
 				Data:   resp,
 				Err:    kitendpoint.Error{Code: 0},
@@ -341,44 +563,84 @@ func MakeModelDeleteEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
 	}
 }
 
-func MakeModelFindEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+func MakeOperationInsertOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
 	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
 		returnChan := make(chan kitendpoint.Response)
 		go func() {
 			defer close(returnChan)
-			resp := s.ModelFind(ctx, req.(service.ModelFindRequestData))
-			returnChan <- kitendpoint.Response{
-				Data:   resp,
-				Err:    kitendpoint.Error{Code: 0},
-				IsLast: true,
+			resp, err := s.OperationInsertOne(ctx, req.(service.OperationInsertOneRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+			} else {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 0},
+					IsLast: true,
+				}
 			}
 		}()
 		return returnChan
 	}
 }
 
-func MakeModelFindOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+func MakeOperationInterruptEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
 	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
 		returnChan := make(chan kitendpoint.Response)
 		go func() {
 			defer close(returnChan)
-			resp := s.ModelFindOne(ctx, req.(service.ModelFindOneRequestData))
-			returnChan <- kitendpoint.Response{
-				Data:   resp,
-				Err:    kitendpoint.Error{Code: 0},
-				IsLast: true,
+			resp, err := s.OperationInterrupt(ctx, req.(service.OperationInterruptRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+			} else {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 0},
+					IsLast: true,
+				}
 			}
 		}()
 		return returnChan
 	}
 }
 
-func MakeModelInsertOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+func MakeOperationHeartbeatEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
 	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
 		returnChan := make(chan kitendpoint.Response)
 		go func() {
 			defer close(returnChan)
-			resp, err := s.ModelInsertOne(ctx, req.(service.ModelInsertOneRequestData))
+			resp, err := s.OperationHeartbeat(ctx, req.(service.OperationHeartbeatRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+			} else {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 0},
+					IsLast: true,
+				}
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeOperationStallEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.OperationStall(ctx, req.(service.OperationStallRequestData))
 			if err != nil {
 				returnChan <- kitendpoint.Response{
 					Data:   resp,
@@ -392,18 +654,49 @@ func MakeModelInsertOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint
 					IsLast: true,
 				}
 			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeOperationUpdateOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp := s.OperationUpdateOne(ctx, req.(service.OperationUpdateOneRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
 
+func MakeProblemUsageAddEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp := s.ProblemUsageAdd(ctx, req.(service.ProblemUsageAddRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
 		}()
 		return returnChan
 	}
 }
 
-func MakeModelUpdateOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+func MakeProblemUsageFindEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
 	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
 		returnChan := make(chan kitendpoint.Response)
 		go func() {
 			defer close(returnChan)
-			resp := s.ModelUpdateOne(ctx, req.(service.ModelUpdateOneRequestData))
+			resp := s.ProblemUsageFind(ctx, req.(service.ProblemUsageFindRequestData))
 			returnChan <- kitendpoint.Response{
 				Data:   resp,
 				Err:    kitendpoint.Error{Code: 0},
@@ -414,12 +707,517 @@ func MakeModelUpdateOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint
 	}
 }
 
-func MakeModelUpdateUpsertEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+func MakeProblemUsageSetEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
 	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
 		returnChan := make(chan kitendpoint.Response)
 		go func() {
 			defer close(returnChan)
-			resp := s.ModelUpdateUpsert(ctx, req.(service.ModelUpdateUpsertRequestData))
+			resp := s.ProblemUsageSet(ctx, req.(service.ProblemUsageSetRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeProblemUpdateOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.ProblemUpdateOne(ctx, req.(service.ProblemUpdateOneRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeProblemUpdateUpsertEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp := s.ProblemUpdateUpsert(ctx, req.(service.ProblemUpdateUpsertRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeLockAcquireEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.LockAcquire(ctx, req.(service.LockAcquireRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeLockRefreshEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.LockRefresh(ctx, req.(service.LockRefreshRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeLockReleaseEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.LockRelease(ctx, req.(service.LockReleaseRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeModelDeleteEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp := s.ModelDelete(ctx, req.(service.ModelDeleteRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeModelFindEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp := s.ModelFind(ctx, req.(service.ModelFindRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeModelFindOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp := s.ModelFindOne(ctx, req.(service.ModelFindOneRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeModelInsertOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.ModelInsertOne(ctx, req.(service.ModelInsertOneRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+			} else {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 0},
+					IsLast: true,
+				}
+			}
+
+		}()
+		return returnChan
+	}
+}
+
+func MakeModelMarkStaleEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.ModelMarkStale(ctx, req.(service.ModelMarkStaleRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+			} else {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 0},
+					IsLast: true,
+				}
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeModelBulkUpdateEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.ModelBulkUpdate(ctx, req.(service.ModelBulkUpdateRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+			} else {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 0},
+					IsLast: true,
+				}
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeModelUpdateOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp := s.ModelUpdateOne(ctx, req.(service.ModelUpdateOneRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeModelUpdateStatusEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.ModelUpdateStatus(ctx, req.(service.ModelUpdateStatusRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+			} else {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 0},
+					IsLast: true,
+				}
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeModelUpdateFieldsEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.ModelUpdateFields(ctx, req.(service.ModelUpdateFieldsRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+			} else {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 0},
+					IsLast: true,
+				}
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeModelUpdateUpsertEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp := s.ModelUpdateUpsert(ctx, req.(service.ModelUpdateUpsertRequestData))
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeSnapshotDeleteEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.SnapshotDelete(ctx, req.(service.SnapshotDeleteRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeSnapshotFindEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.SnapshotFind(ctx, req.(service.SnapshotFindRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeSnapshotSetRefsEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.SnapshotSetRefs(ctx, req.(service.SnapshotSetRefsRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeWebhookDeleteEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.WebhookDelete(ctx, req.(service.WebhookDeleteRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeWebhookFindEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.WebhookFind(ctx, req.(service.WebhookFindRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeWebhookInsertOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.WebhookInsertOne(ctx, req.(service.WebhookInsertOneRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeWebhookDeadLetterFindEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.WebhookDeadLetterFind(ctx, req.(service.WebhookDeadLetterFindRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
+			returnChan <- kitendpoint.Response{
+				Data:   resp,
+				Err:    kitendpoint.Error{Code: 0},
+				IsLast: true,
+			}
+		}()
+		return returnChan
+	}
+}
+
+func MakeWebhookDeadLetterInsertOneEndpoint(s service.DatabaseService) kitendpoint.Endpoint {
+	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
+		returnChan := make(chan kitendpoint.Response)
+		go func() {
+			defer close(returnChan)
+			resp, err := s.WebhookDeadLetterInsertOne(ctx, req.(service.WebhookDeadLetterInsertOneRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: 1, Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
 			returnChan <- kitendpoint.Response{
 				Data:   resp,
 				Err:    kitendpoint.Error{Code: 0},
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	n "server/common/names"
+	t "server/db/pkg/types"
+)
+
+type AnnotationDeleteRequestData struct {
+	Ids []primitive.ObjectID `bson:"ids" json:"ids"`
+}
+
+type AnnotationDeleteResponseData struct {
+	Deleted int64 `bson:"deleted" json:"deleted"`
+}
+
+func (s *basicDatabaseService) AnnotationDelete(ctx context.Context, req AnnotationDeleteRequestData) (result AnnotationDeleteResponseData) {
+	if len(req.Ids) == 0 {
+		return result
+	}
+	annotationCollection := s.db.Collection(n.CAnnotation)
+	filter := bson.M{"_id": bson.M{"$in": req.Ids}}
+	problemIds, err := annotationCollection.Distinct(ctx, "problemId", filter)
+	if err != nil {
+		log.Println("AnnotationDelete.Distinct", err)
+	}
+	r, err := annotationCollection.DeleteMany(ctx, filter)
+	if err != nil {
+		log.Println("AnnotationDelete.DeleteMany", err)
+		return result
+	}
+	for _, problemId := range problemIds {
+		if id, ok := problemId.(primitive.ObjectID); ok {
+			s.bumpAnnotationVersion(ctx, id)
+		}
+	}
+	return AnnotationDeleteResponseData{Deleted: r.DeletedCount}
+}
+
+type AnnotationVersionRequestData struct {
+	ProblemId primitive.ObjectID `bson:"problemId" json:"problemId"`
+}
+
+// AnnotationVersion is the version of the annotations of the problem, zero
+// until they first change.
+func (s *basicDatabaseService) AnnotationVersion(ctx context.Context, req AnnotationVersionRequestData) (result t.AnnotationVersion) {
+	result.ProblemId = req.ProblemId
+	err := s.db.Collection(n.CAnnotationVersion).FindOne(ctx, bson.M{"problemId": req.ProblemId}).Decode(&result)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println("AnnotationVersion.FindOne", err)
+	}
+	return result
+}
+
+// bumpAnnotationVersion counts a change of the annotations of the problem.
+func (s *basicDatabaseService) bumpAnnotationVersion(ctx context.Context, problemId primitive.ObjectID) {
+	option := options.Update()
+	option.SetUpsert(true)
+	_, err := s.db.Collection(n.CAnnotationVersion).UpdateOne(ctx, bson.M{"problemId": problemId}, bson.M{"$inc": bson.M{"version": 1}}, option)
+	if err != nil {
+		log.Println("bumpAnnotationVersion.UpdateOne", err)
+	}
+}
+
+type AnnotationFindRequestData struct {
+	Ids       []primitive.ObjectID `bson:"ids" json:"ids"`
+	AssetId   primitive.ObjectID   `bson:"assetId" json:"assetId"`
+	ProblemId primitive.ObjectID   `bson:"problemId" json:"problemId"`
+	Statuses  []string             `bson:"statuses" json:"statuses"`
+	Label     string               `bson:"label" json:"label"`
+}
+
+func (s *basicDatabaseService) AnnotationFind(ctx context.Context, req AnnotationFindRequestData) (result t.AnnotationFindResponse) {
+	annotationCollection := s.db.Collection(n.CAnnotation)
+	filter := bson.M{}
+	if len(req.Ids) > 0 {
+		filter["_id"] = bson.M{"$in": req.Ids}
+	}
+	if len(req.Statuses) > 0 {
+		filter["status"] = bson.M{"$in": req.Statuses}
+	}
+	if req.Label != "" {
+		filter["objects.label"] = req.Label
+	}
+	if !req.AssetId.IsZero() {
+		filter["assetId"] = req.AssetId
+	}
+	if !req.ProblemId.IsZero() {
+		filter["problemId"] = req.ProblemId
+	}
+	cur, err := annotationCollection.Find(ctx, filter)
+	if err != nil {
+		log.Println("AnnotationFind.Find", err)
+		return result
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var elem t.Annotation
+		if err := cur.Decode(&elem); err != nil {
+			log.Println("AnnotationFind.Decode", err)
+			return t.AnnotationFindResponse{}
+		}
+		result.Items = append(result.Items, elem)
+	}
+	result.Total = int64(len(result.Items))
+	return result
+}
+
+type AnnotationRenameLabelRequestData struct {
+	ProblemId primitive.ObjectID `bson:"problemId" json:"problemId"`
+	From      string             `bson:"from" json:"from"`
+	To        string             `bson:"to" json:"to"`
+}
+
+type AnnotationRenameLabelResponseData struct {
+	Modified int64 `bson:"modified" json:"modified"`
+}
+
+// AnnotationRenameLabel replaces the label of all objects of the problem
+// annotations, used both for class renames and remaps.
+func (s *basicDatabaseService) AnnotationRenameLabel(ctx context.Context, req AnnotationRenameLabelRequestData) (result AnnotationRenameLabelResponseData, err error) {
+	annotationCollection := s.db.Collection(n.CAnnotation)
+	option := options.Update()
+	option.SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"o.label": req.From}}})
+	r, err := annotationCollection.UpdateMany(
+		ctx,
+		bson.M{"problemId": req.ProblemId, "objects.label": req.From},
+		bson.M{"$set": bson.M{"objects.$[o].label": req.To}},
+		option,
+	)
+	if err != nil {
+		log.Println("AnnotationRenameLabel.UpdateMany", err)
+		return result, err
+	}
+	if r.ModifiedCount > 0 {
+		s.bumpAnnotationVersion(ctx, req.ProblemId)
+	}
+	return AnnotationRenameLabelResponseData{Modified: r.ModifiedCount}, nil
+}
+
+type AnnotationUpdateUpsertRequestData struct {
+	AssetId     primitive.ObjectID   `bson:"assetId" json:"assetId"`
+	ProblemId   primitive.ObjectID   `bson:"problemId" json:"problemId"`
+	ImagePath   string               `bson:"imagePath" json:"imagePath"`
+	Width       int                  `bson:"width" json:"width"`
+	Height      int                  `bson:"height" json:"height"`
+	Objects     []t.AnnotationObject `bson:"objects" json:"objects"`
+	Sha256      string               `bson:"sha256" json:"sha256"`
+	Status      string               `bson:"status" json:"status"`
+	DuplicateOf string               `bson:"duplicateOf" json:"duplicateOf"`
+}
+
+func (s *basicDatabaseService) AnnotationUpdateUpsert(ctx context.Context, req AnnotationUpdateUpsertRequestData) (result t.Annotation) {
+	annotationCollection := s.db.Collection(n.CAnnotation)
+	option := options.Update()
+	option.SetUpsert(true)
+	filter := bson.M{"assetId": req.AssetId, "imagePath": req.ImagePath}
+	_, err := annotationCollection.UpdateOne(ctx, filter, bson.M{"$set": req}, option)
+	if err != nil {
+		log.Println("AnnotationUpdateUpsert.UpdateOne", err)
+	} else {
+		s.bumpAnnotationVersion(ctx, req.ProblemId)
+	}
+	err = annotationCollection.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		log.Println("AnnotationUpdateUpsert.FindOne", err)
+	}
+	return result
+}
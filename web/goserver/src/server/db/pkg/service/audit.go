@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	n "server/common/names"
+	t "server/db/pkg/types"
+)
+
+// AuditFindRequestData filters the audit log, zero fields match everything.
+// From and To bound CreatedAt, To is exclusive.
+type AuditFindRequestData struct {
+	Action    string             `bson:"action" json:"action"`
+	User      string             `bson:"user" json:"user"`
+	ProblemId primitive.ObjectID `bson:"problemId" json:"problemId"`
+	From      time.Time          `bson:"from" json:"from"`
+	To        time.Time          `bson:"to" json:"to"`
+	Page      int64              `bson:"page" json:"page"`
+	Size      int64              `bson:"size" json:"size"`
+}
+
+// AuditFind returns the newest entries first.
+func (s *basicDatabaseService) AuditFind(ctx context.Context, req AuditFindRequestData) (result t.AuditFindResponse, err error) {
+	c := s.db.Collection(n.CAudit)
+	filter := bson.M{}
+	if req.Action != "" {
+		filter["action"] = req.Action
+	}
+	if req.User != "" {
+		filter["user"] = req.User
+	}
+	if !req.ProblemId.IsZero() {
+		filter["problemId"] = req.ProblemId
+	}
+	createdAt := bson.M{}
+	if !req.From.IsZero() {
+		createdAt["$gte"] = req.From
+	}
+	if !req.To.IsZero() {
+		createdAt["$lt"] = req.To
+	}
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+	result.Items = []t.AuditEntry{}
+	if result.Total, err = c.CountDocuments(ctx, filter); err != nil {
+		return result, err
+	}
+	option := options.Find().SetSort(bson.M{"createdAt": -1})
+	if req.Page > 0 && req.Size > 0 {
+		option.SetSkip(req.Size * (req.Page - 1))
+		option.SetLimit(req.Size)
+	}
+	cur, err := c.Find(ctx, filter, option)
+	if err != nil {
+		return result, err
+	}
+	defer cur.Close(ctx)
+	err = cur.All(ctx, &result.Items)
+	return result, err
+}
+
+type AuditInsertOneRequestData = t.AuditEntry
+
+// AuditInsertOne appends an entry. The audit log has no update or delete.
+func (s *basicDatabaseService) AuditInsertOne(ctx context.Context, req AuditInsertOneRequestData) (result t.AuditEntry, err error) {
+	c := s.db.Collection(n.CAudit)
+	req.Id = primitive.NewObjectID()
+	if req.CreatedAt.IsZero() {
+		req.CreatedAt = time.Now()
+	}
+	if _, err = c.InsertOne(ctx, req); err != nil {
+		log.Println("AuditInsertOne.InsertOne", err)
+		return result, err
+	}
+	return req, nil
+}
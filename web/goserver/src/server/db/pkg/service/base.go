@@ -9,10 +9,19 @@ import (
 )
 
 type DatabaseService interface {
+	AnnotationDelete(ctx context.Context, req AnnotationDeleteRequestData) AnnotationDeleteResponseData
+	AnnotationFind(ctx context.Context, req AnnotationFindRequestData) t.AnnotationFindResponse
+	AnnotationRenameLabel(ctx context.Context, req AnnotationRenameLabelRequestData) (AnnotationRenameLabelResponseData, error)
+	AnnotationUpdateUpsert(ctx context.Context, req AnnotationUpdateUpsertRequestData) t.Annotation
+	AnnotationVersion(ctx context.Context, req AnnotationVersionRequestData) t.AnnotationVersion
+
 	AssetFind(ctx context.Context, req AssetFindRequestData) t.AssetFindResponse
 	AssetFindOne(ctx context.Context, req AssetFindOneRequestData) t.Asset
 	AssetUpdateUpsert(ctx context.Context, req AssetUpdateUpsertRequestData) t.Asset
 
+	AuditFind(ctx context.Context, req AuditFindRequestData) (t.AuditFindResponse, error)
+	AuditInsertOne(ctx context.Context, req AuditInsertOneRequestData) (t.AuditEntry, error)
+
 	BuildFind(ctx context.Context, req BuildFindRequestData) t.BuildFindResponse
 	BuildFindOne(ctx context.Context, req BuildFindOneRequestData) t.Build
 	BuildInsertOne(ctx context.Context, req BuildInsertOneRequestData) t.Build
@@ -26,14 +35,44 @@ type DatabaseService interface {
 	ProblemDelete(ctx context.Context, req ProblemDeleteRequestData) ProblemDeleteResponseData
 	ProblemFind(ctx context.Context, req ProblemFindRequestData) t.ProblemFindResponse
 	ProblemFindOne(ctx context.Context, req ProblemFindOneRequestData) (t.Problem, error)
+	OperationClaim(ctx context.Context, req OperationClaimRequestData) (t.Operation, error)
+	OperationFindOne(ctx context.Context, req OperationFindOneRequestData) t.Operation
+	OperationInsertOne(ctx context.Context, req OperationInsertOneRequestData) (t.Operation, error)
+	OperationInterrupt(ctx context.Context, req OperationInterruptRequestData) (OperationInterruptResponseData, error)
+	OperationHeartbeat(ctx context.Context, req OperationHeartbeatRequestData) (OperationHeartbeatResponseData, error)
+	OperationStall(ctx context.Context, req OperationStallRequestData) (OperationStallResponseData, error)
+	OperationUpdateOne(ctx context.Context, req OperationUpdateOneRequestData) t.Operation
+
+	ProblemUsageAdd(ctx context.Context, req ProblemUsageAddRequestData) t.ProblemUsage
+	ProblemUsageFind(ctx context.Context, req ProblemUsageFindRequestData) ProblemUsageFindResponseData
+	ProblemUsageSet(ctx context.Context, req ProblemUsageSetRequestData) t.ProblemUsage
+	ProblemUpdateOne(ctx context.Context, req ProblemUpdateOneRequestData) (t.Problem, error)
 	ProblemUpdateUpsert(ctx context.Context, req ProblemUpdateUpsertRequestData) t.Problem
 
+	LockAcquire(ctx context.Context, req LockAcquireRequestData) (LockAcquireResponseData, error)
+	LockRefresh(ctx context.Context, req LockRefreshRequestData) (LockRefreshResponseData, error)
+	LockRelease(ctx context.Context, req LockReleaseRequestData) (LockReleaseResponseData, error)
+
 	ModelDelete(ctx context.Context, req ModelDeleteRequestData) ModelDeleteResponseData
 	ModelFind(ctx context.Context, req ModelFindRequestData) t.ModelFindResponse
 	ModelFindOne(ctx context.Context, req ModelFindOneRequestData) t.Model
 	ModelInsertOne(ctx context.Context, req ModelInsertOneRequestData) (t.Model, error)
+	ModelMarkStale(ctx context.Context, req ModelMarkStaleRequestData) (ModelMarkStaleResponseData, error)
+	ModelBulkUpdate(ctx context.Context, req ModelBulkUpdateRequestData) (ModelBulkUpdateResponseData, error)
 	ModelUpdateOne(ctx context.Context, req ModelUpdateOneRequestData) t.Model
+	ModelUpdateStatus(ctx context.Context, req ModelUpdateStatusRequestData) (t.Model, error)
+	ModelUpdateFields(ctx context.Context, req ModelUpdateFieldsRequestData) (t.Model, error)
 	ModelUpdateUpsert(ctx context.Context, req ModelUpdateUpsertRequestData) t.Model
+
+	SnapshotDelete(ctx context.Context, req SnapshotDeleteRequestData) (SnapshotDeleteResponseData, error)
+	SnapshotFind(ctx context.Context, req SnapshotFindRequestData) (SnapshotFindResponseData, error)
+	SnapshotSetRefs(ctx context.Context, req SnapshotSetRefsRequestData) (SnapshotSetRefsResponseData, error)
+
+	WebhookDelete(ctx context.Context, req WebhookDeleteRequestData) (WebhookDeleteResponseData, error)
+	WebhookFind(ctx context.Context, req WebhookFindRequestData) (WebhookFindResponseData, error)
+	WebhookInsertOne(ctx context.Context, req WebhookInsertOneRequestData) (t.Webhook, error)
+	WebhookDeadLetterFind(ctx context.Context, req WebhookDeadLetterFindRequestData) (t.WebhookDeadLetterFindResponse, error)
+	WebhookDeadLetterInsertOne(ctx context.Context, req WebhookDeadLetterInsertOneRequestData) (t.WebhookDeadLetter, error)
 }
 
 type basicDatabaseService struct {
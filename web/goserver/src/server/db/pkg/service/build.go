@@ -96,6 +96,8 @@ type BuildInsertOneRequestData struct {
 	Name      string                        `bson:"name" json:"name"`
 	Split     map[string]t.BuildAssetsSplit `bson:"split" json:"split"`
 	Status    string                        `bson:"status" json:"status"`
+	// AssetsHash is left out when empty, the build being hashed when saved.
+	AssetsHash string `bson:"assetsHash,omitempty" json:"assetsHash,omitempty"`
 }
 
 func (s *basicDatabaseService) BuildInsertOne(ctx context.Context, req BuildInsertOneRequestData) (result t.Build) {
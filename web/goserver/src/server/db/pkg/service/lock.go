@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	n "server/common/names"
+	t "server/db/pkg/types"
+)
+
+// LockAcquireRequestData takes the lock of Key for Owner during TtlMillis,
+// Operation being the operation Owner works for, if any.
+type LockAcquireRequestData struct {
+	Key       string `json:"key"`
+	Owner     string `json:"owner"`
+	Holder    string `json:"holder"`
+	TtlMillis int64  `json:"ttlMillis"`
+	Operation string `json:"operation"`
+}
+
+// LockAcquireResponseData tells whether the lock was Acquired, Lock being the
+// lock as it is, that of the other owner when it was not.
+type LockAcquireResponseData struct {
+	Acquired bool   `json:"acquired"`
+	Lock     t.Lock `json:"lock"`
+}
+
+// LockAcquire takes the lock of Key when it is free, expired or already
+// held by Owner. The lock documents are unique by key, so that only one of
+// the owners racing for a lock gets it.
+func (s *basicDatabaseService) LockAcquire(ctx context.Context, req LockAcquireRequestData) (result LockAcquireResponseData, err error) {
+	c := s.db.Collection(n.CLock)
+	now := time.Now()
+	filter := bson.M{
+		"_id": req.Key,
+		"$or": bson.A{
+			bson.M{"owner": req.Owner},
+			bson.M{"expiresAt": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"owner":      req.Owner,
+			"holder":     req.Holder,
+			"acquiredAt": now,
+			"expiresAt":  now.Add(time.Duration(req.TtlMillis) * time.Millisecond),
+			"operation":  req.Operation,
+		},
+	}
+	err = c.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&result.Lock)
+	if err == nil {
+		result.Acquired = true
+		return result, nil
+	}
+	if !IsDup(err) && !isDupCommand(err) {
+		log.Println("LockAcquire.FindOneAndUpdate", err)
+		return result, err
+	}
+	err = c.FindOne(ctx, bson.M{"_id": req.Key}).Decode(&result.Lock)
+	if err == mongo.ErrNoDocuments {
+		// Released meanwhile, the caller may try again.
+		return result, nil
+	}
+	if err != nil {
+		log.Println("LockAcquire.FindOne", err)
+	}
+	return result, err
+}
+
+// isDupCommand tells whether err is the duplicate key error of a command, as
+// findAndModify reports them.
+func isDupCommand(err error) bool {
+	e, ok := err.(mongo.CommandError)
+	return ok && e.Code == 11000
+}
+
+// LockRefreshRequestData extends the lock of Key held by Owner by TtlMillis
+// from now.
+type LockRefreshRequestData struct {
+	Key       string `json:"key"`
+	Owner     string `json:"owner"`
+	TtlMillis int64  `json:"ttlMillis"`
+}
+
+// LockRefreshResponseData tells whether Owner still held the lock.
+type LockRefreshResponseData struct {
+	Refreshed bool `json:"refreshed"`
+}
+
+func (s *basicDatabaseService) LockRefresh(ctx context.Context, req LockRefreshRequestData) (result LockRefreshResponseData, err error) {
+	c := s.db.Collection(n.CLock)
+	r, err := c.UpdateOne(
+		ctx,
+		bson.M{"_id": req.Key, "owner": req.Owner},
+		bson.M{"$set": bson.M{"expiresAt": time.Now().Add(time.Duration(req.TtlMillis) * time.Millisecond)}},
+	)
+	if err != nil {
+		log.Println("LockRefresh.UpdateOne", err)
+		return result, err
+	}
+	result.Refreshed = r.MatchedCount > 0
+	return result, nil
+}
+
+// LockReleaseRequestData frees the lock of Key if Owner still holds it.
+// Without Key, it frees all the locks held for Operation, those of a
+// stalled operation.
+type LockReleaseRequestData struct {
+	Key       string `json:"key"`
+	Owner     string `json:"owner"`
+	Operation string `json:"operation"`
+}
+
+type LockReleaseResponseData struct {
+	Released bool `json:"released"`
+}
+
+func (s *basicDatabaseService) LockRelease(ctx context.Context, req LockReleaseRequestData) (result LockReleaseResponseData, err error) {
+	c := s.db.Collection(n.CLock)
+	if req.Key == "" {
+		if req.Operation == "" {
+			return result, nil
+		}
+		r, err := c.DeleteMany(ctx, bson.M{"operation": req.Operation})
+		if err != nil {
+			log.Println("LockRelease.DeleteMany", err)
+			return result, err
+		}
+		result.Released = r.DeletedCount > 0
+		return result, nil
+	}
+	r, err := c.DeleteOne(ctx, bson.M{"_id": req.Key, "owner": req.Owner})
+	if err != nil {
+		log.Println("LockRelease.DeleteOne", err)
+		return result, err
+	}
+	result.Released = r.DeletedCount > 0
+	return result, nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"regexp"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -11,23 +13,48 @@ import (
 
 	n "server/common/names"
 	t "server/db/pkg/types"
+	statusModelEvaluate "server/db/pkg/types/status/model/evaluate"
 )
 
+// ModelFindOneRequestData finds a model by Id or, when Id is not set, by
+// ProblemId, Workspace and Name.
 type ModelFindOneRequestData struct {
-	Id primitive.ObjectID ` bson:"_id" json:"id"`
+	Id        primitive.ObjectID ` bson:"_id" json:"id"`
+	ProblemId primitive.ObjectID `bson:"problemId" json:"problemId"`
+	Workspace string             `bson:"workspace" json:"workspace,omitempty"`
+	Name      string             `bson:"name" json:"name"`
 }
 
 func (s *basicDatabaseService) ModelFindOne(ctx context.Context, req ModelFindOneRequestData) (result t.Model) {
 	modelCollection := s.db.Collection(n.CModel)
-	modelCollection.FindOne(ctx, bson.M{"_id": req.Id}).Decode(&result)
+	filter := bson.M{"_id": req.Id}
+	if req.Id.IsZero() {
+		filter = bson.M{"name": req.Name, "problemId": req.ProblemId, "workspace": workspaceFilter(req.Workspace)}
+	}
+	modelCollection.FindOne(ctx, filter).Decode(&result)
 	fmt.Println("Model FindOne", result)
 	return
 }
 
+// ModelFindRequestData lists the models of a problem, of every problem when
+// AnyProblem is set, the archived ones only when IncludeArchived is set, and
+// those of Workspace, named Name, with NameContains in their name, whatever
+// the case, or of training Status only when they are set. Fields, when set,
+// are the only fields of the models read.
 type ModelFindRequestData struct {
-	Page      int64              `bson:"page" json:"page"`
-	Size      int64              `bson:"size" json:"size"`
-	ProblemId primitive.ObjectID `bson:"problemId" json:"problemId"`
+	Page            int64              `bson:"page" json:"page"`
+	Size            int64              `bson:"size" json:"size"`
+	ProblemId       primitive.ObjectID `bson:"problemId" json:"problemId"`
+	Workspace       string             `bson:"workspace" json:"workspace,omitempty"`
+	Name            string             `bson:"name" json:"name,omitempty"`
+	NameContains    string             `bson:"nameContains" json:"nameContains,omitempty"`
+	Status          string             `bson:"status" json:"status,omitempty"`
+	AnyProblem      bool               `bson:"anyProblem" json:"anyProblem,omitempty"`
+	Tags            []string           `bson:"tags" json:"tags"`
+	Fields          []string           `bson:"fields" json:"fields,omitempty"`
+	IncludeArchived bool               `bson:"includeArchived" json:"includeArchived,omitempty"`
+	// Ids limits the models found to those of the ids when set.
+	Ids []primitive.ObjectID `bson:"ids" json:"ids,omitempty"`
 }
 
 func (s *basicDatabaseService) ModelFind(ctx context.Context, req ModelFindRequestData) (result t.ModelFindResponse) {
@@ -35,7 +62,14 @@ func (s *basicDatabaseService) ModelFind(ctx context.Context, req ModelFindReque
 	option := options.Find()
 	option.SetSkip(req.Size * (req.Page - 1))
 	option.SetLimit(req.Size)
-	filter := bson.M{"problemId": req.ProblemId}
+	if len(req.Fields) > 0 {
+		projection := bson.M{}
+		for _, field := range req.Fields {
+			projection[field] = 1
+		}
+		option.SetProjection(projection)
+	}
+	filter := modelFindFilter(req)
 	total, err := c.CountDocuments(ctx, filter, options.Count())
 	if err != nil {
 		return t.ModelFindResponse{BaseList: t.BaseList{}}
@@ -67,6 +101,42 @@ func (s *basicDatabaseService) ModelFind(ctx context.Context, req ModelFindReque
 	return t.ModelFindResponse{BaseList: t.BaseList{Total: total}, Items: items}
 }
 
+// modelFindFilter is the filter of the models req lists, its paging and
+// fields aside.
+func modelFindFilter(req ModelFindRequestData) bson.M {
+	filter := bson.M{}
+	if !req.AnyProblem {
+		filter["problemId"] = req.ProblemId
+	}
+	if req.Workspace != "" {
+		filter["workspace"] = req.Workspace
+	}
+	if req.Name != "" {
+		filter["name"] = req.Name
+	}
+	if req.NameContains != "" {
+		contains := primitive.Regex{Pattern: regexp.QuoteMeta(req.NameContains), Options: "i"}
+		if req.Name != "" {
+			filter["name"] = bson.M{"$eq": req.Name, "$regex": contains}
+		} else {
+			filter["name"] = contains
+		}
+	}
+	if req.Status != "" {
+		filter["status"] = req.Status
+	}
+	if len(req.Tags) > 0 {
+		filter["tags"] = bson.M{"$all": req.Tags}
+	}
+	if !req.IncludeArchived {
+		filter["archived"] = bson.M{"$ne": true}
+	}
+	if len(req.Ids) > 0 {
+		filter["_id"] = bson.M{"$in": req.Ids}
+	}
+	return filter
+}
+
 type ModelInsertOneRequestData = t.ModelWithoutId
 
 func (s *basicDatabaseService) ModelInsertOne(ctx context.Context, req ModelInsertOneRequestData) (result t.Model, err error) {
@@ -102,17 +172,232 @@ func (s *basicDatabaseService) ModelUpdateOne(ctx context.Context, req ModelUpda
 
 }
 
+// ModelUpdateStatusRequestData sets the training status of a model, when
+// Status is not empty, and its evaluations on the builds keyed by id in
+// Evaluates, leaving the rest of the model alone.
+type ModelUpdateStatusRequestData struct {
+	Id        primitive.ObjectID    `json:"id"`
+	Status    string                `json:"status,omitempty"`
+	Evaluates map[string]t.Evaluate `json:"evaluates,omitempty"`
+}
+
+func (s *basicDatabaseService) ModelUpdateStatus(ctx context.Context, req ModelUpdateStatusRequestData) (result t.Model, err error) {
+	modelCollection := s.db.Collection(n.CModel)
+	set := bson.M{}
+	if req.Status != "" {
+		set["status"] = req.Status
+	}
+	for buildId, evaluate := range req.Evaluates {
+		set["evaluates."+buildId] = evaluate
+	}
+	if len(set) > 0 {
+		if _, err = modelCollection.UpdateOne(ctx, bson.M{"_id": req.Id}, bson.M{"$set": set}); err != nil {
+			log.Println("ModelUpdateStatus.UpdateOne", err)
+			return result, err
+		}
+	}
+	err = modelCollection.FindOne(ctx, bson.M{"_id": req.Id}).Decode(&result)
+	return result, err
+}
+
+// ModelUpdateFieldsRequestData sets the fields of the model Id that are not
+// nil, leaving the rest of it alone: the changes made by the users do not
+// save over the training and evaluation statuses.
+type ModelUpdateFieldsRequestData struct {
+	Id           primitive.ObjectID `json:"id"`
+	Tags         *[]string          `json:"tags,omitempty"`
+	Archived     *bool              `json:"archived,omitempty"`
+	ArchivedAt   *time.Time         `json:"archivedAt,omitempty"`
+	Dependencies *[]t.Dependency    `json:"dependencies,omitempty"`
+	ContentHash  *string            `json:"contentHash,omitempty"`
+}
+
+func (s *basicDatabaseService) ModelUpdateFields(ctx context.Context, req ModelUpdateFieldsRequestData) (result t.Model, err error) {
+	modelCollection := s.db.Collection(n.CModel)
+	set := bson.M{}
+	if req.Tags != nil {
+		set["tags"] = *req.Tags
+	}
+	if req.Archived != nil {
+		set["archived"] = *req.Archived
+	}
+	if req.ArchivedAt != nil {
+		set["archivedAt"] = *req.ArchivedAt
+	}
+	if req.Dependencies != nil {
+		set["dependencies"] = *req.Dependencies
+	}
+	if req.ContentHash != nil {
+		set["contentHash"] = *req.ContentHash
+	}
+	if len(set) > 0 {
+		if _, err = modelCollection.UpdateOne(ctx, bson.M{"_id": req.Id}, bson.M{"$set": set}); err != nil {
+			log.Println("ModelUpdateFields.UpdateOne", err)
+			return result, err
+		}
+	}
+	err = modelCollection.FindOne(ctx, bson.M{"_id": req.Id}).Decode(&result)
+	return result, err
+}
+
+// ModelMarkStaleRequestData marks stale the finished evaluations of the
+// models on the build BuildId made on assets other than AssetsHash, those
+// whose staleness was dismissed aside.
+type ModelMarkStaleRequestData struct {
+	BuildId    primitive.ObjectID `json:"buildId"`
+	AssetsHash string             `json:"assetsHash"`
+}
+
+type ModelMarkStaleResponseData struct {
+	Marked int64 `json:"marked"`
+}
+
+func (s *basicDatabaseService) ModelMarkStale(ctx context.Context, req ModelMarkStaleRequestData) (result ModelMarkStaleResponseData, err error) {
+	modelCollection := s.db.Collection(n.CModel)
+	evaluate := "evaluates." + req.BuildId.Hex()
+	filter := bson.M{
+		evaluate + ".status":         statusModelEvaluate.Finished,
+		evaluate + ".assetsHash":     bson.M{"$ne": req.AssetsHash},
+		evaluate + ".stale":          bson.M{"$ne": true},
+		evaluate + ".staleDismissed": bson.M{"$ne": true},
+	}
+	set := bson.M{evaluate + ".stale": true, evaluate + ".staleSince": time.Now()}
+	r, err := modelCollection.UpdateMany(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		log.Println("ModelMarkStale.UpdateMany", err)
+		return result, err
+	}
+	result.Marked = r.ModifiedCount
+	return result, nil
+}
+
+// modelPatchUpdate is the update pipeline of p, a single $set stage
+// computing the new fields from the stored ones, so that the models are
+// changed with one updateMany.
+func modelPatchUpdate(p t.ModelPatch, now time.Time) bson.A {
+	set := bson.M{}
+	if p.SetTags != nil || len(p.AddTags) > 0 || len(p.RemoveTags) > 0 {
+		var tags interface{} = bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}
+		if p.SetTags != nil {
+			tags = literalStrings(*p.SetTags)
+		}
+		if len(p.RemoveTags) > 0 {
+			tags = bson.M{"$filter": bson.M{
+				"input": tags,
+				"cond":  bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$$this", literalStrings(p.RemoveTags)}}}},
+			}}
+		}
+		if len(p.AddTags) > 0 {
+			tags = bson.M{"$concatArrays": bson.A{tags, bson.M{"$filter": bson.M{
+				"input": literalStrings(p.AddTags),
+				"cond":  bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$$this", tags}}}},
+			}}}}
+		}
+		set["tags"] = tags
+	}
+	if p.Description != nil {
+		set["description"] = bson.M{"$literal": *p.Description}
+	}
+	if p.License != nil {
+		set["license"] = bson.M{"$literal": *p.License}
+	}
+	if p.Archived != nil {
+		set["archived"] = *p.Archived
+		if *p.Archived {
+			set["archivedAt"] = bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$archived", true}}, "$archivedAt", now}}
+		} else {
+			set["archivedAt"] = time.Time{}
+		}
+	}
+	return bson.A{bson.M{"$set": set}}
+}
+
+// literalStrings is values as a constant of an aggregation expression, so
+// that a tag starting with $ is not read as a field path.
+func literalStrings(values []string) bson.M {
+	if values == nil {
+		values = []string{}
+	}
+	return bson.M{"$literal": values}
+}
+
+// ModelBulkUpdateRequestData applies Patch to the models Filter lists, its
+// paging and fields aside. When Preview is set nothing is changed, the
+// models matched are counted and SampleSize of them returned instead.
+type ModelBulkUpdateRequestData struct {
+	Filter     ModelFindRequestData `json:"filter"`
+	Patch      t.ModelPatch         `json:"patch"`
+	Preview    bool                 `json:"preview,omitempty"`
+	SampleSize int64                `json:"sampleSize,omitempty"`
+}
+
+type ModelBulkUpdateResponseData struct {
+	Matched  int64     `json:"matched"`
+	Modified int64     `json:"modified"`
+	Samples  []t.Model `json:"samples,omitempty"`
+}
+
+func (s *basicDatabaseService) ModelBulkUpdate(ctx context.Context, req ModelBulkUpdateRequestData) (result ModelBulkUpdateResponseData, err error) {
+	modelCollection := s.db.Collection(n.CModel)
+	filter := modelFindFilter(req.Filter)
+	if req.Preview {
+		if result.Matched, err = modelCollection.CountDocuments(ctx, filter); err != nil {
+			log.Println("ModelBulkUpdate.CountDocuments", err)
+			return result, err
+		}
+		if req.SampleSize <= 0 {
+			return result, nil
+		}
+		option := options.Find().SetLimit(req.SampleSize).SetSort(bson.M{"_id": 1})
+		if len(req.Filter.Fields) > 0 {
+			projection := bson.M{}
+			for _, field := range req.Filter.Fields {
+				projection[field] = 1
+			}
+			option.SetProjection(projection)
+		}
+		cur, err := modelCollection.Find(ctx, filter, option)
+		if err != nil {
+			log.Println("ModelBulkUpdate.Find", err)
+			return result, err
+		}
+		defer cur.Close(ctx)
+		if err = cur.All(ctx, &result.Samples); err != nil {
+			log.Println("ModelBulkUpdate.cur.All", err)
+		}
+		return result, err
+	}
+	if req.Patch.IsEmpty() {
+		return result, fmt.Errorf("the patch changes nothing")
+	}
+	r, err := modelCollection.UpdateMany(ctx, filter, modelPatchUpdate(req.Patch, time.Now()))
+	if err != nil {
+		log.Println("ModelBulkUpdate.UpdateMany", err)
+		return result, err
+	}
+	result.Matched = r.MatchedCount
+	result.Modified = r.ModifiedCount
+	return result, nil
+}
+
 type ModelUpdateUpsertRequestData = t.ModelWithoutId
 
 func (s *basicDatabaseService) ModelUpdateUpsert(ctx context.Context, req ModelUpdateUpsertRequestData) (result t.Model) {
 	modelCollection := s.db.Collection(n.CModel)
+	if req.ProblemId.IsZero() {
+		// Without a problem the upsert would overwrite the model of the
+		// same name of any problem.
+		log.Println("ModelUpdateUpsert: model", req.Name, "has no problem")
+		return result
+	}
 	option := options.Update()
 	option.SetUpsert(true)
-	_, err := modelCollection.UpdateOne(ctx, bson.M{"name": req.Name, "problemId": req.ProblemId}, bson.D{{"$set", req}}, option)
+	filter := bson.M{"name": req.Name, "problemId": req.ProblemId, "workspace": workspaceFilter(req.Workspace)}
+	_, err := modelCollection.UpdateOne(ctx, filter, bson.D{{"$set", req}}, option)
 	if err != nil {
 		log.Println("UpdateOne", err)
 	}
-	err = modelCollection.FindOne(ctx, bson.M{"name": req.Name, "problemId": req.ProblemId}).Decode(&result)
+	err = modelCollection.FindOne(ctx, filter).Decode(&result)
 	if err != nil {
 		log.Println("ModelUpdateUpsert.FindOne", err)
 	}
@@ -140,3 +425,12 @@ func (s *basicDatabaseService) ModelDelete(ctx context.Context, req ModelDeleteR
 	}
 	return ModelDeleteResponseData{Id: req.Id}
 }
+
+// workspaceFilter matches the models of workspace, those stored before the
+// workspaces, without the field, being in the default one.
+func workspaceFilter(workspace string) interface{} {
+	if workspace == "" {
+		return bson.M{"$in": bson.A{"", nil}}
+	}
+	return workspace
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	n "server/common/names"
+	t "server/db/pkg/types"
+	statusOperation "server/db/pkg/types/status/operation"
+)
+
+type OperationFindOneRequestData struct {
+	Id primitive.ObjectID `bson:"_id" json:"id"`
+}
+
+func (s *basicDatabaseService) OperationFindOne(ctx context.Context, req OperationFindOneRequestData) (result t.Operation) {
+	operationCollection := s.db.Collection(n.COperation)
+	err := operationCollection.FindOne(ctx, bson.M{"_id": req.Id}).Decode(&result)
+	if err != nil {
+		log.Println("OperationFindOne.FindOne", err)
+	}
+	return result
+}
+
+type OperationInsertOneRequestData = t.Operation
+
+func (s *basicDatabaseService) OperationInsertOne(ctx context.Context, req OperationInsertOneRequestData) (result t.Operation, err error) {
+	operationCollection := s.db.Collection(n.COperation)
+	if req.Id.IsZero() {
+		req.Id = primitive.NewObjectID()
+	}
+	now := time.Now()
+	req.CreatedAt = now
+	req.UpdatedAt = now
+	if _, err = operationCollection.InsertOne(ctx, req); err != nil {
+		log.Println("OperationInsertOne.InsertOne", err)
+		return result, err
+	}
+	err = operationCollection.FindOne(ctx, bson.M{"_id": req.Id}).Decode(&result)
+	return result, err
+}
+
+type OperationUpdateOneRequestData = t.Operation
+
+// OperationUpdateOne stores the operation and refreshes UpdatedAt, which the
+// TTL index expires operations by. A stalled operation is left as it is, for
+// its worker may wake up and save it: it is claimed out of the stalled
+// status first.
+func (s *basicDatabaseService) OperationUpdateOne(ctx context.Context, req OperationUpdateOneRequestData) (result t.Operation) {
+	operationCollection := s.db.Collection(n.COperation)
+	req.UpdatedAt = time.Now()
+	_, err := operationCollection.UpdateOne(ctx, bson.M{"_id": req.Id, "status": bson.M{"$ne": statusOperation.Stalled}}, bson.M{"$set": req})
+	if err != nil {
+		log.Println("OperationUpdateOne.UpdateOne", err)
+	}
+	err = operationCollection.FindOne(ctx, bson.M{"_id": req.Id}).Decode(&result)
+	if err != nil {
+		log.Println("OperationUpdateOne.FindOne", err)
+	}
+	return result
+}
+
+// OperationClaimRequestData moves the operation Id from the status From to
+// To, so that only one of the callers racing for it gets it.
+type OperationClaimRequestData struct {
+	Id   primitive.ObjectID `json:"id"`
+	From string             `json:"from"`
+	To   string             `json:"to"`
+}
+
+// OperationClaim returns the claimed operation, or the zero operation when
+// it is not in the status From.
+func (s *basicDatabaseService) OperationClaim(ctx context.Context, req OperationClaimRequestData) (result t.Operation, err error) {
+	operationCollection := s.db.Collection(n.COperation)
+	err = operationCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": req.Id, "status": req.From},
+		bson.M{"$set": bson.M{"status": req.To, "updatedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return t.Operation{}, nil
+	}
+	if err != nil {
+		log.Println("OperationClaim.FindOneAndUpdate", err)
+	}
+	return result, err
+}
+
+type OperationInterruptRequestData struct{}
+
+type OperationInterruptResponseData struct {
+	Items []t.Operation `json:"items"`
+}
+
+// OperationInterrupt marks interrupted the pending and running operations,
+// which no model service runs any more when it starts, and returns them.
+func (s *basicDatabaseService) OperationInterrupt(ctx context.Context, req OperationInterruptRequestData) (result OperationInterruptResponseData, err error) {
+	operationCollection := s.db.Collection(n.COperation)
+	result.Items = []t.Operation{}
+	filter := bson.M{"status": bson.M{"$in": bson.A{statusOperation.Pending, statusOperation.Running}}}
+	cur, err := operationCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		log.Println("OperationInterrupt.Find", err)
+		return result, err
+	}
+	defer cur.Close(ctx)
+	var operations []t.Operation
+	if err := cur.All(ctx, &operations); err != nil {
+		return result, err
+	}
+	now := time.Now()
+	for _, operation := range operations {
+		r, err := operationCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": operation.Id, "status": operation.Status},
+			bson.M{"$set": bson.M{"status": statusOperation.Interrupted, "updatedAt": now}},
+		)
+		if err != nil {
+			log.Println("OperationInterrupt.UpdateOne", err)
+			return result, err
+		}
+		if r.ModifiedCount == 0 {
+			continue
+		}
+		operation.Status = statusOperation.Interrupted
+		operation.UpdatedAt = now
+		result.Items = append(result.Items, operation)
+	}
+	return result, nil
+}
+
+// OperationHeartbeatRequestData tells the operation Id is alive, Owner being
+// the replica running it.
+type OperationHeartbeatRequestData struct {
+	Id    primitive.ObjectID `json:"id"`
+	Owner string             `json:"owner"`
+}
+
+// OperationHeartbeatResponseData tells whether the operation was still
+// pending or running, a stalled, cancelled or finished one not being beaten.
+type OperationHeartbeatResponseData struct {
+	Alive bool `json:"alive"`
+}
+
+// OperationHeartbeat sets the heartbeat of the operation. It leaves
+// UpdatedAt as it is, the watchers of the operation being told about its
+// changes only.
+func (s *basicDatabaseService) OperationHeartbeat(ctx context.Context, req OperationHeartbeatRequestData) (result OperationHeartbeatResponseData, err error) {
+	operationCollection := s.db.Collection(n.COperation)
+	r, err := operationCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": req.Id, "status": bson.M{"$in": bson.A{statusOperation.Pending, statusOperation.Running}}},
+		bson.M{"$set": bson.M{"heartbeatAt": time.Now(), "owner": req.Owner}},
+	)
+	if err != nil {
+		log.Println("OperationHeartbeat.UpdateOne", err)
+		return result, err
+	}
+	result.Alive = r.MatchedCount > 0
+	return result, nil
+}
+
+// OperationStallRequestData stalls the operations whose last heartbeat is
+// older than Before.
+type OperationStallRequestData struct {
+	Before time.Time `json:"before"`
+}
+
+type OperationStallResponseData struct {
+	Items []t.Operation `json:"items"`
+}
+
+// OperationStall marks stalled the pending and running operations that did
+// not beat since Before, recording the stall with them, and returns them.
+// The operations without a heartbeat, of a service that did not send them,
+// are left alone. An operation beaten meanwhile is not stalled, nor is one
+// another caller stalled first.
+func (s *basicDatabaseService) OperationStall(ctx context.Context, req OperationStallRequestData) (result OperationStallResponseData, err error) {
+	operationCollection := s.db.Collection(n.COperation)
+	result.Items = []t.Operation{}
+	filter := bson.M{
+		"status":      bson.M{"$in": bson.A{statusOperation.Pending, statusOperation.Running}},
+		"heartbeatAt": bson.M{"$lt": req.Before},
+	}
+	cur, err := operationCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		log.Println("OperationStall.Find", err)
+		return result, err
+	}
+	defer cur.Close(ctx)
+	var operations []t.Operation
+	if err := cur.All(ctx, &operations); err != nil {
+		return result, err
+	}
+	now := time.Now()
+	for _, operation := range operations {
+		stall := t.OperationStall{At: now, HeartbeatAt: operation.HeartbeatAt, Owner: operation.Owner}
+		r, err := operationCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": operation.Id, "status": operation.Status, "heartbeatAt": operation.HeartbeatAt},
+			bson.M{
+				"$set":  bson.M{"status": statusOperation.Stalled, "updatedAt": now},
+				"$push": bson.M{"stalls": stall},
+			},
+		)
+		if err != nil {
+			log.Println("OperationStall.UpdateOne", err)
+			return result, err
+		}
+		if r.ModifiedCount == 0 {
+			continue
+		}
+		operation.Status = statusOperation.Stalled
+		operation.UpdatedAt = now
+		operation.Stalls = append(operation.Stalls, stall)
+		result.Items = append(result.Items, operation)
+	}
+	return result, nil
+}
@@ -42,9 +42,12 @@ func (s *basicDatabaseService) ProblemFindOne(ctx context.Context, req ProblemFi
 	return
 }
 
+// ProblemFindRequestData lists the problems, only those titled one of
+// Titles when it is set.
 type ProblemFindRequestData struct {
-	Page int64 `json:"page" bson:"page"`
-	Size int64 `json:"size" bson:"size"`
+	Page   int64    `json:"page" bson:"page"`
+	Size   int64    `json:"size" bson:"size"`
+	Titles []string `json:"titles,omitempty" bson:"titles,omitempty"`
 }
 
 func (s *basicDatabaseService) ProblemFind(ctx context.Context, req ProblemFindRequestData) (result t.ProblemFindResponse) {
@@ -52,11 +55,19 @@ func (s *basicDatabaseService) ProblemFind(ctx context.Context, req ProblemFindR
 	option := options.Find()
 	option.SetSkip(req.Size * (req.Page - 1))
 	option.SetLimit(req.Size)
-	total, err := problemCollection.EstimatedDocumentCount(ctx)
+	filter := bson.M{}
+	var total int64
+	var err error
+	if len(req.Titles) > 0 {
+		filter["title"] = bson.M{"$in": req.Titles}
+		total, err = problemCollection.CountDocuments(ctx, filter)
+	} else {
+		total, err = problemCollection.EstimatedDocumentCount(ctx)
+	}
 	if err != nil {
 		return t.ProblemFindResponse{BaseList: t.BaseList{}}
 	}
-	cur, err := problemCollection.Find(ctx, bson.M{}, option)
+	cur, err := problemCollection.Find(ctx, filter, option)
 	var items []t.Problem
 	items = []t.Problem{}
 	if err != nil {
@@ -84,6 +95,22 @@ func (s *basicDatabaseService) ProblemFind(ctx context.Context, req ProblemFindR
 	return t.ProblemFindResponse{BaseList: t.BaseList{Total: total}, Items: items}
 }
 
+type ProblemUpdateOneRequestData = t.Problem
+
+func (s *basicDatabaseService) ProblemUpdateOne(ctx context.Context, req ProblemUpdateOneRequestData) (result t.Problem, err error) {
+	problemCollection := s.db.Collection(n.CProblem)
+	_, err = problemCollection.UpdateOne(ctx, bson.M{"_id": req.Id}, bson.M{"$set": req})
+	if err != nil {
+		log.Println("ProblemUpdateOne.UpdateOne", err)
+		return result, err
+	}
+	err = problemCollection.FindOne(ctx, bson.M{"_id": req.Id}).Decode(&result)
+	if err != nil {
+		log.Println("ProblemUpdateOne.FindOne.Decode(&result)", err)
+	}
+	return result, err
+}
+
 type ProblemUpdateUpsertRequestData = t.ProblemWithouId
 
 func (s *basicDatabaseService) ProblemUpdateUpsert(ctx context.Context, req ProblemUpdateUpsertRequestData) (result t.Problem) {
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	n "server/common/names"
+	t "server/db/pkg/types"
+)
+
+type ProblemUsageAddRequestData struct {
+	ProblemId primitive.ObjectID `bson:"problemId" json:"problemId"`
+	Delta     int64              `bson:"delta" json:"delta"`
+}
+
+// ProblemUsageAdd applies the byte delta reported by an import, export or
+// delete to the tracked usage of the problem.
+func (s *basicDatabaseService) ProblemUsageAdd(ctx context.Context, req ProblemUsageAddRequestData) (result t.ProblemUsage) {
+	usageCollection := s.db.Collection(n.CProblemUsage)
+	option := options.FindOneAndUpdate()
+	option.SetUpsert(true)
+	option.SetReturnDocument(options.After)
+	err := usageCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"problemId": req.ProblemId},
+		bson.M{"$inc": bson.M{"usageBytes": req.Delta}},
+		option,
+	).Decode(&result)
+	if err != nil {
+		log.Println("ProblemUsageAdd.FindOneAndUpdate", err)
+	}
+	return result
+}
+
+type ProblemUsageSetRequestData = t.ProblemUsage
+
+func (s *basicDatabaseService) ProblemUsageSet(ctx context.Context, req ProblemUsageSetRequestData) (result t.ProblemUsage) {
+	usageCollection := s.db.Collection(n.CProblemUsage)
+	option := options.Update()
+	option.SetUpsert(true)
+	filter := bson.M{"problemId": req.ProblemId}
+	_, err := usageCollection.UpdateOne(ctx, filter, bson.M{"$set": req}, option)
+	if err != nil {
+		log.Println("ProblemUsageSet.UpdateOne", err)
+	}
+	err = usageCollection.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		log.Println("ProblemUsageSet.FindOne", err)
+	}
+	return result
+}
+
+type ProblemUsageFindRequestData struct {
+	ProblemIds []primitive.ObjectID `bson:"problemIds" json:"problemIds"`
+}
+
+type ProblemUsageFindResponseData struct {
+	Items []t.ProblemUsage `bson:"items" json:"items"`
+}
+
+func (s *basicDatabaseService) ProblemUsageFind(ctx context.Context, req ProblemUsageFindRequestData) (result ProblemUsageFindResponseData) {
+	usageCollection := s.db.Collection(n.CProblemUsage)
+	filter := bson.M{}
+	if len(req.ProblemIds) > 0 {
+		filter["problemId"] = bson.M{"$in": req.ProblemIds}
+	}
+	result.Items = []t.ProblemUsage{}
+	cur, err := usageCollection.Find(ctx, filter)
+	if err != nil {
+		log.Println("ProblemUsageFind.Find", err)
+		return result
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var elem t.ProblemUsage
+		if err := cur.Decode(&elem); err != nil {
+			log.Println("ProblemUsageFind.Decode", err)
+			return result
+		}
+		result.Items = append(result.Items, elem)
+	}
+	return result
+}
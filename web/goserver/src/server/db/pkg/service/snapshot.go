@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	n "server/common/names"
+	t "server/db/pkg/types"
+)
+
+// SnapshotSetRefsRequestData replaces the references of the model to the
+// snapshots with Files, no files remove them all.
+type SnapshotSetRefsRequestData struct {
+	ModelId primitive.ObjectID `bson:"modelId" json:"modelId"`
+	Files   []t.SnapshotFile   `bson:"files" json:"files"`
+}
+
+// SnapshotSetRefsResponseData tells how many snapshots lost their last
+// reference.
+type SnapshotSetRefsResponseData struct {
+	Unreferenced int64 `bson:"unreferenced" json:"unreferenced"`
+}
+
+// SnapshotSetRefs drops the references of the model before adding the new
+// ones, the snapshots left without references are then marked unreferenced
+// from now on. A snapshot referenced again is no longer unreferenced.
+func (s *basicDatabaseService) SnapshotSetRefs(ctx context.Context, req SnapshotSetRefsRequestData) (result SnapshotSetRefsResponseData, err error) {
+	c := s.db.Collection(n.CSnapshot)
+	_, err = c.UpdateMany(
+		ctx,
+		bson.M{"refs.modelId": req.ModelId},
+		bson.M{"$pull": bson.M{"refs": bson.M{"modelId": req.ModelId}}},
+	)
+	if err != nil {
+		log.Println("SnapshotSetRefs.UpdateMany", err)
+		return result, err
+	}
+	now := time.Now()
+	for _, f := range req.Files {
+		_, err = c.UpdateOne(
+			ctx,
+			bson.M{"_id": f.Sha256},
+			bson.M{
+				"$setOnInsert": bson.M{"size": f.Size, "blob": f.Blob, "createdAt": now},
+				"$set":         bson.M{"unreferencedAt": time.Time{}},
+				"$addToSet":    bson.M{"refs": t.SnapshotRef{ModelId: req.ModelId, Path: f.Path}},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			log.Println("SnapshotSetRefs.UpdateOne", err)
+			return result, err
+		}
+	}
+	updateResult, err := c.UpdateMany(
+		ctx,
+		bson.M{"refs": bson.M{"$size": 0}, "unreferencedAt": time.Time{}},
+		bson.M{"$set": bson.M{"unreferencedAt": now}},
+	)
+	if err != nil {
+		log.Println("SnapshotSetRefs.UpdateMany", err)
+		return result, err
+	}
+	result.Unreferenced = updateResult.ModifiedCount
+	return result, nil
+}
+
+// SnapshotFindRequestData lists all the snapshots, or only those
+// unreferenced since UnreferencedBefore when it is set.
+type SnapshotFindRequestData struct {
+	UnreferencedBefore time.Time `bson:"unreferencedBefore" json:"unreferencedBefore"`
+}
+
+type SnapshotFindResponseData struct {
+	Items []t.Snapshot `bson:"items" json:"items"`
+}
+
+func (s *basicDatabaseService) SnapshotFind(ctx context.Context, req SnapshotFindRequestData) (result SnapshotFindResponseData, err error) {
+	c := s.db.Collection(n.CSnapshot)
+	filter := bson.M{}
+	if !req.UnreferencedBefore.IsZero() {
+		filter = unreferencedBefore(req.UnreferencedBefore)
+	}
+	result.Items = []t.Snapshot{}
+	cur, err := c.Find(ctx, filter)
+	if err != nil {
+		log.Println("SnapshotFind.Find", err)
+		return result, err
+	}
+	defer cur.Close(ctx)
+	err = cur.All(ctx, &result.Items)
+	return result, err
+}
+
+// SnapshotDeleteRequestData deletes the snapshot unless it was referenced
+// again or after UnreferencedBefore, which is required.
+type SnapshotDeleteRequestData struct {
+	Sha256             string    `bson:"sha256" json:"sha256"`
+	UnreferencedBefore time.Time `bson:"unreferencedBefore" json:"unreferencedBefore"`
+}
+
+type SnapshotDeleteResponseData struct {
+	Deleted bool `bson:"deleted" json:"deleted"`
+}
+
+func (s *basicDatabaseService) SnapshotDelete(ctx context.Context, req SnapshotDeleteRequestData) (result SnapshotDeleteResponseData, err error) {
+	c := s.db.Collection(n.CSnapshot)
+	filter := unreferencedBefore(req.UnreferencedBefore)
+	filter["_id"] = req.Sha256
+	deleteResult, err := c.DeleteOne(ctx, filter)
+	if err != nil {
+		log.Println("SnapshotDelete.DeleteOne", err)
+		return result, err
+	}
+	result.Deleted = deleteResult.DeletedCount > 0
+	return result, nil
+}
+
+func unreferencedBefore(before time.Time) bson.M {
+	return bson.M{
+		"refs":           bson.M{"$size": 0},
+		"unreferencedAt": bson.M{"$gt": time.Time{}, "$lte": before},
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	n "server/common/names"
+	t "server/db/pkg/types"
+)
+
+type WebhookDeleteRequestData struct {
+	Id primitive.ObjectID `bson:"id" json:"id"`
+}
+
+type WebhookDeleteResponseData struct {
+	Deleted bool `bson:"deleted" json:"deleted"`
+}
+
+// WebhookDelete removes the webhook, its dead letters are kept.
+func (s *basicDatabaseService) WebhookDelete(ctx context.Context, req WebhookDeleteRequestData) (result WebhookDeleteResponseData, err error) {
+	c := s.db.Collection(n.CWebhook)
+	deleteResult, err := c.DeleteOne(ctx, bson.M{"_id": req.Id})
+	if err != nil {
+		log.Println("WebhookDelete.DeleteOne", err)
+		return result, err
+	}
+	result.Deleted = deleteResult.DeletedCount > 0
+	return result, nil
+}
+
+// WebhookFindRequestData filters the webhooks, zero fields match everything.
+// ProblemIds keeps the webhooks of these problems, a zero id standing for
+// the global webhooks. Event keeps the webhooks receiving it.
+type WebhookFindRequestData struct {
+	Id         primitive.ObjectID   `bson:"id" json:"id"`
+	ProblemIds []primitive.ObjectID `bson:"problemIds" json:"problemIds"`
+	Event      string               `bson:"event" json:"event"`
+}
+
+type WebhookFindResponseData struct {
+	Items []t.Webhook `bson:"items" json:"items"`
+}
+
+func (s *basicDatabaseService) WebhookFind(ctx context.Context, req WebhookFindRequestData) (result WebhookFindResponseData, err error) {
+	c := s.db.Collection(n.CWebhook)
+	filter := bson.M{}
+	if !req.Id.IsZero() {
+		filter["_id"] = req.Id
+	}
+	if len(req.ProblemIds) > 0 {
+		filter["problemId"] = bson.M{"$in": req.ProblemIds}
+	}
+	if req.Event != "" {
+		filter["$or"] = bson.A{
+			bson.M{"events": bson.M{"$size": 0}},
+			bson.M{"events": req.Event},
+		}
+	}
+	result.Items = []t.Webhook{}
+	cur, err := c.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		log.Println("WebhookFind.Find", err)
+		return result, err
+	}
+	defer cur.Close(ctx)
+	err = cur.All(ctx, &result.Items)
+	return result, err
+}
+
+type WebhookInsertOneRequestData = t.Webhook
+
+func (s *basicDatabaseService) WebhookInsertOne(ctx context.Context, req WebhookInsertOneRequestData) (result t.Webhook, err error) {
+	c := s.db.Collection(n.CWebhook)
+	req.Id = primitive.NewObjectID()
+	if req.CreatedAt.IsZero() {
+		req.CreatedAt = time.Now()
+	}
+	if req.Events == nil {
+		req.Events = []string{}
+	}
+	if _, err = c.InsertOne(ctx, req); err != nil {
+		log.Println("WebhookInsertOne.InsertOne", err)
+		return result, err
+	}
+	return req, nil
+}
+
+// WebhookDeadLetterFindRequestData filters the dead letters, zero fields
+// match everything. ProblemIds is that of WebhookFindRequestData.
+type WebhookDeadLetterFindRequestData struct {
+	WebhookId  primitive.ObjectID   `bson:"webhookId" json:"webhookId"`
+	ProblemIds []primitive.ObjectID `bson:"problemIds" json:"problemIds"`
+	Page       int64                `bson:"page" json:"page"`
+	Size       int64                `bson:"size" json:"size"`
+}
+
+// WebhookDeadLetterFind returns the newest dead letters first.
+func (s *basicDatabaseService) WebhookDeadLetterFind(ctx context.Context, req WebhookDeadLetterFindRequestData) (result t.WebhookDeadLetterFindResponse, err error) {
+	c := s.db.Collection(n.CWebhookDeadLetter)
+	filter := bson.M{}
+	if !req.WebhookId.IsZero() {
+		filter["webhookId"] = req.WebhookId
+	}
+	if len(req.ProblemIds) > 0 {
+		filter["problemId"] = bson.M{"$in": req.ProblemIds}
+	}
+	result.Items = []t.WebhookDeadLetter{}
+	if result.Total, err = c.CountDocuments(ctx, filter); err != nil {
+		return result, err
+	}
+	option := options.Find().SetSort(bson.M{"createdAt": -1})
+	if req.Page > 0 && req.Size > 0 {
+		option.SetSkip(req.Size * (req.Page - 1))
+		option.SetLimit(req.Size)
+	}
+	cur, err := c.Find(ctx, filter, option)
+	if err != nil {
+		return result, err
+	}
+	defer cur.Close(ctx)
+	err = cur.All(ctx, &result.Items)
+	return result, err
+}
+
+type WebhookDeadLetterInsertOneRequestData = t.WebhookDeadLetter
+
+func (s *basicDatabaseService) WebhookDeadLetterInsertOne(ctx context.Context, req WebhookDeadLetterInsertOneRequestData) (result t.WebhookDeadLetter, err error) {
+	c := s.db.Collection(n.CWebhookDeadLetter)
+	req.Id = primitive.NewObjectID()
+	if req.CreatedAt.IsZero() {
+		req.CreatedAt = time.Now()
+	}
+	if _, err = c.InsertOne(ctx, req); err != nil {
+		log.Println("WebhookDeadLetterInsertOne.InsertOne", err)
+		return result, err
+	}
+	return req, nil
+}
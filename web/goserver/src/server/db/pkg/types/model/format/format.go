@@ -0,0 +1,8 @@
+package format
+
+// Formats of the snapshot of a model. Models stored without one are PyTorch.
+const (
+	PyTorch    = "pytorch"
+	Onnx       = "onnx"
+	OpenvinoIr = "openvinoIr"
+)
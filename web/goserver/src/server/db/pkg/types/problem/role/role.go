@@ -0,0 +1,8 @@
+package role
+
+// Roles of a user in Problem.Access, from the least to the most privileged.
+const (
+	Viewer = "viewer"
+	Editor = "editor"
+	Owner  = "owner"
+)
@@ -0,0 +1,7 @@
+package annotation
+
+const (
+	Default   = "annotationDefault"
+	Duplicate = "annotationDuplicate"
+	Corrupt   = "annotationCorrupt"
+)
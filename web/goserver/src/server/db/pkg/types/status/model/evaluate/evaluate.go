@@ -1,8 +1,34 @@
 package evaluate
 
+import "fmt"
+
 const (
 	Default    = "evaluateDefault"
 	InProgress = "evaluateInProgress"
 	Finished   = "evaluateFinished"
 	Failed     = "evaluateFailed"
 )
+
+// transitions lists the statuses an evaluation may go to from each status. An
+// evaluation always runs before it ends, and a finished or failed one may be
+// run again.
+var transitions = map[string][]string{
+	Default:    {InProgress},
+	InProgress: {Finished, Failed},
+	Finished:   {InProgress},
+	Failed:     {InProgress},
+}
+
+// CheckTransition returns an error when an evaluation may not go from the
+// status from to the status to. An empty from is taken for Default.
+func CheckTransition(from, to string) error {
+	if from == "" {
+		from = Default
+	}
+	for _, status := range transitions[from] {
+		if status == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("evaluation can not go from %s to %s", from, to)
+}
@@ -1,8 +1,32 @@
 package train
 
+import "fmt"
+
 const (
 	Default    = "trainDefault"
 	InProgress = "trainInProgress"
 	Finished   = "trainFinished"
 	Failed     = "trainFailed"
 )
+
+// transitions lists the statuses a training may go to from each status. A
+// training always runs before it ends, and a failed one may be run again.
+var transitions = map[string][]string{
+	Default:    {InProgress},
+	InProgress: {Finished, Failed},
+	Failed:     {InProgress},
+}
+
+// CheckTransition returns an error when a training may not go from the status
+// from to the status to. An empty from is taken for Default.
+func CheckTransition(from, to string) error {
+	if from == "" {
+		from = Default
+	}
+	for _, status := range transitions[from] {
+		if status == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("training can not go from %s to %s", from, to)
+}
@@ -0,0 +1,16 @@
+package operation
+
+const (
+	Pending   = "operationPending"
+	Running   = "operationRunning"
+	Succeeded = "operationSucceeded"
+	Failed    = "operationFailed"
+	// Cancelled operations were stopped by CancelOperation.
+	Cancelled = "operationCancelled"
+	// Interrupted operations were pending or running when the model service
+	// stopped, those that can be resumed wait for it.
+	Interrupted = "operationInterrupted"
+	// Stalled operations stopped beating while pending or running, their
+	// worker being wedged or gone.
+	Stalled = "operationStalled"
+)
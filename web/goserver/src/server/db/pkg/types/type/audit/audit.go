@@ -0,0 +1,37 @@
+package audit
+
+// Actions of the audit log, one per audited endpoint.
+const (
+	AssetExportDataset         = "assetExportDataset"
+	AssetImportDataset         = "assetImportDataset"
+	AssetResolveFlaggedImages  = "assetResolveFlaggedImages"
+	ModelArchive               = "modelArchive"
+	ModelBulkUpdate            = "modelBulkUpdate"
+	ModelCancelOperation       = "modelCancelOperation"
+	ModelCleanup               = "modelCleanup"
+	ModelClone                 = "modelClone"
+	ModelCollectSnapshots      = "modelCollectSnapshots"
+	ModelCreateWebhook         = "modelCreateWebhook"
+	ModelDelete                = "modelDelete"
+	ModelDeleteWebhook         = "modelDeleteWebhook"
+	ModelDismissStale          = "modelDismissStale"
+	ModelEvaluate              = "modelEvaluate"
+	ModelImport                = "modelImport"
+	ModelImportDirectory       = "modelImportDirectory"
+	ModelMaterialize           = "modelMaterialize"
+	ModelPruneCache            = "modelPruneCache"
+	ModelMigrateStoragePaths   = "modelMigrateStoragePaths"
+	ModelNormalizePermissions  = "modelNormalizePermissions"
+	ModelReEvaluateStale       = "modelReEvaluateStale"
+	ModelRegenerateMetricsFile = "modelRegenerateMetricsFile"
+	ModelResumeOperation       = "modelResumeOperation"
+	ModelSetTags               = "modelSetTags"
+	ModelTestWebhook           = "modelTestWebhook"
+	ModelTrain                 = "modelTrain"
+	ModelUnarchive             = "modelUnarchive"
+	ModelUpdateDependency      = "modelUpdateDependency"
+	ModelUpdateEvaluateResult  = "modelUpdateEvaluateResult"
+	// PathViolation is a file operation refused for leaving the folders of
+	// the service rather than an endpoint call.
+	PathViolation = "pathViolation"
+)
@@ -0,0 +1,7 @@
+package operation
+
+const (
+	ModelEvaluate = "modelEvaluate"
+	ModelImport   = "modelImport"
+	ModelTrain    = "modelTrain"
+)
@@ -0,0 +1,26 @@
+package webhook
+
+// Events the webhooks receive.
+const (
+	ModelEvaluationFinished = "modelEvaluationFinished"
+	ModelImported           = "modelImported"
+	ModelTrainingFinished   = "modelTrainingFinished"
+	// OperationStalled is sent to the global webhooks for the operations
+	// found stalled.
+	OperationStalled = "operationStalled"
+	// Test is only sent by the test deliveries, whatever the events of the
+	// webhook.
+	Test = "test"
+)
+
+// Events are those a webhook may filter on.
+var Events = []string{ModelEvaluationFinished, ModelImported, ModelTrainingFinished, OperationStalled}
+
+func IsEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,9 @@
 package types
 
 import (
+	"encoding/json"
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -20,6 +23,36 @@ type Asset struct {
 	CvatDataPath string             `bson:"cvatDataPath" json:"cvatDataPath"`
 }
 
+type AnnotationObject struct {
+	Label string    `bson:"label" json:"label"`
+	BBox  []float64 `bson:"bbox" json:"bbox"`
+}
+
+type Annotation struct {
+	Id          primitive.ObjectID `bson:"_id" json:"id"`
+	AssetId     primitive.ObjectID `bson:"assetId" json:"assetId"`
+	ProblemId   primitive.ObjectID `bson:"problemId" json:"problemId"`
+	ImagePath   string             `bson:"imagePath" json:"imagePath"`
+	Width       int                `bson:"width" json:"width"`
+	Height      int                `bson:"height" json:"height"`
+	Objects     []AnnotationObject `bson:"objects" json:"objects"`
+	Sha256      string             `bson:"sha256" json:"sha256"`
+	Status      string             `bson:"status" json:"status"`
+	DuplicateOf string             `bson:"duplicateOf" json:"duplicateOf"`
+}
+
+// AnnotationVersion counts the changes of the annotations of a problem, so
+// that the results computed from them can be told stale in any process.
+type AnnotationVersion struct {
+	ProblemId primitive.ObjectID `bson:"problemId" json:"problemId"`
+	Version   int64              `bson:"version" json:"version"`
+}
+
+type AnnotationFindResponse struct {
+	BaseList
+	Items []Annotation `bson:"items" json:"items"`
+}
+
 type AssetFindResponse struct {
 	BaseList
 	Items []Asset `bson:"items" json:"items"`
@@ -41,6 +74,9 @@ type Build struct {
 	Split     map[string]BuildAssetsSplit `bson:"split" json:"split"`
 	Status    string                      `bson:"status" json:"status"`
 	Folder    string                      `bson:"folder" json:"folder"`
+	// AssetsHash fingerprints the assets of the build and their split, the
+	// evaluations made on another asset set are stale.
+	AssetsHash string `bson:"assetsHash,omitempty" json:"assetsHash,omitempty"`
 }
 
 type BuildFindResponse struct {
@@ -105,14 +141,33 @@ type Scripts struct {
 	Eval  string `bson:"eval" json:"eval"`
 }
 
+// Evaluate is the evaluation of a model on a build. AssetsHash is that of the
+// build when it was evaluated, the evaluation turning Stale when the assets of
+// the build change, unless StaleDismissed. Evaluating again clears both.
 type Evaluate struct {
-	Metrics []Metric `bson:"metrics,omitempty" json:"metrics,omitempty"`
-	Status  string   `bson:"status" json:"status"`
+	Metrics        []Metric  `bson:"metrics,omitempty" json:"metrics,omitempty"`
+	Status         string    `bson:"status" json:"status"`
+	AssetsHash     string    `bson:"assetsHash,omitempty" json:"assetsHash,omitempty"`
+	Stale          bool      `bson:"stale,omitempty" json:"stale,omitempty"`
+	StaleSince     time.Time `bson:"staleSince,omitempty" json:"staleSince,omitempty"`
+	StaleDismissed bool      `bson:"staleDismissed,omitempty" json:"staleDismissed,omitempty"`
 }
 
+// Model is a trained or importable model. The snapshot of an OpenVINO IR is
+// at SnapshotPath, its .xml, and WeightsPath, its .bin. Archived models are
+// hidden from the lists but kept with their files, since ArchivedAt. All the
+// files of a Cas model are hardlinks of the snapshot store. ReadmePath and
+// Previews, the images of its previews folder, are empty for the models
+// imported without them. Models of a Workspace have their folders in that of
+// the workspace, in the problem folder, and names of their own; the models
+// stored before the workspaces have none.
 type Model struct {
+	Archived        bool                `bson:"archived" json:"archived"`
+	ArchivedAt      time.Time           `bson:"archivedAt" json:"archivedAt"`
 	BatchSize       int                 `bson:"batchSize" json:"batchSize"`
+	Cas             bool                `bson:"cas" json:"cas"`
 	ConfigPath      string              `bson:"configPath" json:"configPath"`
+	ContentHash     string              `bson:"contentHash" json:"contentHash"`
 	ProblemId       primitive.ObjectID  `bson:"problemId" json:"problemId"`
 	Description     string              `bson:"description" json:"description" yaml:"description"`
 	Dir             string              `bson:"dir" json:"dir"`
@@ -121,19 +176,29 @@ type Model struct {
 	Evaluates       map[string]Evaluate `bson:"evaluates" json:"evaluates"`
 	Framework       string              `bson:"framework" json:"framework" yaml:"framework"`
 	Id              primitive.ObjectID  `bson:"_id" json:"id"`
+	ImportedBy      string              `bson:"importedBy" json:"importedBy"`
+	License         string              `bson:"license" json:"license,omitempty"`
 	ModulesYamlPath string              `bson:"modulesYamlPath" json:"modulesYamlPath"`
 	Name            string              `bson:"name" json:"name" yaml:"name"`
 	ParentModelId   primitive.ObjectID  `bson:"parentModelId" json:"parentModelId"`
+	Previews        []string            `bson:"previews" json:"previews,omitempty"`
+	ReadmePath      string              `bson:"readmePath" json:"readmePath,omitempty"`
 	Scripts         Scripts             `bson:"scripts" json:"scripts"`
+	SnapshotFormat  string              `bson:"snapshotFormat" json:"snapshotFormat"`
 	SnapshotPath    string              `bson:"snapshotPath" json:"snapshotPath"`
 	Status          string              `bson:"status" json:"status"`
+	Tags            []string            `bson:"tags" json:"tags"`
 	TemplatePath    string              `bson:"templatePath" json:"templatePath"`
 	TrainingGpuNum  int                 `bson:"trainingGpuNum" json:"trainingGpuNum"`
+	WeightsPath     string              `bson:"weightsPath" json:"weightsPath,omitempty"`
+	Workspace       string              `bson:"workspace" json:"workspace,omitempty"`
 }
 
 type ModelWithoutId struct {
 	BatchSize       int                 `bson:"batchSize" json:"batchSize"`
+	Cas             bool                `bson:"cas" json:"cas"`
 	ConfigPath      string              `bson:"configPath" json:"configPath"`
+	ContentHash     string              `bson:"contentHash" json:"contentHash"`
 	ProblemId       primitive.ObjectID  `bson:"problemId" json:"problemId"`
 	Description     string              `bson:"description" json:"description" yaml:"description"`
 	Dir             string              `bson:"dir" json:"dir"`
@@ -141,14 +206,41 @@ type ModelWithoutId struct {
 	Epochs          int                 `bson:"epochs" json:"epochs"`
 	Evaluates       map[string]Evaluate `bson:"evaluates" json:"evaluates"`
 	Framework       string              `bson:"framework" json:"framework" yaml:"framework"`
+	ImportedBy      string              `bson:"importedBy" json:"importedBy"`
+	License         string              `bson:"license" json:"license,omitempty"`
 	ModulesYamlPath string              `bson:"modulesYamlPath" json:"modulesYamlPath"`
 	Name            string              `bson:"name" json:"name" yaml:"name"`
 	ParentModelId   primitive.ObjectID  `bson:"parentModelId" json:"parentModelId"`
+	Previews        []string            `bson:"previews" json:"previews,omitempty"`
+	ReadmePath      string              `bson:"readmePath" json:"readmePath,omitempty"`
 	Scripts         Scripts             `bson:"scripts" json:"scripts"`
+	SnapshotFormat  string              `bson:"snapshotFormat" json:"snapshotFormat"`
 	SnapshotPath    string              `bson:"snapshotPath" json:"snapshotPath"`
 	Status          string              `bson:"status" json:"status"`
+	Tags            []string            `bson:"tags" json:"tags"`
 	TemplatePath    string              `bson:"templatePath" json:"templatePath"`
 	TrainingGpuNum  int                 `bson:"trainingGpuNum" json:"trainingGpuNum"`
+	WeightsPath     string              `bson:"weightsPath" json:"weightsPath,omitempty"`
+	Workspace       string              `bson:"workspace" json:"workspace,omitempty"`
+}
+
+// ModelPatch is the change a bulk update makes to every model it matches,
+// the fields left nil or empty being left alone. SetTags replaces the tags,
+// RemoveTags and then AddTags, which are not added twice, change them.
+// Archived archives the models, keeping the ArchivedAt of those already
+// archived, or unarchives them.
+type ModelPatch struct {
+	SetTags     *[]string `json:"setTags,omitempty"`
+	AddTags     []string  `json:"addTags,omitempty"`
+	RemoveTags  []string  `json:"removeTags,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	License     *string   `json:"license,omitempty"`
+	Archived    *bool     `json:"archived,omitempty"`
+}
+
+// IsEmpty tells whether p changes nothing.
+func (p ModelPatch) IsEmpty() bool {
+	return p.SetTags == nil && len(p.AddTags) == 0 && len(p.RemoveTags) == 0 && p.Description == nil && p.License == nil && p.Archived == nil
 }
 
 type Metric struct {
@@ -158,11 +250,34 @@ type Metric struct {
 	Unit        string `bson:"unit" json:"unit" yaml:"unit"`
 }
 
+// Dependency is an artifact of a model. Stored models keep their sources
+// redacted, SourceHash tells the same sources apart. ETag and LastModified
+// are the cache validators the server of a downloaded source answered with,
+// a reimport sends them back to skip unchanged files. SkipSniff lets its
+// downloads start as HTML pages, which are otherwise taken for error pages.
+// Checksums is the SHA256SUMS file listing the sha256 of a dependency
+// declared without one, in place of that of the template.
+// Sample is taken from the file once in place, for fast verifications.
 type Dependency struct {
-	Sha256      string `yaml:"sha256,omitempty"`
-	Size        int    `yaml:"size,omitempty"`
-	Source      string `yaml:"source"`
-	Destination string `yaml:"destination"`
+	Sha256       string  `bson:"sha256" json:"sha256" yaml:"sha256,omitempty"`
+	Size         int     `bson:"size" json:"size" yaml:"size,omitempty"`
+	Source       string  `bson:"source" json:"source" yaml:"source"`
+	SourceHash   string  `bson:"sourceHash,omitempty" json:"sourceHash,omitempty" yaml:"-"`
+	Destination  string  `bson:"destination" json:"destination" yaml:"destination"`
+	ETag         string  `bson:"etag,omitempty" json:"etag,omitempty" yaml:"-"`
+	LastModified string  `bson:"lastModified,omitempty" json:"lastModified,omitempty" yaml:"-"`
+	SkipSniff    bool    `bson:"skipSniff,omitempty" json:"skipSniff,omitempty" yaml:"skip_sniff,omitempty"`
+	Checksums    string  `bson:"checksums,omitempty" json:"checksums,omitempty" yaml:"checksums,omitempty"`
+	Sample       *Sample `bson:"sample,omitempty" json:"sample,omitempty" yaml:"-"`
+}
+
+// Sample is the sha256 of regions of a file of Size bytes, the BlockSize
+// bytes at every one of Offsets, so that it is taken again the same way.
+type Sample struct {
+	Size      int64   `bson:"size" json:"size"`
+	BlockSize int64   `bson:"blockSize" json:"blockSize"`
+	Offsets   []int64 `bson:"offsets" json:"offsets"`
+	Sha256    string  `bson:"sha256" json:"sha256"`
 }
 
 type ModelFindResponse struct {
@@ -170,32 +285,214 @@ type ModelFindResponse struct {
 	Items []Model `bson:"items" json:"items"`
 }
 
+// Operation tracks a long-running request, so a client that lost the response
+// stream can look up how it went. Result holds the terminal response data.
+type Operation struct {
+	Id        primitive.ObjectID `bson:"_id" json:"id"`
+	Kind      string             `bson:"kind" json:"kind"`
+	Status    string             `bson:"status" json:"status"`
+	Progress  float64            `bson:"progress" json:"progress"`
+	Warnings  []string           `bson:"warnings" json:"warnings"`
+	Result    json.RawMessage    `bson:"result" json:"result,omitempty"`
+	Error     string             `bson:"error" json:"error,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+	// Notifications are those sent when the operation ended, a failed one
+	// leaving the outcome of the operation as it is.
+	Notifications []OperationNotification `bson:"notifications,omitempty" json:"notifications,omitempty"`
+	// Request is the request that started the operation, kept for the
+	// operations that can be resumed after an interruption. It may hold
+	// notification addresses, so the model service does not send it to the
+	// clients.
+	Request json.RawMessage `bson:"request,omitempty" json:"request,omitempty"`
+	// Templates are the templates of a directory import, in the order they
+	// are imported, with how far their import went.
+	Templates []OperationTemplate `bson:"templates,omitempty" json:"templates,omitempty"`
+	Resumed   int                 `bson:"resumed,omitempty" json:"resumed,omitempty"`
+	// StartedBy is the user whose request started the operation, who may
+	// cancel it as the admins may. CancelledBy and CancelledAt tell who
+	// cancelled it and when.
+	StartedBy   string    `bson:"startedBy,omitempty" json:"startedBy,omitempty"`
+	CancelledBy string    `bson:"cancelledBy,omitempty" json:"cancelledBy,omitempty"`
+	CancelledAt time.Time `bson:"cancelledAt,omitempty" json:"cancelledAt,omitempty"`
+	// HeartbeatAt is when Owner, the replica running the operation, last
+	// told it was alive. Stalls are the times it stopped doing so.
+	HeartbeatAt time.Time        `bson:"heartbeatAt,omitempty" json:"heartbeatAt,omitempty"`
+	Owner       string           `bson:"owner,omitempty" json:"owner,omitempty"`
+	Stalls      []OperationStall `bson:"stalls,omitempty" json:"stalls,omitempty"`
+}
+
+// OperationStall is an operation found stalled At, its last heartbeat being
+// HeartbeatAt by Owner. Retried tells it was resumed then.
+type OperationStall struct {
+	At          time.Time `bson:"at" json:"at"`
+	HeartbeatAt time.Time `bson:"heartbeatAt" json:"heartbeatAt"`
+	Owner       string    `bson:"owner,omitempty" json:"owner,omitempty"`
+	Retried     bool      `bson:"retried" json:"retried"`
+}
+
+// OperationTemplate is a template of a directory import, Path being relative
+// to the imported folder and Sha256 that of the template file imported.
+type OperationTemplate struct {
+	Path       string    `bson:"path" json:"path"`
+	Status     string    `bson:"status" json:"status"`
+	Sha256     string    `bson:"sha256,omitempty" json:"sha256,omitempty"`
+	Imported   int       `bson:"imported" json:"imported"`
+	Failed     int       `bson:"failed" json:"failed"`
+	FinishedAt time.Time `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+}
+
+// OperationNotification is a notification of the end of an operation on one
+// channel, Error telling why it was not delivered.
+type OperationNotification struct {
+	Channel string    `bson:"channel" json:"channel"`
+	Target  string    `bson:"target" json:"target"`
+	SentAt  time.Time `bson:"sentAt" json:"sentAt"`
+	Error   string    `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// HyperParameters are the training defaults of a problem, applied to the
+// models whose template leaves them unset.
+type HyperParameters struct {
+	BatchSize        int     `bson:"batchSize" json:"batchSize" yaml:"batch_size"`
+	BaseLearningRate float64 `bson:"baseLearningRate" json:"baseLearningRate" yaml:"base_learning_rate"`
+	Epochs           int     `bson:"epochs" json:"epochs" yaml:"epochs"`
+}
+
 type ProblemWithouId struct {
-	Class       string                   `bson:"class" json:"class"`
-	Description string                   `bson:"description" json:"description"`
-	ImagesUrls  []string                 `bson:"imagesUrls" json:"imagesUrls" yaml:"imagesUrls"`
-	Labels      []map[string]interface{} `bson:"labels" json:"labels"`
-	Dir         string                   `bson:"dir" json:"dir"`
-	Subtitle    string                   `bson:"subtitle" json:"subtitle"`
-	Title       string                   `bson:"title" json:"title"`
-	Type        string                   `bson:"type" json:"type"`
-	WorkingDir  string                   `bson:"workingDir" json:"workingDir"`
-	CvatSchema  string                   `bson:"-" json:"-" yaml:"cvat_schema"`
+	Access                 map[string]string        `bson:"access,omitempty" json:"access,omitempty"`
+	AutoReEvaluate         bool                     `bson:"autoReEvaluate,omitempty" json:"autoReEvaluate,omitempty"`
+	Class                  string                   `bson:"class" json:"class"`
+	DefaultHyperParameters *HyperParameters         `bson:"defaultHyperParameters,omitempty" json:"defaultHyperParameters,omitempty"`
+	Description            string                   `bson:"description" json:"description"`
+	ImagesUrls             []string                 `bson:"imagesUrls" json:"imagesUrls" yaml:"imagesUrls"`
+	Labels                 []map[string]interface{} `bson:"labels" json:"labels"`
+	Dir                    string                   `bson:"dir" json:"dir"`
+	QuotaBytes             int64                    `bson:"quotaBytes,omitempty" json:"quotaBytes"`
+	Subtitle               string                   `bson:"subtitle" json:"subtitle"`
+	Title                  string                   `bson:"title" json:"title"`
+	Type                   string                   `bson:"type" json:"type"`
+	WorkingDir             string                   `bson:"workingDir" json:"workingDir"`
+	CvatSchema             string                   `bson:"-" json:"-" yaml:"cvat_schema"`
 }
 
+// Problem is a task models are trained for. The stale evaluations of the
+// models of an AutoReEvaluate problem are run again in the off-peak window.
 // TODO: delete CvatSchema
 type Problem struct {
-	Class       string                   `bson:"class" json:"class"`
-	Description string                   `bson:"description" json:"description"`
-	Id          primitive.ObjectID       `bson:"_id" json:"id"`
-	ImagesUrls  []string                 `bson:"imagesUrls" json:"imagesUrls" yaml:"imagesUrls"`
-	Labels      []map[string]interface{} `bson:"labels" json:"labels"`
-	Dir         string                   `bson:"dir" json:"dir"`
-	Subtitle    string                   `bson:"subtitle" json:"subtitle"`
-	Title       string                   `bson:"title" json:"title"`
-	Type        string                   `bson:"type" json:"type"`
-	WorkingDir  string                   `bson:"workingDir" json:"workingDir"`
-	CvatSchema  string                   `bson:"-" json:"-" yaml:"cvat_schema"`
+	Access                 map[string]string        `bson:"access" json:"access"`
+	AutoReEvaluate         bool                     `bson:"autoReEvaluate" json:"autoReEvaluate"`
+	Class                  string                   `bson:"class" json:"class"`
+	DefaultHyperParameters HyperParameters          `bson:"defaultHyperParameters" json:"defaultHyperParameters" yaml:"default_hyper_parameters"`
+	Description            string                   `bson:"description" json:"description"`
+	Id                     primitive.ObjectID       `bson:"_id" json:"id"`
+	ImagesUrls             []string                 `bson:"imagesUrls" json:"imagesUrls" yaml:"imagesUrls"`
+	Labels                 []map[string]interface{} `bson:"labels" json:"labels"`
+	Dir                    string                   `bson:"dir" json:"dir"`
+	QuotaBytes             int64                    `bson:"quotaBytes" json:"quotaBytes"`
+	Subtitle               string                   `bson:"subtitle" json:"subtitle"`
+	Title                  string                   `bson:"title" json:"title"`
+	Type                   string                   `bson:"type" json:"type"`
+	WorkingDir             string                   `bson:"workingDir" json:"workingDir"`
+	CvatSchema             string                   `bson:"-" json:"-" yaml:"cvat_schema"`
+}
+
+// AuditEntry records one call of a mutating endpoint. Dropped counts the
+// entries lost to a full buffer right before this one.
+type AuditEntry struct {
+	Id          primitive.ObjectID `bson:"_id" json:"id"`
+	Action      string             `bson:"action" json:"action"`
+	Code        int                `bson:"code" json:"code"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	Dropped     int64              `bson:"dropped" json:"dropped"`
+	DurationMs  int64              `bson:"durationMs" json:"durationMs"`
+	Message     string             `bson:"message" json:"message"`
+	OperationId string             `bson:"operationId" json:"operationId"`
+	ProblemId   primitive.ObjectID `bson:"problemId" json:"problemId"`
+	Request     string             `bson:"request" json:"request"`
+	User        string             `bson:"user" json:"user"`
+}
+
+type AuditFindResponse struct {
+	BaseList
+	Items []AuditEntry `bson:"items" json:"items"`
+}
+
+// Webhook receives the model events of Events, all of them when there are
+// none, as JSON payloads signed with Secret. A webhook of a problem receives
+// the events of its models, a webhook without ProblemId those of every
+// problem.
+type Webhook struct {
+	Id        primitive.ObjectID `bson:"_id" json:"id"`
+	ProblemId primitive.ObjectID `bson:"problemId" json:"problemId"`
+	Url       string             `bson:"url" json:"url"`
+	Secret    string             `bson:"secret" json:"secret"`
+	Events    []string           `bson:"events" json:"events"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	CreatedBy string             `bson:"createdBy" json:"createdBy"`
+}
+
+// WebhookDeadLetter is an event the webhook still refused after the last
+// attempt, Payload being the JSON that was sent.
+type WebhookDeadLetter struct {
+	Id        primitive.ObjectID `bson:"_id" json:"id"`
+	WebhookId primitive.ObjectID `bson:"webhookId" json:"webhookId"`
+	ProblemId primitive.ObjectID `bson:"problemId" json:"problemId"`
+	Event     string             `bson:"event" json:"event"`
+	Url       string             `bson:"url" json:"url"`
+	Payload   string             `bson:"payload" json:"payload"`
+	Attempts  int                `bson:"attempts" json:"attempts"`
+	LastError string             `bson:"lastError" json:"lastError"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type WebhookDeadLetterFindResponse struct {
+	BaseList
+	Items []WebhookDeadLetter `bson:"items" json:"items"`
+}
+
+type ProblemUsage struct {
+	ProblemId  primitive.ObjectID `bson:"problemId" json:"problemId"`
+	UsageBytes int64              `bson:"usageBytes" json:"usageBytes"`
+}
+
+// Lock is the lock of Key, held by Owner until ExpiresAt unless refreshed.
+// Holder tells what the owner is doing, for those waiting for it.
+type Lock struct {
+	Key        string    `bson:"_id" json:"key"`
+	Owner      string    `bson:"owner" json:"owner"`
+	Holder     string    `bson:"holder" json:"holder"`
+	AcquiredAt time.Time `bson:"acquiredAt" json:"acquiredAt"`
+	ExpiresAt  time.Time `bson:"expiresAt" json:"expiresAt"`
+	// Operation is the id of the operation holding the lock, if any.
+	Operation string `bson:"operation,omitempty" json:"operation,omitempty"`
+}
+
+// Snapshot is a snapshot file kept once in the snapshot store, at Blob, for
+// all the model files with its content. UnreferencedAt is when the last of
+// its Refs went, zero while it has some.
+type Snapshot struct {
+	Sha256         string        `bson:"_id" json:"sha256"`
+	Size           int64         `bson:"size" json:"size"`
+	Blob           string        `bson:"blob" json:"blob"`
+	Refs           []SnapshotRef `bson:"refs" json:"refs"`
+	CreatedAt      time.Time     `bson:"createdAt" json:"createdAt"`
+	UnreferencedAt time.Time     `bson:"unreferencedAt" json:"unreferencedAt"`
+}
+
+// SnapshotRef is a file of a model with the content of a snapshot.
+type SnapshotRef struct {
+	ModelId primitive.ObjectID `bson:"modelId" json:"modelId"`
+	Path    string             `bson:"path" json:"path"`
+}
+
+// SnapshotFile is the file of a model at Path, with the content of the
+// snapshot stored at Blob.
+type SnapshotFile struct {
+	Sha256 string `bson:"sha256" json:"sha256"`
+	Size   int64  `bson:"size" json:"size"`
+	Blob   string `bson:"blob" json:"blob"`
+	Path   string `bson:"path" json:"path"`
 }
 
 type ProblemFindResponse struct {
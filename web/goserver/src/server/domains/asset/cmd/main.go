@@ -7,15 +7,26 @@ import (
 
 	n "server/common/names"
 	"server/domains/asset/cmd/service"
+	"server/kit/health"
+	"server/kit/trace"
 )
 
 var amqpAddr = flag.String("amqpAddr", "idlp_rabbitmq:5672", "amqp service address")
 var amqpUser = flag.String("amqpUser", "guest", "amqp service user")
 var amqpPass = flag.String("amqpPass", "guest", "amqp service password")
 var assetRoot = flag.String("assetRoot", "/assets", "Assets root folder")
+var exportRoot = flag.String("exportRoot", "/exports", "Exported datasets root folder")
+var auditBufferSize = flag.Int("auditBufferSize", 1024, "audit entries kept while the database is slow, newer ones are dropped")
+var adminAddr = flag.String("adminAddr", ":2112", "address serving /healthz, /readyz and /metrics, disabled when empty")
+var otlpEndpoint = flag.String("otlpEndpoint", "", "OTLP/HTTP collector receiving the traces, e.g. http://otel-collector:4318, disabled when empty")
+var traceSampleRatio = flag.Float64("traceSampleRatio", 0.1, "share of the traces started here that are recorded")
 
 func main() {
 	flag.Parse()
+	trace.Init("asset", *otlpEndpoint, *traceSampleRatio)
+	health.Set("assetRoot", health.Writable(*assetRoot))
+	health.Set("exportRoot", health.Writable(*exportRoot))
+	go health.ListenAndServe(*adminAddr, health.CheckAmqp)
 	go NeverExit("ASSET")
 	select {}
 
@@ -30,5 +41,5 @@ func NeverExit(serviceName string) {
 			go NeverExit(serviceName) // restart
 		}
 	}()
-	service.Run(n.QAsset, *assetRoot, *amqpAddr, *amqpUser, *amqpPass)
+	service.Run(n.QAsset, *assetRoot, *exportRoot, *amqpAddr, *amqpUser, *amqpPass, *auditBufferSize)
 }
@@ -11,8 +11,20 @@ import (
 	"github.com/streadway/amqp"
 
 	n "server/common/names"
+	auditInsertOne "server/db/pkg/handler/audit/insert_one"
+	typeAudit "server/db/pkg/types/type/audit"
 	"server/domains/asset/pkg/background"
+	"server/domains/asset/pkg/endpoint"
+	datasetStats "server/domains/asset/pkg/handler/dataset_stats"
+	exportDataset "server/domains/asset/pkg/handler/export_dataset"
+	importDataset "server/domains/asset/pkg/handler/import_dataset"
+	listFlaggedImages "server/domains/asset/pkg/handler/list_flagged_images"
+	resolveFlaggedImages "server/domains/asset/pkg/handler/resolve_flagged_images"
+	"server/domains/asset/pkg/service"
+	"server/kit/audit"
 	"server/kit/encode_decode"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/health"
 	kitutils "server/kit/utils"
 )
 
@@ -23,12 +35,13 @@ var (
 	ch               *amqp.Channel
 )
 
-func Run(serviceQueueName, assetRoot, amqpAddr, amqpUser, amqpPass string) {
+func Run(serviceQueueName, assetRoot, exportRoot, amqpAddr, amqpUser, amqpPass string, auditBufferSize int) {
 	amqpUrl := fmt.Sprintf("amqp://%s:%s@%s/", amqpUser, amqpPass, amqpAddr)
 	conn, err := rabbitmq.Dial(amqpUrl)
 	if err != nil {
 		log.Panic(err)
 	}
+	health.Set(health.CheckAmqp, health.Amqp(conn))
 	defer conn.Close()
 	ch, err := conn.Channel()
 	if err != nil {
@@ -52,6 +65,9 @@ func Run(serviceQueueName, assetRoot, amqpAddr, amqpUser, amqpPass string) {
 
 	bkg := background.New(conn, getBackgroundMiddleware())
 	go bkg.UpdateFromDisk(context.TODO(), assetRoot, 2*time.Second)
+	svc := service.New(conn, assetRoot, exportRoot, getServiceMiddleware())
+	auditLog := audit.NewLog(auditInsertOne.Sink(conn), auditBufferSize)
+	eps := endpoint.New(svc, getEndpointMiddleware(auditLog))
 	func() {
 		for msg := range msgs {
 			var req encode_decode.BaseAmqpRequest
@@ -63,6 +79,16 @@ func Run(serviceQueueName, assetRoot, amqpAddr, amqpUser, amqpPass string) {
 			fmt.Println("Request:", req)
 			// Event from UI
 			switch req.Event {
+			case datasetStats.Event:
+				go datasetStats.Handle(eps, conn, msg)
+			case exportDataset.Event:
+				go exportDataset.Handle(eps, conn, msg)
+			case importDataset.Event:
+				go importDataset.Handle(eps, conn, msg)
+			case listFlaggedImages.Event:
+				go listFlaggedImages.Handle(eps, conn, msg)
+			case resolveFlaggedImages.Event:
+				go resolveFlaggedImages.Handle(eps, conn, msg)
 			}
 
 			// Request from another service
@@ -78,3 +104,21 @@ func getBackgroundMiddleware() (mw []background.Middleware) {
 	mw = []background.Middleware{}
 	return
 }
+
+func getServiceMiddleware() (mw []service.Middleware) {
+	mw = []service.Middleware{}
+	return
+}
+
+func getEndpointMiddleware(auditLog *audit.Log) (mw map[string][]kitendpoint.Middleware) {
+	mw = map[string][]kitendpoint.Middleware{}
+	audited := map[string]string{
+		"ExportDataset":        typeAudit.AssetExportDataset,
+		"ImportDataset":        typeAudit.AssetImportDataset,
+		"ResolveFlaggedImages": typeAudit.AssetResolveFlaggedImages,
+	}
+	for name, action := range audited {
+		mw[name] = append(mw[name], audit.Middleware(auditLog, action))
+	}
+	return
+}
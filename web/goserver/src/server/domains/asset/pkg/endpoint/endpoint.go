@@ -0,0 +1,67 @@
+package endpoint
+
+import (
+	"context"
+
+	"server/domains/asset/pkg/service"
+	kitendpoint "server/kit/endpoint"
+)
+
+type Endpoints struct {
+	DatasetStats         kitendpoint.Endpoint
+	ExportDataset        kitendpoint.Endpoint
+	ImportDataset        kitendpoint.Endpoint
+	ListFlaggedImages    kitendpoint.Endpoint
+	ResolveFlaggedImages kitendpoint.Endpoint
+}
+
+func New(s service.AssetService, mdw map[string][]kitendpoint.Middleware) Endpoints {
+	eps := Endpoints{
+		DatasetStats:         MakeDatasetStatsEndpoint(s),
+		ExportDataset:        MakeExportDatasetEndpoint(s),
+		ImportDataset:        MakeImportDatasetEndpoint(s),
+		ListFlaggedImages:    MakeListFlaggedImagesEndpoint(s),
+		ResolveFlaggedImages: MakeResolveFlaggedImagesEndpoint(s),
+	}
+	eps.DatasetStats = kitendpoint.Chain(eps.DatasetStats, mdw["DatasetStats"])
+	eps.ExportDataset = kitendpoint.Chain(eps.ExportDataset, mdw["ExportDataset"])
+	eps.ImportDataset = kitendpoint.Chain(eps.ImportDataset, mdw["ImportDataset"])
+	eps.ListFlaggedImages = kitendpoint.Chain(eps.ListFlaggedImages, mdw["ListFlaggedImages"])
+	eps.ResolveFlaggedImages = kitendpoint.Chain(eps.ResolveFlaggedImages, mdw["ResolveFlaggedImages"])
+	return eps
+}
+
+func MakeDatasetStatsEndpoint(s service.AssetService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		req := request.(service.DatasetStatsRequestData)
+		return s.DatasetStats(ctx, req)
+	}
+}
+
+func MakeExportDatasetEndpoint(s service.AssetService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		req := request.(service.ExportDatasetRequestData)
+		return s.ExportDataset(ctx, req)
+	}
+}
+
+func MakeImportDatasetEndpoint(s service.AssetService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		req := request.(service.ImportDatasetRequestData)
+		return s.ImportDataset(ctx, req)
+	}
+}
+
+func MakeListFlaggedImagesEndpoint(s service.AssetService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		req := request.(service.ListFlaggedImagesRequestData)
+		return s.ListFlaggedImages(ctx, req)
+	}
+}
+
+func MakeResolveFlaggedImagesEndpoint(s service.AssetService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		req := request.(service.ResolveFlaggedImagesRequestData)
+		return s.ResolveFlaggedImages(ctx, req)
+	}
+}
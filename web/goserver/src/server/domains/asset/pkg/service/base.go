@@ -1,24 +1,43 @@
 package service
 
 import (
+	"context"
+	"sync"
+
 	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
+
+	kitendpoint "server/kit/endpoint"
 )
 
 type AssetService interface {
+	DatasetStats(ctx context.Context, req DatasetStatsRequestData) chan kitendpoint.Response
+	ExportDataset(ctx context.Context, req ExportDatasetRequestData) chan kitendpoint.Response
+	ImportDataset(ctx context.Context, req ImportDatasetRequestData) chan kitendpoint.Response
+	ListFlaggedImages(ctx context.Context, req ListFlaggedImagesRequestData) chan kitendpoint.Response
+	ResolveFlaggedImages(ctx context.Context, req ResolveFlaggedImagesRequestData) chan kitendpoint.Response
 }
 
 type basicAssetService struct {
-	Conn *rabbitmq.Connection
+	Conn       *rabbitmq.Connection
+	assetRoot  string
+	exportRoot string
+	stats      *datasetStatsCache
+	// exports serializes the swaps of the exported splits into place.
+	exports sync.Mutex
 }
 
-func NewBasicAssetService(conn *rabbitmq.Connection) AssetService {
+func NewBasicAssetService(conn *rabbitmq.Connection, assetRoot, exportRoot string) AssetService {
 	return &basicAssetService{
 		conn,
+		assetRoot,
+		exportRoot,
+		newDatasetStatsCache(),
+		sync.Mutex{},
 	}
 }
 
-func New(conn *rabbitmq.Connection, middleware []Middleware) AssetService {
-	var svc = NewBasicAssetService(conn)
+func New(conn *rabbitmq.Connection, assetRoot, exportRoot string, middleware []Middleware) AssetService {
+	var svc = NewBasicAssetService(conn, assetRoot, exportRoot)
 	for _, m := range middleware {
 		svc = m(svc)
 	}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+type cocoImage struct {
+	Id       int    `json:"id"`
+	FileName string `json:"file_name"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+type cocoAnnotation struct {
+	Id         int       `json:"id"`
+	ImageId    int       `json:"image_id"`
+	CategoryId int       `json:"category_id"`
+	BBox       []float64 `json:"bbox"`
+	Area       float64   `json:"area"`
+	IsCrowd    int       `json:"iscrowd"`
+}
+
+type cocoCategory struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type cocoDataset struct {
+	Images      []cocoImage      `json:"images"`
+	Annotations []cocoAnnotation `json:"annotations"`
+	Categories  []cocoCategory   `json:"categories"`
+}
+
+func readCoco(path string) (dataset cocoDataset, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return dataset, err
+	}
+	err = json.Unmarshal(b, &dataset)
+	return dataset, err
+}
+
+// cocoToDatasetImages converts a COCO dataset to the format independent list of images.
+func cocoToDatasetImages(dataset cocoDataset) (images []datasetImage, errs []ImportDatasetError) {
+	categories := make(map[int]string)
+	for _, c := range dataset.Categories {
+		categories[c.Id] = c.Name
+	}
+	byImage := make(map[int]int)
+	for _, img := range dataset.Images {
+		byImage[img.Id] = len(images)
+		images = append(images, datasetImage{
+			FileName: img.FileName,
+			Width:    img.Width,
+			Height:   img.Height,
+		})
+	}
+	for _, ann := range dataset.Annotations {
+		i, ok := byImage[ann.ImageId]
+		if !ok {
+			errs = append(errs, ImportDatasetError{Message: "annotation references unknown image id"})
+			continue
+		}
+		label, ok := categories[ann.CategoryId]
+		if !ok {
+			errs = append(errs, ImportDatasetError{File: images[i].FileName, Message: "annotation references unknown category id"})
+			continue
+		}
+		images[i].Objects = append(images[i].Objects, datasetObject{Label: label, BBox: ann.BBox})
+	}
+	return images, errs
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	annotationFind "server/db/pkg/handler/annotation/find"
+	annotationVersion "server/db/pkg/handler/annotation/version"
+	assetFindOne "server/db/pkg/handler/asset/find_one"
+	buildFindOne "server/db/pkg/handler/build/find_one"
+	t "server/db/pkg/types"
+	kitendpoint "server/kit/endpoint"
+)
+
+// Histogram bins edges in pixels, sizes are measured as sqrt(width * height).
+var (
+	bboxSizeBins  = []float64{0, 16, 32, 64, 128, 256, 512}
+	imageSizeBins = []float64{0, 256, 512, 1024, 2048, 4096}
+)
+
+// DatasetStatsRequestData selects the problem annotations, narrowed down to the
+// assets of the build when BuildId is set.
+type DatasetStatsRequestData struct {
+	ProblemId primitive.ObjectID `json:"problemId"`
+	BuildId   primitive.ObjectID `json:"buildId"`
+}
+
+type DatasetClassStats struct {
+	Label     string `json:"label"`
+	Instances int    `json:"instances"`
+	Images    int    `json:"images"`
+}
+
+// HistogramBin counts values in [From, To). To is zero for the last open bin.
+type HistogramBin struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int     `json:"count"`
+}
+
+type DatasetStatsResponseData struct {
+	Images            int                 `json:"images"`
+	AnnotatedImages   int                 `json:"annotatedImages"`
+	UnannotatedImages int                 `json:"unannotatedImages"`
+	Annotations       int                 `json:"annotations"`
+	Classes           []DatasetClassStats `json:"classes"`
+	BBoxSizes         []HistogramBin      `json:"bboxSizes"`
+	ImageSizes        []HistogramBin      `json:"imageSizes"`
+}
+
+func (s *basicAssetService) DatasetStats(ctx context.Context, req DatasetStatsRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		result, err := s.datasetStats(ctx, req)
+		if err != nil {
+			log.Println("domains.asset.pkg.service.dataset_stats.DatasetStats", err)
+			returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 1, Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicAssetService) datasetStats(ctx context.Context, req DatasetStatsRequestData) (result DatasetStatsResponseData, err error) {
+	var assetIds []primitive.ObjectID
+	key := "problem:" + req.ProblemId.Hex()
+	if !req.BuildId.IsZero() {
+		buildResp := <-buildFindOne.Send(ctx, s.Conn, buildFindOne.RequestData{Id: req.BuildId})
+		build := buildResp.Data.(buildFindOne.ResponseData)
+		if build.Id.IsZero() {
+			return result, fmt.Errorf("build %s not found", req.BuildId.Hex())
+		}
+		for _, split := range []string{"train", "val", "test"} {
+			assetIds = append(assetIds, getSplitAssetIds(build.Split, split)...)
+		}
+		assetIds = uniqueObjectIds(assetIds)
+		key = "build:" + assetSetHash(assetIds)
+	}
+	// The version is read before the annotations, so that a change made
+	// meanwhile has the stats computed again next time.
+	versionResp := <-annotationVersion.Send(ctx, s.Conn, annotationVersion.RequestData{ProblemId: req.ProblemId})
+	if versionResp.Err.Code > 0 {
+		return result, errors.New(versionResp.Err.Message)
+	}
+	version := versionResp.Data.(annotationVersion.ResponseData).Version
+	if cached, ok := s.stats.Get(key, version); ok {
+		return cached, nil
+	}
+
+	annotationResp := <-annotationFind.Send(ctx, s.Conn, annotationFind.RequestData{ProblemId: req.ProblemId})
+	byAsset := make(map[primitive.ObjectID][]t.Annotation)
+	for _, a := range annotationResp.Data.(annotationFind.ResponseData).Items {
+		if isUsableAnnotation(a) {
+			byAsset[a.AssetId] = append(byAsset[a.AssetId], a)
+		}
+	}
+	if req.BuildId.IsZero() {
+		for assetId := range byAsset {
+			assetIds = append(assetIds, assetId)
+		}
+	}
+
+	result = computeDatasetStats(byAsset, assetIds, s.countAssetImages(ctx, assetIds))
+	s.stats.Set(key, version, result)
+	return result, nil
+}
+
+func computeDatasetStats(byAsset map[primitive.ObjectID][]t.Annotation, assetIds []primitive.ObjectID, images int) (result DatasetStatsResponseData) {
+	classes := make(map[string]*DatasetClassStats)
+	var bboxSizes, imageSizes []float64
+	for _, assetId := range assetIds {
+		for _, a := range byAsset[assetId] {
+			if len(a.Objects) == 0 {
+				continue
+			}
+			result.AnnotatedImages++
+			imageSizes = append(imageSizes, math.Sqrt(float64(a.Width*a.Height)))
+			seen := make(map[string]bool)
+			for _, o := range a.Objects {
+				c, ok := classes[o.Label]
+				if !ok {
+					c = &DatasetClassStats{Label: o.Label}
+					classes[o.Label] = c
+				}
+				c.Instances++
+				if !seen[o.Label] {
+					seen[o.Label] = true
+					c.Images++
+				}
+				if len(o.BBox) == 4 {
+					bboxSizes = append(bboxSizes, math.Sqrt(o.BBox[2]*o.BBox[3]))
+				}
+				result.Annotations++
+			}
+		}
+	}
+	result.Images = images
+	if result.Images < result.AnnotatedImages {
+		result.Images = result.AnnotatedImages
+	}
+	result.UnannotatedImages = result.Images - result.AnnotatedImages
+	for _, c := range classes {
+		result.Classes = append(result.Classes, *c)
+	}
+	sort.Slice(result.Classes, func(i, j int) bool { return result.Classes[i].Label < result.Classes[j].Label })
+	result.BBoxSizes = histogram(bboxSizes, bboxSizeBins)
+	result.ImageSizes = histogram(imageSizes, imageSizeBins)
+	return result
+}
+
+func (s *basicAssetService) countAssetImages(ctx context.Context, assetIds []primitive.ObjectID) (result int) {
+	for _, assetId := range assetIds {
+		assetResp := <-assetFindOne.Send(ctx, s.Conn, assetFindOne.RequestData{Id: assetId})
+		asset := assetResp.Data.(assetFindOne.ResponseData)
+		if asset.Id.IsZero() {
+			continue
+		}
+		files, err := listImages(s.getAssetDir(asset))
+		if err != nil {
+			log.Println("domains.asset.pkg.service.dataset_stats.countAssetImages.listImages", err)
+		}
+		result += len(files)
+	}
+	return result
+}
+
+func histogram(values, edges []float64) []HistogramBin {
+	result := make([]HistogramBin, len(edges))
+	for i, from := range edges {
+		result[i].From = from
+		if i+1 < len(edges) {
+			result[i].To = edges[i+1]
+		}
+	}
+	for _, v := range values {
+		i := sort.SearchFloat64s(edges, v)
+		if i == len(edges) || edges[i] != v {
+			i--
+		}
+		if i >= 0 {
+			result[i].Count++
+		}
+	}
+	return result
+}
+
+func uniqueObjectIds(ids []primitive.ObjectID) (result []primitive.ObjectID) {
+	seen := make(map[primitive.ObjectID]bool)
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func assetSetHash(ids []primitive.ObjectID) string {
+	hexes := make([]string, 0, len(ids))
+	for _, id := range ids {
+		hexes = append(hexes, id.Hex())
+	}
+	sort.Strings(hexes)
+	h := sha256.New()
+	for _, id := range hexes {
+		h.Write([]byte(id))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,49 @@
+package service
+
+import "sync"
+
+// datasetStatsCache keeps computed statistics until annotations change.
+// Entries of builds are keyed on the hash of the build asset set, so changes
+// of the set produce a new key on their own. Every entry is of a version of
+// the annotations of its problem, which the database counts whatever the
+// process changing them, a class rename of the problem service among them:
+// an entry of an older version is not returned.
+type datasetStatsCache struct {
+	mu    sync.RWMutex
+	items map[string]datasetStatsEntry
+}
+
+type datasetStatsEntry struct {
+	version int64
+	stats   DatasetStatsResponseData
+}
+
+func newDatasetStatsCache() *datasetStatsCache {
+	return &datasetStatsCache{items: make(map[string]datasetStatsEntry)}
+}
+
+func (c *datasetStatsCache) Get(key string, version int64) (DatasetStatsResponseData, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.items[key]
+	if !ok || entry.version != version {
+		return DatasetStatsResponseData{}, false
+	}
+	return entry.stats, true
+}
+
+// Set keeps stats computed from the annotations of version, in place of the
+// entry of another version.
+func (c *datasetStatsCache) Set(key string, version int64, stats DatasetStatsResponseData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = datasetStatsEntry{version: version, stats: stats}
+}
+
+// Invalidate drops the entries, for the changes of the images, which are not
+// counted by the annotation versions.
+func (c *datasetStatsCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[string]datasetStatsEntry)
+}
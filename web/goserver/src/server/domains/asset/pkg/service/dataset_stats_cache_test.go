@@ -0,0 +1,23 @@
+package service
+
+import "testing"
+
+// A class renamed by the problem service bumps the version of the
+// annotations, the stats of the former version are computed again.
+func TestDatasetStatsCacheVersions(test *testing.T) {
+	c := newDatasetStatsCache()
+	c.Set("problem:1", 3, DatasetStatsResponseData{Annotations: 10})
+	if stats, ok := c.Get("problem:1", 3); !ok || stats.Annotations != 10 {
+		test.Errorf("stats %+v, %v of the version cached", stats, ok)
+	}
+	if _, ok := c.Get("problem:1", 4); ok {
+		test.Error("stats of version 3 returned for version 4")
+	}
+	c.Set("problem:1", 4, DatasetStatsResponseData{Annotations: 12})
+	if _, ok := c.Get("problem:1", 3); ok {
+		test.Error("stats of version 3 kept after those of version 4")
+	}
+	if stats, _ := c.Get("problem:1", 4); stats.Annotations != 12 {
+		test.Errorf("stats %+v of version 4", stats)
+	}
+}
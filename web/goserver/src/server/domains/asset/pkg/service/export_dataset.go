@@ -0,0 +1,343 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	fp "path/filepath"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	annotationFind "server/db/pkg/handler/annotation/find"
+	assetFindOne "server/db/pkg/handler/asset/find_one"
+	buildFindOne "server/db/pkg/handler/build/find_one"
+	t "server/db/pkg/types"
+	splitState "server/db/pkg/types/build/split_state"
+	buildStatus "server/db/pkg/types/build/status"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/utils/basic/arrays"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// ExportDatasetRequestData selects the split of a frozen build to export. Split
+// is one of train, val or test. Images without annotations are skipped unless
+// IncludeUnannotated is set, then they are exported as negative samples.
+type ExportDatasetRequestData struct {
+	BuildId            primitive.ObjectID `json:"buildId"`
+	Split              string             `json:"split"`
+	Format             string             `json:"format"`
+	Link               bool               `json:"link"`
+	IncludeUnannotated bool               `json:"includeUnannotated"`
+}
+
+type ExportDatasetProgressData struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// ExportDatasetResponseData tells where the dataset was written. Skipped are
+// the assets and images of the split left out of it.
+type ExportDatasetResponseData struct {
+	Path           string            `json:"path"`
+	AnnotationPath string            `json:"annotationPath"`
+	Images         int               `json:"images"`
+	Annotations    int               `json:"annotations"`
+	Manifest       map[string]string `json:"manifest"`
+	Skipped        []ExportSkipped   `json:"skipped"`
+}
+
+// ExportSkipped is an image left out of an export and why, or a whole asset
+// when Image is empty.
+type ExportSkipped struct {
+	AssetId primitive.ObjectID `json:"assetId"`
+	Image   string             `json:"image,omitempty"`
+	Reason  string             `json:"reason"`
+}
+
+const (
+	skippedFlagged     = "flagged"
+	skippedUnannotated = "unannotated"
+)
+
+func (s *basicAssetService) ExportDataset(ctx context.Context, req ExportDatasetRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		result, err := s.exportDataset(ctx, req, returnChan)
+		if err != nil {
+			log.Println("domains.asset.pkg.service.export_dataset.ExportDataset", err)
+			returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: errCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicAssetService) exportDataset(ctx context.Context, req ExportDatasetRequestData, progress chan kitendpoint.Response) (result ExportDatasetResponseData, err error) {
+	if req.Format != DatasetFormatCoco {
+		return result, invalidRequest(fmt.Errorf("unsupported export format %q", req.Format))
+	}
+	split := strings.ToLower(req.Split)
+	if !arrays.ContainsString([]string{"train", "val", "test"}, split) {
+		return result, invalidRequest(fmt.Errorf("unknown split %q", req.Split))
+	}
+	buildResp := <-buildFindOne.Send(ctx, s.Conn, buildFindOne.RequestData{Id: req.BuildId})
+	build := buildResp.Data.(buildFindOne.ResponseData)
+	if build.Id.IsZero() {
+		return result, fmt.Errorf("build %s not found", req.BuildId.Hex())
+	}
+	problem, err := s.getProblem(ctx, build.ProblemId)
+	if err != nil {
+		return result, err
+	}
+	if err := access.CheckProblem(ctx, problem, role.Viewer); err != nil {
+		return result, err
+	}
+	if build.Status != buildStatus.Ready {
+		return result, errors.New("build is not frozen, only the splits of a created build can be exported")
+	}
+
+	// The split is written next to its previous export and swapped in once
+	// complete, so concurrent exports of the same split never share a folder.
+	result.Path = fp.Join(s.exportRoot, build.Id.Hex(), split)
+	if err := os.MkdirAll(fp.Dir(result.Path), 0777); err != nil {
+		return result, err
+	}
+	outDir, err := ioutil.TempDir(fp.Dir(result.Path), split+".export-")
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(outDir)
+	if err := os.MkdirAll(fp.Join(outDir, "images"), 0777); err != nil {
+		return result, err
+	}
+
+	dataset := cocoDataset{
+		Images:      []cocoImage{},
+		Annotations: []cocoAnnotation{},
+		Categories:  []cocoCategory{},
+	}
+	categories := make(map[string]int)
+	for _, label := range problem.Labels {
+		if name, ok := label["name"].(string); ok {
+			categories[name] = len(categories) + 1
+			dataset.Categories = append(dataset.Categories, cocoCategory{Id: categories[name], Name: name})
+		}
+	}
+
+	result.Manifest = make(map[string]string)
+	result.Skipped = []ExportSkipped{}
+	assetIds := getSplitAssetIds(build.Split, split)
+	for i, assetId := range assetIds {
+		skipped, err := s.exportAsset(ctx, assetId, req, categories, outDir, result.Manifest, &dataset)
+		if err != nil {
+			log.Println("domains.asset.pkg.service.export_dataset.exportAsset", assetId.Hex(), err)
+			skipped = append(skipped, ExportSkipped{AssetId: assetId, Reason: err.Error()})
+		}
+		result.Skipped = append(result.Skipped, skipped...)
+		progress <- kitendpoint.Response{
+			Data:   ExportDatasetProgressData{Done: i + 1, Total: len(assetIds)},
+			Err:    kitendpoint.Error{Code: 0},
+			IsLast: false,
+		}
+	}
+
+	b, err := json.Marshal(dataset)
+	if err != nil {
+		return result, err
+	}
+	if err := uFiles.WriteFileAtomic(fp.Join(outDir, "annotations.json"), b, 0666); err != nil {
+		return result, err
+	}
+	result.Manifest["annotations.json"] = bytesSha256(b)
+	result.Images = len(dataset.Images)
+	result.Annotations = len(dataset.Annotations)
+	if err := writeManifest(fp.Join(outDir, "manifest.sha256"), result.Manifest); err != nil {
+		return result, err
+	}
+	if err := s.replaceExport(outDir, result.Path); err != nil {
+		return result, err
+	}
+	result.AnnotationPath = fp.Join(result.Path, "annotations.json")
+	return result, nil
+}
+
+// replaceExport moves the export written to dir in place of the one at path.
+// The previous export is renamed aside first, as a folder cannot be renamed
+// over one that is not empty.
+func (s *basicAssetService) replaceExport(dir, path string) error {
+	s.exports.Lock()
+	defer s.exports.Unlock()
+	old := dir + ".old"
+	if err := os.Rename(path, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(dir, path); err != nil {
+		if restoreErr := os.Rename(old, path); restoreErr != nil && !os.IsNotExist(restoreErr) {
+			log.Println("domains.asset.pkg.service.export_dataset.replaceExport", restoreErr)
+		}
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// exportAsset appends the images of a single asset to the dataset, placing the
+// files under images/<assetId>, and returns the images it left out.
+func (s *basicAssetService) exportAsset(
+	ctx context.Context,
+	assetId primitive.ObjectID,
+	req ExportDatasetRequestData,
+	categories map[string]int,
+	outDir string,
+	manifest map[string]string,
+	dataset *cocoDataset,
+) (skipped []ExportSkipped, err error) {
+	assetResp := <-assetFindOne.Send(ctx, s.Conn, assetFindOne.RequestData{Id: assetId})
+	asset := assetResp.Data.(assetFindOne.ResponseData)
+	if asset.Id.IsZero() {
+		return nil, errors.New("asset not found")
+	}
+	annotationResp := <-annotationFind.Send(ctx, s.Conn, annotationFind.RequestData{AssetId: assetId})
+	annotations := make(map[string]t.Annotation)
+	flagged := make(map[string]bool)
+	for _, a := range annotationResp.Data.(annotationFind.ResponseData).Items {
+		if !isUsableAnnotation(a) {
+			flagged[a.ImagePath] = true
+			continue
+		}
+		annotations[a.ImagePath] = a
+	}
+
+	assetDir := s.getAssetDir(asset)
+	files, err := listImages(assetDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if flagged[file] {
+			skipped = append(skipped, ExportSkipped{AssetId: assetId, Image: file, Reason: skippedFlagged})
+			continue
+		}
+		annotation, annotated := annotations[file]
+		if (!annotated || len(annotation.Objects) == 0) && !req.IncludeUnannotated {
+			skipped = append(skipped, ExportSkipped{AssetId: assetId, Image: file, Reason: skippedUnannotated})
+			continue
+		}
+		rel := fp.Join("images", assetId.Hex(), file)
+		dst := fp.Join(outDir, rel)
+		if err := placeImage(fp.Join(assetDir, file), dst, req.Link); err != nil {
+			log.Println("domains.asset.pkg.service.export_dataset.placeImage", err)
+			skipped = append(skipped, ExportSkipped{AssetId: assetId, Image: file, Reason: err.Error()})
+			continue
+		}
+		manifest[rel] = fileSha256(dst)
+		imageId := len(dataset.Images) + 1
+		dataset.Images = append(dataset.Images, cocoImage{
+			Id:       imageId,
+			FileName: fp.Join(assetId.Hex(), file),
+			Width:    annotation.Width,
+			Height:   annotation.Height,
+		})
+		for _, o := range annotation.Objects {
+			categoryId, ok := categories[o.Label]
+			if !ok || len(o.BBox) != 4 {
+				continue
+			}
+			dataset.Annotations = append(dataset.Annotations, cocoAnnotation{
+				Id:         len(dataset.Annotations) + 1,
+				ImageId:    imageId,
+				CategoryId: categoryId,
+				BBox:       o.BBox,
+				Area:       o.BBox[2] * o.BBox[3],
+			})
+		}
+	}
+	return skipped, nil
+}
+
+func (s *basicAssetService) getAssetDir(asset t.Asset) string {
+	if asset.CvatDataPath != "" {
+		return asset.CvatDataPath
+	}
+	return fp.Join(s.assetRoot, asset.ParentFolder, asset.Name)
+}
+
+func getSplitAssetIds(buildSplit map[string]t.BuildAssetsSplit, split string) (result []primitive.ObjectID) {
+	for _, child := range buildSplit {
+		if len(child.Children) > 0 {
+			result = append(result, getSplitAssetIds(child.Children, split)...)
+			continue
+		}
+		if (split == "train" && child.Train == splitState.Confirmed) ||
+			(split == "val" && child.Val == splitState.Confirmed) ||
+			(split == "test" && child.Test == splitState.Confirmed) {
+			result = append(result, child.AssetId)
+		}
+	}
+	return result
+}
+
+func listImages(dir string) (result []string, err error) {
+	allowedImageFormats := []string{".jpg", ".jpeg", ".png"}
+	err = fp.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !arrays.ContainsString(allowedImageFormats, strings.ToLower(fp.Ext(path))) {
+			return nil
+		}
+		rel, err := fp.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		result = append(result, rel)
+		return nil
+	})
+	sort.Strings(result)
+	return result, err
+}
+
+func writeManifest(path string, manifest map[string]string) error {
+	keys := make([]string, 0, len(manifest))
+	for k := range manifest {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s  %s\n", manifest[k], k)
+	}
+	return uFiles.WriteFileAtomic(path, []byte(b.String()), 0666)
+}
+
+func fileSha256(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Println("domains.asset.pkg.service.export_dataset.fileSha256.os.Open(path)", err)
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		log.Println("domains.asset.pkg.service.export_dataset.fileSha256.io.Copy(h, f)", err)
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func bytesSha256(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
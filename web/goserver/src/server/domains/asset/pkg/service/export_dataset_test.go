@@ -0,0 +1,62 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"sync"
+	"testing"
+)
+
+// Exports of the same split finishing together each leave a whole export in
+// place, never a mix of both.
+func TestReplaceExportOfTheSameSplit(test *testing.T) {
+	root, err := ioutil.TempDir("", "export_dataset")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	path := fp.Join(root, "train")
+	s := &basicAssetService{}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		dir, err := ioutil.TempDir(root, "train.export-")
+		if err != nil {
+			test.Fatal(err)
+		}
+		for _, name := range []string{"annotations.json", "manifest.sha256"} {
+			if err := ioutil.WriteFile(fp.Join(dir, name), []byte(dir), 0666); err != nil {
+				test.Fatal(err)
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.replaceExport(dir, path)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			test.Error(err)
+		}
+	}
+
+	annotations, err := ioutil.ReadFile(fp.Join(path, "annotations.json"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	manifest, err := ioutil.ReadFile(fp.Join(path, "manifest.sha256"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if string(annotations) != string(manifest) {
+		test.Errorf("annotations of %s, manifest of %s", annotations, manifest)
+	}
+	if left, _ := fp.Glob(fp.Join(root, "train.export-*")); len(left) != 0 {
+		test.Errorf("left behind %q", left)
+	}
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	annotationDelete "server/db/pkg/handler/annotation/delete"
+	annotationFind "server/db/pkg/handler/annotation/find"
+	annotationUpdateUpsert "server/db/pkg/handler/annotation/update_upsert"
+	assetFindOne "server/db/pkg/handler/asset/find_one"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	statusAnnotation "server/db/pkg/types/status/annotation"
+	"server/domains/problem/pkg/access"
+	"server/domains/problem/pkg/quota"
+	kitendpoint "server/kit/endpoint"
+)
+
+type ListFlaggedImagesRequestData struct {
+	ProblemId primitive.ObjectID `json:"problemId"`
+}
+
+type ListFlaggedImagesResponseData struct {
+	Duplicates []t.Annotation `json:"duplicates"`
+	Corrupt    []t.Annotation `json:"corrupt"`
+}
+
+func (s *basicAssetService) ListFlaggedImages(ctx context.Context, req ListFlaggedImagesRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		annotationResp := <-annotationFind.Send(ctx, s.Conn, annotationFind.RequestData{
+			ProblemId: req.ProblemId,
+			Statuses:  []string{statusAnnotation.Duplicate, statusAnnotation.Corrupt},
+		})
+		if annotationResp.Err.Code > 0 {
+			returnChan <- annotationResp
+			return
+		}
+		result := ListFlaggedImagesResponseData{Duplicates: []t.Annotation{}, Corrupt: []t.Annotation{}}
+		for _, a := range annotationResp.Data.(annotationFind.ResponseData).Items {
+			if a.Status == statusAnnotation.Duplicate {
+				result.Duplicates = append(result.Duplicates, a)
+			} else {
+				result.Corrupt = append(result.Corrupt, a)
+			}
+		}
+		returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+type ReuploadImage struct {
+	Id     primitive.ObjectID `json:"id"`
+	Source string             `json:"source"`
+}
+
+// ResolveFlaggedImagesRequestData removes the Delete images with their files
+// and replaces the Reupload images with the files at Source.
+type ResolveFlaggedImagesRequestData struct {
+	Delete   []primitive.ObjectID `json:"delete"`
+	Reupload []ReuploadImage      `json:"reupload"`
+}
+
+type ResolveFlaggedImagesResponseData struct {
+	Deleted    int                  `json:"deleted"`
+	Reuploaded int                  `json:"reuploaded"`
+	Errors     []ImportDatasetError `json:"errors"`
+}
+
+func (s *basicAssetService) ResolveFlaggedImages(ctx context.Context, req ResolveFlaggedImagesRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		if err := s.checkFlaggedImagesAccess(ctx, req); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		result := ResolveFlaggedImagesResponseData{Errors: []ImportDatasetError{}}
+		result.Deleted = s.deleteFlaggedImages(ctx, req.Delete)
+		for _, r := range req.Reupload {
+			if err := s.reuploadImage(ctx, r); err != nil {
+				log.Println("domains.asset.pkg.service.flagged_images.reuploadImage", err)
+				result.Errors = append(result.Errors, ImportDatasetError{File: r.Source, Message: err.Error()})
+				continue
+			}
+			result.Reuploaded++
+		}
+		s.stats.Invalidate()
+		returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// checkFlaggedImagesAccess requires editor access to every problem the
+// images belong to, so that nothing is changed when one of them is denied.
+func (s *basicAssetService) checkFlaggedImagesAccess(ctx context.Context, req ResolveFlaggedImagesRequestData) error {
+	ids := append([]primitive.ObjectID{}, req.Delete...)
+	for _, r := range req.Reupload {
+		ids = append(ids, r.Id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	annotationResp := <-annotationFind.Send(ctx, s.Conn, annotationFind.RequestData{Ids: ids})
+	checked := make(map[primitive.ObjectID]bool)
+	for _, a := range annotationResp.Data.(annotationFind.ResponseData).Items {
+		if checked[a.ProblemId] {
+			continue
+		}
+		if err := access.Check(ctx, s.Conn, a.ProblemId, role.Editor); err != nil {
+			return err
+		}
+		checked[a.ProblemId] = true
+	}
+	return nil
+}
+
+func (s *basicAssetService) deleteFlaggedImages(ctx context.Context, ids []primitive.ObjectID) int {
+	if len(ids) == 0 {
+		return 0
+	}
+	annotationResp := <-annotationFind.Send(ctx, s.Conn, annotationFind.RequestData{Ids: ids})
+	for _, a := range annotationResp.Data.(annotationFind.ResponseData).Items {
+		// Duplicates are never placed, their path belongs to the canonical image.
+		if a.Status != statusAnnotation.Corrupt {
+			continue
+		}
+		asset, err := s.getAsset(ctx, a.AssetId)
+		if err != nil {
+			log.Println("domains.asset.pkg.service.flagged_images.deleteFlaggedImages.getAsset", err)
+			continue
+		}
+		path, err := imagePath(s.getAssetDir(asset), a.ImagePath, false)
+		if err != nil {
+			log.Println("domains.asset.pkg.service.flagged_images.deleteFlaggedImages.imagePath", err)
+			continue
+		}
+		size := fileSize(path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Println("domains.asset.pkg.service.flagged_images.deleteFlaggedImages.os.Remove", err)
+			continue
+		}
+		quota.Report(ctx, s.Conn, a.ProblemId, -size)
+	}
+	deleteResp := <-annotationDelete.Send(ctx, s.Conn, annotationDelete.RequestData{Ids: ids})
+	return int(deleteResp.Data.(annotationDelete.ResponseData).Deleted)
+}
+
+func (s *basicAssetService) reuploadImage(ctx context.Context, req ReuploadImage) error {
+	annotationResp := <-annotationFind.Send(ctx, s.Conn, annotationFind.RequestData{Ids: []primitive.ObjectID{req.Id}})
+	items := annotationResp.Data.(annotationFind.ResponseData).Items
+	if len(items) == 0 {
+		return fmt.Errorf("image %s not found", req.Id.Hex())
+	}
+	a := items[0]
+	if a.Status != statusAnnotation.Corrupt {
+		return errors.New("only corrupt images can be reuploaded")
+	}
+	sha, width, height, err := inspectImage(req.Source)
+	if err != nil {
+		return err
+	}
+	asset, err := s.getAsset(ctx, a.AssetId)
+	if err != nil {
+		return err
+	}
+	dst, err := imagePath(s.getAssetDir(asset), a.ImagePath, false)
+	if err != nil {
+		return err
+	}
+	previous := fileSize(dst)
+	if err := quota.Check(ctx, s.Conn, a.ProblemId, fileSize(req.Source)-previous); err != nil {
+		return err
+	}
+	if err := placeImage(req.Source, dst, false); err != nil {
+		return err
+	}
+	quota.Report(ctx, s.Conn, a.ProblemId, fileSize(dst)-previous)
+	annotationResp = <-annotationUpdateUpsert.Send(ctx, s.Conn, annotationUpdateUpsert.RequestData{
+		AssetId:   a.AssetId,
+		ProblemId: a.ProblemId,
+		ImagePath: a.ImagePath,
+		Width:     width,
+		Height:    height,
+		Objects:   a.Objects,
+		Sha256:    sha,
+		Status:    statusAnnotation.Default,
+	})
+	if annotationResp.Err.Code > 0 {
+		return fmt.Errorf("annotation of image %s not saved: %s", req.Id.Hex(), annotationResp.Err.Message)
+	}
+	return nil
+}
+
+func (s *basicAssetService) getAsset(ctx context.Context, assetId primitive.ObjectID) (t.Asset, error) {
+	assetResp := <-assetFindOne.Send(ctx, s.Conn, assetFindOne.RequestData{Id: assetId})
+	asset := assetResp.Data.(assetFindOne.ResponseData)
+	if asset.Id.IsZero() {
+		return asset, fmt.Errorf("asset %s not found", assetId.Hex())
+	}
+	return asset, nil
+}
@@ -0,0 +1,465 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	fp "path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	annotationFind "server/db/pkg/handler/annotation/find"
+	annotationUpdateUpsert "server/db/pkg/handler/annotation/update_upsert"
+	assetUpdateUpsert "server/db/pkg/handler/asset/update_upsert"
+	problemFindOne "server/db/pkg/handler/problem/find_one"
+	problemUpdateUpsert "server/db/pkg/handler/problem/update_upsert"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	statusAnnotation "server/db/pkg/types/status/annotation"
+	typeAsset "server/db/pkg/types/type/asset"
+	"server/domains/problem/pkg/access"
+	"server/domains/problem/pkg/quota"
+	kitendpoint "server/kit/endpoint"
+	u "server/kit/utils"
+	uFiles "server/kit/utils/basic/files"
+)
+
+const (
+	DatasetFormatCoco = "coco"
+	DatasetFormatVoc  = "voc"
+)
+
+// ImportDatasetRequestData describes a dataset to import. For COCO Path is the
+// annotation json file, for Pascal VOC it is the dataset root folder holding
+// Annotations and JPEGImages.
+type ImportDatasetRequestData struct {
+	ProblemId     primitive.ObjectID `json:"problemId"`
+	Path          string             `json:"path"`
+	Format        string             `json:"format"`
+	ImagesDir     string             `json:"imagesDir"`
+	Link          bool               `json:"link"`
+	ExtendClasses bool               `json:"extendClasses"`
+}
+
+type ImportDatasetError struct {
+	File    string `json:"file"`
+	Message string `json:"message"`
+}
+
+type ImportDatasetResponseData struct {
+	Asset        t.Asset              `json:"asset"`
+	Imported     int                  `json:"imported"`
+	Skipped      int                  `json:"skipped"`
+	Duplicates   int                  `json:"duplicates"`
+	Corrupt      int                  `json:"corrupt"`
+	AddedClasses []string             `json:"addedClasses"`
+	Errors       []ImportDatasetError `json:"errors"`
+}
+
+type datasetObject struct {
+	Label string
+	BBox  []float64
+}
+
+type datasetImage struct {
+	FileName string
+	Width    int
+	Height   int
+	Objects  []datasetObject
+}
+
+func (s *basicAssetService) ImportDataset(ctx context.Context, req ImportDatasetRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		result, err := s.importDataset(ctx, req)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: errCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// requestError is an error of the request itself, not worth retrying as is.
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+func (e *requestError) Unwrap() error { return e.err }
+
+func invalidRequest(err error) error {
+	return &requestError{err}
+}
+
+// errCode maps invalid requests, quota and access rejections to their
+// response codes.
+func errCode(err error) int {
+	var invalid *requestError
+	if errors.As(err, &invalid) {
+		return kitendpoint.ErrCodeInvalidArgument
+	}
+	if code := access.ErrCode(err); code != kitendpoint.ErrCodeUnknown {
+		return code
+	}
+	return quota.ErrCode(err)
+}
+
+func (s *basicAssetService) importDataset(ctx context.Context, req ImportDatasetRequestData) (result ImportDatasetResponseData, err error) {
+	problem, err := s.getProblem(ctx, req.ProblemId)
+	if err != nil {
+		return result, err
+	}
+	if err := access.CheckProblem(ctx, problem, role.Editor); err != nil {
+		return result, err
+	}
+	images, imagesDir, errs, err := readDataset(req)
+	if err != nil {
+		return result, err
+	}
+	result.Errors = append(result.Errors, errs...)
+
+	labels := problemLabelNames(problem)
+	result.AddedClasses = unknownLabels(images, labels)
+	if len(result.AddedClasses) > 0 && req.ExtendClasses {
+		problem, err = s.extendProblemLabels(ctx, problem, result.AddedClasses)
+		if err != nil {
+			return result, err
+		}
+		for _, name := range result.AddedClasses {
+			labels[name] = true
+		}
+	} else {
+		result.AddedClasses = nil
+	}
+
+	if !req.Link {
+		if err := quota.Check(ctx, s.Conn, problem.Id, datasetImagesSize(images, imagesDir)); err != nil {
+			return result, err
+		}
+	}
+
+	datasetName := u.StringToFolderName(datasetBaseName(req))
+	datasetDir := fp.Join(problem.Dir, "_datasets", datasetName)
+	if err := os.MkdirAll(datasetDir, 0777); err != nil {
+		return result, err
+	}
+	result.Asset, err = s.upsertDatasetAsset(ctx, datasetName, datasetDir)
+	if err != nil {
+		return result, err
+	}
+	canonical := s.getImageHashes(ctx, result.Asset.Id)
+	var written int64
+	defer func() {
+		quota.Report(ctx, s.Conn, problem.Id, written)
+	}()
+
+	for _, img := range images {
+		src, err := imagePath(imagesDir, img.FileName, true)
+		if err == nil {
+			_, err = imagePath(datasetDir, img.FileName, false)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, ImportDatasetError{File: img.FileName, Message: err.Error()})
+			result.Skipped++
+			continue
+		}
+		if _, err := os.Stat(src); err != nil {
+			result.Errors = append(result.Errors, ImportDatasetError{File: img.FileName, Message: "missing image"})
+			result.Skipped++
+			continue
+		}
+		status := statusAnnotation.Default
+		duplicateOf := ""
+		sha, width, height, err := inspectImage(src)
+		if err != nil {
+			status = statusAnnotation.Corrupt
+			result.Errors = append(result.Errors, ImportDatasetError{File: img.FileName, Message: "corrupt image: " + err.Error()})
+		} else if path, ok := canonical[sha]; ok && path != img.FileName {
+			status = statusAnnotation.Duplicate
+			duplicateOf = path
+		} else {
+			canonical[sha] = img.FileName
+			if (img.Width > 0 && img.Width != width) || (img.Height > 0 && img.Height != height) {
+				result.Errors = append(result.Errors, ImportDatasetError{
+					File:    img.FileName,
+					Message: fmt.Sprintf("annotated size %dx%d differs from image size %dx%d", img.Width, img.Height, width, height),
+				})
+			}
+			img.Width, img.Height = width, height
+		}
+		objects, objErrs := validateObjects(img, labels)
+		result.Errors = append(result.Errors, objErrs...)
+		if status != statusAnnotation.Duplicate {
+			dst := fp.Join(datasetDir, img.FileName)
+			previous := fileSize(dst)
+			if err := placeImage(src, dst, req.Link); err != nil {
+				result.Errors = append(result.Errors, ImportDatasetError{File: img.FileName, Message: err.Error()})
+				result.Skipped++
+				continue
+			}
+			written += fileSize(dst) - previous
+		}
+		annotationResp := <-annotationUpdateUpsert.Send(ctx, s.Conn, annotationUpdateUpsert.RequestData{
+			AssetId:     result.Asset.Id,
+			ProblemId:   problem.Id,
+			ImagePath:   img.FileName,
+			Width:       img.Width,
+			Height:      img.Height,
+			Objects:     objects,
+			Sha256:      sha,
+			Status:      status,
+			DuplicateOf: duplicateOf,
+		})
+		if annotationResp.Err.Code > 0 {
+			result.Errors = append(result.Errors, ImportDatasetError{File: img.FileName, Message: "annotation not saved: " + annotationResp.Err.Message})
+			result.Skipped++
+			continue
+		}
+		switch status {
+		case statusAnnotation.Default:
+			result.Imported++
+		case statusAnnotation.Corrupt:
+			result.Corrupt++
+		case statusAnnotation.Duplicate:
+			result.Duplicates++
+		}
+	}
+	s.stats.Invalidate()
+	return result, nil
+}
+
+// getImageHashes maps content hashes of the usable images already stored in
+// the asset to their paths, so reimports detect duplicates of earlier runs.
+func (s *basicAssetService) getImageHashes(ctx context.Context, assetId primitive.ObjectID) map[string]string {
+	result := make(map[string]string)
+	annotationResp := <-annotationFind.Send(ctx, s.Conn, annotationFind.RequestData{AssetId: assetId})
+	for _, a := range annotationResp.Data.(annotationFind.ResponseData).Items {
+		if a.Sha256 != "" && isUsableAnnotation(a) {
+			result[a.Sha256] = a.ImagePath
+		}
+	}
+	return result
+}
+
+func readDataset(req ImportDatasetRequestData) (images []datasetImage, imagesDir string, errs []ImportDatasetError, err error) {
+	imagesDir = req.ImagesDir
+	switch req.Format {
+	case DatasetFormatCoco:
+		dataset, err := readCoco(req.Path)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if imagesDir == "" {
+			imagesDir = fp.Dir(req.Path)
+		}
+		images, errs = cocoToDatasetImages(dataset)
+	case DatasetFormatVoc:
+		if imagesDir == "" {
+			imagesDir = fp.Join(req.Path, "JPEGImages")
+		}
+		images, errs = readVoc(fp.Join(req.Path, "Annotations"))
+	default:
+		return nil, "", nil, fmt.Errorf("unsupported dataset format %q", req.Format)
+	}
+	if len(images) == 0 {
+		return nil, "", errs, errors.New("dataset has no images")
+	}
+	return images, imagesDir, errs, nil
+}
+
+func datasetBaseName(req ImportDatasetRequestData) string {
+	if req.Format == DatasetFormatCoco {
+		return fp.Base(fp.Dir(req.Path))
+	}
+	return fp.Base(req.Path)
+}
+
+func validateObjects(img datasetImage, labels map[string]bool) (objects []t.AnnotationObject, errs []ImportDatasetError) {
+	for _, o := range img.Objects {
+		if !labels[o.Label] {
+			errs = append(errs, ImportDatasetError{File: img.FileName, Message: fmt.Sprintf("unknown class %q", o.Label)})
+			continue
+		}
+		if !isValidBBox(o.BBox, img.Width, img.Height) {
+			errs = append(errs, ImportDatasetError{File: img.FileName, Message: fmt.Sprintf("malformed bbox %v", o.BBox)})
+			continue
+		}
+		objects = append(objects, t.AnnotationObject{Label: o.Label, BBox: o.BBox})
+	}
+	return objects, errs
+}
+
+// isValidBBox checks an [x, y, width, height] box. Image size is only checked
+// when known.
+func isValidBBox(bbox []float64, width, height int) bool {
+	if len(bbox) != 4 {
+		return false
+	}
+	x, y, w, h := bbox[0], bbox[1], bbox[2], bbox[3]
+	if x < 0 || y < 0 || w <= 0 || h <= 0 {
+		return false
+	}
+	if width > 0 && x+w > float64(width) {
+		return false
+	}
+	if height > 0 && y+h > float64(height) {
+		return false
+	}
+	return true
+}
+
+// datasetImagesSize sums the sizes of the dataset images found on disk, which
+// is what a copying import adds to the problem directory at most.
+func datasetImagesSize(images []datasetImage, imagesDir string) (size int64) {
+	for _, img := range images {
+		if path, err := imagePath(imagesDir, img.FileName, true); err == nil {
+			size += fileSize(path)
+		}
+	}
+	return size
+}
+
+// imagePath joins name, the path of an image as a dataset or an annotation
+// tells it, to dir. It refuses the names leaving dir, lexically or through
+// the symlinks of their folders, and through the image itself when follow
+// is set, since the names come from untrusted annotation files.
+func imagePath(dir, name string, follow bool) (string, error) {
+	clean := fp.Clean(name)
+	if clean == "." || fp.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(fp.Separator)) {
+		return "", fmt.Errorf("image %q is outside of the dataset folder", name)
+	}
+	path := fp.Join(dir, clean)
+	root, err := fp.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	target := path
+	if !follow {
+		target = fp.Dir(path)
+	}
+	rel, err := fp.Rel(root, resolveExisting(target))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(fp.Separator)) {
+		return "", fmt.Errorf("image %q is outside of the dataset folder", name)
+	}
+	return path, nil
+}
+
+// resolveExisting is path with the symlinks of its longest existing prefix
+// resolved.
+func resolveExisting(path string) string {
+	for prefix := path; ; prefix = fp.Dir(prefix) {
+		if resolved, err := fp.EvalSymlinks(prefix); err == nil {
+			rest, _ := fp.Rel(prefix, path)
+			return fp.Join(resolved, rest)
+		}
+		if prefix == fp.Dir(prefix) {
+			return path
+		}
+	}
+}
+
+// fileSize returns the size of the regular file at path, zero for symlinks
+// and missing files.
+func fileSize(path string) int64 {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+func placeImage(src, dst string, link bool) error {
+	if !link {
+		_, err := uFiles.Copy(src, dst)
+		return err
+	}
+	if err := os.MkdirAll(fp.Dir(dst), 0777); err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	absSrc, err := fp.Abs(src)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(absSrc, dst)
+}
+
+func problemLabelNames(problem t.Problem) map[string]bool {
+	result := make(map[string]bool)
+	for _, label := range problem.Labels {
+		if name, ok := label["name"].(string); ok {
+			result[name] = true
+		}
+	}
+	return result
+}
+
+func unknownLabels(images []datasetImage, labels map[string]bool) (result []string) {
+	seen := make(map[string]bool)
+	for _, img := range images {
+		for _, o := range img.Objects {
+			if !labels[o.Label] && !seen[o.Label] {
+				seen[o.Label] = true
+				result = append(result, o.Label)
+			}
+		}
+	}
+	return result
+}
+
+func (s *basicAssetService) getProblem(ctx context.Context, problemId primitive.ObjectID) (t.Problem, error) {
+	problemResp := <-problemFindOne.Send(ctx, s.Conn, problemFindOne.RequestData{Id: problemId})
+	if problemResp.Err.Code > 0 {
+		return t.Problem{}, errors.New(problemResp.Err.Message)
+	}
+	problem := problemResp.Data.(problemFindOne.ResponseData)
+	if problem.Id.IsZero() {
+		return problem, fmt.Errorf("problem %s not found", problemId.Hex())
+	}
+	return problem, nil
+}
+
+func (s *basicAssetService) extendProblemLabels(ctx context.Context, problem t.Problem, names []string) (t.Problem, error) {
+	labels := problem.Labels
+	for _, name := range names {
+		labels = append(labels, map[string]interface{}{"name": name, "attributes": []interface{}{}})
+	}
+	problemResp := <-problemUpdateUpsert.Send(ctx, s.Conn, problemUpdateUpsert.RequestData{
+		Class:       problem.Class,
+		Description: problem.Description,
+		ImagesUrls:  problem.ImagesUrls,
+		Labels:      labels,
+		Dir:         problem.Dir,
+		Subtitle:    problem.Subtitle,
+		Title:       problem.Title,
+		Type:        problem.Type,
+		WorkingDir:  problem.WorkingDir,
+	})
+	if problemResp.Err.Code > 0 {
+		return problem, fmt.Errorf("classes not added: %s", problemResp.Err.Message)
+	}
+	return problemResp.Data.(problemUpdateUpsert.ResponseData), nil
+}
+
+func (s *basicAssetService) upsertDatasetAsset(ctx context.Context, name, dir string) (t.Asset, error) {
+	assetResp := <-assetUpdateUpsert.Send(ctx, s.Conn, assetUpdateUpsert.RequestData{
+		ParentFolder: ".",
+		Name:         name,
+		Type:         typeAsset.ImageFolder,
+		CvatDataPath: dir,
+	})
+	if assetResp.Err.Code > 0 {
+		log.Println("domains.asset.pkg.service.import_dataset.upsertDatasetAsset", assetResp.Err.Message)
+		return t.Asset{}, fmt.Errorf("dataset asset %q not saved: %s", name, assetResp.Err.Message)
+	}
+	return assetResp.Data.(assetUpdateUpsert.ResponseData), nil
+}
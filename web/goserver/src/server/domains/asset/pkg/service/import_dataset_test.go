@@ -0,0 +1,61 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+)
+
+func TestImagePath(test *testing.T) {
+	root, err := ioutil.TempDir("", "import_dataset")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := fp.Join(root, "images")
+	outside := fp.Join(root, "outside")
+	for _, d := range []string{fp.Join(dir, "sub"), outside} {
+		if err := os.MkdirAll(d, 0777); err != nil {
+			test.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(fp.Join(outside, "secret.jpg"), []byte("x"), 0666); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.Symlink(outside, fp.Join(dir, "escape")); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.Symlink(fp.Join(outside, "secret.jpg"), fp.Join(dir, "link.jpg")); err != nil {
+		test.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		follow bool
+		inside bool
+	}{
+		{"a.jpg", true, true},
+		{"sub/a.jpg", true, true},
+		{"sub/../a.jpg", true, true},
+		{"new/dir/a.jpg", false, true},
+		{"../outside/secret.jpg", true, false},
+		{"../../etc/passwd", false, false},
+		{"/etc/passwd", true, false},
+		{"..", false, false},
+		{"escape/secret.jpg", true, false},
+		{"escape/new.jpg", false, false},
+		{"link.jpg", true, false},
+		// Placing an image replaces the symlink rather than writing through it.
+		{"link.jpg", false, true},
+	}
+	for _, c := range cases {
+		path, err := imagePath(dir, c.name, c.follow)
+		if (err == nil) != c.inside {
+			test.Errorf("imagePath(%q, %v) = %q, %v, inside %v", c.name, c.follow, path, err, c.inside)
+		}
+		if err == nil && path != fp.Join(dir, c.name) {
+			test.Errorf("imagePath(%q) = %q", c.name, path)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+
+	t "server/db/pkg/types"
+	statusAnnotation "server/db/pkg/types/status/annotation"
+)
+
+var jpegEndOfImage = []byte{0xff, 0xd9}
+
+// inspectImage returns the content hash and the dimensions read from the
+// image header. Jpeg files missing the end of image marker are reported as
+// truncated.
+func inspectImage(path string) (sha string, width, height int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	defer f.Close()
+	config, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return fileSha256(path), 0, 0, err
+	}
+	if format == "jpeg" {
+		stat, err := f.Stat()
+		if err != nil {
+			return "", 0, 0, err
+		}
+		tail := make([]byte, len(jpegEndOfImage))
+		if _, err := f.ReadAt(tail, stat.Size()-int64(len(tail))); err != nil && err != io.EOF {
+			return "", 0, 0, err
+		}
+		if !bytes.Equal(tail, jpegEndOfImage) {
+			return fileSha256(path), config.Width, config.Height, errors.New("truncated jpeg")
+		}
+	}
+	return fileSha256(path), config.Width, config.Height, nil
+}
+
+// isUsableAnnotation tells whether the image takes part in exports and
+// statistics. Records created before the status was introduced have none.
+func isUsableAnnotation(a t.Annotation) bool {
+	return a.Status == "" || a.Status == statusAnnotation.Default
+}
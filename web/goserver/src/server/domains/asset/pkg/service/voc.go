@@ -0,0 +1,66 @@
+package service
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"log"
+	fp "path/filepath"
+	"sort"
+)
+
+type vocBndBox struct {
+	XMin float64 `xml:"xmin"`
+	YMin float64 `xml:"ymin"`
+	XMax float64 `xml:"xmax"`
+	YMax float64 `xml:"ymax"`
+}
+
+type vocObject struct {
+	Name   string    `xml:"name"`
+	BndBox vocBndBox `xml:"bndbox"`
+}
+
+type vocAnnotation struct {
+	FileName string `xml:"filename"`
+	Size     struct {
+		Width  int `xml:"width"`
+		Height int `xml:"height"`
+	} `xml:"size"`
+	Objects []vocObject `xml:"object"`
+}
+
+// readVoc reads every xml file in the Pascal VOC Annotations folder.
+func readVoc(annotationsDir string) (images []datasetImage, errs []ImportDatasetError) {
+	paths, err := fp.Glob(fp.Join(annotationsDir, "*.xml"))
+	if err != nil {
+		log.Println("domains.asset.pkg.service.voc.readVoc.fp.Glob", err)
+		return nil, []ImportDatasetError{{Message: err.Error()}}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			errs = append(errs, ImportDatasetError{File: path, Message: err.Error()})
+			continue
+		}
+		var ann vocAnnotation
+		if err := xml.Unmarshal(b, &ann); err != nil {
+			errs = append(errs, ImportDatasetError{File: path, Message: err.Error()})
+			continue
+		}
+		img := datasetImage{
+			FileName: ann.FileName,
+			Width:    ann.Size.Width,
+			Height:   ann.Size.Height,
+		}
+		for _, o := range ann.Objects {
+			b := o.BndBox
+			img.Objects = append(img.Objects, datasetObject{
+				Label: o.Name,
+				BBox:  []float64{b.XMin, b.YMin, b.XMax - b.XMin, b.YMax - b.YMin},
+			})
+		}
+		images = append(images, img)
+	}
+	return images, errs
+}
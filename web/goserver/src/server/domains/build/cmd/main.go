@@ -7,15 +7,23 @@ import (
 
 	n "server/common/names"
 	"server/domains/build/cmd/service"
+	"server/kit/health"
+	"server/kit/trace"
 )
 
 var amqpAddr = flag.String("amqpAddr", "idlp_rabbitmq:5672", "amqp service address")
 var amqpUser = flag.String("amqpUser", "guest", "amqp service user")
 var amqpPass = flag.String("amqpPass", "guest", "amqp service password")
 var problemPath = flag.String("problemPath", "/problem", "problem folder path")
+var adminAddr = flag.String("adminAddr", ":2112", "address serving /healthz, /readyz and /metrics, disabled when empty")
+var otlpEndpoint = flag.String("otlpEndpoint", "", "OTLP/HTTP collector receiving the traces, e.g. http://otel-collector:4318, disabled when empty")
+var traceSampleRatio = flag.Float64("traceSampleRatio", 0.1, "share of the traces started here that are recorded")
 
 func main() {
 	flag.Parse()
+	trace.Init("build", *otlpEndpoint, *traceSampleRatio)
+	health.Set("problemPath", health.Writable(*problemPath))
+	go health.ListenAndServe(*adminAddr, health.CheckAmqp)
 	go NeverExit("BUILD")
 	select {}
 }
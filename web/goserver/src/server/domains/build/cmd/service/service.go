@@ -10,12 +10,14 @@ import (
 	"server/domains/build/pkg/endpoint"
 	"server/domains/build/pkg/handler/create"
 	createEmpty "server/domains/build/pkg/handler/create_empty"
+	generateSplit "server/domains/build/pkg/handler/generate_split"
 	"server/domains/build/pkg/handler/list"
 	updateAssetState "server/domains/build/pkg/handler/update_asset_state"
 	updateTmps "server/domains/build/pkg/handler/update_tmps"
 	"server/domains/build/pkg/service"
 	"server/kit/encode_decode"
 	longendpoint "server/kit/endpoint"
+	"server/kit/health"
 )
 
 func Run(serviceQueueName, amqpAddr, amqpUser, amqpPass, problemPath string) {
@@ -24,6 +26,7 @@ func Run(serviceQueueName, amqpAddr, amqpUser, amqpPass, problemPath string) {
 	if err != nil {
 		log.Panic(err)
 	}
+	health.Set(health.CheckAmqp, health.Amqp(conn))
 	defer conn.Close()
 	ch, err := conn.Channel()
 	if err != nil {
@@ -63,6 +66,8 @@ func Run(serviceQueueName, amqpAddr, amqpUser, amqpPass, problemPath string) {
 			go updateAssetState.Handle(eps, conn, msg)
 		case create.Event:
 			go create.Handle(eps, conn, msg)
+		case generateSplit.Event:
+			go generateSplit.Handle(eps, conn, msg)
 		}
 		switch req.Request {
 		case createEmpty.Request:
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"server/domains/build/pkg/service"
+	"server/domains/problem/pkg/access"
 	kitendpoint "server/kit/endpoint"
 )
 
@@ -11,6 +12,7 @@ type Endpoints struct {
 	List             kitendpoint.Endpoint
 	Create           kitendpoint.Endpoint
 	CreateEmpty      kitendpoint.Endpoint
+	GenerateSplit    kitendpoint.Endpoint
 	UpdateAssetState kitendpoint.Endpoint
 	UpdateTmps       kitendpoint.Endpoint
 }
@@ -20,6 +22,7 @@ func New(s service.BuildService, mdw map[string][]kitendpoint.Middleware) Endpoi
 		List:             MakeListEndpoint(s),
 		Create:           MakeCreateEndpoint(s),
 		CreateEmpty:      MakeCreateEmptyEndpoint(s),
+		GenerateSplit:    MakeGenerateSplitEndpoint(s),
 		UpdateAssetState: MakeUpdateAssetStateEndpoint(s),
 		UpdateTmps:       MakeUpdateTmpsEndpoint(s),
 	}
@@ -42,7 +45,14 @@ func MakeCreateEndpoint(s service.BuildService) kitendpoint.Endpoint {
 		returnChan := make(chan kitendpoint.Response)
 		go func() {
 			defer close(returnChan)
-			s.Create(ctx, req.(service.CreateRequestData))
+			if err := s.Create(ctx, req.(service.CreateRequestData)); err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   nil,
+					Err:    kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
 			returnChan <- kitendpoint.Response{
 				Data:   "OK",
 				Err:    kitendpoint.Error{Code: 0},
@@ -69,12 +79,27 @@ func MakeCreateEmptyEndpoint(s service.BuildService) kitendpoint.Endpoint {
 	}
 }
 
+func MakeGenerateSplitEndpoint(s service.BuildService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		req := request.(service.GenerateSplitRequestData)
+		return s.GenerateSplit(ctx, req)
+	}
+}
+
 func MakeUpdateAssetStateEndpoint(s service.BuildService) kitendpoint.Endpoint {
 	return func(ctx context.Context, req interface{}) chan kitendpoint.Response {
 		returnChan := make(chan kitendpoint.Response)
 		go func() {
 			defer close(returnChan)
-			resp := s.UpdateAssetState(ctx, req.(service.UpdateAssetStateRequestData))
+			resp, err := s.UpdateAssetState(ctx, req.(service.UpdateAssetStateRequestData))
+			if err != nil {
+				returnChan <- kitendpoint.Response{
+					Data:   resp,
+					Err:    kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()},
+					IsLast: true,
+				}
+				return
+			}
 			returnChan <- kitendpoint.Response{
 				Data:   resp,
 				Err:    kitendpoint.Error{Code: 0},
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+
+	buildUpdateOne "server/db/pkg/handler/build/update_one"
+	modelMarkStale "server/db/pkg/handler/model/mark_stale"
+	t "server/db/pkg/types"
+)
+
+// assetsHash fingerprints the assets of the build, with their split and
+// annotation, so that it changes whenever the data a model is evaluated on
+// does.
+func assetsHash(build t.Build) string {
+	var entries []string
+	var walk func(node t.BuildAssetsSplit)
+	walk = func(node t.BuildAssetsSplit) {
+		if len(node.Children) == 0 {
+			if !node.AssetId.IsZero() {
+				entries = append(entries, fmt.Sprintf("%s:%d:%d:%d:%d", node.AssetId.Hex(), node.Train, node.Val, node.Test, node.CvatTaskAnnotationId))
+			}
+			return
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	for _, node := range build.Split {
+		walk(node)
+	}
+	sort.Strings(entries)
+	h := sha256.New()
+	for _, e := range entries {
+		io.WriteString(h, e+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// saveBuild stores the build with its assets hash. When the assets changed,
+// the evaluations made on the build are marked stale. A build hashed for the
+// first time marks nothing, since what its evaluations were made on is not
+// known.
+func (s *basicBuildService) saveBuild(ctx context.Context, build t.Build) (t.Build, error) {
+	previous := build.AssetsHash
+	build.AssetsHash = assetsHash(build)
+	resp := <-buildUpdateOne.Send(ctx, s.Conn, build)
+	if resp.Err.Code > 0 {
+		return build, errors.New(resp.Err.Message)
+	}
+	if previous != "" && previous != build.AssetsHash {
+		markResp := <-modelMarkStale.Send(ctx, s.Conn, modelMarkStale.RequestData{BuildId: build.Id, AssetsHash: build.AssetsHash})
+		if markResp.Err.Code > 0 {
+			log.Println("domains.build.pkg.service.assets_hash.saveBuild.modelMarkStale.Send", markResp.Err.Message)
+		} else if marked := markResp.Data.(modelMarkStale.ResponseData).Marked; marked > 0 {
+			log.Printf("domains.build.pkg.service.assets_hash.saveBuild: %d evaluations on build %s are stale", marked, build.Name)
+		}
+	}
+	return resp.Data.(buildUpdateOne.ResponseData), nil
+}
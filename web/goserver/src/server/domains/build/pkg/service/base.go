@@ -10,10 +10,11 @@ import (
 )
 
 type BuildService interface {
-	Create(ctx context.Context, req CreateRequestData)
+	Create(ctx context.Context, req CreateRequestData) error
 	CreateEmpty(ctx context.Context, req CreateEmptyRequestData) t.Build
+	GenerateSplit(ctx context.Context, req GenerateSplitRequestData) chan kitendpoint.Response
 	List(ctx context.Context, req ListRequestData) chan kitendpoint.Response
-	UpdateAssetState(ctx context.Context, req UpdateAssetStateRequestData) UpdateAssetStateResponseData
+	UpdateAssetState(ctx context.Context, req UpdateAssetStateRequestData) (UpdateAssetStateResponseData, error)
 	UpdateTmps(ctx context.Context, req UpdateTmpsRequestData) UpdateTmpsResponseData
 }
 
@@ -17,6 +17,8 @@ import (
 	problemFindOne "server/db/pkg/handler/problem/find_one"
 	t "server/db/pkg/types"
 	buildStatus "server/db/pkg/types/build/status"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
 	"server/kit/utils/basic/arrays"
 	ufiles "server/kit/utils/basic/files"
 )
@@ -26,8 +28,11 @@ type CreateRequestData struct {
 	Name      string             `bson:"name" json:"name"`
 }
 
-func (s *basicBuildService) Create(_ context.Context, req CreateRequestData) {
+func (s *basicBuildService) Create(ctx context.Context, req CreateRequestData) error {
 	problem := s.getProblem(req.ProblemId)
+	if err := access.CheckProblem(ctx, problem, role.Editor); err != nil {
+		return err
+	}
 	tmpBuild := s.getTmpBuild(problem.Id)
 	buildFolderName := getBuildFolderName(req.Name)
 	buildFolderPath := createBuildFolder(problem.Dir, buildFolderName)
@@ -35,6 +40,7 @@ func (s *basicBuildService) Create(_ context.Context, req CreateRequestData) {
 	annotationIdsList := s.getAnnotationIdsInBuild(tmpBuild)
 	copyAnnotationsFromTmpToBuildFolder(tmpFolderPath, buildFolderPath, annotationIdsList)
 	s.createNewBuild(tmpBuild, req.Name, buildFolderName)
+	return nil
 }
 
 func getBuildFolderName(name string) string {
@@ -48,11 +54,12 @@ func (s *basicBuildService) createNewBuild(tmpBuild t.Build, name, folder string
 		context.TODO(),
 		s.Conn,
 		buildInsertOne.RequestData{
-			ProblemId: tmpBuild.ProblemId,
-			Folder:    folder,
-			Name:      name,
-			Split:     tmpBuild.Split,
-			Status:    buildStatus.Ready,
+			ProblemId:  tmpBuild.ProblemId,
+			Folder:     folder,
+			Name:       name,
+			Split:      tmpBuild.Split,
+			Status:     buildStatus.Ready,
+			AssetsHash: assetsHash(tmpBuild),
 		},
 	)
 }
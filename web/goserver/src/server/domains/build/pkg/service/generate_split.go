@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	annotationFind "server/db/pkg/handler/annotation/find"
+	assetFindOne "server/db/pkg/handler/asset/find_one"
+	buildFindOne "server/db/pkg/handler/build/find_one"
+	t "server/db/pkg/types"
+	splitState "server/db/pkg/types/build/split_state"
+	buildStatus "server/db/pkg/types/build/status"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+)
+
+const GroupByParentFolder = "parentFolder"
+
+type SplitFractions struct {
+	Train float64 `json:"train"`
+	Val   float64 `json:"val"`
+	Test  float64 `json:"test"`
+}
+
+// GenerateSplitRequestData describes the split of the build assets. The same
+// seed and asset set always give the same split. Assets with the same GroupBy
+// key, e.g. frames of one folder, land in the same split.
+type GenerateSplitRequestData struct {
+	BuildId         primitive.ObjectID `json:"buildId"`
+	Fractions       SplitFractions     `json:"fractions"`
+	StratifyByClass bool               `json:"stratifyByClass"`
+	Seed            int64              `json:"seed"`
+	GroupBy         string             `json:"groupBy"`
+}
+
+type splitUnit struct {
+	key      string
+	class    string
+	assetIds []primitive.ObjectID
+}
+
+func (s *basicBuildService) GenerateSplit(ctx context.Context, req GenerateSplitRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		build, err := s.generateSplit(ctx, req)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: build, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicBuildService) generateSplit(ctx context.Context, req GenerateSplitRequestData) (t.Build, error) {
+	f := req.Fractions
+	if f.Train < 0 || f.Val < 0 || f.Test < 0 || math.Abs(f.Train+f.Val+f.Test-1) > 1e-6 {
+		return t.Build{}, errors.New("fractions must be non negative and sum up to 1")
+	}
+	buildFindOneResp := <-buildFindOne.Send(ctx, s.Conn, buildFindOne.RequestData{Id: req.BuildId})
+	build := buildFindOneResp.Data.(buildFindOne.ResponseData)
+	if build.Id.IsZero() {
+		return build, fmt.Errorf("build %s not found", req.BuildId.Hex())
+	}
+	if err := access.Check(ctx, s.Conn, build.ProblemId, role.Editor); err != nil {
+		return build, err
+	}
+	if build.Status != buildStatus.Tmp {
+		return build, errors.New("build is frozen, only the pending build split can be generated")
+	}
+
+	units := s.getSplitUnits(ctx, getBuildAssetIds(build.Split), req)
+	assignment := assignSplitUnits(units, req.Fractions, req.Seed)
+	for name, node := range build.Split {
+		build.Split[name] = fixBuildAssetSplitTreeParents(applySplitAssignment(node, assignment), name)
+	}
+	return s.saveBuild(ctx, build)
+}
+
+func (s *basicBuildService) getSplitUnits(ctx context.Context, assetIds []primitive.ObjectID, req GenerateSplitRequestData) []splitUnit {
+	byKey := make(map[string]*splitUnit)
+	counts := make(map[string]map[string]int)
+	for _, assetId := range assetIds {
+		key := assetId.Hex()
+		if req.GroupBy == GroupByParentFolder {
+			assetFindOneResp := <-assetFindOne.Send(ctx, s.Conn, assetFindOne.RequestData{Id: assetId})
+			key = assetFindOneResp.Data.(assetFindOne.ResponseData).ParentFolder
+		}
+		unit, ok := byKey[key]
+		if !ok {
+			unit = &splitUnit{key: key}
+			byKey[key] = unit
+			counts[key] = make(map[string]int)
+		}
+		unit.assetIds = append(unit.assetIds, assetId)
+		if req.StratifyByClass {
+			annotationFindResp := <-annotationFind.Send(ctx, s.Conn, annotationFind.RequestData{AssetId: assetId})
+			for _, a := range annotationFindResp.Data.(annotationFind.ResponseData).Items {
+				for _, o := range a.Objects {
+					counts[key][o.Label]++
+				}
+			}
+		}
+	}
+	units := make([]splitUnit, 0, len(byKey))
+	for key, unit := range byKey {
+		unit.class = dominantClass(counts[key])
+		units = append(units, *unit)
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].key < units[j].key })
+	return units
+}
+
+func getBuildAssetIds(buildSplit map[string]t.BuildAssetsSplit) (result []primitive.ObjectID) {
+	for _, child := range buildSplit {
+		if len(child.Children) > 0 {
+			result = append(result, getBuildAssetIds(child.Children)...)
+		} else if !child.AssetId.IsZero() {
+			result = append(result, child.AssetId)
+		}
+	}
+	return result
+}
+
+func dominantClass(counts map[string]int) (result string) {
+	best := 0
+	for class, count := range counts {
+		if count > best || (count == best && class < result) {
+			best = count
+			result = class
+		}
+	}
+	return result
+}
+
+// assignSplitUnits shuffles the units of every class with the seed and cuts
+// them by the fractions. Units without stratification share the empty class.
+func assignSplitUnits(units []splitUnit, fractions SplitFractions, seed int64) map[primitive.ObjectID]string {
+	byClass := make(map[string][]splitUnit)
+	var classes []string
+	for _, u := range units {
+		if _, ok := byClass[u.class]; !ok {
+			classes = append(classes, u.class)
+		}
+		byClass[u.class] = append(byClass[u.class], u)
+	}
+	sort.Strings(classes)
+
+	r := rand.New(rand.NewSource(seed))
+	result := make(map[primitive.ObjectID]string)
+	for _, class := range classes {
+		group := byClass[class]
+		r.Shuffle(len(group), func(i, j int) { group[i], group[j] = group[j], group[i] })
+		nTrain := int(math.Round(float64(len(group)) * fractions.Train))
+		nVal := int(math.Round(float64(len(group)) * fractions.Val))
+		if nTrain+nVal > len(group) {
+			nVal = len(group) - nTrain
+		}
+		for i, u := range group {
+			split := "test"
+			if i < nTrain {
+				split = "train"
+			} else if i < nTrain+nVal {
+				split = "val"
+			}
+			for _, assetId := range u.assetIds {
+				result[assetId] = split
+			}
+		}
+	}
+	return result
+}
+
+func applySplitAssignment(node t.BuildAssetsSplit, assignment map[primitive.ObjectID]string) t.BuildAssetsSplit {
+	if len(node.Children) > 0 {
+		for name, child := range node.Children {
+			node.Children[name] = applySplitAssignment(child, assignment)
+		}
+		return node
+	}
+	split, ok := assignment[node.AssetId]
+	if !ok {
+		return node
+	}
+	node.Train, node.Val, node.Test = splitState.Rejected, splitState.Rejected, splitState.Rejected
+	switch split {
+	case "train":
+		node.Train = splitState.Confirmed
+	case "val":
+		node.Val = splitState.Confirmed
+	case "test":
+		node.Test = splitState.Confirmed
+	}
+	return node
+}
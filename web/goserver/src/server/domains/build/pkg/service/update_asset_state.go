@@ -9,11 +9,12 @@ import (
 
 	assetFindOne "server/db/pkg/handler/asset/find_one"
 	buildFindOne "server/db/pkg/handler/build/find_one"
-	buildUpdateOne "server/db/pkg/handler/build/update_one"
 	cvatTaskFindOne "server/db/pkg/handler/cvat_task/find_one"
 	t "server/db/pkg/types"
 	splitState "server/db/pkg/types/build/split_state"
 	buildStatus "server/db/pkg/types/build/status"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
 )
 
 type UpdateAssetStateRequestData struct {
@@ -36,7 +37,7 @@ type UpdateAssetStateResponseData struct {
 	Id    primitive.ObjectID `bson:"_id" json:"id"`
 }
 
-func (s *basicBuildService) UpdateAssetState(ctx context.Context, req UpdateAssetStateRequestData) UpdateAssetStateResponseData {
+func (s *basicBuildService) UpdateAssetState(ctx context.Context, req UpdateAssetStateRequestData) (UpdateAssetStateResponseData, error) {
 	cvatTaskFindOneResp := <-cvatTaskFindOne.Send(
 		ctx,
 		s.Conn,
@@ -45,6 +46,9 @@ func (s *basicBuildService) UpdateAssetState(ctx context.Context, req UpdateAsse
 		},
 	)
 	cvatTask := cvatTaskFindOneResp.Data.(cvatTaskFindOne.ResponseData)
+	if err := access.Check(ctx, s.Conn, cvatTask.ProblemId, role.Editor); err != nil {
+		return UpdateAssetStateResponseData{Id: req.Id}, err
+	}
 
 	assetFindOneResp := <-assetFindOne.Send(
 		ctx,
@@ -75,17 +79,15 @@ func (s *basicBuildService) UpdateAssetState(ctx context.Context, req UpdateAsse
 	}
 	build.Split[path[0]] = fixBuildAssetSplitTreeChildren(build.Split[path[0]], buildSplit, path[1:])
 	build.Split[path[0]] = fixBuildAssetSplitTreeParents(build.Split[path[0]], path[0])
-	<-buildUpdateOne.Send(
-		ctx,
-		s.Conn,
-		build,
-	)
+	if _, err := s.saveBuild(ctx, build); err != nil {
+		log.Println("domains.build.pkg.service.update_asset_state.UpdateAssetState.s.saveBuild", err)
+	}
 	return UpdateAssetStateResponseData{
 		Id:    req.Id,
 		Test:  req.Test,
 		Val:   req.Val,
 		Train: req.Train,
-	}
+	}, nil
 }
 
 func fixBuildAssetSplitTreeChildren(node t.BuildAssetsSplit, split BuildSplit, path []string) t.BuildAssetsSplit {
@@ -2,9 +2,9 @@ package service
 
 import (
 	"context"
+	"log"
 
 	buildFind "server/db/pkg/handler/build/find"
-	buildUpdateOne "server/db/pkg/handler/build/update_one"
 	t "server/db/pkg/types"
 	buildStatus "server/db/pkg/types/build/status"
 )
@@ -27,7 +27,9 @@ func (s *basicBuildService) UpdateTmps(ctx context.Context, req UpdateTmpsReques
 }
 
 func (s *basicBuildService) updateBuild(build t.Build) {
-	<-buildUpdateOne.Send(context.TODO(), s.Conn, build)
+	if _, err := s.saveBuild(context.TODO(), build); err != nil {
+		log.Println("domains.build.pkg.service.update_tmps.updateBuild.s.saveBuild", err)
+	}
 }
 
 func (s *basicBuildService) getTmpBuilds() []t.Build {
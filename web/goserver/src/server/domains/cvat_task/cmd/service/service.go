@@ -16,6 +16,7 @@ import (
 	"server/domains/cvat_task/pkg/service"
 	"server/kit/encode_decode"
 	longendpoint "server/kit/endpoint"
+	"server/kit/health"
 	kitutils "server/kit/utils"
 )
 
@@ -32,6 +33,7 @@ func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass *string) {
 	if err != nil {
 		log.Panic(err)
 	}
+	health.Set(health.CheckAmqp, health.Amqp(conn))
 	defer conn.Close()
 	ch, err := conn.Channel()
 	if err != nil {
@@ -7,29 +7,115 @@ import (
 
 	n "server/common/names"
 	"server/domains/model/cmd/service"
+	modelService "server/domains/model/pkg/service"
+	"server/kit/config"
+	"server/kit/health"
+	"server/kit/storage"
+	"server/kit/trace"
+	u "server/kit/utils"
+	uFiles "server/kit/utils/basic/files"
 )
 
-var amqpAddr = flag.String("amqpAddr", "idlp_rabbitmq:5672", "amqp service address")
-var amqpUser = flag.String("amqpUser", "guest", "amqp service user")
-var amqpPass = flag.String("amqpPass", "guest", "amqp service password")
-var trainingPath = flag.String("trainingPath", "/training", "training folder path")
-var problemPath = flag.String("problemPath", "/problem", "problem folder path")
+var configPath = flag.String("config", "", "yaml config file, overrides the flag defaults and is overridden by the environment and the flags set")
+
+func init() {
+	flag.String("amqpAddr", "idlp_rabbitmq:5672", "amqp service address")
+	flag.String("amqpUser", "guest", "amqp service user")
+	flag.String("amqpPass", "guest", "amqp service password")
+	flag.String("trainingPath", "/training", "training folder path")
+	flag.String("problemPath", "/problem", "problem folder path")
+	flag.String("storageUrl", "", "where the model folders are kept, s3://bucket/prefix for an S3 bucket with a working copy in problemPath, problemPath itself when empty")
+	flag.String("storageS3Endpoint", "", "url of the S3 endpoint of storageUrl, e.g. https://s3.eu-west-1.amazonaws.com")
+	flag.String("storageS3Region", "us-east-1", "region the S3 requests are signed for")
+	flag.String("storageS3AccessKey", "", "S3 access key, better set with MODEL_STORAGE_S3_ACCESS_KEY")
+	flag.String("storageS3SecretKey", "", "S3 secret key, better set with MODEL_STORAGE_S3_SECRET_KEY")
+	flag.Int("storageTimeoutSeconds", 300, "seconds an S3 request has to complete")
+	flag.Int("importLimit", 3, "maximum number of simultaneous model imports, reloaded on SIGHUP")
+	flag.Int("importQueueSize", 10, "maximum number of imports waiting for a free slot, reloaded on SIGHUP")
+	flag.Int("importInteractiveWeight", 4, "share of the free import slots going to the interactive imports while batch ones wait too, reloaded on SIGHUP")
+	flag.Int("importBatchWeight", 1, "share of the free import slots going to the batch imports, those of directories, while interactive ones wait too, reloaded on SIGHUP")
+	flag.Int("auditBufferSize", 1024, "audit entries kept while the database is slow, newer ones are dropped")
+	flag.String("importOptions", "", "default import options as json, e.g. {\"concurrency\":4,\"allowedHosts\":[\"example.com\"]}, reloaded on SIGHUP")
+	flag.String("importPostCommands", "", "comma separated programs the post_import commands of the templates may run, none when empty, reloaded on SIGHUP")
+	flag.Int("importPostTimeoutSeconds", 300, "seconds a post_import command has to complete, reloaded on SIGHUP")
+	flag.String("adminAddr", ":2112", "address serving /healthz, /readyz and /metrics, disabled when empty")
+	flag.String("templateRoots", "/ote", "comma separated folders models may be imported from")
+	flag.String("templateBase", "", "folder the relative template paths of the requests are taken from, the first of templateRoots when empty")
+	flag.Int("copyBufferSize", 1<<20, "buffer size in bytes of the file copies")
+	flag.Bool("copyReaderFrom", false, "copy regular files with sendfile or copy_file_range where available instead of the buffer")
+	flag.Int("maxOpenFiles", uFiles.DefaultMaxOpenFiles, "files the copies and the downloads may hold open at once, the others waiting, keep it below the descriptor limit")
+	flag.String("downloadCredentials", "", "per-host download credentials as json, e.g. {\"registry.example.com\":{\"header\":\"PRIVATE-TOKEN\",\"value\":\"<token>\"}}, better set with MODEL_DOWNLOAD_CREDENTIALS")
+	flag.String("downloadS3Endpoint", "", "url of the S3 endpoint the s3:// dependencies are fetched from, that of AWS in downloadS3Region when empty")
+	flag.String("downloadS3Region", "us-east-1", "region of the s3:// dependencies, better set with AWS_REGION")
+	flag.String("downloadS3AccessKey", "", "access key of the s3:// dependencies, which are disabled without one, better set with AWS_ACCESS_KEY_ID")
+	flag.String("downloadS3SecretKey", "", "secret key of the s3:// dependencies, better set with AWS_SECRET_ACCESS_KEY")
+	flag.String("downloadGsAccessKey", "", "HMAC access key of the gs:// dependencies, which are disabled without one, better set with MODEL_DOWNLOAD_GS_ACCESS_KEY")
+	flag.String("downloadGsSecretKey", "", "HMAC secret of the gs:// dependencies, better set with MODEL_DOWNLOAD_GS_SECRET_KEY")
+	flag.Int("statusWindowMillis", 2000, "milliseconds the status updates of a model are gathered before being saved, terminal ones are saved at once")
+	flag.Int("cleanupIntervalMinutes", 60, "minutes between two janitor runs, disabled when 0")
+	flag.Int("cleanupGraceHours", 24, "hours a file or folder stays untouched before the janitor takes it")
+	flag.Bool("cleanupRemove", false, "let the janitor remove what it finds instead of only logging it")
+	flag.Int("snapshotStoreMaxMegabytes", 0, "megabytes of snapshots beyond which the unreferenced ones are collected before their grace period, no budget when 0")
+	flag.Bool("resumeImports", false, "resume at start the directory imports a restart interrupted instead of waiting for MODEL_RESUME_OPERATION")
+	flag.Int("operationStallMinutes", 5, "minutes without a heartbeat after which a pending or running operation is stalled and its locks released, never when 0")
+	flag.Int("operationStallRetries", 0, "times a stalled directory import is resumed before it is left stalled")
+	flag.String("reEvaluateWindow", "", "daily HH:MM-HH:MM local time window the stale evaluations of the problems asking for it are run again in, e.g. 22:00-06:00, never when empty")
+	flag.Int("reEvaluateIntervalMinutes", 15, "minutes between two looks for stale evaluations within the re-evaluation window")
+	flag.String("exportRoot", "", "exported datasets root folder swept by the janitor, not swept when empty")
+	flag.Int("exportRetentionHours", 24*7, "hours exports are kept, forever when 0")
+	flag.Int("webhookAttempts", 5, "attempts to deliver an event to a webhook before it is kept as a dead letter")
+	flag.Int("webhookBackoffSeconds", 10, "seconds before the second attempt of a webhook delivery, doubled for each next one")
+	flag.Int("webhookTimeoutSeconds", 10, "seconds a webhook has to answer a delivery")
+	flag.Int("webhookBufferSize", 1024, "events kept while the webhooks are slow, newer ones are dropped")
+	flag.Int("webhookWorkers", 4, "number of webhook deliveries made at the same time")
+	flag.String("webhookAllowedHosts", "", "comma separated hosts webhooks may point to, any when empty")
+	flag.String("webhookPrivateHosts", "", "comma separated hosts webhooks may point to though they resolve to loopback, link-local or private addresses")
+	flag.String("notifySmtpAddr", "", "host:port of the smtp relay sending the operation notifications, no email when empty")
+	flag.String("notifySmtpUser", "", "smtp relay user, no authentication when empty")
+	flag.String("notifySmtpPass", "", "smtp relay password, better set with MODEL_NOTIFY_SMTP_PASS")
+	flag.String("notifyFrom", "", "sender address of the notification emails")
+	flag.Int("notifyTimeoutSeconds", 30, "seconds the smtp relay and the slack webhooks have to take a notification")
+	flag.String("notifySlackHosts", "", "comma separated hosts slack webhooks may point to, hooks.slack.com when empty")
+	flag.String("notifyBaseUrl", "", "url of the web application the notifications link the operations to, no link when empty")
+	flag.String("notifyUsers", "", "per-user notifications as json, e.g. {\"alice\":{\"on\":[\"failure\"],\"email\":\"alice@example.com\"}}, better set with MODEL_NOTIFY_USERS")
+	flag.String("otlpEndpoint", "", "OTLP/HTTP collector receiving the traces, e.g. http://otel-collector:4318, disabled when empty")
+	flag.Float64("traceSampleRatio", 0.1, "share of the traces started here that are recorded")
+}
 
 func main() {
 	flag.Parse()
-	go NeverExit("MODEL")
+	cfg, err := service.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Print("MODEL", cfg)
+	trace.Init("model", cfg.OtlpEndpoint, cfg.TraceSampleRatio)
+	uFiles.SetCopyOptions(cfg.CopyOptions())
+	uFiles.SetMaxOpenFiles(cfg.MaxOpenFiles)
+	if err := u.SetCredentials(cfg.DownloadCredentials); err != nil {
+		log.Fatal(err)
+	}
+	if err := storage.SetObjectSources(cfg.ObjectSettings()); err != nil {
+		log.Fatal(err)
+	}
+	imports := modelService.NewImportSettings(cfg.ImportLimit, cfg.ImportQueueSize, cfg.ImportWeights(), cfg.ImportOptions, cfg.PostImportHooks())
+	service.ReloadOnSignal(*configPath, cfg, imports)
+	health.Set("problemPath", health.Writable(cfg.ProblemPath))
+	health.Set("trainingPath", health.Writable(cfg.TrainingPath))
+	go health.ListenAndServe(cfg.AdminAddr, health.CheckAmqp)
+	go NeverExit("MODEL", cfg, imports)
 	select {}
 
 }
 
-func NeverExit(serviceName string) {
+func NeverExit(serviceName string, cfg service.Config, imports *modelService.ImportSettings) {
 	defer func() {
 		if v := recover(); v != nil {
 			// A panic is detected.
 			time.Sleep(5 * time.Second)
 			log.Println(serviceName, "is crashed. Restart it now.")
-			go NeverExit(serviceName) // restart
+			go NeverExit(serviceName, cfg, imports) // restart
 		}
 	}()
-	service.Run(n.QModel, amqpAddr, amqpUser, amqpPass, trainingPath, problemPath)
+	service.Run(n.QModel, cfg, imports)
 }
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"flag"
+	"log"
+	"reflect"
+	"time"
+
+	"server/domains/model/pkg/service"
+	"server/kit/audit"
+	"server/kit/config"
+	"server/kit/notify"
+	"server/kit/storage"
+	u "server/kit/utils"
+	uFiles "server/kit/utils/basic/files"
+	"server/kit/webhook"
+)
+
+// Config is the configuration of the model service. The yaml names are those
+// of the flags, the env tags name the variables overriding the config file.
+type Config struct {
+	AmqpAddr                  string                         `yaml:"amqpAddr" env:"AMQP_ADDR" validate:"required"`
+	AmqpUser                  string                         `yaml:"amqpUser" env:"AMQP_USER" validate:"required"`
+	AmqpPass                  string                         `yaml:"amqpPass" env:"AMQP_PASS" secret:"true"`
+	TrainingPath              string                         `yaml:"trainingPath" env:"MODEL_TRAINING_PATH" validate:"dir"`
+	ProblemPath               string                         `yaml:"problemPath" env:"MODEL_PROBLEM_PATH" validate:"dir"`
+	StorageUrl                string                         `yaml:"storageUrl" env:"MODEL_STORAGE_URL"`
+	StorageS3Endpoint         string                         `yaml:"storageS3Endpoint" env:"MODEL_STORAGE_S3_ENDPOINT" validate:"url"`
+	StorageS3Region           string                         `yaml:"storageS3Region" env:"MODEL_STORAGE_S3_REGION"`
+	StorageS3AccessKey        string                         `yaml:"storageS3AccessKey" env:"MODEL_STORAGE_S3_ACCESS_KEY" secret:"true"`
+	StorageS3SecretKey        string                         `yaml:"storageS3SecretKey" env:"MODEL_STORAGE_S3_SECRET_KEY" secret:"true"`
+	StorageTimeoutSeconds     int                            `yaml:"storageTimeoutSeconds" env:"MODEL_STORAGE_TIMEOUT_SECONDS" validate:"min=1"`
+	AdminAddr                 string                         `yaml:"adminAddr" env:"MODEL_ADMIN_ADDR"`
+	AuditBufferSize           int                            `yaml:"auditBufferSize" env:"MODEL_AUDIT_BUFFER_SIZE" validate:"min=1"`
+	ImportLimit               int                            `yaml:"importLimit" env:"MODEL_IMPORT_LIMIT" validate:"min=1"`
+	ImportQueueSize           int                            `yaml:"importQueueSize" env:"MODEL_IMPORT_QUEUE_SIZE" validate:"min=0"`
+	ImportInteractiveWeight   int                            `yaml:"importInteractiveWeight" env:"MODEL_IMPORT_INTERACTIVE_WEIGHT" validate:"min=1"`
+	ImportBatchWeight         int                            `yaml:"importBatchWeight" env:"MODEL_IMPORT_BATCH_WEIGHT" validate:"min=1"`
+	ImportOptions             service.ImportOptions          `yaml:"importOptions" env:"MODEL_IMPORT_OPTIONS"`
+	ImportPostCommands        []string                       `yaml:"importPostCommands" env:"MODEL_IMPORT_POST_COMMANDS"`
+	ImportPostTimeoutSeconds  int                            `yaml:"importPostTimeoutSeconds" env:"MODEL_IMPORT_POST_TIMEOUT_SECONDS" validate:"min=1"`
+	TemplateRoots             []string                       `yaml:"templateRoots" env:"MODEL_TEMPLATE_ROOTS" validate:"required"`
+	TemplateBase              string                         `yaml:"templateBase" env:"MODEL_TEMPLATE_BASE"`
+	CopyBufferSize            int                            `yaml:"copyBufferSize" env:"MODEL_COPY_BUFFER_SIZE" validate:"min=0"`
+	CopyReaderFrom            bool                           `yaml:"copyReaderFrom" env:"MODEL_COPY_READER_FROM"`
+	MaxOpenFiles              int                            `yaml:"maxOpenFiles" env:"MODEL_MAX_OPEN_FILES" validate:"min=0"`
+	DownloadCredentials       u.Credentials                  `yaml:"downloadCredentials" env:"MODEL_DOWNLOAD_CREDENTIALS" secret:"true"`
+	DownloadS3Endpoint        string                         `yaml:"downloadS3Endpoint" env:"MODEL_DOWNLOAD_S3_ENDPOINT" validate:"url"`
+	DownloadS3Region          string                         `yaml:"downloadS3Region" env:"AWS_REGION"`
+	DownloadS3AccessKey       string                         `yaml:"downloadS3AccessKey" env:"AWS_ACCESS_KEY_ID" secret:"true"`
+	DownloadS3SecretKey       string                         `yaml:"downloadS3SecretKey" env:"AWS_SECRET_ACCESS_KEY" secret:"true"`
+	DownloadGsAccessKey       string                         `yaml:"downloadGsAccessKey" env:"MODEL_DOWNLOAD_GS_ACCESS_KEY" secret:"true"`
+	DownloadGsSecretKey       string                         `yaml:"downloadGsSecretKey" env:"MODEL_DOWNLOAD_GS_SECRET_KEY" secret:"true"`
+	StatusWindowMillis        int                            `yaml:"statusWindowMillis" env:"MODEL_STATUS_WINDOW_MILLIS" validate:"min=0"`
+	CleanupIntervalMinutes    int                            `yaml:"cleanupIntervalMinutes" env:"MODEL_CLEANUP_INTERVAL_MINUTES" validate:"min=0"`
+	CleanupGraceHours         int                            `yaml:"cleanupGraceHours" env:"MODEL_CLEANUP_GRACE_HOURS" validate:"min=1"`
+	CleanupRemove             bool                           `yaml:"cleanupRemove" env:"MODEL_CLEANUP_REMOVE"`
+	SnapshotStoreMaxMegabytes int                            `yaml:"snapshotStoreMaxMegabytes" env:"MODEL_SNAPSHOT_STORE_MAX_MEGABYTES" validate:"min=0"`
+	ResumeImports             bool                           `yaml:"resumeImports" env:"MODEL_RESUME_IMPORTS"`
+	OperationStallMinutes     int                            `yaml:"operationStallMinutes" env:"MODEL_OPERATION_STALL_MINUTES" validate:"min=0"`
+	OperationStallRetries     int                            `yaml:"operationStallRetries" env:"MODEL_OPERATION_STALL_RETRIES" validate:"min=0"`
+	ReEvaluateWindow          service.OffPeakWindow          `yaml:"reEvaluateWindow" env:"MODEL_RE_EVALUATE_WINDOW"`
+	ReEvaluateIntervalMinutes int                            `yaml:"reEvaluateIntervalMinutes" env:"MODEL_RE_EVALUATE_INTERVAL_MINUTES" validate:"min=1"`
+	ExportRoot                string                         `yaml:"exportRoot" env:"MODEL_EXPORT_ROOT"`
+	ExportRetentionHours      int                            `yaml:"exportRetentionHours" env:"MODEL_EXPORT_RETENTION_HOURS" validate:"min=0"`
+	WebhookAttempts           int                            `yaml:"webhookAttempts" env:"MODEL_WEBHOOK_ATTEMPTS" validate:"min=1"`
+	WebhookBackoffSeconds     int                            `yaml:"webhookBackoffSeconds" env:"MODEL_WEBHOOK_BACKOFF_SECONDS" validate:"min=0"`
+	WebhookTimeoutSeconds     int                            `yaml:"webhookTimeoutSeconds" env:"MODEL_WEBHOOK_TIMEOUT_SECONDS" validate:"min=1"`
+	WebhookBufferSize         int                            `yaml:"webhookBufferSize" env:"MODEL_WEBHOOK_BUFFER_SIZE" validate:"min=1"`
+	WebhookWorkers            int                            `yaml:"webhookWorkers" env:"MODEL_WEBHOOK_WORKERS" validate:"min=1"`
+	WebhookAllowedHosts       []string                       `yaml:"webhookAllowedHosts" env:"MODEL_WEBHOOK_ALLOWED_HOSTS"`
+	WebhookPrivateHosts       []string                       `yaml:"webhookPrivateHosts" env:"MODEL_WEBHOOK_PRIVATE_HOSTS"`
+	NotifySmtpAddr            string                         `yaml:"notifySmtpAddr" env:"MODEL_NOTIFY_SMTP_ADDR"`
+	NotifySmtpUser            string                         `yaml:"notifySmtpUser" env:"MODEL_NOTIFY_SMTP_USER"`
+	NotifySmtpPass            string                         `yaml:"notifySmtpPass" env:"MODEL_NOTIFY_SMTP_PASS" secret:"true"`
+	NotifyFrom                string                         `yaml:"notifyFrom" env:"MODEL_NOTIFY_FROM"`
+	NotifyTimeoutSeconds      int                            `yaml:"notifyTimeoutSeconds" env:"MODEL_NOTIFY_TIMEOUT_SECONDS" validate:"min=1"`
+	NotifySlackHosts          []string                       `yaml:"notifySlackHosts" env:"MODEL_NOTIFY_SLACK_HOSTS"`
+	NotifyBaseUrl             string                         `yaml:"notifyBaseUrl" env:"MODEL_NOTIFY_BASE_URL" validate:"url"`
+	NotifyUsers               map[string]notify.Subscription `yaml:"notifyUsers" env:"MODEL_NOTIFY_USERS" secret:"true"`
+	OtlpEndpoint              string                         `yaml:"otlpEndpoint" env:"OTLP_ENDPOINT" validate:"url"`
+	TraceSampleRatio          float64                        `yaml:"traceSampleRatio" env:"TRACE_SAMPLE_RATIO" validate:"min=0"`
+}
+
+// CleanupSettings tells the janitor and the Cleanup requests what to take.
+func (c Config) CleanupSettings() service.CleanupSettings {
+	return service.CleanupSettings{
+		GracePeriod:      time.Duration(c.CleanupGraceHours) * time.Hour,
+		ExportRoot:       c.ExportRoot,
+		ExportRetention:  time.Duration(c.ExportRetentionHours) * time.Hour,
+		SnapshotMaxBytes: int64(c.SnapshotStoreMaxMegabytes) << 20,
+	}
+}
+
+// StorageSettings tells where the model folders are kept, the problem
+// folder holding their working copy.
+func (c Config) StorageSettings() storage.Settings {
+	return storage.Settings{
+		Url:       c.StorageUrl,
+		Root:      c.ProblemPath,
+		Endpoint:  c.StorageS3Endpoint,
+		Region:    c.StorageS3Region,
+		AccessKey: c.StorageS3AccessKey,
+		SecretKey: c.StorageS3SecretKey,
+		Timeout:   time.Duration(c.StorageTimeoutSeconds) * time.Second,
+	}
+}
+
+// ObjectSettings tells how the s3:// and gs:// dependencies are fetched, the
+// S3 credentials being those of the AWS tools when set in the environment.
+// A scheme without credentials is disabled.
+func (c Config) ObjectSettings() storage.ObjectSettings {
+	return storage.ObjectSettings{
+		S3: storage.S3Settings{
+			Endpoint:  c.DownloadS3Endpoint,
+			Region:    c.DownloadS3Region,
+			AccessKey: c.DownloadS3AccessKey,
+			SecretKey: c.DownloadS3SecretKey,
+		},
+		Gs: storage.S3Settings{
+			AccessKey: c.DownloadGsAccessKey,
+			SecretKey: c.DownloadGsSecretKey,
+		},
+	}
+}
+
+// WebhookSettings tells how the model events are delivered, the backoff
+// doubling after each attempt up to an hour.
+func (c Config) WebhookSettings() webhook.Settings {
+	return webhook.Settings{
+		Attempts:     c.WebhookAttempts,
+		Backoff:      time.Duration(c.WebhookBackoffSeconds) * time.Second,
+		MaxBackoff:   time.Hour,
+		Timeout:      time.Duration(c.WebhookTimeoutSeconds) * time.Second,
+		BufferSize:   c.WebhookBufferSize,
+		Workers:      c.WebhookWorkers,
+		AllowedHosts: c.WebhookAllowedHosts,
+		PrivateHosts: c.WebhookPrivateHosts,
+	}
+}
+
+// NotifySettings tells how the ends of the operations are notified, the
+// slack webhooks of the users being secrets as much as the smtp password.
+func (c Config) NotifySettings() notify.Settings {
+	return notify.Settings{
+		SmtpAddr:          c.NotifySmtpAddr,
+		SmtpUser:          c.NotifySmtpUser,
+		SmtpPassword:      c.NotifySmtpPass,
+		From:              c.NotifyFrom,
+		SlackAllowedHosts: c.NotifySlackHosts,
+		Timeout:           time.Duration(c.NotifyTimeoutSeconds) * time.Second,
+		BaseUrl:           c.NotifyBaseUrl,
+		Users:             c.NotifyUsers,
+	}
+}
+
+// ImportWeights tells how the free import slots are shared between the
+// interactive and the batch imports.
+func (c Config) ImportWeights() service.ImportWeights {
+	return service.ImportWeights{Interactive: c.ImportInteractiveWeight, Batch: c.ImportBatchWeight}
+}
+
+// StallSettings tells when the operations are stalled and how often the
+// stalled directory imports are resumed.
+func (c Config) StallSettings() service.StallSettings {
+	return service.StallSettings{After: time.Duration(c.OperationStallMinutes) * time.Minute, Retries: c.OperationStallRetries}
+}
+
+// PostImportHooks tells which post_import commands the templates may run.
+func (c Config) PostImportHooks() service.PostImportHooks {
+	return service.PostImportHooks{Commands: c.ImportPostCommands, TimeoutSeconds: c.ImportPostTimeoutSeconds}
+}
+
+// CopyOptions tells how the files of the models are copied.
+func (c Config) CopyOptions() uFiles.CopyOptions {
+	return uFiles.CopyOptions{BufferSize: c.CopyBufferSize, ReaderFrom: c.CopyReaderFrom}
+}
+
+// PathPolicy keeps the service inside its folders: the problem, training and
+// export folders and the import temp folder hold the data, templates come
+// from TemplateRoots, relative template paths from TemplateBase.
+func (c Config) PathPolicy(auditLog *audit.Log) (*service.PathPolicy, error) {
+	dataRoots := []string{c.ProblemPath, c.TrainingPath, c.ExportRoot, c.ImportOptions.TempDir}
+	return service.NewPathPolicy(dataRoots, c.TemplateRoots, c.TemplateBase, auditLog)
+}
+
+// LoadConfig reads the configuration from the flags, the config file at path,
+// when set, and the environment.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	err := config.Load(path, &cfg, flag.CommandLine)
+	return cfg, err
+}
+
+// ReloadOnSignal applies the import settings of the configuration at path on
+// SIGHUP. The other settings need a restart, the configuration is kept as it
+// was when it no longer loads.
+func ReloadOnSignal(path string, current Config, imports *service.ImportSettings) {
+	config.OnReload(func() {
+		next, err := LoadConfig(path)
+		if err != nil {
+			log.Println("domains.model.cmd.service.ReloadOnSignal: configuration kept", err)
+			return
+		}
+		imports.Set(next.ImportLimit, next.ImportQueueSize, next.ImportWeights(), next.ImportOptions, next.PostImportHooks())
+		current.ImportLimit = next.ImportLimit
+		current.ImportQueueSize = next.ImportQueueSize
+		current.ImportInteractiveWeight = next.ImportInteractiveWeight
+		current.ImportBatchWeight = next.ImportBatchWeight
+		current.ImportOptions = next.ImportOptions
+		current.ImportPostCommands = next.ImportPostCommands
+		current.ImportPostTimeoutSeconds = next.ImportPostTimeoutSeconds
+		if !reflect.DeepEqual(current, next) {
+			log.Println("domains.model.cmd.service.ReloadOnSignal: only the import settings were applied, restart the service for the others")
+		}
+		config.Print("MODEL", next)
+	})
+}
@@ -4,30 +4,80 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
 
 	n "server/common/names"
+	auditInsertOne "server/db/pkg/handler/audit/insert_one"
+	webhookFind "server/db/pkg/handler/webhook/find"
+	webhookDeadLetterInsertOne "server/db/pkg/handler/webhook_dead_letter/insert_one"
+	typeAudit "server/db/pkg/types/type/audit"
 	"server/domains/model/pkg/endpoint"
+	archiveModel "server/domains/model/pkg/handler/archive_model"
+	bulkUpdate "server/domains/model/pkg/handler/bulk_update"
+	cacheStats "server/domains/model/pkg/handler/cache_stats"
+	cancelOperation "server/domains/model/pkg/handler/cancel_operation"
+	checkFolderCollisions "server/domains/model/pkg/handler/check_folder_collisions"
+	"server/domains/model/pkg/handler/cleanup"
+	cloneModel "server/domains/model/pkg/handler/clone_model"
+	collectSnapshots "server/domains/model/pkg/handler/collect_snapshots"
+	"server/domains/model/pkg/handler/compare"
 	createFromGeneric "server/domains/model/pkg/handler/create_from_generic"
+	createWebhook "server/domains/model/pkg/handler/create_webhook"
 	"server/domains/model/pkg/handler/delete"
+	deleteWebhook "server/domains/model/pkg/handler/delete_webhook"
+	dismissStale "server/domains/model/pkg/handler/dismiss_stale"
+	downloadSnapshot "server/domains/model/pkg/handler/download_snapshot"
 	"server/domains/model/pkg/handler/evaluate"
 	fineTune "server/domains/model/pkg/handler/fine_tune"
+	getDetails "server/domains/model/pkg/handler/get_details"
+	getModelTemplate "server/domains/model/pkg/handler/get_model_template"
+	getOperation "server/domains/model/pkg/handler/get_operation"
+	getPreview "server/domains/model/pkg/handler/get_preview"
+	getReadme "server/domains/model/pkg/handler/get_readme"
+	healthCheck "server/domains/model/pkg/handler/health_check"
+	importDirectory "server/domains/model/pkg/handler/import_directory"
 	"server/domains/model/pkg/handler/list"
+	listWebhookDeadLetters "server/domains/model/pkg/handler/list_webhook_dead_letters"
+	listWebhooks "server/domains/model/pkg/handler/list_webhooks"
+	"server/domains/model/pkg/handler/materialize"
+	migrateStoragePaths "server/domains/model/pkg/handler/migrate_storage_paths"
+	normalizeModelPermissions "server/domains/model/pkg/handler/normalize_model_permissions"
+	pruneCache "server/domains/model/pkg/handler/prune_cache"
+	reEvaluateStale "server/domains/model/pkg/handler/re_evaluate_stale"
+	regenerateMetricsFile "server/domains/model/pkg/handler/regenerate_metrics_file"
+	resumeOperation "server/domains/model/pkg/handler/resume_operation"
+	"server/domains/model/pkg/handler/selftest"
+	setModelTags "server/domains/model/pkg/handler/set_model_tags"
+	testWebhook "server/domains/model/pkg/handler/test_webhook"
+	unarchiveModel "server/domains/model/pkg/handler/unarchive_model"
+	updateEvaluateResult "server/domains/model/pkg/handler/update_evaluate_result"
 	updateFromlocal "server/domains/model/pkg/handler/update_from_local"
+	updateModelDependency "server/domains/model/pkg/handler/update_model_dependency"
+	validateTemplate "server/domains/model/pkg/handler/validate_template"
+	validateTemplates "server/domains/model/pkg/handler/validate_templates"
+	verifyModel "server/domains/model/pkg/handler/verify_model"
+	watchOperation "server/domains/model/pkg/handler/watch_operation"
 	"server/domains/model/pkg/service"
+	"server/kit/audit"
 	"server/kit/encode_decode"
+	"server/kit/health"
+	"server/kit/notify"
+	"server/kit/storage"
 	kitutils "server/kit/utils"
+	"server/kit/webhook"
 
 	longendpoint "server/kit/endpoint"
 )
 
-func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass, trainingPath, problemPath *string) {
-	amqpUrl := fmt.Sprintf("amqp://%s:%s@%s/", *amqpUser, *amqpPass, *amqpAddr)
+func Run(serviceQueueName string, cfg Config, imports *service.ImportSettings) {
+	amqpUrl := fmt.Sprintf("amqp://%s:%s@%s/", cfg.AmqpUser, cfg.AmqpPass, cfg.AmqpAddr)
 	conn, err := rabbitmq.Dial(amqpUrl)
 	if err != nil {
 		log.Panic(err)
 	}
+	health.Set(health.CheckAmqp, health.Amqp(conn))
 	defer conn.Close()
 	ch, err := conn.Channel()
 	if err != nil {
@@ -49,8 +99,34 @@ func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass, trainingPath, pr
 	if err != nil {
 		log.Println("Consume", serviceQueueName, err)
 	}
-	svc := service.New(conn, *problemPath, *trainingPath, getServiceMiddleware())
-	eps := endpoint.New(svc, getEndpointMiddleware())
+	auditLog := audit.NewLog(auditInsertOne.Sink(conn), cfg.AuditBufferSize)
+	paths, err := cfg.PathPolicy(auditLog)
+	if err != nil {
+		log.Panic(err)
+	}
+	store, err := storage.New(cfg.StorageSettings())
+	if err != nil {
+		log.Panic(err)
+	}
+	webhooks := webhook.NewDispatcher(webhookFind.Finder(conn), webhookDeadLetterInsertOne.Sink(conn), cfg.WebhookSettings())
+	svc := service.New(conn, cfg.ProblemPath, cfg.TrainingPath, imports, paths, store, cfg.CleanupSettings(), time.Duration(cfg.StatusWindowMillis)*time.Millisecond, webhooks, notify.New(cfg.NotifySettings()), service.Repositories{}, getServiceMiddleware())
+	service.RecoverOperations(conn, svc, cfg.ResumeImports)
+	if cfg.CleanupIntervalMinutes > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go service.RunJanitor(svc, time.Duration(cfg.CleanupIntervalMinutes)*time.Minute, cfg.CleanupRemove, stop)
+	}
+	if cfg.OperationStallMinutes > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go service.RunStallMonitor(conn, svc, webhooks, cfg.StallSettings(), stop)
+	}
+	if cfg.ReEvaluateWindow != "" {
+		stop := make(chan struct{})
+		defer close(stop)
+		go service.RunReEvaluator(svc, cfg.ReEvaluateWindow, time.Duration(cfg.ReEvaluateIntervalMinutes)*time.Minute, stop)
+	}
+	eps := endpoint.New(svc, getEndpointMiddleware(auditLog))
 
 	go func() {
 		for msg := range msgs {
@@ -63,14 +139,88 @@ func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass, trainingPath, pr
 				fmt.Println(req)
 			}
 			switch req.Event {
+			case archiveModel.Event:
+				go archiveModel.Handle(eps, conn, msg)
+			case bulkUpdate.Event:
+				go bulkUpdate.Handle(eps, conn, msg)
+			case cacheStats.Event:
+				go cacheStats.Handle(eps, conn, msg)
+			case cleanup.Event:
+				go cleanup.Handle(eps, conn, msg)
+			case collectSnapshots.Event:
+				go collectSnapshots.Handle(eps, conn, msg)
+			case compare.Event:
+				go compare.Handle(eps, conn, msg)
+			case createWebhook.Event:
+				go createWebhook.Handle(eps, conn, msg)
+			case deleteWebhook.Event:
+				go deleteWebhook.Handle(eps, conn, msg)
+			case dismissStale.Event:
+				go dismissStale.Handle(eps, conn, msg)
 			case delete.Event:
 				go delete.Handle(eps, conn, msg)
 			case list.Event:
 				go list.Handle(eps, conn, msg)
+			case listWebhookDeadLetters.Event:
+				go listWebhookDeadLetters.Handle(eps, conn, msg)
+			case listWebhooks.Event:
+				go listWebhooks.Handle(eps, conn, msg)
+			case materialize.Event:
+				go materialize.Handle(eps, conn, msg)
+			case pruneCache.Event:
+				go pruneCache.Handle(eps, conn, msg)
+			case migrateStoragePaths.Event:
+				go migrateStoragePaths.Handle(eps, conn, msg)
+			case checkFolderCollisions.Event:
+				go checkFolderCollisions.Handle(eps, conn, msg)
+			case regenerateMetricsFile.Event:
+				go regenerateMetricsFile.Handle(eps, conn, msg)
+			case resumeOperation.Event:
+				go resumeOperation.Handle(eps, conn, msg)
+			case reEvaluateStale.Event:
+				go reEvaluateStale.Handle(eps, conn, msg)
 			case fineTune.Event:
 				go fineTune.Handle(eps, conn, msg)
+			case importDirectory.Event:
+				go importDirectory.Handle(eps, conn, msg)
+			case getDetails.Event:
+				go getDetails.Handle(eps, conn, msg)
+			case getModelTemplate.Event:
+				go getModelTemplate.Handle(eps, conn, msg)
+			case getOperation.Event:
+				go getOperation.Handle(eps, conn, msg)
+			case cancelOperation.Event:
+				go cancelOperation.Handle(eps, conn, msg)
+			case getPreview.Event:
+				go getPreview.Handle(eps, conn, msg)
+			case getReadme.Event:
+				go getReadme.Handle(eps, conn, msg)
+			case watchOperation.Event:
+				go watchOperation.Handle(eps, conn, msg)
 			case evaluate.Event:
 				go evaluate.Handle(eps, conn, msg)
+			case selftest.Event:
+				go selftest.Handle(eps, conn, msg)
+			case testWebhook.Event:
+				go testWebhook.Handle(eps, conn, msg)
+			case setModelTags.Event:
+				go setModelTags.Handle(eps, conn, msg)
+			case unarchiveModel.Event:
+				go unarchiveModel.Handle(eps, conn, msg)
+			case updateEvaluateResult.Event:
+				go updateEvaluateResult.Handle(eps, conn, msg)
+			case updateModelDependency.Event:
+				go updateModelDependency.Handle(eps, conn, msg)
+			case validateTemplate.Event:
+				go validateTemplate.Handle(eps, conn, msg)
+			case validateTemplates.Event:
+				go validateTemplates.Handle(eps, conn, msg)
+			case verifyModel.Event:
+				go verifyModel.Handle(eps, conn, msg)
+			case normalizeModelPermissions.Event:
+				go normalizeModelPermissions.Handle(eps, conn, msg)
+			case downloadSnapshot.Event:
+				go downloadSnapshot.Handle(eps, conn, msg)
 			}
 
 			switch req.Request {
@@ -78,6 +228,10 @@ func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass, trainingPath, pr
 				go updateFromlocal.Handle(eps, conn, msg)
 			case createFromGeneric.Request:
 				go createFromGeneric.Handle(eps, conn, msg)
+			case cloneModel.Event:
+				go cloneModel.Handle(eps, conn, msg)
+			case healthCheck.Request:
+				go healthCheck.Handle(eps, conn, msg)
 			}
 		}
 	}()
@@ -90,9 +244,41 @@ func getServiceMiddleware() (mw []service.Middleware) {
 	return
 }
 
-func getEndpointMiddleware() (mw map[string][]longendpoint.Middleware) {
+func getEndpointMiddleware(auditLog *audit.Log) (mw map[string][]longendpoint.Middleware) {
 	mw = map[string][]longendpoint.Middleware{}
 	// Add you endpoint middleware here
+	audited := map[string]string{
+		"ArchiveModel":              typeAudit.ModelArchive,
+		"BulkUpdate":                typeAudit.ModelBulkUpdate,
+		"CancelOperation":           typeAudit.ModelCancelOperation,
+		"Cleanup":                   typeAudit.ModelCleanup,
+		"CollectSnapshots":          typeAudit.ModelCollectSnapshots,
+		"CreateWebhook":             typeAudit.ModelCreateWebhook,
+		"CloneModel":                typeAudit.ModelClone,
+		"CreateFromGeneric":         typeAudit.ModelClone,
+		"Delete":                    typeAudit.ModelDelete,
+		"DeleteWebhook":             typeAudit.ModelDeleteWebhook,
+		"DismissStale":              typeAudit.ModelDismissStale,
+		"Evaluate":                  typeAudit.ModelEvaluate,
+		"FineTune":                  typeAudit.ModelTrain,
+		"ImportDirectory":           typeAudit.ModelImportDirectory,
+		"Materialize":               typeAudit.ModelMaterialize,
+		"NormalizeModelPermissions": typeAudit.ModelNormalizePermissions,
+		"PruneCache":                typeAudit.ModelPruneCache,
+		"MigrateStoragePaths":       typeAudit.ModelMigrateStoragePaths,
+		"ReEvaluateStale":           typeAudit.ModelReEvaluateStale,
+		"RegenerateMetricsFile":     typeAudit.ModelRegenerateMetricsFile,
+		"ResumeOperation":           typeAudit.ModelResumeOperation,
+		"SetModelTags":              typeAudit.ModelSetTags,
+		"TestWebhook":               typeAudit.ModelTestWebhook,
+		"UnarchiveModel":            typeAudit.ModelUnarchive,
+		"UpdateEvaluateResult":      typeAudit.ModelUpdateEvaluateResult,
+		"UpdateFromLocal":           typeAudit.ModelImport,
+		"UpdateModelDependency":     typeAudit.ModelUpdateDependency,
+	}
+	for name, action := range audited {
+		mw[name] = append(mw[name], audit.Middleware(auditLog, action))
+	}
 	return
 }
 
@@ -8,26 +8,203 @@ import (
 )
 
 type Endpoints struct {
-	CreateFromGeneric kitendpoint.Endpoint
-	Delete            kitendpoint.Endpoint
-	Evaluate          kitendpoint.Endpoint
-	FineTune          kitendpoint.Endpoint
-	List              kitendpoint.Endpoint
-	UpdateFromLocal   kitendpoint.Endpoint
+	ArchiveModel              kitendpoint.Endpoint
+	BulkUpdate                kitendpoint.Endpoint
+	CacheStats                kitendpoint.Endpoint
+	Cleanup                   kitendpoint.Endpoint
+	CollectSnapshots          kitendpoint.Endpoint
+	Compare                   kitendpoint.Endpoint
+	CreateWebhook             kitendpoint.Endpoint
+	DeleteWebhook             kitendpoint.Endpoint
+	DismissStale              kitendpoint.Endpoint
+	CreateFromGeneric         kitendpoint.Endpoint
+	CloneModel                kitendpoint.Endpoint
+	Delete                    kitendpoint.Endpoint
+	Evaluate                  kitendpoint.Endpoint
+	FineTune                  kitendpoint.Endpoint
+	GetDetails                kitendpoint.Endpoint
+	GetModelTemplate          kitendpoint.Endpoint
+	GetOperation              kitendpoint.Endpoint
+	CancelOperation           kitendpoint.Endpoint
+	GetPreview                kitendpoint.Endpoint
+	GetReadme                 kitendpoint.Endpoint
+	HealthCheck               kitendpoint.Endpoint
+	ImportDirectory           kitendpoint.Endpoint
+	List                      kitendpoint.Endpoint
+	ListWebhookDeadLetters    kitendpoint.Endpoint
+	ListWebhooks              kitendpoint.Endpoint
+	Materialize               kitendpoint.Endpoint
+	PruneCache                kitendpoint.Endpoint
+	MigrateStoragePaths       kitendpoint.Endpoint
+	CheckFolderCollisions     kitendpoint.Endpoint
+	RegenerateMetricsFile     kitendpoint.Endpoint
+	ResumeOperation           kitendpoint.Endpoint
+	ReEvaluateStale           kitendpoint.Endpoint
+	SelfTest                  kitendpoint.Endpoint
+	TestWebhook               kitendpoint.Endpoint
+	SetModelTags              kitendpoint.Endpoint
+	UnarchiveModel            kitendpoint.Endpoint
+	UpdateEvaluateResult      kitendpoint.Endpoint
+	UpdateFromLocal           kitendpoint.Endpoint
+	UpdateModelDependency     kitendpoint.Endpoint
+	ValidateTemplate          kitendpoint.Endpoint
+	ValidateTemplates         kitendpoint.Endpoint
+	VerifyModel               kitendpoint.Endpoint
+	NormalizeModelPermissions kitendpoint.Endpoint
+	DownloadSnapshot          kitendpoint.Endpoint
+	WatchOperation            kitendpoint.Endpoint
 }
 
 func New(s service.ModelService, mdw map[string][]kitendpoint.Middleware) Endpoints {
 	eps := Endpoints{
-		CreateFromGeneric: MakeCreateFromGenericEndpoint(s),
-		Delete:            MakeDeleteEndpoint(s),
-		Evaluate:          MakeEvaluateEndpoint(s),
-		FineTune:          MakeFineTuneEndpoint(s),
-		List:              MakeListEndpoint(s),
-		UpdateFromLocal:   MakeUpdateFromLocalEnpoint(s),
+		ArchiveModel:              MakeArchiveModelEndpoint(s),
+		BulkUpdate:                MakeBulkUpdateEndpoint(s),
+		CacheStats:                MakeCacheStatsEndpoint(s),
+		Cleanup:                   MakeCleanupEndpoint(s),
+		CollectSnapshots:          MakeCollectSnapshotsEndpoint(s),
+		Compare:                   MakeCompareEndpoint(s),
+		CreateWebhook:             MakeCreateWebhookEndpoint(s),
+		DeleteWebhook:             MakeDeleteWebhookEndpoint(s),
+		DismissStale:              MakeDismissStaleEndpoint(s),
+		CreateFromGeneric:         MakeCreateFromGenericEndpoint(s),
+		CloneModel:                MakeCloneModelEndpoint(s),
+		Delete:                    MakeDeleteEndpoint(s),
+		Evaluate:                  MakeEvaluateEndpoint(s),
+		FineTune:                  MakeFineTuneEndpoint(s),
+		GetDetails:                MakeGetDetailsEndpoint(s),
+		GetModelTemplate:          MakeGetModelTemplateEndpoint(s),
+		GetOperation:              MakeGetOperationEndpoint(s),
+		CancelOperation:           MakeCancelOperationEndpoint(s),
+		GetPreview:                MakeGetPreviewEndpoint(s),
+		GetReadme:                 MakeGetReadmeEndpoint(s),
+		HealthCheck:               MakeHealthCheckEndpoint(s),
+		ImportDirectory:           MakeImportDirectoryEndpoint(s),
+		List:                      MakeListEndpoint(s),
+		ListWebhookDeadLetters:    MakeListWebhookDeadLettersEndpoint(s),
+		ListWebhooks:              MakeListWebhooksEndpoint(s),
+		Materialize:               MakeMaterializeEndpoint(s),
+		PruneCache:                MakePruneCacheEndpoint(s),
+		MigrateStoragePaths:       MakeMigrateStoragePathsEndpoint(s),
+		CheckFolderCollisions:     MakeCheckFolderCollisionsEndpoint(s),
+		RegenerateMetricsFile:     MakeRegenerateMetricsFileEndpoint(s),
+		ResumeOperation:           MakeResumeOperationEndpoint(s),
+		ReEvaluateStale:           MakeReEvaluateStaleEndpoint(s),
+		SelfTest:                  MakeSelfTestEndpoint(s),
+		TestWebhook:               MakeTestWebhookEndpoint(s),
+		SetModelTags:              MakeSetModelTagsEndpoint(s),
+		UnarchiveModel:            MakeUnarchiveModelEndpoint(s),
+		UpdateEvaluateResult:      MakeUpdateEvaluateResultEndpoint(s),
+		UpdateFromLocal:           MakeUpdateFromLocalEnpoint(s),
+		UpdateModelDependency:     MakeUpdateModelDependencyEndpoint(s),
+		ValidateTemplate:          MakeValidateTemplateEndpoint(s),
+		ValidateTemplates:         MakeValidateTemplatesEndpoint(s),
+		VerifyModel:               MakeVerifyModelEndpoint(s),
+		NormalizeModelPermissions: MakeNormalizeModelPermissionsEndpoint(s),
+		DownloadSnapshot:          MakeDownloadSnapshotEndpoint(s),
+		WatchOperation:            MakeWatchOperationEndpoint(s),
 	}
+	eps.ArchiveModel = kitendpoint.Chain(eps.ArchiveModel, mdw["ArchiveModel"])
+	eps.BulkUpdate = kitendpoint.Chain(eps.BulkUpdate, mdw["BulkUpdate"])
+	eps.CacheStats = kitendpoint.Chain(eps.CacheStats, mdw["CacheStats"])
+	eps.Cleanup = kitendpoint.Chain(eps.Cleanup, mdw["Cleanup"])
+	eps.CollectSnapshots = kitendpoint.Chain(eps.CollectSnapshots, mdw["CollectSnapshots"])
+	eps.Compare = kitendpoint.Chain(eps.Compare, mdw["Compare"])
+	eps.CreateWebhook = kitendpoint.Chain(eps.CreateWebhook, mdw["CreateWebhook"])
+	eps.DeleteWebhook = kitendpoint.Chain(eps.DeleteWebhook, mdw["DeleteWebhook"])
+	eps.DismissStale = kitendpoint.Chain(eps.DismissStale, mdw["DismissStale"])
+	eps.CreateFromGeneric = kitendpoint.Chain(eps.CreateFromGeneric, mdw["CreateFromGeneric"])
+	eps.CloneModel = kitendpoint.Chain(eps.CloneModel, mdw["CloneModel"])
+	eps.Delete = kitendpoint.Chain(eps.Delete, mdw["Delete"])
+	eps.Evaluate = kitendpoint.Chain(eps.Evaluate, mdw["Evaluate"])
+	eps.FineTune = kitendpoint.Chain(eps.FineTune, mdw["FineTune"])
+	eps.GetDetails = kitendpoint.Chain(eps.GetDetails, mdw["GetDetails"])
+	eps.GetModelTemplate = kitendpoint.Chain(eps.GetModelTemplate, mdw["GetModelTemplate"])
+	eps.GetOperation = kitendpoint.Chain(eps.GetOperation, mdw["GetOperation"])
+	eps.CancelOperation = kitendpoint.Chain(eps.CancelOperation, mdw["CancelOperation"])
+	eps.GetPreview = kitendpoint.Chain(eps.GetPreview, mdw["GetPreview"])
+	eps.GetReadme = kitendpoint.Chain(eps.GetReadme, mdw["GetReadme"])
+	eps.HealthCheck = kitendpoint.Chain(eps.HealthCheck, mdw["HealthCheck"])
+	eps.ImportDirectory = kitendpoint.Chain(eps.ImportDirectory, mdw["ImportDirectory"])
+	eps.List = kitendpoint.Chain(eps.List, mdw["List"])
+	eps.ListWebhookDeadLetters = kitendpoint.Chain(eps.ListWebhookDeadLetters, mdw["ListWebhookDeadLetters"])
+	eps.ListWebhooks = kitendpoint.Chain(eps.ListWebhooks, mdw["ListWebhooks"])
+	eps.Materialize = kitendpoint.Chain(eps.Materialize, mdw["Materialize"])
+	eps.PruneCache = kitendpoint.Chain(eps.PruneCache, mdw["PruneCache"])
+	eps.MigrateStoragePaths = kitendpoint.Chain(eps.MigrateStoragePaths, mdw["MigrateStoragePaths"])
+	eps.CheckFolderCollisions = kitendpoint.Chain(eps.CheckFolderCollisions, mdw["CheckFolderCollisions"])
+	eps.RegenerateMetricsFile = kitendpoint.Chain(eps.RegenerateMetricsFile, mdw["RegenerateMetricsFile"])
+	eps.ResumeOperation = kitendpoint.Chain(eps.ResumeOperation, mdw["ResumeOperation"])
+	eps.ReEvaluateStale = kitendpoint.Chain(eps.ReEvaluateStale, mdw["ReEvaluateStale"])
+	eps.SelfTest = kitendpoint.Chain(eps.SelfTest, mdw["SelfTest"])
+	eps.TestWebhook = kitendpoint.Chain(eps.TestWebhook, mdw["TestWebhook"])
+	eps.SetModelTags = kitendpoint.Chain(eps.SetModelTags, mdw["SetModelTags"])
+	eps.UnarchiveModel = kitendpoint.Chain(eps.UnarchiveModel, mdw["UnarchiveModel"])
+	eps.UpdateEvaluateResult = kitendpoint.Chain(eps.UpdateEvaluateResult, mdw["UpdateEvaluateResult"])
+	eps.UpdateFromLocal = kitendpoint.Chain(eps.UpdateFromLocal, mdw["UpdateFromLocal"])
+	eps.UpdateModelDependency = kitendpoint.Chain(eps.UpdateModelDependency, mdw["UpdateModelDependency"])
+	eps.ValidateTemplate = kitendpoint.Chain(eps.ValidateTemplate, mdw["ValidateTemplate"])
+	eps.ValidateTemplates = kitendpoint.Chain(eps.ValidateTemplates, mdw["ValidateTemplates"])
+	eps.VerifyModel = kitendpoint.Chain(eps.VerifyModel, mdw["VerifyModel"])
+	eps.NormalizeModelPermissions = kitendpoint.Chain(eps.NormalizeModelPermissions, mdw["NormalizeModelPermissions"])
+	eps.DownloadSnapshot = kitendpoint.Chain(eps.DownloadSnapshot, mdw["DownloadSnapshot"])
+	eps.WatchOperation = kitendpoint.Chain(eps.WatchOperation, mdw["WatchOperation"])
 	return eps
 }
 
+func MakeArchiveModelEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.ArchiveModel(ctx, request.(service.ArchiveModelRequestData))
+	}
+}
+
+func MakeBulkUpdateEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.BulkUpdate(ctx, request.(service.BulkUpdateRequestData))
+	}
+}
+
+func MakeCacheStatsEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.CacheStats(ctx, request.(service.CacheStatsRequestData))
+	}
+}
+
+func MakeCleanupEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.Cleanup(ctx, request.(service.CleanupRequestData))
+	}
+}
+
+func MakeCollectSnapshotsEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.CollectSnapshots(ctx, request.(service.CollectSnapshotsRequestData))
+	}
+}
+
+func MakeCompareEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.Compare(ctx, request.(service.CompareRequestData))
+	}
+}
+
+func MakeCreateWebhookEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.CreateWebhook(ctx, request.(service.CreateWebhookRequestData))
+	}
+}
+
+func MakeDeleteWebhookEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.DeleteWebhook(ctx, request.(service.DeleteWebhookRequestData))
+	}
+}
+
+func MakeDismissStaleEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.DismissStale(ctx, request.(service.DismissStaleRequestData))
+	}
+}
+
 func MakeCreateFromGenericEndpoint(s service.ModelService) kitendpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
 		req := request.(service.CreateFromGenericRequest)
@@ -35,6 +212,12 @@ func MakeCreateFromGenericEndpoint(s service.ModelService) kitendpoint.Endpoint
 	}
 }
 
+func MakeCloneModelEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.CloneModel(ctx, request.(service.CloneModelRequestData))
+	}
+}
+
 func MakeDeleteEndpoint(s service.ModelService) kitendpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
 		req := request.(service.DeleteRequestData)
@@ -59,6 +242,55 @@ func MakeFineTuneEndpoint(s service.ModelService) kitendpoint.Endpoint {
 	}
 }
 
+func MakeGetDetailsEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.GetDetails(ctx, request.(service.GetDetailsRequestData))
+	}
+}
+
+func MakeGetModelTemplateEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.GetModelTemplate(ctx, request.(service.GetModelTemplateRequestData))
+	}
+}
+
+func MakeGetOperationEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.GetOperation(ctx, request.(service.GetOperationRequestData))
+	}
+}
+
+func MakeCancelOperationEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.CancelOperation(ctx, request.(service.CancelOperationRequestData))
+	}
+}
+
+func MakeGetPreviewEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.GetPreview(ctx, request.(service.GetPreviewRequestData))
+	}
+}
+
+func MakeGetReadmeEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.GetReadme(ctx, request.(service.GetReadmeRequestData))
+	}
+}
+
+func MakeHealthCheckEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		req := request.(service.HealthCheckRequestData)
+		return s.HealthCheck(ctx, req)
+	}
+}
+
+func MakeImportDirectoryEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.ImportDirectory(ctx, request.(service.ImportDirectoryRequestData))
+	}
+}
+
 func MakeListEndpoint(s service.ModelService) kitendpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
 		req := request.(service.ListRequestData)
@@ -66,8 +298,134 @@ func MakeListEndpoint(s service.ModelService) kitendpoint.Endpoint {
 	}
 }
 
+func MakeListWebhookDeadLettersEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.ListWebhookDeadLetters(ctx, request.(service.ListWebhookDeadLettersRequestData))
+	}
+}
+
+func MakeListWebhooksEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.ListWebhooks(ctx, request.(service.ListWebhooksRequestData))
+	}
+}
+
+func MakeMaterializeEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.Materialize(ctx, request.(service.MaterializeRequestData))
+	}
+}
+
+func MakePruneCacheEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.PruneCache(ctx, request.(service.PruneCacheRequestData))
+	}
+}
+
+func MakeMigrateStoragePathsEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.MigrateStoragePaths(ctx, request.(service.MigrateStoragePathsRequestData))
+	}
+}
+
+func MakeCheckFolderCollisionsEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.CheckFolderCollisions(ctx, request.(service.CheckFolderCollisionsRequestData))
+	}
+}
+
+func MakeRegenerateMetricsFileEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.RegenerateMetricsFile(ctx, request.(service.RegenerateMetricsFileRequestData))
+	}
+}
+
+func MakeResumeOperationEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.ResumeOperation(ctx, request.(service.ResumeOperationRequestData))
+	}
+}
+
+func MakeReEvaluateStaleEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.ReEvaluateStale(ctx, request.(service.ReEvaluateStaleRequestData))
+	}
+}
+
+func MakeSelfTestEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.SelfTest(ctx, request.(service.SelfTestRequestData))
+	}
+}
+
+func MakeTestWebhookEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.TestWebhook(ctx, request.(service.TestWebhookRequestData))
+	}
+}
+
+func MakeUnarchiveModelEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.UnarchiveModel(ctx, request.(service.UnarchiveModelRequestData))
+	}
+}
+
+func MakeSetModelTagsEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.SetModelTags(ctx, request.(service.SetModelTagsRequestData))
+	}
+}
+
+func MakeUpdateEvaluateResultEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.UpdateEvaluateResult(ctx, request.(service.UpdateEvaluateResultRequestData))
+	}
+}
+
 func MakeUpdateFromLocalEnpoint(s service.ModelService) kitendpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
 		return s.UpdateFromLocal(ctx, request.(service.UpdateFromLocalRequestData))
 	}
 }
+
+func MakeUpdateModelDependencyEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.UpdateModelDependency(ctx, request.(service.UpdateModelDependencyRequestData))
+	}
+}
+
+func MakeValidateTemplateEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.ValidateTemplate(ctx, request.(service.ValidateTemplateRequestData))
+	}
+}
+
+func MakeValidateTemplatesEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.ValidateTemplates(ctx, request.(service.ValidateTemplatesRequestData))
+	}
+}
+
+func MakeVerifyModelEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.VerifyModel(ctx, request.(service.VerifyModelRequestData))
+	}
+}
+
+func MakeNormalizeModelPermissionsEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.NormalizeModelPermissions(ctx, request.(service.NormalizeModelPermissionsRequestData))
+	}
+}
+
+func MakeDownloadSnapshotEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.DownloadSnapshot(ctx, request.(service.DownloadSnapshotRequestData))
+	}
+}
+
+func MakeWatchOperationEndpoint(s service.ModelService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		return s.WatchOperation(ctx, request.(service.WatchOperationRequestData))
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/streadway/amqp"
 
 	n "server/common/names"
-	modelFind "server/db/pkg/handler/model/find"
 	"server/domains/model/pkg/service"
 	kited "server/kit/encode_decode"
 	kitendpoint "server/kit/endpoint"
@@ -46,11 +45,10 @@ func decodeRequest(_ context.Context, deliv *amqp.Delivery) (interface{}, error)
 	return req.Data, err
 }
 
-type ResponseData = modelFind.ResponseData
+type ResponseData = service.ListResponseData
 
 func encodeResponse(_ context.Context, pub *amqp.Publishing, res interface{}) error {
 	r := res.(kitendpoint.Response)
-	r.Data = r.Data.(ResponseData)
 	b, err := json.Marshal(r)
 	pub.Body = b
 	return err
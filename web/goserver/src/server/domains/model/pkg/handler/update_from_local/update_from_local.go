@@ -8,7 +8,6 @@ import (
 	"github.com/streadway/amqp"
 
 	n "server/common/names"
-	t "server/db/pkg/types"
 	"server/domains/model/pkg/endpoint"
 	"server/domains/model/pkg/service"
 	kitendpoint "server/kit/endpoint"
@@ -72,15 +71,25 @@ func decodeRequest(_ context.Context, deliv *amqp.Delivery) (interface{}, error)
 	return req.Data, err
 }
 
-type ResponseData = t.Model
+// ResponseData is the data of the intermediate responses, one per imported
+// model of the template. SummaryData is the data of the last response.
+type ResponseData = service.UpdateFromLocalResponseData
+
+type SummaryData = service.UpdateFromLocalSummary
 
 func decodeResponse(_ context.Context, deliv *amqp.Delivery) (interface{}, error) {
 	var res kitendpoint.Response
-	var resData ResponseData
 	err := json.Unmarshal(deliv.Body, &res)
 	b, err := json.Marshal(res.Data)
-	err = json.Unmarshal(b, &resData)
-	res.Data = resData
+	if res.IsLast {
+		var summaryData SummaryData
+		err = json.Unmarshal(b, &summaryData)
+		res.Data = summaryData
+	} else {
+		var resData ResponseData
+		err = json.Unmarshal(b, &resData)
+		res.Data = resData
+	}
 	return res, err
 }
 
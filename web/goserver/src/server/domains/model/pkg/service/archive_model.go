@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	modelUpdateFields "server/db/pkg/handler/model/update_fields"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+)
+
+type ArchiveModelRequestData struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+}
+
+type UnarchiveModelRequestData = ArchiveModelRequestData
+
+// ArchiveModel hides a model from the lists instead of deleting it, its
+// folder and evaluations are kept until it is unarchived.
+func (s *basicModelService) ArchiveModel(ctx context.Context, req ArchiveModelRequestData) chan kitendpoint.Response {
+	return s.setArchived(ctx, req.ModelId, true)
+}
+
+// UnarchiveModel lists an archived model again.
+func (s *basicModelService) UnarchiveModel(ctx context.Context, req UnarchiveModelRequestData) chan kitendpoint.Response {
+	return s.setArchived(ctx, req.ModelId, false)
+}
+
+func (s *basicModelService) setArchived(ctx context.Context, modelId primitive.ObjectID, archived bool) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		unlock, err := s.lockModel(ctx, modelId, "archiving")
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: dirLockErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		defer unlock()
+		if err := s.statuses.flush(ctx, modelId); err != nil {
+			log.Println("domains.model.pkg.service.archive_model.setArchived.s.statuses.flush", err)
+		}
+		model, err := s.repos.Models.FindModel(ctx, modelFindOne.RequestData{Id: modelId})
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		model = s.localModel(model)
+		if model.Id.IsZero() {
+			err := fmt.Errorf("model %s not found", modelId.Hex())
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if err := access.Check(ctx, s.Conn, model.ProblemId, role.Editor); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		if model.Archived == archived {
+			returnChan <- kitendpoint.Response{Data: model, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+			return
+		}
+		archivedAt := time.Time{}
+		if archived {
+			archivedAt = time.Now()
+		}
+		saved, err := s.repos.Models.UpdateModelFields(ctx, modelUpdateFields.RequestData{Id: model.Id, Archived: &archived, ArchivedAt: &archivedAt})
+		if err != nil {
+			log.Println("domains.model.pkg.service.archive_model.setArchived.s.repos.Models.UpdateModelFields", err)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: s.localModel(saved), Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
@@ -2,37 +2,120 @@ package service
 
 import (
 	"context"
+	"os"
+	"time"
 
 	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
 
 	kitendpoint "server/kit/endpoint"
+	"server/kit/notify"
+	"server/kit/storage"
+	"server/kit/webhook"
 )
 
 type ModelService interface {
+	ArchiveModel(ctx context.Context, req ArchiveModelRequestData) chan kitendpoint.Response
+	BulkUpdate(ctx context.Context, req BulkUpdateRequestData) chan kitendpoint.Response
+	CacheStats(ctx context.Context, req CacheStatsRequestData) chan kitendpoint.Response
+	Cleanup(ctx context.Context, req CleanupRequestData) chan kitendpoint.Response
+	CollectSnapshots(ctx context.Context, req CollectSnapshotsRequestData) chan kitendpoint.Response
+	Compare(ctx context.Context, req CompareRequestData) chan kitendpoint.Response
+	CreateWebhook(ctx context.Context, req CreateWebhookRequestData) chan kitendpoint.Response
+	DeleteWebhook(ctx context.Context, req DeleteWebhookRequestData) chan kitendpoint.Response
+	DismissStale(ctx context.Context, req DismissStaleRequestData) chan kitendpoint.Response
 	CreateFromGeneric(ctx context.Context, req CreateFromGenericRequest) chan kitendpoint.Response
+	CloneModel(ctx context.Context, req CloneModelRequestData) chan kitendpoint.Response
 	Delete(ctx context.Context, req DeleteRequestData, responseChan chan kitendpoint.Response)
 	Evaluate(ctx context.Context, req EvaluateRequest) chan kitendpoint.Response
 	FineTune(ctx context.Context, req FineTuneRequestData) chan kitendpoint.Response
+	GetDetails(ctx context.Context, req GetDetailsRequestData) chan kitendpoint.Response
+	GetModelTemplate(ctx context.Context, req GetModelTemplateRequestData) chan kitendpoint.Response
+	GetOperation(ctx context.Context, req GetOperationRequestData) chan kitendpoint.Response
+	CancelOperation(ctx context.Context, req CancelOperationRequestData) chan kitendpoint.Response
+	GetPreview(ctx context.Context, req GetPreviewRequestData) chan kitendpoint.Response
+	GetReadme(ctx context.Context, req GetReadmeRequestData) chan kitendpoint.Response
+	HealthCheck(ctx context.Context, req HealthCheckRequestData) chan kitendpoint.Response
+	ImportDirectory(ctx context.Context, req ImportDirectoryRequestData) chan kitendpoint.Response
 	List(ctx context.Context, req ListRequestData) chan kitendpoint.Response
+	ListWebhookDeadLetters(ctx context.Context, req ListWebhookDeadLettersRequestData) chan kitendpoint.Response
+	ListWebhooks(ctx context.Context, req ListWebhooksRequestData) chan kitendpoint.Response
+	Materialize(ctx context.Context, req MaterializeRequestData) chan kitendpoint.Response
+	PruneCache(ctx context.Context, req PruneCacheRequestData) chan kitendpoint.Response
+	MigrateStoragePaths(ctx context.Context, req MigrateStoragePathsRequestData) chan kitendpoint.Response
+	CheckFolderCollisions(ctx context.Context, req CheckFolderCollisionsRequestData) chan kitendpoint.Response
+	RegenerateMetricsFile(ctx context.Context, req RegenerateMetricsFileRequestData) chan kitendpoint.Response
+	ResumeOperation(ctx context.Context, req ResumeOperationRequestData) chan kitendpoint.Response
+	ReEvaluateStale(ctx context.Context, req ReEvaluateStaleRequestData) chan kitendpoint.Response
+	SelfTest(ctx context.Context, req SelfTestRequestData) chan kitendpoint.Response
+	TestWebhook(ctx context.Context, req TestWebhookRequestData) chan kitendpoint.Response
+	SetModelTags(ctx context.Context, req SetModelTagsRequestData) chan kitendpoint.Response
+	UnarchiveModel(ctx context.Context, req UnarchiveModelRequestData) chan kitendpoint.Response
+	UpdateEvaluateResult(ctx context.Context, req UpdateEvaluateResultRequestData) chan kitendpoint.Response
 	UpdateFromLocal(ctx context.Context, req UpdateFromLocalRequestData) chan kitendpoint.Response
+	UpdateModelDependency(ctx context.Context, req UpdateModelDependencyRequestData) chan kitendpoint.Response
+	ValidateTemplate(ctx context.Context, req ValidateTemplateRequestData) chan kitendpoint.Response
+	ValidateTemplates(ctx context.Context, req ValidateTemplatesRequestData) chan kitendpoint.Response
+	VerifyModel(ctx context.Context, req VerifyModelRequestData) chan kitendpoint.Response
+	NormalizeModelPermissions(ctx context.Context, req NormalizeModelPermissionsRequestData) chan kitendpoint.Response
+	DownloadSnapshot(ctx context.Context, req DownloadSnapshotRequestData) chan kitendpoint.Response
+	WatchOperation(ctx context.Context, req WatchOperationRequestData) chan kitendpoint.Response
 }
 
 type basicModelService struct {
 	Conn          *rabbitmq.Connection
 	problemPath   string
 	trainingsPath string
+	imports       *ImportSettings
+	paths         *PathPolicy
+	store         storage.Storage
+	statuses      *statusWriter
+	webhooks      *webhook.Dispatcher
+	notifier      *notify.Notifier
+	repos         Repositories
+
+	cleanupSettings CleanupSettings
+	cleaning        int32
+	collecting      int32
+	migrating       int32
+	reEvaluating    int32
+	snapshotLeases  *snapshotLeases
+	modelLocks      *modelLocks
+	operations      *runningOperations
+	cacheHits       int64
+	cacheMisses     int64
 }
 
-func NewBasicModelService(conn *rabbitmq.Connection, problemPath, trainingsPath string) ModelService {
+// NewBasicModelService returns the model service. The model folders are
+// kept in store, with a working copy under problemPath. The status updates
+// of a model within statusWindow are saved together, the model events are sent
+// to the webhooks through webhooks and the ends of the operations are
+// notified through notifier. The imports find and save the problems, builds
+// and models through repos, the database service for those not set.
+func NewBasicModelService(conn *rabbitmq.Connection, problemPath, trainingsPath string, imports *ImportSettings, paths *PathPolicy, store storage.Storage, cleanup CleanupSettings, statusWindow time.Duration, webhooks *webhook.Dispatcher, notifier *notify.Notifier, repos Repositories) ModelService {
 	return &basicModelService{
 		Conn:          conn,
 		problemPath:   problemPath,
 		trainingsPath: trainingsPath,
+		imports:       imports,
+		paths:         paths,
+		store:         store,
+		statuses:      newStatusWriter(conn, statusWindow),
+		webhooks:      webhooks,
+		notifier:      notifier,
+		repos:         repos.withDefaults(conn),
+
+		cleanupSettings: cleanup,
+		snapshotLeases:  newSnapshotLeases(),
+		modelLocks:      newModelLocks(),
+		operations: newRunningOperations(func() error {
+			_, err := os.Stat(problemPath)
+			return err
+		}),
 	}
 }
 
-func New(conn *rabbitmq.Connection, problemPath, trainingsPath string, middleware []Middleware) ModelService {
-	var svc = NewBasicModelService(conn, problemPath, trainingsPath)
+func New(conn *rabbitmq.Connection, problemPath, trainingsPath string, imports *ImportSettings, paths *PathPolicy, store storage.Storage, cleanup CleanupSettings, statusWindow time.Duration, webhooks *webhook.Dispatcher, notifier *notify.Notifier, repos Repositories, middleware []Middleware) ModelService {
+	var svc = NewBasicModelService(conn, problemPath, trainingsPath, imports, paths, store, cleanup, statusWindow, webhooks, notifier, repos)
 	for _, m := range middleware {
 		svc = m(svc)
 	}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelBulkUpdate "server/db/pkg/handler/model/bulk_update"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+)
+
+const (
+	// defaultBulkUpdateSamples is the number of models a preview returns
+	// when it does not tell.
+	defaultBulkUpdateSamples = 10
+	// maxBulkUpdateSamples is the most models a preview returns.
+	maxBulkUpdateSamples = 100
+)
+
+// BulkUpdateRequestData applies Patch to the models Filter lists, whatever
+// its page. When Preview is set nothing is changed, the models matched are
+// counted and Samples of them returned instead, defaultBulkUpdateSamples
+// when zero.
+type BulkUpdateRequestData struct {
+	Filter  ListRequestData `json:"filter"`
+	Patch   t.ModelPatch    `json:"patch"`
+	Preview bool            `json:"preview,omitempty"`
+	Samples int64           `json:"samples,omitempty"`
+}
+
+// BulkUpdateResponseData tells how many models the filter matched and, unless
+// previewed, how many the patch changed. ProblemId is that of the filter, for
+// the audit log.
+type BulkUpdateResponseData struct {
+	ProblemId primitive.ObjectID `json:"problemId"`
+	Preview   bool               `json:"preview,omitempty"`
+	Matched   int64              `json:"matched"`
+	Modified  int64              `json:"modified"`
+	Samples   []ModelSummary     `json:"samples,omitempty"`
+}
+
+// BulkUpdate tags, describes, licenses, archives or unarchives at once the
+// models of a problem a list filter matches, with a single update of the
+// database, so that the models are changed even if there are hundreds. The
+// filter of a patch archiving or unarchiving the models matches the archived
+// ones. It takes the locks of the models it patches first, so that it does
+// not save over a change of one of them in progress, nor the reverse.
+func (s *basicModelService) BulkUpdate(ctx context.Context, req BulkUpdateRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		patch, err := normalizePatch(req.Patch)
+		if err == nil && req.Filter.ProblemId.IsZero() {
+			err = fmt.Errorf("the filter has no problem")
+		}
+		if err == nil && !req.Preview && patch.IsEmpty() {
+			err = fmt.Errorf("the patch changes nothing")
+		}
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if err := access.Check(ctx, s.Conn, req.Filter.ProblemId, role.Editor); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		samples := req.Samples
+		if samples <= 0 {
+			samples = defaultBulkUpdateSamples
+		}
+		if samples > maxBulkUpdateSamples {
+			samples = maxBulkUpdateSamples
+		}
+		result := BulkUpdateResponseData{
+			ProblemId: req.Filter.ProblemId,
+			Preview:   req.Preview,
+		}
+		filter := req.Filter.findRequest()
+		if patch.Archived != nil {
+			filter.IncludeArchived = true
+		}
+		if !req.Preview {
+			unlock, ids, err := s.lockModels(ctx, filter, "bulk update")
+			if err != nil {
+				returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: dirLockErrCode(err), Message: err.Error()}, IsLast: true}
+				return
+			}
+			defer unlock()
+			if len(ids) == 0 {
+				returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+				return
+			}
+			// The models found after their locks were taken are left alone.
+			filter.Ids = ids
+		}
+		updated, err := s.repos.Models.BulkUpdateModels(ctx, modelBulkUpdate.RequestData{
+			Filter:     filter,
+			Patch:      patch,
+			Preview:    req.Preview,
+			SampleSize: samples,
+		})
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		result.Matched = updated.Matched
+		result.Modified = updated.Modified
+		for _, model := range s.localModels(updated.Samples) {
+			result.Samples = append(result.Samples, summarizeModel(model))
+		}
+		returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// normalizePatch normalizes the tags of patch as SetModelTags does and trims
+// its license. SetTags replaces the tags, it goes with neither AddTags nor
+// RemoveTags.
+func normalizePatch(patch t.ModelPatch) (t.ModelPatch, error) {
+	if patch.SetTags != nil && (len(patch.AddTags) > 0 || len(patch.RemoveTags) > 0) {
+		return patch, fmt.Errorf("setTags goes with neither addTags nor removeTags")
+	}
+	var err error
+	if patch.SetTags != nil {
+		tags, err := normalizeTags(*patch.SetTags)
+		if err != nil {
+			return patch, err
+		}
+		patch.SetTags = &tags
+	}
+	if patch.AddTags, err = normalizeTags(patch.AddTags); err != nil {
+		return patch, err
+	}
+	if patch.RemoveTags, err = normalizeTags(patch.RemoveTags); err != nil {
+		return patch, err
+	}
+	if patch.License != nil {
+		license := strings.TrimSpace(*patch.License)
+		patch.License = &license
+	}
+	return patch, nil
+}
@@ -0,0 +1,144 @@
+package service_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	lockAcquire "server/db/pkg/handler/lock/acquire"
+	lockRelease "server/db/pkg/handler/lock/release"
+	t "server/db/pkg/types"
+	"server/domains/model/pkg/service"
+	kitendpoint "server/kit/endpoint"
+)
+
+// addModels stores a model of a new problem for each name, those named in
+// archived being archived.
+func (f memoryFixture) addModels(test *testing.T, names []string, archived ...string) (t.Problem, []t.Model) {
+	problem := f.store.AddProblem(t.Problem{Title: "problem"})
+	var models []t.Model
+	for _, name := range names {
+		model, err := f.store.AddModel(t.Model{ProblemId: problem.Id, Name: name, Tags: []string{"zoo"}})
+		if err != nil {
+			test.Fatal(err)
+		}
+		models = append(models, model)
+	}
+	for _, name := range archived {
+		_, err := f.store.AddModel(t.Model{ProblemId: problem.Id, Name: name, Tags: []string{"zoo"}, Archived: true, ArchivedAt: time.Now()})
+		if err != nil {
+			test.Fatal(err)
+		}
+	}
+	return problem, models
+}
+
+func (f memoryFixture) bulkUpdate(req service.BulkUpdateRequestData) (service.BulkUpdateResponseData, kitendpoint.Error) {
+	resp := <-f.service.BulkUpdate(context.Background(), req)
+	result, _ := resp.Data.(service.BulkUpdateResponseData)
+	return result, resp.Err
+}
+
+func TestBulkUpdate(test *testing.T) {
+	f := newMemoryFixture(test)
+	problem, _ := f.addModels(test, []string{"a", "b"}, "archived")
+	license := "apache-2.0"
+	req := service.BulkUpdateRequestData{
+		Filter:  service.ListRequestData{ProblemId: problem.Id, Tags: []string{"zoo"}},
+		Patch:   t.ModelPatch{AddTags: []string{"deprecated"}, License: &license},
+		Preview: true,
+	}
+
+	result, err := f.bulkUpdate(req)
+	if err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("preview: code %d (%s)", err.Code, err.Message)
+	}
+	if result.Matched != 2 || len(result.Samples) != 2 {
+		test.Errorf("preview matched %d with %d samples, want 2", result.Matched, len(result.Samples))
+	}
+	for _, model := range f.store.Models() {
+		if model.License != "" {
+			test.Errorf("preview licensed %s", model.Name)
+		}
+	}
+
+	req.Preview = false
+	result, err = f.bulkUpdate(req)
+	if err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("code %d (%s)", err.Code, err.Message)
+	}
+	if result.Matched != 2 || result.Modified != 2 {
+		test.Errorf("matched %d, modified %d, want 2", result.Matched, result.Modified)
+	}
+	for _, model := range f.store.Models() {
+		want := []string{"zoo", "deprecated"}
+		if model.Archived {
+			want = []string{"zoo"}
+		}
+		if !reflect.DeepEqual(model.Tags, want) {
+			test.Errorf("%s: tags %v, want %v", model.Name, model.Tags, want)
+		}
+	}
+}
+
+func TestBulkUpdateUnarchives(test *testing.T) {
+	f := newMemoryFixture(test)
+	problem, _ := f.addModels(test, []string{"a"}, "archived")
+	unarchive := false
+
+	result, err := f.bulkUpdate(service.BulkUpdateRequestData{
+		Filter: service.ListRequestData{ProblemId: problem.Id},
+		Patch:  t.ModelPatch{Archived: &unarchive},
+	})
+	if err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("code %d (%s)", err.Code, err.Message)
+	}
+	if result.Matched != 2 || result.Modified != 1 {
+		test.Errorf("matched %d, modified %d, want 2 and 1", result.Matched, result.Modified)
+	}
+	for _, model := range f.store.Models() {
+		if model.Archived || !model.ArchivedAt.IsZero() {
+			test.Errorf("%s still archived", model.Name)
+		}
+	}
+}
+
+func TestBulkUpdateWaitsForTheModelLocks(test *testing.T) {
+	f := newMemoryFixture(test)
+	problem, models := f.addModels(test, []string{"a", "b"})
+
+	// A change of the second model is in progress on another replica.
+	change := lockAcquire.RequestData{Key: "model/" + models[1].Id.Hex(), Owner: "replica", Holder: "tags", TtlMillis: 60000}
+	if held, err := f.store.AcquireLock(context.Background(), change); err != nil || !held.Acquired {
+		test.Fatal("model lock not taken", err)
+	}
+	done := make(chan kitendpoint.Response, 1)
+	go func() {
+		done <- <-f.service.BulkUpdate(context.Background(), service.BulkUpdateRequestData{
+			Filter: service.ListRequestData{ProblemId: problem.Id},
+			Patch:  t.ModelPatch{AddTags: []string{"deprecated"}},
+		})
+	}()
+	select {
+	case resp := <-done:
+		test.Fatalf("bulk update done during the change, code %d (%s)", resp.Err.Code, resp.Err.Message)
+	case <-time.After(300 * time.Millisecond):
+	}
+	for _, model := range f.store.Models() {
+		if len(model.Tags) != 1 {
+			test.Errorf("%s patched during the change: %v", model.Name, model.Tags)
+		}
+	}
+
+	if _, err := f.store.ReleaseLock(context.Background(), lockRelease.RequestData{Key: change.Key, Owner: change.Owner}); err != nil {
+		test.Fatal(err)
+	}
+	resp := <-done
+	if resp.Err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("code %d (%s)", resp.Err.Code, resp.Err.Message)
+	}
+	if result := resp.Data.(service.BulkUpdateResponseData); result.Modified != 2 {
+		test.Errorf("modified %d, want 2", result.Modified)
+	}
+}
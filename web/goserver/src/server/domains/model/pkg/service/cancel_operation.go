@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	operationClaim "server/db/pkg/handler/operation/claim"
+	operationUpdateOne "server/db/pkg/handler/operation/update_one"
+	t "server/db/pkg/types"
+	statusOperation "server/db/pkg/types/status/operation"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+)
+
+// operationCancelTimeout bounds the wait of CancelOperation for the
+// operation to stop.
+const operationCancelTimeout = 30 * time.Second
+
+// runningOperations are the operations the service runs, by id, for
+// CancelOperation to reach them. probe must succeed for them to beat.
+type runningOperations struct {
+	mu    sync.Mutex
+	byId  map[primitive.ObjectID]*operation
+	probe func() error
+}
+
+func newRunningOperations(probe func() error) *runningOperations {
+	return &runningOperations{byId: make(map[primitive.ObjectID]*operation), probe: probe}
+}
+
+// add registers op, starts its heartbeat and returns the context it runs
+// in. An operation that could not be stored can not be cancelled, it runs in
+// ctx itself.
+func (r *runningOperations) add(ctx context.Context, op *operation) context.Context {
+	if op.Id.IsZero() {
+		return ctx
+	}
+	ctx, op.cancel = context.WithCancel(withOperationId(ctx, op.Id))
+	op.done = make(chan struct{})
+	op.running = r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byId[op.Id] = op
+	go op.keepAlive(r.probe)
+	return ctx
+}
+
+func (r *runningOperations) get(id primitive.ObjectID) (*operation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	op, ok := r.byId[id]
+	return op, ok
+}
+
+// remove unregisters the finished op, releasing its context, and tells
+// the cancellers waiting for it. A lost op may have been resumed meanwhile,
+// the operation resumed staying registered.
+func (r *runningOperations) remove(op *operation) {
+	r.mu.Lock()
+	if r.byId[op.Id] == op {
+		delete(r.byId, op.Id)
+	}
+	r.mu.Unlock()
+	op.cancel()
+	close(op.done)
+}
+
+// canceller names the user who cancelled an operation in its error.
+func canceller(user string) string {
+	if user == "" {
+		return "the service"
+	}
+	return fmt.Sprintf("user %q", user)
+}
+
+type CancelOperationRequestData struct {
+	Id primitive.ObjectID `json:"id"`
+}
+
+// CancelOperation stops the operation Id and waits, for up to
+// operationCancelTimeout, until it did. The operation ends cancelled once
+// its cleanups removed what it left half done, the canceller being kept
+// with it. An interrupted operation is cancelled rather than resumed. An
+// operation already over is returned as it is. Only the admins and the user
+// who started the operation may cancel it when the request carries an
+// identity.
+func (s *basicModelService) CancelOperation(ctx context.Context, req CancelOperationRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		stored, err := s.getOperation(ctx, req.Id)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		var by string
+		if identity, ok := auth.FromContext(ctx); ok {
+			by = identity.User
+			if !identity.HasRole(auth.RoleAdmin) && identity.User != stored.StartedBy {
+				err := fmt.Errorf("user %q is not allowed to cancel operation %s", identity.User, stored.Id.Hex())
+				returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeForbidden, Message: err.Error()}, IsLast: true}
+				return
+			}
+		}
+		if isOperationOver(stored) {
+			returnChan <- kitendpoint.Response{Data: stored, Err: kitendpoint.Error{Code: 0}, IsLast: true, OperationId: stored.Id.Hex()}
+			return
+		}
+		if op, ok := s.operations.get(stored.Id); ok {
+			op.requestCancel(by)
+			select {
+			case <-op.done:
+			case <-time.After(operationCancelTimeout):
+				stored, _ = s.getOperation(ctx, req.Id)
+				err := fmt.Errorf("operation %s did not stop within %s, it is cancelled when it does", stored.Id.Hex(), operationCancelTimeout)
+				returnChan <- kitendpoint.Response{Data: stored, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeBusy, Message: err.Error()}, IsLast: true, OperationId: stored.Id.Hex()}
+				return
+			}
+			stored, err = s.getOperation(ctx, req.Id)
+			if err != nil {
+				returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+				return
+			}
+			returnChan <- kitendpoint.Response{Data: stored, Err: kitendpoint.Error{Code: 0}, IsLast: true, OperationId: stored.Id.Hex()}
+			return
+		}
+		cancelled, err := s.cancelStoredOperation(ctx, stored, by)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: stored, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeBusy, Message: err.Error()}, IsLast: true, OperationId: stored.Id.Hex()}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: cancelled, Err: kitendpoint.Error{Code: 0}, IsLast: true, OperationId: cancelled.Id.Hex()}
+	}()
+	return returnChan
+}
+
+// cancelStoredOperation cancels an operation the service does not run: an
+// interrupted one, or one a stopped service left. It fails when the
+// operation changed meanwhile, being resumed for instance.
+func (s *basicModelService) cancelStoredOperation(ctx context.Context, stored t.Operation, by string) (t.Operation, error) {
+	claimResp := <-operationClaim.Send(ctx, s.Conn, operationClaim.RequestData{Id: stored.Id, From: stored.Status, To: statusOperation.Cancelled})
+	claimed, _ := claimResp.Data.(operationClaim.ResponseData)
+	if claimResp.Err.Code > 0 || claimed.Id.IsZero() {
+		return stored, fmt.Errorf("operation %s changed while it was cancelled, retry", stored.Id.Hex())
+	}
+	claimed.CancelledBy = by
+	claimed.CancelledAt = time.Now()
+	claimed.Error = fmt.Sprintf("cancelled by %s", canceller(by))
+	resp := <-operationUpdateOne.Send(ctx, s.Conn, claimed)
+	if resp.Err.Code == 0 {
+		claimed = resp.Data.(operationUpdateOne.ResponseData)
+	}
+	claimed.Request = nil
+	return claimed, nil
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	fp "path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxChecksumsBytes bounds the checksums files read.
+const maxChecksumsBytes = 4 << 20
+
+var (
+	// gnuChecksumPattern matches the lines of sha256sum, the name marked
+	// binary or not, bsdChecksumPattern those of sha256sum --tag.
+	gnuChecksumPattern = regexp.MustCompile(`^([0-9a-fA-F]{64}) [ *](.+)$`)
+	bsdChecksumPattern = regexp.MustCompile(`^SHA256 \((.+)\) = ([0-9a-fA-F]{64})$`)
+)
+
+// checksums are the sha256 of a checksums file by the names it lists.
+type checksums map[string]string
+
+// resolveChecksums gives the dependencies without a sha256 the one their
+// checksums file lists for them, that of the dependency or else that of the
+// template. The files are read once per import when ctx has an import cache.
+func (s *basicModelService) resolveChecksums(ctx context.Context, from string, modelYml *ModelYml, opts ImportOptions) error {
+	for i, d := range modelYml.Dependencies {
+		source := d.Checksums
+		if source == "" {
+			source = modelYml.Checksums
+		}
+		if d.Sha256 != "" || source == "" || isModelSource(d.Source) {
+			continue
+		}
+		if !isValidUrl(source) {
+			source = sourcePath(from, source)
+		}
+		sums, err := s.findChecksums(ctx, source, opts)
+		if err != nil {
+			return fmt.Errorf("checksums of %s: %w", d.Destination, err)
+		}
+		sha, ok := sums.lookup(source, d.Source, from)
+		if !ok {
+			return fmt.Errorf("checksums file does not list %s", d.Destination)
+		}
+		modelYml.Dependencies[i].Sha256 = sha
+	}
+	return nil
+}
+
+// lookup finds the sha256 of the dependency source, listed by its path
+// relative to the checksums file at checksumsSource or by its base name.
+func (sums checksums) lookup(checksumsSource, source, from string) (string, bool) {
+	var rel, base string
+	if u, err := url.Parse(source); err == nil && isValidUrl(source) {
+		base = path.Base(u.Path)
+		if c, err := url.Parse(checksumsSource); err == nil && c.Scheme == u.Scheme && c.Host == u.Host {
+			rel = strings.TrimPrefix(u.Path, path.Dir(c.Path)+"/")
+		}
+	} else {
+		file := sourcePath(from, source)
+		base = fp.Base(file)
+		if r, err := fp.Rel(fp.Dir(checksumsSource), file); err == nil {
+			rel = fp.ToSlash(r)
+		}
+	}
+	for _, name := range []string{rel, base} {
+		if sha, ok := sums[name]; ok && name != "" {
+			return sha, true
+		}
+	}
+	return "", false
+}
+
+// findChecksums is readChecksums through the import cache of ctx, if any.
+func (s *basicModelService) findChecksums(ctx context.Context, source string, opts ImportOptions) (checksums, error) {
+	cache := importCacheFrom(ctx)
+	if cache == nil {
+		return s.readChecksums(ctx, source, opts)
+	}
+	entry := cache.checksumsFile(source)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.found {
+		importCacheLookups.Inc("checksums", "hit")
+		return entry.sums, nil
+	}
+	importCacheLookups.Inc("checksums", "miss")
+	sums, err := s.readChecksums(ctx, source, opts)
+	if err == nil {
+		entry.sums, entry.found = sums, true
+	}
+	return sums, err
+}
+
+// readChecksums downloads or reads the checksums file at source, a download
+// following the host policy of opts.
+func (s *basicModelService) readChecksums(ctx context.Context, source string, opts ImportOptions) (checksums, error) {
+	var b bytes.Buffer
+	if isValidUrl(source) {
+		if err := opts.checkSource(source, 0); err != nil {
+			return nil, err
+		}
+		if timeout := opts.downloadTimeout(); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		if _, _, err := fetchUrl(ctx, source, &b, maxChecksumsBytes, opts.userAgent(), validators{}); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := s.paths.CheckRead(ctx, source); err != nil {
+			return nil, err
+		}
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if n, err := io.Copy(&b, io.LimitReader(f, maxChecksumsBytes+1)); err != nil {
+			return nil, err
+		} else if n > maxChecksumsBytes {
+			return nil, fmt.Errorf("checksums file exceeds the limit of %d bytes", maxChecksumsBytes)
+		}
+	}
+	return parseChecksums(&b)
+}
+
+// parseChecksums reads the GNU and BSD formats of sha256sum, the names being
+// kept without a leading ./ and the blank and comment lines skipped.
+func parseChecksums(r io.Reader) (checksums, error) {
+	sums := make(checksums)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var sha, name string
+		if m := gnuChecksumPattern.FindStringSubmatch(line); m != nil {
+			sha, name = m[1], m[2]
+		} else if m := bsdChecksumPattern.FindStringSubmatch(line); m != nil {
+			name, sha = m[1], m[2]
+		} else {
+			return nil, fmt.Errorf("malformed checksums line %q", line)
+		}
+		sums[strings.TrimPrefix(name, "./")] = strings.ToLower(sha)
+	}
+	return sums, scanner.Err()
+}
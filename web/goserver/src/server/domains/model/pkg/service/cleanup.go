@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	problemFind "server/db/pkg/handler/problem/find"
+	t "server/db/pkg/types"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+)
+
+// Kinds of the files and folders found by Cleanup.
+const (
+	CleanupOrphanModel   = "orphanModel"
+	CleanupStaging       = "staging"
+	CleanupLeftover      = "leftover"
+	CleanupExpiredExport = "expiredExport"
+)
+
+// CleanupSettings tells what Cleanup may take. Files are only taken once
+// nothing in them changed for GracePeriod, exports once they are older than
+// ExportRetention. Exports are left alone when ExportRoot or ExportRetention
+// is not set. The unreferenced snapshots are collected beyond
+// SnapshotMaxBytes bytes of snapshots, or by the grace period only when it
+// is 0.
+type CleanupSettings struct {
+	GracePeriod      time.Duration
+	ExportRoot       string
+	ExportRetention  time.Duration
+	SnapshotMaxBytes int64
+}
+
+// CleanupRequestData asks for the findings only when DryRun is set.
+type CleanupRequestData struct {
+	DryRun bool `json:"dryRun"`
+}
+
+// CleanupFinding is a file or folder nothing refers to any more. Bytes is
+// what removing it reclaims, Error why it could not be removed.
+type CleanupFinding struct {
+	Kind       string    `json:"kind"`
+	Path       string    `json:"path"`
+	Bytes      int64     `json:"bytes"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+	Removed    bool      `json:"removed"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// CleanupSummary is the data of the last response of Cleanup. Problems whose
+// models could not be listed are skipped, so their folders are never taken
+// for orphans.
+type CleanupSummary struct {
+	DryRun           bool     `json:"dryRun"`
+	Findings         int      `json:"findings"`
+	ReclaimableBytes int64    `json:"reclaimableBytes"`
+	RemovedBytes     int64    `json:"removedBytes"`
+	Failed           int      `json:"failed"`
+	SkippedProblems  []string `json:"skippedProblems"`
+}
+
+// Cleanup looks for the model folders of deleted or failed imports, staging
+// folders and download leftovers in the problem folders, download leftovers
+// in the import temp folder and expired exports. It sends one response per
+// finding, removing it unless DryRun is set, then the summary. Only admins
+// may run it when the request carries an identity.
+func (s *basicModelService) Cleanup(ctx context.Context, req CleanupRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		if identity, ok := auth.FromContext(ctx); ok && !identity.HasRole(auth.RoleAdmin) {
+			err := fmt.Errorf("user %q is not allowed to run the cleanup", identity.User)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeForbidden, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if !atomic.CompareAndSwapInt32(&s.cleaning, 0, 1) {
+			err := fmt.Errorf("a cleanup is already running")
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeBusy, Message: err.Error()}, IsLast: true}
+			return
+		}
+		defer atomic.StoreInt32(&s.cleaning, 0)
+		summary := CleanupSummary{DryRun: req.DryRun, SkippedProblems: []string{}}
+		s.cleanup(ctx, time.Now(), &summary, func(finding CleanupFinding) {
+			if !req.DryRun {
+				err := s.paths.CheckWrite(ctx, finding.Path)
+				if err == nil {
+					err = s.removeFinding(ctx, finding)
+				}
+				if err != nil {
+					log.Println("domains.model.pkg.service.cleanup.Cleanup.os.RemoveAll", err)
+					finding.Error = err.Error()
+					summary.Failed++
+				} else {
+					s.publish(ctx, finding.Path, nil)
+					finding.Removed = true
+					summary.RemovedBytes += finding.Bytes
+				}
+			}
+			summary.Findings++
+			summary.ReclaimableBytes += finding.Bytes
+			returnChan <- kitendpoint.Response{Data: finding, Err: kitendpoint.Error{Code: 0}, IsLast: false}
+		})
+		returnChan <- kitendpoint.Response{Data: summary, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicModelService) cleanup(ctx context.Context, now time.Time, summary *CleanupSummary, found func(CleanupFinding)) {
+	expired := func(kind, path string, age time.Duration) {
+		bytes, modified, err := usage(path)
+		if err != nil {
+			log.Println("domains.model.pkg.service.cleanup.usage", path, err)
+			return
+		}
+		if now.Sub(modified) >= age {
+			found(CleanupFinding{Kind: kind, Path: path, Bytes: bytes, ModifiedAt: modified})
+		}
+	}
+	grace := s.cleanupSettings.GracePeriod
+	problemResp := <-problemFind.Send(ctx, s.Conn, problemFind.RequestData{Page: 1, Size: 0})
+	if problemResp.Err.Code > 0 {
+		log.Println("domains.model.pkg.service.cleanup.problemFind.Send", problemResp.Err.Message)
+		return
+	}
+	for _, problem := range problemResp.Data.(problemFind.ResponseData).Items {
+		if problem.Dir == "" {
+			continue
+		}
+		models, err := s.problemModels(ctx, problem)
+		if err != nil {
+			log.Println("domains.model.pkg.service.cleanup.problemModels", problem.Title, err)
+			summary.SkippedProblems = append(summary.SkippedProblems, problem.Title)
+			continue
+		}
+		entries, err := ioutil.ReadDir(problem.Dir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Println("domains.model.pkg.service.cleanup.ioutil.ReadDir", problem.Dir, err)
+			}
+			continue
+		}
+		known := make(map[string]bool)
+		for _, model := range models {
+			known[fp.Clean(model.Dir)] = true
+		}
+		for _, entry := range entries {
+			path := fp.Join(problem.Dir, entry.Name())
+			switch {
+			case !entry.IsDir():
+				if isLeftover(entry.Name()) {
+					expired(CleanupLeftover, path, grace)
+				}
+			case strings.HasPrefix(entry.Name(), ".self_test"):
+				expired(CleanupStaging, path, grace)
+			case entry.Name() == "_builds":
+				if _, err := os.Stat(fp.Join(path, "__tmp__")); err == nil {
+					expired(CleanupStaging, fp.Join(path, "__tmp__"), grace)
+				}
+			case strings.HasPrefix(entry.Name(), "_") || strings.HasPrefix(entry.Name(), "."):
+			case known[path]:
+				for _, leftover := range findLeftovers(path) {
+					expired(CleanupLeftover, leftover, grace)
+				}
+			default:
+				expired(CleanupOrphanModel, path, grace)
+			}
+		}
+	}
+	if tempDir := s.imports.Options().TempDir; tempDir != "" {
+		for _, leftover := range findLeftovers(tempDir) {
+			expired(CleanupLeftover, leftover, grace)
+		}
+	}
+	settings := s.cleanupSettings
+	if settings.ExportRoot != "" && settings.ExportRetention > 0 {
+		entries, err := ioutil.ReadDir(settings.ExportRoot)
+		if err != nil {
+			log.Println("domains.model.pkg.service.cleanup.ioutil.ReadDir", settings.ExportRoot, err)
+		}
+		for _, entry := range entries {
+			expired(CleanupExpiredExport, fp.Join(settings.ExportRoot, entry.Name()), settings.ExportRetention)
+		}
+	}
+}
+
+// problemModels lists all the models of the problem, failing rather than
+// returning a partial list.
+func (s *basicModelService) problemModels(ctx context.Context, problem t.Problem) ([]t.Model, error) {
+	models, err := s.storedModels(ctx, problem)
+	if err != nil {
+		return nil, err
+	}
+	return s.localModels(models), nil
+}
+
+// isLeftover tells the temp files of interrupted downloads and writes apart.
+func isLeftover(name string) bool {
+	return strings.HasPrefix(name, ".download-") || (strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp"))
+}
+
+func findLeftovers(dir string) []string {
+	var leftovers []string
+	err := fp.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && isLeftover(info.Name()) {
+			leftovers = append(leftovers, path)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("domains.model.pkg.service.cleanup.findLeftovers", dir, err)
+	}
+	return leftovers
+}
+
+// removeFinding removes what Cleanup found. An orphan model folder is
+// locked first, as another replica may be importing into it since.
+func (s *basicModelService) removeFinding(ctx context.Context, finding CleanupFinding) error {
+	if finding.Kind == CleanupOrphanModel {
+		unlock, err := s.lockDir(ctx, finding.Path, "cleanup")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	return os.RemoveAll(finding.Path)
+}
+
+// usage returns the size of the regular files under path and the time the
+// last of its entries changed.
+func usage(path string) (bytes int64, modified time.Time, err error) {
+	err = fp.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			bytes += info.Size()
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+		return nil
+	})
+	return bytes, modified, err
+}
+
+// RunJanitor runs Cleanup then CollectSnapshots every interval until stop is
+// closed, removing what they find when remove is set and only logging it
+// otherwise.
+func RunJanitor(svc ModelService, interval time.Duration, remove bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		for resp := range svc.Cleanup(context.Background(), CleanupRequestData{DryRun: !remove}) {
+			if resp.Err.Code > 0 {
+				log.Println("domains.model.pkg.service.cleanup.RunJanitor", resp.Err.Message)
+				continue
+			}
+			switch data := resp.Data.(type) {
+			case CleanupFinding:
+				log.Println("domains.model.pkg.service.cleanup.RunJanitor", data.Kind, data.Path, data.Bytes, data.Removed, data.Error)
+			case CleanupSummary:
+				log.Printf("domains.model.pkg.service.cleanup.RunJanitor: %d findings, %d bytes reclaimable, %d bytes removed, %d failed, skipped problems %v", data.Findings, data.ReclaimableBytes, data.RemovedBytes, data.Failed, data.SkippedProblems)
+			}
+		}
+		for resp := range svc.CollectSnapshots(context.Background(), CollectSnapshotsRequestData{DryRun: !remove}) {
+			if resp.Err.Code > 0 {
+				log.Println("domains.model.pkg.service.cleanup.RunJanitor", resp.Err.Message)
+				continue
+			}
+			data := resp.Data.(CollectSnapshotsResponseData)
+			log.Printf("domains.model.pkg.service.cleanup.RunJanitor: %d snapshots, %d bytes stored, %d bytes saved by dedup, %d to collect, %d bytes reclaimable, %d bytes removed, %d failed", data.Snapshots, data.StoredBytes, data.DedupSavedBytes, len(data.Candidates), data.ReclaimableBytes, data.RemovedBytes, data.Failed)
+		}
+	}
+}
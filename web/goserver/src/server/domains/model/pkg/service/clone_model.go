@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	fp "path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	buildFindOne "server/db/pkg/handler/build/find_one"
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	problemFindOne "server/db/pkg/handler/problem/find_one"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	statusModelEvaluate "server/db/pkg/types/status/model/evaluate"
+	statusModelTrain "server/db/pkg/types/status/model/train"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+	u "server/kit/utils"
+)
+
+// CloneModelRequestData asks for a copy of the model ModelId named NewName,
+// in the same problem.
+type CloneModelRequestData struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+	NewName string             `json:"newName"`
+}
+
+// CloneModel copies a model into a new model of the same problem, which can
+// be edited and trained without touching the original. The copy starts
+// untrained, with the metrics of its template on the default build only: the
+// evaluations of the original are not copied. Its snapshots are hardlinks of
+// the snapshot store rather than copies.
+func (s *basicModelService) CloneModel(ctx context.Context, req CloneModelRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		name := strings.TrimSpace(req.NewName)
+		folderName := strings.TrimSpace(u.StringToFolderName(name))
+		if folderName == "" || strings.HasPrefix(folderName, "_") || strings.HasPrefix(folderName, ".") {
+			err := fmt.Errorf("%q is not a valid model name", req.NewName)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		found, err := s.repos.Models.FindModel(ctx, modelFindOne.RequestData{Id: req.ModelId})
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		model := s.localModel(found)
+		if model.Id.IsZero() {
+			err := fmt.Errorf("model %s not found", req.ModelId.Hex())
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if err := access.Check(ctx, s.Conn, model.ProblemId, role.Editor); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		problem, err := s.repos.Problems.FindProblem(ctx, problemFindOne.RequestData{Id: model.ProblemId})
+		if err == nil && problem.Id.IsZero() {
+			err = fmt.Errorf("problem %s of model %q not found", model.ProblemId.Hex(), model.Name)
+		}
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		defaultBuild, err := s.getDefaultBuild(ctx, problem.Id)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		existing := s.findImportedModel(ctx, t.Model{ProblemId: problem.Id, Workspace: model.Workspace, Name: name})
+		if !existing.Id.IsZero() {
+			err := fmt.Errorf("model %q already exists in problem %q", name, problem.Title)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		dir, err := s.modelDir(ctx, problem, model.Workspace, name, existing)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if err := s.checkCopy(ctx, dir, model.Dir); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: err.Error()}, IsLast: true}
+			return
+		}
+		unlock, err := s.lockDir(ctx, dir, "clone")
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: dirLockErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		defer unlock()
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			err := fmt.Errorf("folder %s of model %q already exists", fp.Base(dir), name)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		clone, err := s.cloneModel(ctx, model, defaultBuild, name, dir)
+		if err != nil {
+			log.Println("domains.model.pkg.service.clone_model.CloneModel.cloneModel", model.Name, err)
+			if err := os.RemoveAll(dir); err != nil {
+				log.Println("domains.model.pkg.service.clone_model.CloneModel.os.RemoveAll", dir, err)
+			}
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: clone, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// cloneModel copies the folder of model to dir, leaving the evaluations out,
+// and inserts the clone.
+func (s *basicModelService) cloneModel(ctx context.Context, model t.Model, defaultBuild t.Build, name, dir string) (t.Model, error) {
+	skipped := map[string]bool{defaultBuildFolder: true}
+	if defaultBuild.Folder != "" {
+		skipped[defaultBuild.Folder] = true
+	}
+	for buildId := range model.Evaluates {
+		id, err := primitive.ObjectIDFromHex(buildId)
+		if err != nil {
+			continue
+		}
+		if build, err := s.repos.Builds.FindBuild(ctx, buildFindOne.RequestData{Id: id}); err == nil && build.Folder != "" {
+			skipped[build.Folder] = true
+		}
+	}
+	if err := s.copyModelDir(ctx, model, dir, skipped); err != nil {
+		return t.Model{}, err
+	}
+	templateYaml := getTemplateYaml(rebasePath(model.TemplatePath, model.Dir, dir))
+	if err := s.saveMetrics(ctx, dir, templateYaml); err != nil {
+		log.Println("domains.model.pkg.service.clone_model.cloneModel.saveMetrics", err)
+	}
+	clone := t.ModelWithoutId{
+		BatchSize:       model.BatchSize,
+		Cas:             model.Cas,
+		ConfigPath:      model.ConfigPath,
+		ContentHash:     model.ContentHash,
+		ProblemId:       model.ProblemId,
+		Description:     model.Description,
+		Dir:             model.Dir,
+		Dependencies:    model.Dependencies,
+		Epochs:          model.Epochs,
+		Evaluates:       map[string]t.Evaluate{defaultBuild.Id.Hex(): {Metrics: templateYaml.Metrics, Status: statusModelEvaluate.Default}},
+		Framework:       model.Framework,
+		ImportedBy:      importedBy(ctx),
+		License:         model.License,
+		ModulesYamlPath: model.ModulesYamlPath,
+		Name:            name,
+		ParentModelId:   model.Id,
+		Previews:        model.Previews,
+		ReadmePath:      model.ReadmePath,
+		Scripts:         model.Scripts,
+		SnapshotFormat:  model.SnapshotFormat,
+		SnapshotPath:    model.SnapshotPath,
+		Status:          statusModelTrain.Default,
+		Tags:            model.Tags,
+		TemplatePath:    model.TemplatePath,
+		TrainingGpuNum:  model.TrainingGpuNum,
+		WeightsPath:     model.WeightsPath,
+		Workspace:       model.Workspace,
+	}
+	for _, path := range modelPaths(&clone.ConfigPath, &clone.Dir, &clone.ModulesYamlPath, &clone.ReadmePath, &clone.Scripts, &clone.SnapshotPath, &clone.TemplatePath, &clone.WeightsPath) {
+		*path = rebasePath(*path, model.Dir, dir)
+	}
+	saved, err := s.repos.Models.InsertModel(ctx, s.storedModelWithoutId(clone))
+	if err != nil {
+		return t.Model{}, fmt.Errorf("save clone: %v", err)
+	}
+	inserted := s.localModel(saved)
+	s.publish(ctx, dir, nil)
+	s.registerSnapshots(ctx, inserted)
+	return inserted, nil
+}
+
+// copyModelDir copies the folder of model to dir, but for the top folders in
+// skipped and the leftovers. The snapshots are linked from the store, being
+// copied only when they can not be.
+func (s *basicModelService) copyModelDir(ctx context.Context, model t.Model, dir string, skipped map[string]bool) error {
+	return fp.Walk(model.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := fp.Rel(model.Dir, path)
+		if err != nil {
+			return err
+		}
+		to := fp.Join(dir, rel)
+		switch {
+		case info.IsDir():
+			if skipped[rel] {
+				return fp.SkipDir
+			}
+			return os.MkdirAll(to, 0777)
+		case !info.Mode().IsRegular() || isLeftover(info.Name()):
+			return nil
+		case info.Size() > 0 && (model.Cas || path == model.SnapshotPath || path == model.WeightsPath):
+			err := s.linkSnapshot(ctx, path, to)
+			if err == nil {
+				return nil
+			}
+			log.Println("domains.model.pkg.service.clone_model.copyModelDir.linkSnapshot", path, err)
+		}
+		return copyFiles(path, to)
+	})
+}
+
+// linkSnapshot places at to a hardlink of the stored snapshot of the file at
+// path, storing it first when it is not yet.
+func (s *basicModelService) linkSnapshot(ctx context.Context, path, to string) error {
+	file, err := s.storeSnapshot(ctx, path)
+	if err != nil {
+		return err
+	}
+	return os.Link(file.Blob, to)
+}
+
+// rebasePath moves path from the folder from to the folder to, a path
+// outside of from being kept as it is.
+func rebasePath(path, from, to string) string {
+	rel, err := fp.Rel(from, path)
+	if path == "" || err != nil || !(rel == "." || isInsideDir(rel)) {
+		return path
+	}
+	return fp.Join(to, rel)
+}
@@ -0,0 +1,56 @@
+package service_test
+
+import (
+	"context"
+	fp "path/filepath"
+	"testing"
+
+	t "server/db/pkg/types"
+	"server/domains/model/pkg/service"
+	kitendpoint "server/kit/endpoint"
+)
+
+func (f memoryFixture) clone(model t.Model, name string) kitendpoint.Response {
+	return <-f.service.CloneModel(context.Background(), service.CloneModelRequestData{ModelId: model.Id, NewName: name})
+}
+
+func TestCloneModel(test *testing.T) {
+	f := newMemoryFixture(test)
+	model := f.importDetector(test)
+
+	resp := f.clone(model, "detector copy")
+	if resp.Err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("code %d (%s)", resp.Err.Code, resp.Err.Message)
+	}
+	clone := resp.Data.(t.Model)
+	if clone.ParentModelId != model.Id || clone.ProblemId != model.ProblemId || clone.Name != "detector copy" {
+		test.Errorf("clone %+v of model %s", clone, model.Id.Hex())
+	}
+	if clone.Dir == model.Dir || fp.Dir(clone.Dir) != fp.Dir(model.Dir) {
+		test.Errorf("clone in %s, model in %s", clone.Dir, model.Dir)
+	}
+	if got := readFile(test, fp.Join(clone.Dir, "snapshot.pth")); got != "weights" {
+		test.Errorf("snapshot of the clone %q", got)
+	}
+	if models := f.store.Models(); len(models) != 2 {
+		test.Errorf("stored models %+v", models)
+	}
+	if len(f.store.SnapshotRefs(clone.Id)) == 0 {
+		test.Error("snapshot of the clone not referenced")
+	}
+}
+
+func TestCloneModelNameTaken(test *testing.T) {
+	f := newMemoryFixture(test)
+	model := f.importDetector(test)
+
+	if resp := f.clone(model, model.Name); resp.Err.Code != kitendpoint.ErrCodeInvalidArgument {
+		test.Fatalf("code %d (%s), want invalid argument", resp.Err.Code, resp.Err.Message)
+	}
+	if resp := f.clone(model, "_hidden"); resp.Err.Code != kitendpoint.ErrCodeInvalidArgument {
+		test.Fatalf("code %d (%s) for a hidden folder, want invalid argument", resp.Err.Code, resp.Err.Message)
+	}
+	if models := f.store.Models(); len(models) != 1 {
+		test.Errorf("stored models %+v", models)
+	}
+}
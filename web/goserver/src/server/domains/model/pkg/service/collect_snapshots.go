@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	snapshotDelete "server/db/pkg/handler/snapshot/delete"
+	snapshotFind "server/db/pkg/handler/snapshot/find"
+	t "server/db/pkg/types"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+)
+
+// CollectSnapshotsRequestData asks for the report only when DryRun is set.
+type CollectSnapshotsRequestData struct {
+	DryRun bool `json:"dryRun"`
+}
+
+// SnapshotCandidate is a stored snapshot no model refers to since
+// UnreferencedAt, for longer than the grace period or taken to bring the
+// store under its budget.
+type SnapshotCandidate struct {
+	Sha256         string    `json:"sha256"`
+	Blob           string    `json:"blob"`
+	Bytes          int64     `json:"bytes"`
+	UnreferencedAt time.Time `json:"unreferencedAt"`
+	Removed        bool      `json:"removed"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// CollectSnapshotsResponseData reports the snapshot store. StoredBytes is
+// what its snapshots take, DedupSavedBytes what the model files sharing them
+// would take on top without it.
+type CollectSnapshotsResponseData struct {
+	DryRun           bool                `json:"dryRun"`
+	Snapshots        int                 `json:"snapshots"`
+	StoredBytes      int64               `json:"storedBytes"`
+	DedupSavedBytes  int64               `json:"dedupSavedBytes"`
+	Candidates       []SnapshotCandidate `json:"candidates"`
+	ReclaimableBytes int64               `json:"reclaimableBytes"`
+	RemovedBytes     int64               `json:"removedBytes"`
+	Failed           int                 `json:"failed"`
+}
+
+// CollectSnapshots removes the stored snapshots unreferenced for longer than
+// the grace period of the cleanup, then those unreferenced the longest while
+// the store is above SnapshotMaxBytes, unless DryRun is set. A snapshot
+// referenced again in the meantime is kept. Only admins may run it when the
+// request carries an identity.
+func (s *basicModelService) CollectSnapshots(ctx context.Context, req CollectSnapshotsRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		if identity, ok := auth.FromContext(ctx); ok && !identity.HasRole(auth.RoleAdmin) {
+			err := fmt.Errorf("user %q is not allowed to collect the snapshots", identity.User)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeForbidden, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if !atomic.CompareAndSwapInt32(&s.collecting, 0, 1) {
+			err := fmt.Errorf("a snapshot collection is already running")
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeBusy, Message: err.Error()}, IsLast: true}
+			return
+		}
+		defer atomic.StoreInt32(&s.collecting, 0)
+		data, err := s.collectSnapshots(ctx, req.DryRun, s.collectBefore(), s.cleanupSettings.SnapshotMaxBytes)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// collectBefore is when the snapshots were last referenced for the grace
+// period to be over.
+func (s *basicModelService) collectBefore() time.Time {
+	return time.Now().Add(-s.cleanupSettings.GracePeriod)
+}
+
+// collectSnapshots removes the unreferenced snapshots, those unreferenced
+// since before first then, the oldest first, while the store takes more
+// than maxBytes, when it is set. The snapshots leased by imports are kept.
+func (s *basicModelService) collectSnapshots(ctx context.Context, dryRun bool, before time.Time, maxBytes int64) (CollectSnapshotsResponseData, error) {
+	data := CollectSnapshotsResponseData{DryRun: dryRun, Candidates: []SnapshotCandidate{}}
+	resp := <-snapshotFind.Send(ctx, s.Conn, snapshotFind.RequestData{})
+	if resp.Err.Code > 0 {
+		return data, errors.New(resp.Err.Message)
+	}
+	var unreferenced []t.Snapshot
+	for _, snapshot := range resp.Data.(snapshotFind.ResponseData).Items {
+		data.Snapshots++
+		data.StoredBytes += snapshot.Size
+		if len(snapshot.Refs) > 1 {
+			data.DedupSavedBytes += snapshot.Size * int64(len(snapshot.Refs)-1)
+		}
+		if len(snapshot.Refs) == 0 && !snapshot.UnreferencedAt.IsZero() {
+			unreferenced = append(unreferenced, snapshot)
+		}
+	}
+	sort.SliceStable(unreferenced, func(i, j int) bool {
+		return unreferenced[i].UnreferencedAt.Before(unreferenced[j].UnreferencedAt)
+	})
+	left := data.StoredBytes
+	for _, snapshot := range unreferenced {
+		aged := !snapshot.UnreferencedAt.After(before)
+		if !aged && (maxBytes <= 0 || left <= maxBytes) {
+			continue
+		}
+		if s.snapshotLeases.held(snapshot.Sha256) {
+			continue
+		}
+		// Under the budget, the snapshot goes unless referenced since it
+		// was listed.
+		until := before
+		if !aged {
+			until = snapshot.UnreferencedAt
+		}
+		candidate := SnapshotCandidate{Sha256: snapshot.Sha256, Blob: snapshot.Blob, Bytes: snapshot.Size, UnreferencedAt: snapshot.UnreferencedAt}
+		data.ReclaimableBytes += candidate.Bytes
+		left -= candidate.Bytes
+		if !dryRun {
+			if err := s.removeSnapshot(ctx, &candidate, until); err != nil {
+				log.Println("domains.model.pkg.service.collect_snapshots.removeSnapshot", candidate.Sha256, err)
+				candidate.Error = err.Error()
+				data.Failed++
+			} else if candidate.Removed {
+				data.RemovedBytes += candidate.Bytes
+			}
+		}
+		data.Candidates = append(data.Candidates, candidate)
+	}
+	return data, nil
+}
+
+// removeSnapshot drops the snapshot from the registry then removes it from
+// the store, unless it was referenced again.
+func (s *basicModelService) removeSnapshot(ctx context.Context, candidate *SnapshotCandidate, before time.Time) error {
+	if err := s.paths.CheckWrite(ctx, candidate.Blob); err != nil {
+		return err
+	}
+	resp := <-snapshotDelete.Send(ctx, s.Conn, snapshotDelete.RequestData{Sha256: candidate.Sha256, UnreferencedBefore: before})
+	if resp.Err.Code > 0 {
+		return errors.New(resp.Err.Message)
+	}
+	if !resp.Data.(snapshotDelete.ResponseData).Deleted {
+		return nil
+	}
+	if err := os.Remove(candidate.Blob); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	candidate.Removed = true
+	return nil
+}
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"sort"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v2"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/redact"
+)
+
+const (
+	// maxCompareConfigBytes bounds the configs compared line by line, larger
+	// ones are compared by hash.
+	maxCompareConfigBytes = 1 << 20
+	// maxConfigDiffBytes bounds the unified diff of the configs.
+	maxConfigDiffBytes = 64 << 10
+)
+
+// CompareRequestData compares the model ModelIdB to ModelIdA.
+type CompareRequestData struct {
+	ModelIdA primitive.ObjectID `json:"modelIdA"`
+	ModelIdB primitive.ObjectID `json:"modelIdB"`
+}
+
+type ComparedModel struct {
+	Id        primitive.ObjectID `json:"id"`
+	Name      string             `json:"name"`
+	ProblemId primitive.ObjectID `json:"problemId"`
+}
+
+// ValueDiff is a value of both models, by Name.
+type ValueDiff struct {
+	Name    string `json:"name"`
+	A       string `json:"a"`
+	B       string `json:"b"`
+	Differs bool   `json:"differs"`
+}
+
+// ConfigDiff compares the configs of the models. Diff is their unified diff,
+// cut at a bound when Truncated. Binary configs, and those too large, are
+// only compared by hash.
+type ConfigDiff struct {
+	Sha256A   string `json:"sha256A"`
+	Sha256B   string `json:"sha256B"`
+	Same      bool   `json:"same"`
+	Binary    bool   `json:"binary"`
+	Diff      string `json:"diff,omitempty"`
+	Truncated bool   `json:"truncated"`
+}
+
+// DependencyChange is a dependency of the models at Destination, the sha256
+// and size of a model being empty when it does not have it.
+type DependencyChange struct {
+	Destination string `json:"destination"`
+	Sha256A     string `json:"sha256A,omitempty"`
+	Sha256B     string `json:"sha256B,omitempty"`
+	SizeA       int    `json:"sizeA,omitempty"`
+	SizeB       int    `json:"sizeB,omitempty"`
+}
+
+// DependencyDiff compares the dependencies by destination, and their
+// content by sha256.
+type DependencyDiff struct {
+	Added     []DependencyChange `json:"added"`
+	Removed   []DependencyChange `json:"removed"`
+	Changed   []DependencyChange `json:"changed"`
+	Unchanged int                `json:"unchanged"`
+}
+
+// FileDiff compares a binary file of the models by hash.
+type FileDiff struct {
+	Name    string `json:"name"`
+	Sha256A string `json:"sha256A"`
+	Sha256B string `json:"sha256B"`
+	Same    bool   `json:"same"`
+}
+
+// MetricDelta is a metric of both models on a build, Delta being B - A when
+// both values are numbers.
+type MetricDelta struct {
+	Key         string   `json:"key"`
+	DisplayName string   `json:"displayName"`
+	Unit        string   `json:"unit"`
+	A           string   `json:"a"`
+	B           string   `json:"b"`
+	Delta       *float64 `json:"delta,omitempty"`
+}
+
+// BuildMetricsDiff compares the metrics of the models evaluated both on
+// BuildId, by key. Stale tells the evaluation of either model is stale.
+type BuildMetricsDiff struct {
+	BuildId primitive.ObjectID `json:"buildId"`
+	Stale   bool               `json:"stale"`
+	Metrics []MetricDelta      `json:"metrics"`
+}
+
+// CompareResponseData is the diff from model A to model B. DifferentProblems
+// warns that the models do not belong to the same problem, their metrics
+// being then hardly comparable.
+type CompareResponseData struct {
+	A                 ComparedModel      `json:"a"`
+	B                 ComparedModel      `json:"b"`
+	DifferentProblems bool               `json:"differentProblems"`
+	HyperParameters   []ValueDiff        `json:"hyperParameters"`
+	Config            ConfigDiff         `json:"config"`
+	Dependencies      DependencyDiff     `json:"dependencies"`
+	Files             []FileDiff         `json:"files"`
+	Metrics           []BuildMetricsDiff `json:"metrics"`
+}
+
+// Compare tells what differs between two models: their hyperparameters side
+// by side, their configs, dependencies, snapshot and weights, and their
+// metrics on the builds both were evaluated on. The caller must be a viewer
+// of the problems of both.
+func (s *basicModelService) Compare(ctx context.Context, req CompareRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		var models [2]t.Model
+		for i, id := range []primitive.ObjectID{req.ModelIdA, req.ModelIdB} {
+			modelResp := <-modelFindOne.Send(ctx, s.Conn, modelFindOne.RequestData{Id: id})
+			if modelResp.Err.Code > 0 {
+				returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: modelResp.Err.Message}, IsLast: true}
+				return
+			}
+			models[i] = s.localModel(modelResp.Data.(modelFindOne.ResponseData))
+			if models[i].Id.IsZero() {
+				err := fmt.Errorf("model %s not found", id.Hex())
+				returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+				return
+			}
+			if err := access.Check(ctx, s.Conn, models[i].ProblemId, role.Viewer); err != nil {
+				returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}, IsLast: true}
+				return
+			}
+		}
+		a, b := models[0], models[1]
+		data := CompareResponseData{
+			A:                 ComparedModel{Id: a.Id, Name: a.Name, ProblemId: a.ProblemId},
+			B:                 ComparedModel{Id: b.Id, Name: b.Name, ProblemId: b.ProblemId},
+			DifferentProblems: a.ProblemId != b.ProblemId,
+			HyperParameters:   compareValues(s.hyperParameters(ctx, a), s.hyperParameters(ctx, b)),
+			Config:            s.compareConfigs(ctx, a, b),
+			Dependencies:      compareDependencies(a.Dependencies, b.Dependencies),
+			Files: []FileDiff{
+				s.compareFiles(ctx, "snapshot", a, a.SnapshotPath, b, b.SnapshotPath),
+				s.compareFiles(ctx, "weights", a, a.WeightsPath, b, b.WeightsPath),
+			},
+			Metrics: compareMetrics(a.Evaluates, b.Evaluates),
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// hyperParameters are those the model was imported or trained with, then
+// the other ones of its template, by their yaml path.
+func (s *basicModelService) hyperParameters(ctx context.Context, model t.Model) map[string]string {
+	values := make(map[string]string)
+	if model.TemplatePath != "" && s.paths.CheckRead(ctx, model.TemplatePath) == nil {
+		if b, err := ioutil.ReadFile(model.TemplatePath); err != nil {
+			redact.Println("compare.hyperParameters.ioutil.ReadFile(model.TemplatePath)", err)
+		} else {
+			var template struct {
+				HyperParameters map[interface{}]interface{} `yaml:"hyper_parameters"`
+			}
+			// A template of several models only keeps the first one here.
+			if err := yaml.NewDecoder(bytes.NewReader(b)).Decode(&template); err != nil && err != io.EOF {
+				redact.Println("compare.hyperParameters.yaml.Decode", err)
+			}
+			flattenValues("", template.HyperParameters, values)
+		}
+	}
+	values["basic.batch_size"] = strconv.Itoa(model.BatchSize)
+	values["basic.epochs"] = strconv.Itoa(model.Epochs)
+	values["gpu_num"] = strconv.Itoa(model.TrainingGpuNum)
+	values["framework"] = model.Framework
+	return values
+}
+
+// flattenValues puts the leaves of the yaml mapping m into values by their
+// dotted path under prefix.
+func flattenValues(prefix string, m map[interface{}]interface{}, values map[string]string) {
+	for k, v := range m {
+		name := fmt.Sprint(k)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if nested, ok := v.(map[interface{}]interface{}); ok {
+			flattenValues(name, nested, values)
+			continue
+		}
+		values[name] = fmt.Sprint(v)
+	}
+}
+
+// compareValues lists the values of a and b by name, a value one of them
+// does not have being empty.
+func compareValues(a, b map[string]string) []ValueDiff {
+	names := make(map[string]bool)
+	for name := range a {
+		names[name] = true
+	}
+	for name := range b {
+		names[name] = true
+	}
+	diffs := make([]ValueDiff, 0, len(names))
+	for name := range names {
+		_, inA := a[name]
+		_, inB := b[name]
+		diffs = append(diffs, ValueDiff{Name: name, A: a[name], B: b[name], Differs: inA != inB || a[name] != b[name]})
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Name < diffs[j].Name })
+	return diffs
+}
+
+func (s *basicModelService) compareConfigs(ctx context.Context, a, b t.Model) ConfigDiff {
+	var diff ConfigDiff
+	contentA, binaryA := s.readComparedFile(ctx, a.ConfigPath)
+	contentB, binaryB := s.readComparedFile(ctx, b.ConfigPath)
+	diff.Sha256A, diff.Sha256B = s.modelFileSha256(ctx, a, a.ConfigPath), s.modelFileSha256(ctx, b, b.ConfigPath)
+	diff.Same = diff.Sha256A == diff.Sha256B
+	diff.Binary = binaryA || binaryB
+	if diff.Same || diff.Binary {
+		return diff
+	}
+	diff.Diff, diff.Truncated = unifiedDiff("a/"+fp.Base(a.ConfigPath), "b/"+fp.Base(b.ConfigPath), string(contentA), string(contentB), maxConfigDiffBytes)
+	return diff
+}
+
+// readComparedFile reads the file at path to compare it line by line, or
+// tells it is binary, or too large, to be compared by hash.
+func (s *basicModelService) readComparedFile(ctx context.Context, path string) ([]byte, bool) {
+	if path == "" {
+		return nil, false
+	}
+	if err := s.paths.CheckRead(ctx, path); err != nil {
+		return nil, false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(f, maxCompareConfigBytes+1))
+	if err != nil {
+		redact.Println("compare.readComparedFile.ioutil.ReadAll", path, err)
+		return nil, true
+	}
+	if len(content) > maxCompareConfigBytes || bytes.IndexByte(content, 0) >= 0 {
+		return nil, true
+	}
+	return content, false
+}
+
+// modelFileSha256 is the sha256 of the file of the model at path, taken from
+// its manifest when it has one. It is empty when the file can not be read.
+func (s *basicModelService) modelFileSha256(ctx context.Context, model t.Model, path string) string {
+	if path == "" || s.paths.CheckRead(ctx, path) != nil {
+		return ""
+	}
+	if manifest, err := readManifest(model.Dir); err == nil && manifest != nil {
+		if rel, err := fp.Rel(model.Dir, path); err == nil {
+			if sha, ok := manifest[fp.ToSlash(rel)]; ok {
+				return sha
+			}
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return getSha265(path)
+}
+
+func (s *basicModelService) compareFiles(ctx context.Context, name string, a t.Model, pathA string, b t.Model, pathB string) FileDiff {
+	diff := FileDiff{Name: name, Sha256A: s.modelFileSha256(ctx, a, pathA), Sha256B: s.modelFileSha256(ctx, b, pathB)}
+	diff.Same = diff.Sha256A == diff.Sha256B
+	return diff
+}
+
+func compareDependencies(a, b []t.Dependency) DependencyDiff {
+	diff := DependencyDiff{Added: []DependencyChange{}, Removed: []DependencyChange{}, Changed: []DependencyChange{}}
+	byDestination := make(map[string]t.Dependency, len(a))
+	for _, d := range a {
+		byDestination[fp.Clean(d.Destination)] = d
+	}
+	for _, d := range b {
+		destination := fp.Clean(d.Destination)
+		old, ok := byDestination[destination]
+		if !ok {
+			diff.Added = append(diff.Added, DependencyChange{Destination: d.Destination, Sha256B: d.Sha256, SizeB: d.Size})
+			continue
+		}
+		delete(byDestination, destination)
+		if old.Sha256 == d.Sha256 && old.Size == d.Size {
+			diff.Unchanged++
+			continue
+		}
+		diff.Changed = append(diff.Changed, DependencyChange{Destination: d.Destination, Sha256A: old.Sha256, Sha256B: d.Sha256, SizeA: old.Size, SizeB: d.Size})
+	}
+	for _, d := range a {
+		if _, ok := byDestination[fp.Clean(d.Destination)]; ok {
+			diff.Removed = append(diff.Removed, DependencyChange{Destination: d.Destination, Sha256A: d.Sha256, SizeA: d.Size})
+		}
+	}
+	return diff
+}
+
+// compareMetrics compares the metrics of the builds both models were
+// evaluated on, the builds in order.
+func compareMetrics(a, b map[string]t.Evaluate) []BuildMetricsDiff {
+	diffs := []BuildMetricsDiff{}
+	for hex, evaluateA := range a {
+		evaluateB, ok := b[hex]
+		if !ok {
+			continue
+		}
+		buildId, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			continue
+		}
+		diff := BuildMetricsDiff{BuildId: buildId, Stale: evaluateA.Stale || evaluateB.Stale, Metrics: []MetricDelta{}}
+		metricsB := make(map[string]t.Metric, len(evaluateB.Metrics))
+		for _, m := range evaluateB.Metrics {
+			metricsB[m.Key] = m
+		}
+		for _, m := range evaluateA.Metrics {
+			mB, ok := metricsB[m.Key]
+			if !ok {
+				continue
+			}
+			delta := MetricDelta{Key: m.Key, DisplayName: m.DisplayName, Unit: m.Unit, A: m.Value, B: mB.Value}
+			valueA, errA := strconv.ParseFloat(m.Value, 64)
+			valueB, errB := strconv.ParseFloat(mB.Value, 64)
+			if errA == nil && errB == nil {
+				d := valueB - valueA
+				delta.Delta = &d
+			}
+			diff.Metrics = append(diff.Metrics, delta)
+		}
+		diffs = append(diffs, diff)
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].BuildId.Hex() < diffs[j].BuildId.Hex() })
+	return diffs
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// configBase matches the _base_ entry of an mmcv config, a string or a
+	// list of strings.
+	configBase = regexp.MustCompile(`(?m)^\s*_base_\s*=\s*(\[[^\]]*\]|'[^'\n]*'|"[^"\n]*")`)
+	// configString matches the quoted strings of a config.
+	configString = regexp.MustCompile(`'([^'\n]*)'|"([^"\n]*)"`)
+)
+
+// configReferences lists the files the config refers to by a relative path:
+// its bases and the strings starting with ./ or ../. Patterns and format
+// strings are skipped, they name no file by themselves.
+func configReferences(content string) []string {
+	var refs []string
+	for _, base := range configBase.FindAllStringSubmatch(content, -1) {
+		refs = append(refs, quotedStrings(base[1])...)
+	}
+	for _, s := range quotedStrings(content) {
+		if strings.HasPrefix(s, "./") || strings.HasPrefix(s, "../") {
+			refs = append(refs, s)
+		}
+	}
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, ref := range refs {
+		if ref == "" || seen[ref] || fp.IsAbs(ref) || strings.Contains(ref, "://") || strings.ContainsAny(ref, "{}*?%") {
+			continue
+		}
+		seen[ref] = true
+		result = append(result, ref)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func quotedStrings(s string) []string {
+	var result []string
+	for _, m := range configString.FindAllStringSubmatch(s, -1) {
+		result = append(result, m[1]+m[2])
+	}
+	return result
+}
+
+// checkConfigReferences returns a message per file the config at configPath
+// refers to that is missing from modelDir, the references leaving the folder
+// included.
+func checkConfigReferences(configPath, modelDir string) ([]string, error) {
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, ref := range configReferences(string(content)) {
+		path := fp.Join(fp.Dir(configPath), ref)
+		rel, err := fp.Rel(modelDir, path)
+		if err != nil || !isInsideDir(rel) {
+			missing = append(missing, fmt.Sprintf("config %s refers to %s, outside of the model folder", fp.Base(configPath), ref))
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			missing = append(missing, fmt.Sprintf("config %s refers to %s, which is not in the model folder, declare it as a dependency", fp.Base(configPath), ref))
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var placeholderNameRegexp = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// Placeholder is a value that differs from one deployment to the other, such
+// as the path of a dataset, the config of the template holding ${Name} where
+// it goes. The import request gives the value, Default being taken when it
+// does not, unless the placeholder is Required.
+type Placeholder struct {
+	Name     string `yaml:"name"`
+	Required bool   `yaml:"required,omitempty"`
+	Default  string `yaml:"default,omitempty"`
+}
+
+func (p Placeholder) token() string {
+	return "${" + p.Name + "}"
+}
+
+// placeholderValues are the values of the placeholders of the template, taken
+// from supplied, which may hold those of other templates, or the defaults.
+// It fails naming all the required placeholders missing, or when the config
+// the placeholders go in is not a file.
+func (m ModelYml) placeholderValues(from string, supplied map[string]string) (map[string]string, error) {
+	if len(m.Placeholders) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]string, len(m.Placeholders))
+	var missing []string
+	for _, p := range m.Placeholders {
+		value, ok := supplied[p.Name]
+		if !ok {
+			if p.Required {
+				missing = append(missing, p.Name)
+				continue
+			}
+			value = p.Default
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("model %q: placeholder %s must be on one line", m.Name, p.Name)
+		}
+		values[p.Name] = value
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("model %q: missing required placeholders %s", m.Name, strings.Join(missing, ", "))
+	}
+	info, err := os.Stat(sourcePath(from, m.Config))
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("model %q: placeholders need the config %s to be a file", m.Name, m.Config)
+	}
+	return values, nil
+}
+
+// copyConfig copies the config of the template into the model folder, its
+// placeholders replaced by values.
+func copyConfig(ctx context.Context, from string, doc templateDocument, diff *importDiff) error {
+	if len(doc.Placeholders) == 0 {
+		_, err := diff.copy(ctx, sourcePath(from, doc.Config), doc.configName(), OverwriteChanged)
+		return err
+	}
+	config, err := ioutil.ReadFile(sourcePath(from, doc.Config))
+	if err != nil {
+		return err
+	}
+	return diff.write(ctx, doc.configName(), substitutePlaceholders(config, doc.Placeholders, doc.placeholders))
+}
+
+// substitutePlaceholders replaces the tokens of placeholders in config by
+// their values.
+func substitutePlaceholders(config []byte, placeholders []Placeholder, values map[string]string) []byte {
+	var pairs []string
+	for _, p := range placeholders {
+		pairs = append(pairs, p.token(), values[p.Name])
+	}
+	return []byte(strings.NewReplacer(pairs...).Replace(string(config)))
+}
+
+// checkPlaceholders lints the placeholders declared by the template.
+func checkPlaceholders(modelYml ModelYml, result *ValidateTemplateResponseData) {
+	names := make(map[string]bool)
+	for i, p := range modelYml.Placeholders {
+		field := fmt.Sprintf("placeholders[%d]", i)
+		if !placeholderNameRegexp.MatchString(p.Name) {
+			result.error(field+".name", "%q must be upper case letters, digits and _", p.Name)
+		} else if names[p.Name] {
+			result.error(field+".name", "%q is declared more than once", p.Name)
+		}
+		names[p.Name] = true
+		if p.Required && p.Default != "" {
+			result.warning(field+".default", "is never used, %s is required", p.Name)
+		}
+	}
+}
@@ -14,10 +14,11 @@ import (
 	buildInsertOne "server/db/pkg/handler/build/insert_one"
 	modelFindOne "server/db/pkg/handler/model/find_one"
 	modelInsertOne "server/db/pkg/handler/model/insert_one"
-	modelUpdateOne "server/db/pkg/handler/model/update_one"
+	modelUpdateStatus "server/db/pkg/handler/model/update_status"
 	problemFindOne "server/db/pkg/handler/problem/find_one"
 	t "server/db/pkg/types"
 	buildStatus "server/db/pkg/types/build/status"
+	statusModelEvaluate "server/db/pkg/types/status/model/evaluate"
 	statusModelTrain "server/db/pkg/types/status/model/train"
 	kitendpoint "server/kit/endpoint"
 	u "server/kit/utils"
@@ -36,22 +37,49 @@ func (s *basicModelService) CreateFromGeneric(ctx context.Context, req CreateFro
 		defer close(returnChan)
 		genericModel, defaultBuild, problem := s.getGenericModelDefaultBuildProblem(req.GenericModelId, req.ProblemId)
 		modelDirPath := createModelDirPath(problem, genericModel.Name)
+		from := []string{genericModel.Dir, genericModel.SnapshotPath, genericModel.TemplatePath}
+		if genericModel.WeightsPath != "" {
+			from = append(from, genericModel.WeightsPath)
+		}
+		if err := s.checkCopy(ctx, modelDirPath, from...); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: err.Error()}, IsLast: true}
+			return
+		}
+		unlock, err := s.lockDir(ctx, modelDirPath, "copy")
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: dirLockErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		defer unlock()
 		modelSnapshotPath := copySnapshot(genericModel.SnapshotPath, modelDirPath)
-		model := s.createModelFromGeneric(genericModel, problem, modelDirPath, modelSnapshotPath)
-		copyModelFilesFromParentModel(genericModel.Dir, model.Dir, genericModel.TemplatePath, []string{})
-		model = s.eval(ctx, model, defaultBuild, problem, false)
+		modelWeightsPath := ""
+		if genericModel.WeightsPath != "" {
+			modelWeightsPath = copySnapshot(genericModel.WeightsPath, modelDirPath)
+		}
+		model := s.createModelFromGeneric(genericModel, problem, modelDirPath, modelSnapshotPath, modelWeightsPath)
+		s.copyModelFilesFromParentModel(ctx, genericModel.Dir, model.Dir, genericModel.TemplatePath, []string{})
+		s.publish(ctx, model.Dir, nil)
+		s.registerSnapshots(ctx, model)
+		model, err = s.eval(ctx, model, defaultBuild, problem, false)
+		if err != nil {
+			log.Println("domains.model.pkg.service.create_from_generic.CreateFromGeneric.eval", err)
+		}
 		returnChan <- kitendpoint.Response{Data: model, Err: kitendpoint.Error{Code: 0}, IsLast: true}
 	}()
 	return returnChan
 }
 
-func copyModelFilesFromParentModel(from, to, modelTemplatePath string, excluded []string) {
+func (s *basicModelService) copyModelFilesFromParentModel(ctx context.Context, from, to, modelTemplatePath string, excluded []string) {
 	templateYamlPath := copyTemplateYaml(modelTemplatePath, to)
 	templateYaml := getTemplateYaml(templateYamlPath)
 	copyModulesYaml(from, to)
-	copyConfig(from, to, templateYaml)
+	if err := copyFiles(fp.Join(from, templateYaml.configName()), fp.Join(to, templateYaml.configName())); err != nil {
+		log.Println("create_from_generic.copyModelFilesFromParentModel.copyFiles(fp.Join(from, templateYaml.configName()), fp.Join(to, templateYaml.configName()))", err)
+	}
 	copyDependenciesFromParentModel(from, to, templateYaml, excluded)
-	saveMetrics(to, templateYaml)
+	if err := s.saveMetrics(ctx, to, templateYaml); err != nil {
+		log.Println("create_from_generic.copyModelFilesFromParentModel.saveMetrics(to, templateYaml)", err)
+	}
 }
 
 func copyDependenciesFromParentModel(from, to string, modelYml ModelYml, excluded []string) {
@@ -68,7 +96,13 @@ func copyDependenciesFromParentModel(from, to string, modelYml ModelYml, exclude
 	}
 }
 
-func (s *basicModelService) updateModelEvaluateStatus(ctx context.Context, model t.Model, buildId primitive.ObjectID, status string) t.Model {
+// updateModelEvaluateStatus saves the status of the evaluation of the model on
+// the build, refusing the transitions statusModelEvaluate does not allow.
+func (s *basicModelService) updateModelEvaluateStatus(ctx context.Context, model t.Model, buildId primitive.ObjectID, status string) (t.Model, error) {
+	if err := statusModelEvaluate.CheckTransition(model.Evaluates[buildId.Hex()].Status, status); err != nil {
+		log.Println("domains.model.pkg.service.create_from_generic.updateModelEvaluateStatus", model.Name, err)
+		return model, fmt.Errorf("model %s: %v", model.Name, err)
+	}
 	if model.Evaluates == nil {
 		model.Evaluates = make(map[string]t.Evaluate)
 	}
@@ -77,13 +111,15 @@ func (s *basicModelService) updateModelEvaluateStatus(ctx context.Context, model
 		Status:  status,
 	}
 	log.Println("updateModelEvaluateStatus", model.Evaluates)
-	modelUpdateOneResp := <-modelUpdateOne.Send(ctx, s.Conn, model)
-	log.Println("updateModelEvaluateStatus", modelUpdateOneResp.Data.(modelUpdateOne.ResponseData).Evaluates)
-	return modelUpdateOneResp.Data.(modelUpdateOne.ResponseData)
+	return s.saveStatus(ctx, model, modelUpdateStatus.RequestData{
+		Id:        model.Id,
+		Evaluates: map[string]t.Evaluate{buildId.Hex(): model.Evaluates[buildId.Hex()]},
+	}, status != statusModelEvaluate.InProgress)
 }
 
-func (s *basicModelService) createModelFromGeneric(genericModel t.Model, problem t.Problem, dir, snapshotPath string) t.Model {
-	modelInsertOneResp := <-modelInsertOne.Send(context.TODO(), s.Conn, modelInsertOne.RequestData{
+func (s *basicModelService) createModelFromGeneric(genericModel t.Model, problem t.Problem, dir, snapshotPath, weightsPath string) t.Model {
+	modelInsertOneResp := <-modelInsertOne.Send(context.TODO(), s.Conn, s.storedModelWithoutId(modelInsertOne.RequestData{
+		Cas:             isSet(s.imports.Options().Cas),
 		ConfigPath:      fp.Join(dir, "model.py"),
 		Dir:             dir,
 		Evaluates:       make(map[string]t.Evaluate),
@@ -95,13 +131,15 @@ func (s *basicModelService) createModelFromGeneric(genericModel t.Model, problem
 			Train: fp.Join(dir, "train.py"),
 			Eval:  fp.Join(dir, "eval.py"),
 		},
+		SnapshotFormat: genericModel.SnapshotFormat,
 		SnapshotPath:   snapshotPath,
 		Status:         statusModelTrain.Default,
 		TemplatePath:   fp.Join(dir, "template.yaml"),
 		TrainingGpuNum: genericModel.TrainingGpuNum,
-	})
+		WeightsPath:    weightsPath,
+	}))
 
-	return modelInsertOneResp.Data.(modelInsertOne.ResponseData)
+	return s.localModel(modelInsertOneResp.Data.(modelInsertOne.ResponseData))
 }
 
 func getClasses(labels []map[string]interface{}) string {
@@ -125,7 +163,7 @@ func (s *basicModelService) getGenericModelDefaultBuildProblem(genericModelId, p
 	})
 	problemFindOneResp, modelFindOneResp, buildFindOneResp := <-problemFindOneChan, <-modelFindOneChan, <-buildFindOneChan
 	problem := problemFindOneResp.Data.(problemFindOne.ResponseData)
-	model := modelFindOneResp.Data.(modelFindOne.ResponseData)
+	model := s.localModel(modelFindOneResp.Data.(modelFindOne.ResponseData))
 	build := buildFindOneResp.Data.(buildFindOne.ResponseData)
 	if build.Id.IsZero() {
 		buildInsertOneResp := <-buildInsertOne.Send(context.TODO(), s.Conn, buildInsertOne.RequestData{
@@ -6,6 +6,9 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	modelDelete "server/db/pkg/handler/model/delete"
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
 	kitendpoint "server/kit/endpoint"
 )
 
@@ -14,12 +17,42 @@ type DeleteRequestData struct {
 }
 
 func (s *basicModelService) Delete(ctx context.Context, req DeleteRequestData, responseChan chan kitendpoint.Response) {
-	modelDeleteResp := <-modelDelete.Send(
+	unlock, err := s.lockModel(ctx, req.Id, "deletion")
+	if err != nil {
+		responseChan <- kitendpoint.Response{Data: nil, IsLast: true, Err: kitendpoint.Error{Code: dirLockErrCode(err), Message: err.Error()}}
+		return
+	}
+	defer unlock()
+	found, err := s.repos.Models.FindModel(ctx, modelFindOne.RequestData{Id: req.Id})
+	if err != nil {
+		responseChan <- kitendpoint.Response{Data: nil, IsLast: true, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}}
+		return
+	}
+	model := s.localModel(found)
+	if !model.Id.IsZero() {
+		if err := access.Check(ctx, s.Conn, model.ProblemId, role.Editor); err != nil {
+			responseChan <- kitendpoint.Response{Data: nil, IsLast: true, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}}
+			return
+		}
+		unlockDir, err := s.lockDir(ctx, model.Dir, "deletion")
+		if err != nil {
+			responseChan <- kitendpoint.Response{Data: nil, IsLast: true, Err: kitendpoint.Error{Code: dirLockErrCode(err), Message: err.Error()}}
+			return
+		}
+		defer unlockDir()
+	}
+	deleted, err := s.repos.Models.DeleteModel(
 		ctx,
-		s.Conn,
 		modelDelete.RequestData{
 			Id: req.Id,
 		},
 	)
-	responseChan <- modelDeleteResp
+	if err != nil {
+		responseChan <- kitendpoint.Response{Data: nil, IsLast: true, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}}
+		return
+	}
+	if !model.Id.IsZero() {
+		s.unregisterSnapshots(ctx, model.Id)
+	}
+	responseChan <- kitendpoint.Response{Data: deleted, IsLast: true, Err: kitendpoint.Error{Code: 0}}
 }
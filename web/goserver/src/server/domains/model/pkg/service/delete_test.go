@@ -0,0 +1,62 @@
+package service_test
+
+import (
+	"context"
+	fp "path/filepath"
+	"testing"
+
+	lockAcquire "server/db/pkg/handler/lock/acquire"
+	modelDelete "server/db/pkg/handler/model/delete"
+	t "server/db/pkg/types"
+	"server/domains/model/pkg/service"
+	kitendpoint "server/kit/endpoint"
+)
+
+func (f memoryFixture) delete(model t.Model) kitendpoint.Response {
+	responses := make(chan kitendpoint.Response, 1)
+	f.service.Delete(context.Background(), service.DeleteRequestData{Id: model.Id}, responses)
+	return <-responses
+}
+
+func TestDelete(test *testing.T) {
+	f := newMemoryFixture(test)
+	model := f.importDetector(test)
+
+	resp := f.delete(model)
+	if resp.Err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("code %d (%s)", resp.Err.Code, resp.Err.Message)
+	}
+	if deleted := resp.Data.(modelDelete.ResponseData); deleted.Id != model.Id {
+		test.Errorf("deleted %s, want %s", deleted.Id.Hex(), model.Id.Hex())
+	}
+	if models := f.store.Models(); len(models) != 0 {
+		test.Errorf("stored models %+v", models)
+	}
+	if refs := f.store.SnapshotRefs(model.Id); len(refs) != 0 {
+		test.Errorf("snapshots still referenced %+v", refs)
+	}
+
+	// Deleting it again is not an error, as with the database.
+	if resp := f.delete(model); resp.Err.Code != kitendpoint.ErrCodeOk {
+		test.Errorf("second deletion: code %d (%s)", resp.Err.Code, resp.Err.Message)
+	}
+}
+
+func TestDeleteWaitsForTheFolderLock(test *testing.T) {
+	f := newMemoryFixture(test)
+	model := f.importDetector(test)
+	key, err := fp.Rel(f.problems, model.Dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if held, err := f.store.AcquireLock(context.Background(), lockAcquire.RequestData{Key: fp.ToSlash(key), Owner: "reimport", Holder: "import", TtlMillis: 60000}); err != nil || !held.Acquired {
+		test.Fatal("folder lock not taken", err)
+	}
+
+	if resp := f.delete(model); resp.Err.Code != kitendpoint.ErrCodeBusy {
+		test.Fatalf("code %d (%s), want busy", resp.Err.Code, resp.Err.Message)
+	}
+	if models := f.store.Models(); len(models) != 1 {
+		test.Errorf("model deleted during the reimport: %+v", models)
+	}
+}
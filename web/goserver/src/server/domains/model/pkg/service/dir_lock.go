@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	lockAcquire "server/db/pkg/handler/lock/acquire"
+	lockRefresh "server/db/pkg/handler/lock/refresh"
+	lockRelease "server/db/pkg/handler/lock/release"
+	t "server/db/pkg/types"
+	kitendpoint "server/kit/endpoint"
+)
+
+const (
+	// dirLockTtl is how long the lock of a model folder outlives the last
+	// heartbeat of its owner, that is how long the folder stays locked after
+	// the replica holding it died.
+	dirLockTtl = time.Minute
+	// dirLockHeartbeat is how often the locks held are refreshed.
+	dirLockHeartbeat = dirLockTtl / 3
+)
+
+// lockOwnerPrefix names this replica in the owners of its locks, which flock
+// can not be trusted with on NFS.
+var lockOwnerPrefix = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}()
+
+// DirLockedError is a model folder locked by another request, of this
+// replica or of another one. It is worth retrying once the lock is freed.
+type DirLockedError struct {
+	Dir  string
+	Lock t.Lock
+}
+
+func (e *DirLockedError) Error() string {
+	return fmt.Sprintf("model folder %s is busy with the %s of %s since %s, retry later", e.Dir, e.Lock.Holder, e.Lock.Owner, e.Lock.AcquiredAt.Format(time.RFC3339))
+}
+
+// dirLockErrCode maps the error of lockDir or lockModel to the response
+// error code.
+func dirLockErrCode(err error) int {
+	var locked *DirLockedError
+	var modelLocked *ModelLockedError
+	if errors.As(err, &locked) || errors.As(err, &modelLocked) {
+		return kitendpoint.ErrCodeBusy
+	}
+	return kitendpoint.ErrCodeUnknown
+}
+
+// lockDir takes the lock of the model folder dir for holder, a short
+// description of the request, failing at once with a DirLockedError when
+// another request holds it. The lock is kept alive until unlock is called,
+// a replica dying with it leaving it to expire after dirLockTtl. The lock
+// of an operation is released as well when the operation stalls.
+func (s *basicModelService) lockDir(ctx context.Context, dir, holder string) (unlock func(), err error) {
+	key := dir
+	if k, ok := s.storageKey(dir); ok {
+		// The replicas may mount the problem folder at different paths.
+		key = k
+	}
+	unlock, held, err := s.acquireLock(ctx, key, dir, holder)
+	if err != nil {
+		return nil, err
+	}
+	if held != nil {
+		return nil, &DirLockedError{Dir: dir, Lock: *held}
+	}
+	return unlock, nil
+}
+
+// acquireLock takes the database lock key, named what in the messages, for
+// holder and keeps it alive until unlock is called. The lock held by
+// another request is returned instead when there is one.
+func (s *basicModelService) acquireLock(ctx context.Context, key, what, holder string) (unlock func(), held *t.Lock, err error) {
+	owner := lockOwnerPrefix + "/" + primitive.NewObjectID().Hex()
+	acquired, err := s.repos.Locks.AcquireLock(ctx, lockAcquire.RequestData{Key: key, Owner: owner, Holder: holder, TtlMillis: dirLockTtl.Milliseconds(), Operation: operationIdFrom(ctx)})
+	if err != nil {
+		return nil, nil, fmt.Errorf("lock of %s: %v", what, err)
+	}
+	if !acquired.Acquired {
+		return nil, &acquired.Lock, nil
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(dirLockHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			refreshed, err := s.repos.Locks.RefreshLock(context.Background(), lockRefresh.RequestData{Key: key, Owner: owner, TtlMillis: dirLockTtl.Milliseconds()})
+			if err != nil {
+				log.Println("domains.model.pkg.service.dir_lock.acquireLock.RefreshLock", what, err)
+			} else if !refreshed.Refreshed {
+				log.Println("domains.model.pkg.service.dir_lock.acquireLock: lock lost", what, holder)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		if _, err := s.repos.Locks.ReleaseLock(context.Background(), lockRelease.RequestData{Key: key, Owner: owner}); err != nil {
+			log.Println("domains.model.pkg.service.dir_lock.acquireLock.ReleaseLock", what, err)
+		}
+	}, nil, nil
+}
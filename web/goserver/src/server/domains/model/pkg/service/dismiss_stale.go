@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	modelUpdateStatus "server/db/pkg/handler/model/update_status"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+)
+
+// DismissStaleRequestData dismisses the staleness of the evaluation of the
+// model on the build, until the model is evaluated on it again.
+type DismissStaleRequestData struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+	BuildId primitive.ObjectID `json:"buildId"`
+}
+
+func (s *basicModelService) DismissStale(ctx context.Context, req DismissStaleRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		if err := s.statuses.flush(ctx, req.ModelId); err != nil {
+			log.Println("domains.model.pkg.service.dismiss_stale.DismissStale.s.statuses.flush", err)
+		}
+		modelResp := <-modelFindOne.Send(ctx, s.Conn, modelFindOne.RequestData{Id: req.ModelId})
+		model := s.localModel(modelResp.Data.(modelFindOne.ResponseData))
+		if model.Id.IsZero() {
+			err := fmt.Errorf("model %s not found", req.ModelId.Hex())
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if err := access.Check(ctx, s.Conn, model.ProblemId, role.Editor); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		evaluate, ok := model.Evaluates[req.BuildId.Hex()]
+		if !ok {
+			err := fmt.Errorf("model %s was not evaluated on build %s", model.Name, req.BuildId.Hex())
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		evaluate.Stale = false
+		evaluate.StaleDismissed = true
+		model, err := s.saveStatus(ctx, model, modelUpdateStatus.RequestData{
+			Id:        model.Id,
+			Evaluates: map[string]t.Evaluate{req.BuildId.Hex(): evaluate},
+		}, true)
+		if err != nil {
+			log.Println("domains.model.pkg.service.dismiss_stale.DismissStale.s.saveStatus", err)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: model, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
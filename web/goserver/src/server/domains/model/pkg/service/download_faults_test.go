@@ -0,0 +1,221 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	fp "path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"server/kit/faults"
+	u "server/kit/utils"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// faultyDownload serves a file of 4096 bytes through d, installed for the
+// time of the test, and returns its url, its sha256 and its content.
+func faultyDownload(test *testing.T, d *faults.Doer) (url, sha string, content []byte) {
+	content = bytes.Repeat([]byte("weights "), 512)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	test.Cleanup(server.Close)
+	d.Base = server.Client()
+	test.Cleanup(u.SetDownloadDoer(d))
+	h := sha256.Sum256(content)
+	return server.URL + "/weights.bin", hex.EncodeToString(h[:]), content
+}
+
+func downloadDir(test *testing.T) string {
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		test.Fatal(err)
+	}
+	test.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// checkDownloaded checks that dst holds content and is alone in its folder.
+func checkDownloaded(test *testing.T, dst string, content []byte) {
+	b, err := ioutil.ReadFile(dst)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		test.Errorf("downloaded %d bytes differing from the %d served", len(b), len(content))
+	}
+	checkNoTemp(test, fp.Dir(dst), 1)
+}
+
+func checkNoTemp(test *testing.T, dir string, want int) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(infos) != want {
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		test.Errorf("files %v, want %d", names, want)
+	}
+}
+
+func faultOptions(attempts int) ImportOptions {
+	return ImportOptions{MaxAttempts: attempts, Verify: VerifyStrict, Overwrite: OverwriteChanged}
+}
+
+func TestDownloadRetriesRefusedConnections(test *testing.T) {
+	d := &faults.Doer{FailRequests: 2}
+	url, sha, content := faultyDownload(test, d)
+	dst := fp.Join(downloadDir(test), "weights.bin")
+
+	if _, err := downloadWithCheck(context.Background(), url, dst, sha, len(content), false, faultOptions(3), validators{}); err != nil {
+		test.Fatal(err)
+	}
+	if d.Requests() != 3 {
+		test.Errorf("%d requests, want 3", d.Requests())
+	}
+	checkDownloaded(test, dst, content)
+}
+
+func TestDownloadGivesUpAfterMaxAttempts(test *testing.T) {
+	d := &faults.Doer{FailRequests: 5}
+	url, sha, content := faultyDownload(test, d)
+	dir := downloadDir(test)
+
+	_, err := downloadWithCheck(context.Background(), url, fp.Join(dir, "weights.bin"), sha, len(content), false, faultOptions(3), validators{})
+	if !errors.Is(err, faults.ErrInjected) {
+		test.Fatalf("error %v, want the injected one", err)
+	}
+	if d.Requests() != 3 {
+		test.Errorf("%d requests, want 3", d.Requests())
+	}
+	checkNoTemp(test, dir, 0)
+}
+
+// A dropped connection leaves no partial file behind, the import run
+// again downloads the file whole.
+func TestDownloadResumesAfterADroppedConnection(test *testing.T) {
+	d := &faults.Doer{DropAfter: 1000}
+	url, sha, content := faultyDownload(test, d)
+	dir := downloadDir(test)
+	dst := fp.Join(dir, "weights.bin")
+
+	if _, err := downloadWithCheck(context.Background(), url, dst, sha, len(content), false, faultOptions(2), validators{}); err == nil {
+		test.Fatal("dropped download succeeded")
+	}
+	checkNoTemp(test, dir, 0)
+
+	d.DropAfter = 0
+	if _, err := downloadWithCheck(context.Background(), url, dst, sha, len(content), false, faultOptions(2), validators{}); err != nil {
+		test.Fatal(err)
+	}
+	checkDownloaded(test, dst, content)
+
+	// The file downloaded is kept by the next run.
+	requests := d.Requests()
+	if _, err := downloadWithCheck(context.Background(), url, dst, sha, len(content), false, faultOptions(2), validators{}); err != nil {
+		test.Fatal(err)
+	}
+	if d.Requests() != requests {
+		test.Errorf("%d requests for a file already there", d.Requests()-requests)
+	}
+}
+
+func TestDownloadOnAFullDisk(test *testing.T) {
+	d := &faults.Doer{}
+	url, sha, content := faultyDownload(test, d)
+	dir := downloadDir(test)
+	test.Cleanup(uFiles.SetFS(&faults.FS{FailWrite: 1}))
+
+	_, err := downloadWithCheck(context.Background(), url, fp.Join(dir, "weights.bin"), sha, len(content), false, faultOptions(2), validators{})
+	if !errors.Is(err, syscall.ENOSPC) {
+		test.Fatalf("error %v, want ENOSPC", err)
+	}
+	checkNoTemp(test, dir, 0)
+}
+
+// A download is moved to its destination by a copy when the rename fails
+// across devices.
+func TestDownloadAcrossDevices(test *testing.T) {
+	d := &faults.Doer{}
+	url, sha, content := faultyDownload(test, d)
+	dst := fp.Join(downloadDir(test), "weights.bin")
+	test.Cleanup(uFiles.SetFS(&faults.FS{RenameErr: syscall.EXDEV, ShortRead: 100}))
+
+	if _, err := downloadWithCheck(context.Background(), url, dst, sha, len(content), false, faultOptions(1), validators{}); err != nil {
+		test.Fatal(err)
+	}
+	checkDownloaded(test, dst, content)
+}
+
+func TestCopyFilesOnAFullDisk(test *testing.T) {
+	dir := downloadDir(test)
+	src := fp.Join(dir, "src", "weights.bin")
+	dst := fp.Join(dir, "dst", "weights.bin")
+	if err := os.MkdirAll(fp.Dir(src), 0777); err != nil {
+		test.Fatal(err)
+	}
+	if err := ioutil.WriteFile(src, []byte("new"), 0666); err != nil {
+		test.Fatal(err)
+	}
+	test.Cleanup(uFiles.SetFS(&faults.FS{FailWrite: 1}))
+
+	if err := copyFiles(src, dst); !errors.Is(err, syscall.ENOSPC) {
+		test.Fatalf("error %v, want ENOSPC", err)
+	}
+}
+
+// changingServer serves the file of 4096 bytes, the first requests getting
+// first instead.
+func changingServer(test *testing.T, first []byte, times int64) (url, sha string, content []byte, requests *int64) {
+	content = bytes.Repeat([]byte("weights "), 512)
+	requests = new(int64)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(requests, 1) <= times {
+			w.Write(first)
+			return
+		}
+		w.Write(content)
+	}))
+	test.Cleanup(server.Close)
+	test.Cleanup(u.SetDownloadDoer(server.Client()))
+	h := sha256.Sum256(content)
+	return server.URL + "/weights.bin", hex.EncodeToString(h[:]), content, requests
+}
+
+func TestDownloadRetriesAShortFile(test *testing.T) {
+	url, sha, content, requests := changingServer(test, []byte("weights"), 2)
+	dst := fp.Join(downloadDir(test), "weights.bin")
+
+	if _, err := downloadWithCheck(context.Background(), url, dst, sha, len(content), false, faultOptions(3), validators{}); err != nil {
+		test.Fatal(err)
+	}
+	if n := atomic.LoadInt64(requests); n != 3 {
+		test.Errorf("%d requests, want 3", n)
+	}
+	checkDownloaded(test, dst, content)
+}
+
+func TestDownloadFailsFastOnAChecksumMismatch(test *testing.T) {
+	url, sha, content, requests := changingServer(test, bytes.Repeat([]byte("changed "), 512), 10)
+	dir := downloadDir(test)
+
+	_, err := downloadWithCheck(context.Background(), url, fp.Join(dir, "weights.bin"), sha, len(content), false, faultOptions(3), validators{})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		test.Fatalf("error %v, want ErrChecksumMismatch", err)
+	}
+	if n := atomic.LoadInt64(requests); n != 1 {
+		test.Errorf("%d requests, want 1", n)
+	}
+	checkNoTemp(test, dir, 0)
+}
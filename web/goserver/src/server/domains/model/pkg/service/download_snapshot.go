@@ -0,0 +1,277 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	fp "path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/storage"
+	u "server/kit/utils"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// snapshotChunkSize is the size of the content sent by response of
+// DownloadSnapshot.
+const snapshotChunkSize = 512 << 10
+
+// snapshotZipTime is the time of the entries of the snapshot zips, fixed so
+// that the zips of the same files are the same bytes, a download being
+// resumed from another request.
+var snapshotZipTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// DownloadSnapshotRequestData asks for the snapshot of the model ModelId,
+// from Offset on and Length bytes long, to its end when Length is not set.
+// The range is ignored and the whole snapshot sent when IfRange is set and
+// is not the sha256 of the snapshot any more, as for HTTP.
+type DownloadSnapshotRequestData struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+	Offset  int64              `json:"offset"`
+	Length  int64              `json:"length"`
+	IfRange string             `json:"ifRange,omitempty"`
+}
+
+// DownloadSnapshotResponseData describes the snapshot sent, Size and Sha256
+// being those of the whole snapshot, Offset and Length those of the bytes
+// sent. Partial tells a range was sent. Unsatisfiable tells the range asked
+// for is past the end of the snapshot, nothing being sent.
+type DownloadSnapshotResponseData struct {
+	Name          string `json:"name"`
+	ContentType   string `json:"contentType"`
+	Size          int64  `json:"size"`
+	Sha256        string `json:"sha256"`
+	Offset        int64  `json:"offset"`
+	Length        int64  `json:"length"`
+	Partial       bool   `json:"partial"`
+	Unsatisfiable bool   `json:"unsatisfiable,omitempty"`
+}
+
+// SnapshotChunk is the content of the snapshot from Offset on.
+type SnapshotChunk struct {
+	Offset  int64  `json:"offset"`
+	Content []byte `json:"content"`
+}
+
+// DownloadSnapshot streams the snapshot of a model: a response describing
+// it, the chunks of its content, then the description again as the last
+// response, once all was sent. The IR models are sent as a zip of their xml
+// and bin, stored rather than compressed.
+func (s *basicModelService) DownloadSnapshot(ctx context.Context, req DownloadSnapshotRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		ctx, temps := uFiles.WithTemps(ctx)
+		defer temps.RemoveAll()
+		data, path, err := s.prepareSnapshotDownload(ctx, req)
+		if err.Code > 0 {
+			if !data.Unsatisfiable {
+				returnChan <- kitendpoint.Response{Data: nil, Err: err, IsLast: true}
+				return
+			}
+			returnChan <- kitendpoint.Response{Data: data, Err: err, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: kitendpoint.Error{Code: 0}}
+		if err := sendSnapshotChunks(ctx, path, data.Offset, data.Length, returnChan); err != nil {
+			log.Println("domains.model.pkg.service.download_snapshot.DownloadSnapshot.sendSnapshotChunks", path, err)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// prepareSnapshotDownload finds the snapshot of the model as a local file,
+// the temp files made for it being registered with ctx, and the bytes of it
+// to send.
+func (s *basicModelService) prepareSnapshotDownload(ctx context.Context, req DownloadSnapshotRequestData) (DownloadSnapshotResponseData, string, kitendpoint.Error) {
+	data := DownloadSnapshotResponseData{}
+	if req.Offset < 0 || req.Length < 0 {
+		return data, "", kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: "offset and length must not be negative"}
+	}
+	modelResp := <-modelFindOne.Send(ctx, s.Conn, modelFindOne.RequestData{Id: req.ModelId})
+	model := s.localModel(modelResp.Data.(modelFindOne.ResponseData))
+	if model.Id.IsZero() {
+		err := fmt.Errorf("model %s not found", req.ModelId.Hex())
+		return data, "", kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}
+	}
+	if err := access.Check(ctx, s.Conn, model.ProblemId, role.Viewer); err != nil {
+		return data, "", kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}
+	}
+	if model.SnapshotPath == "" {
+		err := fmt.Errorf("model %q has no snapshot", model.Name)
+		return data, "", kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}
+	}
+	for _, path := range []string{model.SnapshotPath, model.WeightsPath} {
+		if path == "" {
+			continue
+		}
+		if err := s.paths.CheckRead(ctx, path); err != nil {
+			return data, "", kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: err.Error()}
+		}
+	}
+	path, err := s.snapshotFile(ctx, model, &data)
+	if storage.IsNotExist(err) {
+		err := fmt.Errorf("snapshot of model %q is missing", model.Name)
+		return data, "", kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return data, "", kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}
+	}
+	data.Offset, data.Length = 0, data.Size
+	if req.IfRange != "" && req.IfRange != data.Sha256 {
+		return data, path, kitendpoint.Error{Code: 0}
+	}
+	if req.Offset > 0 || req.Length > 0 {
+		if req.Offset >= data.Size {
+			data.Unsatisfiable = true
+			err := fmt.Errorf("range from %d is past the end of the snapshot, of %d bytes", req.Offset, data.Size)
+			return data, "", kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}
+		}
+		data.Offset, data.Length, data.Partial = req.Offset, data.Size-req.Offset, true
+		if req.Length > 0 && req.Length < data.Length {
+			data.Length = req.Length
+		}
+	}
+	return data, path, kitendpoint.Error{Code: 0}
+}
+
+// snapshotFile is a local file holding the snapshot of model, as it will be
+// sent, and describes it in data. The snapshots in a remote storage are
+// copied to a temp file, the IR pairs zipped to one.
+func (s *basicModelService) snapshotFile(ctx context.Context, model t.Model, data *DownloadSnapshotResponseData) (string, error) {
+	if model.WeightsPath != "" {
+		data.Name = u.StringToFolderName(model.Name) + ".zip"
+		data.ContentType = "application/zip"
+		return s.tempSnapshot(ctx, data, func(w io.Writer) error {
+			return s.zipSnapshot(ctx, w, model.SnapshotPath, model.WeightsPath)
+		})
+	}
+	data.Name = fp.Base(model.SnapshotPath)
+	data.ContentType = "application/octet-stream"
+	path := model.SnapshotPath
+	if key, ok := s.storageKey(path); ok {
+		local, ok := s.store.LocalPath(key)
+		if !ok {
+			return s.tempSnapshot(ctx, data, func(w io.Writer) error {
+				return s.copySnapshotFile(ctx, w, path)
+			})
+		}
+		path = local
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if data.Size, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	data.Sha256 = hex.EncodeToString(h.Sum(nil))
+	return path, nil
+}
+
+// tempSnapshot writes the snapshot to a temp file through write, hashing it
+// on the way.
+func (s *basicModelService) tempSnapshot(ctx context.Context, data *DownloadSnapshotResponseData, write func(w io.Writer) error) (string, error) {
+	f, err := ioutil.TempFile(s.imports.Options().TempDir, "snapshot-*"+fp.Ext(data.Name))
+	if err != nil {
+		return "", err
+	}
+	uFiles.RegisterTemp(ctx, f.Name())
+	defer f.Close()
+	h := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(f, h)}
+	if err := write(counter); err != nil {
+		return "", err
+	}
+	data.Size, data.Sha256 = counter.n, hex.EncodeToString(h.Sum(nil))
+	return f.Name(), f.Close()
+}
+
+func (s *basicModelService) copySnapshotFile(ctx context.Context, w io.Writer, path string) error {
+	r, err := s.openModelFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// zipSnapshot writes the files at paths to a zip, by their base names.
+func (s *basicModelService) zipSnapshot(ctx context.Context, w io.Writer, paths ...string) error {
+	zw := zip.NewWriter(w)
+	for _, path := range paths {
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: fp.Base(path), Method: zip.Store, Modified: snapshotZipTime})
+		if err != nil {
+			return err
+		}
+		if err := s.copySnapshotFile(ctx, entry, path); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// sendSnapshotChunks sends the length bytes of the file at path from offset
+// on, snapshotChunkSize bytes by response.
+func sendSnapshotChunks(ctx context.Context, path string, offset, length int64, returnChan chan kitendpoint.Response) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	r := io.LimitReader(f, length)
+	for sent := int64(0); sent < length; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		buf := make([]byte, snapshotChunkSize)
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			returnChan <- kitendpoint.Response{Data: SnapshotChunk{Offset: offset + sent, Content: buf[:n]}, Err: kitendpoint.Error{Code: 0}}
+			sent += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if sent < length {
+				return fmt.Errorf("snapshot ended after %d of %d bytes", sent, length)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
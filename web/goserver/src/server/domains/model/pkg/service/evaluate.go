@@ -3,7 +3,6 @@ package service
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	fp "path/filepath"
@@ -11,38 +10,60 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
-	"gopkg.in/yaml.v2"
-
 	buildFindOne "server/db/pkg/handler/build/find_one"
 	modelFindOne "server/db/pkg/handler/model/find_one"
-	modelUpdateOne "server/db/pkg/handler/model/update_one"
+	modelUpdateStatus "server/db/pkg/handler/model/update_status"
 	problemFindOne "server/db/pkg/handler/problem/find_one"
 	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
 	problemType "server/db/pkg/types/problem/types"
 	statusModelEvaluate "server/db/pkg/types/status/model/evaluate"
+	typeOperation "server/db/pkg/types/type/operation"
+	"server/domains/problem/pkg/access"
 	kitendpoint "server/kit/endpoint"
 	"server/kit/utils/basic/arrays"
 )
 
 type EvaluateRequest struct {
-	ModelId   primitive.ObjectID `json:"modelId" bson:"modelId"`
-	BuildId   primitive.ObjectID `json:"buildId" bson:"buildId"`
-	ProblemId primitive.ObjectID `json:"problemId" bson:"problemId"`
+	ModelId       primitive.ObjectID `json:"modelId" bson:"modelId"`
+	BuildId       primitive.ObjectID `json:"buildId" bson:"buildId"`
+	ProblemId     primitive.ObjectID `json:"problemId" bson:"problemId"`
+	NotifyRequest `bson:",inline"`
 }
 
 func (s *basicModelService) Evaluate(ctx context.Context, req EvaluateRequest) chan kitendpoint.Response {
 	returnChan := make(chan kitendpoint.Response)
 	go func() {
 		defer close(returnChan)
+		subscription, err := s.subscription(ctx, req.NotifyRequest)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		ctx, op := s.startOperation(ctx, typeOperation.ModelEvaluate, subscription, returnChan)
+		op.run(ctx)
 		model, build, problem := s.getModelBuildProblem(req.ModelId, req.BuildId, req.ProblemId)
-		model = s.eval(ctx, model, build, problem, false)
-		returnChan <- kitendpoint.Response{Data: model, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+		if err := access.CheckProblem(ctx, problem, role.Editor); err != nil {
+			op.finish(ctx, kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}}, nil)
+			return
+		}
+		model, err = s.eval(ctx, model, build, problem, false)
+		if err == nil && model.Evaluates[build.Id.Hex()].Status == statusModelEvaluate.Failed {
+			err = fmt.Errorf("evaluation of model %s on build %s failed", model.Name, build.Name)
+		}
+		op.finish(ctx, kitendpoint.Response{Data: model, Err: kitendpoint.Error{Code: 0}}, err)
 	}()
 	return returnChan
 }
 
-func (s *basicModelService) eval(ctx context.Context, model t.Model, build t.Build, problem t.Problem, saveImages bool) t.Model {
-	model = s.updateModelEvaluateStatus(ctx, model, build.Id, statusModelEvaluate.InProgress)
+// eval evaluates the model on the build. It fails without running anything
+// when the evaluation may not be started, a failed run is recorded in the
+// evaluation status of the model.
+func (s *basicModelService) eval(ctx context.Context, model t.Model, build t.Build, problem t.Problem, saveImages bool) (t.Model, error) {
+	model, err := s.updateModelEvaluateStatus(ctx, model, build.Id, statusModelEvaluate.InProgress)
+	if err != nil {
+		return model, err
+	}
 	evalFolderPath := createEvalDir(model.Dir, build.Folder)
 	metricsYml := fp.Join(evalFolderPath, "metrics.yaml")
 	outputImagesPath := ""
@@ -53,12 +74,19 @@ func (s *basicModelService) eval(ctx context.Context, model t.Model, build t.Bui
 	outputLog := createFile(fp.Join(evalFolderPath, "output.log"))
 	env := getEvaluateEnv()
 	if err := s.runCommand(commands, env, model.Dir, outputLog); err != nil {
-		model = s.updateModelEvaluateStatus(ctx, model, build.Id, statusModelEvaluate.Failed)
+		model, err = s.updateModelEvaluateStatus(ctx, model, build.Id, statusModelEvaluate.Failed)
+		if err != nil {
+			return model, err
+		}
 	} else {
-		model = s.saveModelEvalMetrics(metricsYml, build.Id, model)
+		model, err = s.saveModelEvalMetrics(metricsYml, build, model)
+		if err != nil {
+			return model, err
+		}
 	}
 	log.Println("eval.model.Evaluates", model.Evaluates)
-	return model
+	s.emitEvaluation(model, build.Id)
+	return model, nil
 }
 
 func makeImagesFolder(evalFolderPath string) string {
@@ -75,7 +103,12 @@ func getEvaluateEnv() []string {
 	}
 }
 
+// createFile replaces the file name with an empty one, the files hardlinked
+// to it are left alone.
 func createFile(name string) string {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		log.Println("evaluate.createFile.os.Remove(name)", err)
+	}
 	_, err := os.Create(name)
 	if err != nil {
 		log.Println("evaluate.createMetricsYaml.os.Create(metricsYml)", err)
@@ -100,7 +133,7 @@ func (s *basicModelService) getModelBuildProblem(modelId, buildId, problemId pri
 		problemFindOne.RequestData{Id: problemId},
 	)
 	rModel, rBuild, rProblem := <-chModel, <-chBuild, <-chProblem
-	model := rModel.Data.(modelFindOne.ResponseData)
+	model := s.localModel(rModel.Data.(modelFindOne.ResponseData))
 	build := rBuild.Data.(buildFindOne.ResponseData)
 	problem := rProblem.Data.(problemFindOne.ResponseData)
 
@@ -148,26 +181,30 @@ func (s *basicModelService) prepareEvaluateCommands(evalYml, outputImagesPath st
 	return commands
 }
 
-func (s *basicModelService) saveModelEvalMetrics(evalYml string, buildId primitive.ObjectID, model t.Model) t.Model {
-	log.Println(evalYml)
-	newModelYamlFile, err := ioutil.ReadFile(evalYml)
-	if err != nil {
-		log.Println("ReadFile", err)
-	}
-	var metrics struct {
-		Metrics []t.Metric `yaml:"metrics"`
+// saveModelEvalMetrics records the metrics of the evaluation of the model on
+// the build, made on the assets the build has now.
+func (s *basicModelService) saveModelEvalMetrics(evalYml string, build t.Build, model t.Model) (t.Model, error) {
+	buildId := build.Id
+	if err := statusModelEvaluate.CheckTransition(model.Evaluates[buildId.Hex()].Status, statusModelEvaluate.Finished); err != nil {
+		log.Println("domains.model.pkg.service.evaluate.saveModelEvalMetrics", model.Name, err)
+		return model, fmt.Errorf("model %s: %v", model.Name, err)
 	}
-	err = yaml.Unmarshal(newModelYamlFile, &metrics)
+	log.Println(evalYml)
+	s.publish(context.TODO(), model.Dir, nil)
+	metrics, err := s.loadMetrics(context.TODO(), evalYml)
 	if err != nil {
-		log.Println("Unmarshal", err)
+		log.Println("loadMetrics", err)
 	}
 	if model.Evaluates == nil {
 		model.Evaluates = make(map[string]t.Evaluate)
 	}
 	model.Evaluates[buildId.Hex()] = t.Evaluate{
-		Metrics: metrics.Metrics,
-		Status:  statusModelEvaluate.Finished,
-	}
-	modelUpdateOneResp := <-modelUpdateOne.Send(context.TODO(), s.Conn, model)
-	return modelUpdateOneResp.Data.(modelUpdateOne.ResponseData)
+		Metrics:    metrics,
+		Status:     statusModelEvaluate.Finished,
+		AssetsHash: build.AssetsHash,
+	}
+	return s.saveStatus(context.TODO(), model, modelUpdateStatus.RequestData{
+		Id:        model.Id,
+		Evaluates: map[string]t.Evaluate{buildId.Hex(): model.Evaluates[buildId.Hex()]},
+	}, true)
 }
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"context"
+
+	kitendpoint "server/kit/endpoint"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// ImportTemplate imports the template of req as UpdateFromLocal does, but
+// without the operation the endpoint keeps through the database service,
+// for the imports to be tested over the memory store.
+func ImportTemplate(ctx context.Context, svc ModelService, req UpdateFromLocalRequestData) kitendpoint.Response {
+	s := svc.(*basicModelService)
+	ctx, temps := uFiles.WithTemps(ctx)
+	defer temps.RemoveAll()
+	ctx, held := withHeldSnapshots(ctx, s.snapshotLeases)
+	defer held.releaseAll()
+	ctx = withImportCache(ctx)
+	req.Path = s.paths.TemplatePath(req.Path)
+	responses := make(chan kitendpoint.Response)
+	defer close(responses)
+	go func() {
+		for range responses {
+		}
+	}()
+	return s.updateFromLocal(ctx, req, &operation{responseChan: responses}, nil)
+}
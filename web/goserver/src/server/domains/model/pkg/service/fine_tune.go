@@ -20,15 +20,22 @@ import (
 	cvatTaskFind "server/db/pkg/handler/cvat_task/find"
 	modelFindOne "server/db/pkg/handler/model/find_one"
 	modelInsertOne "server/db/pkg/handler/model/insert_one"
-	modelUpdateOne "server/db/pkg/handler/model/update_one"
+	modelUpdateStatus "server/db/pkg/handler/model/update_status"
 	problemFindOne "server/db/pkg/handler/problem/find_one"
 	t "server/db/pkg/types"
 	splitState "server/db/pkg/types/build/split_state"
+	modelFormat "server/db/pkg/types/model/format"
+	"server/db/pkg/types/problem/role"
 	problemType "server/db/pkg/types/problem/types"
+	statusModelEvaluate "server/db/pkg/types/status/model/evaluate"
 	statusModelTrain "server/db/pkg/types/status/model/train"
+	typeOperation "server/db/pkg/types/type/operation"
+	typeWebhook "server/db/pkg/types/type/webhook"
+	"server/domains/problem/pkg/access"
 
 	kitendpoint "server/kit/endpoint"
 	"server/kit/utils/basic/arrays"
+	uFiles "server/kit/utils/basic/files"
 	trainingWorkerGpuNum "server/workers/train/pkg/handler/get_gpu_amount"
 	runCommandsWorker "server/workers/train/pkg/handler/run_commands"
 )
@@ -42,23 +49,46 @@ type FineTuneRequestData struct {
 	ParentModelId          string `json:"parentModelId"`
 	ProblemId              string `json:"problemId"`
 	SaveAnnotatedValImages bool   `json:"saveAnnotatedValImages"`
+	NotifyRequest
 }
 
 func (s *basicModelService) FineTune(ctx context.Context, req FineTuneRequestData) chan kitendpoint.Response {
 	returnChan := make(chan kitendpoint.Response)
 	go func() {
 		defer close(returnChan)
+		subscription, err := s.subscription(ctx, req.NotifyRequest)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		ctx, op := s.startOperation(ctx, typeOperation.ModelTrain, subscription, returnChan)
+		op.run(ctx)
 		parentModel, build, problem := s.getParentModelBuildProblem(req.ParentModelId, req.BuildId, req.ProblemId)
+		if err := access.CheckProblem(ctx, problem, role.Editor); err != nil {
+			op.finish(ctx, kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}}, nil)
+			return
+		}
+		if !isPyTorch(parentModel) {
+			err := fmt.Errorf("model %s is a %s snapshot, only PyTorch snapshots can be trained", parentModel.Name, parentModel.SnapshotFormat)
+			op.finish(ctx, kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}, nil)
+			return
+		}
 		newModel, err := s.train(ctx, parentModel, build, problem, req.GpuNum, req.BatchSize, req.Epochs, req.Name)
 		if err != nil {
-			returnChan <- kitendpoint.Response{Data: newModel, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+			op.finish(ctx, kitendpoint.Response{Data: newModel, Err: kitendpoint.Error{Code: 0}}, err)
 			return
 		}
+		op.progress(ctx, 0.9)
 		if err := os.Rename(fp.Join(newModel.Dir, "latest.pth"), newModel.SnapshotPath); err != nil {
 			log.Println("os.Rename(fp.Join(newModel.Dir, \"latest.pth\"), newModel.SnapshotPath)", err)
 		}
-		newModel = s.eval(ctx, newModel, build, problem, req.SaveAnnotatedValImages)
-		returnChan <- kitendpoint.Response{Data: newModel, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+		newModel, err = s.eval(ctx, newModel, build, problem, req.SaveAnnotatedValImages)
+		if err != nil {
+			op.warn(ctx, "evaluation on build %s not run: %v", build.Name, err)
+		} else if newModel.Evaluates[build.Id.Hex()].Status == statusModelEvaluate.Failed {
+			op.warn(ctx, "evaluation on build %s failed", build.Name)
+		}
+		op.finish(ctx, kitendpoint.Response{Data: newModel, Err: kitendpoint.Error{Code: 0}}, nil)
 	}()
 	return returnChan
 }
@@ -66,7 +96,15 @@ func (s *basicModelService) FineTune(ctx context.Context, req FineTuneRequestDat
 func (s *basicModelService) train(ctx context.Context, parentModel t.Model, build t.Build, problem t.Problem, userGpuNum, batchSize, epochs int, newModelName string) (t.Model, error) {
 	gpuNum := s.getOptimalGpuNumber(userGpuNum, parentModel.TrainingGpuNum)
 	newModel, err := s.createNewModel(ctx, newModelName, problem, parentModel, gpuNum, epochs)
-	copyModelFilesFromParentModel(parentModel.Dir, newModel.Dir, parentModel.TemplatePath, []string{"snapshot.pth"})
+	if err := s.checkCopy(ctx, newModel.Dir, parentModel.Dir, parentModel.TemplatePath); err != nil {
+		return newModel, err
+	}
+	unlock, lockErr := s.lockDir(ctx, newModel.Dir, "training")
+	if lockErr != nil {
+		return newModel, lockErr
+	}
+	defer unlock()
+	s.copyModelFilesFromParentModel(ctx, parentModel.Dir, newModel.Dir, parentModel.TemplatePath, []string{modelSnapshotName(parentModel)})
 	if err != nil {
 		return newModel, err
 	}
@@ -74,18 +112,30 @@ func (s *basicModelService) train(ctx context.Context, parentModel t.Model, buil
 	outputLog := fmt.Sprintf("%s/output.log", newModel.Dir)
 	env := getFineTuneEnv()
 	err = s.runCommand(commands, env, newModel.Dir, outputLog)
+	s.publish(ctx, newModel.Dir, nil)
+	status := statusModelTrain.Finished
 	if err != nil {
-		newModel = s.updateModelTrainStatus(ctx, newModel, statusModelTrain.Failed)
-	} else {
-		newModel = s.updateModelTrainStatus(ctx, newModel, statusModelTrain.Finished)
+		status = statusModelTrain.Failed
+	}
+	newModel, statusErr := s.updateModelTrainStatus(ctx, newModel, status)
+	if statusErr == nil {
+		s.emitModel(typeWebhook.ModelTrainingFinished, newModel, false)
+	}
+	if err == nil {
+		err = statusErr
 	}
 	return newModel, err
 }
 
-func (s *basicModelService) updateModelTrainStatus(ctx context.Context, model t.Model, status string) t.Model {
+// updateModelTrainStatus saves the training status of the model, refusing
+// the transitions statusModelTrain does not allow.
+func (s *basicModelService) updateModelTrainStatus(ctx context.Context, model t.Model, status string) (t.Model, error) {
+	if err := statusModelTrain.CheckTransition(model.Status, status); err != nil {
+		log.Println("domains.model.pkg.service.fine_tune.updateModelTrainStatus", model.Name, err)
+		return model, fmt.Errorf("model %s: %v", model.Name, err)
+	}
 	model.Status = status
-	modelUpdateOneResp := <-modelUpdateOne.Send(ctx, s.Conn, model)
-	return modelUpdateOneResp.Data.(modelUpdateOne.ResponseData)
+	return s.saveStatus(ctx, model, modelUpdateStatus.RequestData{Id: model.Id, Status: status}, status != statusModelTrain.InProgress)
 }
 
 func getFineTuneEnv() []string {
@@ -163,6 +213,16 @@ func (s *basicModelService) runCommand(commands, env []string, workingDir, outpu
 	return nil
 }
 
+// modelSnapshotName is the snapshot path relative to the model folder, so
+// fine-tuned models keep the weights file name of their parent.
+func modelSnapshotName(model t.Model) string {
+	name, err := fp.Rel(model.Dir, model.SnapshotPath)
+	if err != nil || !isInsideDir(name) {
+		return defaultSnapshotNames[modelFormat.PyTorch]
+	}
+	return name
+}
+
 func (s *basicModelService) createNewModel(
 	ctx context.Context,
 	name string,
@@ -177,16 +237,18 @@ func (s *basicModelService) createNewModel(
 	newModelResp := <-modelInsertOne.Send(
 		ctx,
 		s.Conn,
-		modelInsertOne.RequestData{
+		s.storedModelWithoutId(modelInsertOne.RequestData{
 			ConfigPath:      fp.Join(dir, "model.py"),
 			Dir:             dir,
 			Epochs:          parentModel.Epochs + epochs,
 			Evaluates:       make(map[string]t.Evaluate),
+			ImportedBy:      importedBy(ctx),
 			ModulesYamlPath: fp.Join(dir, "modules.yaml"),
 			Name:            name,
 			ParentModelId:   parentModel.Id,
 			ProblemId:       problem.Id,
-			SnapshotPath:    fp.Join(dir, "snapshot.pth"),
+			SnapshotFormat:  modelFormat.PyTorch,
+			SnapshotPath:    fp.Join(dir, modelSnapshotName(parentModel)),
 			Scripts: t.Scripts{
 				Train: fp.Join(dir, "train.py"),
 				Eval:  fp.Join(dir, "eval.py"),
@@ -194,9 +256,9 @@ func (s *basicModelService) createNewModel(
 			Status:         statusModelTrain.InProgress,
 			TemplatePath:   fp.Join(dir, "template.yaml"),
 			TrainingGpuNum: gpuNum,
-		},
+		}),
 	)
-	model := newModelResp.Data.(modelInsertOne.ResponseData)
+	model := s.localModel(newModelResp.Data.(modelInsertOne.ResponseData))
 	if newModelResp.Err.Code > 0 {
 		return model, errors.New(newModelResp.Err.Message)
 	} else {
@@ -233,7 +295,7 @@ func (s *basicModelService) getParentModelBuildProblem(modelIdString, buildIdStr
 		problemFindOne.RequestData{Id: problemId},
 	)
 	rParentModel, rBuild, rProblem := <-chParentModel, <-chBuild, <-chProblem
-	parentModel := rParentModel.Data.(modelFindOne.ResponseData)
+	parentModel := s.localModel(rParentModel.Data.(modelFindOne.ResponseData))
 	build := rBuild.Data.(buildFindOne.ResponseData)
 	problem := rProblem.Data.(problemFindOne.ResponseData)
 
@@ -280,7 +342,7 @@ func (s *basicModelService) createNewBuildConfig(
 	byteValue = replaceVarValList(byteValue, "test_ann_file", stringArrToString(testAnnFiles))
 	byteValue = replaceVarValList(byteValue, "test_img_prefix", stringArrToString(testImgPrefixes))
 
-	err = ioutil.WriteFile(newModelConfigPath, byteValue, 0777)
+	err = uFiles.WriteFileAtomic(newModelConfigPath, byteValue, 0777)
 	if err != nil {
 		fmt.Println("WriteFile", err)
 		return newModelConfigPath, err
@@ -0,0 +1,75 @@
+package service_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+
+	"server/domains/model/pkg/service"
+	"server/domains/model/pkg/service/memory"
+	"server/kit/storage"
+)
+
+// memoryFixture is the model service over an in-memory store. A temporary
+// folder is both its data and template root, the problems being kept in
+// its problems folder, which is the local storage unless storage is set
+// before serve.
+type memoryFixture struct {
+	root, problems string
+	store          *memory.Store
+	storage        storage.Storage
+	paths          *service.PathPolicy
+	service        service.ModelService
+}
+
+func newMemoryFixture(test *testing.T) memoryFixture {
+	root, err := ioutil.TempDir("", "model_service")
+	if err != nil {
+		test.Fatal(err)
+	}
+	test.Cleanup(func() { os.RemoveAll(root) })
+	f := memoryFixture{root: root, problems: fp.Join(root, "problems"), store: memory.New()}
+	f.storage = storage.NewLocal(f.problems)
+	if err := os.MkdirAll(f.problems, 0777); err != nil {
+		test.Fatal(err)
+	}
+	paths, err := service.NewPathPolicy([]string{root}, []string{root}, "", nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	f.paths = paths
+	f.serve(f.store.Repositories())
+	return f
+}
+
+// serve has the service use repos in place of the store.
+func (f *memoryFixture) serve(repos service.Repositories) {
+	imports := service.NewImportSettings(1, 1, service.ImportWeights{}, service.ImportOptions{}, service.PostImportHooks{})
+	f.service = service.NewBasicModelService(nil, f.problems, fp.Join(f.root, "trainings"), imports, f.paths, f.storage, service.CleanupSettings{}, 0, nil, nil, repos)
+}
+
+// writeFile writes content to path, creating its folder.
+func writeFile(test *testing.T, path, content string) {
+	if err := os.MkdirAll(fp.Dir(path), 0777); err != nil {
+		test.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+		test.Fatal(err)
+	}
+}
+
+func readFile(test *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	return string(b)
+}
+
+func sha(content string) string {
+	h := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	fp "path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	problemFind "server/db/pkg/handler/problem/find"
+	t "server/db/pkg/types"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+	u "server/kit/utils"
+)
+
+// maxWorkspaceLength bounds the names of the workspaces.
+const maxWorkspaceLength = 64
+
+// modelDir is the folder of the model name in the workspace of problem, in
+// the problem folder for the default workspace. A model imported before
+// keeps its folder. The folder of a new model gets a suffix hashed from its
+// name when another model of the problem has it already, as "SSD 300" and
+// "ssd-300" would on a filesystem ignoring case, so that the import does not
+// overwrite the files of that model, or when it is the folder of a
+// workspace. A workspace can not be named after the folder of a model.
+func (s *basicModelService) modelDir(ctx context.Context, problem t.Problem, workspace, name string, imported t.Model) (string, error) {
+	if !imported.Id.IsZero() && imported.Dir != "" {
+		return imported.Dir, nil
+	}
+	parent := fp.Join(problem.Dir, workspace)
+	dir := fp.Join(parent, u.StringToFolderName(name))
+	models, err := s.storedModels(ctx, problem)
+	if err != nil {
+		return "", fmt.Errorf("checking the folder of model %q: %v", name, err)
+	}
+	taken := false
+	for _, model := range models {
+		modelDir := s.localModel(model).Dir
+		if workspace != "" && sameDir(modelDir, parent) {
+			return "", fmt.Errorf("workspace %q is the folder of model %q", workspace, model.Name)
+		}
+		if model.Name != name && sameDir(modelDir, dir) {
+			log.Printf("domains.model.pkg.service.folder_names.modelDir: folder %s of model %q is taken by model %q", fp.Base(dir), name, model.Name)
+			taken = true
+		}
+		if model.Workspace != "" && sameDir(fp.Join(problem.Dir, model.Workspace), dir) {
+			log.Printf("domains.model.pkg.service.folder_names.modelDir: folder %s of model %q is that of workspace %q", fp.Base(dir), name, model.Workspace)
+			taken = true
+		}
+	}
+	if taken {
+		return fp.Join(parent, u.SuffixedFolderName(name)), nil
+	}
+	return dir, nil
+}
+
+// checkWorkspace returns an error when workspace, unless empty, is not a
+// folder name of its own: it must be kept as it is by StringToFolderName and
+// not start with the "_" or "." of the folders the service keeps in the
+// problem folder.
+func checkWorkspace(workspace string) error {
+	if workspace == "" {
+		return nil
+	}
+	if len(workspace) > maxWorkspaceLength {
+		return fmt.Errorf("workspace %q is longer than %d bytes", workspace, maxWorkspaceLength)
+	}
+	if u.StringToFolderName(workspace) != workspace || strings.HasPrefix(workspace, "_") || strings.HasPrefix(workspace, ".") {
+		return fmt.Errorf("%q is not a valid workspace name, use letters, digits, \"-\" and \"_\"", workspace)
+	}
+	return nil
+}
+
+// sameDir tells whether a and b are the same folder, also on a filesystem
+// ignoring case.
+func sameDir(a, b string) bool {
+	return strings.EqualFold(fp.Clean(a), fp.Clean(b))
+}
+
+// CheckFolderCollisionsRequestData asks for the models sharing a folder,
+// in every problem.
+type CheckFolderCollisionsRequestData struct{}
+
+// FolderCollision is a folder several models of a problem point at.
+type FolderCollision struct {
+	ProblemId primitive.ObjectID `json:"problemId"`
+	Dir       string             `json:"dir"`
+	Models    []MigratedModel    `json:"models"`
+}
+
+// CheckFolderCollisionsResponseData lists the Collisions found among the
+// Models seen, those of the problems in SkippedProblems not being checked.
+type CheckFolderCollisionsResponseData struct {
+	Models          int               `json:"models"`
+	Collisions      []FolderCollision `json:"collisions"`
+	SkippedProblems []string          `json:"skippedProblems"`
+}
+
+// CheckFolderCollisions reports the models imported before their folders
+// were told apart, whose files overwrote each other. It changes nothing, the
+// models being re-imported or removed by hand. Only admins may run it when
+// the request carries an identity.
+func (s *basicModelService) CheckFolderCollisions(ctx context.Context, req CheckFolderCollisionsRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		if identity, ok := auth.FromContext(ctx); ok && !identity.HasRole(auth.RoleAdmin) {
+			err := fmt.Errorf("user %q is not allowed to check the model folders", identity.User)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeForbidden, Message: err.Error()}, IsLast: true}
+			return
+		}
+		problemResp := <-problemFind.Send(ctx, s.Conn, problemFind.RequestData{Page: 1, Size: 0})
+		if problemResp.Err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: problemResp.Err.Message}, IsLast: true}
+			return
+		}
+		data := CheckFolderCollisionsResponseData{Collisions: []FolderCollision{}, SkippedProblems: []string{}}
+		for _, problem := range problemResp.Data.(problemFind.ResponseData).Items {
+			models, err := s.storedModels(ctx, problem)
+			if err != nil {
+				log.Println("domains.model.pkg.service.folder_names.CheckFolderCollisions.storedModels", problem.Title, err)
+				data.SkippedProblems = append(data.SkippedProblems, problem.Title)
+				continue
+			}
+			data.Models += len(models)
+			data.Collisions = append(data.Collisions, s.folderCollisions(problem, models)...)
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// folderCollisions groups the models of problem by folder, ignoring case,
+// and keeps the folders of several models.
+func (s *basicModelService) folderCollisions(problem t.Problem, models []t.Model) []FolderCollision {
+	var order []string
+	byDir := make(map[string]*FolderCollision)
+	for _, model := range models {
+		dir := s.localModel(model).Dir
+		if dir == "" {
+			continue
+		}
+		key := strings.ToLower(fp.Clean(dir))
+		collision, ok := byDir[key]
+		if !ok {
+			collision = &FolderCollision{ProblemId: problem.Id, Dir: dir}
+			byDir[key] = collision
+			order = append(order, key)
+		}
+		collision.Models = append(collision.Models, MigratedModel{Id: model.Id, Name: model.Name, ProblemId: model.ProblemId, Dir: dir})
+	}
+	var collisions []FolderCollision
+	for _, key := range order {
+		if len(byDir[key].Models) > 1 {
+			collisions = append(collisions, *byDir[key])
+		}
+	}
+	return collisions
+}
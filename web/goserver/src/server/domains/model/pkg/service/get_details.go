@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+)
+
+type GetDetailsRequestData struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+}
+
+// GetDetails returns the whole model, with the evaluations, dependencies and
+// provenance List leaves out.
+func (s *basicModelService) GetDetails(ctx context.Context, req GetDetailsRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		modelResp := <-modelFindOne.Send(ctx, s.Conn, modelFindOne.RequestData{Id: req.ModelId})
+		model := s.localModel(modelResp.Data.(modelFindOne.ResponseData))
+		if model.Id.IsZero() {
+			err := fmt.Errorf("model %s not found", req.ModelId.Hex())
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if err := access.Check(ctx, s.Conn, model.ProblemId, role.Viewer); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: model, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
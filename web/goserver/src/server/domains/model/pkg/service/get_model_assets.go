@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	fp "path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/markdown"
+	"server/kit/storage"
+)
+
+// GetReadmeRequestData asks for the readme of a model rendered to HTML. The
+// response is NotModified, without the HTML, when ETag is still the one of
+// the readme.
+type GetReadmeRequestData struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+	ETag    string             `json:"etag"`
+}
+
+// GetReadmeResponseData holds the readme rendered to sanitized HTML, ETag
+// being the sha256 of its Markdown.
+type GetReadmeResponseData struct {
+	Html        string `json:"html"`
+	ETag        string `json:"etag"`
+	NotModified bool   `json:"notModified"`
+}
+
+// GetPreviewRequestData asks for the preview image Name of a model, one of
+// its Previews. The response is NotModified, without the image, when ETag
+// is still the one of the image.
+type GetPreviewRequestData struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+	Name    string             `json:"name"`
+	ETag    string             `json:"etag"`
+}
+
+// GetPreviewResponseData holds the image with its content type, ETag being
+// its sha256.
+type GetPreviewResponseData struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Content     []byte `json:"content"`
+	ETag        string `json:"etag"`
+	NotModified bool   `json:"notModified"`
+}
+
+func (s *basicModelService) GetReadme(ctx context.Context, req GetReadmeRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		data := GetReadmeResponseData{}
+		content, err := s.readAsset(ctx, req.ModelId, req.ETag, &data.ETag, func(model t.Model) (string, error) {
+			if model.ReadmePath == "" {
+				return "", fmt.Errorf("model %q has no readme", model.Name)
+			}
+			return model.ReadmePath, nil
+		})
+		if err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: err, IsLast: true}
+			return
+		}
+		if content == nil {
+			data.NotModified = true
+		} else {
+			data.Html = markdown.ToHTML(content)
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: err, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicModelService) GetPreview(ctx context.Context, req GetPreviewRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		data := GetPreviewResponseData{Name: req.Name, ContentType: previewType(req.Name)}
+		content, err := s.readAsset(ctx, req.ModelId, req.ETag, &data.ETag, func(model t.Model) (string, error) {
+			for _, name := range model.Previews {
+				if name == req.Name {
+					return fp.Join(model.Dir, previewsFolder, fp.FromSlash(name)), nil
+				}
+			}
+			return "", fmt.Errorf("model %q has no preview %q", model.Name, req.Name)
+		})
+		if err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: err, IsLast: true}
+			return
+		}
+		data.Content = content
+		data.NotModified = content == nil
+		returnChan <- kitendpoint.Response{Data: data, Err: err, IsLast: true}
+	}()
+	return returnChan
+}
+
+// readAsset reads the file of the model that path names, a missing one being
+// not found, and sets etag to its sha256. No content is returned when etag
+// is already ifNoneMatch.
+func (s *basicModelService) readAsset(ctx context.Context, modelId primitive.ObjectID, ifNoneMatch string, etag *string, path func(t.Model) (string, error)) ([]byte, kitendpoint.Error) {
+	modelResp := <-modelFindOne.Send(ctx, s.Conn, modelFindOne.RequestData{Id: modelId})
+	model := s.localModel(modelResp.Data.(modelFindOne.ResponseData))
+	if model.Id.IsZero() {
+		err := fmt.Errorf("model %s not found", modelId.Hex())
+		return nil, kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}
+	}
+	if err := access.Check(ctx, s.Conn, model.ProblemId, role.Viewer); err != nil {
+		return nil, kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}
+	}
+	name, err := path(model)
+	if err != nil {
+		return nil, kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}
+	}
+	if err := s.paths.CheckRead(ctx, name); err != nil {
+		return nil, kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: err.Error()}
+	}
+	content, err := s.readModelFile(ctx, name)
+	if storage.IsNotExist(err) {
+		err := fmt.Errorf("%s of model %q is missing", fp.Base(name), model.Name)
+		return nil, kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return nil, kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}
+	}
+	*etag = sha256Of(content)
+	if *etag == ifNoneMatch {
+		return nil, kitendpoint.Error{Code: 0}
+	}
+	return content, kitendpoint.Error{Code: 0}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	fp "path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/storage"
+)
+
+// originalTemplateName is the whole template a model of a multi-document
+// template was imported from, template.yaml holding only its own document.
+const originalTemplateName = "template.original.yaml"
+
+// GetModelTemplateRequestData asks for the stored template of a model, and
+// for the template it was imported from as well when Original is set.
+type GetModelTemplateRequestData struct {
+	ModelId  primitive.ObjectID `json:"modelId"`
+	Original bool               `json:"original"`
+}
+
+// GetModelTemplateResponseData holds the templates as they are stored.
+// Original is empty when the model was imported from a single-document
+// template, it is then the same as Template.
+type GetModelTemplateResponseData struct {
+	Template string `json:"template"`
+	Original string `json:"original,omitempty"`
+}
+
+func (s *basicModelService) GetModelTemplate(ctx context.Context, req GetModelTemplateRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		data, err := s.getModelTemplate(ctx, req)
+		if err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: err, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: err, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicModelService) getModelTemplate(ctx context.Context, req GetModelTemplateRequestData) (GetModelTemplateResponseData, kitendpoint.Error) {
+	var data GetModelTemplateResponseData
+	modelResp := <-modelFindOne.Send(ctx, s.Conn, modelFindOne.RequestData{Id: req.ModelId})
+	model := s.localModel(modelResp.Data.(modelFindOne.ResponseData))
+	if model.Id.IsZero() {
+		err := fmt.Errorf("model %s not found", req.ModelId.Hex())
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}
+	}
+	if err := access.Check(ctx, s.Conn, model.ProblemId, role.Viewer); err != nil {
+		return data, kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}
+	}
+	if model.TemplatePath == "" {
+		err := fmt.Errorf("model %q has no template", model.Name)
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}
+	}
+	if err := s.paths.CheckRead(ctx, model.TemplatePath); err != nil {
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: err.Error()}
+	}
+	template, err := s.readModelFile(ctx, model.TemplatePath)
+	if storage.IsNotExist(err) {
+		err := fmt.Errorf("the template of model %q is missing", model.Name)
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}
+	}
+	data.Template = string(template)
+	if req.Original {
+		original, err := s.readModelFile(ctx, fp.Join(fp.Dir(model.TemplatePath), originalTemplateName))
+		if err != nil && !storage.IsNotExist(err) {
+			return data, kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}
+		}
+		data.Original = string(original)
+	}
+	return data, kitendpoint.Error{Code: 0}
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+
+	kitendpoint "server/kit/endpoint"
+)
+
+type HealthCheckRequestData struct {
+}
+
+type HealthCheckResponseData struct {
+	ActiveImports    int `json:"activeImports"`
+	QueuedImports    int `json:"queuedImports"`
+	ImportLimit      int `json:"importLimit"`
+	MaxQueuedImports int `json:"maxQueuedImports"`
+}
+
+func (s *basicModelService) HealthCheck(ctx context.Context, req HealthCheckRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		active, queued := s.imports.Stats()
+		limit, maxQueued := s.imports.Limits()
+		returnChan <- kitendpoint.Response{
+			Data: HealthCheckResponseData{
+				ActiveImports:    active,
+				QueuedImports:    queued,
+				ImportLimit:      limit,
+				MaxQueuedImports: maxQueued,
+			},
+			Err:    kitendpoint.Error{Code: 0},
+			IsLast: true,
+		}
+	}()
+	return returnChan
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	t "server/db/pkg/types"
+)
+
+type importCacheKey struct{}
+
+// importCache remembers the problems, default builds and checksums files the
+// imports of a batch look up, so that the templates of a problem share a
+// single lookup of each. Only what was found is kept, a failed lookup is tried again by
+// the next template.
+type importCache struct {
+	mu            sync.Mutex
+	problems      map[string]*cachedProblem
+	builds        map[primitive.ObjectID]*cachedBuild
+	checksumFiles map[string]*cachedChecksums
+}
+
+// cachedProblem and cachedBuild are locked during their lookup, the
+// templates of the same problem wait for it rather than repeat it.
+type cachedProblem struct {
+	mu      sync.Mutex
+	found   bool
+	problem t.Problem
+}
+
+type cachedBuild struct {
+	mu    sync.Mutex
+	found bool
+	build t.Build
+}
+
+type cachedChecksums struct {
+	mu    sync.Mutex
+	found bool
+	sums  checksums
+}
+
+// withImportCache returns a context the lookups of the imports run with it
+// are cached in, for as long as the context is used.
+func withImportCache(ctx context.Context) context.Context {
+	cache := &importCache{
+		problems:      make(map[string]*cachedProblem),
+		builds:        make(map[primitive.ObjectID]*cachedBuild),
+		checksumFiles: make(map[string]*cachedChecksums),
+	}
+	return context.WithValue(ctx, importCacheKey{}, cache)
+}
+
+func importCacheFrom(ctx context.Context) *importCache {
+	cache, _ := ctx.Value(importCacheKey{}).(*importCache)
+	return cache
+}
+
+func (cache *importCache) problem(title string) *cachedProblem {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.problems[title]
+	if !ok {
+		entry = &cachedProblem{}
+		cache.problems[title] = entry
+	}
+	return entry
+}
+
+func (cache *importCache) build(problemId primitive.ObjectID) *cachedBuild {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.builds[problemId]
+	if !ok {
+		entry = &cachedBuild{}
+		cache.builds[problemId] = entry
+	}
+	return entry
+}
+
+func (cache *importCache) checksumsFile(source string) *cachedChecksums {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.checksumFiles[source]
+	if !ok {
+		entry = &cachedChecksums{}
+		cache.checksumFiles[source] = entry
+	}
+	return entry
+}
+
+// findProblem is getProblem through the import cache of ctx, if any.
+func (s *basicModelService) findProblem(ctx context.Context, ref string) (t.Problem, error) {
+	cache := importCacheFrom(ctx)
+	if cache == nil {
+		return s.getProblem(ctx, ref)
+	}
+	entry := cache.problem(ref)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.found {
+		importCacheLookups.Inc("problem", "hit")
+		return entry.problem, nil
+	}
+	importCacheLookups.Inc("problem", "miss")
+	problem, err := s.getProblem(ctx, ref)
+	if err == nil && !problem.Id.IsZero() {
+		entry.problem, entry.found = problem, true
+	}
+	return problem, err
+}
+
+// findDefaultBuild is getDefaultBuild through the import cache of ctx, if
+// any. The build created for a problem without one is cached as well.
+func (s *basicModelService) findDefaultBuild(ctx context.Context, problemId primitive.ObjectID) (t.Build, error) {
+	cache := importCacheFrom(ctx)
+	if cache == nil {
+		return s.getDefaultBuild(ctx, problemId)
+	}
+	entry := cache.build(problemId)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.found {
+		importCacheLookups.Inc("build", "hit")
+		return entry.build, nil
+	}
+	importCacheLookups.Inc("build", "miss")
+	build, err := s.getDefaultBuild(ctx, problemId)
+	if err == nil && !build.Id.IsZero() {
+		entry.build, entry.found = build, true
+	}
+	return build, err
+}
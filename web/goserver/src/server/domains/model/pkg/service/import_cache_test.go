@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	buildFindOne "server/db/pkg/handler/build/find_one"
+	buildInsertOne "server/db/pkg/handler/build/insert_one"
+	problemFind "server/db/pkg/handler/problem/find"
+	problemFindOne "server/db/pkg/handler/problem/find_one"
+	t "server/db/pkg/types"
+)
+
+// countingRepos are problems and builds counting the round trips made to
+// them. Every problem has a default build once it was inserted.
+type countingRepos struct {
+	trips    int64
+	mu       sync.Mutex
+	problems map[string]t.Problem
+	builds   map[primitive.ObjectID]t.Build
+}
+
+func newCountingRepos(problems int) *countingRepos {
+	r := &countingRepos{problems: make(map[string]t.Problem), builds: make(map[primitive.ObjectID]t.Build)}
+	for i := 0; i < problems; i++ {
+		title := fmt.Sprintf("problem %d", i)
+		r.problems[title] = t.Problem{Id: primitive.NewObjectID(), Title: title}
+	}
+	return r
+}
+
+func (r *countingRepos) FindProblem(_ context.Context, req problemFindOne.RequestData) (t.Problem, error) {
+	atomic.AddInt64(&r.trips, 1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.problems[req.Title], nil
+}
+
+func (r *countingRepos) FindProblems(context.Context, problemFind.RequestData) (t.ProblemFindResponse, error) {
+	atomic.AddInt64(&r.trips, 1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := t.ProblemFindResponse{BaseList: t.BaseList{Total: int64(len(r.problems))}}
+	for _, problem := range r.problems {
+		result.Items = append(result.Items, problem)
+	}
+	return result, nil
+}
+
+func (r *countingRepos) FindBuild(_ context.Context, req buildFindOne.RequestData) (t.Build, error) {
+	atomic.AddInt64(&r.trips, 1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.builds[req.ProblemId], nil
+}
+
+func (r *countingRepos) InsertBuild(_ context.Context, req buildInsertOne.RequestData) (t.Build, error) {
+	atomic.AddInt64(&r.trips, 1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	build := t.Build{Id: primitive.NewObjectID(), ProblemId: req.ProblemId, Name: req.Name}
+	r.builds[req.ProblemId] = build
+	return build, nil
+}
+
+// lookUpTemplates looks up the problem and default build of templates
+// templates spread over problems problems, concurrently as ImportDirectory
+// does, and returns the round trips made.
+func lookUpTemplates(tb testing.TB, ctx context.Context, templates, problems int) int64 {
+	repos := newCountingRepos(problems)
+	s := &basicModelService{repos: Repositories{Problems: repos, Builds: repos}}
+	var wg sync.WaitGroup
+	for i := 0; i < templates; i++ {
+		wg.Add(1)
+		go func(title string) {
+			defer wg.Done()
+			problem, err := s.findProblem(ctx, title)
+			if err != nil {
+				tb.Error(err)
+				return
+			}
+			if _, err := s.findDefaultBuild(ctx, problem.Id); err != nil {
+				tb.Error(err)
+			}
+		}(fmt.Sprintf("problem %d", i%problems))
+	}
+	wg.Wait()
+	return atomic.LoadInt64(&repos.trips)
+}
+
+// A problem costs three round trips: finding it, not finding its default
+// build and inserting that.
+func TestImportCacheLooksUpEveryProblemOnce(test *testing.T) {
+	if trips := lookUpTemplates(test, withImportCache(context.Background()), 150, 4); trips != 3*4 {
+		test.Errorf("%d round trips for 4 problems, want 12", trips)
+	}
+	if trips := lookUpTemplates(test, context.Background(), 150, 4); trips <= 150 {
+		test.Errorf("%d round trips without the cache, want more than one per template", trips)
+	}
+}
+
+// BenchmarkImportCache reports the round trips of a batch of 150 templates
+// of 4 problems, with and without the cache.
+func BenchmarkImportCache(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		ctx  func() context.Context
+	}{
+		{"uncached", context.Background},
+		{"cached", func() context.Context { return withImportCache(context.Background()) }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var trips int64
+			for i := 0; i < b.N; i++ {
+				trips += lookUpTemplates(b, bench.ctx(), 150, 4)
+			}
+			b.ReportMetric(float64(trips)/float64(b.N), "trips/op")
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	fp "path/filepath"
+	"sort"
+	"sync"
+
+	t "server/db/pkg/types"
+	"server/kit/redact"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// DiffEntry is a file of the model folder a reimport added, changed or
+// removed, Bytes being its size. A removed file is only Deleted when the
+// import prunes.
+type DiffEntry struct {
+	Path    string `json:"path"`
+	Bytes   int64  `json:"bytes"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// ImportDiff tells what an import changed in the model folder. Unchanged
+// counts the files of the template already there with the same content.
+type ImportDiff struct {
+	Added     []DiffEntry `json:"added"`
+	Changed   []DiffEntry `json:"changed"`
+	Removed   []DiffEntry `json:"removed"`
+	Unchanged int         `json:"unchanged"`
+}
+
+func (d ImportDiff) isEmpty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// importDiff writes the files of an import to the model folder dir, only
+// those missing or whose content changed, and records the difference. The
+// files are compared by the sha256 of the manifest of the previous import,
+// or hashed when it does not list them.
+type importDiff struct {
+	dir      string
+	manifest map[string]string
+
+	mu   sync.Mutex
+	diff ImportDiff
+}
+
+func newImportDiff(dir string) *importDiff {
+	manifest, err := readManifest(dir)
+	if err != nil {
+		redact.Println("import_diff.newImportDiff.readManifest(dir)", err)
+	}
+	return &importDiff{
+		dir:      dir,
+		manifest: manifest,
+		diff:     ImportDiff{Added: []DiffEntry{}, Changed: []DiffEntry{}, Removed: []DiffEntry{}},
+	}
+}
+
+// result sorts the entries, the dependencies being fetched concurrently.
+func (d *importDiff) result() ImportDiff {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, entries := range [][]DiffEntry{d.diff.Added, d.diff.Changed, d.diff.Removed} {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	}
+	return d.diff
+}
+
+func (d *importDiff) record(entries *[]DiffEntry, entry DiffEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	*entries = append(*entries, entry)
+}
+
+func (d *importDiff) unchanged() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.diff.Unchanged++
+}
+
+func (d *importDiff) exists(rel string) bool {
+	_, err := os.Lstat(fp.Join(d.dir, rel))
+	return err == nil
+}
+
+// sha256 is the sha256 of the file at rel, which must exist.
+func (d *importDiff) sha256(rel string) string {
+	if sha, ok := d.manifest[fp.ToSlash(fp.Clean(rel))]; ok {
+		return sha
+	}
+	return getSha265(fp.Join(d.dir, rel))
+}
+
+// keeps tells whether the file at rel is left as it is under the overwrite
+// policy, being already there with the declared sha256 and size. It is then
+// counted unchanged.
+func (d *importDiff) keeps(rel, sha string, size int64, overwrite string) bool {
+	if !d.exists(rel) {
+		return false
+	}
+	keep := false
+	switch overwrite {
+	case OverwriteNever:
+		keep = true
+	case OverwriteChanged:
+		if _, listed := d.manifest[fp.ToSlash(fp.Clean(rel))]; listed {
+			keep = sha != "" && d.sha256(rel) == sha
+		} else {
+			keep = sha != "" && checkFile(fp.Join(d.dir, rel), sha, size) == nil
+		}
+	}
+	if keep {
+		d.unchanged()
+	}
+	return keep
+}
+
+// copy copies the file or folder src to rel in the model folder, the files of
+// a folder one by one. It returns how many files were copied.
+func (d *importDiff) copy(ctx context.Context, src, rel, overwrite string) (int, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return d.copyFile(ctx, src, rel, info.Size(), overwrite)
+	}
+	copied := 0
+	err = fp.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name, err := fp.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		n, err := d.copyFile(ctx, path, fp.Join(rel, name), info.Size(), overwrite)
+		copied += n
+		return err
+	})
+	return copied, err
+}
+
+func (d *importDiff) copyFile(ctx context.Context, src, rel string, size int64, overwrite string) (int, error) {
+	switch {
+	case !d.exists(rel):
+		d.record(&d.diff.Added, DiffEntry{Path: rel, Bytes: size})
+	case overwrite == OverwriteNever:
+		d.unchanged()
+		return 0, nil
+	case d.sha256(rel) == getSha265(src):
+		d.unchanged()
+		if overwrite != OverwriteAlways {
+			return 0, nil
+		}
+	default:
+		d.record(&d.diff.Changed, DiffEntry{Path: rel, Bytes: size})
+	}
+	dst := fp.Join(d.dir, rel)
+	if err := os.MkdirAll(fp.Dir(dst), 0777); err != nil {
+		return 0, err
+	}
+	return 1, copyFilesContext(ctx, src, dst)
+}
+
+// write writes data to rel in the model folder unless it is there already,
+// no data removing the file.
+func (d *importDiff) write(ctx context.Context, rel string, data []byte) error {
+	path := fp.Join(d.dir, rel)
+	if data == nil {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		d.record(&d.diff.Removed, DiffEntry{Path: rel, Bytes: info.Size(), Deleted: true})
+		return nil
+	}
+	if !d.exists(rel) {
+		d.record(&d.diff.Added, DiffEntry{Path: rel, Bytes: int64(len(data))})
+	} else if d.sha256(rel) == sha256Of(data) {
+		d.unchanged()
+		return nil
+	} else {
+		d.record(&d.diff.Changed, DiffEntry{Path: rel, Bytes: int64(len(data))})
+	}
+	return uFiles.WriteFileAtomicContext(ctx, path, data, 0644)
+}
+
+// fetched records the file fetch placed at rel, it is unchanged when fetch
+// left the file there in place.
+func (d *importDiff) fetched(rel string, fetch func() error) error {
+	path := fp.Join(d.dir, rel)
+	before, statErr := os.Lstat(path)
+	if err := fetch(); err != nil {
+		return err
+	}
+	after, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case statErr != nil:
+		d.record(&d.diff.Added, DiffEntry{Path: rel, Bytes: after.Size()})
+	case os.SameFile(before, after) && before.ModTime().Equal(after.ModTime()) && before.Size() == after.Size():
+		d.unchanged()
+	default:
+		d.record(&d.diff.Changed, DiffEntry{Path: rel, Bytes: after.Size()})
+	}
+	return nil
+}
+
+// prune records the config, dependencies, readme and previews of the previous
+// import of the model the template no longer has, and removes them when remove is set.
+func (s *basicModelService) prune(ctx context.Context, d *importDiff, previous t.Model, modelYml ModelYml, remove bool) {
+	if previous.Id.IsZero() {
+		return
+	}
+	kept := map[string]bool{fp.Clean(modelYml.configName()): true, "modules.yaml": true}
+	for _, dep := range modelYml.Dependencies {
+		kept[fp.Clean(dep.Destination)] = true
+	}
+	if modelYml.Readme != "" {
+		kept[readmeName] = true
+	}
+	if modelYml.Previews != "" {
+		kept[previewsFolder] = true
+	}
+	var names []string
+	if config, err := fp.Rel(previous.Dir, previous.ConfigPath); err == nil && previous.ConfigPath != "" {
+		names = append(names, config)
+	}
+	if previous.ReadmePath != "" {
+		names = append(names, readmeName)
+	}
+	if len(previous.Previews) > 0 {
+		names = append(names, previewsFolder)
+	}
+	for _, dep := range previous.Dependencies {
+		names = append(names, dep.Destination)
+	}
+	for _, name := range names {
+		name = fp.Clean(name)
+		if kept[name] || !isInsideDir(name) || !d.exists(name) {
+			continue
+		}
+		kept[name] = true
+		path := fp.Join(d.dir, name)
+		entry := DiffEntry{Path: name, Bytes: dirSize(path)}
+		if remove {
+			if err := s.paths.CheckWrite(ctx, path); err != nil {
+				redact.Println("import_diff.prune.s.paths.CheckWrite(ctx, path)", err)
+			} else if err := os.RemoveAll(path); err != nil {
+				redact.Println("import_diff.prune.os.RemoveAll(path)", err)
+			} else {
+				entry.Deleted = true
+			}
+		}
+		d.record(&d.diff.Removed, entry)
+	}
+}
+
+// sameTags tells whether the tags are the same, in the same order.
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sha256Of(data []byte) string {
+	h := sha256.New()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
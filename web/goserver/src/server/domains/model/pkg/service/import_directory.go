@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	typeOperation "server/db/pkg/types/type/operation"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/utils/basic/arrays"
+	uFiles "server/kit/utils/basic/files"
+	"server/kit/workpool"
+)
+
+// importBusyWait is how long a directory import waits for a free import slot
+// when the import queue is full.
+const importBusyWait = time.Second
+
+var templateFileNames = []string{"template.yaml", "template.yml"}
+
+// ImportDirectoryRequestData imports every template under RootPath, taken
+// from the template base when it is relative, running up to Concurrency
+// imports at once. Tags, Workspace, Options and Placeholders apply to every
+// template, as for UpdateFromLocal. Resume skips the templates the last
+// import of RootPath fully imported, unless they changed since. The request
+// is kept with the operation, so that ResumeOperation can resume it when it
+// is interrupted.
+type ImportDirectoryRequestData struct {
+	RootPath     string            `json:"rootPath"`
+	Concurrency  int               `json:"concurrency"`
+	Tags         []string          `json:"tags"`
+	Workspace    string            `json:"workspace,omitempty"`
+	Options      ImportOptions     `json:"options"`
+	Placeholders map[string]string `json:"placeholders,omitempty"`
+	Resume       bool              `json:"resume"`
+	NotifyRequest
+}
+
+// ImportDirectoryFailure is a template that could not be read or a model of
+// it that was not imported, in which case Name is set.
+type ImportDirectoryFailure struct {
+	Path string `json:"path"`
+	ImportFailure
+}
+
+// ImportDirectorySummary is the data of the last response, sent after one
+// response per model of the templates found. Skipped are the templates left
+// out by Resume, Cancelled those not imported because the import was
+// cancelled, they are imported when it is resumed.
+type ImportDirectorySummary struct {
+	Templates int                      `json:"templates"`
+	Succeeded int                      `json:"succeeded"`
+	Failed    int                      `json:"failed"`
+	Skipped   int                      `json:"skipped"`
+	Cancelled int                      `json:"cancelled"`
+	Failures  []ImportDirectoryFailure `json:"failures"`
+}
+
+func (s *basicModelService) ImportDirectory(ctx context.Context, req ImportDirectoryRequestData) chan kitendpoint.Response {
+	responseChan := make(chan kitendpoint.Response)
+	go func() {
+		ctx, temps := uFiles.WithTemps(ctx)
+		defer temps.RemoveAll()
+		ctx, held := withHeldSnapshots(ctx, s.snapshotLeases)
+		defer held.releaseAll()
+		ctx = withImportCache(ctx)
+		req.RootPath = s.paths.TemplatePath(req.RootPath)
+		subscription, err := s.subscription(ctx, req.NotifyRequest)
+		if err != nil {
+			responseChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		ctx, op := s.startOperation(ctx, typeOperation.ModelImport, subscription, responseChan)
+		op.onCancel(temps.RemoveAll)
+		op.keepRequest(ctx, req)
+		op.run(ctx)
+		op.finish(ctx, s.importDirectory(ctx, req, op), nil)
+	}()
+	return responseChan
+}
+
+// importDirectory imports the templates independently, a failed one does not
+// stop the others.
+func (s *basicModelService) importDirectory(ctx context.Context, req ImportDirectoryRequestData, op *operation) kitendpoint.Response {
+	if req.RootPath == "" {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: "rootPath is required"}}
+	}
+	if err := req.Options.Validate(); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}
+	}
+	if err := checkWorkspace(req.Workspace); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}
+	}
+	if err := s.paths.CheckTemplate(ctx, req.RootPath); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: err.Error()}}
+	}
+	templates, err := findTemplates(req.RootPath)
+	if err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}
+	}
+	if len(templates) == 0 {
+		err := fmt.Errorf("no template under %s", req.RootPath)
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}}
+	}
+	concurrency := req.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	summary := ImportDirectorySummary{Templates: len(templates), Failures: []ImportDirectoryFailure{}}
+	progress := s.loadImportProgress(req.RootPath, req.Resume)
+	progress.restore(op.Templates)
+	op.listTemplates(ctx, templates, progress)
+	var mu sync.Mutex
+	done := 0
+	pool := workpool.New(workpool.Options{Workers: concurrency, Hooks: workpool.Metrics("model_import_directory")})
+	for i, path := range templates {
+		if progress.done(path) {
+			mu.Lock()
+			summary.Skipped++
+			done++
+			mu.Unlock()
+			continue
+		}
+		path := path
+		err := pool.Go(ctx, func(ctx context.Context) {
+			resp := s.importDirectoryTemplate(ctx, path, req, op)
+			mu.Lock()
+			defer mu.Unlock()
+			if ctx.Err() != nil {
+				summary.Cancelled++
+			} else {
+				imported, failed := summary.add(path, resp)
+				op.recordTemplate(ctx, progress.key(path), progress.record(path, imported, failed))
+			}
+			done++
+			op.progress(ctx, float64(done)/float64(len(templates)))
+		})
+		if err != nil {
+			mu.Lock()
+			summary.Cancelled += len(templates) - i
+			mu.Unlock()
+			break
+		}
+	}
+	pool.Close(context.Background())
+	return kitendpoint.Response{Data: summary, Err: kitendpoint.Error{Code: 0}}
+}
+
+// importDirectoryTemplate imports the template at path once an import slot
+// is free. Template errors are streamed, the models of the template stream
+// their own responses.
+func (s *basicModelService) importDirectoryTemplate(ctx context.Context, path string, req ImportDirectoryRequestData, op *operation) kitendpoint.Response {
+	ticket := importTicket{Priority: ImportBatch, Problem: s.importProblem(ctx, path)}
+	if err := s.acquireImport(ctx, ticket); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}}
+	}
+	defer s.imports.Release(ticket)
+	resp := s.updateFromLocal(ctx, UpdateFromLocalRequestData{Path: path, Tags: req.Tags, Workspace: req.Workspace, Options: req.Options, Placeholders: req.Placeholders, Priority: ImportBatch}, op, nil)
+	if _, ok := resp.Data.(UpdateFromLocalSummary); !ok && resp.Err.Code > 0 {
+		op.send(kitendpoint.Response{Data: ImportDirectoryFailure{Path: path}, Err: resp.Err})
+	}
+	return resp
+}
+
+// acquireImport waits for an import slot for ticket, also while the import
+// queue is full.
+func (s *basicModelService) acquireImport(ctx context.Context, ticket importTicket) error {
+	for {
+		err := s.imports.Acquire(ctx, ticket, nil)
+		if err != ErrBusy {
+			return err
+		}
+		select {
+		case <-time.After(importBusyWait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// add counts the models of the template at path and returns how many were
+// imported and how many failed.
+func (summary *ImportDirectorySummary) add(path string, resp kitendpoint.Response) (imported, failed int) {
+	if result, ok := resp.Data.(UpdateFromLocalSummary); ok {
+		summary.Succeeded += len(result.Imported)
+		summary.Failed += len(result.Failed)
+		for _, failure := range result.Failed {
+			summary.Failures = append(summary.Failures, ImportDirectoryFailure{Path: path, ImportFailure: failure})
+		}
+		return len(result.Imported), len(result.Failed)
+	}
+	if resp.Err.Code > 0 {
+		summary.Failed++
+		summary.Failures = append(summary.Failures, ImportDirectoryFailure{
+			Path:          path,
+			ImportFailure: ImportFailure{Code: resp.Err.Code, Message: resp.Err.Message, Fields: resp.Err.Fields},
+		})
+		return 0, 1
+	}
+	return 0, 0
+}
+
+// findTemplates lists the templates under root. Hidden folders are skipped,
+// so are the folders that can not be read.
+func findTemplates(root string) ([]string, error) {
+	var templates []string
+	err := fp.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			log.Println("domains.model.pkg.service.import_directory.findTemplates", path, err)
+			return nil
+		}
+		if info.IsDir() {
+			if path != root && strings.HasPrefix(info.Name(), ".") {
+				return fp.SkipDir
+			}
+			return nil
+		}
+		if arrays.ContainsString(templateFileNames, info.Name()) {
+			templates = append(templates, path)
+		}
+		return nil
+	})
+	return templates, err
+}
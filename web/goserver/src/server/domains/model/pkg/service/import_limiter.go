@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrBusy = errors.New("too many imports in progress, retry later")
+
+const importRetryAfter = 30 * time.Second
+
+// Priorities of the imports. Interactive imports, the default, are served
+// before the batch ones, those of ImportDirectory.
+const (
+	ImportInteractive = "interactive"
+	ImportBatch       = "batch"
+)
+
+// ImportWeights are the shares of the freed slots going to the interactive
+// and the batch imports while both wait, so that the batch imports are
+// delayed rather than starved. Weights below 1 are taken for 1.
+type ImportWeights struct {
+	Interactive int
+	Batch       int
+}
+
+// checkImportPriority returns an error for a priority that is neither
+// interactive nor batch, the empty one being interactive.
+func checkImportPriority(priority string) error {
+	switch priority {
+	case "", ImportInteractive, ImportBatch:
+		return nil
+	}
+	return fmt.Errorf("priority %q is neither %q nor %q", priority, ImportInteractive, ImportBatch)
+}
+
+// importTicket tells the queue what an import is: its priority and the
+// problem it imports into, the imports of each problem sharing the slots
+// fairly with those of the others.
+type importTicket struct {
+	Priority string
+	Problem  string
+}
+
+func (t importTicket) priority() string {
+	if t.Priority == "" {
+		return ImportInteractive
+	}
+	return t.Priority
+}
+
+type importWaiter struct {
+	ticket   importTicket
+	granted  bool
+	position int
+	// moved is signalled when the waiter was granted a slot or its position
+	// changed.
+	moved chan struct{}
+}
+
+func (w *importWaiter) signal() {
+	select {
+	case w.moved <- struct{}{}:
+	default:
+	}
+}
+
+// importLimiter bounds the number of imports running at once. Requests over
+// the limit wait in a queue of maxQueued entries, beyond that they are
+// rejected with ErrBusy. A freed slot goes to the interactive or the batch
+// imports by their weights, then to the waiting import of the problem that
+// runs the fewest, the oldest first. The bounds can change while imports
+// run, imports over a lowered limit finish normally.
+type importLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	maxQueued int
+	weights   ImportWeights
+	active    int
+	byProblem map[string]int
+	waiters   []*importWaiter
+	// pass is, by priority, the virtual time of the next slot it is given,
+	// each slot pushing it by the inverse of its weight. now is the pass of
+	// the last slot given, a priority idle until then starting from it.
+	pass map[string]float64
+	now  float64
+}
+
+func newImportLimiter(limit, maxQueued int, weights ImportWeights) *importLimiter {
+	l := &importLimiter{byProblem: make(map[string]int), pass: make(map[string]float64)}
+	l.Resize(limit, maxQueued, weights)
+	return l
+}
+
+// Resize sets new bounds and weights, starting the queued imports that now
+// fit.
+func (l *importLimiter) Resize(limit, maxQueued int, weights ImportWeights) {
+	if limit <= 0 {
+		limit = 1
+	}
+	if maxQueued < 0 {
+		maxQueued = 0
+	}
+	if weights.Interactive < 1 {
+		weights.Interactive = 1
+	}
+	if weights.Batch < 1 {
+		weights.Batch = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.maxQueued = maxQueued
+	l.weights = weights
+	l.dispatch()
+}
+
+// Acquire waits for a slot for the import of ticket. queued, when set, is
+// told the position of the import in the queue whenever it changes, 1 being
+// the next to start, and is not called when a slot is free at once.
+func (l *importLimiter) Acquire(ctx context.Context, ticket importTicket, queued func(position int)) error {
+	l.mu.Lock()
+	if l.active >= l.limit && len(l.waiters) >= l.maxQueued {
+		l.mu.Unlock()
+		return ErrBusy
+	}
+	w := &importWaiter{ticket: ticket, moved: make(chan struct{}, 1)}
+	l.waiters = append(l.waiters, w)
+	l.dispatch()
+	for !w.granted {
+		position := w.position
+		l.mu.Unlock()
+		if queued != nil {
+			queued(position)
+		}
+		for {
+			select {
+			case <-w.moved:
+			case <-ctx.Done():
+				l.mu.Lock()
+				if w.granted {
+					l.mu.Unlock()
+					l.Release(ticket)
+				} else {
+					l.remove(w)
+					l.dispatch()
+					l.mu.Unlock()
+				}
+				return ctx.Err()
+			}
+			l.mu.Lock()
+			if w.granted || w.position != position {
+				break
+			}
+			l.mu.Unlock()
+		}
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+// Release frees the slot of the import of ticket.
+func (l *importLimiter) Release(ticket importTicket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	if l.byProblem[ticket.Problem]--; l.byProblem[ticket.Problem] <= 0 {
+		delete(l.byProblem, ticket.Problem)
+	}
+	l.dispatch()
+}
+
+// dispatch starts the waiting imports the free slots allow and renumbers
+// the others. The caller holds l.mu.
+func (l *importLimiter) dispatch() {
+	for l.active < l.limit && len(l.waiters) > 0 {
+		w := l.next(l.waiters, l.byProblem, l.pass, &l.now)
+		l.remove(w)
+		l.active++
+		l.byProblem[w.ticket.Problem]++
+		w.granted = true
+		w.signal()
+	}
+	// The positions are those the imports would start in if none was
+	// cancelled, none released before the others started.
+	waiters := append([]*importWaiter(nil), l.waiters...)
+	byProblem := make(map[string]int, len(l.byProblem))
+	for problem, n := range l.byProblem {
+		byProblem[problem] = n
+	}
+	pass := make(map[string]float64, len(l.pass))
+	for priority, p := range l.pass {
+		pass[priority] = p
+	}
+	now := l.now
+	for position := 1; len(waiters) > 0; position++ {
+		w := l.next(waiters, byProblem, pass, &now)
+		for i := range waiters {
+			if waiters[i] == w {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		byProblem[w.ticket.Problem]++
+		if w.position != position {
+			w.position = position
+			w.signal()
+		}
+	}
+}
+
+// next picks the import of waiters to start next and charges its priority
+// for it in pass and now.
+func (l *importLimiter) next(waiters []*importWaiter, byProblem map[string]int, pass map[string]float64, now *float64) *importWaiter {
+	priority := ""
+	for _, p := range []string{ImportInteractive, ImportBatch} {
+		if pass[p] < *now {
+			pass[p] = *now
+		}
+		waiting := false
+		for _, w := range waiters {
+			if w.ticket.priority() == p {
+				waiting = true
+				break
+			}
+		}
+		if waiting && (priority == "" || pass[p] < pass[priority]) {
+			priority = p
+		}
+	}
+	var next *importWaiter
+	for _, w := range waiters {
+		if w.ticket.priority() == priority && (next == nil || byProblem[w.ticket.Problem] < byProblem[next.ticket.Problem]) {
+			next = w
+		}
+	}
+	weight := l.weights.Interactive
+	if priority == ImportBatch {
+		weight = l.weights.Batch
+	}
+	*now = pass[priority]
+	pass[priority] += 1 / float64(weight)
+	return next
+}
+
+// remove takes w out of the queue. The caller holds l.mu.
+func (l *importLimiter) remove(w *importWaiter) {
+	for i := range l.waiters {
+		if l.waiters[i] == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (l *importLimiter) Stats() (active, queued int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active, len(l.waiters)
+}
+
+func (l *importLimiter) Limits() (limit, maxQueued int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit, l.maxQueued
+}
+
+// ImportSettings holds the import limits, default import options and
+// post_import bounds of the service. They are read at the start of every
+// import, so Set takes effect for the imports that follow, as after a
+// configuration reload.
+type ImportSettings struct {
+	*importLimiter
+
+	mu      sync.RWMutex
+	options ImportOptions
+	hooks   PostImportHooks
+}
+
+func NewImportSettings(limit, maxQueued int, weights ImportWeights, options ImportOptions, hooks PostImportHooks) *ImportSettings {
+	return &ImportSettings{
+		importLimiter: newImportLimiter(limit, maxQueued, weights),
+		options:       options.merge(defaultImportOptions),
+		hooks:         hooks,
+	}
+}
+
+func (s *ImportSettings) Set(limit, maxQueued int, weights ImportWeights, options ImportOptions, hooks PostImportHooks) {
+	s.Resize(limit, maxQueued, weights)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.options = options.merge(defaultImportOptions)
+	s.hooks = hooks
+}
+
+// Options are the default import options, requests override them.
+func (s *ImportSettings) Options() ImportOptions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.options
+}
+
+// Hooks bounds the post_import commands of the templates. Requests can not
+// override them.
+func (s *ImportSettings) Hooks() PostImportHooks {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hooks
+}
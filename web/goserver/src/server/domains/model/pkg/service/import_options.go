@@ -0,0 +1,268 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	u "server/kit/utils"
+	"server/kit/utils/basic/arrays"
+)
+
+// Verify modes of downloaded dependencies.
+const (
+	VerifyStrict = "strict"
+	VerifySize   = "size"
+	VerifyNone   = "none"
+)
+
+// Overwrite policies for dependencies whose destination already exists.
+// OverwriteChanged keeps a destination matching the declared size and sha256,
+// so reimports do not fetch again what they already have.
+const (
+	OverwriteAlways  = "always"
+	OverwriteNever   = "never"
+	OverwriteChanged = "changed"
+)
+
+// Config checks, made on the config of a model once it is copied. The config
+// must not reference files the model folder lacks: ConfigCheckWarn reports
+// them in the warnings of the import, ConfigCheckStrict fails the import.
+const (
+	ConfigCheckOff    = "off"
+	ConfigCheckWarn   = "warn"
+	ConfigCheckStrict = "strict"
+)
+
+// ImportOptions tunes how the files of an import are fetched and placed. Zero
+// fields take the value of the service defaults, which come from the
+// importOptions setting, and then of defaultImportOptions, so the zero value
+// keeps the usual behavior.
+type ImportOptions struct {
+	// MaxAttempts is the number of tries of a download.
+	MaxAttempts int `json:"maxAttempts" yaml:"maxAttempts"`
+	// BackoffSeconds is the wait after a failed try, doubled after each.
+	BackoffSeconds int `json:"backoffSeconds" yaml:"backoffSeconds"`
+	// DownloadTimeoutSeconds limits each try of a download.
+	DownloadTimeoutSeconds int `json:"downloadTimeoutSeconds" yaml:"downloadTimeoutSeconds"`
+	// DependencyTimeoutSeconds limits the whole fetch of a dependency, which
+	// fails alone past it. No limit when zero.
+	DependencyTimeoutSeconds int `json:"dependencyTimeoutSeconds" yaml:"dependencyTimeoutSeconds"`
+	// Concurrency is the number of dependencies fetched at once.
+	Concurrency int `json:"concurrency" yaml:"concurrency"`
+	// TempDir is where downloads land until verified, the destination
+	// folder by default.
+	TempDir string `json:"tempDir" yaml:"tempDir"`
+	// Verify is VerifyStrict, VerifySize or VerifyNone.
+	Verify string `json:"verify" yaml:"verify"`
+	// Overwrite is OverwriteAlways, OverwriteNever or OverwriteChanged.
+	Overwrite string `json:"overwrite" yaml:"overwrite"`
+	// MaxDownloadBytes bounds every download. An import can only lower it.
+	MaxDownloadBytes int64 `json:"maxDownloadBytes" yaml:"maxDownloadBytes"`
+	// AllowedHosts, when set, are the only hosts downloaded from. An import
+	// can only narrow them.
+	AllowedHosts []string `json:"allowedHosts" yaml:"allowedHosts"`
+	// UserAgent introduces the downloads, the server name and version by
+	// default.
+	UserAgent string `json:"userAgent" yaml:"userAgent"`
+	// ConfigCheck is ConfigCheckOff, ConfigCheckWarn or ConfigCheckStrict.
+	ConfigCheck string `json:"configCheck" yaml:"configCheck"`
+	// SampleRegions is the number of blocks sampled for VerifyModel.
+	SampleRegions int `json:"sampleRegions" yaml:"sampleRegions"`
+	// Manifest writes the sha256 of the files to manifest.sha256.
+	Manifest *bool `json:"manifest" yaml:"manifest"`
+	// HashWorkers is the number of files hashed at once.
+	HashWorkers int `json:"hashWorkers" yaml:"hashWorkers"`
+	// Cas stores all the files in the snapshot store, the folder keeping
+	// hardlinks, which must be replaced rather than written over.
+	Cas *bool `json:"cas" yaml:"cas"`
+	// Prune removes from a reimported model the files the template dropped.
+	Prune *bool `json:"prune" yaml:"prune"`
+	// RecordChecksums records the size and sha256 of the dependencies
+	// declared without them.
+	RecordChecksums *bool `json:"recordChecksums" yaml:"recordChecksums"`
+	// UpdateTemplate writes the recorded checksums to the imported template.
+	UpdateTemplate *bool `json:"updateTemplate" yaml:"updateTemplate"`
+	// AllowDuplicateNames imports a model named as one of another problem
+	// with a warning instead of ErrCodeConflict.
+	AllowDuplicateNames *bool `json:"allowDuplicateNames" yaml:"allowDuplicateNames"`
+
+	// noHostAllowed is set by merge when the hosts allowed by the import
+	// and by the service have none in common.
+	noHostAllowed bool
+}
+
+const maxBackoff = 30 * time.Second
+
+// maxRetryAfter bounds the wait a host asks for with Retry-After.
+const maxRetryAfter = 15 * time.Minute
+
+var defaultImportOptions = ImportOptions{
+	MaxAttempts:            10,
+	BackoffSeconds:         1,
+	DownloadTimeoutSeconds: 60 * 60,
+	Concurrency:            1,
+	Verify:                 VerifyStrict,
+	Overwrite:              OverwriteChanged,
+	ConfigCheck:            ConfigCheckWarn,
+	SampleRegions:          3,
+	HashWorkers:            4,
+}
+
+// merge fills the zero fields of o from defaults. The limits of defaults,
+// AllowedHosts and MaxDownloadBytes, can only be narrowed by o: the hosts
+// allowed are those both allow, the size limit the smaller one.
+func (o ImportOptions) merge(defaults ImportOptions) ImportOptions {
+	if o.MaxAttempts == 0 {
+		o.MaxAttempts = defaults.MaxAttempts
+	}
+	if o.BackoffSeconds == 0 {
+		o.BackoffSeconds = defaults.BackoffSeconds
+	}
+	if o.DownloadTimeoutSeconds == 0 {
+		o.DownloadTimeoutSeconds = defaults.DownloadTimeoutSeconds
+	}
+	if o.DependencyTimeoutSeconds == 0 {
+		o.DependencyTimeoutSeconds = defaults.DependencyTimeoutSeconds
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = defaults.Concurrency
+	}
+	if o.TempDir == "" {
+		o.TempDir = defaults.TempDir
+	}
+	if o.Verify == "" {
+		o.Verify = defaults.Verify
+	}
+	if o.Overwrite == "" {
+		o.Overwrite = defaults.Overwrite
+	}
+	if o.MaxDownloadBytes == 0 || (defaults.MaxDownloadBytes > 0 && defaults.MaxDownloadBytes < o.MaxDownloadBytes) {
+		o.MaxDownloadBytes = defaults.MaxDownloadBytes
+	}
+	o.noHostAllowed = o.noHostAllowed || defaults.noHostAllowed
+	switch {
+	case len(o.AllowedHosts) == 0:
+		o.AllowedHosts = defaults.AllowedHosts
+	case len(defaults.AllowedHosts) > 0:
+		var hosts []string
+		for _, host := range o.AllowedHosts {
+			if arrays.ContainsString(defaults.AllowedHosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+		o.AllowedHosts = hosts
+		o.noHostAllowed = o.noHostAllowed || len(hosts) == 0
+	}
+	if o.UserAgent == "" {
+		o.UserAgent = defaults.UserAgent
+	}
+	if o.ConfigCheck == "" {
+		o.ConfigCheck = defaults.ConfigCheck
+	}
+	if o.SampleRegions == 0 {
+		o.SampleRegions = defaults.SampleRegions
+	}
+	if o.Manifest == nil {
+		o.Manifest = defaults.Manifest
+	}
+	if o.HashWorkers == 0 {
+		o.HashWorkers = defaults.HashWorkers
+	}
+	if o.Cas == nil {
+		o.Cas = defaults.Cas
+	}
+	if o.Prune == nil {
+		o.Prune = defaults.Prune
+	}
+	if o.RecordChecksums == nil {
+		o.RecordChecksums = defaults.RecordChecksums
+	}
+	if o.UpdateTemplate == nil {
+		o.UpdateTemplate = defaults.UpdateTemplate
+	}
+	if o.AllowDuplicateNames == nil {
+		o.AllowDuplicateNames = defaults.AllowDuplicateNames
+	}
+	return o
+}
+
+// isSet tells whether an option left nil, for the default, is set.
+func isSet(option *bool) bool {
+	return option != nil && *option
+}
+
+// Validate checks the options, zero fields are valid as they take defaults.
+func (o ImportOptions) Validate() error {
+	if o.MaxAttempts < 0 || o.BackoffSeconds < 0 || o.DownloadTimeoutSeconds < 0 || o.DependencyTimeoutSeconds < 0 || o.Concurrency < 0 || o.MaxDownloadBytes < 0 || o.SampleRegions < 0 || o.HashWorkers < 0 {
+		return fmt.Errorf("import options must not be negative")
+	}
+	switch o.Verify {
+	case "", VerifyStrict, VerifySize, VerifyNone:
+	default:
+		return fmt.Errorf("unknown verify mode %q", o.Verify)
+	}
+	switch o.Overwrite {
+	case "", OverwriteAlways, OverwriteNever, OverwriteChanged:
+	default:
+		return fmt.Errorf("unknown overwrite policy %q", o.Overwrite)
+	}
+	switch o.ConfigCheck {
+	case "", ConfigCheckOff, ConfigCheckWarn, ConfigCheckStrict:
+	default:
+		return fmt.Errorf("unknown config check %q", o.ConfigCheck)
+	}
+	return nil
+}
+
+// backoff is the wait before the attempt following the given failed one.
+func (o ImportOptions) backoff(attempt int) time.Duration {
+	d := time.Duration(o.BackoffSeconds) * time.Second
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+func (o ImportOptions) userAgent() string {
+	if o.UserAgent == "" {
+		return u.DefaultUserAgent()
+	}
+	return o.UserAgent
+}
+
+func (o ImportOptions) downloadTimeout() time.Duration {
+	return time.Duration(o.DownloadTimeoutSeconds) * time.Second
+}
+
+func (o ImportOptions) dependencyTimeout() time.Duration {
+	return time.Duration(o.DependencyTimeoutSeconds) * time.Second
+}
+
+// checkSource refuses downloads from hosts outside AllowedHosts and of
+// dependencies declared larger than MaxDownloadBytes.
+func (o ImportOptions) checkSource(source string, size int64) error {
+	if len(o.AllowedHosts) > 0 || o.noHostAllowed {
+		u, err := url.Parse(source)
+		if err != nil {
+			return err
+		}
+		allowed := false
+		for _, host := range o.AllowedHosts {
+			if u.Hostname() == host {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("host %q is not allowed", u.Hostname())
+		}
+	}
+	if o.MaxDownloadBytes > 0 && size > o.MaxDownloadBytes {
+		return fmt.Errorf("size %d exceeds the limit of %d bytes", size, o.MaxDownloadBytes)
+	}
+	return nil
+}
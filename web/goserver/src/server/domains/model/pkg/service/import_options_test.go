@@ -0,0 +1,53 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImportOptionsMergeNarrowsLimits(test *testing.T) {
+	server := ImportOptions{AllowedHosts: []string{"a.example", "b.example"}, MaxDownloadBytes: 100}
+	cases := []struct {
+		name     string
+		request  ImportOptions
+		hosts    []string
+		maxBytes int64
+		allowed  map[string]bool
+	}{
+		{"defaults", ImportOptions{}, []string{"a.example", "b.example"}, 100, map[string]bool{"a.example": true, "c.example": false}},
+		{"narrower", ImportOptions{AllowedHosts: []string{"b.example"}, MaxDownloadBytes: 10}, []string{"b.example"}, 10, map[string]bool{"a.example": false, "b.example": true}},
+		{"wider", ImportOptions{AllowedHosts: []string{"b.example", "c.example"}, MaxDownloadBytes: 1000}, []string{"b.example"}, 100, map[string]bool{"b.example": true, "c.example": false}},
+		{"disjoint", ImportOptions{AllowedHosts: []string{"c.example"}}, nil, 100, map[string]bool{"a.example": false, "c.example": false}},
+	}
+	for _, c := range cases {
+		merged := c.request.merge(server)
+		if !reflect.DeepEqual(merged.AllowedHosts, c.hosts) || merged.MaxDownloadBytes != c.maxBytes {
+			test.Errorf("%s: hosts %v, max %d, want %v, %d", c.name, merged.AllowedHosts, merged.MaxDownloadBytes, c.hosts, c.maxBytes)
+		}
+		for host, allowed := range c.allowed {
+			if err := merged.checkSource("https://"+host+"/file", 0); (err == nil) != allowed {
+				test.Errorf("%s: checkSource(%s) = %v, allowed %v", c.name, host, err, allowed)
+			}
+		}
+		if err := merged.checkSource("https://b.example/file", c.maxBytes+1); err == nil {
+			test.Errorf("%s: a download larger than %d bytes was allowed", c.name, c.maxBytes)
+		}
+	}
+
+	unlimited := ImportOptions{AllowedHosts: []string{"c.example"}, MaxDownloadBytes: 5}.merge(ImportOptions{})
+	if !reflect.DeepEqual(unlimited.AllowedHosts, []string{"c.example"}) || unlimited.MaxDownloadBytes != 5 {
+		test.Errorf("a service without limits: hosts %v, max %d", unlimited.AllowedHosts, unlimited.MaxDownloadBytes)
+	}
+}
+
+func TestImportOptionsMergeTurnsOffDefaults(test *testing.T) {
+	on, off := true, false
+	server := ImportOptions{Manifest: &on, Cas: &on, Prune: &on, RecordChecksums: &on, UpdateTemplate: &on, AllowDuplicateNames: &on}
+	merged := ImportOptions{Manifest: &off, Prune: &off}.merge(server)
+	if isSet(merged.Manifest) || isSet(merged.Prune) {
+		test.Errorf("options set to false were turned on by the defaults")
+	}
+	if !isSet(merged.Cas) || !isSet(merged.RecordChecksums) || !isSet(merged.UpdateTemplate) || !isSet(merged.AllowDuplicateNames) {
+		test.Errorf("options left unset did not take the defaults")
+	}
+}
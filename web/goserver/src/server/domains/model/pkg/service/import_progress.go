@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"time"
+
+	t "server/db/pkg/types"
+	"server/kit/redact"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// importProgressFolder holds the progress of the directory imports, in the
+// training folder, one file per root path.
+const importProgressFolder = ".import_progress"
+
+// Statuses of a template in the progress of a directory import, pending
+// ones being only listed in its operation.
+const (
+	importPending   = "pending"
+	importSucceeded = "succeeded"
+	importFailed    = "failed"
+)
+
+// importProgress records which templates of a directory import are done, so
+// an interrupted import can resume where it stopped. It is rewritten after
+// each template, a template whose import was cancelled is not recorded.
+type importProgress struct {
+	path string
+
+	RootPath  string                            `json:"rootPath"`
+	StartedAt time.Time                         `json:"startedAt"`
+	UpdatedAt time.Time                         `json:"updatedAt"`
+	Templates map[string]importProgressTemplate `json:"templates"`
+}
+
+// importProgressTemplate is a template done, Sha256 being that of the
+// template file imported, so an edited template is imported again.
+type importProgressTemplate struct {
+	Status     string    `json:"status"`
+	Sha256     string    `json:"sha256"`
+	Imported   int       `json:"imported"`
+	Failed     int       `json:"failed"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+func (s *basicModelService) importProgressPath(rootPath string) string {
+	return fp.Join(s.trainingsPath, importProgressFolder, sha256Of([]byte(fp.Clean(rootPath)))+".json")
+}
+
+// loadImportProgress reads the progress of rootPath when resume is set and
+// starts a new one otherwise, or when there is none to resume.
+func (s *basicModelService) loadImportProgress(rootPath string, resume bool) *importProgress {
+	progress := &importProgress{
+		path:      s.importProgressPath(rootPath),
+		RootPath:  fp.Clean(rootPath),
+		StartedAt: time.Now(),
+		Templates: make(map[string]importProgressTemplate),
+	}
+	if !resume {
+		return progress
+	}
+	b, err := ioutil.ReadFile(progress.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			redact.Println("import_progress.loadImportProgress.ioutil.ReadFile(progress.path)", err)
+		}
+		return progress
+	}
+	var previous importProgress
+	if err := json.Unmarshal(b, &previous); err != nil || previous.RootPath != progress.RootPath {
+		redact.Println("import_progress.loadImportProgress: progress not resumed", progress.path, err)
+		return progress
+	}
+	previous.path = progress.path
+	if previous.Templates == nil {
+		previous.Templates = progress.Templates
+	}
+	return &previous
+}
+
+// done tells whether the template at path was imported by the previous run,
+// as it is now.
+func (p *importProgress) done(path string) bool {
+	template, ok := p.Templates[p.key(path)]
+	if !ok || template.Status != importSucceeded {
+		return false
+	}
+	b, err := ioutil.ReadFile(path)
+	return err == nil && sha256Of(b) == template.Sha256
+}
+
+// restore takes the templates done before the operation of the import was
+// interrupted, which are known also when the progress of the root was not
+// resumed or was overwritten since.
+func (p *importProgress) restore(templates []t.OperationTemplate) {
+	for _, template := range templates {
+		if template.Status != importSucceeded && template.Status != importFailed {
+			continue
+		}
+		p.Templates[template.Path] = importProgressTemplate{
+			Status:     template.Status,
+			Sha256:     template.Sha256,
+			Imported:   template.Imported,
+			Failed:     template.Failed,
+			FinishedAt: template.FinishedAt,
+		}
+	}
+}
+
+// record saves the outcome of the template at path, the imports of the
+// other templates are not lost when the write fails.
+func (p *importProgress) record(path string, imported, failed int) importProgressTemplate {
+	template := importProgressTemplate{Status: importSucceeded, Imported: imported, Failed: failed, FinishedAt: time.Now()}
+	if failed > 0 {
+		template.Status = importFailed
+	}
+	if b, err := ioutil.ReadFile(path); err == nil {
+		template.Sha256 = sha256Of(b)
+	}
+	p.Templates[p.key(path)] = template
+	p.UpdatedAt = template.FinishedAt
+	if err := p.save(); err != nil {
+		redact.Println("import_progress.importProgress.record.p.save()", err)
+	}
+	return template
+}
+
+func (p *importProgress) save() error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return uFiles.WriteFileAtomic(p.path, b, 0644)
+}
+
+// key is the path of the template relative to the root.
+func (p *importProgress) key(path string) string {
+	rel, err := fp.Rel(p.RootPath, path)
+	if err != nil {
+		return path
+	}
+	return fp.ToSlash(rel)
+}
+
+// listTemplates keeps the templates of a directory import in op, as pending
+// unless progress has them done, so the import can be resumed from op alone.
+func (op *operation) listTemplates(ctx context.Context, templates []string, progress *importProgress) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.Templates = make([]t.OperationTemplate, len(templates))
+	for i, path := range templates {
+		key := progress.key(path)
+		op.Templates[i] = t.OperationTemplate{Path: key, Status: importPending}
+		if template, ok := progress.Templates[key]; ok && progress.done(path) {
+			op.Templates[i] = operationTemplate(key, template)
+		}
+	}
+	op.save(ctx)
+}
+
+// recordTemplate stores in op the outcome of the template at key.
+func (op *operation) recordTemplate(ctx context.Context, key string, template importProgressTemplate) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	for i := range op.Templates {
+		if op.Templates[i].Path == key {
+			op.Templates[i] = operationTemplate(key, template)
+		}
+	}
+	op.save(ctx)
+}
+
+func operationTemplate(key string, template importProgressTemplate) t.OperationTemplate {
+	return t.OperationTemplate{
+		Path:       key,
+		Status:     template.Status,
+		Sha256:     template.Sha256,
+		Imported:   template.Imported,
+		Failed:     template.Failed,
+		FinishedAt: template.FinishedAt,
+	}
+}
@@ -6,13 +6,72 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	modelFind "server/db/pkg/handler/model/find"
+	t "server/db/pkg/types"
 	kitendpoint "server/kit/endpoint"
 )
 
+// headlineMetrics is the number of metrics of every evaluation listed, the
+// first ones of the template. GetDetails has them all.
+const headlineMetrics = 5
+
+// summaryFields are the fields of the models read for ModelSummary.
+var summaryFields = []string{"_id", "archived", "name", "problemId", "parentModelId", "status", "dir", "framework", "snapshotFormat", "tags", "license", "evaluates", "workspace"}
+
+// ListRequestData lists the models of a problem, the archived ones only when
+// IncludeArchived is set, and those of Workspace, with NameContains in their
+// name, whatever the case, with all the Tags or of training Status only when
+// they are set.
 type ListRequestData struct {
-	Page      int64              `json:"page"`
-	Size      int64              `json:"size"`
-	ProblemId primitive.ObjectID `json:"problemId"`
+	Page            int64              `json:"page"`
+	Size            int64              `json:"size"`
+	ProblemId       primitive.ObjectID `json:"problemId"`
+	Workspace       string             `json:"workspace,omitempty"`
+	NameContains    string             `json:"nameContains,omitempty"`
+	Tags            []string           `json:"tags"`
+	Status          string             `json:"status,omitempty"`
+	IncludeArchived bool               `json:"includeArchived"`
+}
+
+// findRequest is the request of the database service for the summaries of
+// the models req lists.
+func (req ListRequestData) findRequest() modelFind.RequestData {
+	return modelFind.RequestData{
+		Page:            req.Page,
+		Size:            req.Size,
+		ProblemId:       req.ProblemId,
+		Workspace:       req.Workspace,
+		NameContains:    req.NameContains,
+		Tags:            req.Tags,
+		Status:          req.Status,
+		Fields:          summaryFields,
+		IncludeArchived: req.IncludeArchived,
+	}
+}
+
+// ModelSummary is a model as listed, its evaluations limited to their
+// status and headline metrics.
+type ModelSummary struct {
+	Id             primitive.ObjectID    `json:"id"`
+	Name           string                `json:"name"`
+	ProblemId      primitive.ObjectID    `json:"problemId"`
+	ParentModelId  primitive.ObjectID    `json:"parentModelId"`
+	Status         string                `json:"status"`
+	Dir            string                `json:"dir"`
+	Framework      string                `json:"framework"`
+	SnapshotFormat string                `json:"snapshotFormat"`
+	Tags           []string              `json:"tags"`
+	License        string                `json:"license,omitempty"`
+	Workspace      string                `json:"workspace,omitempty"`
+	Archived       bool                  `json:"archived"`
+	Evaluates      map[string]t.Evaluate `json:"evaluates"`
+	// StaleEvaluations counts the evaluations made on builds whose assets
+	// changed since, their staleness not dismissed.
+	StaleEvaluations int `json:"staleEvaluations"`
+}
+
+type ListResponseData struct {
+	t.BaseList
+	Items []ModelSummary `json:"items"`
 }
 
 func (s *basicModelService) List(
@@ -20,18 +79,15 @@ func (s *basicModelService) List(
 	req ListRequestData,
 ) chan kitendpoint.Response {
 	returnChan := make(chan kitendpoint.Response)
-	respChan := modelFind.Send(
-		ctx,
-		s.Conn,
-		modelFind.RequestData{
-			Page:      req.Page,
-			Size:      req.Size,
-			ProblemId: req.ProblemId,
-		},
-	)
+	respChan := modelFind.Send(ctx, s.Conn, req.findRequest())
 	go func() {
 		defer close(returnChan)
 		for r := range respChan {
+			if r.Err.Code == 0 {
+				found := r.Data.(modelFind.ResponseData)
+				found.Items = s.localModels(found.Items)
+				r.Data = summarizeModels(found)
+			}
 			returnChan <- r
 			if r.IsLast {
 				return
@@ -40,3 +96,42 @@ func (s *basicModelService) List(
 	}()
 	return returnChan
 }
+
+func summarizeModels(found t.ModelFindResponse) ListResponseData {
+	result := ListResponseData{BaseList: found.BaseList, Items: make([]ModelSummary, 0, len(found.Items))}
+	for _, model := range found.Items {
+		result.Items = append(result.Items, summarizeModel(model))
+	}
+	return result
+}
+
+func summarizeModel(model t.Model) ModelSummary {
+	evaluates := make(map[string]t.Evaluate, len(model.Evaluates))
+	stale := 0
+	for buildId, evaluate := range model.Evaluates {
+		if len(evaluate.Metrics) > headlineMetrics {
+			evaluate.Metrics = evaluate.Metrics[:headlineMetrics]
+		}
+		if evaluate.Stale && !evaluate.StaleDismissed {
+			stale++
+		}
+		evaluates[buildId] = evaluate
+	}
+	return ModelSummary{
+		Id:             model.Id,
+		Name:           model.Name,
+		ProblemId:      model.ProblemId,
+		ParentModelId:  model.ParentModelId,
+		Status:         model.Status,
+		Dir:            model.Dir,
+		Framework:      model.Framework,
+		SnapshotFormat: model.SnapshotFormat,
+		Tags:           model.Tags,
+		License:        model.License,
+		Workspace:      model.Workspace,
+		Archived:       model.Archived,
+		Evaluates:      evaluates,
+
+		StaleEvaluations: stale,
+	}
+}
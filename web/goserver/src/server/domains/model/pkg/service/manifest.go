@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"time"
+
+	"server/kit/storage"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// manifestName is the file listing the sha256 of every file of a model
+// folder, in the format of sha256sum.
+const manifestName = "manifest.sha256"
+
+// manifestProgressInterval bounds how often the hashing of a manifest
+// updates the progress of the import.
+const manifestProgressInterval = time.Second
+
+// writeManifest hashes the files of the model folder dir and writes them to
+// its manifest, through the storage. progress, if set, is told the share of
+// the bytes hashed.
+func (s *basicModelService) writeManifest(ctx context.Context, dir string, workers int, progress func(hashed float64)) error {
+	path := fp.Join(dir, manifestName)
+	if err := s.removeModelFile(ctx, path); err != nil && !storage.IsNotExist(err) {
+		return err
+	}
+	var last time.Time
+	hashes, err := uFiles.HashTree(ctx, dir, uFiles.HashOptions{
+		Workers: workers,
+		Progress: func(p uFiles.HashProgress) {
+			if progress == nil || p.TotalBytes == 0 || time.Since(last) < manifestProgressInterval {
+				return
+			}
+			last = time.Now()
+			progress(float64(p.Bytes) / float64(p.TotalBytes))
+		},
+	})
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	for _, h := range hashes {
+		fmt.Fprintf(&b, "%s  %s\n", h.Sha256, h.Path)
+	}
+	return s.writeModelFile(ctx, path, func(w io.Writer) error {
+		_, err := b.WriteTo(w)
+		return err
+	})
+}
+
+// readManifest reads the manifest of the model folder dir into the sha256 of
+// its files by their slash separated path, nil when it has none.
+func readManifest(dir string) (map[string]string, error) {
+	f, err := os.Open(fp.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed manifest line %q", scanner.Text())
+		}
+		hashes[parts[1]] = parts[0]
+	}
+	return hashes, scanner.Err()
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	fp "path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	modelUpdateOne "server/db/pkg/handler/model/update_one"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+	uFiles "server/kit/utils/basic/files"
+)
+
+type MaterializeRequestData struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+}
+
+// MaterializeResponseData tells how many files were copied and their size.
+type MaterializeResponseData struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Materialize turns the files of a model into plain files, for the tools
+// that write over them. Every file of the model folder is copied in place,
+// then the model is no longer Cas and is left out of the snapshot registry
+// until it is imported again.
+func (s *basicModelService) Materialize(ctx context.Context, req MaterializeRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		if err := s.statuses.flush(ctx, req.ModelId); err != nil {
+			log.Println("domains.model.pkg.service.materialize.Materialize.s.statuses.flush", err)
+		}
+		modelResp := <-modelFindOne.Send(ctx, s.Conn, modelFindOne.RequestData{Id: req.ModelId})
+		model := s.localModel(modelResp.Data.(modelFindOne.ResponseData))
+		if model.Id.IsZero() {
+			err := fmt.Errorf("model %s not found", req.ModelId.Hex())
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if err := access.Check(ctx, s.Conn, model.ProblemId, role.Editor); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		if err := s.paths.CheckWrite(ctx, model.Dir); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: err.Error()}, IsLast: true}
+			return
+		}
+		data, err := materializeDir(model.Dir)
+		if err != nil {
+			log.Println("domains.model.pkg.service.materialize.Materialize.materializeDir", err)
+			returnChan <- kitendpoint.Response{Data: data, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		s.unregisterSnapshots(ctx, model.Id)
+		if model.Cas {
+			model.Cas = false
+			modelUpdateOneResp := <-modelUpdateOne.Send(ctx, s.Conn, s.storedModel(model))
+			if modelUpdateOneResp.Err.Code > 0 {
+				log.Println("domains.model.pkg.service.materialize.Materialize.modelUpdateOne.Send", modelUpdateOneResp.Err.Message)
+				returnChan <- kitendpoint.Response{Data: data, Err: modelUpdateOneResp.Err, IsLast: true}
+				return
+			}
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// materializeDir copies every regular file of dir next to itself and renames
+// the copy over it, so that no file of dir is a hardlink any more.
+func materializeDir(dir string) (MaterializeResponseData, error) {
+	var data MaterializeResponseData
+	err := fp.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || isLeftover(info.Name()) {
+			return nil
+		}
+		tmp := fp.Join(fp.Dir(path), "."+info.Name()+".tmp")
+		n, err := uFiles.Copy(path, tmp)
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		data.Files++
+		data.Bytes += n
+		return nil
+	})
+	return data, err
+}
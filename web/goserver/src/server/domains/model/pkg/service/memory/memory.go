@@ -0,0 +1,674 @@
+// Package memory keeps the problems, builds, models, quotas, snapshots and
+// locks of the model service in maps, in place of the database service, for the model service to run
+// without Mongo nor the bus. The documents go through bson as they would to
+// the database, the stored ones are never shared with the callers.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	buildFindOne "server/db/pkg/handler/build/find_one"
+	buildInsertOne "server/db/pkg/handler/build/insert_one"
+	lockAcquire "server/db/pkg/handler/lock/acquire"
+	lockRefresh "server/db/pkg/handler/lock/refresh"
+	lockRelease "server/db/pkg/handler/lock/release"
+	modelBulkUpdate "server/db/pkg/handler/model/bulk_update"
+	modelDelete "server/db/pkg/handler/model/delete"
+	modelFind "server/db/pkg/handler/model/find"
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	modelInsertOne "server/db/pkg/handler/model/insert_one"
+	modelUpdateFields "server/db/pkg/handler/model/update_fields"
+	modelUpdateOne "server/db/pkg/handler/model/update_one"
+	modelUpdateUpsert "server/db/pkg/handler/model/update_upsert"
+	problemFind "server/db/pkg/handler/problem/find"
+	problemFindOne "server/db/pkg/handler/problem/find_one"
+	snapshotSetRefs "server/db/pkg/handler/snapshot/set_refs"
+	t "server/db/pkg/types"
+	buildStatus "server/db/pkg/types/build/status"
+	"server/domains/model/pkg/service"
+	"server/domains/problem/pkg/quota"
+)
+
+// Store is the in-memory database. It enforces the unique indexes of the
+// database: the name of a build in its problem, the name of a model in the
+// workspace of its problem.
+type Store struct {
+	mu       sync.Mutex
+	problems map[primitive.ObjectID]bson.M
+	builds   map[primitive.ObjectID]bson.M
+	models   map[primitive.ObjectID]bson.M
+	locks    map[string]t.Lock
+	usage    map[primitive.ObjectID]int64
+	refs     map[primitive.ObjectID][]t.SnapshotFile
+}
+
+// New returns an empty store.
+func New() *Store {
+	return &Store{
+		problems: make(map[primitive.ObjectID]bson.M),
+		builds:   make(map[primitive.ObjectID]bson.M),
+		models:   make(map[primitive.ObjectID]bson.M),
+		locks:    make(map[string]t.Lock),
+		usage:    make(map[primitive.ObjectID]int64),
+		refs:     make(map[primitive.ObjectID][]t.SnapshotFile),
+	}
+}
+
+// Repositories are the repositories of the model service backed by s.
+func (s *Store) Repositories() service.Repositories {
+	return service.Repositories{Problems: s, Builds: s, Models: s, Locks: s, Quotas: s, Snapshots: s}
+}
+
+// AddProblem stores problem, with a new id when it has none, and returns it.
+func (s *Store) AddProblem(problem t.Problem) t.Problem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if problem.Id.IsZero() {
+		problem.Id = primitive.NewObjectID()
+	}
+	s.problems[problem.Id] = document(problem)
+	return problem
+}
+
+// AddBuild stores build, with a new id when it has none, and returns it.
+func (s *Store) AddBuild(build t.Build) (t.Build, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if build.Id.IsZero() {
+		build.Id = primitive.NewObjectID()
+	}
+	doc := document(build)
+	if other := s.findBuild(bson.M{"problemId": build.ProblemId, "name": build.Name}); other != nil && other["_id"] != build.Id {
+		return build, duplicateError("build", build.Name)
+	}
+	s.builds[build.Id] = doc
+	return build, nil
+}
+
+// AddModel stores model, with a new id when it has none, and returns it.
+func (s *Store) AddModel(model t.Model) (t.Model, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if model.Id.IsZero() {
+		model.Id = primitive.NewObjectID()
+	}
+	if other := s.findModel(modelKey(model.ProblemId, model.Workspace, model.Name)); other != nil && other["_id"] != model.Id {
+		return model, duplicateError("model", model.Name)
+	}
+	s.models[model.Id] = document(model)
+	return model, nil
+}
+
+// Builds are the stored builds of the problem, by name.
+func (s *Store) Builds(problemId primitive.ObjectID) []t.Build {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var builds []t.Build
+	for _, doc := range sorted(s.builds) {
+		if doc["problemId"] == problemId {
+			var build t.Build
+			decode(doc, &build)
+			builds = append(builds, build)
+		}
+	}
+	return builds
+}
+
+// Models are all the stored models, by problem, workspace and name.
+func (s *Store) Models() []t.Model {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	models := s.matchModels(bson.M{})
+	sort.Slice(models, func(i, j int) bool {
+		a, b := models[i], models[j]
+		if a.ProblemId != b.ProblemId {
+			return a.ProblemId.Hex() < b.ProblemId.Hex()
+		}
+		if a.Workspace != b.Workspace {
+			return a.Workspace < b.Workspace
+		}
+		return a.Name < b.Name
+	})
+	return models
+}
+
+// FindProblem finds the problem by id, or by title, type and class.
+func (s *Store) FindProblem(_ context.Context, req problemFindOne.RequestData) (problem t.Problem, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	filter := bson.M{}
+	if !req.Id.IsZero() {
+		filter["_id"] = req.Id
+	} else {
+		for field, value := range map[string]string{"title": req.Title, "type": req.Type, "class": req.Class} {
+			if value != "" {
+				filter[field] = value
+			}
+		}
+	}
+	for _, doc := range sorted(s.problems) {
+		if matches(doc, filter) {
+			return problem, decode(doc, &problem)
+		}
+	}
+	return problem, nil
+}
+
+// FindProblems lists the problems, or those of req.Titles, whatever the
+// page.
+func (s *Store) FindProblems(_ context.Context, req problemFind.RequestData) (t.ProblemFindResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	titles := make(map[string]bool, len(req.Titles))
+	for _, title := range req.Titles {
+		titles[title] = true
+	}
+	result := t.ProblemFindResponse{Items: []t.Problem{}}
+	for _, doc := range sorted(s.problems) {
+		var problem t.Problem
+		if err := decode(doc, &problem); err != nil {
+			return result, err
+		}
+		if len(titles) == 0 || titles[problem.Title] {
+			result.Items = append(result.Items, problem)
+		}
+	}
+	result.Total = int64(len(result.Items))
+	return result, nil
+}
+
+// FindBuild finds the build by id, else the temporary build of the problem
+// or the build of the problem by name.
+func (s *Store) FindBuild(_ context.Context, req buildFindOne.RequestData) (build t.Build, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var filter bson.M
+	switch {
+	case !req.Id.IsZero():
+		filter = bson.M{"_id": req.Id}
+	case req.Status == buildStatus.Tmp:
+		filter = bson.M{"problemId": req.ProblemId, "status": req.Status}
+	case req.Name != "":
+		filter = bson.M{"problemId": req.ProblemId, "name": req.Name}
+	default:
+		return build, nil
+	}
+	if doc := s.findBuild(filter); doc != nil {
+		return build, decode(doc, &build)
+	}
+	return build, nil
+}
+
+// InsertBuild inserts the build. A build named as another of its problem is
+// not inserted, the other being returned when it has the same status, as
+// the database does.
+func (s *Store) InsertBuild(_ context.Context, req buildInsertOne.RequestData) (build t.Build, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findBuild(bson.M{"problemId": req.ProblemId, "name": req.Name}) != nil {
+		if doc := s.findBuild(bson.M{"problemId": req.ProblemId, "name": req.Name, "status": req.Status}); doc != nil {
+			return build, decode(doc, &build)
+		}
+		return build, nil
+	}
+	doc := document(req)
+	doc["_id"] = primitive.NewObjectID()
+	s.builds[doc["_id"].(primitive.ObjectID)] = doc
+	return build, decode(doc, &build)
+}
+
+// FindModel finds the model by id, else by problem, workspace and name.
+func (s *Store) FindModel(_ context.Context, req modelFindOne.RequestData) (model t.Model, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	filter := bson.M{"_id": req.Id}
+	if req.Id.IsZero() {
+		filter = modelKey(req.ProblemId, req.Workspace, req.Name)
+	}
+	if doc := s.findModel(filter); doc != nil {
+		return model, decode(doc, &model)
+	}
+	return model, nil
+}
+
+// FindModels lists the models as the database does, a page of Size models
+// when Size is set, all of them otherwise. Fields are not projected, the
+// models are read whole.
+func (s *Store) FindModels(_ context.Context, req modelFind.RequestData) (t.ModelFindResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	models := s.findModels(req)
+	result := t.ModelFindResponse{BaseList: t.BaseList{Total: int64(len(models))}}
+	if req.Size > 0 {
+		from := req.Size * (req.Page - 1)
+		if from < 0 {
+			from = 0
+		}
+		if from > int64(len(models)) {
+			from = int64(len(models))
+		}
+		to := from + req.Size
+		if to > int64(len(models)) {
+			to = int64(len(models))
+		}
+		models = models[from:to]
+	}
+	result.Items = models
+	return result, nil
+}
+
+// findModels returns the models req finds, whatever its page. It is called
+// with mu held.
+func (s *Store) findModels(req modelFind.RequestData) []t.Model {
+	filter := bson.M{}
+	if !req.AnyProblem {
+		filter["problemId"] = req.ProblemId
+	}
+	if req.Workspace != "" {
+		filter["workspace"] = req.Workspace
+	}
+	if req.Name != "" {
+		filter["name"] = req.Name
+	}
+	var models []t.Model
+	for _, model := range s.matchModels(filter) {
+		if (!model.Archived || req.IncludeArchived) && hasTags(model.Tags, req.Tags) && hasId(req.Ids, model.Id) {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// InsertModel inserts the model, failing for a model named as another of
+// the workspace of its problem.
+func (s *Store) InsertModel(_ context.Context, req modelInsertOne.RequestData) (model t.Model, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findModel(modelKey(req.ProblemId, req.Workspace, req.Name)) != nil {
+		return model, duplicateError("model", req.Name)
+	}
+	doc := document(req)
+	doc["_id"] = primitive.NewObjectID()
+	s.models[doc["_id"].(primitive.ObjectID)] = doc
+	return model, decode(doc, &model)
+}
+
+// UpsertModel sets the fields of the model of the same problem, workspace
+// and name, inserting it when there is none. A model without a problem is
+// not saved, as by the database.
+func (s *Store) UpsertModel(_ context.Context, req modelUpdateUpsert.RequestData) (model t.Model, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.ProblemId.IsZero() {
+		return model, nil
+	}
+	doc := s.findModel(modelKey(req.ProblemId, req.Workspace, req.Name))
+	if doc == nil {
+		doc = bson.M{"_id": primitive.NewObjectID()}
+	}
+	for field, value := range document(req) {
+		doc[field] = value
+	}
+	s.models[doc["_id"].(primitive.ObjectID)] = doc
+	return model, decode(doc, &model)
+}
+
+// UpdateModel sets the fields of the model of the same id, as the database
+// does. A model not stored is not saved, the zero model is returned.
+func (s *Store) UpdateModel(_ context.Context, req modelUpdateOne.RequestData) (model t.Model, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, ok := s.models[req.Id]
+	if !ok {
+		return model, nil
+	}
+	for field, value := range document(req) {
+		doc[field] = value
+	}
+	return model, decode(doc, &model)
+}
+
+// UpdateModelFields sets the fields of req that are not nil, as the database
+// does.
+func (s *Store) UpdateModelFields(_ context.Context, req modelUpdateFields.RequestData) (model t.Model, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, ok := s.models[req.Id]
+	if !ok {
+		return model, nil
+	}
+	if err := decode(doc, &model); err != nil {
+		return model, err
+	}
+	if req.Tags != nil {
+		model.Tags = append([]string{}, *req.Tags...)
+	}
+	if req.Archived != nil {
+		model.Archived = *req.Archived
+	}
+	if req.ArchivedAt != nil {
+		model.ArchivedAt = *req.ArchivedAt
+	}
+	if req.Dependencies != nil {
+		model.Dependencies = append([]t.Dependency{}, *req.Dependencies...)
+	}
+	if req.ContentHash != nil {
+		model.ContentHash = *req.ContentHash
+	}
+	s.models[req.Id] = document(model)
+	return model, nil
+}
+
+// BulkUpdateModels patches the models the filter of req finds, whatever its
+// page, or counts them and returns SampleSize of them when previewed.
+func (s *Store) BulkUpdateModels(_ context.Context, req modelBulkUpdate.RequestData) (result modelBulkUpdate.ResponseData, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	models := s.findModels(req.Filter)
+	result.Matched = int64(len(models))
+	if req.Preview {
+		if int64(len(models)) > req.SampleSize {
+			models = models[:req.SampleSize]
+		}
+		result.Samples = models
+		return result, nil
+	}
+	if req.Patch.IsEmpty() {
+		return result, fmt.Errorf("the patch changes nothing")
+	}
+	now := time.Now()
+	for _, model := range models {
+		before := document(model)
+		patchModel(&model, req.Patch, now)
+		after := document(model)
+		if !reflect.DeepEqual(before, after) {
+			result.Modified++
+		}
+		s.models[model.Id] = after
+	}
+	return result, nil
+}
+
+// patchModel applies p to model as the database does.
+func patchModel(model *t.Model, p t.ModelPatch, now time.Time) {
+	if p.SetTags != nil {
+		model.Tags = append([]string{}, *p.SetTags...)
+	}
+	if len(p.RemoveTags) > 0 {
+		var kept []string
+		for _, tag := range model.Tags {
+			if !hasTags(p.RemoveTags, []string{tag}) {
+				kept = append(kept, tag)
+			}
+		}
+		model.Tags = kept
+	}
+	for _, tag := range p.AddTags {
+		if !hasTags(model.Tags, []string{tag}) {
+			model.Tags = append(model.Tags, tag)
+		}
+	}
+	if p.Description != nil {
+		model.Description = *p.Description
+	}
+	if p.License != nil {
+		model.License = *p.License
+	}
+	if p.Archived != nil {
+		if !*p.Archived {
+			model.ArchivedAt = time.Time{}
+		} else if !model.Archived {
+			model.ArchivedAt = now
+		}
+		model.Archived = *p.Archived
+	}
+}
+
+// DeleteModel deletes the model, the zero id telling nothing was.
+func (s *Store) DeleteModel(_ context.Context, req modelDelete.RequestData) (modelDelete.ResponseData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Id.IsZero() {
+		return modelDelete.ResponseData{Id: primitive.NilObjectID}, nil
+	}
+	delete(s.models, req.Id)
+	return modelDelete.ResponseData{Id: req.Id}, nil
+}
+
+// Usage is the number of bytes the problem uses, as reported.
+func (s *Store) Usage(problemId primitive.ObjectID) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage[problemId]
+}
+
+// SnapshotRefs are the snapshot files the model references.
+func (s *Store) SnapshotRefs(modelId primitive.ObjectID) []t.SnapshotFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]t.SnapshotFile(nil), s.refs[modelId]...)
+}
+
+// CheckQuota fails with a *quota.Error when incoming more bytes do not fit
+// in the quota of the problem.
+func (s *Store) CheckQuota(_ context.Context, problemId primitive.ObjectID, incoming int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, ok := s.problems[problemId]
+	if !ok {
+		return fmt.Errorf("problem %s not found", problemId.Hex())
+	}
+	var problem t.Problem
+	if err := decode(doc, &problem); err != nil {
+		return err
+	}
+	if problem.QuotaBytes <= 0 {
+		return nil
+	}
+	usage := quota.NewUsage(problem, s.usage[problemId])
+	if incoming <= usage.FreeBytes {
+		return nil
+	}
+	return &quota.Error{Usage: usage, Incoming: incoming}
+}
+
+// ReportUsage adds delta to the bytes the problem uses.
+func (s *Store) ReportUsage(_ context.Context, problemId primitive.ObjectID, delta int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if delta != 0 && !problemId.IsZero() {
+		s.usage[problemId] += delta
+	}
+}
+
+// SetSnapshotRefs replaces the snapshot files the model references. The
+// unreferenced snapshots are not counted.
+func (s *Store) SetSnapshotRefs(_ context.Context, req snapshotSetRefs.RequestData) (result snapshotSetRefs.ResponseData, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(req.Files) == 0 {
+		delete(s.refs, req.ModelId)
+		return result, nil
+	}
+	s.refs[req.ModelId] = append([]t.SnapshotFile(nil), req.Files...)
+	return result, nil
+}
+
+// AcquireLock takes the lock of the key when it is free, expired or already
+// held by the owner, the lock held by another owner being returned
+// otherwise.
+func (s *Store) AcquireLock(_ context.Context, req lockAcquire.RequestData) (result lockAcquire.ResponseData, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if lock, ok := s.locks[req.Key]; ok && lock.Owner != req.Owner && lock.ExpiresAt.After(now) {
+		return lockAcquire.ResponseData{Lock: lock}, nil
+	}
+	lock := t.Lock{
+		Key:        req.Key,
+		Owner:      req.Owner,
+		Holder:     req.Holder,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(time.Duration(req.TtlMillis) * time.Millisecond),
+		Operation:  req.Operation,
+	}
+	s.locks[req.Key] = lock
+	return lockAcquire.ResponseData{Acquired: true, Lock: lock}, nil
+}
+
+// RefreshLock extends the lock of the key if the owner still holds it.
+func (s *Store) RefreshLock(_ context.Context, req lockRefresh.RequestData) (result lockRefresh.ResponseData, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.locks[req.Key]
+	if !ok || lock.Owner != req.Owner {
+		return result, nil
+	}
+	lock.ExpiresAt = time.Now().Add(time.Duration(req.TtlMillis) * time.Millisecond)
+	s.locks[req.Key] = lock
+	return lockRefresh.ResponseData{Refreshed: true}, nil
+}
+
+// ReleaseLock frees the lock of the key if the owner still holds it, or
+// without a key all the locks of the operation.
+func (s *Store) ReleaseLock(_ context.Context, req lockRelease.RequestData) (result lockRelease.ResponseData, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, lock := range s.locks {
+		if (req.Key == "" && req.Operation != "" && lock.Operation == req.Operation) || (req.Key != "" && key == req.Key && lock.Owner == req.Owner) {
+			delete(s.locks, key)
+			result.Released = true
+		}
+	}
+	return result, nil
+}
+
+func (s *Store) findBuild(filter bson.M) bson.M {
+	for _, doc := range sorted(s.builds) {
+		if matches(doc, filter) {
+			return doc
+		}
+	}
+	return nil
+}
+
+func (s *Store) findModel(filter bson.M) bson.M {
+	for _, doc := range sorted(s.models) {
+		if matches(doc, filter) {
+			return doc
+		}
+	}
+	return nil
+}
+
+func (s *Store) matchModels(filter bson.M) []t.Model {
+	var models []t.Model
+	for _, doc := range sorted(s.models) {
+		if matches(doc, filter) {
+			var model t.Model
+			decode(doc, &model)
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// modelKey is the filter of the unique index of the models.
+func modelKey(problemId primitive.ObjectID, workspace, name string) bson.M {
+	return bson.M{"problemId": problemId, "workspace": workspace, "name": name}
+}
+
+// matches tells whether doc has the values of filter, a missing field
+// matching the empty string as the workspace of the models stored before
+// them.
+func matches(doc, filter bson.M) bool {
+	for field, want := range filter {
+		got, ok := doc[field]
+		if !ok && want == "" {
+			continue
+		}
+		if got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// hasTags tells whether tags has all of want.
+func hasTags(tags, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, tag := range tags {
+			if tag == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// hasId tells whether ids, when set, has id.
+func hasId(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted are the documents of collection in the order of their ids, the
+// order they were inserted in.
+func sorted(collection map[primitive.ObjectID]bson.M) []bson.M {
+	ids := make([]primitive.ObjectID, 0, len(collection))
+	for id := range collection {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Hex() < ids[j].Hex() })
+	docs := make([]bson.M, len(ids))
+	for i, id := range ids {
+		docs[i] = collection[id]
+	}
+	return docs
+}
+
+// document is v as stored by the database.
+func document(v interface{}) bson.M {
+	b, err := bson.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("memory: encoding %T: %v", v, err))
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		panic(fmt.Sprintf("memory: decoding %T: %v", v, err))
+	}
+	return doc
+}
+
+// decode reads the document doc into v.
+func decode(doc bson.M, v interface{}) error {
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(b, v)
+}
+
+func duplicateError(kind, name string) error {
+	return fmt.Errorf("duplicate key: %s %q already exists", kind, name)
+}
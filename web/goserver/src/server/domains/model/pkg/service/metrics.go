@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"server/kit/metrics"
+)
+
+// sizeBuckets are the upper bounds, in bytes, of the import size histogram.
+var sizeBuckets = []float64{1 << 20, 10 << 20, 100 << 20, 500 << 20, 1 << 30, 5 << 30, 10 << 30, 50 << 30}
+
+var (
+	importsStarted = metrics.NewCounter(
+		"model_imports_started_total",
+		"Models of templates whose import started.",
+	)
+	importResults = metrics.NewCounter(
+		"model_imports_total",
+		"Models of templates whose import ended, by result.",
+		"result",
+	)
+	importStageSeconds = metrics.NewHistogram(
+		"model_import_stage_seconds",
+		"Time spent by model imports in each of their stages.",
+		metrics.DefBuckets,
+		"stage",
+	)
+	importCacheLookups = metrics.NewCounter(
+		"model_import_cache_lookups_total",
+		"Problem and build lookups of the imports of a batch, by kind and result.",
+		"kind", "result",
+	)
+	dependencyCopies = metrics.NewCounter(
+		"model_import_dependency_copies_total",
+		"Local dependencies of model imports, by result.",
+		"result",
+	)
+	downloads = metrics.NewCounter(
+		"model_import_downloads_total",
+		"Dependency downloads of model imports, by result.",
+		"result",
+	)
+	downloadFailures = metrics.NewCounter(
+		"model_import_download_failures_total",
+		"Failed download attempts, by kind: mismatch for a download of the expected size whose sha256 is wrong, size for one of another size, error otherwise.",
+		"kind",
+	)
+	downloadRetries = metrics.NewCounter(
+		"model_import_download_retries_total",
+		"Download attempts made after a failed one.",
+	)
+	downloadedBytes = metrics.NewCounter(
+		"model_import_downloaded_bytes_total",
+		"Bytes received by dependency downloads, failed attempts included.",
+	)
+	snapshotCacheLookups = metrics.NewCounter(
+		"model_snapshot_cache_lookups_total",
+		"Downloads looked for in the snapshot store, by result.",
+		"result",
+	)
+	statusWritesCoalesced = metrics.NewCounter(
+		"model_status_writes_coalesced_total",
+		"Model status updates merged into the pending write of the model.",
+	)
+	importedFiles = metrics.NewHistogram(
+		"model_import_files",
+		"Files in the folder of an imported model.",
+		[]float64{1, 2, 5, 10, 20, 50, 100, 500, 1000},
+	)
+	importedBytes = metrics.NewHistogram(
+		"model_import_bytes",
+		"Bytes in the folder of an imported model.",
+		sizeBuckets,
+	)
+)
+
+// observeImport records the files and bytes the folder of an imported model
+// ended up with.
+func observeImport(stats ImportStats) {
+	importedFiles.Observe(float64(stats.Files))
+	importedBytes.Observe(float64(stats.Bytes))
+}
+
+// observeStage records the time since start as spent in stage, and returns
+// the start of the next stage.
+func observeStage(stage string, start time.Time) time.Time {
+	now := time.Now()
+	importStageSeconds.Observe(now.Sub(start).Seconds(), stage)
+	return now
+}
+
+func resultOf(err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return "ok"
+}
+
+// downloadResultOf is the result of a download, "mismatch" for one ending
+// with ErrChecksumMismatch.
+func downloadResultOf(err error) string {
+	if errors.Is(err, ErrChecksumMismatch) {
+		return "mismatch"
+	}
+	return resultOf(err)
+}
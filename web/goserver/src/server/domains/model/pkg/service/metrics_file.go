@@ -0,0 +1,137 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	fp "path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	t "server/db/pkg/types"
+	"server/kit/storage"
+)
+
+const (
+	metricsFileName    = "metrics.yaml"
+	metricsSidecarName = "metrics.json"
+	// maxMetricsYamlSize is the estimated size of the metrics beyond which
+	// the YAML keeps only the headline metrics, all of them going to the
+	// JSON sidecar. Per-class metrics of large problems reach it.
+	maxMetricsYamlSize = 1 << 20
+	// metricEntrySize is the estimated size of the keys and indentation of
+	// a metric as encoded.
+	metricEntrySize = 48
+)
+
+// metricsYaml is the metrics file of a model. Sidecar names the JSON file
+// next to it holding all the metrics when they were too large for it.
+type metricsYaml struct {
+	Metrics []t.Metric `yaml:"metrics"`
+	Sidecar string     `yaml:"sidecar,omitempty"`
+}
+
+// metricsBuffers are the write buffers of the metrics files, so that the
+// encoders stream into the file through a buffer reused across calls.
+var metricsBuffers = sync.Pool{
+	New: func() interface{} { return bufio.NewWriterSize(nil, 64<<10) },
+}
+
+func (s *basicModelService) saveMetrics(ctx context.Context, to string, modelYml ModelYml) error {
+	return s.writeMetrics(ctx, fp.Join(to, defaultBuildFolder), modelYml.Metrics)
+}
+
+// writeMetrics writes the metrics file of dir through the storage. The
+// sidecar is written before the YAML referring to it, a stale one removed
+// after the YAML no longer does.
+func (s *basicModelService) writeMetrics(ctx context.Context, dir string, metrics []t.Metric) error {
+	doc := metricsYaml{Metrics: metrics}
+	sidecar := fp.Join(dir, metricsSidecarName)
+	large := metricsSize(metrics) > maxMetricsYamlSize
+	if large {
+		err := s.writeBuffered(ctx, sidecar, func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(metrics)
+		})
+		if err != nil {
+			return err
+		}
+		doc = metricsYaml{Metrics: headline(metrics), Sidecar: metricsSidecarName}
+	}
+	err := s.writeBuffered(ctx, fp.Join(dir, metricsFileName), func(w io.Writer) error {
+		enc := yaml.NewEncoder(w)
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		return enc.Close()
+	})
+	if err != nil {
+		return err
+	}
+	if !large {
+		if err := s.removeModelFile(ctx, sidecar); err != nil && !storage.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadMetrics reads the metrics file at path, from its sidecar when it has
+// one.
+func (s *basicModelService) loadMetrics(ctx context.Context, path string) ([]t.Metric, error) {
+	f, err := s.openModelFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var doc metricsYaml
+	if err := yaml.NewDecoder(bufio.NewReader(f)).Decode(&doc); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if doc.Sidecar == "" {
+		return doc.Metrics, nil
+	}
+	sf, err := s.openModelFile(ctx, fp.Join(fp.Dir(path), fp.Base(doc.Sidecar)))
+	if err != nil {
+		return nil, err
+	}
+	defer sf.Close()
+	var metrics []t.Metric
+	if err := json.NewDecoder(bufio.NewReader(sf)).Decode(&metrics); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+func (s *basicModelService) writeBuffered(ctx context.Context, path string, encode func(w io.Writer) error) error {
+	return s.writeModelFile(ctx, path, func(f io.Writer) error {
+		w := metricsBuffers.Get().(*bufio.Writer)
+		w.Reset(f)
+		defer func() {
+			w.Reset(nil)
+			metricsBuffers.Put(w)
+		}()
+		if err := encode(w); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+func metricsSize(metrics []t.Metric) int {
+	size := 0
+	for _, m := range metrics {
+		size += metricEntrySize + len(m.DisplayName) + len(m.Key) + len(m.Value) + len(m.Unit)
+	}
+	return size
+}
+
+// headline returns the first metrics of the template, the aggregates listed
+// before the per-class ones.
+func headline(metrics []t.Metric) []t.Metric {
+	if len(metrics) > headlineMetrics {
+		return metrics[:headlineMetrics]
+	}
+	return metrics
+}
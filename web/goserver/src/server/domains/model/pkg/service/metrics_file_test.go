@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	t "server/db/pkg/types"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// classMetrics are the aggregates and the per-class metrics of a problem of
+// classes classes.
+func classMetrics(classes int) []t.Metric {
+	metrics := []t.Metric{
+		{DisplayName: "mAP", Key: "map", Value: "0.51", Unit: "%"},
+		{DisplayName: "Size", Key: "size", Value: "24.3", Unit: "Mb"},
+	}
+	for i := 0; i < classes; i++ {
+		metrics = append(metrics, t.Metric{
+			DisplayName: fmt.Sprintf("AP of class %d", i),
+			Key:         fmt.Sprintf("ap_%d", i),
+			Value:       "0.42",
+			Unit:        "%",
+		})
+	}
+	return metrics
+}
+
+func metricsDir(tb testing.TB) string {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestMetricsRoundTrip(test *testing.T) {
+	s := &basicModelService{}
+	for _, classes := range []int{10, 20000} {
+		test.Run(fmt.Sprint(classes), func(test *testing.T) {
+			dir := metricsDir(test)
+			metrics := classMetrics(classes)
+			if err := s.writeMetrics(context.Background(), dir, metrics); err != nil {
+				test.Fatal(err)
+			}
+			_, err := os.Stat(fp.Join(dir, metricsSidecarName))
+			if sidecar := err == nil; sidecar != (metricsSize(metrics) > maxMetricsYamlSize) {
+				test.Errorf("sidecar written %v for %d metrics", sidecar, len(metrics))
+			}
+			got, err := s.loadMetrics(context.Background(), fp.Join(dir, metricsFileName))
+			if err != nil {
+				test.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, metrics) {
+				test.Errorf("loaded %d metrics, want the %d written", len(got), len(metrics))
+			}
+		})
+	}
+}
+
+// BenchmarkMetricsFile compares the allocations of writing the metrics of
+// 1000 and 20000 classes by marshalling them first, as saveMetrics did, and
+// by streaming them with writeMetrics.
+func BenchmarkMetricsFile(b *testing.B) {
+	s := &basicModelService{}
+	for _, classes := range []int{1000, 20000} {
+		metrics := classMetrics(classes)
+		b.Run(fmt.Sprintf("marshal/%d", classes), func(b *testing.B) {
+			path := fp.Join(metricsDir(b), metricsFileName)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				content, err := yaml.Marshal(metricsYaml{Metrics: metrics})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := uFiles.WriteFileAtomic(path, content, 0666); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("stream/%d", classes), func(b *testing.B) {
+			dir := metricsDir(b)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := s.writeMetrics(context.Background(), dir, metrics); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFind "server/db/pkg/handler/model/find"
+	problemFind "server/db/pkg/handler/problem/find"
+	t "server/db/pkg/types"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+)
+
+// MigrateStoragePathsRequestData rewrites the paths of the models, asking
+// for the report only when DryRun is set.
+type MigrateStoragePathsRequestData struct {
+	DryRun bool `json:"dryRun"`
+}
+
+// MigratedModel is a model whose paths were, or are to be, rewritten. Error
+// tells why it was not.
+type MigratedModel struct {
+	Id        primitive.ObjectID `json:"id"`
+	Name      string             `json:"name"`
+	ProblemId primitive.ObjectID `json:"problemId"`
+	Dir       string             `json:"dir"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// MigrateStoragePathsResponseData lists the models migrated out of the
+// Models seen, those of the problems in SkippedProblems not being listed.
+type MigrateStoragePathsResponseData struct {
+	DryRun          bool            `json:"dryRun"`
+	Models          int             `json:"models"`
+	Migrated        []MigratedModel `json:"migrated"`
+	Failed          int             `json:"failed"`
+	SkippedProblems []string        `json:"skippedProblems"`
+}
+
+// MigrateStoragePaths rewrites the absolute paths the models were stored
+// with before the storages into refs of the storage, and publishes their
+// folders to it. Paths outside the problem folder are kept as they are. The
+// models already migrated are left alone, so that it can be run again after
+// a failure. Only admins may run it when the request carries an identity.
+func (s *basicModelService) MigrateStoragePaths(ctx context.Context, req MigrateStoragePathsRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		if identity, ok := auth.FromContext(ctx); ok && !identity.HasRole(auth.RoleAdmin) {
+			err := fmt.Errorf("user %q is not allowed to migrate the storage paths", identity.User)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeForbidden, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if !atomic.CompareAndSwapInt32(&s.migrating, 0, 1) {
+			err := fmt.Errorf("a storage paths migration is already running")
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeBusy, Message: err.Error()}, IsLast: true}
+			return
+		}
+		defer atomic.StoreInt32(&s.migrating, 0)
+		problems, err := s.repos.Problems.FindProblems(ctx, problemFind.RequestData{Page: 1, Size: 0})
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		data := MigrateStoragePathsResponseData{DryRun: req.DryRun, Migrated: []MigratedModel{}, SkippedProblems: []string{}}
+		for _, problem := range problems.Items {
+			models, err := s.storedModels(ctx, problem)
+			if err != nil {
+				log.Println("domains.model.pkg.service.migrate_storage_paths.MigrateStoragePaths.storedModels", problem.Title, err)
+				data.SkippedProblems = append(data.SkippedProblems, problem.Title)
+				continue
+			}
+			for _, model := range models {
+				data.Models++
+				migrated := s.storedModel(s.localModel(model))
+				if sameModelPaths(model, migrated) {
+					continue
+				}
+				entry := MigratedModel{Id: model.Id, Name: model.Name, ProblemId: model.ProblemId, Dir: model.Dir}
+				if !req.DryRun {
+					if err := s.migrateModel(ctx, migrated); err != nil {
+						log.Println("domains.model.pkg.service.migrate_storage_paths.MigrateStoragePaths.migrateModel", model.Name, err)
+						entry.Error = err.Error()
+						data.Failed++
+					}
+				}
+				data.Migrated = append(data.Migrated, entry)
+			}
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// migrateModel publishes the folder of model, then saves its refs, so that
+// they never name files missing from the storage.
+func (s *basicModelService) migrateModel(ctx context.Context, model t.Model) error {
+	dir := s.localPath(model.Dir)
+	hashes, err := readManifest(dir)
+	if err != nil {
+		log.Println("domains.model.pkg.service.migrate_storage_paths.migrateModel.readManifest", dir, err)
+	}
+	if err := s.syncDir(ctx, dir, hashes); err != nil {
+		return err
+	}
+	_, err = s.repos.Models.UpdateModel(ctx, model)
+	return err
+}
+
+// storedModels lists all the models of the problem as the database keeps
+// them, failing rather than returning a partial list.
+func (s *basicModelService) storedModels(ctx context.Context, problem t.Problem) ([]t.Model, error) {
+	models, err := s.repos.Models.FindModels(ctx, modelFind.RequestData{Page: 1, Size: 0, ProblemId: problem.Id, IncludeArchived: true})
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(models.Items)) != models.Total {
+		return nil, fmt.Errorf("listed %d of %d models", len(models.Items), models.Total)
+	}
+	return models.Items, nil
+}
+
+func sameModelPaths(a, b t.Model) bool {
+	pathsA := modelPaths(&a.ConfigPath, &a.Dir, &a.ModulesYamlPath, &a.ReadmePath, &a.Scripts, &a.SnapshotPath, &a.TemplatePath, &a.WeightsPath)
+	pathsB := modelPaths(&b.ConfigPath, &b.Dir, &b.ModulesYamlPath, &b.ReadmePath, &b.Scripts, &b.SnapshotPath, &b.TemplatePath, &b.WeightsPath)
+	for i := range pathsA {
+		if *pathsA[i] != *pathsB[i] {
+			return false
+		}
+	}
+	return true
+}
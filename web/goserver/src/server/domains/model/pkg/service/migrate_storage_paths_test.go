@@ -0,0 +1,87 @@
+package service_test
+
+import (
+	"context"
+	fp "path/filepath"
+	"testing"
+	"time"
+
+	t "server/db/pkg/types"
+	"server/domains/model/pkg/service"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/storage"
+	"server/kit/storage/s3test"
+)
+
+func (f memoryFixture) migrate(test *testing.T, dryRun bool) service.MigrateStoragePathsResponseData {
+	resp := <-f.service.MigrateStoragePaths(context.Background(), service.MigrateStoragePathsRequestData{DryRun: dryRun})
+	if resp.Err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("code %d (%s)", resp.Err.Code, resp.Err.Message)
+	}
+	return resp.Data.(service.MigrateStoragePathsResponseData)
+}
+
+// A model stored with the absolute paths of before the storages is
+// published to S3, its paths turned into refs the service reads it back by.
+func TestMigrateStoragePathsToS3(test *testing.T) {
+	f := newMemoryFixture(test)
+	server := s3test.NewServer("models")
+	defer server.Close()
+	store, err := storage.New(storage.Settings{
+		Url:       "s3://models/data",
+		Root:      f.problems,
+		Endpoint:  server.URL,
+		Region:    s3test.Region,
+		AccessKey: s3test.AccessKey,
+		SecretKey: s3test.SecretKey,
+		Timeout:   5 * time.Second,
+	})
+	if err != nil {
+		test.Fatal(err)
+	}
+	f.storage = store
+	f.serve(f.store.Repositories())
+
+	problem := f.addProblem()
+	dir := fp.Join(problem.Dir, "ssd detector")
+	writeFile(test, fp.Join(dir, "model.py"), "model = dict()\n")
+	writeFile(test, fp.Join(dir, "snapshot+v2.pth"), "weights")
+	_, err = f.store.AddModel(t.Model{
+		Name:         "ssd detector",
+		ProblemId:    problem.Id,
+		Dir:          dir,
+		ConfigPath:   fp.Join(dir, "model.py"),
+		SnapshotPath: fp.Join(dir, "snapshot+v2.pth"),
+	})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if report := f.migrate(test, true); len(report.Migrated) != 1 || report.Failed != 0 {
+		test.Fatalf("dry run %+v", report)
+	}
+	if keys := server.Keys(); len(keys) != 0 || f.store.Models()[0].Dir != dir {
+		test.Fatalf("dry run published %q, stored %q", keys, f.store.Models()[0].Dir)
+	}
+
+	if report := f.migrate(test, false); len(report.Migrated) != 1 || report.Failed != 0 {
+		test.Fatalf("migration %+v", report)
+	}
+	stored := f.store.Models()[0]
+	if want := storage.Ref(store, "vehicles/ssd detector"); stored.Dir != want {
+		test.Errorf("dir stored %q, want %q", stored.Dir, want)
+	}
+	if object, ok := server.Object("data/vehicles/ssd detector/snapshot+v2.pth"); !ok || string(object.Body) != "weights" {
+		test.Errorf("objects %q", server.Keys())
+	}
+	content, err := storage.ReadFile(context.Background(), store.(*storage.Mirror).Remote, "vehicles/ssd detector/model.py")
+	if err != nil || string(content) != "model = dict()\n" {
+		test.Errorf("config read back %q, %v", content, err)
+	}
+
+	// The refs stored turn back into the paths of the working copy, which
+	// have the same refs: the model is migrated already.
+	if report := f.migrate(test, false); len(report.Migrated) != 0 {
+		test.Errorf("migrated again %+v", report.Migrated)
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"os"
+	fp "path/filepath"
+	"sort"
+	"strings"
+
+	"server/kit/redact"
+)
+
+// The readme and previews a template declares are copied to the model folder
+// under these names, whatever their names next to the template.
+const (
+	readmeName     = "README.md"
+	previewsFolder = "previews"
+)
+
+// maxPreviewBytes bounds the previews listed, as they are sent whole in the
+// responses of GetPreview.
+const maxPreviewBytes = 8 << 20
+
+// previewTypes are the content types of the images listed as previews. SVG is
+// left out, it may carry scripts.
+var previewTypes = map[string]string{
+	".gif":  "image/gif",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".webp": "image/webp",
+}
+
+// checkAssetPaths refuses a readme or previews outside the roots, when the
+// template declares them.
+func (s *basicModelService) checkAssetPaths(ctx context.Context, from, to string, modelYml ModelYml) error {
+	if modelYml.Readme != "" {
+		if err := s.paths.CheckRead(ctx, sourcePath(from, modelYml.Readme)); err != nil {
+			return err
+		}
+		if err := s.paths.CheckWrite(ctx, fp.Join(to, readmeName)); err != nil {
+			return err
+		}
+	}
+	if modelYml.Previews != "" {
+		if err := s.paths.CheckRead(ctx, sourcePath(from, modelYml.Previews)); err != nil {
+			return err
+		}
+		return s.paths.CheckWrite(ctx, fp.Join(to, previewsFolder))
+	}
+	return nil
+}
+
+// copyAssets copies the readme and previews of the template, those missing
+// are left out without a warning.
+func copyAssets(ctx context.Context, from string, modelYml ModelYml, diff *importDiff) {
+	assets := map[string]string{readmeName: modelYml.Readme, previewsFolder: modelYml.Previews}
+	for name, source := range assets {
+		if source == "" {
+			continue
+		}
+		if _, err := diff.copy(ctx, sourcePath(from, source), name, OverwriteChanged); err != nil && !os.IsNotExist(err) {
+			redact.Println("model_assets.copyAssets.diff.copy(sourcePath(from, source), name, OverwriteChanged)", err)
+		}
+	}
+}
+
+func assetsSize(from string, modelYml ModelYml) (size int64) {
+	for _, source := range []string{modelYml.Readme, modelYml.Previews} {
+		if source != "" {
+			size += dirSize(sourcePath(from, source))
+		}
+	}
+	return size
+}
+
+// modelAssets returns the readme of the model folder dir and the images of
+// its previews, relative to the previews folder, when the template declares
+// them.
+func modelAssets(dir string, modelYml ModelYml) (readmePath string, previews []string) {
+	if modelYml.Readme != "" {
+		if info, err := os.Stat(fp.Join(dir, readmeName)); err == nil && info.Mode().IsRegular() {
+			readmePath = fp.Join(dir, readmeName)
+		}
+	}
+	if modelYml.Previews == "" {
+		return readmePath, nil
+	}
+	root := fp.Join(dir, previewsFolder)
+	err := fp.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || info.Size() > maxPreviewBytes || previewType(path) == "" {
+			return nil
+		}
+		name, err := fp.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		previews = append(previews, fp.ToSlash(name))
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		redact.Println("model_assets.modelAssets.fp.Walk(root)", err)
+	}
+	sort.Strings(previews)
+	return readmePath, previews
+}
+
+func previewType(name string) string {
+	return previewTypes[strings.ToLower(fp.Ext(name))]
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	fp "path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	problemFindOne "server/db/pkg/handler/problem/find_one"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// modelScheme prefixes dependency sources pointing into another imported
+// model: model://<modelId>/<relativePath>.
+const modelScheme = "model://"
+
+func isModelSource(source string) bool {
+	return strings.HasPrefix(source, modelScheme)
+}
+
+func parseModelSource(source string) (primitive.ObjectID, string, error) {
+	rest := strings.TrimPrefix(source, modelScheme)
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return primitive.NilObjectID, "", fmt.Errorf("malformed model source %q", source)
+	}
+	modelId, err := primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		return primitive.NilObjectID, "", fmt.Errorf("malformed model id in %q: %v", source, err)
+	}
+	rel := fp.Clean(parts[1])
+	if fp.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(fp.Separator)) {
+		return primitive.NilObjectID, "", fmt.Errorf("path of %q leaves the model folder", source)
+	}
+	return modelId, rel, nil
+}
+
+// linkModelDependency hardlinks the artifact of another model to dst, falling
+// back to a copy when the files are on different devices. The caller needs
+// viewer access to the problem of the other model.
+func (s *basicModelService) linkModelDependency(ctx context.Context, d t.Dependency, dst string) error {
+	modelId, rel, err := parseModelSource(d.Source)
+	if err != nil {
+		return err
+	}
+	model, err := s.repos.Models.FindModel(ctx, modelFindOne.RequestData{Id: modelId})
+	if err != nil {
+		return err
+	}
+	model = s.localModel(model)
+	if model.Id.IsZero() {
+		return fmt.Errorf("model %s not found", modelId.Hex())
+	}
+	problem, err := s.repos.Problems.FindProblem(ctx, problemFindOne.RequestData{Id: model.ProblemId})
+	if err != nil {
+		return err
+	}
+	if err := access.CheckProblem(ctx, problem, role.Viewer); err != nil {
+		return err
+	}
+	src := fp.Join(model.Dir, rel)
+	if err := s.paths.CheckRead(ctx, src); err != nil {
+		return err
+	}
+	stat, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if stat.IsDir() {
+		return fmt.Errorf("%s is a folder", d.Source)
+	}
+	if d.Size > 0 && stat.Size() != int64(d.Size) {
+		return errors.New("wrong size")
+	}
+	if d.Sha256 != "" && getSha265(src) != d.Sha256 {
+		return errors.New("wrong sha")
+	}
+	if err := os.MkdirAll(fp.Dir(dst), 0777); err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	_, err = uFiles.Copy(src, dst)
+	return err
+}
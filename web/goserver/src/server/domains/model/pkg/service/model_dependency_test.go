@@ -0,0 +1,73 @@
+package service_test
+
+import (
+	"context"
+	"os"
+	fp "path/filepath"
+	"testing"
+
+	problemFindOne "server/db/pkg/handler/problem/find_one"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/domains/model/pkg/service"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+)
+
+// A model:// dependency reads the artifact of a model of another problem,
+// which the importer has to be able to view.
+func TestModelDependencyChecksTheSourceProblem(test *testing.T) {
+	for _, c := range []struct {
+		name   string
+		access map[string]string
+		linked bool
+	}{
+		{"editor of the target only", map[string]string{}, false},
+		{"viewer of the source", map[string]string{"alice": role.Viewer}, true},
+	} {
+		test.Run(c.name, func(test *testing.T) {
+			f := newMemoryFixture(test)
+			source := f.importDetector(test)
+			vehicles, err := f.store.FindProblem(context.Background(), problemFindOne.RequestData{Id: source.ProblemId})
+			if err != nil {
+				test.Fatal(err)
+			}
+			vehicles.Access = c.access
+			f.store.AddProblem(vehicles)
+			people := f.store.AddProblem(t.Problem{
+				Title:  "people",
+				Class:  "Object Detection",
+				Dir:    fp.Join(f.problems, "people"),
+				Access: map[string]string{"alice": role.Editor},
+			})
+
+			ctx := auth.WithIdentity(context.Background(), auth.Identity{User: "alice"})
+			dir := fp.Join(f.root, "templates", "person_detector")
+			writeFile(test, fp.Join(dir, "model.py"), "model = dict()\n")
+			writeFile(test, fp.Join(dir, "template.yaml"), `name: person_detector
+domain: Object Detection
+problem: people
+framework: OTEDetection v2.1.1
+config: model.py
+hyper_parameters:
+  basic:
+    batch_size: 32
+    epochs: 10
+dependencies:
+- source: model://`+source.Id.Hex()+`/snapshot.pth
+  destination: snapshot.pth
+  sha256: `+sha("weights")+`
+  size: 7
+`)
+			path := fp.Join(dir, "template.yaml")
+			resp := service.ImportTemplate(ctx, f.service, service.UpdateFromLocalRequestData{Path: path, Options: service.ImportOptions{MaxAttempts: 1}})
+			if resp.Err.Code != kitendpoint.ErrCodeOk {
+				test.Fatalf("code %d (%s)", resp.Err.Code, resp.Err.Message)
+			}
+			_, err = os.Stat(fp.Join(people.Dir, "person_detector", "snapshot.pth"))
+			if linked := err == nil; linked != c.linked {
+				test.Errorf("snapshot linked %v, want %v", linked, c.linked)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFind "server/db/pkg/handler/model/find"
+	t "server/db/pkg/types"
+)
+
+const (
+	// modelLockWait is how long a change of a model waits for the change
+	// in progress to end before failing with a ModelLockedError. The
+	// changes take a few database round trips, a dependency download
+	// longer.
+	modelLockWait = 30 * time.Second
+	// modelLockPoll is how often the lock of a model held by another
+	// replica is tried again.
+	modelLockPoll = 100 * time.Millisecond
+)
+
+// ModelLockedError is a model changed by another request for longer than
+// modelLockWait. It is worth retrying once the change is over.
+type ModelLockedError struct {
+	ModelId primitive.ObjectID
+	Lock    t.Lock
+}
+
+func (e *ModelLockedError) Error() string {
+	return fmt.Sprintf("model %s is busy with the %s of %s since %s, retry later", e.ModelId.Hex(), e.Lock.Holder, e.Lock.Owner, e.Lock.AcquiredAt.Format(time.RFC3339))
+}
+
+// lockModel serializes the changes of the model id: the tags, the archiving,
+// the dependencies, the evaluation results, the metrics files, the bulk
+// updates and the deletion take it before reading the model they change, so
+// that they do not save over one another, and hold it until they saved it.
+// They save only the fields they change: the status writes of the trainings
+// and evaluations do not take the lock. Reads do not take it either. holder
+// is a short description of the change. The changes of a replica wait in
+// turn, those of the replicas on the database lock of the model, for
+// modelLockWait at most. A change holding the lock of a model
+// may then take the lock of its folder, never the reverse.
+func (s *basicModelService) lockModel(ctx context.Context, id primitive.ObjectID, holder string) (unlock func(), err error) {
+	wait, cancel := context.WithTimeout(ctx, modelLockWait)
+	defer cancel()
+	unlockLocal, local := s.modelLocks.lock(wait, id, holder)
+	if local != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, &ModelLockedError{ModelId: id, Lock: *local}
+	}
+	key := "model/" + id.Hex()
+	for {
+		unlock, held, err := s.acquireLock(ctx, key, "model "+id.Hex(), holder)
+		if err != nil {
+			unlockLocal()
+			return nil, err
+		}
+		if held == nil {
+			return func() {
+				unlock()
+				unlockLocal()
+			}, nil
+		}
+		select {
+		case <-wait.Done():
+			unlockLocal()
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return nil, &ModelLockedError{ModelId: id, Lock: *held}
+		case <-time.After(modelLockPoll):
+		}
+	}
+}
+
+// lockModels takes the locks of the models filter finds, whatever its page,
+// in the order of their ids, so that two requests locking models in bulk do
+// not each wait for a lock the other holds. It returns the ids of the
+// models locked.
+func (s *basicModelService) lockModels(ctx context.Context, filter modelFind.RequestData, holder string) (unlock func(), ids []primitive.ObjectID, err error) {
+	filter.Page, filter.Size, filter.Fields = 1, 0, []string{"_id"}
+	found, err := s.repos.Models.FindModels(ctx, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, model := range found.Items {
+		ids = append(ids, model.Id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Hex() < ids[j].Hex() })
+	var unlocks []func()
+	unlock = func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	for _, id := range ids {
+		unlockModel, err := s.lockModel(ctx, id, holder)
+		if err != nil {
+			unlock()
+			return nil, nil, err
+		}
+		unlocks = append(unlocks, unlockModel)
+	}
+	return unlock, ids, nil
+}
+
+// modelLocks are the models changed by this replica, the other changes of
+// a model waiting in turn on its lock.
+type modelLocks struct {
+	mu    sync.Mutex
+	locks map[primitive.ObjectID]*modelLock
+}
+
+type modelLock struct {
+	held    t.Lock
+	waiters int
+	free    chan struct{}
+}
+
+func newModelLocks() *modelLocks {
+	return &modelLocks{locks: make(map[primitive.ObjectID]*modelLock)}
+}
+
+// lock takes the lock of the model id for holder, returning the lock as it
+// is held instead when ctx is done first.
+func (l *modelLocks) lock(ctx context.Context, id primitive.ObjectID, holder string) (unlock func(), held *t.Lock) {
+	l.mu.Lock()
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &modelLock{free: make(chan struct{}, 1)}
+		lock.free <- struct{}{}
+		l.locks[id] = lock
+	}
+	lock.waiters++
+	l.mu.Unlock()
+
+	select {
+	case <-lock.free:
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		current := lock.held
+		l.leave(id, lock)
+		return nil, &current
+	}
+	l.mu.Lock()
+	lock.held = t.Lock{Key: id.Hex(), Owner: lockOwnerPrefix, Holder: holder, AcquiredAt: time.Now()}
+	l.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			lock.held = t.Lock{}
+			lock.free <- struct{}{}
+			l.leave(id, lock)
+		})
+	}, nil
+}
+
+// leave forgets the lock of the model id once nobody holds or waits for it.
+// It is called with mu held.
+func (l *modelLocks) leave(id primitive.ObjectID, lock *modelLock) {
+	lock.waiters--
+	if lock.waiters == 0 {
+		delete(l.locks, id)
+	}
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	modelFind "server/db/pkg/handler/model/find"
+	problemFindOne "server/db/pkg/handler/problem/find_one"
+	t "server/db/pkg/types"
+	kitendpoint "server/kit/endpoint"
+)
+
+// checkNameConflict looks for the models named as model in the other
+// problems, which the UI, keying the models on their names, would mix up
+// with it. It fails with ErrCodeConflict listing them, unless allow is set,
+// when it returns the warning to import model with instead. The models of
+// the other problems are never overwritten, the lookup failing only lets
+// the import go on.
+func (s *basicModelService) checkNameConflict(ctx context.Context, model t.Model, allow bool) (string, kitendpoint.Error) {
+	models, err := s.repos.Models.FindModels(ctx, modelFind.RequestData{Page: 1, Size: 0, AnyProblem: true, Name: model.Name, Fields: []string{"_id", "name", "problemId", "workspace"}})
+	if err != nil {
+		log.Println("domains.model.pkg.service.name_conflicts.checkNameConflict.FindModels", model.Name, err)
+		return "", kitendpoint.Error{Code: 0}
+	}
+	var others []string
+	for _, other := range models.Items {
+		if other.ProblemId == model.ProblemId {
+			continue
+		}
+		others = append(others, s.describeModel(ctx, other))
+	}
+	if len(others) == 0 {
+		return "", kitendpoint.Error{Code: 0}
+	}
+	message := fmt.Sprintf("model %q already exists in %s", model.Name, strings.Join(others, ", "))
+	if !allow {
+		return "", kitendpoint.Error{Code: kitendpoint.ErrCodeConflict, Message: message + ", rename it or allow duplicate names"}
+	}
+	return message, kitendpoint.Error{Code: 0}
+}
+
+// describeModel names the problem of model, by its title when it can be
+// found, and its workspace.
+func (s *basicModelService) describeModel(ctx context.Context, model t.Model) string {
+	problem := model.ProblemId.Hex()
+	if found, err := s.repos.Problems.FindProblem(ctx, problemFindOne.RequestData{Id: model.ProblemId}); err == nil && found.Title != "" {
+		problem = fmt.Sprintf("%q", found.Title)
+	}
+	description := fmt.Sprintf("problem %s (model %s)", problem, model.Id.Hex())
+	if model.Workspace != "" {
+		description = fmt.Sprintf("workspace %q of %s", model.Workspace, description)
+	}
+	return description
+}
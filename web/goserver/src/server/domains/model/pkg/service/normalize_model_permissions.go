@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	fp "path/filepath"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+)
+
+// The modes NormalizeModelPermissions gives by default to the folders, to
+// the files and to the files their owner could run, such as the training
+// scripts.
+const (
+	defaultDirMode  os.FileMode = 0755
+	defaultFileMode os.FileMode = 0644
+	defaultExecMode os.FileMode = 0755
+)
+
+// NormalizeModelPermissionsRequestData asks for the modes of the folders and
+// files of the model ModelId to be set. The modes are octal strings, such as
+// "0750", the defaults being used for those not set. DryRun reports what
+// would change without changing it.
+type NormalizeModelPermissionsRequestData struct {
+	ModelId  primitive.ObjectID `json:"modelId"`
+	DirMode  string             `json:"dirMode,omitempty"`
+	FileMode string             `json:"fileMode,omitempty"`
+	ExecMode string             `json:"execMode,omitempty"`
+	DryRun   bool               `json:"dryRun"`
+}
+
+// PermissionChange is a file or folder of the model, relative to its folder,
+// whose mode was, or is to be, changed. Error tells why it was not.
+type PermissionChange struct {
+	Path  string `json:"path"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Error string `json:"error,omitempty"`
+}
+
+// NormalizeModelPermissionsResponseData lists the Changed entries out of the
+// Checked ones, Failed counting those that could not be changed.
+type NormalizeModelPermissionsResponseData struct {
+	DryRun  bool               `json:"dryRun"`
+	Checked int                `json:"checked"`
+	Changed []PermissionChange `json:"changed"`
+	Failed  int                `json:"failed"`
+}
+
+// permissionModes are the modes NormalizeModelPermissions sets.
+type permissionModes struct {
+	dir, file, exec os.FileMode
+}
+
+func (req NormalizeModelPermissionsRequestData) modes() (modes permissionModes, err error) {
+	if modes.dir, err = parseMode("dirMode", req.DirMode, defaultDirMode); err != nil {
+		return modes, err
+	}
+	if modes.file, err = parseMode("fileMode", req.FileMode, defaultFileMode); err != nil {
+		return modes, err
+	}
+	modes.exec, err = parseMode("execMode", req.ExecMode, defaultExecMode)
+	return modes, err
+}
+
+// parseMode reads the octal mode value of field, def when it is empty.
+func parseMode(field, value string, def os.FileMode) (os.FileMode, error) {
+	if value == "" {
+		return def, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%s %q is not an octal mode from 0 to 0777", field, value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// NormalizeModelPermissions repairs the modes of the files of a model after
+// a restore or a move between machines left them unreadable to the training
+// scripts. It walks the model folder, giving the folders the dir mode, the
+// files their owner could run the exec mode and the other files the file
+// mode. Symlinks are left alone, as are the entries it can not change, which
+// it reports and goes on. The hardlinks of the snapshot store of a Cas model
+// are changed with it.
+func (s *basicModelService) NormalizeModelPermissions(ctx context.Context, req NormalizeModelPermissionsRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		data, err := s.normalizeModelPermissions(ctx, req)
+		if err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: err, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: err, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicModelService) normalizeModelPermissions(ctx context.Context, req NormalizeModelPermissionsRequestData) (NormalizeModelPermissionsResponseData, kitendpoint.Error) {
+	data := NormalizeModelPermissionsResponseData{DryRun: req.DryRun, Changed: []PermissionChange{}}
+	modes, err := req.modes()
+	if err != nil {
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}
+	}
+	modelResp := <-modelFindOne.Send(ctx, s.Conn, modelFindOne.RequestData{Id: req.ModelId})
+	model := s.localModel(modelResp.Data.(modelFindOne.ResponseData))
+	if model.Id.IsZero() {
+		err := fmt.Errorf("model %s not found", req.ModelId.Hex())
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}
+	}
+	if err := access.Check(ctx, s.Conn, model.ProblemId, role.Editor); err != nil {
+		return data, kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}
+	}
+	if err := s.paths.CheckWrite(ctx, model.Dir); err != nil {
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: err.Error()}
+	}
+	unlock, err := s.lockDir(ctx, model.Dir, "permissions")
+	if err != nil {
+		return data, kitendpoint.Error{Code: dirLockErrCode(err), Message: err.Error()}
+	}
+	defer unlock()
+	if _, err := os.Stat(model.Dir); err != nil {
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: fmt.Sprintf("folder of model %q: %v", model.Name, err)}
+	}
+	err = fp.Walk(model.Dir, func(path string, info os.FileInfo, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, _ := fp.Rel(model.Dir, path)
+		if err != nil {
+			// The folder could not be read, although it got its mode
+			// before its entries were walked.
+			log.Println("domains.model.pkg.service.normalize_model_permissions.normalizeModelPermissions.Walk", path, err)
+			data.Changed = append(data.Changed, PermissionChange{Path: rel, Error: err.Error()})
+			data.Failed++
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 || !(info.IsDir() || info.Mode().IsRegular()) {
+			return nil
+		}
+		data.Checked++
+		want := modes.of(info)
+		if info.Mode().Perm() == want {
+			return nil
+		}
+		change := PermissionChange{Path: rel, From: fmt.Sprintf("%04o", info.Mode().Perm()), To: fmt.Sprintf("%04o", want)}
+		if !req.DryRun {
+			if err := os.Chmod(path, want); err != nil {
+				log.Println("domains.model.pkg.service.normalize_model_permissions.normalizeModelPermissions.os.Chmod", path, err)
+				change.Error = err.Error()
+				data.Failed++
+			}
+		}
+		data.Changed = append(data.Changed, change)
+		return nil
+	})
+	if err != nil {
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodeCancelled, Message: err.Error()}
+	}
+	return data, kitendpoint.Error{Code: 0}
+}
+
+// of is the mode the entry of info is to have.
+func (m permissionModes) of(info os.FileInfo) os.FileMode {
+	switch {
+	case info.IsDir():
+		return m.dir
+	case info.Mode().Perm()&0100 != 0:
+		return m.exec
+	}
+	return m.file
+}
@@ -0,0 +1,369 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	operationFindOne "server/db/pkg/handler/operation/find_one"
+	operationInsertOne "server/db/pkg/handler/operation/insert_one"
+	operationUpdateOne "server/db/pkg/handler/operation/update_one"
+	t "server/db/pkg/types"
+	statusOperation "server/db/pkg/types/status/operation"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/notify"
+)
+
+const (
+	operationWatchInterval = time.Second
+	operationWatchTimeout  = 12 * time.Hour
+	// operationSummaryLimit is the size of the result quoted by the
+	// notifications.
+	operationSummaryLimit = 2000
+)
+
+// NotifyRequest asks for a notification when the operation started by the
+// request ends. NotifyOn holds "success" and "failure", the email and the
+// Slack webhook default to those configured for the user.
+type NotifyRequest struct {
+	NotifyOn              []string `json:"notifyOn,omitempty"`
+	NotifyEmail           string   `json:"notifyEmail,omitempty"`
+	NotifySlackWebhookUrl string   `json:"notifySlackWebhookUrl,omitempty"`
+}
+
+// operation mirrors the state of a long-running request in the operation
+// collection. Its first response carries the OperationId, so a client that
+// lost the stream can call GetOperation or WatchOperation, or stop it with
+// CancelOperation. Its methods may be called from several goroutines.
+type operation struct {
+	conn         *rabbitmq.Connection
+	responseChan chan kitendpoint.Response
+	notifier     *notify.Notifier
+	subscription notify.Subscription
+	mu           sync.Mutex
+	t.Operation
+
+	// cancel cancels the context the operation runs in, done is closed once
+	// it finished. cleanups run when it finishes cancelled. lost tells the
+	// stall monitor took the operation over.
+	running  *runningOperations
+	cancel   context.CancelFunc
+	done     chan struct{}
+	cleanups []func()
+	finished bool
+	lost     bool
+}
+
+// subscription is where and when the end of the operation started by req is
+// notified, an error telling the request asks for what cannot be served.
+func (s *basicModelService) subscription(ctx context.Context, req NotifyRequest) (notify.Subscription, error) {
+	var user string
+	if identity, ok := auth.FromContext(ctx); ok {
+		user = identity.User
+	}
+	return s.notifier.Subscription(user, notify.Subscription{
+		On:              req.NotifyOn,
+		Email:           req.NotifyEmail,
+		SlackWebhookUrl: req.NotifySlackWebhookUrl,
+	})
+}
+
+// startOperation stores a new operation and sends it as the first response.
+// The operation runs in the context returned, which CancelOperation cancels.
+func (s *basicModelService) startOperation(ctx context.Context, kind string, subscription notify.Subscription, responseChan chan kitendpoint.Response) (context.Context, *operation) {
+	op := &operation{conn: s.Conn, responseChan: responseChan, notifier: s.notifier, subscription: subscription}
+	var startedBy string
+	if identity, ok := auth.FromContext(ctx); ok {
+		startedBy = identity.User
+	}
+	resp := <-operationInsertOne.Send(ctx, s.Conn, operationInsertOne.RequestData{
+		Kind:        kind,
+		Status:      statusOperation.Pending,
+		Warnings:    []string{},
+		StartedBy:   startedBy,
+		HeartbeatAt: time.Now(),
+		Owner:       lockOwnerPrefix,
+	})
+	if resp.Err.Code > 0 {
+		log.Println("operation.startOperation", resp.Err.Message)
+	} else {
+		op.Operation = resp.Data.(operationInsertOne.ResponseData)
+	}
+	ctx = s.operations.add(ctx, op)
+	responseChan <- kitendpoint.Response{Data: op.Operation, Err: kitendpoint.Error{Code: 0}, IsLast: false, OperationId: op.id()}
+	return ctx, op
+}
+
+func (op *operation) id() string {
+	if op.Id.IsZero() {
+		return ""
+	}
+	return op.Id.Hex()
+}
+
+// save stores the operation, the caller holds op.mu. A lost operation is
+// no longer saved.
+func (op *operation) save(ctx context.Context) {
+	if op.Id.IsZero() || op.lost {
+		return
+	}
+	<-operationUpdateOne.Send(ctx, op.conn, op.Operation)
+}
+
+// keepRequest stores req with the operation, for it to be resumed.
+func (op *operation) keepRequest(ctx context.Context, req interface{}) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		log.Println("operation.keepRequest", err)
+		return
+	}
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.Request = b
+	op.save(ctx)
+}
+
+func (op *operation) run(ctx context.Context) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.Status = statusOperation.Running
+	op.save(ctx)
+}
+
+func (op *operation) progress(ctx context.Context, progress float64) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.Progress = progress
+	op.save(ctx)
+}
+
+func (op *operation) warn(ctx context.Context, format string, args ...interface{}) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.Warnings = append(op.Warnings, fmt.Sprintf(format, args...))
+	op.save(ctx)
+}
+
+// onCancel registers cleanup to run when the operation finishes cancelled,
+// once it stopped, the last registered first.
+func (op *operation) onCancel(cleanup func()) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.cleanups = append(op.cleanups, cleanup)
+}
+
+// requestCancel records that by cancels the operation and cancels its
+// context. The operation finishes cancelled when it stops.
+func (op *operation) requestCancel(by string) {
+	op.mu.Lock()
+	if op.CancelledAt.IsZero() {
+		op.CancelledBy = by
+		op.CancelledAt = time.Now()
+	}
+	op.mu.Unlock()
+	op.cancel()
+}
+
+// send streams an intermediate response of the operation.
+func (op *operation) send(resp kitendpoint.Response) {
+	resp.IsLast = false
+	resp.OperationId = op.id()
+	op.responseChan <- resp
+}
+
+// finish stores resp as the terminal payload and sends it. The operation
+// fails when resp carries an error code or err is set, and is cancelled,
+// whatever resp says, when CancelOperation asked for it. A cancelled
+// operation runs its cleanups and is not notified, nor is a lost one, which
+// is left to the stall monitor as it stored it.
+func (op *operation) finish(ctx context.Context, resp kitendpoint.Response, err error) {
+	op.mu.Lock()
+	op.finished = true
+	lost := op.lost
+	op.Status = statusOperation.Succeeded
+	op.Progress = 1
+	if resp.Err.Code > 0 {
+		op.Status = statusOperation.Failed
+		op.Error = resp.Err.Message
+	}
+	if err != nil {
+		op.Status = statusOperation.Failed
+		op.Error = err.Error()
+	}
+	cancelled := !op.CancelledAt.IsZero()
+	if cancelled {
+		op.Status = statusOperation.Cancelled
+		op.Error = fmt.Sprintf("cancelled by %s", canceller(op.CancelledBy))
+		resp.Err = kitendpoint.Error{Code: kitendpoint.ErrCodeCancelled, Message: op.Error}
+	}
+	if lost {
+		cancelled = true
+		resp.Err = kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: fmt.Sprintf("operation %s stalled and was taken over", op.id())}
+	}
+	if b, err := json.Marshal(resp.Data); err == nil {
+		op.Result = b
+	}
+	// The context of the operation is cancelled by now, the last save must
+	// not be.
+	op.save(context.Background())
+	outcome := notify.OnSuccess
+	if op.Status == statusOperation.Failed {
+		outcome = notify.OnFailure
+	}
+	cleanups := op.cleanups
+	op.cleanups = nil
+	op.mu.Unlock()
+	if cancelled {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	} else if op.subscription.Wants(outcome) {
+		go op.notify()
+	}
+	if op.running != nil {
+		op.running.remove(op)
+	}
+	resp.IsLast = true
+	resp.OperationId = op.id()
+	op.responseChan <- resp
+}
+
+// notify sends the notifications of the end of the operation and keeps how
+// they went with it. It runs once the operation is finished, so it never
+// delays nor changes its result.
+func (op *operation) notify() {
+	op.mu.Lock()
+	msg := op.message()
+	op.mu.Unlock()
+	notifications := op.notifier.Send(context.Background(), op.subscription, msg)
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.Notifications = append(op.Notifications, notifications...)
+	op.save(context.Background())
+}
+
+// message tells the kind and outcome of the operation, how long it took,
+// its error or warnings and the start of its result. The caller holds op.mu.
+func (op *operation) message() notify.Message {
+	outcome := "succeeded"
+	if op.Status == statusOperation.Failed {
+		outcome = "failed"
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "Operation: %s %s\n", op.Kind, op.id())
+	fmt.Fprintf(&text, "Status: %s\n", outcome)
+	if !op.CreatedAt.IsZero() {
+		fmt.Fprintf(&text, "Duration: %s\n", time.Since(op.CreatedAt).Round(time.Second))
+	}
+	if op.Error != "" {
+		fmt.Fprintf(&text, "Error: %s\n", op.Error)
+	}
+	if len(op.Warnings) > 0 {
+		fmt.Fprintf(&text, "Warnings: %d, the first: %s\n", len(op.Warnings), op.Warnings[0])
+	}
+	if len(op.Result) > 0 && string(op.Result) != "null" {
+		summary := string(op.Result)
+		if len(summary) > operationSummaryLimit {
+			summary = summary[:operationSummaryLimit] + "..."
+		}
+		fmt.Fprintf(&text, "Summary: %s\n", summary)
+	}
+	var link string
+	if op.id() != "" {
+		link = op.notifier.Link("operations/" + op.id())
+	}
+	return notify.Message{
+		Subject: fmt.Sprintf("Operation %s %s", op.Kind, outcome),
+		Text:    text.String(),
+		Link:    link,
+	}
+}
+
+// isOperationDone tells whether the operation stopped, an interrupted or
+// stalled one doing nothing until it is resumed.
+func isOperationDone(op t.Operation) bool {
+	return isOperationOver(op) || op.Status == statusOperation.Interrupted || op.Status == statusOperation.Stalled
+}
+
+// isOperationOver tells whether the operation stopped for good.
+func isOperationOver(op t.Operation) bool {
+	return op.Status == statusOperation.Succeeded || op.Status == statusOperation.Failed || op.Status == statusOperation.Cancelled
+}
+
+type GetOperationRequestData struct {
+	Id primitive.ObjectID `json:"id"`
+}
+
+func (s *basicModelService) GetOperation(ctx context.Context, req GetOperationRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		op, err := s.getOperation(ctx, req.Id)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: op, Err: kitendpoint.Error{Code: 0}, IsLast: true, OperationId: op.Id.Hex()}
+	}()
+	return returnChan
+}
+
+type WatchOperationRequestData = GetOperationRequestData
+
+// WatchOperation streams the operation every time it changes and ends with
+// its terminal state.
+func (s *basicModelService) WatchOperation(ctx context.Context, req WatchOperationRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		ctx, cancel := context.WithTimeout(ctx, operationWatchTimeout)
+		defer cancel()
+		var updatedAt time.Time
+		for {
+			op, err := s.getOperation(ctx, req.Id)
+			if err != nil {
+				returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+				return
+			}
+			done := isOperationDone(op)
+			if done || !op.UpdatedAt.Equal(updatedAt) {
+				updatedAt = op.UpdatedAt
+				returnChan <- kitendpoint.Response{Data: op, Err: kitendpoint.Error{Code: 0}, IsLast: done, OperationId: op.Id.Hex()}
+			}
+			if done {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				returnChan <- kitendpoint.Response{Data: op, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: ctx.Err().Error()}, IsLast: true, OperationId: op.Id.Hex()}
+				return
+			case <-time.After(operationWatchInterval):
+			}
+		}
+	}()
+	return returnChan
+}
+
+// getOperation finds the operation id, without the request that started it.
+func (s *basicModelService) getOperation(ctx context.Context, id primitive.ObjectID) (t.Operation, error) {
+	op, err := s.getStoredOperation(ctx, id)
+	op.Request = nil
+	return op, err
+}
+
+func (s *basicModelService) getStoredOperation(ctx context.Context, id primitive.ObjectID) (t.Operation, error) {
+	resp := <-operationFindOne.Send(ctx, s.Conn, operationFindOne.RequestData{Id: id})
+	op := resp.Data.(operationFindOne.ResponseData)
+	if op.Id.IsZero() {
+		return op, fmt.Errorf("operation %s not found", id.Hex())
+	}
+	return op, nil
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	lockRelease "server/db/pkg/handler/lock/release"
+	operationClaim "server/db/pkg/handler/operation/claim"
+	operationHeartbeat "server/db/pkg/handler/operation/heartbeat"
+	operationStall "server/db/pkg/handler/operation/stall"
+	operationUpdateOne "server/db/pkg/handler/operation/update_one"
+	t "server/db/pkg/types"
+	statusOperation "server/db/pkg/types/status/operation"
+	typeWebhook "server/db/pkg/types/type/webhook"
+	"server/kit/webhook"
+)
+
+// operationHeartbeatInterval is how often the running operations tell they
+// are alive, and how often the stall monitor looks for those that stopped.
+const operationHeartbeatInterval = 30 * time.Second
+
+// StallSettings tells the stall monitor when an operation is stalled: when
+// its last heartbeat is older than After. The stalled directory imports are
+// resumed up to Retries times each.
+type StallSettings struct {
+	After   time.Duration
+	Retries int
+}
+
+// WebhookOperationData is the data of the operationStalled event. Retried
+// tells the operation was resumed.
+type WebhookOperationData struct {
+	OperationId primitive.ObjectID `json:"operationId"`
+	Kind        string             `json:"kind"`
+	Status      string             `json:"status"`
+	StartedBy   string             `json:"startedBy,omitempty"`
+	Owner       string             `json:"owner,omitempty"`
+	HeartbeatAt time.Time          `json:"heartbeatAt"`
+	Stalls      int                `json:"stalls"`
+	Retried     bool               `json:"retried"`
+}
+
+type operationIdKey struct{}
+
+// withOperationId tells the work done in ctx, the locks it takes among
+// others, that it belongs to the operation id.
+func withOperationId(ctx context.Context, id primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, operationIdKey{}, id)
+}
+
+// operationIdFrom is the hex of the id of the operation ctx works for, empty
+// when there is none.
+func operationIdFrom(ctx context.Context) string {
+	id, ok := ctx.Value(operationIdKey{}).(primitive.ObjectID)
+	if !ok || id.IsZero() {
+		return ""
+	}
+	return id.Hex()
+}
+
+// keepAlive beats the operation every operationHeartbeatInterval until it
+// finished. probe must succeed for a beat to be sent, so that a worker
+// wedged on the problem folder is found stalled although this replica is
+// alive. The operation is lost when the beat finds it no longer runs, the
+// stall monitor having taken it over.
+func (op *operation) keepAlive(probe func() error) {
+	ticker := time.NewTicker(operationHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-op.done:
+			return
+		}
+		if err := probe(); err != nil {
+			log.Println("domains.model.pkg.service.operation_heartbeat.keepAlive.probe", op.id(), err)
+			continue
+		}
+		now := time.Now()
+		resp := <-operationHeartbeat.Send(context.Background(), op.conn, operationHeartbeat.RequestData{Id: op.Id, Owner: lockOwnerPrefix})
+		if resp.Err.Code > 0 {
+			log.Println("domains.model.pkg.service.operation_heartbeat.keepAlive.operationHeartbeat.Send", op.id(), resp.Err.Message)
+			continue
+		}
+		if !resp.Data.(operationHeartbeat.ResponseData).Alive {
+			op.lose()
+			return
+		}
+		op.mu.Lock()
+		// The saves of the operation store its heartbeat as well.
+		op.HeartbeatAt = now
+		op.mu.Unlock()
+	}
+}
+
+// lose stops the operation the stall monitor took over, which it must no
+// longer save: its locks were released and it may run again elsewhere.
+func (op *operation) lose() {
+	op.mu.Lock()
+	if op.finished {
+		op.mu.Unlock()
+		return
+	}
+	op.lost = true
+	op.mu.Unlock()
+	log.Println("domains.model.pkg.service.operation_heartbeat.lose: operation taken over as stalled", op.id())
+	op.cancel()
+}
+
+// RunStallMonitor looks for the stalled operations every
+// operationHeartbeatInterval until stop is closed, and stalls them.
+func RunStallMonitor(conn *rabbitmq.Connection, svc ModelService, webhooks *webhook.Dispatcher, settings StallSettings, stop <-chan struct{}) {
+	ticker := time.NewTicker(operationHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), recoverTimeout)
+		resp := <-operationStall.Send(ctx, conn, operationStall.RequestData{Before: time.Now().Add(-settings.After)})
+		if resp.Err.Code > 0 {
+			log.Println("domains.model.pkg.service.operation_heartbeat.RunStallMonitor.operationStall.Send", resp.Err.Message)
+			cancel()
+			continue
+		}
+		for _, op := range resp.Data.(operationStall.ResponseData).Items {
+			stallOperation(ctx, conn, svc, webhooks, settings, op)
+		}
+		cancel()
+	}
+}
+
+// stallOperation releases the locks of the operation found stalled, resumes
+// it when it is a directory import with retries left and tells the webhooks.
+// The operations that are not resumed stay stalled, for CancelOperation or
+// ResumeOperation.
+func stallOperation(ctx context.Context, conn *rabbitmq.Connection, svc ModelService, webhooks *webhook.Dispatcher, settings StallSettings, op t.Operation) {
+	log.Printf("domains.model.pkg.service.operation_heartbeat.stallOperation: operation %s %s stalled, last heartbeat %s by %s", op.Kind, op.Id.Hex(), op.HeartbeatAt.Format(time.RFC3339), op.Owner)
+	resp := <-lockRelease.Send(ctx, conn, lockRelease.RequestData{Operation: op.Id.Hex()})
+	if resp.Err.Code > 0 {
+		log.Println("domains.model.pkg.service.operation_heartbeat.stallOperation.lockRelease.Send", op.Id.Hex(), resp.Err.Message)
+	}
+	retried := false
+	if len(op.Request) > 0 && stallRetries(op) < settings.Retries {
+		claimResp := <-operationClaim.Send(ctx, conn, operationClaim.RequestData{Id: op.Id, From: statusOperation.Stalled, To: statusOperation.Interrupted})
+		claimed, _ := claimResp.Data.(operationClaim.ResponseData)
+		if claimResp.Err.Code == 0 && !claimed.Id.IsZero() {
+			claimed.Stalls[len(claimed.Stalls)-1].Retried = true
+			<-operationUpdateOne.Send(ctx, conn, claimed)
+			op, retried = claimed, true
+		}
+	}
+	webhooks.Emit(typeWebhook.OperationStalled, primitive.NilObjectID, WebhookOperationData{
+		OperationId: op.Id,
+		Kind:        op.Kind,
+		Status:      op.Status,
+		StartedBy:   op.StartedBy,
+		Owner:       op.Owner,
+		HeartbeatAt: op.HeartbeatAt,
+		Stalls:      len(op.Stalls),
+		Retried:     retried,
+	})
+	if !retried {
+		return
+	}
+	go func() {
+		for resp := range svc.ResumeOperation(context.Background(), ResumeOperationRequestData{Id: op.Id}) {
+			if resp.IsLast && resp.Err.Code > 0 {
+				log.Println("domains.model.pkg.service.operation_heartbeat.stallOperation.svc.ResumeOperation", op.Id.Hex(), resp.Err.Message)
+			}
+		}
+	}()
+}
+
+// stallRetries counts the times the operation was resumed after a stall.
+func stallRetries(op t.Operation) int {
+	n := 0
+	for _, stall := range op.Stalls {
+		if stall.Retried {
+			n++
+		}
+	}
+	return n
+}
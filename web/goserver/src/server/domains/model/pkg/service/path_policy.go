@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"time"
+
+	t "server/db/pkg/types"
+	typeAudit "server/db/pkg/types/type/audit"
+	"server/kit/audit"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+)
+
+// Operations checked by PathPolicy.
+const (
+	PathRead  = "read"
+	PathWrite = "write"
+)
+
+// PathPolicy keeps the file operations of the service inside its roots: files
+// are written under the data roots only, read under the data and template
+// roots, and templates are imported from the template roots only. Paths are
+// checked once their symlinks are resolved, so neither a link nor a ".." leads
+// out. Refusals are logged to the audit log, when there is one. Relative
+// template paths are taken relative to the template base.
+type PathPolicy struct {
+	dataRoots     []string
+	templateRoots []string
+	templateBase  string
+	auditLog      *audit.Log
+}
+
+// PathViolationError is a refused file operation.
+type PathViolationError struct {
+	Op       string
+	Path     string
+	Resolved string
+}
+
+func (e *PathViolationError) Error() string {
+	if e.Resolved != "" && e.Resolved != e.Path {
+		return fmt.Sprintf("refusing to %s %s, it resolves to %s outside of the allowed folders", e.Op, e.Path, e.Resolved)
+	}
+	return fmt.Sprintf("refusing to %s %s, it is outside of the allowed folders", e.Op, e.Path)
+}
+
+// NewPathPolicy resolves the roots, empty ones are ignored. templateBase
+// defaults to the first template root. auditLog may be nil.
+func NewPathPolicy(dataRoots, templateRoots []string, templateBase string, auditLog *audit.Log) (*PathPolicy, error) {
+	p := &PathPolicy{auditLog: auditLog}
+	var err error
+	if p.dataRoots, err = resolveRoots(dataRoots); err != nil {
+		return nil, err
+	}
+	if p.templateRoots, err = resolveRoots(templateRoots); err != nil {
+		return nil, err
+	}
+	if len(p.dataRoots) == 0 {
+		return nil, fmt.Errorf("no data root is set")
+	}
+	if templateBase == "" && len(p.templateRoots) > 0 {
+		templateBase = p.templateRoots[0]
+	}
+	if templateBase != "" {
+		if p.templateBase, err = fp.Abs(templateBase); err != nil {
+			return nil, fmt.Errorf("template base %s: %v", templateBase, err)
+		}
+	}
+	return p, nil
+}
+
+func resolveRoots(roots []string) ([]string, error) {
+	var resolved []string
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		r, err := resolvePath(root)
+		if err != nil {
+			return nil, fmt.Errorf("root %s: %v", root, err)
+		}
+		resolved = append(resolved, r)
+	}
+	return resolved, nil
+}
+
+// CheckRead allows reading path when it is under a data or template root.
+func (p *PathPolicy) CheckRead(ctx context.Context, path string) error {
+	return p.check(ctx, PathRead, path, p.dataRoots, p.templateRoots)
+}
+
+// CheckWrite allows creating, changing or removing path when it is under a
+// data root.
+func (p *PathPolicy) CheckWrite(ctx context.Context, path string) error {
+	return p.check(ctx, PathWrite, path, p.dataRoots)
+}
+
+// CheckTemplate allows importing the template at path when it is under a
+// template root.
+func (p *PathPolicy) CheckTemplate(ctx context.Context, path string) error {
+	return p.check(ctx, PathRead, path, p.templateRoots)
+}
+
+// TemplatePath is the absolute path of the template at path, a relative one
+// being taken relative to the template base rather than to the working folder
+// of the service, which callers do not know. An empty path stays empty.
+func (p *PathPolicy) TemplatePath(path string) string {
+	if path == "" {
+		return ""
+	}
+	if fp.IsAbs(path) {
+		return fp.Clean(path)
+	}
+	if p.templateBase == "" {
+		abs, err := fp.Abs(path)
+		if err != nil {
+			return path
+		}
+		return abs
+	}
+	return fp.Join(p.templateBase, path)
+}
+
+func (p *PathPolicy) check(ctx context.Context, op, path string, rootSets ...[]string) error {
+	resolved, err := resolvePath(path)
+	if err == nil {
+		for _, roots := range rootSets {
+			for _, root := range roots {
+				if isUnder(resolved, root) {
+					return nil
+				}
+			}
+		}
+	}
+	violation := &PathViolationError{Op: op, Path: path, Resolved: resolved}
+	log.Println("domains.model.pkg.service.path_policy.check", violation, err)
+	if p.auditLog != nil {
+		identity, _ := auth.FromContext(ctx)
+		p.auditLog.Record(t.AuditEntry{
+			Action:    typeAudit.PathViolation,
+			Code:      kitendpoint.ErrCodePathViolation,
+			CreatedAt: time.Now(),
+			Message:   violation.Error(),
+			Request:   fmt.Sprintf("%s %s", op, path),
+			User:      identity.User,
+		})
+	}
+	return violation
+}
+
+// checkCopy allows copying from the sources to the folder to.
+func (s *basicModelService) checkCopy(ctx context.Context, to string, from ...string) error {
+	for _, path := range from {
+		if err := s.paths.CheckRead(ctx, path); err != nil {
+			return err
+		}
+	}
+	return s.paths.CheckWrite(ctx, to)
+}
+
+// pathErrCode is the response code of an error of a file operation.
+func pathErrCode(err error, otherwise int) int {
+	var violation *PathViolationError
+	if errors.As(err, &violation) {
+		return kitendpoint.ErrCodePathViolation
+	}
+	return otherwise
+}
+
+// resolvePath makes path absolute and resolves its symlinks. The missing end
+// of a path to be created is kept as is once the existing part is resolved.
+// ".." is only applied after the symlinks before it are resolved, the way the
+// system does it.
+func resolvePath(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	if !fp.IsAbs(path) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		path = wd + string(fp.Separator) + path
+	}
+	resolved, err := fp.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	trimmed := strings.TrimRight(path, string(fp.Separator))
+	i := strings.LastIndex(trimmed, string(fp.Separator))
+	if trimmed == "" || i < 0 {
+		return "", err
+	}
+	parent, base := trimmed[:i], trimmed[i+1:]
+	if parent == "" {
+		parent = string(fp.Separator)
+	}
+	// A dangling link would create its target, which is checked instead.
+	if info, lerr := os.Lstat(trimmed); lerr == nil && info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(trimmed)
+		if err != nil {
+			return "", err
+		}
+		if !fp.IsAbs(target) {
+			target = parent + string(fp.Separator) + target
+		}
+		return resolvePath(target)
+	}
+	resolvedParent, err := resolvePath(parent)
+	if err != nil {
+		return "", err
+	}
+	return fp.Join(resolvedParent, base), nil
+}
+
+func isUnder(path, root string) bool {
+	rel, err := fp.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(fp.Separator)))
+}
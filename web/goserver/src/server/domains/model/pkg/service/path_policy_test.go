@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+
+	t "server/db/pkg/types"
+)
+
+// pathFixture is a data root, a template root holding a template folder
+// and a folder outside of both with a secret file.
+type pathFixture struct {
+	data, templates, from, outside string
+	service                        *basicModelService
+}
+
+func newPathFixture(test *testing.T) pathFixture {
+	root, err := ioutil.TempDir("", "path_policy")
+	if err != nil {
+		test.Fatal(err)
+	}
+	test.Cleanup(func() { os.RemoveAll(root) })
+	f := pathFixture{
+		data:      fp.Join(root, "data"),
+		templates: fp.Join(root, "templates"),
+		from:      fp.Join(root, "templates", "model"),
+		outside:   fp.Join(root, "outside"),
+	}
+	for _, dir := range []string{f.data, f.from, f.outside} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			test.Fatal(err)
+		}
+	}
+	for _, file := range []string{fp.Join(f.outside, "secret"), fp.Join(f.from, "weights.bin"), fp.Join(f.from, "config.py")} {
+		if err := ioutil.WriteFile(file, []byte("x"), 0666); err != nil {
+			test.Fatal(err)
+		}
+	}
+	policy, err := NewPathPolicy([]string{f.data}, []string{f.templates}, "", nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	f.service = &basicModelService{paths: policy}
+	return f
+}
+
+func TestCheckModelPathsRefusesEscapingDependencies(test *testing.T) {
+	f := newPathFixture(test)
+	to := fp.Join(f.data, "model")
+	if err := os.MkdirAll(to, 0777); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.Symlink(fp.Join(f.outside, "secret"), fp.Join(f.from, "link")); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.Symlink(f.outside, fp.Join(to, "escape")); err != nil {
+		test.Fatal(err)
+	}
+	cases := []struct {
+		name       string
+		dependency t.Dependency
+		refused    bool
+	}{
+		{"inside", t.Dependency{Source: "weights.bin", Destination: "weights.bin"}, false},
+		{"download", t.Dependency{Source: "https://example.com/weights.bin", Destination: "weights.bin"}, false},
+		{"dot dot source", t.Dependency{Source: "../../outside/secret", Destination: "secret"}, true},
+		{"absolute source", t.Dependency{Source: fp.Join(f.outside, "secret"), Destination: "secret"}, true},
+		{"symlinked source", t.Dependency{Source: "link", Destination: "secret"}, true},
+		{"symlinked destination", t.Dependency{Source: "weights.bin", Destination: "escape/weights.bin"}, true},
+	}
+	for _, c := range cases {
+		modelYml := ModelYml{Config: "config.py", Dependencies: []t.Dependency{c.dependency}}
+		err := f.service.checkModelPaths(context.Background(), f.from, to, modelYml)
+		var violation *PathViolationError
+		if errors.As(err, &violation) != c.refused {
+			test.Errorf("%s: checkModelPaths = %v, refused %v", c.name, err, c.refused)
+		}
+	}
+}
+
+func TestCopyDependenciesFailsOnPathViolation(test *testing.T) {
+	f := newPathFixture(test)
+	to := fp.Join(f.data, "model")
+	modelYml := ModelYml{Dependencies: []t.Dependency{
+		{Source: "../../outside/secret", Destination: "secret"},
+	}}
+	stored := make([]t.Dependency, len(modelYml.Dependencies))
+	warnings, err := f.service.copyDependencies(context.Background(), f.from, modelYml, stored, ImportOptions{}, newImportDiff(to))
+	var violation *PathViolationError
+	if !errors.As(err, &violation) || len(warnings) > 0 {
+		test.Fatalf("copyDependencies = %v, %v, want a path violation", warnings, err)
+	}
+	if pathErrCode(err, 0) == 0 {
+		test.Errorf("the violation has no path violation code")
+	}
+	if _, err := os.Stat(fp.Join(to, "secret")); !os.IsNotExist(err) {
+		test.Errorf("the refused dependency was copied: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	fp "path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultHookTimeout = 5 * time.Minute
+
+// maxHookOutput bounds the output of a hook kept in the import stats, the
+// end of a longer one being dropped.
+const maxHookOutput = 64 << 10
+
+// PostImportHooks bounds the commands a template may have run in the folder
+// of a model once its files are copied, those of its post_import. Commands
+// lists the programs allowed, by name or path, none being allowed when it is
+// empty, the default. Each command is stopped after TimeoutSeconds.
+type PostImportHooks struct {
+	Commands       []string
+	TimeoutSeconds int
+}
+
+func (h PostImportHooks) timeout() time.Duration {
+	if h.TimeoutSeconds <= 0 {
+		return defaultHookTimeout
+	}
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+// HookResult is what a post_import command of the template did.
+type HookResult struct {
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exitCode"`
+	Output     string `json:"output"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// check returns an error for the first of commands that is empty or whose
+// program is not allowed.
+func (h PostImportHooks) check(commands []string) error {
+	for _, command := range commands {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return fmt.Errorf("post_import command is empty")
+		}
+		if !h.allows(args[0]) {
+			return fmt.Errorf("post_import command %q is not allowed", args[0])
+		}
+	}
+	return nil
+}
+
+func (h PostImportHooks) allows(program string) bool {
+	for _, allowed := range h.Commands {
+		if program == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// run runs commands in dir, one after the other, and stops at the first that
+// fails. The commands are split on spaces, not run by a shell, and get an
+// environment of PATH and MODEL_DIR only, so that they do not see the
+// credentials of the service.
+func (h PostImportHooks) run(ctx context.Context, dir string, commands []string) ([]HookResult, error) {
+	if err := h.check(commands); err != nil {
+		return nil, err
+	}
+	results := make([]HookResult, 0, len(commands))
+	for _, command := range commands {
+		result, err := h.runOne(ctx, dir, command)
+		results = append(results, result)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func (h PostImportHooks) runOne(ctx context.Context, dir, command string) (HookResult, error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, h.timeout())
+	defer cancel()
+	args := strings.Fields(command)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "MODEL_DIR=" + fp.Clean(dir)}
+	// The command gets a process group of its own, so that the processes it
+	// starts are stopped with it: one of them still holding the output open
+	// would keep Wait from returning.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	output := &hookOutput{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err := cmd.Start()
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+	result := HookResult{
+		Command:    command,
+		ExitCode:   cmd.ProcessState.ExitCode(),
+		Output:     output.String(),
+		DurationMs: int64(time.Since(start) / time.Millisecond),
+	}
+	switch {
+	case err == nil:
+		return result, nil
+	case ctx.Err() == context.DeadlineExceeded:
+		return result, fmt.Errorf("post_import command %q timed out after %s", command, h.timeout())
+	case result.ExitCode > 0:
+		return result, fmt.Errorf("post_import command %q exited with %d: %s", command, result.ExitCode, lastLine(result.Output))
+	}
+	return result, fmt.Errorf("post_import command %q: %v", command, err)
+}
+
+// hookOutput keeps the first maxHookOutput bytes written to it.
+type hookOutput struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (o *hookOutput) Write(p []byte) (int, error) {
+	if room := maxHookOutput - o.buf.Len(); len(p) > room {
+		o.buf.Write(p[:room])
+		o.truncated = true
+	} else {
+		o.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+func (o *hookOutput) String() string {
+	if o.truncated {
+		return o.buf.String() + "\n[output truncated]"
+	}
+	return o.buf.String()
+}
+
+// lastLine is the last non blank line of output, for the error of a failed
+// hook.
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
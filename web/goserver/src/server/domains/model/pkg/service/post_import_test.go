@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+	"time"
+)
+
+// A process the hook leaves behind holds its output open, the timeout stops
+// it along with the hook.
+func TestHookTimeoutStopsTheProcessesItStarted(test *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		test.Fatal(err)
+	}
+	test.Cleanup(func() { os.RemoveAll(dir) })
+	if err := ioutil.WriteFile(fp.Join(dir, "hook.sh"), []byte("sleep 60 &\necho started\n"), 0666); err != nil {
+		test.Fatal(err)
+	}
+	hooks := PostImportHooks{Commands: []string{"sh"}, TimeoutSeconds: 1}
+
+	start := time.Now()
+	results, err := hooks.run(context.Background(), dir, []string{"sh hook.sh"})
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		test.Fatalf("hook returned after %s", elapsed)
+	}
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Output != "started\n" {
+		test.Errorf("results %+v", results)
+	}
+}
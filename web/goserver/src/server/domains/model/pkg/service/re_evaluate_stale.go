@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	problemFind "server/db/pkg/handler/problem/find"
+	problemFindOne "server/db/pkg/handler/problem/find_one"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	statusModelEvaluate "server/db/pkg/types/status/model/evaluate"
+	"server/domains/problem/pkg/access"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+)
+
+// ReEvaluateStaleRequestData evaluates again the stale evaluations of the
+// models of ProblemId, or of every problem asking for it by AutoReEvaluate
+// when ProblemId is not set. At most Limit evaluations are run, all of them
+// when it is 0.
+type ReEvaluateStaleRequestData struct {
+	ProblemId primitive.ObjectID `json:"problemId"`
+	Limit     int                `json:"limit"`
+}
+
+// ReEvaluateStaleResult is sent for each evaluation run, Error telling why it
+// failed.
+type ReEvaluateStaleResult struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+	BuildId primitive.ObjectID `json:"buildId"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// ReEvaluateStaleSummary is the data of the last response of ReEvaluateStale.
+// Remaining are the stale evaluations left for the next run, because of the
+// limit or because the request was cancelled. Problems whose models could not
+// be listed are skipped.
+type ReEvaluateStaleSummary struct {
+	Evaluated       int      `json:"evaluated"`
+	Failed          int      `json:"failed"`
+	Remaining       int      `json:"remaining"`
+	SkippedProblems []string `json:"skippedProblems"`
+}
+
+// ReEvaluateStale evaluates the stale evaluations one after the other, each
+// one being an evaluation operation of its own. Only admins may run it on
+// every problem when the request carries an identity, editors of the problem
+// otherwise.
+func (s *basicModelService) ReEvaluateStale(ctx context.Context, req ReEvaluateStaleRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		problems, err := s.reEvaluateProblems(ctx, req.ProblemId)
+		if err != nil {
+			code := access.ErrCode(err)
+			if errors.Is(err, errReEvaluateForbidden) {
+				code = kitendpoint.ErrCodeForbidden
+			}
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: code, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if !atomic.CompareAndSwapInt32(&s.reEvaluating, 0, 1) {
+			err := fmt.Errorf("a re-evaluation is already running")
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeBusy, Message: err.Error()}, IsLast: true}
+			return
+		}
+		defer atomic.StoreInt32(&s.reEvaluating, 0)
+		summary := ReEvaluateStaleSummary{SkippedProblems: []string{}}
+		for _, problem := range problems {
+			models, err := s.problemModels(ctx, problem)
+			if err != nil {
+				log.Println("domains.model.pkg.service.re_evaluate_stale.ReEvaluateStale.problemModels", problem.Title, err)
+				summary.SkippedProblems = append(summary.SkippedProblems, problem.Title)
+				continue
+			}
+			for _, model := range models {
+				if model.Archived {
+					continue
+				}
+				for _, buildId := range staleBuilds(model) {
+					if ctx.Err() != nil || (req.Limit > 0 && summary.Evaluated+summary.Failed >= req.Limit) {
+						summary.Remaining++
+						continue
+					}
+					result := s.reEvaluate(ctx, model, buildId)
+					if result.Error != "" {
+						summary.Failed++
+					} else {
+						summary.Evaluated++
+					}
+					returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 0}, IsLast: false}
+				}
+			}
+		}
+		returnChan <- kitendpoint.Response{Data: summary, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+var errReEvaluateForbidden = errors.New("only admins may re-evaluate every problem")
+
+// reEvaluateProblems is the problem problemId, once checked the caller may
+// edit it, or the problems asking for re-evaluations when it is not set.
+func (s *basicModelService) reEvaluateProblems(ctx context.Context, problemId primitive.ObjectID) ([]t.Problem, error) {
+	if !problemId.IsZero() {
+		if err := access.Check(ctx, s.Conn, problemId, role.Editor); err != nil {
+			return nil, err
+		}
+		problemResp := <-problemFindOne.Send(ctx, s.Conn, problemFindOne.RequestData{Id: problemId})
+		if problemResp.Err.Code > 0 {
+			return nil, errors.New(problemResp.Err.Message)
+		}
+		problem := problemResp.Data.(problemFindOne.ResponseData)
+		if problem.Id.IsZero() {
+			return nil, fmt.Errorf("problem %s not found", problemId.Hex())
+		}
+		return []t.Problem{problem}, nil
+	}
+	if identity, ok := auth.FromContext(ctx); ok && !identity.HasRole(auth.RoleAdmin) {
+		return nil, fmt.Errorf("user %q: %w", identity.User, errReEvaluateForbidden)
+	}
+	problemResp := <-problemFind.Send(ctx, s.Conn, problemFind.RequestData{Page: 1, Size: 0})
+	if problemResp.Err.Code > 0 {
+		return nil, errors.New(problemResp.Err.Message)
+	}
+	var problems []t.Problem
+	for _, problem := range problemResp.Data.(problemFind.ResponseData).Items {
+		if problem.AutoReEvaluate {
+			problems = append(problems, problem)
+		}
+	}
+	return problems, nil
+}
+
+// staleBuilds lists, in order, the builds the finished evaluations of the
+// model on which are stale and not dismissed.
+func staleBuilds(model t.Model) []primitive.ObjectID {
+	var builds []primitive.ObjectID
+	for hex, evaluate := range model.Evaluates {
+		if !evaluate.Stale || evaluate.StaleDismissed || evaluate.Status != statusModelEvaluate.Finished {
+			continue
+		}
+		buildId, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			continue
+		}
+		builds = append(builds, buildId)
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].Hex() < builds[j].Hex() })
+	return builds
+}
+
+// reEvaluate runs the evaluation of the model on the build to its end.
+func (s *basicModelService) reEvaluate(ctx context.Context, model t.Model, buildId primitive.ObjectID) ReEvaluateStaleResult {
+	result := ReEvaluateStaleResult{ModelId: model.Id, BuildId: buildId}
+	for resp := range s.Evaluate(ctx, EvaluateRequest{ModelId: model.Id, BuildId: buildId, ProblemId: model.ProblemId}) {
+		if resp.Err.Code > 0 {
+			result.Error = resp.Err.Message
+		}
+	}
+	return result
+}
+
+// OffPeakWindow is a daily span of time, as "HH:MM-HH:MM" in the local time
+// of the service, which may go past midnight as "22:00-06:00".
+type OffPeakWindow string
+
+func (w OffPeakWindow) Validate() error {
+	if w == "" {
+		return nil
+	}
+	_, _, err := w.parse()
+	return err
+}
+
+func (w OffPeakWindow) parse() (start, end time.Duration, err error) {
+	bounds := strings.Split(string(w), "-")
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("window %q is not HH:MM-HH:MM", w)
+	}
+	var offsets [2]time.Duration
+	for i, bound := range bounds {
+		at, err := time.Parse("15:04", strings.TrimSpace(bound))
+		if err != nil {
+			return 0, 0, fmt.Errorf("window %q is not HH:MM-HH:MM", w)
+		}
+		offsets[i] = time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+	}
+	if offsets[0] == offsets[1] {
+		return 0, 0, fmt.Errorf("window %q is empty", w)
+	}
+	return offsets[0], offsets[1], nil
+}
+
+// End tells whether the window is open at now and, when it is, when it
+// closes. The empty window is never open.
+func (w OffPeakWindow) End(now time.Time) (time.Time, bool) {
+	start, end, err := w.parse()
+	if err != nil {
+		return time.Time{}, false
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	switch {
+	case start < end && offset >= start && offset < end:
+		return midnight.Add(end), true
+	case start > end && offset >= start:
+		return midnight.AddDate(0, 0, 1).Add(end), true
+	case start > end && offset < end:
+		return midnight.Add(end), true
+	}
+	return time.Time{}, false
+}
+
+// RunReEvaluator runs ReEvaluateStale on the problems asking for it every
+// interval while window is open, until stop is closed. A run is cancelled
+// when the window closes, what it did not evaluate waiting for the next one.
+func RunReEvaluator(svc ModelService, window OffPeakWindow, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		end, open := window.End(time.Now())
+		if !open {
+			continue
+		}
+		ctx, cancel := context.WithDeadline(context.Background(), end)
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		for resp := range svc.ReEvaluateStale(ctx, ReEvaluateStaleRequestData{}) {
+			if resp.Err.Code > 0 {
+				log.Println("domains.model.pkg.service.re_evaluate_stale.RunReEvaluator", resp.Err.Message)
+				continue
+			}
+			switch data := resp.Data.(type) {
+			case ReEvaluateStaleResult:
+				log.Println("domains.model.pkg.service.re_evaluate_stale.RunReEvaluator", data.ModelId.Hex(), data.BuildId.Hex(), data.Error)
+			case ReEvaluateStaleSummary:
+				log.Printf("domains.model.pkg.service.re_evaluate_stale.RunReEvaluator: %d evaluated, %d failed, %d remaining, skipped problems %v", data.Evaluated, data.Failed, data.Remaining, data.SkippedProblems)
+			}
+		}
+		cancel()
+	}
+}
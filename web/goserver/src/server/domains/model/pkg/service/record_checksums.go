@@ -0,0 +1,214 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+	yamlNode "gopkg.in/yaml.v3"
+
+	t "server/db/pkg/types"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// recallChecksums gives the downloaded dependencies of the template declared
+// without a size or sha256 those recorded by the previous import of the
+// model, provided they still come from the same source, so the downloads are
+// verified against them. Local files are not recalled, a changed file would
+// be taken for one to keep.
+func recallChecksums(modelYml *ModelYml, model, previous t.Model) {
+	if previous.Id.IsZero() {
+		return
+	}
+	for i, d := range model.Dependencies {
+		if !isValidUrl(modelYml.Dependencies[i].Source) || (d.Sha256 != "" && d.Size != 0) {
+			continue
+		}
+		for _, p := range previous.Dependencies {
+			if p.Destination != d.Destination || p.SourceHash != d.SourceHash {
+				continue
+			}
+			if d.Sha256 == "" {
+				modelYml.Dependencies[i].Sha256, model.Dependencies[i].Sha256 = p.Sha256, p.Sha256
+			}
+			if d.Size == 0 {
+				modelYml.Dependencies[i].Size, model.Dependencies[i].Size = p.Size, p.Size
+			}
+			break
+		}
+	}
+}
+
+// recordChecksum gives the dependency fetched at path the size and sha256 of
+// the file when it has none. Folders are left alone.
+func recordChecksum(d *t.Dependency, path string) {
+	if d.Sha256 != "" && d.Size != 0 {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	if d.Size == 0 {
+		d.Size = int(info.Size())
+	}
+	if d.Sha256 == "" {
+		d.Sha256 = getSha265(path)
+	}
+}
+
+// withChecksums writes the sizes and sha256 of stored into the dependencies
+// of the template document raw lacking them. raw is returned as is when
+// nothing is missing, or else rewritten with the checksums set in place,
+// its keys keeping their order and its comments being kept.
+func withChecksums(raw []byte, stored []t.Dependency) ([]byte, error) {
+	var doc yamlNode.Node
+	if err := yamlNode.Unmarshal(raw, &doc); err != nil {
+		return raw, err
+	}
+	if len(doc.Content) == 0 {
+		return raw, nil
+	}
+	dependencies := yamlValue(doc.Content[0], "dependencies")
+	if dependencies == nil {
+		return raw, nil
+	}
+	if dependencies.Kind != yamlNode.SequenceNode || len(dependencies.Content) != len(stored) {
+		return raw, fmt.Errorf("the dependencies of the template do not match those imported")
+	}
+	changed := false
+	for j, dependency := range dependencies.Content {
+		if dependency.Kind != yamlNode.MappingNode {
+			continue
+		}
+		if stored[j].Sha256 != "" && isYamlZero(dependency, "sha256") {
+			setYamlKey(dependency, "sha256", "!!str", stored[j].Sha256)
+			changed = true
+		}
+		if stored[j].Size != 0 && isYamlZero(dependency, "size") {
+			setYamlKey(dependency, "size", "!!int", strconv.Itoa(stored[j].Size))
+			changed = true
+		}
+	}
+	if !changed {
+		return raw, nil
+	}
+	var b bytes.Buffer
+	encoder := yamlNode.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return raw, err
+	}
+	if err := encoder.Close(); err != nil {
+		return raw, err
+	}
+	rewritten := b.Bytes()
+	// The template is read by yaml.v2, which takes some plain scalars, such
+	// as y or on, for booleans, so the rewrite is checked to say the same as
+	// raw but for the checksums.
+	var before, after ModelYml
+	if err := yaml.Unmarshal(raw, &before); err != nil {
+		return raw, err
+	}
+	if err := yaml.Unmarshal(rewritten, &after); err != nil {
+		return raw, err
+	}
+	if !reflect.DeepEqual(withoutChecksums(before), withoutChecksums(after)) {
+		return raw, fmt.Errorf("the template cannot be rewritten without changing it, quote its values")
+	}
+	return rewritten, nil
+}
+
+func withoutChecksums(modelYml ModelYml) ModelYml {
+	dependencies := make([]t.Dependency, len(modelYml.Dependencies))
+	for i, d := range modelYml.Dependencies {
+		d.Sha256, d.Size = "", 0
+		dependencies[i] = d
+	}
+	modelYml.Dependencies = dependencies
+	return modelYml
+}
+
+// yamlValue is the value of key in the map node m, nil when m is not a map
+// or has no such key.
+func yamlValue(m *yamlNode.Node, key string) *yamlNode.Node {
+	if m.Kind != yamlNode.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func isYamlZero(m *yamlNode.Node, key string) bool {
+	v := yamlValue(m, key)
+	return v == nil || v.ShortTag() == "!!null" || v.Value == "" || (v.ShortTag() == "!!int" && v.Value == "0")
+}
+
+// setYamlKey sets key of the map node m to the scalar value of tag.
+func setYamlKey(m *yamlNode.Node, key, tag, value string) {
+	scalar := yamlNode.Node{Kind: yamlNode.ScalarNode, Tag: tag, Value: value}
+	if v := yamlValue(m, key); v != nil {
+		*v = scalar
+		return
+	}
+	m.Content = append(m.Content, &yamlNode.Node{Kind: yamlNode.ScalarNode, Tag: "!!str", Value: key}, &scalar)
+}
+
+// updateTemplateChecksums writes the checksums recorded for the model name
+// into its document of the template at path, the other documents being kept
+// byte for byte. It tells whether the template changed. The template roots
+// are only read otherwise, asking for UpdateTemplate allows the write.
+func (s *basicModelService) updateTemplateChecksums(ctx context.Context, path, name string, stored []t.Dependency) (bool, error) {
+	if err := s.paths.CheckTemplate(ctx, path); err != nil {
+		return false, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	var out bytes.Buffer
+	changed := false
+	start := 0
+	separators := append(yamlDocumentSeparator.FindAllIndex(content, -1), []int{len(content), len(content)})
+	for _, separator := range separators {
+		part := content[start:separator[0]]
+		var modelYml ModelYml
+		if !changed && len(bytes.TrimSpace(part)) > 0 && yaml.Unmarshal(part, &modelYml) == nil && modelYml.Name == name {
+			trimmed := bytes.TrimLeft(part, "\n")
+			if hasIncludes(trimmed) {
+				return false, fmt.Errorf("the document of %q includes fragments, write the checksums into them", name)
+			}
+			rewritten, err := withChecksums(trimmed, stored)
+			if err != nil {
+				return false, err
+			}
+			if !bytes.Equal(rewritten, trimmed) {
+				part = append(part[:len(part)-len(trimmed):len(part)-len(trimmed)], rewritten...)
+				changed = true
+			}
+		}
+		out.Write(part)
+		out.Write(content[separator[0]:separator[1]])
+		start = separator[1]
+	}
+	if !changed {
+		return false, nil
+	}
+	if err := uFiles.WriteFileAtomicContext(ctx, path, out.Bytes(), info.Mode().Perm()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	fp "path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	buildFindOne "server/db/pkg/handler/build/find_one"
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+)
+
+// defaultBuildFolder is the folder of the default build in the model folders,
+// the metrics of the template being written there on import.
+const defaultBuildFolder = "_default"
+
+// RegenerateMetricsFileRequestData names the model and the build whose
+// metrics file is written, the default build of the problem when BuildId is
+// not set.
+type RegenerateMetricsFileRequestData struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+	BuildId primitive.ObjectID `json:"buildId"`
+}
+
+type RegenerateMetricsFileResponseData struct {
+	Path    string `json:"path"`
+	Metrics int    `json:"metrics"`
+}
+
+// RegenerateMetricsFile writes the metrics stored with the evaluation of a
+// model on a build back to the metrics file of the build folder, for when it
+// was lost.
+func (s *basicModelService) RegenerateMetricsFile(ctx context.Context, req RegenerateMetricsFileRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		unlock, err := s.lockModel(ctx, req.ModelId, "metrics file")
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: dirLockErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		defer unlock()
+		if err := s.statuses.flush(ctx, req.ModelId); err != nil {
+			log.Println("domains.model.pkg.service.regenerate_metrics_file.RegenerateMetricsFile.s.statuses.flush", err)
+		}
+		modelResp := <-modelFindOne.Send(ctx, s.Conn, modelFindOne.RequestData{Id: req.ModelId})
+		model := s.localModel(modelResp.Data.(modelFindOne.ResponseData))
+		if model.Id.IsZero() {
+			err := fmt.Errorf("model %s not found", req.ModelId.Hex())
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if err := access.Check(ctx, s.Conn, model.ProblemId, role.Editor); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		buildReq := buildFindOne.RequestData{Id: req.BuildId}
+		if req.BuildId.IsZero() {
+			buildReq = buildFindOne.RequestData{ProblemId: model.ProblemId, Name: "default"}
+		}
+		buildResp := <-buildFindOne.Send(ctx, s.Conn, buildReq)
+		build := buildResp.Data.(buildFindOne.ResponseData)
+		if build.Id.IsZero() || build.ProblemId != model.ProblemId {
+			err := fmt.Errorf("build %s of the problem of model %s not found", req.BuildId.Hex(), model.Name)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		evaluate, ok := model.Evaluates[build.Id.Hex()]
+		if !ok {
+			err := fmt.Errorf("model %s has no metrics for build %s", model.Name, build.Name)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		folder := build.Folder
+		if folder == "" {
+			folder = defaultBuildFolder
+		}
+		dir := fp.Join(model.Dir, folder)
+		path := fp.Join(dir, metricsFileName)
+		if err := s.paths.CheckWrite(ctx, path); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: err.Error()}, IsLast: true}
+			return
+		}
+		err = os.MkdirAll(dir, 0777)
+		if err == nil {
+			err = s.writeMetrics(ctx, dir, evaluate.Metrics)
+		}
+		if err != nil {
+			log.Println("domains.model.pkg.service.regenerate_metrics_file.RegenerateMetricsFile.writeMetrics", err)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		s.publish(ctx, model.Dir, nil)
+		returnChan <- kitendpoint.Response{Data: RegenerateMetricsFileResponseData{Path: path, Metrics: len(evaluate.Metrics)}, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
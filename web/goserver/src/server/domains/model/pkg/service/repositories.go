@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	buildFindOne "server/db/pkg/handler/build/find_one"
+	buildInsertOne "server/db/pkg/handler/build/insert_one"
+	lockAcquire "server/db/pkg/handler/lock/acquire"
+	lockRefresh "server/db/pkg/handler/lock/refresh"
+	lockRelease "server/db/pkg/handler/lock/release"
+	modelBulkUpdate "server/db/pkg/handler/model/bulk_update"
+	modelDelete "server/db/pkg/handler/model/delete"
+	modelFind "server/db/pkg/handler/model/find"
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	modelInsertOne "server/db/pkg/handler/model/insert_one"
+	modelUpdateFields "server/db/pkg/handler/model/update_fields"
+	modelUpdateOne "server/db/pkg/handler/model/update_one"
+	modelUpdateUpsert "server/db/pkg/handler/model/update_upsert"
+	problemFind "server/db/pkg/handler/problem/find"
+	problemFindOne "server/db/pkg/handler/problem/find_one"
+	snapshotSetRefs "server/db/pkg/handler/snapshot/set_refs"
+	t "server/db/pkg/types"
+	"server/domains/problem/pkg/quota"
+	kitendpoint "server/kit/endpoint"
+)
+
+// ProblemFinder finds a problem by its id or its title, and lists the
+// problems. A problem that does not exist is the zero problem, not an error.
+type ProblemFinder interface {
+	FindProblem(ctx context.Context, req problemFindOne.RequestData) (t.Problem, error)
+	FindProblems(ctx context.Context, req problemFind.RequestData) (t.ProblemFindResponse, error)
+}
+
+// BuildRepo finds and inserts the builds of the problems. A build that does
+// not exist is the zero build, not an error.
+type BuildRepo interface {
+	FindBuild(ctx context.Context, req buildFindOne.RequestData) (t.Build, error)
+	InsertBuild(ctx context.Context, req buildInsertOne.RequestData) (t.Build, error)
+}
+
+// ModelRepo finds, saves and deletes the models. A model that does not
+// exist is the zero model, not an error. InsertModel fails for a model named
+// as another of its problem and workspace. UpdateModel saves a model found
+// before, by its id, over the whole of it. UpdateModelFields sets only the
+// fields given, the status and the evaluations of the model being left to
+// the status writes. BulkUpdateModels patches at once the models a filter
+// finds.
+type ModelRepo interface {
+	FindModel(ctx context.Context, req modelFindOne.RequestData) (t.Model, error)
+	FindModels(ctx context.Context, req modelFind.RequestData) (t.ModelFindResponse, error)
+	InsertModel(ctx context.Context, req modelInsertOne.RequestData) (t.Model, error)
+	UpsertModel(ctx context.Context, req modelUpdateUpsert.RequestData) (t.Model, error)
+	UpdateModel(ctx context.Context, req modelUpdateOne.RequestData) (t.Model, error)
+	UpdateModelFields(ctx context.Context, req modelUpdateFields.RequestData) (t.Model, error)
+	DeleteModel(ctx context.Context, req modelDelete.RequestData) (modelDelete.ResponseData, error)
+	BulkUpdateModels(ctx context.Context, req modelBulkUpdate.RequestData) (modelBulkUpdate.ResponseData, error)
+}
+
+// LockRepo takes, keeps alive and frees the locks the replicas share. A
+// lock held by another owner is not an error, it is returned not acquired.
+type LockRepo interface {
+	AcquireLock(ctx context.Context, req lockAcquire.RequestData) (lockAcquire.ResponseData, error)
+	RefreshLock(ctx context.Context, req lockRefresh.RequestData) (lockRefresh.ResponseData, error)
+	ReleaseLock(ctx context.Context, req lockRelease.RequestData) (lockRelease.ResponseData, error)
+}
+
+// QuotaRepo checks the bytes an import adds to a problem against its quota,
+// failing with a *quota.Error when they do not fit, and counts the bytes the
+// import took in the end.
+type QuotaRepo interface {
+	CheckQuota(ctx context.Context, problemId primitive.ObjectID, incoming int64) error
+	ReportUsage(ctx context.Context, problemId primitive.ObjectID, delta int64)
+}
+
+// SnapshotRepo keeps the snapshot files each model references.
+type SnapshotRepo interface {
+	SetSnapshotRefs(ctx context.Context, req snapshotSetRefs.RequestData) (snapshotSetRefs.ResponseData, error)
+}
+
+// Repositories are the stores of the problems, builds and models the
+// imports, the clones and the deletions use, of the quotas and snapshots
+// of the problems and of the locks of the models and their folders. The
+// ones not set are those of the database service, over conn.
+type Repositories struct {
+	Problems  ProblemFinder
+	Builds    BuildRepo
+	Models    ModelRepo
+	Locks     LockRepo
+	Quotas    QuotaRepo
+	Snapshots SnapshotRepo
+}
+
+func (r Repositories) withDefaults(conn *rabbitmq.Connection) Repositories {
+	if r.Problems == nil {
+		r.Problems = busRepositories{conn}
+	}
+	if r.Builds == nil {
+		r.Builds = busRepositories{conn}
+	}
+	if r.Models == nil {
+		r.Models = busRepositories{conn}
+	}
+	if r.Locks == nil {
+		r.Locks = busRepositories{conn}
+	}
+	if r.Quotas == nil {
+		r.Quotas = busRepositories{conn}
+	}
+	if r.Snapshots == nil {
+		r.Snapshots = busRepositories{conn}
+	}
+	return r
+}
+
+// busRepositories sends the requests to the database service.
+type busRepositories struct {
+	conn *rabbitmq.Connection
+}
+
+func (b busRepositories) FindProblem(ctx context.Context, req problemFindOne.RequestData) (t.Problem, error) {
+	resp := <-problemFindOne.Send(ctx, b.conn, req)
+	problem, _ := resp.Data.(problemFindOne.ResponseData)
+	return problem, responseErr(resp)
+}
+
+func (b busRepositories) FindProblems(ctx context.Context, req problemFind.RequestData) (t.ProblemFindResponse, error) {
+	resp := <-problemFind.Send(ctx, b.conn, req)
+	problems, _ := resp.Data.(problemFind.ResponseData)
+	return problems, responseErr(resp)
+}
+
+func (b busRepositories) FindBuild(ctx context.Context, req buildFindOne.RequestData) (t.Build, error) {
+	resp := <-buildFindOne.Send(ctx, b.conn, req)
+	build, _ := resp.Data.(buildFindOne.ResponseData)
+	return build, responseErr(resp)
+}
+
+func (b busRepositories) InsertBuild(ctx context.Context, req buildInsertOne.RequestData) (t.Build, error) {
+	resp := <-buildInsertOne.Send(ctx, b.conn, req)
+	build, _ := resp.Data.(buildInsertOne.ResponseData)
+	if err := responseErr(resp); err != nil {
+		return build, err
+	}
+	if build.Id.IsZero() {
+		return build, fmt.Errorf("build %q of problem %s was not saved", req.Name, req.ProblemId.Hex())
+	}
+	return build, nil
+}
+
+func (b busRepositories) FindModel(ctx context.Context, req modelFindOne.RequestData) (t.Model, error) {
+	resp := <-modelFindOne.Send(ctx, b.conn, req)
+	model, _ := resp.Data.(modelFindOne.ResponseData)
+	return model, responseErr(resp)
+}
+
+func (b busRepositories) FindModels(ctx context.Context, req modelFind.RequestData) (t.ModelFindResponse, error) {
+	resp := <-modelFind.Send(ctx, b.conn, req)
+	models, _ := resp.Data.(modelFind.ResponseData)
+	return models, responseErr(resp)
+}
+
+func (b busRepositories) InsertModel(ctx context.Context, req modelInsertOne.RequestData) (t.Model, error) {
+	resp := <-modelInsertOne.Send(ctx, b.conn, req)
+	model, _ := resp.Data.(modelInsertOne.ResponseData)
+	return model, responseErr(resp)
+}
+
+func (b busRepositories) UpsertModel(ctx context.Context, req modelUpdateUpsert.RequestData) (t.Model, error) {
+	resp := <-modelUpdateUpsert.Send(ctx, b.conn, req)
+	model, _ := resp.Data.(modelUpdateUpsert.ResponseData)
+	if err := responseErr(resp); err != nil {
+		return model, err
+	}
+	if model.Id.IsZero() {
+		return model, fmt.Errorf("model %q was not saved", req.Name)
+	}
+	return model, nil
+}
+
+func (b busRepositories) UpdateModel(ctx context.Context, req modelUpdateOne.RequestData) (t.Model, error) {
+	resp := <-modelUpdateOne.Send(ctx, b.conn, req)
+	model, _ := resp.Data.(modelUpdateOne.ResponseData)
+	if err := responseErr(resp); err != nil {
+		return model, err
+	}
+	if model.Id.IsZero() {
+		return model, fmt.Errorf("model %q was not saved", req.Name)
+	}
+	return model, nil
+}
+
+func (b busRepositories) UpdateModelFields(ctx context.Context, req modelUpdateFields.RequestData) (t.Model, error) {
+	resp := <-modelUpdateFields.Send(ctx, b.conn, req)
+	model, _ := resp.Data.(modelUpdateFields.ResponseData)
+	return model, responseErr(resp)
+}
+
+func (b busRepositories) DeleteModel(ctx context.Context, req modelDelete.RequestData) (modelDelete.ResponseData, error) {
+	resp := <-modelDelete.Send(ctx, b.conn, req)
+	deleted, _ := resp.Data.(modelDelete.ResponseData)
+	return deleted, responseErr(resp)
+}
+
+func (b busRepositories) BulkUpdateModels(ctx context.Context, req modelBulkUpdate.RequestData) (modelBulkUpdate.ResponseData, error) {
+	resp := <-modelBulkUpdate.Send(ctx, b.conn, req)
+	updated, _ := resp.Data.(modelBulkUpdate.ResponseData)
+	return updated, responseErr(resp)
+}
+
+func (b busRepositories) AcquireLock(ctx context.Context, req lockAcquire.RequestData) (lockAcquire.ResponseData, error) {
+	resp := <-lockAcquire.Send(ctx, b.conn, req)
+	acquired, _ := resp.Data.(lockAcquire.ResponseData)
+	return acquired, responseErr(resp)
+}
+
+func (b busRepositories) RefreshLock(ctx context.Context, req lockRefresh.RequestData) (lockRefresh.ResponseData, error) {
+	resp := <-lockRefresh.Send(ctx, b.conn, req)
+	refreshed, _ := resp.Data.(lockRefresh.ResponseData)
+	return refreshed, responseErr(resp)
+}
+
+func (b busRepositories) ReleaseLock(ctx context.Context, req lockRelease.RequestData) (lockRelease.ResponseData, error) {
+	resp := <-lockRelease.Send(ctx, b.conn, req)
+	released, _ := resp.Data.(lockRelease.ResponseData)
+	return released, responseErr(resp)
+}
+
+func (b busRepositories) CheckQuota(ctx context.Context, problemId primitive.ObjectID, incoming int64) error {
+	return quota.Check(ctx, b.conn, problemId, incoming)
+}
+
+func (b busRepositories) ReportUsage(ctx context.Context, problemId primitive.ObjectID, delta int64) {
+	quota.Report(ctx, b.conn, problemId, delta)
+}
+
+func (b busRepositories) SetSnapshotRefs(ctx context.Context, req snapshotSetRefs.RequestData) (snapshotSetRefs.ResponseData, error) {
+	resp := <-snapshotSetRefs.Send(ctx, b.conn, req)
+	refs, _ := resp.Data.(snapshotSetRefs.ResponseData)
+	return refs, responseErr(resp)
+}
+
+// responseErr is the error of a response of the database service, nil when
+// it succeeded.
+func responseErr(resp kitendpoint.Response) error {
+	if resp.Err.Code > 0 {
+		return errors.New(resp.Err.Message)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	operationClaim "server/db/pkg/handler/operation/claim"
+	operationInterrupt "server/db/pkg/handler/operation/interrupt"
+	operationUpdateOne "server/db/pkg/handler/operation/update_one"
+	t "server/db/pkg/types"
+	statusOperation "server/db/pkg/types/status/operation"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/notify"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// recoverTimeout bounds the wait for the database when the interrupted
+// operations are looked for.
+const recoverTimeout = time.Minute
+
+// ResumeOperationRequestData resumes the interrupted operation Id.
+type ResumeOperationRequestData struct {
+	Id primitive.ObjectID `json:"id"`
+}
+
+// ResumeOperation goes on with an interrupted or stalled directory import in
+// the same operation, which streams its responses again. The templates it imported
+// are not imported again unless they changed, those it was importing when
+// it was interrupted are imported again, the models already imported being
+// kept as the overwrite option of the import says. Only admins may resume
+// an operation when the request carries an identity.
+func (s *basicModelService) ResumeOperation(ctx context.Context, req ResumeOperationRequestData) chan kitendpoint.Response {
+	responseChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(responseChan)
+		if identity, ok := auth.FromContext(ctx); ok && !identity.HasRole(auth.RoleAdmin) {
+			err := fmt.Errorf("user %q is not allowed to resume operations", identity.User)
+			responseChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeForbidden, Message: err.Error()}, IsLast: true}
+			return
+		}
+		stored, err := s.getStoredOperation(ctx, req.Id)
+		if err != nil {
+			responseChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		resumable := stored.Status == statusOperation.Interrupted || stored.Status == statusOperation.Stalled
+		if !resumable || len(stored.Request) == 0 {
+			err := fmt.Errorf("operation %s is %s, only interrupted or stalled directory imports are resumed", stored.Id.Hex(), stored.Status)
+			responseChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		var importReq ImportDirectoryRequestData
+		if err := json.Unmarshal(stored.Request, &importReq); err != nil {
+			responseChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		// The notifications may have been configured otherwise since, the
+		// import is resumed without them rather than not at all.
+		subscription, err := s.notifier.Subscription("", notify.Subscription{
+			On:              importReq.NotifyOn,
+			Email:           importReq.NotifyEmail,
+			SlackWebhookUrl: importReq.NotifySlackWebhookUrl,
+		})
+		if err != nil {
+			log.Println("domains.model.pkg.service.resume_operation.ResumeOperation.s.notifier.Subscription", err)
+		}
+		claimResp := <-operationClaim.Send(ctx, s.Conn, operationClaim.RequestData{Id: stored.Id, From: stored.Status, To: statusOperation.Running})
+		claimed, _ := claimResp.Data.(operationClaim.ResponseData)
+		if claimResp.Err.Code > 0 || claimed.Id.IsZero() {
+			err := fmt.Errorf("operation %s is already resumed", stored.Id.Hex())
+			responseChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeBusy, Message: err.Error()}, IsLast: true}
+			return
+		}
+		ctx, temps := uFiles.WithTemps(ctx)
+		defer temps.RemoveAll()
+		ctx, held := withHeldSnapshots(ctx, s.snapshotLeases)
+		defer held.releaseAll()
+		ctx = withImportCache(ctx)
+		ctx, op := s.resumeOperation(ctx, claimed, subscription, responseChan)
+		op.onCancel(temps.RemoveAll)
+		op.finish(ctx, s.importDirectory(ctx, importReq, op), nil)
+	}()
+	return responseChan
+}
+
+// resumeOperation takes over the claimed operation stored, its first
+// response carrying it and its context being cancellable as those of
+// startOperation.
+func (s *basicModelService) resumeOperation(ctx context.Context, stored t.Operation, subscription notify.Subscription, responseChan chan kitendpoint.Response) (context.Context, *operation) {
+	op := &operation{conn: s.Conn, responseChan: responseChan, notifier: s.notifier, subscription: subscription, Operation: stored}
+	ctx = s.operations.add(ctx, op)
+	op.mu.Lock()
+	op.Resumed++
+	op.Error = ""
+	op.HeartbeatAt = time.Now()
+	op.Owner = lockOwnerPrefix
+	op.save(ctx)
+	sent := op.Operation
+	op.mu.Unlock()
+	sent.Request = nil
+	responseChan <- kitendpoint.Response{Data: sent, Err: kitendpoint.Error{Code: 0}, IsLast: false, OperationId: op.id()}
+	return ctx, op
+}
+
+// RecoverOperations marks interrupted the operations the previous run of the
+// service left pending or running. The directory imports among them are
+// resumed one after the other when resume is set, and wait for
+// ResumeOperation otherwise. The other operations fail, nothing can resume
+// them. It must run before the service takes requests, whose operations
+// would be taken for interrupted ones.
+func RecoverOperations(conn *rabbitmq.Connection, svc ModelService, resume bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), recoverTimeout)
+	defer cancel()
+	resp := <-operationInterrupt.Send(ctx, conn, operationInterrupt.RequestData{})
+	if resp.Err.Code > 0 {
+		log.Println("domains.model.pkg.service.resume_operation.RecoverOperations.operationInterrupt.Send", resp.Err.Message)
+		return
+	}
+	var resumable []primitive.ObjectID
+	for _, op := range resp.Data.(operationInterrupt.ResponseData).Items {
+		if len(op.Request) > 0 {
+			log.Printf("domains.model.pkg.service.resume_operation.RecoverOperations: operation %s %s interrupted", op.Kind, op.Id.Hex())
+			resumable = append(resumable, op.Id)
+			continue
+		}
+		op.Status = statusOperation.Failed
+		op.Error = "interrupted by a restart of the model service"
+		<-operationUpdateOne.Send(ctx, conn, op)
+	}
+	if !resume || len(resumable) == 0 {
+		return
+	}
+	go func() {
+		for _, id := range resumable {
+			for resp := range svc.ResumeOperation(context.Background(), ResumeOperationRequestData{Id: id}) {
+				if !resp.IsLast {
+					continue
+				}
+				if resp.Err.Code > 0 {
+					log.Println("domains.model.pkg.service.resume_operation.RecoverOperations", id.Hex(), resp.Err.Message)
+				} else {
+					log.Println("domains.model.pkg.service.resume_operation.RecoverOperations: operation resumed", id.Hex())
+				}
+			}
+		}
+	}()
+}
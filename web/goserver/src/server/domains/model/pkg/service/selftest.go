@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	fp "path/filepath"
+	"time"
+
+	kitendpoint "server/kit/endpoint"
+	u "server/kit/utils"
+)
+
+const (
+	selfTestDefaultSize = 16 << 20
+	selfTestMaxSize     = 1 << 30
+)
+
+// SelfTestRequestData sizes the scratch file pushed through the local copy
+// path. Url is downloaded once when set, and checked against Sha256 and
+// ExpectedSize when those are given.
+type SelfTestRequestData struct {
+	Size         int64  `json:"size"`
+	Url          string `json:"url"`
+	Sha256       string `json:"sha256"`
+	ExpectedSize int64  `json:"expectedSize"`
+}
+
+type SelfTestStage struct {
+	Name       string `json:"name"`
+	Passed     bool   `json:"passed"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+type SelfTestResponseData struct {
+	Passed bool            `json:"passed"`
+	Stages []SelfTestStage `json:"stages"`
+}
+
+func (r *SelfTestResponseData) run(name string, f func() error) bool {
+	start := time.Now()
+	err := f()
+	stage := SelfTestStage{Name: name, Passed: err == nil, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		log.Println("selftest.SelfTest."+name, err)
+		stage.Error = err.Error()
+		r.Passed = false
+	}
+	r.Stages = append(r.Stages, stage)
+	return err == nil
+}
+
+// SelfTest exercises the disk and the network the way an import does, so a
+// failing downloadWithCheck can be blamed on the storage or on the mirror.
+// The scratch files live in the problems folder and are removed afterwards.
+func (s *basicModelService) SelfTest(ctx context.Context, req SelfTestRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		size := req.Size
+		if size <= 0 {
+			size = selfTestDefaultSize
+		}
+		if size > selfTestMaxSize {
+			err := fmt.Errorf("size %d exceeds the limit of %d bytes", size, int64(selfTestMaxSize))
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		result := SelfTestResponseData{Passed: true, Stages: []SelfTestStage{}}
+		s.selfTest(size, req, &result)
+		returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicModelService) selfTest(size int64, req SelfTestRequestData, result *SelfTestResponseData) {
+	var dir, src, dst, sha string
+	defer func() {
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+	}()
+	ok := result.run("generate", func() (err error) {
+		if err := os.MkdirAll(s.problemPath, 0777); err != nil {
+			return err
+		}
+		if dir, err = ioutil.TempDir(s.problemPath, ".self_test"); err != nil {
+			return err
+		}
+		src = fp.Join(dir, "source.bin")
+		return writeRandomFile(src, size)
+	})
+	ok = ok && result.run("hash", func() error {
+		if sha = getSha265(src); sha == "" {
+			return errors.New("can not hash the source file")
+		}
+		return nil
+	})
+	ok = ok && result.run("copy", func() error {
+		dst = fp.Join(dir, "copy", "source.bin")
+		return copyFiles(src, dst)
+	})
+	if ok {
+		result.run("verify", func() error {
+			return checkFile(dst, sha, size)
+		})
+	}
+	if req.Url != "" {
+		result.run("download", func() error {
+			if dir == "" {
+				return errors.New("no scratch folder")
+			}
+			path := fp.Join(dir, "download.bin")
+			if _, err := u.DownloadFile(req.Url, path); err != nil {
+				return err
+			}
+			if req.Sha256 == "" && req.ExpectedSize <= 0 {
+				return nil
+			}
+			return checkFile(path, req.Sha256, req.ExpectedSize)
+		})
+	}
+}
+
+func writeRandomFile(path string, size int64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, rand.Reader, size); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	modelUpdateFields "server/db/pkg/handler/model/update_fields"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+)
+
+const maxTagLength = 64
+
+var tagRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.:/-]+$`)
+
+type SetModelTagsRequestData struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+	Tags    []string           `json:"tags"`
+}
+
+func (s *basicModelService) SetModelTags(ctx context.Context, req SetModelTagsRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		tags, err := normalizeTags(req.Tags)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		unlock, err := s.lockModel(ctx, req.ModelId, "tags")
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: dirLockErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		defer unlock()
+		if err := s.statuses.flush(ctx, req.ModelId); err != nil {
+			log.Println("domains.model.pkg.service.set_model_tags.SetModelTags.s.statuses.flush", err)
+		}
+		model, err := s.repos.Models.FindModel(ctx, modelFindOne.RequestData{Id: req.ModelId})
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		model = s.localModel(model)
+		if model.Id.IsZero() {
+			err := fmt.Errorf("model %s not found", req.ModelId.Hex())
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if err := access.Check(ctx, s.Conn, model.ProblemId, role.Editor); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		// Only the tags are set, a status saved meanwhile by a training or
+		// an evaluation, which do not take the lock of the model, is kept.
+		saved, err := s.repos.Models.UpdateModelFields(ctx, modelUpdateFields.RequestData{Id: model.Id, Tags: &tags})
+		if err != nil {
+			log.Println("domains.model.pkg.service.set_model_tags.SetModelTags.s.repos.Models.UpdateModelFields", err)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: s.localModel(saved), Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// normalizeTags trims and dedupes tags keeping their order.
+func normalizeTags(tags []string) ([]string, error) {
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		if !tagRegexp.MatchString(tag) {
+			return nil, fmt.Errorf("tag %q contains characters other than letters, digits and _.:/-", tag)
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result, nil
+}
@@ -0,0 +1,103 @@
+package service_test
+
+import (
+	"context"
+	fp "path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	modelUpdateOne "server/db/pkg/handler/model/update_one"
+	t "server/db/pkg/types"
+	statusModelTrain "server/db/pkg/types/status/model/train"
+	"server/domains/model/pkg/service"
+	"server/domains/model/pkg/service/memory"
+	kitendpoint "server/kit/endpoint"
+)
+
+func TestInvalidTags(test *testing.T) {
+	f := newMemoryFixture(test)
+	model := f.importDetector(test)
+	invalid := []string{"ok", "not a tag!"}
+
+	resp := <-f.service.SetModelTags(context.Background(), service.SetModelTagsRequestData{ModelId: model.Id, Tags: invalid})
+	if resp.Err.Code != kitendpoint.ErrCodeInvalidArgument {
+		test.Errorf("set: code %d (%s), want invalid argument", resp.Err.Code, resp.Err.Message)
+	}
+	resp = service.ImportTemplate(context.Background(), f.service, service.UpdateFromLocalRequestData{
+		Path: fp.Join(f.root, "templates", "detector", "template.yaml"),
+		Tags: invalid,
+	})
+	if resp.Err.Code != kitendpoint.ErrCodeInvalidArgument {
+		test.Errorf("import: code %d (%s), want invalid argument", resp.Err.Code, resp.Err.Message)
+	}
+	if models := f.store.Models(); len(models) != 1 || len(models[0].Tags) != 0 {
+		test.Errorf("stored models %+v", models)
+	}
+}
+
+func TestSetTagsOfAMissingModel(test *testing.T) {
+	f := newMemoryFixture(test)
+	id := primitive.NewObjectID()
+	resp := <-f.service.SetModelTags(context.Background(), service.SetModelTagsRequestData{ModelId: id, Tags: []string{"new"}})
+	if resp.Err.Code != kitendpoint.ErrCodeNotFound {
+		test.Errorf("code %d (%s), want not found", resp.Err.Code, resp.Err.Message)
+	}
+}
+
+// finishingTrainings saves the training of a model finished right after the
+// model is read, as a training ending meanwhile does without the lock of
+// the model.
+type finishingTrainings struct {
+	*memory.Store
+}
+
+func (f finishingTrainings) FindModel(ctx context.Context, req modelFindOne.RequestData) (t.Model, error) {
+	model, err := f.Store.FindModel(ctx, req)
+	if err != nil || model.Id.IsZero() {
+		return model, err
+	}
+	finished := model
+	finished.Status = statusModelTrain.Finished
+	if _, err := f.Store.UpdateModel(ctx, modelUpdateOne.RequestData(finished)); err != nil {
+		return model, err
+	}
+	return model, nil
+}
+
+func TestModelChangesKeepTheStatusSavedMeanwhile(test *testing.T) {
+	changes := []struct {
+		name   string
+		change func(f memoryFixture, model t.Model) kitendpoint.Response
+	}{
+		{"tags", func(f memoryFixture, model t.Model) kitendpoint.Response {
+			return <-f.service.SetModelTags(context.Background(), service.SetModelTagsRequestData{ModelId: model.Id, Tags: []string{"new"}})
+		}},
+		{"archiving", func(f memoryFixture, model t.Model) kitendpoint.Response {
+			return <-f.service.ArchiveModel(context.Background(), service.ArchiveModelRequestData{ModelId: model.Id})
+		}},
+		{"dependency", func(f memoryFixture, model t.Model) kitendpoint.Response {
+			source := fp.Join(f.root, "new.bin")
+			writeFile(test, source, "new")
+			return f.updateDependency(model, source)
+		}},
+	}
+	for _, c := range changes {
+		c := c
+		test.Run(c.name, func(test *testing.T) {
+			f := newMemoryFixture(test)
+			model := f.addDependencyModel(test)
+			repos := f.store.Repositories()
+			repos.Models = finishingTrainings{f.store}
+			f.serve(repos)
+
+			if resp := c.change(f, model); resp.Err.Code != kitendpoint.ErrCodeOk {
+				test.Fatalf("code %d (%s)", resp.Err.Code, resp.Err.Message)
+			}
+			if status := f.store.Models()[0].Status; status != statusModelTrain.Finished {
+				test.Errorf("status %q, the finished training was saved over", status)
+			}
+		})
+	}
+}
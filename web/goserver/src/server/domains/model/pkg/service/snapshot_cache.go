@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	fp "path/filepath"
+	"sync"
+	"sync/atomic"
+
+	snapshotFind "server/db/pkg/handler/snapshot/find"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/redact"
+)
+
+// snapshotLeases counts, by sha256, the imports using a stored snapshot, the
+// collections leave the leased snapshots alone.
+type snapshotLeases struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newSnapshotLeases() *snapshotLeases {
+	return &snapshotLeases{counts: make(map[string]int)}
+}
+
+func (l *snapshotLeases) acquire(sha256 string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.counts[sha256]++
+}
+
+func (l *snapshotLeases) release(sha256 string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts[sha256]--; l.counts[sha256] <= 0 {
+		delete(l.counts, sha256)
+	}
+}
+
+func (l *snapshotLeases) held(sha256 string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.counts[sha256] > 0
+}
+
+func (l *snapshotLeases) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.counts)
+}
+
+type heldSnapshotsKey struct{}
+
+// heldSnapshots are the snapshots leased by the import of a model, until it
+// registered its references.
+type heldSnapshots struct {
+	mu      sync.Mutex
+	leases  *snapshotLeases
+	sha256s []string
+}
+
+// withHeldSnapshots returns a context the snapshots an import links from the
+// store are leased in. The caller releases them with releaseAll once the
+// import is over.
+func withHeldSnapshots(ctx context.Context, leases *snapshotLeases) (context.Context, *heldSnapshots) {
+	held := &heldSnapshots{leases: leases}
+	return context.WithValue(ctx, heldSnapshotsKey{}, held), held
+}
+
+func (held *heldSnapshots) releaseAll() {
+	held.mu.Lock()
+	defer held.mu.Unlock()
+	for _, sha256 := range held.sha256s {
+		held.leases.release(sha256)
+	}
+	held.sha256s = nil
+}
+
+// linkCached places at dst a hardlink of the stored snapshot with the given
+// sha256 and size, and tells whether it did, the download being spared. The
+// snapshot is leased for the rest of the import of ctx, or only while it is
+// linked outside of an import. A model file keeps its content once linked,
+// even when the store loses the snapshot.
+func (s *basicModelService) linkCached(ctx context.Context, sha256 string, size int64, dst string) bool {
+	if !sha256Regexp.MatchString(sha256) {
+		return false
+	}
+	s.snapshotLeases.acquire(sha256)
+	if held, ok := ctx.Value(heldSnapshotsKey{}).(*heldSnapshots); ok {
+		held.mu.Lock()
+		held.sha256s = append(held.sha256s, sha256)
+		held.mu.Unlock()
+	} else {
+		defer s.snapshotLeases.release(sha256)
+	}
+	blob := s.snapshotBlob(sha256)
+	if err := s.linkBlob(ctx, blob, sha256, size, dst); err != nil {
+		if !os.IsNotExist(err) {
+			redact.Println("snapshot_cache.linkCached", blob, err)
+		}
+		atomic.AddInt64(&s.cacheMisses, 1)
+		snapshotCacheLookups.Inc("miss")
+		return false
+	}
+	atomic.AddInt64(&s.cacheHits, 1)
+	snapshotCacheLookups.Inc("hit")
+	return true
+}
+
+// linkBlob links blob at dst once checked it still has the content of the
+// snapshot, a file of a model being editable in place.
+func (s *basicModelService) linkBlob(ctx context.Context, blob, sha256 string, size int64, dst string) error {
+	if err := s.paths.CheckRead(ctx, blob); err != nil {
+		return err
+	}
+	if _, err := os.Stat(blob); err != nil {
+		return err
+	}
+	if err := checkFile(blob, sha256, size); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(fp.Dir(dst), 0777); err != nil {
+		return err
+	}
+	tmp := fp.Join(fp.Dir(dst), "."+fp.Base(dst)+".tmp")
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(blob, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+type CacheStatsRequestData struct{}
+
+// CacheStats describes the snapshot store, which the downloads are served
+// from. Unreferenced snapshots are those no model refers to, Leased those
+// imports use before referring to them. Hits and Misses are the lookups of
+// the downloads since the service started.
+type CacheStats struct {
+	Entries             int     `json:"entries"`
+	Bytes               int64   `json:"bytes"`
+	UnreferencedEntries int     `json:"unreferencedEntries"`
+	UnreferencedBytes   int64   `json:"unreferencedBytes"`
+	DedupSavedBytes     int64   `json:"dedupSavedBytes"`
+	Leased              int     `json:"leased"`
+	Hits                int64   `json:"hits"`
+	Misses              int64   `json:"misses"`
+	HitRate             float64 `json:"hitRate"`
+}
+
+func (s *basicModelService) CacheStats(ctx context.Context, req CacheStatsRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		resp := <-snapshotFind.Send(ctx, s.Conn, snapshotFind.RequestData{})
+		if resp.Err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: resp.Err.Message}, IsLast: true}
+			return
+		}
+		stats := CacheStats{
+			Leased: s.snapshotLeases.len(),
+			Hits:   atomic.LoadInt64(&s.cacheHits),
+			Misses: atomic.LoadInt64(&s.cacheMisses),
+		}
+		for _, snapshot := range resp.Data.(snapshotFind.ResponseData).Items {
+			stats.Entries++
+			stats.Bytes += snapshot.Size
+			if len(snapshot.Refs) == 0 {
+				stats.UnreferencedEntries++
+				stats.UnreferencedBytes += snapshot.Size
+			} else {
+				stats.DedupSavedBytes += snapshot.Size * int64(len(snapshot.Refs)-1)
+			}
+		}
+		if lookups := stats.Hits + stats.Misses; lookups > 0 {
+			stats.HitRate = float64(stats.Hits) / float64(lookups)
+		}
+		returnChan <- kitendpoint.Response{Data: stats, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// PruneCacheRequestData brings the snapshot store down to MaxBytes, asking
+// for the report only when DryRun is set.
+type PruneCacheRequestData struct {
+	MaxBytes int64 `json:"maxBytes"`
+	DryRun   bool  `json:"dryRun"`
+}
+
+// PruneCache is CollectSnapshots with the budget MaxBytes: on top of the
+// snapshots unreferenced for longer than the grace period, it removes the
+// unreferenced ones, the oldest first, while the store is larger than
+// MaxBytes. Referenced and leased snapshots are always kept, so the store
+// may stay above the budget. Only admins may run it when the request
+// carries an identity.
+func (s *basicModelService) PruneCache(ctx context.Context, req PruneCacheRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		if identity, ok := auth.FromContext(ctx); ok && !identity.HasRole(auth.RoleAdmin) {
+			err := fmt.Errorf("user %q is not allowed to prune the cache", identity.User)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeForbidden, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if req.MaxBytes <= 0 {
+			err := errors.New("maxBytes must be positive")
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if !atomic.CompareAndSwapInt32(&s.collecting, 0, 1) {
+			err := fmt.Errorf("a snapshot collection is already running")
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeBusy, Message: err.Error()}, IsLast: true}
+			return
+		}
+		defer atomic.StoreInt32(&s.collecting, 0)
+		data, err := s.collectSnapshots(ctx, req.DryRun, s.collectBefore(), req.MaxBytes)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	fp "path/filepath"
+	"strings"
+
+	t "server/db/pkg/types"
+	modelFormat "server/db/pkg/types/model/format"
+)
+
+// defaultSnapshotNames are the snapshots of the templates that do not name
+// theirs.
+var defaultSnapshotNames = map[string]string{
+	modelFormat.PyTorch:    "snapshot.pth",
+	modelFormat.Onnx:       "model.onnx",
+	modelFormat.OpenvinoIr: "model.xml",
+}
+
+// snapshotLayout is what a model is made of once trained: its format and the
+// files of its snapshot, relative to the model folder. An OpenVINO IR is a
+// .xml topology followed by its .bin weights, the other formats are a single
+// file.
+type snapshotLayout struct {
+	Format string
+	Files  []string
+}
+
+// snapshotLayout tells the layout from the extension of the snapshot, when
+// the template names it, or from the framework of the template.
+func (m ModelYml) snapshotLayout() snapshotLayout {
+	format := snapshotFormat(m.Snapshot, m.Framework)
+	name := m.Snapshot
+	if name == "" {
+		name = defaultSnapshotNames[format]
+	}
+	if format != modelFormat.OpenvinoIr {
+		return snapshotLayout{Format: format, Files: []string{name}}
+	}
+	base := strings.TrimSuffix(name, fp.Ext(name))
+	return snapshotLayout{Format: format, Files: []string{base + ".xml", base + ".bin"}}
+}
+
+func snapshotFormat(snapshot, framework string) string {
+	switch strings.ToLower(fp.Ext(snapshot)) {
+	case ".xml", ".bin":
+		return modelFormat.OpenvinoIr
+	case ".onnx":
+		return modelFormat.Onnx
+	case "":
+	default:
+		return modelFormat.PyTorch
+	}
+	framework = strings.ToLower(framework)
+	switch {
+	case strings.Contains(framework, "openvino"):
+		return modelFormat.OpenvinoIr
+	case strings.Contains(framework, "onnx"):
+		return modelFormat.Onnx
+	}
+	return modelFormat.PyTorch
+}
+
+// setSnapshot points the model to the snapshot files of layout in dir.
+func (l snapshotLayout) setSnapshot(model *t.Model, dir string) {
+	model.SnapshotFormat = l.Format
+	model.SnapshotPath = fp.Join(dir, l.Files[0])
+	model.WeightsPath = ""
+	if len(l.Files) > 1 {
+		model.WeightsPath = fp.Join(dir, l.Files[1])
+	}
+}
+
+// checkFiles returns an error for the first file of the layout that is
+// neither in the model folder dir nor placed by a dependency of the template.
+func (l snapshotLayout) checkFiles(dir string, modelYml ModelYml) error {
+	for _, name := range l.Files {
+		if _, err := os.Stat(fp.Join(dir, name)); err != nil && !modelYml.isDependencyDestination(name) {
+			return fmt.Errorf("snapshot %q of model %q is neither copied nor a dependency destination", name, modelYml.Name)
+		}
+	}
+	return nil
+}
+
+// isPyTorch tells whether the snapshot of the model can be trained further.
+func isPyTorch(model t.Model) bool {
+	return model.SnapshotFormat == "" || model.SnapshotFormat == modelFormat.PyTorch
+}
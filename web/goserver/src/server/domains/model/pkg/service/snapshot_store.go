@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	fp "path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	snapshotSetRefs "server/db/pkg/handler/snapshot/set_refs"
+	t "server/db/pkg/types"
+)
+
+// snapshotStoreName is the folder of the problem path the snapshots are
+// stored in by their sha256, every model file with the content of a snapshot
+// being a hardlink of it. The snapshot registry counts the files referring
+// to each, CollectSnapshots removes those left without any.
+const snapshotStoreName = ".snapshots"
+
+func (s *basicModelService) snapshotBlob(sha256 string) string {
+	return fp.Join(s.problemPath, snapshotStoreName, sha256[:2], sha256)
+}
+
+// registerSnapshots stores the snapshot files of the model, or all its files
+// when it is Cas, and makes them its only references in the registry. The
+// files that can not be stored are logged and left as they are.
+func (s *basicModelService) registerSnapshots(ctx context.Context, model t.Model) {
+	paths := []string{model.SnapshotPath, model.WeightsPath}
+	if model.Cas {
+		paths = casFiles(model.Dir)
+	}
+	files := []t.SnapshotFile{}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		file, err := s.storeSnapshot(ctx, path)
+		if err != nil {
+			log.Println("domains.model.pkg.service.snapshot_store.registerSnapshots.storeSnapshot", path, err)
+			continue
+		}
+		files = append(files, file)
+	}
+	s.setSnapshotRefs(ctx, model.Id, files)
+}
+
+// casFiles lists the files of the model folder dir stored in Cas mode, the
+// empty files and the leftovers of downloads and writes are kept out.
+func casFiles(dir string) []string {
+	var paths []string
+	err := fp.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && info.Size() > 0 && !isLeftover(info.Name()) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("domains.model.pkg.service.snapshot_store.casFiles", dir, err)
+	}
+	return paths
+}
+
+// unregisterSnapshots drops the references of a deleted or materialized
+// model.
+func (s *basicModelService) unregisterSnapshots(ctx context.Context, modelId primitive.ObjectID) {
+	s.setSnapshotRefs(ctx, modelId, []t.SnapshotFile{})
+}
+
+func (s *basicModelService) setSnapshotRefs(ctx context.Context, modelId primitive.ObjectID, files []t.SnapshotFile) {
+	if _, err := s.repos.Snapshots.SetSnapshotRefs(ctx, snapshotSetRefs.RequestData{ModelId: modelId, Files: files}); err != nil {
+		log.Println("domains.model.pkg.service.snapshot_store.setSnapshotRefs.SetSnapshotRefs", err)
+	}
+}
+
+// storeSnapshot hardlinks the file at path into the store, or replaces it
+// with a hardlink of the stored snapshot when its content is already there.
+func (s *basicModelService) storeSnapshot(ctx context.Context, path string) (t.SnapshotFile, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return t.SnapshotFile{}, err
+	}
+	if !stat.Mode().IsRegular() {
+		return t.SnapshotFile{}, fmt.Errorf("%s is not a regular file", path)
+	}
+	sha256 := getSha265(path)
+	if sha256 == "" {
+		return t.SnapshotFile{}, errors.New("can not hash the file")
+	}
+	blob := s.snapshotBlob(sha256)
+	if err := s.paths.CheckWrite(ctx, blob); err != nil {
+		return t.SnapshotFile{}, err
+	}
+	if err := s.paths.CheckWrite(ctx, path); err != nil {
+		return t.SnapshotFile{}, err
+	}
+	file := t.SnapshotFile{Sha256: sha256, Size: stat.Size(), Blob: blob, Path: path}
+	if err := os.MkdirAll(fp.Dir(blob), 0777); err != nil {
+		return file, err
+	}
+	if err := os.Link(path, blob); err == nil || !os.IsExist(err) {
+		return file, err
+	}
+	blobStat, err := os.Stat(blob)
+	if err != nil {
+		return file, err
+	}
+	if os.SameFile(stat, blobStat) {
+		return file, nil
+	}
+	if blobStat.Size() != stat.Size() {
+		return file, fmt.Errorf("stored snapshot %s has %d bytes, not %d", blob, blobStat.Size(), stat.Size())
+	}
+	// The link is made next to the file and renamed over it, so that the
+	// file is never missing.
+	tmp := fp.Join(fp.Dir(path), "."+fp.Base(path)+".tmp")
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return file, err
+	}
+	if err := os.Link(blob, tmp); err != nil {
+		return file, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return file, err
+	}
+	return file, nil
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	fp "path/filepath"
+	"strings"
+
+	t "server/db/pkg/types"
+)
+
+// sniffLength is how many bytes of a download are read to sniff its content.
+const sniffLength = 512
+
+// htmlPrefixes start the HTML pages a mirror may answer with a 200 in place
+// of the file, the content being lowercased and trimmed first.
+var htmlPrefixes = [][]byte{[]byte("<!doctype"), []byte("<html")}
+
+// sniffs tells whether the downloads of the dependency are sniffed, that is
+// all but those placed as HTML files and those marked skip_sniff by the
+// template.
+func sniffs(d t.Dependency) bool {
+	switch strings.ToLower(fp.Ext(d.Destination)) {
+	case ".htm", ".html":
+		return false
+	}
+	return !d.SkipSniff
+}
+
+// sniffDownload fails for an empty download and for one that is an HTML
+// page, so that an error page is retried even when its size happens to be
+// the declared one.
+func sniffDownload(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(f, head)
+	if err == io.EOF {
+		return errors.New("the download is empty")
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = bytes.ToLower(bytes.TrimLeft(head[:n], " \t\r\n\ufeff"))
+	for _, prefix := range htmlPrefixes {
+		if bytes.HasPrefix(head, prefix) {
+			return errors.New("the download is an HTML page, not the dependency")
+		}
+	}
+	return nil
+}
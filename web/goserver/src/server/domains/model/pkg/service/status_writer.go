@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelUpdateStatus "server/db/pkg/handler/model/update_status"
+	t "server/db/pkg/types"
+)
+
+// statusWriter coalesces the status updates of the models. The updates of a
+// model within window are merged into one write made when the window ends,
+// terminal updates are written at once together with what is pending. The
+// writes of a model are made in the order they were taken, so a pending
+// update never lands after a later one.
+type statusWriter struct {
+	conn   *rabbitmq.Connection
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[primitive.ObjectID]*statusBatch
+	// last is closed once the last write taken for the model is made.
+	last map[primitive.ObjectID]chan struct{}
+}
+
+type statusBatch struct {
+	req   modelUpdateStatus.RequestData
+	timer *time.Timer
+}
+
+func newStatusWriter(conn *rabbitmq.Connection, window time.Duration) *statusWriter {
+	return &statusWriter{
+		conn:    conn,
+		window:  window,
+		pending: make(map[primitive.ObjectID]*statusBatch),
+		last:    make(map[primitive.ObjectID]chan struct{}),
+	}
+}
+
+// update saves req, at once and returning the updated model when terminal is
+// set or there is no window, later and returning a zero model otherwise.
+func (w *statusWriter) update(ctx context.Context, req modelUpdateStatus.RequestData, terminal bool) (t.Model, error) {
+	w.mu.Lock()
+	b, ok := w.pending[req.Id]
+	if ok {
+		statusWritesCoalesced.Inc()
+	} else {
+		b = &statusBatch{req: modelUpdateStatus.RequestData{Id: req.Id}}
+	}
+	mergeStatus(&b.req, req)
+	if !terminal && w.window > 0 {
+		if !ok {
+			w.pending[req.Id] = b
+			b.timer = time.AfterFunc(w.window, func() { w.flushBatch(b) })
+		}
+		w.mu.Unlock()
+		return t.Model{}, nil
+	}
+	if ok {
+		b.timer.Stop()
+		delete(w.pending, req.Id)
+	}
+	wait, done := w.take(req.Id)
+	w.mu.Unlock()
+	return w.write(ctx, req.Id, &b.req, wait, done)
+}
+
+// flush writes what is pending for the model and waits for the writes taken
+// before, so that a model read afterwards has them.
+func (w *statusWriter) flush(ctx context.Context, id primitive.ObjectID) error {
+	w.mu.Lock()
+	var req *modelUpdateStatus.RequestData
+	if b, ok := w.pending[id]; ok {
+		b.timer.Stop()
+		delete(w.pending, id)
+		req = &b.req
+	}
+	wait, done := w.take(id)
+	w.mu.Unlock()
+	_, err := w.write(ctx, id, req, wait, done)
+	return err
+}
+
+// flushBatch writes b once its window is over, unless a terminal update or a
+// flush took it meanwhile.
+func (w *statusWriter) flushBatch(b *statusBatch) {
+	w.mu.Lock()
+	if w.pending[b.req.Id] != b {
+		w.mu.Unlock()
+		return
+	}
+	delete(w.pending, b.req.Id)
+	wait, done := w.take(b.req.Id)
+	w.mu.Unlock()
+	if _, err := w.write(context.Background(), b.req.Id, &b.req, wait, done); err != nil {
+		log.Println("domains.model.pkg.service.status_writer.flushBatch", b.req.Id.Hex(), err)
+	}
+}
+
+// take queues a write of the model, the caller holds w.mu. The write waits
+// for wait and closes done once made.
+func (w *statusWriter) take(id primitive.ObjectID) (wait <-chan struct{}, done chan struct{}) {
+	wait = w.last[id]
+	done = make(chan struct{})
+	w.last[id] = done
+	return wait, done
+}
+
+// write makes req, if any, once the writes taken before it are made.
+func (w *statusWriter) write(ctx context.Context, id primitive.ObjectID, req *modelUpdateStatus.RequestData, wait <-chan struct{}, done chan struct{}) (t.Model, error) {
+	defer func() {
+		close(done)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.last[id] == done {
+			delete(w.last, id)
+		}
+	}()
+	if wait != nil {
+		<-wait
+	}
+	if req == nil {
+		return t.Model{}, nil
+	}
+	resp := <-modelUpdateStatus.Send(ctx, w.conn, *req)
+	if resp.Err.Code > 0 {
+		return t.Model{}, errors.New(resp.Err.Message)
+	}
+	return resp.Data.(modelUpdateStatus.ResponseData), nil
+}
+
+// saveStatus saves the status update req of model, which the caller already
+// applied to it. The model is returned as saved when it was written at once.
+func (s *basicModelService) saveStatus(ctx context.Context, model t.Model, req modelUpdateStatus.RequestData, terminal bool) (t.Model, error) {
+	saved, err := s.statuses.update(ctx, req, terminal)
+	if err != nil {
+		return model, err
+	}
+	if saved.Id.IsZero() {
+		return model, nil
+	}
+	return s.localModel(saved), nil
+}
+
+// mergeStatus lays from over into, the maps of from are not shared.
+func mergeStatus(into *modelUpdateStatus.RequestData, from modelUpdateStatus.RequestData) {
+	if from.Status != "" {
+		into.Status = from.Status
+	}
+	for buildId, evaluate := range from.Evaluates {
+		if into.Evaluates == nil {
+			into.Evaluates = make(map[string]t.Evaluate)
+		}
+		into.Evaluates[buildId] = evaluate
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"strings"
+
+	t "server/db/pkg/types"
+	"server/kit/redact"
+	"server/kit/storage"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// storageKey is the key, in the storage, of the file at path of the working
+// copy, ok being false when path is not under the problem path.
+func (s *basicModelService) storageKey(path string) (key string, ok bool) {
+	if path == "" || !fp.IsAbs(path) {
+		return "", false
+	}
+	rel, err := fp.Rel(s.problemPath, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(fp.Separator)) {
+		return "", false
+	}
+	return fp.ToSlash(rel), true
+}
+
+// storageRef is the ref stored for the file at path, path itself when it is
+// not in the storage.
+func (s *basicModelService) storageRef(path string) string {
+	if key, ok := s.storageKey(path); ok {
+		return storage.Ref(s.store, key)
+	}
+	return path
+}
+
+// localPath is the path in the working copy of the file ref refers to,
+// whatever the storage it was stored with, ref itself when it is an
+// absolute path stored before the storages.
+func (s *basicModelService) localPath(ref string) string {
+	_, key, ok := storage.ParseRef(ref)
+	if !ok || storage.CheckKey(key) != nil {
+		return ref
+	}
+	return fp.Join(s.problemPath, fp.FromSlash(key))
+}
+
+// storedModel is model with its paths turned into refs, as the database
+// keeps them.
+func (s *basicModelService) storedModel(model t.Model) t.Model {
+	for _, path := range modelPaths(&model.ConfigPath, &model.Dir, &model.ModulesYamlPath, &model.ReadmePath, &model.Scripts, &model.SnapshotPath, &model.TemplatePath, &model.WeightsPath) {
+		*path = s.storageRef(*path)
+	}
+	return model
+}
+
+func (s *basicModelService) storedModelWithoutId(model t.ModelWithoutId) t.ModelWithoutId {
+	for _, path := range modelPaths(&model.ConfigPath, &model.Dir, &model.ModulesYamlPath, &model.ReadmePath, &model.Scripts, &model.SnapshotPath, &model.TemplatePath, &model.WeightsPath) {
+		*path = s.storageRef(*path)
+	}
+	return model
+}
+
+// localModel is the model the database returned with its refs turned into
+// paths of the working copy, which the rest of the service works with.
+func (s *basicModelService) localModel(model t.Model) t.Model {
+	for _, path := range modelPaths(&model.ConfigPath, &model.Dir, &model.ModulesYamlPath, &model.ReadmePath, &model.Scripts, &model.SnapshotPath, &model.TemplatePath, &model.WeightsPath) {
+		*path = s.localPath(*path)
+	}
+	return model
+}
+
+func (s *basicModelService) localModels(models []t.Model) []t.Model {
+	for i := range models {
+		models[i] = s.localModel(models[i])
+	}
+	return models
+}
+
+func modelPaths(config, dir, modulesYaml, readme *string, scripts *t.Scripts, snapshot, template, weights *string) []*string {
+	return []*string{config, dir, modulesYaml, readme, &scripts.Train, &scripts.Eval, snapshot, template, weights}
+}
+
+// publish syncs the model folder dir of the working copy to the storage,
+// hashes holding the sha256 of its files known already by their path in
+// dir. A folder no longer in the working copy is removed from the storage.
+// The local storage is the working copy, nothing is done for it. A failure
+// is logged, the next publish of the folder uploading what is missing.
+func (s *basicModelService) publish(ctx context.Context, dir string, hashes map[string]string) {
+	if err := s.syncDir(ctx, dir, hashes); err != nil {
+		redact.Println("domains.model.pkg.service.storage_paths.publish", dir, err)
+	}
+}
+
+// syncDir is publish, returning its error.
+func (s *basicModelService) syncDir(ctx context.Context, dir string, hashes map[string]string) error {
+	key, ok := s.storageKey(dir)
+	if !ok {
+		return nil
+	}
+	keyed := make(map[string]string, len(hashes))
+	for rel, sha := range hashes {
+		keyed[key+"/"+rel] = sha
+	}
+	result, err := storage.Sync(ctx, s.store, key, keyed)
+	if err != nil {
+		return err
+	}
+	if result.Uploaded > 0 || result.Removed > 0 {
+		redact.Println("domains.model.pkg.service.storage_paths.publish", dir, result.Uploaded, result.UploadedSize, result.Removed)
+	}
+	return nil
+}
+
+// readModelFile reads the file at path of a model through the storage, or
+// from the file system when it is not in the storage.
+func (s *basicModelService) readModelFile(ctx context.Context, path string) ([]byte, error) {
+	if key, ok := s.storageKey(path); ok {
+		return storage.ReadFile(ctx, s.store, key)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// writeModelFile writes the file at path of a model through write, to the
+// storage, or to the file system when it is not in the storage. The file is
+// replaced only when write succeeds.
+func (s *basicModelService) writeModelFile(ctx context.Context, path string, write func(w io.Writer) error) error {
+	if key, ok := s.storageKey(path); ok {
+		return storage.Write(ctx, s.store, key, write)
+	}
+	return uFiles.WriteAtomicContext(ctx, path, 0666, write)
+}
+
+// openModelFile opens the file at path of a model, like readModelFile.
+func (s *basicModelService) openModelFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	if key, ok := s.storageKey(path); ok {
+		return s.store.Open(ctx, key)
+	}
+	return os.Open(path)
+}
+
+// removeModelFile removes the file at path of a model, like writeModelFile.
+func (s *basicModelService) removeModelFile(ctx context.Context, path string) error {
+	if key, ok := s.storageKey(path); ok {
+		return s.store.Remove(ctx, key)
+	}
+	return os.Remove(path)
+}
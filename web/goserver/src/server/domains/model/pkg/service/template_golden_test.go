@@ -0,0 +1,134 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	t "server/db/pkg/types"
+	u "server/kit/utils"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata/templates")
+
+// templateGolden is what a template of testdata/templates gives: its parsed
+// documents, the values of their placeholders without any supplied and
+// the models prepared from them, or the first error met.
+type templateGolden struct {
+	Documents    []ModelYml                   `json:"documents,omitempty"`
+	Placeholders map[string]map[string]string `json:"placeholders,omitempty"`
+	Models       []t.Model                    `json:"models,omitempty"`
+	Error        string                       `json:"error,omitempty"`
+}
+
+// goldenProblem is the problem the models of the templates are prepared
+// for, unless the folder of the template has a problem.json.
+var goldenProblem = t.Problem{
+	Id:    mustObjectId("5f0000000000000000000001"),
+	Title: "vehicles",
+	Class: "Object Detection",
+	Dir:   "/problems/vehicles",
+}
+
+var goldenBuildId = mustObjectId("5f0000000000000000000002")
+
+func mustObjectId(hex string) primitive.ObjectID {
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// TestTemplateGolden parses the template.yaml of every folder of
+// testdata/templates and prepares its models, comparing the outcome with
+// the golden.json of the folder. go test -run TestTemplateGolden -update
+// writes the golden files anew.
+func TestTemplateGolden(test *testing.T) {
+	paths, err := fp.Glob(fp.Join("testdata", "templates", "*", "template.yaml"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(paths) == 0 {
+		test.Fatal("no template in testdata/templates")
+	}
+	for _, path := range paths {
+		path := path
+		test.Run(fp.Base(fp.Dir(path)), func(test *testing.T) {
+			var b bytes.Buffer
+			encoder := json.NewEncoder(&b)
+			encoder.SetEscapeHTML(false)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(goldenOf(test, path)); err != nil {
+				test.Fatal(err)
+			}
+			got := b.Bytes()
+			golden := fp.Join(fp.Dir(path), "golden.json")
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0666); err != nil {
+					test.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				test.Fatalf("%v, run with -update to write it", err)
+			}
+			if !bytes.Equal(got, want) {
+				test.Errorf("%s differs from %s:\n%s", path, golden, got)
+			}
+		})
+	}
+}
+
+func goldenOf(test *testing.T, path string) (golden templateGolden) {
+	dir := fp.Dir(path)
+	problem := goldenProblem
+	if b, err := ioutil.ReadFile(fp.Join(dir, "problem.json")); err == nil {
+		if err := json.Unmarshal(b, &problem); err != nil {
+			test.Fatal(err)
+		}
+	} else if !os.IsNotExist(err) {
+		test.Fatal(err)
+	}
+	fail := func(err error) templateGolden {
+		// The messages name the files relative to the test data.
+		message := err.Error()
+		if abs, err := fp.Abs(dir); err == nil {
+			message = strings.ReplaceAll(message, abs, dir)
+		}
+		golden.Error = fp.ToSlash(message)
+		return golden
+	}
+	docs, err := getTemplateDocuments(path)
+	if err != nil {
+		return fail(err)
+	}
+	s := &basicModelService{}
+	for _, doc := range docs {
+		golden.Documents = append(golden.Documents, doc.ModelYml)
+		values, err := doc.placeholderValues(dir, nil)
+		if err != nil {
+			return fail(err)
+		}
+		if values != nil {
+			if golden.Placeholders == nil {
+				golden.Placeholders = make(map[string]map[string]string)
+			}
+			golden.Placeholders[doc.Name] = values
+		}
+		model, err := s.prepareModel(doc.ModelYml, goldenBuildId, problem, fp.Join(problem.Dir, u.StringToFolderName(doc.Name)))
+		if err != nil {
+			return fail(err)
+		}
+		golden.Models = append(golden.Models, model)
+	}
+	return golden
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	fp "path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxIncludeDepth bounds the fragments included by included fragments.
+const maxIncludeDepth = 8
+
+// includeTag is the tag of the values read from a fragment of the
+// template, "!include fragment.yaml".
+const includeTag = "!include"
+
+// resolveIncludes replaces the "!include fragment.yaml" values of the
+// template document content by the contents of the fragments, so that the
+// templates of a catalog can share their dependencies, for instance. The
+// paths are relative to dir, the folder of the template, or to the folder
+// of the fragment including another, and must stay in dir. An included list
+// replaces the item of a list it is in, its items being inserted instead.
+// The includes are the values tagged !include, not the text looking like
+// one in a string or a comment. The document is returned as it is when it
+// includes nothing, and written anew otherwise, in the order it was written.
+func resolveIncludes(content []byte, dir string) ([]byte, error) {
+	if !bytes.Contains(content, []byte(includeTag)) {
+		return content, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if !includes(&doc) {
+		return content, nil
+	}
+	root, err := fp.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := (includeResolver{root: root}).resolve(&doc, root, nil); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// hasIncludes tells whether the template document content includes
+// fragments. A document that is not valid YAML includes none.
+func hasIncludes(content []byte) bool {
+	if !bytes.Contains(content, []byte(includeTag)) {
+		return false
+	}
+	var doc yaml.Node
+	return yaml.Unmarshal(content, &doc) == nil && includes(&doc)
+}
+
+// includes tells whether node or a node under it is tagged !include.
+func includes(node *yaml.Node) bool {
+	if node.Tag == includeTag {
+		return true
+	}
+	for _, child := range node.Content {
+		if includes(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// includeResolver reads the fragments under root.
+type includeResolver struct {
+	root string
+}
+
+// resolve replaces the includes under node, found in the folder dir, stack
+// being the fragments being included, from the outermost. The keys of the
+// maps are left as they are.
+func (r includeResolver) resolve(node *yaml.Node, dir string, stack []string) error {
+	if node.Tag == includeTag {
+		included, err := r.include(node, dir, stack)
+		if err != nil {
+			return err
+		}
+		*node = *included
+		return nil
+	}
+	content := make([]*yaml.Node, 0, len(node.Content))
+	for i, child := range node.Content {
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			content = append(content, child)
+			continue
+		}
+		included := child.Tag == includeTag
+		if err := r.resolve(child, dir, stack); err != nil {
+			return err
+		}
+		if included && node.Kind == yaml.SequenceNode && child.Kind == yaml.SequenceNode {
+			content = append(content, child.Content...)
+			continue
+		}
+		content = append(content, child)
+	}
+	node.Content = content
+	return nil
+}
+
+// include reads the fragment the include node names, relative to dir,
+// resolving its own includes.
+func (r includeResolver) include(node *yaml.Node, dir string, stack []string) (*yaml.Node, error) {
+	if node.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("line %d: include must be given the path of a fragment", node.Line)
+	}
+	path := strings.TrimSpace(node.Value)
+	if path == "" || fp.IsAbs(path) {
+		return nil, fmt.Errorf("include %q must be a path relative to the template", path)
+	}
+	outside := fmt.Errorf("include %q is outside of the folder of the template", path)
+	if rel, err := fp.Rel(r.root, fp.Join(dir, path)); err != nil || !isInsideDir(rel) {
+		return nil, outside
+	}
+	target, err := fp.EvalSymlinks(fp.Join(dir, path))
+	if err != nil {
+		return nil, fmt.Errorf("include %q: %v", path, err)
+	}
+	if rel, err := fp.Rel(r.root, target); err != nil || !isInsideDir(rel) {
+		return nil, outside
+	}
+	for _, included := range stack {
+		if included == target {
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(append(r.relative(stack), r.relative([]string{target})...), " -> "))
+		}
+	}
+	if len(stack) >= maxIncludeDepth {
+		return nil, fmt.Errorf("include %q: more than %d nested includes", path, maxIncludeDepth)
+	}
+	content, err := ioutil.ReadFile(target)
+	if err != nil {
+		return nil, fmt.Errorf("include %q: %v", path, err)
+	}
+	var fragment yaml.Node
+	if err := yaml.Unmarshal(content, &fragment); err != nil {
+		return nil, fmt.Errorf("include %q: %v", path, err)
+	}
+	if len(fragment.Content) == 0 {
+		// An empty fragment is null.
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+	included := fragment.Content[0]
+	if err := r.resolve(included, fp.Dir(target), append(stack[:len(stack):len(stack)], target)); err != nil {
+		return nil, err
+	}
+	return included, nil
+}
+
+// relative are paths relative to the folder of the template, for messages.
+func (r includeResolver) relative(paths []string) []string {
+	rels := make([]string, len(paths))
+	for i, path := range paths {
+		rels[i] = path
+		if rel, err := fp.Rel(r.root, path); err == nil {
+			rels[i] = rel
+		}
+	}
+	return rels
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"testing"
+)
+
+// includeTree writes files, by path relative to a temporary folder, and
+// returns its template folder, "template".
+func includeTree(test *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "includes")
+	if err != nil {
+		test.Fatal(err)
+	}
+	test.Cleanup(func() { os.RemoveAll(root) })
+	for path, content := range files {
+		path = fp.Join(root, path)
+		if err := os.MkdirAll(fp.Dir(path), 0777); err != nil {
+			test.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			test.Fatal(err)
+		}
+	}
+	return fp.Join(root, "template")
+}
+
+// includeChain is a template including fragments nested depth deep.
+func includeChain(depth int) map[string]string {
+	files := map[string]string{"template/template.yaml": "name: detector\nmetrics: !include f1.yaml\n"}
+	for i := 1; i < depth; i++ {
+		files[fmt.Sprintf("template/f%d.yaml", i)] = fmt.Sprintf("- !include f%d.yaml\n", i+1)
+	}
+	files[fmt.Sprintf("template/f%d.yaml", depth)] = "- key: ap\n"
+	return files
+}
+
+func TestResolveIncludesRefuses(test *testing.T) {
+	for _, c := range []struct {
+		name  string
+		files map[string]string
+		link  [2]string
+		error string
+	}{
+		{
+			name: "cycle",
+			files: map[string]string{
+				"template/template.yaml":    "name: detector\nmetrics: !include fragments/a.yaml\n",
+				"template/fragments/a.yaml": "- !include b.yaml\n",
+				"template/fragments/b.yaml": "- !include a.yaml\n",
+			},
+			error: "include cycle: fragments/a.yaml -> fragments/b.yaml -> fragments/a.yaml",
+		},
+		{
+			name: "parent folder",
+			files: map[string]string{
+				"template/template.yaml": "name: detector\nmetrics: !include ../x.yaml\n",
+				"x.yaml":                 "- key: ap\n",
+			},
+			error: `include "../x.yaml" is outside of the folder of the template`,
+		},
+		{
+			name: "symlink out of the folder",
+			files: map[string]string{
+				"template/template.yaml": "name: detector\nmetrics: !include fragments/metrics.yaml\n",
+				"secrets.yaml":           "- key: ap\n",
+			},
+			link:  [2]string{"secrets.yaml", "template/fragments/metrics.yaml"},
+			error: `include "fragments/metrics.yaml" is outside of the folder of the template`,
+		},
+		{
+			name:  "too deep",
+			files: includeChain(maxIncludeDepth + 1),
+			error: fmt.Sprintf("more than %d nested includes", maxIncludeDepth),
+		},
+	} {
+		test.Run(c.name, func(test *testing.T) {
+			dir := includeTree(test, c.files)
+			if c.link[0] != "" {
+				root := fp.Dir(dir)
+				if err := os.MkdirAll(fp.Dir(fp.Join(root, c.link[1])), 0777); err != nil {
+					test.Fatal(err)
+				}
+				if err := os.Symlink(fp.Join(root, c.link[0]), fp.Join(root, c.link[1])); err != nil {
+					test.Fatal(err)
+				}
+			}
+			content, err := ioutil.ReadFile(fp.Join(dir, "template.yaml"))
+			if err != nil {
+				test.Fatal(err)
+			}
+			resolved, err := resolveIncludes(content, dir)
+			if err == nil || !strings.Contains(err.Error(), c.error) {
+				test.Fatalf("resolved %q, error %v, want %q", resolved, err, c.error)
+			}
+		})
+	}
+}
+
+func TestResolveIncludesWithinTheBounds(test *testing.T) {
+	dir := includeTree(test, includeChain(maxIncludeDepth))
+	// A link staying in the folder of the template is followed.
+	if err := os.Symlink(fp.Join(dir, "f1.yaml"), fp.Join(dir, "metrics.yaml")); err != nil {
+		test.Fatal(err)
+	}
+	resolved, err := resolveIncludes([]byte("name: detector\nmetrics: !include metrics.yaml\n"), dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if want := "name: detector\nmetrics:\n  - key: ap\n"; string(resolved) != want {
+		test.Errorf("resolved %q, want %q", resolved, want)
+	}
+}
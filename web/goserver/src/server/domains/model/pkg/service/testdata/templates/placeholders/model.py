@@ -0,0 +1,2 @@
+data_root = "${DATASET_ROOT}"
+work_dir = "${WORK_DIR}"
@@ -0,0 +1 @@
+data_root = "${DATASET_ROOT}"
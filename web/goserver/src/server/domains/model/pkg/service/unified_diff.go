@@ -0,0 +1,151 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxDiffLines bounds the lines of each side unifiedDiff compares, the
+	// comparison taking their product in memory.
+	maxDiffLines = 4000
+	// diffContext is the unchanged lines kept around the changes.
+	diffContext = 3
+)
+
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff is the unified diff of the lines of a and b, named nameA and
+// nameB in its header, empty when they are the same. It stops at maxBytes,
+// telling it was truncated. The sides longer than maxDiffLines are not
+// compared, the diff being then empty and truncated.
+func unifiedDiff(nameA, nameB, a, b string, maxBytes int) (diff string, truncated bool) {
+	if a == b {
+		return "", false
+	}
+	linesA, linesB := splitLines(a), splitLines(b)
+	if len(linesA) > maxDiffLines || len(linesB) > maxDiffLines {
+		return "", true
+	}
+	ops := diffLines(linesA, linesB)
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		// The hunk goes on while two changes are at most twice the context
+		// apart.
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		end, unchanged := start, 0
+		for end < len(ops) && unchanged <= 2*diffContext {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		if end = end - unchanged + diffContext; end > len(ops) {
+			end = len(ops)
+		}
+		var hunk strings.Builder
+		lineA, lineB := lineOf(ops[:from], '+'), lineOf(ops[:from], '-')
+		countA, countB := 0, 0
+		for _, op := range ops[from:end] {
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+			hunk.WriteByte(op.kind)
+			hunk.WriteString(op.line)
+			hunk.WriteByte('\n')
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(lineA, countA), hunkRange(lineB, countB))
+		out.WriteString(hunk.String())
+		if out.Len() > maxBytes {
+			return strings.ToValidUTF8(out.String()[:maxBytes], ""), true
+		}
+		start = end
+	}
+	return out.String(), false
+}
+
+func splitLines(s string) []string {
+	lines := strings.Split(s, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines is the edit script from a to b along their longest common
+// subsequence, the removals of a change coming before its additions.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return ops
+}
+
+// lineOf is the number of the line of a side the ops end before, the ops of
+// the other side, skip, left out.
+func lineOf(ops []diffOp, skip byte) int {
+	line := 1
+	for _, op := range ops {
+		if op.kind != skip {
+			line++
+		}
+	}
+	return line
+}
+
+func hunkRange(line, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
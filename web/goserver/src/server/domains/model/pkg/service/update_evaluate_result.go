@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	buildFindOne "server/db/pkg/handler/build/find_one"
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	modelUpdateStatus "server/db/pkg/handler/model/update_status"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	statusModelEvaluate "server/db/pkg/types/status/model/evaluate"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+)
+
+// UpdateEvaluateResultRequestData stores the outcome of an evaluation run of
+// the model on the build. Status is statusModelEvaluate.Finished, with one
+// metric per key, or statusModelEvaluate.Failed, and the evaluation has to be
+// in progress.
+type UpdateEvaluateResultRequestData struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+	BuildId primitive.ObjectID `json:"buildId"`
+	Metrics []t.Metric         `json:"metrics"`
+	Status  string             `json:"status"`
+}
+
+func (s *basicModelService) UpdateEvaluateResult(ctx context.Context, req UpdateEvaluateResultRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		metrics, err := validateEvaluateResult(req)
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		unlock, err := s.lockModel(ctx, req.ModelId, "evaluation result")
+		if err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: dirLockErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		defer unlock()
+		if err := s.statuses.flush(ctx, req.ModelId); err != nil {
+			log.Println("domains.model.pkg.service.update_evaluate_result.UpdateEvaluateResult.s.statuses.flush", err)
+		}
+		modelResp := <-modelFindOne.Send(ctx, s.Conn, modelFindOne.RequestData{Id: req.ModelId})
+		model := s.localModel(modelResp.Data.(modelFindOne.ResponseData))
+		if model.Id.IsZero() {
+			err := fmt.Errorf("model %s not found", req.ModelId.Hex())
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if err := access.Check(ctx, s.Conn, model.ProblemId, role.Editor); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}, IsLast: true}
+			return
+		}
+		current, ok := model.Evaluates[req.BuildId.Hex()]
+		if !ok {
+			err := fmt.Errorf("model %s has no evaluation on build %s", model.Name, req.BuildId.Hex())
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if err := statusModelEvaluate.CheckTransition(current.Status, req.Status); err != nil {
+			err := fmt.Errorf("model %s on build %s: %v", model.Name, req.BuildId.Hex(), err)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		buildResp := <-buildFindOne.Send(ctx, s.Conn, buildFindOne.RequestData{Id: req.BuildId})
+		build, _ := buildResp.Data.(buildFindOne.ResponseData)
+		model.Evaluates[req.BuildId.Hex()] = t.Evaluate{Metrics: metrics, Status: req.Status, AssetsHash: build.AssetsHash}
+		model, err = s.saveStatus(ctx, model, modelUpdateStatus.RequestData{
+			Id:        model.Id,
+			Evaluates: map[string]t.Evaluate{req.BuildId.Hex(): model.Evaluates[req.BuildId.Hex()]},
+		}, true)
+		if err != nil {
+			log.Println("domains.model.pkg.service.update_evaluate_result.UpdateEvaluateResult.s.saveStatus", err)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+			return
+		}
+		s.emitEvaluation(model, req.BuildId)
+		returnChan <- kitendpoint.Response{Data: model, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// validateEvaluateResult checks the status and the metrics of the result and
+// returns the metrics with their fields trimmed. Metrics need a unique key and
+// a numeric value, a failed evaluation keeps the metrics it sent, if any.
+func validateEvaluateResult(req UpdateEvaluateResultRequestData) ([]t.Metric, error) {
+	if req.Status != statusModelEvaluate.Finished && req.Status != statusModelEvaluate.Failed {
+		return nil, fmt.Errorf("status must be %s or %s, got %q", statusModelEvaluate.Finished, statusModelEvaluate.Failed, req.Status)
+	}
+	if req.Status == statusModelEvaluate.Finished && len(req.Metrics) == 0 {
+		return nil, fmt.Errorf("a finished evaluation needs metrics")
+	}
+	metrics := make([]t.Metric, 0, len(req.Metrics))
+	keys := make(map[string]bool)
+	for i, metric := range req.Metrics {
+		metric.Key = strings.TrimSpace(metric.Key)
+		metric.DisplayName = strings.TrimSpace(metric.DisplayName)
+		metric.Value = strings.TrimSpace(metric.Value)
+		metric.Unit = strings.TrimSpace(metric.Unit)
+		if metric.Key == "" {
+			return nil, fmt.Errorf("metric %d has no key", i)
+		}
+		if keys[metric.Key] {
+			return nil, fmt.Errorf("metric %q is given more than once", metric.Key)
+		}
+		keys[metric.Key] = true
+		if _, err := strconv.ParseFloat(metric.Value, 64); err != nil {
+			return nil, fmt.Errorf("metric %q has a value that is not a number: %q", metric.Key, metric.Value)
+		}
+		if metric.DisplayName == "" {
+			metric.DisplayName = metric.Key
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
@@ -8,10 +8,15 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"net/http"
 	"net/url"
 	"os"
 	fp "path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"gopkg.in/yaml.v2"
@@ -22,11 +27,21 @@ import (
 	problemFindOne "server/db/pkg/handler/problem/find_one"
 	t "server/db/pkg/types"
 	buildStatus "server/db/pkg/types/build/status"
+	"server/db/pkg/types/problem/role"
 	statusModelEvaluate "server/db/pkg/types/status/model/evaluate"
 	statusModelTrain "server/db/pkg/types/status/model/train"
+	typeOperation "server/db/pkg/types/type/operation"
+	typeWebhook "server/db/pkg/types/type/webhook"
+	"server/domains/problem/pkg/access"
+	"server/domains/problem/pkg/quota"
+	"server/kit/auth"
 	kitendpoint "server/kit/endpoint"
+	"server/kit/redact"
+	"server/kit/storage"
+	"server/kit/trace"
 	u "server/kit/utils"
 	uFiles "server/kit/utils/basic/files"
+	"server/kit/workpool"
 )
 
 type Basic struct {
@@ -40,122 +55,746 @@ type HyperParameters struct {
 }
 
 type ModelYml struct {
-	Class           string          `yaml:"domain"`
-	Name            string          `yaml:"name"`
+	Class     string `yaml:"domain"`
+	Framework string `yaml:"framework"`
+	Name      string `yaml:"name"`
+	// Problem is the title of the problem, or the hex of its id.
 	Problem         string          `yaml:"problem"`
 	Dependencies    []t.Dependency  `yaml:"dependencies"`
 	Metrics         []t.Metric      `yaml:"metrics"`
 	GpuNum          int             `yaml:"gpu_num"`
 	Config          string          `yaml:"config"`
 	HyperParameters HyperParameters `yaml:"hyper_parameters"`
+	Snapshot        string          `yaml:"snapshot"`
+	Readme          string          `yaml:"readme,omitempty"`
+	Previews        string          `yaml:"previews,omitempty"`
+	Checksums       string          `yaml:"checksums,omitempty"`
+	// PostImport are commands run in the folder of the model after every
+	// import, among those the service allows.
+	PostImport   []string      `yaml:"post_import,omitempty"`
+	Placeholders []Placeholder `yaml:"placeholders,omitempty"`
 }
 
+// isDependencyDestination tells whether one of the dependencies is placed at
+// the given path of the model folder.
+func (m ModelYml) isDependencyDestination(name string) bool {
+	for _, d := range m.Dependencies {
+		if fp.Clean(d.Destination) == fp.Clean(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateFromLocalRequestData imports the models of the template at Path, a
+// relative one being taken from the template base of the service.
+// Options override the import defaults of the service for this import.
+// Placeholders are the values, by name, of the placeholders the templates
+// declare for their configs, such as the paths of the datasets. Priority is
+// ImportInteractive, the default, or ImportBatch. Workspace, when set,
+// imports the models into that workspace of their problem.
 type UpdateFromLocalRequestData struct {
-	Path string `json:"path"`
+	Path         string            `json:"path"`
+	Tags         []string          `json:"tags"`
+	Workspace    string            `json:"workspace,omitempty"`
+	Options      ImportOptions     `json:"options"`
+	Placeholders map[string]string `json:"placeholders,omitempty"`
+	Priority     string            `json:"priority,omitempty"`
+	NotifyRequest
+}
+
+// ImportFailure is a model of the template that was not imported. Fields
+// lists the fields of the template at fault, when known.
+type ImportFailure struct {
+	Name    string          `json:"name"`
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Fields  []TemplateIssue `json:"fields,omitempty"`
+}
+
+// ImportStats describes what the import left in the folder of a model, and
+// what the post_import commands of its template did.
+type ImportStats struct {
+	Files      int64        `json:"files"`
+	Bytes      int64        `json:"bytes"`
+	DurationMs int64        `json:"durationMs"`
+	Hooks      []HookResult `json:"hooks,omitempty"`
+}
+
+// UpdateFromLocalResponseData is the data of the response sent for every
+// imported model of the template: the model, the build it was added to, what
+// the import left in the model folder and changed there, and the
+// dependencies it could not fetch.
+type UpdateFromLocalResponseData struct {
+	Model    t.Model     `json:"model"`
+	Build    t.Build     `json:"build"`
+	Stats    ImportStats `json:"stats"`
+	Diff     ImportDiff  `json:"diff"`
+	Warnings []string    `json:"warnings"`
+}
+
+// UpdateFromLocalSummary is the data of the last response, sent after one
+// response per model of the template.
+type UpdateFromLocalSummary struct {
+	Imported []UpdateFromLocalResponseData `json:"imported"`
+	Failed   []ImportFailure               `json:"failed"`
+}
+
+type BusyResponseData struct {
+	RetryAfter int `json:"retryAfter"`
+}
+
+// ImportQueuedResponseData is streamed while the import waits for a slot,
+// whenever its position in the queue changes, 1 being the next to start.
+type ImportQueuedResponseData struct {
+	Position int    `json:"position"`
+	Priority string `json:"priority"`
 }
 
 func (s *basicModelService) UpdateFromLocal(ctx context.Context, req UpdateFromLocalRequestData) chan kitendpoint.Response {
 	responseChan := make(chan kitendpoint.Response)
 	go func() {
-		templateYaml := getTemplateYaml(req.Path)
-		problem, err := s.getProblem(ctx, templateYaml.Problem)
+		// Deferred first, so the temp files go last, also when the import
+		// panics.
+		ctx, temps := uFiles.WithTemps(ctx)
+		defer temps.RemoveAll()
+		ctx, held := withHeldSnapshots(ctx, s.snapshotLeases)
+		defer held.releaseAll()
+		ctx = withImportCache(ctx)
+		req.Path = s.paths.TemplatePath(req.Path)
+		subscription, err := s.subscription(ctx, req.NotifyRequest)
 		if err != nil {
-			responseChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: 1}, IsLast: true}
+			responseChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		ctx, op := s.startOperation(ctx, typeOperation.ModelImport, subscription, responseChan)
+		op.onCancel(temps.RemoveAll)
+		if err := checkImportPriority(req.Priority); err != nil {
+			op.finish(ctx, kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}, nil)
+			return
+		}
+		ticket := importTicket{Priority: req.Priority, Problem: s.importProblem(ctx, req.Path)}
+		err = s.imports.Acquire(ctx, ticket, func(position int) {
+			op.send(kitendpoint.Response{Data: ImportQueuedResponseData{Position: position, Priority: ticket.priority()}, Err: kitendpoint.Error{Code: 0}})
+		})
+		if err != nil {
+			redact.Println("update_from_local.UpdateFromLocal.s.imports.Acquire(ctx)", err)
+			code := kitendpoint.ErrCodeUnknown
+			if err == ErrBusy {
+				code = kitendpoint.ErrCodeBusy
+			}
+			op.finish(ctx, kitendpoint.Response{
+				Data: BusyResponseData{RetryAfter: int(importRetryAfter.Seconds())},
+				Err:  kitendpoint.Error{Code: code, Message: err.Error()},
+			}, nil)
 			return
 		}
-		defaultBuild := s.getDefaultBuild(problem.Id)
-		model := s.prepareModel(templateYaml, defaultBuild.Id, problem)
-		copyModelFiles(fp.Dir(req.Path), model.Dir, req.Path, templateYaml)
-		model = s.updateCreateModel(model)
-		responseChan <- kitendpoint.Response{Data: model, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+		defer s.imports.Release(ticket)
+		op.run(ctx)
+		op.finish(ctx, s.updateFromLocal(ctx, req, op, func(done float64, total int) {
+			op.progress(ctx, done/float64(total))
+		}), nil)
 	}()
 	return responseChan
 }
 
-func copyModelFiles(from, to, modelTemplatePath string, modelYml ModelYml) {
-	copyConfig(from, to, modelYml)
-	copyModulesYaml(from, to)
-	copyDependencies(from, to, modelYml)
-	saveMetrics(to, modelYml)
-	copyTemplateYaml(modelTemplatePath, to)
+// importProblem names the problem the template at path imports into, that of
+// its first model, for the import queue to share the slots among problems.
+// Templates that can not be read share the empty name, their imports fail
+// soon enough.
+func (s *basicModelService) importProblem(ctx context.Context, path string) string {
+	if err := s.paths.CheckRead(ctx, path); err != nil {
+		return ""
+	}
+	docs, err := getTemplateDocuments(path)
+	if err != nil || len(docs) == 0 {
+		return ""
+	}
+	return docs[0].Class + "/" + docs[0].Problem
+}
+
+// updateFromLocal imports every model of a multi-document template. The
+// models are imported independently, so one broken variant does not keep the
+// others out; the import fails only when none of them made it. progress, if
+// set, is told how many of the models are done, the model being hashed for
+// its manifest counting for the share hashed.
+func (s *basicModelService) updateFromLocal(ctx context.Context, req UpdateFromLocalRequestData, op *operation, progress func(done float64, total int)) kitendpoint.Response {
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}
+	}
+	if err := req.Options.Validate(); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}
+	}
+	if err := checkWorkspace(req.Workspace); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}
+	}
+	opts := req.Options.merge(s.imports.Options())
+	if err := s.checkImportPaths(ctx, req.Path, opts); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: err.Error()}}
+	}
+	if err := checkTemplateFile(req.Path); err.Code > 0 {
+		return kitendpoint.Response{Data: nil, Err: err}
+	}
+	docs, err := getTemplateDocuments(req.Path)
+	if err == nil && len(docs) == 0 {
+		err = fmt.Errorf("template %s describes no model", req.Path)
+	}
+	if err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}
+	}
+	result := UpdateFromLocalSummary{Imported: []UpdateFromLocalResponseData{}, Failed: []ImportFailure{}}
+	names := make(map[string]bool)
+	for i, doc := range docs {
+		var resp kitendpoint.Response
+		if names[doc.Name] {
+			err := fmt.Errorf("model %q is described more than once in the template", doc.Name)
+			resp = kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}
+		} else {
+			resp = s.importTemplateDocument(ctx, req.Path, doc, tags, req.Workspace, req.Placeholders, opts, op, func(hashed float64) {
+				if progress != nil {
+					progress(float64(i)+hashed, len(docs))
+				}
+			})
+		}
+		names[doc.Name] = true
+		if resp.Err.Code > 0 {
+			redact.Println("update_from_local.updateFromLocal", doc.Name, resp.Err.Message)
+			result.Failed = append(result.Failed, ImportFailure{Name: doc.Name, Code: resp.Err.Code, Message: resp.Err.Message, Fields: resp.Err.Fields})
+		} else {
+			result.Imported = append(result.Imported, resp.Data.(UpdateFromLocalResponseData))
+		}
+		op.send(resp)
+		if progress != nil {
+			progress(float64(i+1), len(docs))
+		}
+	}
+	if len(result.Imported) == 0 {
+		failure := result.Failed[0]
+		message := fmt.Sprintf("none of the %d models of %s was imported, %s: %s", len(docs), req.Path, failure.Name, failure.Message)
+		return kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: failure.Code, Message: message, Fields: failure.Fields}}
+	}
+	return kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 0}}
+}
+
+func (s *basicModelService) importTemplateDocument(ctx context.Context, templatePath string, doc templateDocument, tags []string, workspace string, placeholders map[string]string, opts ImportOptions, op *operation, hashed func(float64)) (resp kitendpoint.Response) {
+	start := time.Now()
+	ctx, span := trace.Start(ctx, "import model")
+	defer span.End()
+	importsStarted.Inc()
+	defer func() {
+		if resp.Err.Code > 0 {
+			importResults.Inc("failed")
+		} else {
+			importResults.Inc("ok")
+		}
+	}()
+	span.SetAttribute("model.name", doc.Name)
+	if err := s.resolveChecksums(ctx, fp.Dir(templatePath), &doc.ModelYml, opts); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: pathErrCode(err, kitendpoint.ErrCodeInvalidArgument), Message: redact.String(err.Error())}}
+	}
+	templateYaml := doc.ModelYml
+	problem, err := s.findProblem(ctx, templateYaml.Problem)
+	if err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: 1, Message: err.Error()}}
+	}
+	if err := access.CheckProblem(ctx, problem, role.Editor); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}}
+	}
+	defaultBuild, err := s.findDefaultBuild(ctx, problem.Id)
+	if err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}}
+	}
+	dir, err := s.modelDir(ctx, problem, workspace, templateYaml.Name, s.findImportedModel(ctx, t.Model{ProblemId: problem.Id, Workspace: workspace, Name: templateYaml.Name}))
+	if err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}}
+	}
+	model, err := s.prepareModel(templateYaml, defaultBuild.Id, problem, dir)
+	if err != nil {
+		return kitendpoint.Response{Data: nil, Err: invalidArgument(err)}
+	}
+	model.Workspace = workspace
+	duplicate, conflict := s.checkNameConflict(ctx, model, isSet(opts.AllowDuplicateNames))
+	if conflict.Code > 0 {
+		return kitendpoint.Response{Data: nil, Err: conflict}
+	}
+	if err := s.checkModelPaths(ctx, fp.Dir(templatePath), model.Dir, templateYaml); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: err.Error()}}
+	}
+	hooks := s.imports.Hooks()
+	if err := hooks.check(templateYaml.PostImport); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}
+	}
+	unlock, err := s.lockDir(ctx, model.Dir, "import")
+	if err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: dirLockErrCode(err), Message: err.Error()}}
+	}
+	defer unlock()
+	values, err := doc.placeholderValues(fp.Dir(templatePath), placeholders)
+	if err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: redact.String(err.Error())}}
+	}
+	doc.placeholders = values
+	model.Tags = tags
+	model.ImportedBy = importedBy(ctx)
+	previous := dirSize(model.Dir)
+	if err := s.repos.Quotas.CheckQuota(ctx, problem.Id, modelFilesSize(fp.Dir(templatePath), doc)-previous); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: quota.ErrCode(err), Message: err.Error()}}
+	}
+	imported := s.findImportedModel(ctx, model)
+	keepValidators(model, imported)
+	// The templates have no license, the one of a model is set by the bulk
+	// updates and kept by the reimports.
+	model.License = imported.License
+	if isSet(opts.RecordChecksums) {
+		recallChecksums(&doc.ModelYml, model, imported)
+	}
+	stage := observeStage("prepare", start)
+	// The files copied stay in the folder of the model whether or not the
+	// import goes through, the problem is charged for them either way.
+	reportUsage := func() ImportStats {
+		stats := dirStats(model.Dir)
+		s.repos.Quotas.ReportUsage(ctx, problem.Id, stats.Bytes-previous)
+		return stats
+	}
+	diff := newImportDiff(model.Dir)
+	warnings, err := s.copyModelFiles(ctx, fp.Dir(templatePath), doc, model.Dependencies, opts, diff)
+	if err != nil {
+		reportUsage()
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: redact.String(err.Error())}}
+	}
+	if duplicate != "" {
+		warnings = append(warnings, duplicate)
+	}
+	if isSet(opts.RecordChecksums) {
+		model.ContentHash = getContentHash(model.Dependencies)
+		if isSet(opts.UpdateTemplate) {
+			if _, err := s.updateTemplateChecksums(ctx, templatePath, model.Name, model.Dependencies); err != nil {
+				redact.Println("update_from_local.importTemplateDocument.s.updateTemplateChecksums(ctx, templatePath, model.Name, model.Dependencies)", err)
+				warnings = append(warnings, redact.String(fmt.Sprintf("template checksums: %v", err)))
+			}
+		}
+	}
+	s.prune(ctx, diff, imported, templateYaml, isSet(opts.Prune))
+	model.ReadmePath, model.Previews = modelAssets(model.Dir, templateYaml)
+	stage = observeStage("copy", stage)
+	if opts.ConfigCheck != ConfigCheckOff {
+		missing, err := checkConfigReferences(model.ConfigPath, model.Dir)
+		if err != nil {
+			redact.Println("update_from_local.importTemplateDocument.checkConfigReferences(model.ConfigPath, model.Dir)", err)
+		}
+		if len(missing) > 0 && opts.ConfigCheck == ConfigCheckStrict {
+			err := fmt.Errorf("model %q: %s", model.Name, strings.Join(missing, "; "))
+			reportUsage()
+			return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}
+		}
+		warnings = append(warnings, missing...)
+	}
+	hookResults, err := hooks.run(ctx, model.Dir, templateYaml.PostImport)
+	if err != nil {
+		err := fmt.Errorf("model %q: %v", model.Name, err)
+		reportUsage()
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: redact.String(err.Error())}}
+	}
+	if len(hookResults) > 0 {
+		stage = observeStage("hooks", stage)
+	}
+	for _, warning := range warnings {
+		op.warn(ctx, "model %q: %s", model.Name, warning)
+	}
+	stats := reportUsage()
+	stats.Hooks = hookResults
+	observeImport(stats)
+	if err := templateYaml.snapshotLayout().checkFiles(model.Dir, templateYaml); err != nil {
+		return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}}
+	}
+	stage = observeStage("check", stage)
+	changes := diff.result()
+	if !isSet(opts.Manifest) && !changes.isEmpty() {
+		// A manifest left by a previous import no longer lists the files.
+		if err := os.Remove(fp.Join(model.Dir, manifestName)); err != nil && !os.IsNotExist(err) {
+			redact.Println("update_from_local.importTemplateDocument.os.Remove(manifest)", err)
+		}
+	}
+	if isSet(opts.Manifest) && (!changes.isEmpty() || diff.manifest == nil) {
+		if err := s.writeManifest(ctx, model.Dir, opts.HashWorkers, hashed); err != nil {
+			redact.Println("update_from_local.importTemplateDocument.writeManifest(ctx, model.Dir, opts.HashWorkers, hashed)", err)
+			warning := fmt.Sprintf("manifest: %v", err)
+			op.warn(ctx, "model %q: %s", model.Name, warning)
+			warnings = append(warnings, warning)
+		}
+		stage = observeStage("manifest", stage)
+	}
+	var hashes map[string]string
+	if isSet(opts.Manifest) {
+		hashes, _ = readManifest(model.Dir)
+	}
+	s.publish(ctx, model.Dir, hashes)
+	stage = observeStage("publish", stage)
+	model.Cas = isSet(opts.Cas)
+	if imported.Id.IsZero() || !changes.isEmpty() || !sameTags(imported.Tags, model.Tags) || imported.Cas != model.Cas {
+		model, err = s.updateCreateModel(ctx, model)
+		if err != nil {
+			return kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}}
+		}
+		s.registerSnapshots(ctx, model)
+		s.emitModel(typeWebhook.ModelImported, model, imported.Id.IsZero())
+	} else {
+		model = imported
+	}
+	observeStage("save", stage)
+	stats.DurationMs = int64(time.Since(start) / time.Millisecond)
+	if warnings == nil {
+		warnings = []string{}
+	}
+	return kitendpoint.Response{
+		Data: UpdateFromLocalResponseData{Model: model, Build: defaultBuild, Stats: stats, Diff: changes, Warnings: warnings},
+		Err:  kitendpoint.Error{Code: 0},
+	}
+}
+
+// copyModelFiles writes the files of the model missing from its folder or
+// changed since the last import, and returns the dependencies that could not
+// be fetched, the model is imported without them, or the first dependency
+// refused by the path policy, which fails the import. stored are the dependencies
+// kept with the model, their validators are updated by the downloads. The
+// own document of the model is stored as its template.yaml, so a model
+// imported from a multi-document template gets a template describing only
+// itself, the whole template being stored next to it.
+func (s *basicModelService) copyModelFiles(ctx context.Context, from string, doc templateDocument, stored []t.Dependency, opts ImportOptions, diff *importDiff) ([]string, error) {
+	_, span := trace.Start(ctx, "copy model files")
+	if err := copyConfig(ctx, from, doc, diff); err != nil {
+		redact.Println("update_from_local.copyModelFiles.copyConfig(ctx, from, doc, diff)", err)
+	}
+	if _, err := diff.copy(ctx, fp.Join(from, "modules.yaml"), "modules.yaml", OverwriteChanged); err != nil {
+		redact.Println("update_from_local.copyModelFiles.diff.copy(fp.Join(from, \"modules.yaml\"), \"modules.yaml\", OverwriteChanged)", err)
+	}
+	span.End()
+	warnings, err := s.copyDependencies(ctx, from, doc.ModelYml, stored, opts, diff)
+	if err != nil {
+		return warnings, err
+	}
+	if err := s.saveMetrics(ctx, diff.dir, doc.ModelYml); err != nil {
+		redact.Println("update_from_local.copyModelFiles.saveMetrics(ctx, diff.dir, doc.ModelYml)", err)
+	}
+	copyAssets(ctx, from, doc.ModelYml, diff)
+	if isSet(opts.RecordChecksums) {
+		raw, err := withChecksums(doc.raw, stored)
+		if err != nil {
+			redact.Println("update_from_local.copyModelFiles.withChecksums(doc.raw, stored)", err)
+		}
+		doc.raw = raw
+	}
+	if err := diff.write(ctx, "template.yaml", doc.raw); err != nil {
+		redact.Println("update_from_local.copyModelFiles.diff.write(\"template.yaml\", doc.raw)", err)
+	}
+	if err := diff.write(ctx, originalTemplateName, doc.original); err != nil {
+		redact.Println("update_from_local.copyModelFiles.diff.write(originalTemplateName, doc.original)", err)
+	}
+	return warnings, nil
 }
 
-func copyConfig(from, to string, modelYml ModelYml) {
-	if err := copyFiles(fp.Join(from, modelYml.Config), fp.Join(to, modelYml.Config)); err != nil {
-		log.Println("update_from_local.copyDependencies.copyFiles(fp.Join(from, modelYml.Config), fp.Join(to, modelYml.Config))", err)
+// checkImportPaths refuses templates outside the template roots and download
+// folders outside the data roots.
+func (s *basicModelService) checkImportPaths(ctx context.Context, templatePath string, opts ImportOptions) error {
+	if err := s.paths.CheckTemplate(ctx, templatePath); err != nil {
+		return err
+	}
+	if opts.TempDir != "" {
+		return s.paths.CheckWrite(ctx, opts.TempDir)
 	}
+	return nil
+}
+
+// checkTemplateFile tells the template at path is missing, or is a folder
+// given in place of a template.
+func checkTemplateFile(path string) kitendpoint.Error {
+	if path == "" {
+		return kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: "path is required"}
+	}
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		return kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: fmt.Sprintf("template %s not found", path)}
+	case err != nil:
+		return kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}
+	case info.IsDir():
+		return kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: fmt.Sprintf("%s is a folder, not a template: import the templates under it with ImportDirectory", path)}
+	case !info.Mode().IsRegular():
+		return kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: fmt.Sprintf("template %s is not a regular file", path)}
+	}
+	return kitendpoint.Error{Code: 0}
+}
+
+// checkModelPaths refuses an import whose config, modules file, assets or
+// dependencies, or whose model folder, lie outside the roots. An absolute
+// config is read where it is, so it must be under a root as well.
+func (s *basicModelService) checkModelPaths(ctx context.Context, from, to string, modelYml ModelYml) error {
+	if err := s.paths.CheckWrite(ctx, to); err != nil {
+		return err
+	}
+	if err := s.paths.CheckRead(ctx, sourcePath(from, modelYml.Config)); err != nil {
+		return err
+	}
+	if err := s.paths.CheckWrite(ctx, fp.Join(to, modelYml.configName())); err != nil {
+		return err
+	}
+	if err := s.paths.CheckRead(ctx, fp.Join(from, "modules.yaml")); err != nil {
+		return err
+	}
+	if err := s.paths.CheckWrite(ctx, fp.Join(to, "modules.yaml")); err != nil {
+		return err
+	}
+	for _, d := range modelYml.Dependencies {
+		if err := s.checkDependencySource(ctx, from, to, d); err != nil {
+			return err
+		}
+	}
+	return s.checkAssetPaths(ctx, from, to, modelYml)
+}
+
+// checkDependencySource refuses a dependency written outside the data roots or
+// copied from a file outside the roots. Downloads and links to other models
+// have no source on disk.
+func (s *basicModelService) checkDependencySource(ctx context.Context, from, to string, d t.Dependency) error {
+	if err := s.paths.CheckWrite(ctx, fp.Join(to, d.Destination)); err != nil {
+		return err
+	}
+	if !isValidUrl(d.Source) && !isModelSource(d.Source) {
+		return s.paths.CheckRead(ctx, sourcePath(from, d.Source))
+	}
+	return nil
+}
+
+// sourcePath is the file a template in the folder from names: name itself
+// when it is absolute, such as a config shared by several templates, name in
+// from otherwise.
+func sourcePath(from, name string) string {
+	if fp.IsAbs(name) {
+		return fp.Clean(name)
+	}
+	return fp.Join(from, name)
+}
+
+// configName is the path of the config in the model folder, an absolute
+// config being copied there under its base name.
+func (m ModelYml) configName() string {
+	if fp.IsAbs(m.Config) {
+		return fp.Base(m.Config)
+	}
+	return m.Config
 }
 
 func copyModulesYaml(from, to string) {
 	modulesYaml := "modules.yaml"
 	if err := copyFiles(fp.Join(from, modulesYaml), fp.Join(to, modulesYaml)); err != nil {
-		log.Println("update_from_local.copyDependencies.copyFiles(fp.Join(from,modulesYaml), fp.Join(to, modulesYaml))", err)
+		redact.Println("update_from_local.copyDependencies.copyFiles(fp.Join(from,modulesYaml), fp.Join(to, modulesYaml))", err)
 	}
 }
 
+// copyTemplateYaml stores the template byte for byte, so the original key
+// order is kept. Rewriting it must go through yaml.MapSlice, since yaml.v2
+// sorts the keys of maps on marshal.
 func copyTemplateYaml(from, to string) string {
 	templateYamlPath := fp.Join(to, "template.yaml")
 	if err := copyFiles(from, templateYamlPath); err != nil {
-		log.Println("update_from_local.copyDependencies.copyFiles(fp.Join(from, modelYml.Config), fp.Join(to, modelYml.Config))", err)
+		redact.Println("update_from_local.copyDependencies.copyFiles(fp.Join(from, modelYml.Config), fp.Join(to, modelYml.Config))", err)
 	}
 	return templateYamlPath
 }
 
-func copyDependencies(from, to string, modelYml ModelYml) {
-	for _, d := range modelYml.Dependencies {
-		toPath := fp.Join(to, d.Destination)
-		if isValidUrl(d.Source) {
-			if err := downloadWithCheck(d.Source, toPath, d.Sha256, d.Size); err != nil {
-				log.Println("update_from_local.copyDependencies.downloadWithCheck(d.Source, d.Destination, d.Sha256, d.Size)", err)
-			}
-		} else {
-			if err := copyFiles(fp.Join(from, d.Source), toPath); err != nil {
-				log.Println("update_from_local.copyDependencies.copyFiles(fp.Join(from, d.Source), fp.Join(to, d.Destination))", err)
+// copyDependencies fetches up to opts.Concurrency dependencies at once. The
+// dependencies that could not be fetched are warnings, a dependency refused
+// by the path policy is the error.
+func (s *basicModelService) copyDependencies(ctx context.Context, from string, modelYml ModelYml, stored []t.Dependency, opts ImportOptions, diff *importDiff) (warnings []string, err error) {
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	errs := make([]error, len(modelYml.Dependencies))
+	pool := workpool.New(workpool.Options{Workers: concurrency, Hooks: workpool.Metrics("model_dependencies")})
+	for i, d := range modelYml.Dependencies {
+		i, d := i, d
+		err := pool.Go(ctx, func(ctx context.Context) {
+			errs[i] = s.copyDependency(ctx, from, d, &stored[i], opts, diff)
+			if errs[i] == nil && !isModelSource(d.Source) {
+				if isSet(opts.RecordChecksums) {
+					recordChecksum(&stored[i], fp.Join(diff.dir, d.Destination))
+				}
+				stored[i].Sample = takeSample(fp.Join(diff.dir, d.Destination), opts.SampleRegions)
 			}
+		})
+		if err != nil {
+			errs[i] = err
+		}
+	}
+	pool.Close(context.Background())
+	for i, e := range errs {
+		var violation *PathViolationError
+		if errors.As(e, &violation) && err == nil {
+			err = fmt.Errorf("dependency %s: %w", modelYml.Dependencies[i].Destination, e)
+		} else if e != nil {
+			warnings = append(warnings, redact.String(fmt.Sprintf("dependency %s: %v", modelYml.Dependencies[i].Destination, e)))
 		}
 	}
+	return warnings, err
 }
 
-func saveMetrics(to string, modelYml ModelYml) {
-	type MetricsYaml struct {
-		Metrics []t.Metric `yaml:"metrics"`
+// copyDependency fetches the dependency d within the dependency timeout of
+// opts.
+func (s *basicModelService) copyDependency(ctx context.Context, from string, d t.Dependency, stored *t.Dependency, opts ImportOptions, diff *importDiff) (err error) {
+	ctx, span := trace.Start(ctx, "dependency")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	span.SetAttribute("dependency.destination", d.Destination)
+	if timeout := opts.dependencyTimeout(); timeout > 0 {
+		parent := ctx
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		defer func() {
+			if err != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+				err = fmt.Errorf("timed out after %s: %v", timeout, err)
+			}
+		}()
 	}
-	metricsPath := fp.Join(to, "_default", "metrics.yaml")
-	if err := os.MkdirAll(fp.Dir(metricsPath), 0777); err != nil {
-		log.Println("saveMetrics.os.MkdirAll(fp.Dir(metricsPath), 0777)", err)
+	toPath := fp.Join(diff.dir, d.Destination)
+	if err = s.checkDependencySource(ctx, from, diff.dir, d); err != nil {
+		return err
 	}
-	f, err := os.Create(metricsPath)
-	if err != nil {
-		log.Println("saveMetrics.os.Create(metricsPath)", err)
+	if diff.keeps(d.Destination, d.Sha256, int64(d.Size), opts.Overwrite) {
+		dependencyCopies.Inc("kept")
+		return nil
 	}
-	metrics, err := yaml.Marshal(MetricsYaml{modelYml.Metrics})
-	if err != nil {
-		log.Println("saveMetrics.yaml.Marshal(modelYml.Metrics)", err)
+	if isModelSource(d.Source) {
+		err = diff.fetched(d.Destination, func() error { return s.linkModelDependency(ctx, d, toPath) })
+		if err != nil {
+			redact.Println("update_from_local.copyDependency.s.linkModelDependency(ctx, d, toPath)", err)
+		}
+	} else if isValidUrl(d.Source) {
+		err = diff.fetched(d.Destination, func() error {
+			if d.Sha256 != "" && s.linkCached(ctx, d.Sha256, int64(d.Size), toPath) {
+				redact.Println("update_from_local.copyDependency: linked from the snapshot store", d.Destination)
+				dependencyCopies.Inc("cached")
+				return nil
+			}
+			v, err := downloadWithCheck(ctx, d.Source, toPath, d.Sha256, d.Size, sniffs(d), opts, validatorsOf(*stored))
+			if err == nil {
+				stored.ETag, stored.LastModified = v.ETag, v.LastModified
+			}
+			return err
+		})
+		if err != nil {
+			redact.Println("update_from_local.copyDependency.downloadWithCheck(ctx, d.Source, toPath, d.Sha256, d.Size, opts)", err)
+		}
+	} else {
+		copied := 0
+		copied, err = diff.copy(ctx, sourcePath(from, d.Source), d.Destination, opts.Overwrite)
+		if err != nil {
+			redact.Println("update_from_local.copyDependency.diff.copy(sourcePath(from, d.Source), d.Destination, opts.Overwrite)", err)
+		}
+		if err == nil && copied == 0 {
+			dependencyCopies.Inc("kept")
+		} else {
+			dependencyCopies.Inc(resultOf(err))
+		}
 	}
-	_, err = f.Write(metrics)
-	if err != nil {
-		log.Println("saveMetrics.f.Write(metrics)", err)
+	return err
+}
+
+// keepExisting tells whether the overwrite policy leaves the file already at
+// dst in place. Under OverwriteChanged the file is kept when it has the
+// declared size and sha256, the size being compared first as it is cheap.
+func keepExisting(dst, sha string, size int64, opts ImportOptions) bool {
+	if _, err := os.Lstat(dst); err != nil {
+		return false
 	}
-	if err := f.Sync(); err != nil {
-		log.Println("saveMetrics.f.Sync()", err)
+	switch opts.Overwrite {
+	case OverwriteNever:
+		return true
+	case OverwriteChanged:
+		return sha != "" && checkFile(dst, sha, size) == nil
 	}
+	return false
+}
+
+// modelFilesSize estimates the bytes copyModelFiles writes: the local files
+// it copies plus the declared size of downloaded dependencies. Dependencies
+// on other models are linked and take no space.
+func modelFilesSize(from string, doc templateDocument) int64 {
+	size := int64(len(doc.raw)) + dirSize(sourcePath(from, doc.Config)) + dirSize(fp.Join(from, "modules.yaml")) + assetsSize(from, doc.ModelYml)
+	for _, d := range doc.Dependencies {
+		if isModelSource(d.Source) {
+			continue
+		} else if isValidUrl(d.Source) {
+			size += int64(d.Size)
+		} else {
+			size += dirSize(sourcePath(from, d.Source))
+		}
+	}
+	return size
+}
+
+// dirStats counts the regular files under dir and their bytes, as dirSize.
+func dirStats(dir string) (stats ImportStats) {
+	err := fp.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			stats.Files++
+			stats.Bytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		redact.Println("update_from_local.dirStats.fp.Walk(dir)", err)
+	}
+	return stats
+}
+
+func dirSize(path string) int64 {
+	size, err := uFiles.DirSize(path)
+	if err != nil && !os.IsNotExist(err) {
+		redact.Println("update_from_local.dirSize.uFiles.DirSize(path)", err)
+	}
+	return size
+}
+
+// isInsideDir tells whether the relative path stays inside the folder it is
+// joined to.
+func isInsideDir(path string) bool {
+	path = fp.Clean(path)
+	return path != "." && !fp.IsAbs(path) && path != ".." && !strings.HasPrefix(path, ".."+string(fp.Separator))
 }
 
 func copyFiles(from, to string) error {
+	return copyFilesContext(context.Background(), from, to)
+}
+
+// copyFilesContext is copyFiles stopping once ctx is done. A folder is
+// copied whole whatever ctx.
+func copyFilesContext(ctx context.Context, from, to string) error {
 	si, err := os.Stat(from)
 	if err != nil {
-		log.Println("update_from_local.copyFiles.os.Stat(from)", err)
+		redact.Println("update_from_local.copyFiles.os.Stat(from)", err)
 		return err
 	}
 	if si.IsDir() {
 		if err := uFiles.CopyDir(from, to); err != nil {
-			log.Println("update_from_local.copyFiles.uFiles.CopyDir(from, to)", err)
+			redact.Println("update_from_local.copyFiles.uFiles.CopyDir(from, to)", err)
 		}
 	} else {
-		if _, err := uFiles.Copy(from, to); err != nil {
-			log.Println("update_from_local.copyFiles.uFiles.Copy(from, to)", err)
+		stats, err := uFiles.CopyContext(ctx, from, to, uFiles.GetCopyOptions())
+		if err != nil {
+			redact.Println("update_from_local.copyFiles.uFiles.CopyWith(from, to)", err)
 			return err
 		}
+		if stats.Bytes >= 1<<20 {
+			redact.Printf("update_from_local.copyFiles: copied %s, %d bytes in %s (%.1f MB/s)", to, stats.Bytes, stats.Duration, stats.BytesPerSecond()/(1<<20))
+		}
 	}
 	return nil
 }
@@ -174,9 +813,34 @@ func isValidUrl(toTest string) bool {
 	return true
 }
 
-func (s *basicModelService) prepareModel(modelYml ModelYml, buildId primitive.ObjectID, problem t.Problem) t.Model {
-	modelFolderName := u.StringToFolderName(modelYml.Name)
-	dir := fp.Join(problem.Dir, modelFolderName)
+// urlHost keeps the host of a download url for the traces, the query may hold
+// credentials.
+func urlHost(source string) string {
+	u, err := url.Parse(source)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// prepareModel describes the model of modelYml, its files being in dir.
+func (s *basicModelService) prepareModel(modelYml ModelYml, buildId primitive.ObjectID, problem t.Problem, dir string) (t.Model, error) {
+	basic, applied := mergeHyperParameters(modelYml.HyperParameters.Basic, problem.DefaultHyperParameters)
+	if len(applied) > 0 {
+		redact.Printf("update_from_local.prepareModel: model %q inherits %s from problem %q", modelYml.Name, strings.Join(applied, ", "), problem.Title)
+	}
+	layout := modelYml.snapshotLayout()
+	var issues []TemplateIssue
+	for _, name := range layout.Files {
+		if !isInsideDir(name) {
+			issues = append(issues, TemplateIssue{Field: "snapshot", Message: fmt.Sprintf("%q must be a path inside the model folder", name)})
+		}
+	}
+	issues = append(issues, checkDestinations(modelYml.Dependencies)...)
+	issues = append(issues, checkBasic(basic, problem)...)
+	if len(issues) > 0 {
+		return t.Model{}, &InvalidTemplateError{Model: modelYml.Name, Issues: issues}
+	}
 	metrics := make(map[string][]t.Metric)
 	metrics[buildId.Hex()] = modelYml.Metrics
 	evaluates := make(map[string]t.Evaluate)
@@ -185,144 +849,545 @@ func (s *basicModelService) prepareModel(modelYml ModelYml, buildId primitive.Ob
 		Status:  statusModelEvaluate.Default,
 	}
 	model := t.Model{
-		BatchSize:       modelYml.HyperParameters.Basic.BatchSize,
-		ConfigPath:      fp.Join(dir, modelYml.Config),
+		BatchSize:       basic.BatchSize,
+		ConfigPath:      fp.Join(dir, modelYml.configName()),
+		ContentHash:     getContentHash(modelYml.Dependencies),
 		ProblemId:       problem.Id,
 		Description:     "",
 		Dir:             dir,
-		Epochs:          modelYml.HyperParameters.Basic.Epochs,
+		Dependencies:    storedDependencies(modelYml.Dependencies),
+		Epochs:          basic.Epochs,
 		Evaluates:       evaluates,
+		Framework:       modelYml.Framework,
 		ModulesYamlPath: fp.Join(dir, "modules.yaml"),
 		Name:            modelYml.Name,
 		Scripts: t.Scripts{
 			Train: fp.Join(dir, "train.py"),
 			Eval:  fp.Join(dir, "eval.py"),
 		},
-		SnapshotPath:   fp.Join(dir, "snapshot.pth"),
 		Status:         statusModelTrain.Default,
 		TemplatePath:   fp.Join(dir, "template.yaml"),
 		TrainingGpuNum: modelYml.GpuNum,
 	}
-	log.Println("Epochs:", modelYml.HyperParameters.Basic.Epochs, model.Epochs)
-	return model
+	layout.setSnapshot(&model, dir)
+	redact.Println("Epochs:", modelYml.HyperParameters.Basic.Epochs, model.Epochs)
+	return model, nil
 }
 
-func getTemplateYaml(path string) (modelYml ModelYml) {
-	yamlFile, err := ioutil.ReadFile(path)
-	if err != nil {
-		log.Println("ReadFile", err)
+// mergeHyperParameters fills the unset basic hyperparameters of a template
+// with the problem defaults and names the fields it filled.
+func mergeHyperParameters(basic Basic, defaults t.HyperParameters) (Basic, []string) {
+	var applied []string
+	if basic.BatchSize <= 0 && defaults.BatchSize > 0 {
+		basic.BatchSize = defaults.BatchSize
+		applied = append(applied, fmt.Sprintf("batch_size=%d", basic.BatchSize))
 	}
-	err = yaml.Unmarshal(yamlFile, &modelYml)
+	if basic.BaseLearningRate <= 0 && defaults.BaseLearningRate > 0 {
+		basic.BaseLearningRate = defaults.BaseLearningRate
+		applied = append(applied, fmt.Sprintf("base_learning_rate=%v", basic.BaseLearningRate))
+	}
+	if basic.Epochs <= 0 && defaults.Epochs > 0 {
+		basic.Epochs = defaults.Epochs
+		applied = append(applied, fmt.Sprintf("epochs=%d", basic.Epochs))
+	}
+	return basic, applied
+}
 
+// getTemplateYaml returns the first model of the template.
+func getTemplateYaml(path string) (modelYml ModelYml) {
+	docs, err := getTemplateDocuments(path)
 	if err != nil {
-		log.Println("Unmarshal", err)
+		redact.Println("update_from_local.getTemplateYaml.getTemplateDocuments(path)", err)
+	}
+	if len(docs) > 0 {
+		modelYml = docs[0].ModelYml
 	}
-	log.Println("Model BatchSize", modelYml.HyperParameters.Basic.BatchSize)
+	redact.Println("Model BatchSize", modelYml.HyperParameters.Basic.BatchSize)
 	return modelYml
 }
 
-func (s *basicModelService) getProblem(ctx context.Context, title string) (t.Problem, error) {
-	problemResp := <-problemFindOne.Send(
-		ctx,
-		s.Conn,
-		problemFindOne.RequestData{
-			Title: title,
-		},
-	)
-	var err error = nil
-	if problemResp.Err.Code > 0 {
-		err = fmt.Errorf(problemResp.Err.Message)
+// templateDocument is one model of a template together with its source.
+// original is the whole template when it describes several models,
+// placeholders the values of its placeholders once checked.
+type templateDocument struct {
+	ModelYml
+	raw          []byte
+	original     []byte
+	placeholders map[string]string
+}
+
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---[ \t]*(#.*)?$`)
+
+// getTemplateDocuments splits a template into its "---" separated documents,
+// one model variant each. Documents holding only blanks are skipped. The
+// fragments the documents include are read into them, the raw document
+// being the one written with them.
+func getTemplateDocuments(path string) ([]templateDocument, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
-	return problemResp.Data.(problemFindOne.ResponseData), err
+	var docs []templateDocument
+	for i, part := range yamlDocumentSeparator.Split(string(content), -1) {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		raw, err := resolveIncludes([]byte(strings.TrimLeft(part, "\n")), fp.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("document %d of %s: %v", i+1, path, err)
+		}
+		var modelYml ModelYml
+		if err := yaml.Unmarshal(raw, &modelYml); err != nil {
+			return nil, fmt.Errorf("document %d of %s: %v", i+1, path, err)
+		}
+		docs = append(docs, templateDocument{ModelYml: modelYml, raw: raw})
+	}
+	if len(docs) > 1 {
+		for i := range docs {
+			docs[i].original = content
+		}
+	}
+	return docs, nil
 }
 
-func downloadWithCheck(url, dst, sha256 string, size int) error {
-	for i := 0; i < 10; i++ {
-		nBytes, err := u.DownloadFile(url, dst)
+// getProblem finds the problem a template names, ref being its title or the
+// hex of its id. A ref of the form of an id is looked up as an id first, as
+// a title then.
+func (s *basicModelService) getProblem(ctx context.Context, ref string) (t.Problem, error) {
+	if id, err := primitive.ObjectIDFromHex(ref); err == nil {
+		problem, err := s.repos.Problems.FindProblem(ctx, problemFindOne.RequestData{Id: id})
 		if err != nil {
-			log.Println("downloadWithCheck.DownloadFile", err)
-			continue
+			return problem, fmt.Errorf("finding problem %q: %v", ref, err)
 		}
-		log.Println(dst, nBytes)
-		if nBytes != int64(size) {
-			log.Println("downloadWithCheck.WrongSize", err)
-			err = errors.New("wrong size")
-			continue
+		if !problem.Id.IsZero() {
+			return problem, nil
 		}
-		dstSha265 := getSha265(dst)
-		if dstSha265 != sha256 {
-			log.Println("downloadWithCheck.WrongSha", err)
-			err = errors.New("wrong sha")
-			continue
+	}
+	problem, err := s.repos.Problems.FindProblem(ctx, problemFindOne.RequestData{Title: ref})
+	if err != nil {
+		return problem, fmt.Errorf("finding problem %q: %v", ref, err)
+	}
+	if problem.Id.IsZero() {
+		return problem, fmt.Errorf("no problem has the title or id %q", ref)
+	}
+	return problem, nil
+}
+
+// downloadWithCheck fetches url to dst following the retry, limit and verify
+// policy of opts. dst is only replaced by a download that passed the checks.
+// The validators of the file at dst, when known, make the server answer 304
+// Not Modified rather than send it again, dst is then kept. The validators
+// of the file left at dst are returned. A download that is empty or an HTML
+// page fails when sniff is set. Only the transport errors and the downloads
+// of the wrong size, cut short on their way, are retried: a download of the
+// expected size whose sha256 is wrong fails at once with ErrChecksumMismatch,
+// the same url sending the same file again.
+func downloadWithCheck(ctx context.Context, url, dst, sha256 string, size int, sniff bool, opts ImportOptions, cached validators) (v validators, err error) {
+	if err := opts.checkSource(url, int64(size)); err != nil {
+		downloads.Inc("rejected")
+		return cached, err
+	}
+	if keepExisting(dst, sha256, int64(size), opts) {
+		redact.Println("downloadWithCheck: cache hit, keeping", dst)
+		downloads.Inc("cached")
+		return cached, nil
+	}
+	if !cached.isSet() || checkFile(dst, "", int64(size)) != nil {
+		cached = validators{}
+	}
+	notModified := false
+	defer func() {
+		if notModified {
+			downloads.Inc("not_modified")
+		} else {
+			downloads.Inc(downloadResultOf(err))
+		}
+	}()
+	for attempt := 1; attempt <= opts.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			downloadRetries.Inc()
+			wait := opts.backoff(attempt - 1)
+			var busy *retryAfterError
+			if errors.As(err, &busy) {
+				wait = busy.wait
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return cached, ctx.Err()
+			}
+		}
+		v, err = downloadOnce(ctx, url, dst, sha256, int64(size), sniff, opts, cached)
+		if err == errNotModified {
+			redact.Println("downloadWithCheck: not modified, keeping", dst)
+			notModified = true
+			return cached, nil
+		}
+		if err == nil {
+			return v, nil
+		}
+		redact.Println("downloadWithCheck.downloadOnce", url, attempt, err)
+		var mismatch *checksumError
+		if errors.As(err, &mismatch) {
+			if mismatch.wrongSize() {
+				downloadFailures.Inc("size")
+				continue
+			}
+			downloadFailures.Inc("mismatch")
+			return cached, fmt.Errorf("%w: the template is out of date or the file changed upstream", err)
+		}
+		downloadFailures.Inc("error")
+	}
+	return cached, err
+}
+
+func downloadOnce(ctx context.Context, url, dst, sha256 string, size int64, sniff bool, opts ImportOptions, cached validators) (v validators, err error) {
+	ctx, span := trace.Start(ctx, "download")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	span.SetAttribute("download.host", urlHost(url))
+	tmpDir := opts.TempDir
+	if tmpDir == "" {
+		tmpDir = fp.Dir(dst)
+	}
+	fs := uFiles.GetFS()
+	if err := fs.MkdirAll(tmpDir, 0777); err != nil {
+		return v, err
+	}
+	// The download holds its file open while it lasts, released before the
+	// file is moved, which may copy it.
+	release, err := uFiles.AcquireFiles(ctx, 1)
+	if err != nil {
+		return v, err
+	}
+	f, err := fs.TempFile(tmpDir, ".download-")
+	if err != nil {
+		release()
+		return v, err
+	}
+	uFiles.RegisterTemp(ctx, f.Name())
+	defer fs.Remove(f.Name())
+	if timeout := opts.downloadTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	nBytes, v, err := fetchUrl(ctx, url, f, opts.MaxDownloadBytes, opts.userAgent(), cached)
+	downloadedBytes.Add(float64(nBytes))
+	span.SetAttribute("download.bytes", nBytes)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	release()
+	if err != nil {
+		return v, err
+	}
+	redact.Println(dst, nBytes)
+	if sniff {
+		if err := sniffDownload(f.Name()); err != nil {
+			return v, err
+		}
+	}
+	switch opts.Verify {
+	case VerifyStrict:
+		err = checkFile(f.Name(), sha256, size)
+	case VerifySize:
+		err = checkFile(f.Name(), "", size)
+	}
+	if err != nil {
+		return v, err
+	}
+	return v, moveFile(f.Name(), dst)
+}
+
+// ErrChecksumMismatch is a file whose size or sha256 is not the expected
+// one. A download of the expected size failing so is not retried: the file
+// is whole, it is the template or the upstream file that changed.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksumError is the ErrChecksumMismatch of a file, telling what of it,
+// "size" or "sha256", was expected and what it actually is.
+type checksumError struct {
+	what     string
+	expected string
+	actual   string
+}
+
+// wrongSize tells whether the file is not of the expected size, as a
+// download cut short or garbled on its way.
+func (e *checksumError) wrongSize() bool {
+	return e.what == "size"
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("%s mismatch: expected %s, got %s", e.what, e.expected, e.actual)
+}
+
+func (e *checksumError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// checkFile compares the size and sha256 of path with the expected ones,
+// skipping the checks whose expectation is empty. A mismatch is a
+// checksumError.
+func checkFile(path, sha string, size int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if size > 0 && info.Size() != size {
+		return &checksumError{what: "size", expected: fmt.Sprint(size), actual: fmt.Sprint(info.Size())}
+	}
+	if sha != "" {
+		if got := getSha265(path); got != sha {
+			return &checksumError{what: "sha256", expected: sha, actual: got}
 		}
-		break
 	}
 	return nil
+}
+
+// retryAfterError is a 429 or 503 answer telling how long to wait before
+// trying again.
+type retryAfterError struct {
+	status string
+	wait   time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("unexpected status %s, retry after %s", e.status, e.wait)
+}
+
+// fetchUrl writes the body of url to w, failing once more than limit bytes
+// arrive when limit is set. The request is made conditional on the cached
+// validators, errNotModified tells that the server has nothing newer.
+func fetchUrl(ctx context.Context, url string, w io.Writer, limit int64, userAgent string, cached validators) (int64, validators, error) {
+	if storage.IsObjectUrl(url) {
+		nBytes, err := fetchObject(ctx, url, w, limit)
+		return nBytes, validators{}, err
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, validators{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+	u.Authorize(req)
+	resp, err := u.DoDownload(req.WithContext(ctx))
+	if err != nil {
+		return 0, validators{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached.isSet() {
+		return 0, cached, errNotModified
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return 0, validators{}, &retryAfterError{status: resp.Status, wait: wait}
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, validators{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	v := validators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	var body io.Reader = resp.Body
+	if limit > 0 {
+		body = io.LimitReader(resp.Body, limit+1)
+	}
+	nBytes, err := io.Copy(w, body)
+	if err == nil && limit > 0 && nBytes > limit {
+		err = fmt.Errorf("download exceeds the limit of %d bytes", limit)
+	}
+	return nBytes, v, err
+}
 
+// fetchObject writes the object of the s3:// or gs:// url to w, as fetchUrl.
+// The objects are fetched whole, without validators.
+func fetchObject(ctx context.Context, url string, w io.Writer, limit int64) (int64, error) {
+	r, size, err := storage.OpenObject(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	if limit > 0 && size > limit {
+		return 0, fmt.Errorf("download exceeds the limit of %d bytes", limit)
+	}
+	var body io.Reader = r
+	if limit > 0 {
+		body = io.LimitReader(r, limit+1)
+	}
+	nBytes, err := io.Copy(w, body)
+	if err == nil && limit > 0 && nBytes > limit {
+		err = fmt.Errorf("download exceeds the limit of %d bytes", limit)
+	}
+	return nBytes, err
+}
+
+// parseRetryAfter reads a Retry-After value, either seconds or an HTTP date,
+// as a wait from now bounded by maxRetryAfter.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = date.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+	} else {
+		return 0, false
+	}
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+	return wait, true
+}
+
+// moveFile renames from to to, copying when they are on different devices.
+func moveFile(from, to string) error {
+	fs := uFiles.GetFS()
+	if err := fs.MkdirAll(fp.Dir(to), 0777); err != nil {
+		return err
+	}
+	if err := fs.Rename(from, to); err == nil {
+		return nil
+	}
+	_, err := uFiles.Copy(from, to)
+	return err
 }
 
 func getSha265(path string) string {
 	f, err := os.Open(path)
 	if err != nil {
-		log.Println("getSha265.os.Open(path)", err)
+		redact.Println("getSha265.os.Open(path)", err)
 		return ""
 	}
 	defer f.Close()
 	h := sha256.New()
 	if _, err := io.Copy(h, f); err != nil {
-		log.Println("getSha265.io.Copy(h, f)", err)
+		redact.Println("getSha265.io.Copy(h, f)", err)
 		return ""
 	}
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-func (s *basicModelService) updateCreateModel(model t.Model) t.Model {
-	log.Println("updateCreateModel.Epochs", model.Epochs)
-	modelResp := <-modelUpdateUpsert.Send(
-		context.TODO(),
-		s.Conn,
-		modelUpdateUpsert.RequestData{
+// storedDependencies copies dependencies with their sources redacted, the
+// credentials a download url may embed are not kept with the model.
+func storedDependencies(dependencies []t.Dependency) []t.Dependency {
+	stored := make([]t.Dependency, len(dependencies))
+	for i, d := range dependencies {
+		stored[i] = storedDependency(d)
+	}
+	return stored
+}
+
+func storedDependency(d t.Dependency) t.Dependency {
+	d.SourceHash = redact.Hash(d.Source)
+	d.Source = redact.Url(d.Source)
+	d.Checksums = redact.Url(d.Checksums)
+	return d
+}
+
+// getContentHash fingerprints the model artifacts by their destinations and
+// checksums, so it changes whenever any dependency is replaced.
+func getContentHash(dependencies []t.Dependency) string {
+	entries := make([]string, 0, len(dependencies))
+	for _, d := range dependencies {
+		entries = append(entries, d.Destination+":"+d.Sha256)
+	}
+	sort.Strings(entries)
+	h := sha256.New()
+	for _, e := range entries {
+		io.WriteString(h, e+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// updateCreateModel saves model, inserting it when it is not yet.
+func (s *basicModelService) updateCreateModel(ctx context.Context, model t.Model) (t.Model, error) {
+	redact.Println("updateCreateModel.Epochs", model.Epochs)
+	saved, err := s.repos.Models.UpsertModel(
+		ctx,
+		s.storedModelWithoutId(modelUpdateUpsert.RequestData{
 			ConfigPath:      model.ConfigPath,
 			BatchSize:       model.BatchSize,
+			Cas:             model.Cas,
+			ContentHash:     model.ContentHash,
 			Description:     model.Description,
 			Dir:             model.Dir,
+			Dependencies:    model.Dependencies,
 			Epochs:          model.Epochs,
 			Evaluates:       model.Evaluates,
 			Framework:       model.Framework,
+			ImportedBy:      model.ImportedBy,
+			License:         model.License,
 			ModulesYamlPath: model.ModulesYamlPath,
 			Name:            model.Name,
+			Previews:        model.Previews,
+			ReadmePath:      model.ReadmePath,
 			Scripts:         model.Scripts,
+			SnapshotFormat:  model.SnapshotFormat,
 			SnapshotPath:    model.SnapshotPath,
 			Status:          model.Status,
 			ProblemId:       model.ProblemId,
+			Tags:            model.Tags,
 			TemplatePath:    model.TemplatePath,
 			TrainingGpuNum:  model.TrainingGpuNum,
-		},
+			WeightsPath:     model.WeightsPath,
+			Workspace:       model.Workspace,
+		}),
 	)
-	return modelResp.Data.(modelUpdateUpsert.ResponseData)
+	if err != nil {
+		return model, fmt.Errorf("saving model %q: %v", model.Name, err)
+	}
+	return s.localModel(saved), nil
+}
+
+// importedBy names the authenticated user behind ctx, empty for requests the
+// services make on their own.
+func importedBy(ctx context.Context) string {
+	identity, _ := auth.FromContext(ctx)
+	return identity.User
 }
 
-func (s *basicModelService) getDefaultBuild(problemId primitive.ObjectID) (result t.Build) {
-	buildFindOneResp := <-buildFindOne.Send(
-		context.TODO(),
-		s.Conn,
+// getDefaultBuild finds the default build of the problem, creating it when
+// the problem has none.
+func (s *basicModelService) getDefaultBuild(ctx context.Context, problemId primitive.ObjectID) (t.Build, error) {
+	result, err := s.repos.Builds.FindBuild(
+		ctx,
 		buildFindOne.RequestData{
 			ProblemId: problemId,
 			Name:      "default",
 		},
 	)
-	result = buildFindOneResp.Data.(buildFindOne.ResponseData)
+	if err != nil {
+		return result, fmt.Errorf("finding the default build of problem %s: %v", problemId.Hex(), err)
+	}
 	if result.Id.IsZero() {
-		buildInsertOneResp := <-buildInsertOne.Send(
-			context.TODO(),
-			s.Conn,
+		result, err = s.repos.Builds.InsertBuild(
+			ctx,
 			buildInsertOne.RequestData{
 				ProblemId: problemId,
 				Name:      "default",
 				Status:    buildStatus.Default,
 			},
 		)
-		result = buildInsertOneResp.Data.(buildInsertOne.ResponseData)
+		if err != nil {
+			return result, fmt.Errorf("creating the default build of problem %s: %v", problemId.Hex(), err)
+		}
 	}
-	return result
+	return result, nil
 }
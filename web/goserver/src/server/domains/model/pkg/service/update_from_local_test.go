@@ -0,0 +1,318 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	buildFindOne "server/db/pkg/handler/build/find_one"
+	t "server/db/pkg/types"
+	"server/domains/model/pkg/service"
+	"server/domains/model/pkg/service/memory"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/faults"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// template writes the template of the model "detector" of the problem,
+// its weights coming from weights, a file next to it or an url.
+func (f memoryFixture) template(test *testing.T, problem, weights, sha256 string, size int) string {
+	dir := fp.Join(f.root, "templates", "detector")
+	writeFile(test, fp.Join(dir, "model.py"), "model = dict()\n")
+	writeFile(test, fp.Join(dir, "template.yaml"), fmt.Sprintf(`name: detector
+domain: Object Detection
+problem: %s
+framework: OTEDetection v2.1.1
+config: model.py
+hyper_parameters:
+  basic:
+    batch_size: 32
+    epochs: 10
+dependencies:
+- source: %s
+  destination: snapshot.pth
+  sha256: %s
+  size: %d
+metrics:
+- display_name: AP
+  key: ap
+  unit: '%%'
+  value: 25.4
+`, problem, weights, sha256, size))
+	return fp.Join(dir, "template.yaml")
+}
+
+// addProblem stores the problem "vehicles", with its folder.
+func (f memoryFixture) addProblem() t.Problem {
+	return f.store.AddProblem(t.Problem{Title: "vehicles", Class: "Object Detection", Dir: fp.Join(f.problems, "vehicles")})
+}
+
+func (f memoryFixture) importTemplate(path string) kitendpoint.Response {
+	return service.ImportTemplate(context.Background(), f.service, service.UpdateFromLocalRequestData{Path: path, Options: service.ImportOptions{MaxAttempts: 1}})
+}
+
+// importDetector imports the model "detector" into a new problem.
+func (f memoryFixture) importDetector(test *testing.T) t.Model {
+	problem := f.addProblem()
+	writeFile(test, fp.Join(f.root, "templates", "detector", "weights.pth"), "weights")
+	resp := f.importTemplate(f.template(test, problem.Title, "weights.pth", sha("weights"), len("weights")))
+	if resp.Err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("import: code %d (%s)", resp.Err.Code, resp.Err.Message)
+	}
+	return resp.Data.(service.UpdateFromLocalSummary).Imported[0].Model
+}
+
+func TestUpdateFromLocal(test *testing.T) {
+	f := newMemoryFixture(test)
+	problem := f.addProblem()
+	writeFile(test, fp.Join(f.root, "templates", "detector", "weights.pth"), "weights")
+	path := f.template(test, problem.Title, "weights.pth", sha("weights"), len("weights"))
+
+	resp := f.importTemplate(path)
+	if resp.Err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("code %d (%s)", resp.Err.Code, resp.Err.Message)
+	}
+	summary := resp.Data.(service.UpdateFromLocalSummary)
+	if len(summary.Imported) != 1 || len(summary.Failed) != 0 {
+		test.Fatalf("summary %+v", summary)
+	}
+	imported := summary.Imported[0]
+	if _, ok := imported.Model.Evaluates[imported.Build.Id.Hex()]; imported.Build.Id.IsZero() || imported.Build.Name != "default" || !ok {
+		test.Errorf("model evaluated on %v imported into build %+v", imported.Model.Evaluates, imported.Build)
+	}
+	models := f.store.Models()
+	if len(models) != 1 || models[0].Name != "detector" || models[0].ProblemId != problem.Id {
+		test.Fatalf("stored models %+v", models)
+	}
+	if builds := f.store.Builds(problem.Id); len(builds) != 1 || builds[0].Id != imported.Build.Id {
+		test.Errorf("stored builds %+v", builds)
+	}
+	dir := fp.Join(problem.Dir, "detector")
+	if got := readFile(test, fp.Join(dir, "snapshot.pth")); got != "weights" {
+		test.Errorf("snapshot %q", got)
+	}
+	if f.store.Usage(problem.Id) <= 0 {
+		test.Errorf("usage %d of the problem not reported", f.store.Usage(problem.Id))
+	}
+	if len(f.store.SnapshotRefs(models[0].Id)) == 0 {
+		test.Error("snapshot of the model not referenced")
+	}
+
+	// The model is imported again in place, not as a new one.
+	writeFile(test, fp.Join(f.root, "templates", "detector", "weights.pth"), "retrained")
+	path = f.template(test, problem.Title, "weights.pth", sha("retrained"), len("retrained"))
+	if resp := f.importTemplate(path); resp.Err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("reimport: code %d (%s)", resp.Err.Code, resp.Err.Message)
+	}
+	if reimported := f.store.Models(); len(reimported) != 1 || reimported[0].Id != models[0].Id || reimported[0].Dependencies[0].Sha256 != sha("retrained") {
+		test.Errorf("stored models after the reimport %+v", reimported)
+	}
+	if got := readFile(test, fp.Join(dir, "snapshot.pth")); got != "retrained" {
+		test.Errorf("snapshot %q after the reimport", got)
+	}
+}
+
+func TestUpdateFromLocalWithoutProblem(test *testing.T) {
+	f := newMemoryFixture(test)
+	writeFile(test, fp.Join(f.root, "templates", "detector", "weights.pth"), "weights")
+	path := f.template(test, "missing", "weights.pth", sha("weights"), len("weights"))
+
+	resp := f.importTemplate(path)
+	if resp.Err.Code == kitendpoint.ErrCodeOk {
+		test.Fatal("model imported into a missing problem")
+	}
+	if !strings.Contains(resp.Err.Message, "missing") {
+		test.Errorf("message %q does not name the problem", resp.Err.Message)
+	}
+	if models := f.store.Models(); len(models) != 0 {
+		test.Errorf("stored models %+v", models)
+	}
+}
+
+func TestUpdateFromLocalFailedDownload(test *testing.T) {
+	f := newMemoryFixture(test)
+	problem := f.addProblem()
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	path := f.template(test, problem.Title, server.URL+"/weights.pth", sha("weights"), len("weights"))
+
+	resp := f.importTemplate(path)
+	if resp.Err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("code %d (%s)", resp.Err.Code, resp.Err.Message)
+	}
+	imported := resp.Data.(service.UpdateFromLocalSummary).Imported
+	if len(imported) != 1 || len(imported[0].Warnings) == 0 || !strings.Contains(strings.Join(imported[0].Warnings, "\n"), "snapshot.pth") {
+		test.Fatalf("imported %+v without a warning for the download", imported)
+	}
+	if _, err := os.Stat(fp.Join(problem.Dir, "detector", "snapshot.pth")); !os.IsNotExist(err) {
+		test.Errorf("snapshot of a failed download: %v", err)
+	}
+}
+
+// failingBuilds fails the requests of the builds.
+type failingBuilds struct {
+	*memory.Store
+}
+
+func (failingBuilds) FindBuild(context.Context, buildFindOne.RequestData) (t.Build, error) {
+	return t.Build{}, errors.New("database unavailable")
+}
+
+func TestUpdateFromLocalDatabaseError(test *testing.T) {
+	f := newMemoryFixture(test)
+	problem := f.addProblem()
+	repos := f.store.Repositories()
+	repos.Builds = failingBuilds{f.store}
+	f.serve(repos)
+	writeFile(test, fp.Join(f.root, "templates", "detector", "weights.pth"), "weights")
+	path := f.template(test, problem.Title, "weights.pth", sha("weights"), len("weights"))
+
+	resp := f.importTemplate(path)
+	if resp.Err.Code != kitendpoint.ErrCodeUnknown || !strings.Contains(resp.Err.Message, "database unavailable") {
+		test.Fatalf("code %d (%s), want the database error", resp.Err.Code, resp.Err.Message)
+	}
+	if models := f.store.Models(); len(models) != 0 {
+		test.Errorf("model stored without a build: %+v", models)
+	}
+}
+
+// yamlKeys are the keys of the YAML document content, in the order written.
+var yamlKeys = regexp.MustCompile(`(?m)^[ \t]*(?:-[ \t]+)?([a-z_0-9]+):`)
+
+func TestUpdateFromLocalKeepsTheKeyOrder(test *testing.T) {
+	f := newMemoryFixture(test)
+	problem := f.addProblem()
+	dir := fp.Join(f.root, "templates", "detector")
+	writeFile(test, fp.Join(dir, "model.py"), "model = dict()\n")
+	writeFile(test, fp.Join(dir, "weights.pth"), "weights")
+	writeFile(test, fp.Join(dir, "fragments", "hyper_parameters.yaml"), "basic:\n  epochs: 10\n  batch_size: 32\n")
+	writeFile(test, fp.Join(dir, "template.yaml"), `# Trained on COCO.
+name: detector
+problem: `+problem.Title+`
+domain: Object Detection
+framework: OTEDetection v2.1.1
+config: model.py
+hyper_parameters: !include fragments/hyper_parameters.yaml
+dependencies:
+- destination: snapshot.pth
+  source: weights.pth
+`)
+	record := true
+	resp := service.ImportTemplate(context.Background(), f.service, service.UpdateFromLocalRequestData{
+		Path:    fp.Join(dir, "template.yaml"),
+		Options: service.ImportOptions{MaxAttempts: 1, RecordChecksums: &record},
+	})
+	if resp.Err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("code %d (%s)", resp.Err.Code, resp.Err.Message)
+	}
+
+	stored := readFile(test, fp.Join(problem.Dir, "detector", "template.yaml"))
+	var keys []string
+	for _, match := range yamlKeys.FindAllStringSubmatch(stored, -1) {
+		keys = append(keys, match[1])
+	}
+	want := []string{"name", "problem", "domain", "framework", "config", "hyper_parameters", "basic", "epochs", "batch_size", "dependencies", "destination", "source", "sha256", "size"}
+	if !reflect.DeepEqual(keys, want) {
+		test.Errorf("keys %v, want %v, in:\n%s", keys, want, stored)
+	}
+	if !strings.Contains(stored, "# Trained on COCO.") || !strings.Contains(stored, sha("weights")) {
+		test.Errorf("comment or checksum missing from:\n%s", stored)
+	}
+}
+
+// panickingFS panics on the writes to the temp files of the atomic writes
+// of the stored template.
+type panickingFS struct {
+	uFiles.FS
+}
+
+func (fs panickingFS) TempFile(dir, pattern string) (uFiles.File, error) {
+	f, err := fs.FS.TempFile(dir, pattern)
+	if err != nil || !strings.HasPrefix(pattern, ".template.yaml") {
+		return f, err
+	}
+	return panickingFile{f}, nil
+}
+
+type panickingFile struct {
+	uFiles.File
+}
+
+func (panickingFile) Write([]byte) (int, error) {
+	panic("write interrupted")
+}
+
+func TestUpdateFromLocalLeavesNoTempFiles(test *testing.T) {
+	cases := []struct {
+		name   string
+		fs     uFiles.FS
+		panics bool
+	}{
+		{"error", &faults.FS{FailWrite: 1}, false},
+		{"panic", panickingFS{uFiles.OS}, true},
+	}
+	for _, c := range cases {
+		test.Run(c.name, func(test *testing.T) {
+			f := newMemoryFixture(test)
+			problem := f.addProblem()
+			writeFile(test, fp.Join(f.root, "templates", "detector", "weights.pth"), "weights")
+			path := f.template(test, problem.Title, "weights.pth", sha("weights"), len("weights"))
+
+			// The writes fail or panic midway through the import.
+			func() {
+				defer uFiles.SetFS(c.fs)()
+				defer func() {
+					if recovered := recover(); (recovered != nil) != c.panics {
+						test.Errorf("import panicked: %v", recovered)
+					}
+				}()
+				f.importTemplate(path)
+			}()
+
+			err := fp.Walk(f.root, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if name := info.Name(); strings.Contains(name, ".tmp") || strings.HasPrefix(name, ".download-") {
+					test.Errorf("temp file %s left", path)
+				}
+				return nil
+			})
+			if err != nil {
+				test.Fatal(err)
+			}
+		})
+	}
+}
+
+// The files of an import refused once they are copied take their room in the
+// problem folder, the usage of the problem counts them.
+func TestUpdateFromLocalRefusedAfterTheCopyReportsTheUsage(test *testing.T) {
+	f := newMemoryFixture(test)
+	problem := f.addProblem()
+	writeFile(test, fp.Join(f.root, "templates", "detector", "weights.pth"), "weights")
+	path := f.template(test, problem.Title, "weights.pth", sha("weights"), len("weights"))
+	writeFile(test, fp.Join(f.root, "templates", "detector", "model.py"), "_base_ = './missing.py'\nmodel = dict()\n")
+
+	resp := service.ImportTemplate(context.Background(), f.service, service.UpdateFromLocalRequestData{
+		Path:    path,
+		Options: service.ImportOptions{MaxAttempts: 1, ConfigCheck: service.ConfigCheckStrict},
+	})
+	if resp.Err.Code == kitendpoint.ErrCodeOk || !strings.Contains(resp.Err.Message, "missing.py") {
+		test.Fatalf("code %d (%s), want the missing base", resp.Err.Code, resp.Err.Message)
+	}
+	if _, err := os.Stat(fp.Join(problem.Dir, "detector", "snapshot.pth")); err != nil {
+		test.Fatal(err)
+	}
+	if f.store.Usage(problem.Id) <= 0 {
+		test.Errorf("usage %d of the files copied not reported", f.store.Usage(problem.Id))
+	}
+}
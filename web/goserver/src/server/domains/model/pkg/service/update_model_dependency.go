@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	fp "path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	modelUpdateFields "server/db/pkg/handler/model/update_fields"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/redact"
+)
+
+// UpdateModelDependencyRequestData replaces the artifact stored at Destination.
+// NewSource is either an url or a local path. Sha256 and Size are required for
+// urls and checked for local files when set. Options override the import
+// defaults of the service for the download.
+type UpdateModelDependencyRequestData struct {
+	ModelId     primitive.ObjectID `json:"modelId"`
+	Destination string             `json:"destination"`
+	NewSource   string             `json:"newSource"`
+	Sha256      string             `json:"sha256"`
+	Size        int                `json:"size"`
+	Backup      bool               `json:"backup"`
+	Options     ImportOptions      `json:"options"`
+}
+
+func (s *basicModelService) UpdateModelDependency(ctx context.Context, req UpdateModelDependencyRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		model, err := s.updateModelDependency(ctx, req)
+		if err != nil {
+			message := redact.String(err.Error())
+			log.Println("domains.model.pkg.service.update_model_dependency.UpdateModelDependency", message)
+			code := access.ErrCode(err)
+			if code == kitendpoint.ErrCodeUnknown {
+				code = dirLockErrCode(err)
+			}
+			returnChan <- kitendpoint.Response{Data: model, Err: kitendpoint.Error{Code: pathErrCode(err, code), Message: message}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: model, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeOk}, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicModelService) updateModelDependency(ctx context.Context, req UpdateModelDependencyRequestData) (_ t.Model, err error) {
+	unlock, err := s.lockModel(ctx, req.ModelId, "dependency update")
+	if err != nil {
+		return t.Model{}, err
+	}
+	defer unlock()
+	if err := s.statuses.flush(ctx, req.ModelId); err != nil {
+		return t.Model{}, err
+	}
+	found, err := s.repos.Models.FindModel(ctx, modelFindOne.RequestData{Id: req.ModelId})
+	if err != nil {
+		return t.Model{}, err
+	}
+	model := s.localModel(found)
+	if model.Id.IsZero() {
+		return model, fmt.Errorf("model %s not found", req.ModelId.Hex())
+	}
+	if err := access.Check(ctx, s.Conn, model.ProblemId, role.Editor); err != nil {
+		return model, err
+	}
+	// The reimports of the model take the lock of its folder only.
+	unlockDir, err := s.lockDir(ctx, model.Dir, "dependency update")
+	if err != nil {
+		return model, err
+	}
+	defer unlockDir()
+	index := -1
+	for i, d := range model.Dependencies {
+		if fp.Clean(d.Destination) == fp.Clean(req.Destination) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return model, fmt.Errorf("model %s has no dependency %s", model.Name, req.Destination)
+	}
+	if err := req.Options.Validate(); err != nil {
+		return model, err
+	}
+
+	dst := fp.Join(model.Dir, model.Dependencies[index].Destination)
+	tmp := dst + ".tmp"
+	opts := req.Options.merge(s.imports.Options())
+	if err := s.checkDependencyPaths(ctx, req, dst, opts); err != nil {
+		return model, err
+	}
+	defer os.Remove(tmp)
+	if err := fetchDependency(ctx, req, tmp, sniffs(model.Dependencies[index]), opts); err != nil {
+		return model, err
+	}
+	if req.Backup {
+		backup := dst + ".bak"
+		renameErr := os.Rename(dst, backup)
+		if renameErr != nil && !os.IsNotExist(renameErr) {
+			return model, renameErr
+		}
+		if renameErr == nil {
+			// The old file is put back unless the new one is saved.
+			defer func() {
+				if err != nil {
+					restoreBackup(backup, dst)
+				}
+			}()
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return model, err
+	}
+
+	stat, err := os.Stat(dst)
+	if err != nil {
+		return model, err
+	}
+	model.Dependencies[index] = storedDependency(t.Dependency{
+		Sha256:      getSha265(dst),
+		Size:        int(stat.Size()),
+		Source:      req.NewSource,
+		Destination: model.Dependencies[index].Destination,
+		SkipSniff:   model.Dependencies[index].SkipSniff,
+		Sample:      takeSample(dst, opts.SampleRegions),
+	})
+	contentHash := getContentHash(model.Dependencies)
+	saved, err := s.repos.Models.UpdateModelFields(ctx, modelUpdateFields.RequestData{
+		Id:           model.Id,
+		Dependencies: &model.Dependencies,
+		ContentHash:  &contentHash,
+	})
+	if err != nil {
+		return model, err
+	}
+	return s.localModel(saved), nil
+}
+
+// restoreBackup puts the backup of a dependency back at dst, over the new
+// file if it was moved there.
+func restoreBackup(backup, dst string) {
+	if err := os.Rename(backup, dst); err != nil {
+		log.Println("domains.model.pkg.service.update_model_dependency.restoreBackup", err)
+	}
+}
+
+// checkDependencyPaths refuses replacing dst, reading the new source or
+// downloading to a folder outside the roots.
+func (s *basicModelService) checkDependencyPaths(ctx context.Context, req UpdateModelDependencyRequestData, dst string, opts ImportOptions) error {
+	from := []string{}
+	if !isValidUrl(req.NewSource) {
+		from = append(from, req.NewSource)
+	}
+	if err := s.checkCopy(ctx, dst, from...); err != nil {
+		return err
+	}
+	if opts.TempDir != "" {
+		return s.paths.CheckWrite(ctx, opts.TempDir)
+	}
+	return nil
+}
+
+func fetchDependency(ctx context.Context, req UpdateModelDependencyRequestData, dst string, sniff bool, opts ImportOptions) error {
+	if isValidUrl(req.NewSource) {
+		if req.Sha256 == "" || req.Size == 0 {
+			return errors.New("sha256 and size are required for remote sources")
+		}
+		_, err := downloadWithCheck(ctx, req.NewSource, dst, req.Sha256, req.Size, sniff, opts, validators{})
+		return err
+	}
+	if err := copyFiles(req.NewSource, dst); err != nil {
+		return err
+	}
+	if req.Size > 0 {
+		stat, err := os.Stat(dst)
+		if err != nil {
+			return err
+		}
+		if stat.Size() != int64(req.Size) {
+			return errors.New("wrong size")
+		}
+	}
+	if req.Sha256 != "" && getSha265(dst) != req.Sha256 {
+		return errors.New("wrong sha")
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	fp "path/filepath"
+	"sync"
+	"testing"
+
+	lockAcquire "server/db/pkg/handler/lock/acquire"
+	lockRelease "server/db/pkg/handler/lock/release"
+	modelUpdateFields "server/db/pkg/handler/model/update_fields"
+	t "server/db/pkg/types"
+	"server/domains/model/pkg/service"
+	"server/domains/model/pkg/service/memory"
+	kitendpoint "server/kit/endpoint"
+)
+
+// addDependencyModel stores a model of a new problem with the dependency
+// weights.bin holding "old".
+func (f memoryFixture) addDependencyModel(test *testing.T) t.Model {
+	problem := f.store.AddProblem(t.Problem{Title: "problem"})
+	dir := fp.Join(f.problems, "problem", "_default", "model")
+	writeFile(test, fp.Join(dir, "weights.bin"), "old")
+	model, err := f.store.AddModel(t.Model{
+		ProblemId:    problem.Id,
+		Name:         "model",
+		Dir:          dir,
+		Dependencies: []t.Dependency{{Destination: "weights.bin", Sha256: sha("old"), Size: 3}},
+	})
+	if err != nil {
+		test.Fatal(err)
+	}
+	return model
+}
+
+func (f memoryFixture) updateDependency(model t.Model, source string) kitendpoint.Response {
+	return f.updateDependencyWithBackup(model, source, false)
+}
+
+func (f memoryFixture) updateDependencyWithBackup(model t.Model, source string, backup bool) kitendpoint.Response {
+	return <-f.service.UpdateModelDependency(context.Background(), service.UpdateModelDependencyRequestData{
+		ModelId:     model.Id,
+		Destination: "weights.bin",
+		NewSource:   source,
+		Backup:      backup,
+	})
+}
+
+func TestUpdateModelDependencyWaitsForTheFolderLock(test *testing.T) {
+	f := newMemoryFixture(test)
+	model := f.addDependencyModel(test)
+	source := fp.Join(f.root, "new.bin")
+	writeFile(test, source, "new")
+
+	// A reimport of the model holds the lock of its folder.
+	key, err := fp.Rel(f.problems, model.Dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+	reimport := lockAcquire.RequestData{Key: fp.ToSlash(key), Owner: "reimport", Holder: "import", TtlMillis: 60000}
+	if held, err := f.store.AcquireLock(context.Background(), reimport); err != nil || !held.Acquired {
+		test.Fatal("folder lock not taken", err)
+	}
+	resp := f.updateDependency(model, source)
+	if resp.Err.Code != kitendpoint.ErrCodeBusy {
+		test.Fatalf("code %d (%s), want busy", resp.Err.Code, resp.Err.Message)
+	}
+	if got := readFile(test, fp.Join(model.Dir, "weights.bin")); got != "old" {
+		test.Errorf("dependency replaced during the reimport: %q", got)
+	}
+
+	if _, err := f.store.ReleaseLock(context.Background(), lockRelease.RequestData{Key: reimport.Key, Owner: reimport.Owner}); err != nil {
+		test.Fatal(err)
+	}
+	resp = f.updateDependency(model, source)
+	if resp.Err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("code %d (%s) once the reimport is over", resp.Err.Code, resp.Err.Message)
+	}
+	if got := readFile(test, fp.Join(model.Dir, "weights.bin")); got != "new" {
+		test.Errorf("dependency %q, want new", got)
+	}
+}
+
+func TestConcurrentUpdateModelDependency(test *testing.T) {
+	f := newMemoryFixture(test)
+	model := f.addDependencyModel(test)
+	sources := []string{"a", "bb", "ccc", "dddd"}
+	var wg sync.WaitGroup
+	for _, content := range sources {
+		source := fp.Join(f.root, content+".bin")
+		writeFile(test, source, content)
+		wg.Add(1)
+		go func(source string) {
+			defer wg.Done()
+			if resp := f.updateDependency(model, source); resp.Err.Code != kitendpoint.ErrCodeOk {
+				test.Errorf("%s: code %d (%s)", source, resp.Err.Code, resp.Err.Message)
+			}
+		}(source)
+	}
+	wg.Wait()
+
+	content := readFile(test, fp.Join(model.Dir, "weights.bin"))
+	models := f.store.Models()
+	if len(models) != 1 || len(models[0].Dependencies) != 1 {
+		test.Fatalf("stored models %+v", models)
+	}
+	saved := models[0].Dependencies[0]
+	if saved.Sha256 != sha(content) || saved.Size != len(content) {
+		test.Errorf("stored dependency %s (%d bytes) is not the file %q", saved.Sha256, saved.Size, content)
+	}
+	if _, err := os.Stat(fp.Join(model.Dir, "weights.bin.tmp")); !os.IsNotExist(err) {
+		test.Errorf("temporary file left: %v", err)
+	}
+}
+
+// failingUpdates fails the updates of the models.
+type failingUpdates struct {
+	*memory.Store
+}
+
+func (failingUpdates) UpdateModelFields(context.Context, modelUpdateFields.RequestData) (t.Model, error) {
+	return t.Model{}, errors.New("database unavailable")
+}
+
+func TestUpdateModelDependencyRestoresTheBackup(test *testing.T) {
+	f := newMemoryFixture(test)
+	model := f.addDependencyModel(test)
+	source := fp.Join(f.root, "new.bin")
+	writeFile(test, source, "new")
+	repos := f.store.Repositories()
+	repos.Models = failingUpdates{f.store}
+	f.serve(repos)
+
+	if resp := f.updateDependencyWithBackup(model, source, true); resp.Err.Code == kitendpoint.ErrCodeOk {
+		test.Fatal("dependency updated without saving the model")
+	}
+	dst := fp.Join(model.Dir, "weights.bin")
+	if got := readFile(test, dst); got != "old" {
+		test.Errorf("dependency %q, want the old one back", got)
+	}
+	if _, err := os.Stat(dst + ".bak"); !os.IsNotExist(err) {
+		test.Errorf("backup left: %v", err)
+	}
+	if saved := f.store.Models()[0].Dependencies[0]; saved.Sha256 != sha("old") {
+		test.Errorf("stored dependency %s, want that of the old file", saved.Sha256)
+	}
+
+	f.serve(f.store.Repositories())
+	if resp := f.updateDependencyWithBackup(model, source, true); resp.Err.Code != kitendpoint.ErrCodeOk {
+		test.Fatalf("code %d (%s)", resp.Err.Code, resp.Err.Message)
+	}
+	if got := readFile(test, dst+".bak"); got != "old" {
+		test.Errorf("backup %q, want old", got)
+	}
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	fp "path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	t "server/db/pkg/types"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/storage"
+)
+
+var sha256Regexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ValidateTemplateRequestData holds either the path of a template.yaml or its
+// content. Content wins when both are set.
+type ValidateTemplateRequestData struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// TemplateIssue is an error or a warning about the field of a template.
+type TemplateIssue = kitendpoint.FieldError
+
+type ValidateTemplateResponseData struct {
+	Valid    bool            `json:"valid"`
+	Errors   []TemplateIssue `json:"errors"`
+	Warnings []TemplateIssue `json:"warnings"`
+}
+
+func (r *ValidateTemplateResponseData) error(field, format string, args ...interface{}) {
+	r.Errors = append(r.Errors, TemplateIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *ValidateTemplateResponseData) warning(field, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, TemplateIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateTemplate lints the template statically, nothing is downloaded or
+// written.
+func (s *basicModelService) ValidateTemplate(ctx context.Context, req ValidateTemplateRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		result := ValidateTemplateResponseData{Errors: []TemplateIssue{}, Warnings: []TemplateIssue{}}
+		content := []byte(req.Content)
+		dir := ""
+		if req.Path != "" {
+			dir = fp.Dir(s.paths.TemplatePath(req.Path))
+		}
+		if req.Content == "" {
+			b, err := ioutil.ReadFile(s.paths.TemplatePath(req.Path))
+			if err != nil {
+				result.error("", "can not read template: %v", err)
+				returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+				return
+			}
+			content = b
+		}
+		s.validateTemplate(ctx, content, dir, &result)
+		result.Valid = len(result.Errors) == 0
+		returnChan <- kitendpoint.Response{Data: result, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicModelService) validateTemplate(ctx context.Context, content []byte, dir string, result *ValidateTemplateResponseData) {
+	modelYml, ok := parseTemplate(content, dir, result)
+	if !ok {
+		return
+	}
+	var problem t.Problem
+	if modelYml.Problem != "" {
+		problem, _ = s.getProblem(ctx, modelYml.Problem)
+	}
+	checkTemplate(modelYml, problem, s.imports.Hooks(), result)
+}
+
+// parseTemplate reads the template content, the fragments it includes being
+// read from dir, the folder of the template. A template given without its
+// folder can not include any.
+func parseTemplate(content []byte, dir string, result *ValidateTemplateResponseData) (ModelYml, bool) {
+	var modelYml ModelYml
+	if hasIncludes(content) {
+		if dir == "" {
+			result.error("", "the template includes fragments, give its path to validate it")
+			return modelYml, false
+		}
+		resolved, err := resolveIncludes(content, dir)
+		if err != nil {
+			result.error("", "%v", err)
+			return modelYml, false
+		}
+		content = resolved
+	}
+	if err := yaml.Unmarshal(content, &modelYml); err != nil {
+		result.error("", "invalid yaml: %v", err)
+		return modelYml, false
+	}
+	if err := yaml.UnmarshalStrict(content, &modelYml); err != nil {
+		result.warning("", "%v", err)
+	}
+	return modelYml, true
+}
+
+// checkTemplate lints modelYml, problem being the problem it names, zero when
+// there is none of that title or id, and hooks bounding its post_import.
+func checkTemplate(modelYml ModelYml, problem t.Problem, hooks PostImportHooks, result *ValidateTemplateResponseData) {
+	if modelYml.Name == "" {
+		result.error("name", "is required")
+	}
+	if modelYml.Config == "" {
+		result.error("config", "is required")
+	}
+	if modelYml.Problem == "" {
+		result.error("problem", "is required")
+	} else if problem.Id.IsZero() {
+		result.error("problem", "no problem has the title or id %q", modelYml.Problem)
+	}
+	if err := hooks.check(modelYml.PostImport); err != nil {
+		result.error("post_import", "%v", err)
+	}
+	if modelYml.GpuNum < 0 {
+		result.error("gpu_num", "must not be negative")
+	} else if modelYml.GpuNum == 0 {
+		result.warning("gpu_num", "is not set, training will run on one gpu")
+	}
+
+	basic, applied := mergeHyperParameters(modelYml.HyperParameters.Basic, problem.DefaultHyperParameters)
+	for _, a := range applied {
+		result.warning("hyper_parameters.basic", "%s is inherited from problem %q", a, problem.Title)
+	}
+	result.Errors = append(result.Errors, checkBasic(basic, problem)...)
+	if basic.BaseLearningRate <= 0 {
+		result.error("hyper_parameters.basic.base_learning_rate", "must be positive")
+	} else if basic.BaseLearningRate >= 1 {
+		result.warning("hyper_parameters.basic.base_learning_rate", "%v looks too large", basic.BaseLearningRate)
+	}
+
+	checkPlaceholders(modelYml, result)
+
+	for _, name := range modelYml.snapshotLayout().Files {
+		if !isInsideDir(name) {
+			result.error("snapshot", "%q must be a path inside the model folder", name)
+		} else if !modelYml.isDependencyDestination(name) {
+			result.warning("snapshot", "%q is not placed by any dependency, the import fails unless it already exists in the model folder", name)
+		}
+	}
+
+	for i, d := range modelYml.Dependencies {
+		field := fmt.Sprintf("dependencies[%d]", i)
+		if d.Source == "" {
+			result.error(field+".source", "is required")
+		}
+		if d.Destination == "" {
+			result.error(field+".destination", "is required")
+		} else if strings.HasPrefix(d.Destination, "/") || strings.Contains(d.Destination, "..") {
+			result.error(field+".destination", "must be a path inside the model folder")
+		}
+		switch {
+		case isModelSource(d.Source):
+			if _, _, err := parseModelSource(d.Source); err != nil {
+				result.error(field+".source", "%v", err)
+			}
+		case isValidUrl(d.Source):
+			if storage.IsObjectUrl(d.Source) {
+				if err := storage.CheckObjectUrl(d.Source); err != nil {
+					result.error(field+".source", "%v", err)
+				}
+			}
+			checksummed := d.Sha256 == "" && (d.Checksums != "" || modelYml.Checksums != "")
+			if !checksummed && !sha256Regexp.MatchString(d.Sha256) {
+				result.error(field+".sha256", "remote dependencies need a sha256 of 64 hex digits, or a checksums file")
+			}
+			if d.Size <= 0 {
+				result.error(field+".size", "remote dependencies need a positive size")
+			}
+		default:
+			if d.Sha256 != "" && !sha256Regexp.MatchString(d.Sha256) {
+				result.error(field+".sha256", "must be 64 hex digits")
+			}
+			if d.Size < 0 {
+				result.error(field+".size", "must not be negative")
+			}
+		}
+	}
+	result.Errors = append(result.Errors, checkDestinations(modelYml.Dependencies)...)
+
+	for i, m := range modelYml.Metrics {
+		if m.Key == "" {
+			result.error(fmt.Sprintf("metrics[%d].key", i), "is required")
+		}
+	}
+}
+
+// checkDestinations refuses the dependencies written where another one is,
+// to the same destination or inside the folder another one is copied to,
+// which one of them would overwrite depending on the order of the copies.
+// The destinations outside the model folder are left to the other checks.
+func checkDestinations(dependencies []t.Dependency) []TemplateIssue {
+	var issues []TemplateIssue
+	for j, d := range dependencies {
+		if !isInsideDir(d.Destination) {
+			continue
+		}
+		destination := fp.Clean(d.Destination)
+		for i, other := range dependencies[:j] {
+			if !isInsideDir(other.Destination) {
+				continue
+			}
+			previous := fp.Clean(other.Destination)
+			field := fmt.Sprintf("dependencies[%d].destination", j)
+			switch {
+			case destination == previous:
+				issues = append(issues, TemplateIssue{Field: field, Message: fmt.Sprintf("%q is the destination of dependencies[%d] too", d.Destination, i)})
+			case strings.HasPrefix(destination, previous+string(fp.Separator)):
+				issues = append(issues, TemplateIssue{Field: field, Message: fmt.Sprintf("%q is inside %q, the destination of dependencies[%d]", d.Destination, other.Destination, i)})
+			case strings.HasPrefix(previous, destination+string(fp.Separator)):
+				issues = append(issues, TemplateIssue{Field: field, Message: fmt.Sprintf("%q holds %q, the destination of dependencies[%d]", d.Destination, other.Destination, i)})
+			}
+		}
+	}
+	return issues
+}
+
+// checkBasic checks the basic hyperparameters of a template once merged with
+// the defaults of problem, those an import can not do without.
+func checkBasic(basic Basic, problem t.Problem) []TemplateIssue {
+	var issues []TemplateIssue
+	hint := "set it in the template"
+	if !problem.Id.IsZero() {
+		hint = fmt.Sprintf("set it in the template or as a default of problem %q", problem.Title)
+	}
+	if basic.BatchSize <= 0 {
+		issues = append(issues, TemplateIssue{Field: "hyper_parameters.basic.batch_size", Message: "must be positive, " + hint})
+	}
+	if basic.Epochs <= 0 {
+		issues = append(issues, TemplateIssue{Field: "hyper_parameters.basic.epochs", Message: "must be positive, " + hint})
+	}
+	return issues
+}
+
+// InvalidTemplateError is a template refused for the Issues of its fields.
+type InvalidTemplateError struct {
+	Model  string
+	Issues []TemplateIssue
+}
+
+func (e *InvalidTemplateError) Error() string {
+	issues := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		issues[i] = issue.Field + " " + issue.Message
+	}
+	return fmt.Sprintf("model %q: %s", e.Model, strings.Join(issues, "; "))
+}
+
+// invalidArgument is the response error of the invalid request err, listing
+// the fields at fault when err tells them.
+func invalidArgument(err error) kitendpoint.Error {
+	e := kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}
+	var invalid *InvalidTemplateError
+	if errors.As(err, &invalid) {
+		e.Fields = invalid.Issues
+	}
+	return e
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	fp "path/filepath"
+	"sync"
+	"time"
+
+	problemFind "server/db/pkg/handler/problem/find"
+	t "server/db/pkg/types"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/redact"
+	"server/kit/storage"
+	u "server/kit/utils"
+)
+
+const (
+	// validateConcurrency is the number of templates validated at once by
+	// default, and maxValidateConcurrency the most a request may ask for.
+	validateConcurrency    = 16
+	maxValidateConcurrency = 64
+	// remoteCheckTimeout bounds the HEAD request checking a remote
+	// dependency.
+	remoteCheckTimeout = 10 * time.Second
+)
+
+// ValidateTemplatesRequestData asks for the templates at Paths to be linted
+// as ValidateTemplate does, Concurrency at once. The remote dependencies are
+// only checked to be reachable, with a HEAD request, when CheckRemotes is
+// set.
+type ValidateTemplatesRequestData struct {
+	Paths        []string `json:"paths"`
+	CheckRemotes bool     `json:"checkRemotes"`
+	Concurrency  int      `json:"concurrency"`
+}
+
+type TemplateReport struct {
+	Path string `json:"path"`
+	ValidateTemplateResponseData
+}
+
+// ValidateTemplatesResponseData has a report per template, in the order of
+// the paths. Valid is set when all of them are.
+type ValidateTemplatesResponseData struct {
+	Valid     bool             `json:"valid"`
+	Templates []TemplateReport `json:"templates"`
+}
+
+// ValidateTemplates lints many templates at once, for CI. The problems they
+// name are looked up together.
+func (s *basicModelService) ValidateTemplates(ctx context.Context, req ValidateTemplatesRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		if req.Concurrency < 0 || req.Concurrency > maxValidateConcurrency {
+			err := fmt.Errorf("concurrency must be between 0 and %d", maxValidateConcurrency)
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: s.validateTemplates(ctx, req), Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicModelService) validateTemplates(ctx context.Context, req ValidateTemplatesRequestData) ValidateTemplatesResponseData {
+	concurrency := req.Concurrency
+	if concurrency == 0 {
+		concurrency = validateConcurrency
+	}
+	reports := make([]TemplateReport, len(req.Paths))
+	parsed := make([]*ModelYml, len(req.Paths))
+	forEach(len(req.Paths), concurrency, func(i int) {
+		reports[i] = TemplateReport{
+			Path:                         req.Paths[i],
+			ValidateTemplateResponseData: ValidateTemplateResponseData{Errors: []TemplateIssue{}, Warnings: []TemplateIssue{}},
+		}
+		result := &reports[i].ValidateTemplateResponseData
+		if err := s.paths.CheckTemplate(ctx, req.Paths[i]); err != nil {
+			result.error("", "%v", err)
+			return
+		}
+		content, err := ioutil.ReadFile(req.Paths[i])
+		if err != nil {
+			result.error("", "can not read template: %v", err)
+			return
+		}
+		if modelYml, ok := parseTemplate(content, fp.Dir(req.Paths[i]), result); ok {
+			parsed[i] = &modelYml
+		}
+	})
+
+	problems := s.findTemplateProblems(ctx, parsed)
+	hooks := s.imports.Hooks()
+	forEach(len(req.Paths), concurrency, func(i int) {
+		if parsed[i] == nil {
+			return
+		}
+		result := &reports[i].ValidateTemplateResponseData
+		checkTemplate(*parsed[i], problems[parsed[i].Problem], hooks, result)
+		if req.CheckRemotes {
+			checkRemotes(ctx, *parsed[i], result)
+		}
+	})
+
+	data := ValidateTemplatesResponseData{Valid: true, Templates: reports}
+	for i := range reports {
+		reports[i].Valid = len(reports[i].Errors) == 0
+		data.Valid = data.Valid && reports[i].Valid
+	}
+	return data
+}
+
+// findTemplateProblems looks up the problems the templates name, by the
+// title or id they name them with. The titles are looked up with a single
+// query, the ids not found as titles one by one.
+func (s *basicModelService) findTemplateProblems(ctx context.Context, templates []*ModelYml) map[string]t.Problem {
+	problems := make(map[string]t.Problem)
+	seen := make(map[string]bool)
+	var titles []string
+	for _, modelYml := range templates {
+		if modelYml != nil && modelYml.Problem != "" && !seen[modelYml.Problem] {
+			seen[modelYml.Problem] = true
+			titles = append(titles, modelYml.Problem)
+		}
+	}
+	if len(titles) == 0 {
+		return problems
+	}
+	resp := <-problemFind.Send(ctx, s.Conn, problemFind.RequestData{Page: 1, Size: 0, Titles: titles})
+	found, _ := resp.Data.(problemFind.ResponseData)
+	for _, problem := range found.Items {
+		problems[problem.Title] = problem
+	}
+	for _, ref := range titles {
+		if _, ok := problems[ref]; ok {
+			continue
+		}
+		if problem, err := s.getProblem(ctx, ref); err == nil {
+			problems[ref] = problem
+		}
+	}
+	return problems
+}
+
+// checkRemotes checks that the remote dependencies of modelYml are reachable
+// and of their declared size, when the host tells it.
+func checkRemotes(ctx context.Context, modelYml ModelYml, result *ValidateTemplateResponseData) {
+	for i, d := range modelYml.Dependencies {
+		if !isValidUrl(d.Source) {
+			continue
+		}
+		field := fmt.Sprintf("dependencies[%d].source", i)
+		size, err := headUrl(ctx, d.Source)
+		if err != nil {
+			result.error(field, "%s", redact.String(err.Error()))
+		} else if size >= 0 && d.Size > 0 && size != int64(d.Size) {
+			result.error(field, "the host announces %d bytes, %d are declared", size, d.Size)
+		}
+	}
+}
+
+// headUrl returns the length the host announces for url, -1 when it does not.
+func headUrl(ctx context.Context, url string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteCheckTimeout)
+	defer cancel()
+	if storage.IsObjectUrl(url) {
+		info, err := storage.StatObject(ctx, url)
+		return info.Size, err
+	}
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", u.DefaultUserAgent())
+	u.Authorize(req)
+	resp, err := u.DoDownload(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.ContentLength, nil
+}
+
+// forEach calls f with 0 to n-1, concurrency calls at once.
+func forEach(n, concurrency int, f func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
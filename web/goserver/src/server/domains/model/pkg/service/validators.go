@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	t "server/db/pkg/types"
+)
+
+// errNotModified is the 304 answer to a conditional download.
+var errNotModified = errors.New("not modified")
+
+// validators are the cache validators of a downloaded file, sent back to its
+// server to download it again only when it changed.
+type validators struct {
+	ETag         string
+	LastModified string
+}
+
+func validatorsOf(d t.Dependency) validators {
+	return validators{ETag: d.ETag, LastModified: d.LastModified}
+}
+
+func (v validators) isSet() bool {
+	return v.ETag != "" || v.LastModified != ""
+}
+
+// findImportedModel returns the model as it was last imported, a zero model
+// when it is new.
+func (s *basicModelService) findImportedModel(ctx context.Context, model t.Model) t.Model {
+	imported, err := s.repos.Models.FindModel(ctx, modelFindOne.RequestData{ProblemId: model.ProblemId, Workspace: model.Workspace, Name: model.Name})
+	if err != nil {
+		log.Println("domains.model.pkg.service.validators.findImportedModel", model.Name, err)
+	}
+	return s.localModel(imported)
+}
+
+// keepValidators gives the downloaded dependencies of model the validators
+// the previous import of the model had, provided they still come from the
+// same source. Servers without validators get full downloads, as the
+// dependencies without validators.
+func keepValidators(model, previous t.Model) {
+	if previous.Id.IsZero() {
+		return
+	}
+	for i, d := range model.Dependencies {
+		if !isValidUrl(d.Source) {
+			continue
+		}
+		for _, p := range previous.Dependencies {
+			if p.Destination == d.Destination && p.SourceHash == d.SourceHash {
+				model.Dependencies[i].ETag, model.Dependencies[i].LastModified = p.ETag, p.LastModified
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	fp "path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	modelFindOne "server/db/pkg/handler/model/find_one"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+)
+
+// Verification modes of VerifyModel. VerifyModeFast checks the size and the
+// sample of every file, which catches truncations and most corruptions
+// reading a few blocks. VerifyModeFull hashes the whole files.
+const (
+	VerifyModeFast = "fast"
+	VerifyModeFull = "full"
+)
+
+// sampleBlockSize is the size of the regions of a file sampled.
+const sampleBlockSize = 1 << 20
+
+// VerifyModelRequestData asks for the files of a model to be checked against
+// what was recorded when they were placed, in VerifyModeFast by default.
+type VerifyModelRequestData struct {
+	ModelId primitive.ObjectID `json:"modelId"`
+	Mode    string             `json:"mode"`
+}
+
+type VerifyModelFailure struct {
+	Destination string `json:"destination"`
+	Error       string `json:"error"`
+}
+
+// VerifyModelResponseData tells how many files were checked and which
+// failed. Dependencies on other models and folders are not checked.
+type VerifyModelResponseData struct {
+	Mode     string               `json:"mode"`
+	Checked  int                  `json:"checked"`
+	Failures []VerifyModelFailure `json:"failures"`
+}
+
+func (s *basicModelService) VerifyModel(ctx context.Context, req VerifyModelRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		data, err := s.verifyModel(ctx, req)
+		if err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: err, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: err, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicModelService) verifyModel(ctx context.Context, req VerifyModelRequestData) (VerifyModelResponseData, kitendpoint.Error) {
+	data := VerifyModelResponseData{Mode: req.Mode, Failures: []VerifyModelFailure{}}
+	switch data.Mode {
+	case "":
+		data.Mode = VerifyModeFast
+	case VerifyModeFast, VerifyModeFull:
+	default:
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: fmt.Sprintf("unknown verification mode %q", req.Mode)}
+	}
+	modelResp := <-modelFindOne.Send(ctx, s.Conn, modelFindOne.RequestData{Id: req.ModelId})
+	model := s.localModel(modelResp.Data.(modelFindOne.ResponseData))
+	if model.Id.IsZero() {
+		err := fmt.Errorf("model %s not found", req.ModelId.Hex())
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}
+	}
+	if err := access.Check(ctx, s.Conn, model.ProblemId, role.Viewer); err != nil {
+		return data, kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}
+	}
+	if err := s.paths.CheckRead(ctx, model.Dir); err != nil {
+		return data, kitendpoint.Error{Code: kitendpoint.ErrCodePathViolation, Message: err.Error()}
+	}
+	for _, d := range model.Dependencies {
+		if isModelSource(d.Source) {
+			continue
+		}
+		path := fp.Join(model.Dir, d.Destination)
+		info, err := os.Stat(path)
+		if err == nil && info.IsDir() {
+			continue
+		}
+		data.Checked++
+		if err == nil {
+			err = verifyDependency(path, d, data.Mode)
+		}
+		if err != nil {
+			data.Failures = append(data.Failures, VerifyModelFailure{Destination: d.Destination, Error: err.Error()})
+		}
+	}
+	return data, kitendpoint.Error{Code: 0}
+}
+
+// verifyDependency checks the file at path in mode. Files placed before the
+// samples were taken are hashed whole, and files without a declared sha256
+// are checked by their sample in both modes.
+func verifyDependency(path string, d t.Dependency, mode string) error {
+	if d.Sample != nil && (mode == VerifyModeFast || d.Sha256 == "") {
+		return checkSample(path, *d.Sample)
+	}
+	return checkFile(path, d.Sha256, int64(d.Size))
+}
+
+func checkSample(path string, sample t.Sample) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() != sample.Size {
+		return fmt.Errorf("wrong size: got %d, want %d", info.Size(), sample.Size)
+	}
+	got, err := sampleSha256(path, sample.BlockSize, sample.Offsets)
+	if err != nil {
+		return err
+	}
+	if got != sample.Sha256 {
+		return fmt.Errorf("wrong sample sha256: got %s, want %s", got, sample.Sha256)
+	}
+	return nil
+}
+
+// takeSample samples the file at path over regions blocks, nil is returned
+// for folders and files that cannot be read.
+func takeSample(path string, regions int) *t.Sample {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	sample := t.Sample{
+		Size:      info.Size(),
+		BlockSize: sampleBlockSize,
+		Offsets:   sampleOffsets(info.Size(), sampleBlockSize, regions),
+	}
+	sample.Sha256, err = sampleSha256(path, sample.BlockSize, sample.Offsets)
+	if err != nil {
+		log.Println("domains.model.pkg.service.verify_model.takeSample", err)
+		return nil
+	}
+	return &sample
+}
+
+// sampleOffsets spreads regions blocks over a file of size bytes, the first
+// and the last blocks included. Files holding no more blocks are read whole.
+func sampleOffsets(size, block int64, regions int) []int64 {
+	if regions < 1 {
+		regions = 1
+	}
+	var offsets []int64
+	if size <= block*int64(regions) {
+		for off := int64(0); off < size || off == 0; off += block {
+			offsets = append(offsets, off)
+		}
+		return offsets
+	}
+	if regions == 1 {
+		return []int64{0}
+	}
+	for i := 0; i < regions; i++ {
+		offsets = append(offsets, int64(i)*(size-block)/int64(regions-1))
+	}
+	return offsets
+}
+
+func sampleSha256(path string, block int64, offsets []int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	for _, off := range offsets {
+		if _, err := io.Copy(h, io.NewSectionReader(f, off, block)); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
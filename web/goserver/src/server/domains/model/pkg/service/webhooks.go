@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	webhookDelete "server/db/pkg/handler/webhook/delete"
+	webhookFind "server/db/pkg/handler/webhook/find"
+	webhookInsertOne "server/db/pkg/handler/webhook/insert_one"
+	webhookDeadLetterFind "server/db/pkg/handler/webhook_dead_letter/find"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	typeWebhook "server/db/pkg/types/type/webhook"
+	"server/domains/problem/pkg/access"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/webhook"
+)
+
+// minWebhookSecret is the length under which the secrets given are refused.
+const minWebhookSecret = 16
+
+// CreateWebhookRequestData registers Url for Events, every event when there
+// are none, of the models of the problem, or of all the problems without
+// ProblemId. A secret is generated when none is given.
+type CreateWebhookRequestData struct {
+	ProblemId primitive.ObjectID `json:"problemId"`
+	Url       string             `json:"url"`
+	Secret    string             `json:"secret"`
+	Events    []string           `json:"events"`
+}
+
+type DeleteWebhookRequestData struct {
+	Id primitive.ObjectID `json:"id"`
+}
+
+// ListWebhooksRequestData lists the webhooks of the problem, the global ones
+// without ProblemId.
+type ListWebhooksRequestData struct {
+	ProblemId primitive.ObjectID `json:"problemId"`
+}
+
+type ListWebhooksResponseData struct {
+	Items []t.Webhook `json:"items"`
+}
+
+// ListWebhookDeadLettersRequestData lists the dead letters of the webhook,
+// or else those of the problem, the global ones without ProblemId.
+type ListWebhookDeadLettersRequestData struct {
+	WebhookId primitive.ObjectID `json:"webhookId"`
+	ProblemId primitive.ObjectID `json:"problemId"`
+	Page      int64              `json:"page"`
+	Size      int64              `json:"size"`
+}
+
+type TestWebhookRequestData struct {
+	Id primitive.ObjectID `json:"id"`
+}
+
+// TestWebhookResponseData tells how the receiver answered the test delivery,
+// Status being zero when it did not. Why a receiver could not be reached is
+// only told to the admins, the others learning nothing of the networks of
+// the server.
+type TestWebhookResponseData struct {
+	Delivered  bool   `json:"delivered"`
+	Status     int    `json:"status"`
+	Error      string `json:"error"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// WebhookModelData is the data of the model events. Status is the status of
+// the evaluation on BuildId for the evaluation events, and Created tells a
+// model imported for the first time.
+type WebhookModelData struct {
+	ModelId   primitive.ObjectID `json:"modelId"`
+	ProblemId primitive.ObjectID `json:"problemId"`
+	Name      string             `json:"name"`
+	Status    string             `json:"status"`
+	Tags      []string           `json:"tags"`
+	Created   bool               `json:"created,omitempty"`
+	BuildId   string             `json:"buildId,omitempty"`
+	Metrics   []t.Metric         `json:"metrics,omitempty"`
+}
+
+func (s *basicModelService) CreateWebhook(ctx context.Context, req CreateWebhookRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		if err := s.checkWebhookAccess(ctx, req.ProblemId); err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: err, IsLast: true}
+			return
+		}
+		if err := s.checkWebhook(ctx, req); err != nil {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeInvalidArgument, Message: err.Error()}, IsLast: true}
+			return
+		}
+		if req.Secret == "" {
+			b := make([]byte, 32)
+			if _, err := rand.Read(b); err != nil {
+				returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: err.Error()}, IsLast: true}
+				return
+			}
+			req.Secret = hex.EncodeToString(b)
+		}
+		identity, _ := auth.FromContext(ctx)
+		resp := <-webhookInsertOne.Send(ctx, s.Conn, webhookInsertOne.RequestData{
+			ProblemId: req.ProblemId,
+			Url:       req.Url,
+			Secret:    req.Secret,
+			Events:    req.Events,
+			CreatedBy: identity.User,
+		})
+		if resp.Err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: resp.Err.Message}, IsLast: true}
+			return
+		}
+		// The secret is only ever returned here.
+		returnChan <- kitendpoint.Response{Data: resp.Data, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicModelService) DeleteWebhook(ctx context.Context, req DeleteWebhookRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		hook, err := s.findWebhook(ctx, req.Id)
+		if err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: err, IsLast: true}
+			return
+		}
+		resp := <-webhookDelete.Send(ctx, s.Conn, webhookDelete.RequestData{Id: hook.Id})
+		if resp.Err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: resp.Err.Message}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: withoutSecret(hook), Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicModelService) ListWebhooks(ctx context.Context, req ListWebhooksRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		if err := s.checkWebhookAccess(ctx, req.ProblemId); err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: err, IsLast: true}
+			return
+		}
+		resp := <-webhookFind.Send(ctx, s.Conn, webhookFind.RequestData{ProblemIds: []primitive.ObjectID{req.ProblemId}})
+		if resp.Err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: resp.Err.Message}, IsLast: true}
+			return
+		}
+		items := resp.Data.(webhookFind.ResponseData).Items
+		for i := range items {
+			items[i] = withoutSecret(items[i])
+		}
+		returnChan <- kitendpoint.Response{Data: ListWebhooksResponseData{Items: items}, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+func (s *basicModelService) ListWebhookDeadLetters(ctx context.Context, req ListWebhookDeadLettersRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		find := webhookDeadLetterFind.RequestData{Page: req.Page, Size: req.Size}
+		if req.WebhookId.IsZero() {
+			if err := s.checkWebhookAccess(ctx, req.ProblemId); err.Code > 0 {
+				returnChan <- kitendpoint.Response{Data: nil, Err: err, IsLast: true}
+				return
+			}
+			find.ProblemIds = []primitive.ObjectID{req.ProblemId}
+		} else {
+			hook, err := s.findWebhook(ctx, req.WebhookId)
+			if err.Code > 0 {
+				returnChan <- kitendpoint.Response{Data: nil, Err: err, IsLast: true}
+				return
+			}
+			find.WebhookId = hook.Id
+		}
+		resp := <-webhookDeadLetterFind.Send(ctx, s.Conn, find)
+		if resp.Err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: resp.Err.Message}, IsLast: true}
+			return
+		}
+		returnChan <- kitendpoint.Response{Data: resp.Data, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// TestWebhook sends a test event to the webhook once and waits for the
+// answer of the receiver, a refused delivery is not an error of the request.
+func (s *basicModelService) TestWebhook(ctx context.Context, req TestWebhookRequestData) chan kitendpoint.Response {
+	returnChan := make(chan kitendpoint.Response)
+	go func() {
+		defer close(returnChan)
+		hook, err := s.findWebhook(ctx, req.Id)
+		if err.Code > 0 {
+			returnChan <- kitendpoint.Response{Data: nil, Err: err, IsLast: true}
+			return
+		}
+		start := time.Now()
+		identity, _ := auth.FromContext(ctx)
+		status, deliveryErr := s.webhooks.Test(ctx, hook, map[string]string{"webhookId": hook.Id.Hex(), "user": identity.User})
+		data := TestWebhookResponseData{
+			Delivered:  deliveryErr == nil,
+			Status:     status,
+			DurationMs: int64(time.Since(start) / time.Millisecond),
+		}
+		if deliveryErr != nil {
+			data.Error = deliveryErr.Error()
+			if identity, ok := auth.FromContext(ctx); ok && !identity.HasRole(auth.RoleAdmin) && status == 0 {
+				data.Error = "webhook could not be reached"
+				if errors.Is(deliveryErr, webhook.ErrAddressNotAllowed) {
+					data.Error = webhook.ErrAddressNotAllowed.Error()
+				}
+			}
+		}
+		returnChan <- kitendpoint.Response{Data: data, Err: kitendpoint.Error{Code: 0}, IsLast: true}
+	}()
+	return returnChan
+}
+
+// findWebhook returns the webhook if the caller may manage it.
+func (s *basicModelService) findWebhook(ctx context.Context, id primitive.ObjectID) (t.Webhook, kitendpoint.Error) {
+	resp := <-webhookFind.Send(ctx, s.Conn, webhookFind.RequestData{Id: id})
+	if resp.Err.Code > 0 {
+		return t.Webhook{}, kitendpoint.Error{Code: kitendpoint.ErrCodeUnknown, Message: resp.Err.Message}
+	}
+	items := resp.Data.(webhookFind.ResponseData).Items
+	if len(items) == 0 {
+		err := fmt.Errorf("webhook %s not found", id.Hex())
+		return t.Webhook{}, kitendpoint.Error{Code: kitendpoint.ErrCodeNotFound, Message: err.Error()}
+	}
+	if err := s.checkWebhookAccess(ctx, items[0].ProblemId); err.Code > 0 {
+		return t.Webhook{}, err
+	}
+	return items[0], kitendpoint.Error{Code: 0}
+}
+
+// checkWebhook refuses the urls the dispatcher would not deliver to, the
+// short secrets and the unknown events.
+func (s *basicModelService) checkWebhook(ctx context.Context, req CreateWebhookRequestData) error {
+	if err := s.webhooks.CheckUrl(ctx, req.Url); err != nil {
+		return err
+	}
+	if req.Secret != "" && len(req.Secret) < minWebhookSecret {
+		return fmt.Errorf("webhook secret must have at least %d characters", minWebhookSecret)
+	}
+	for _, event := range req.Events {
+		if !typeWebhook.IsEvent(event) {
+			return fmt.Errorf("unknown webhook event %q, expected one of %v", event, typeWebhook.Events)
+		}
+	}
+	return nil
+}
+
+// checkWebhookAccess lets the editors of a problem manage its webhooks, the
+// global ones, which receive the events of every problem, being left to the
+// admins.
+func (s *basicModelService) checkWebhookAccess(ctx context.Context, problemId primitive.ObjectID) kitendpoint.Error {
+	if !problemId.IsZero() {
+		if err := access.Check(ctx, s.Conn, problemId, role.Editor); err != nil {
+			return kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}
+		}
+		return kitendpoint.Error{Code: 0}
+	}
+	if identity, ok := auth.FromContext(ctx); ok && !identity.HasRole(auth.RoleAdmin) {
+		err := fmt.Errorf("user %q is not allowed to manage the global webhooks", identity.User)
+		return kitendpoint.Error{Code: kitendpoint.ErrCodeForbidden, Message: err.Error()}
+	}
+	return kitendpoint.Error{Code: 0}
+}
+
+func withoutSecret(hook t.Webhook) t.Webhook {
+	hook.Secret = ""
+	return hook
+}
+
+// emitModel sends the event of the model to its webhooks.
+func (s *basicModelService) emitModel(event string, model t.Model, created bool) {
+	s.webhooks.Emit(event, model.ProblemId, WebhookModelData{
+		ModelId:   model.Id,
+		ProblemId: model.ProblemId,
+		Name:      model.Name,
+		Status:    model.Status,
+		Tags:      model.Tags,
+		Created:   created,
+	})
+}
+
+// emitEvaluation sends the evaluation of the model on the build to the
+// webhooks of the model.
+func (s *basicModelService) emitEvaluation(model t.Model, buildId primitive.ObjectID) {
+	evaluate := model.Evaluates[buildId.Hex()]
+	s.webhooks.Emit(typeWebhook.ModelEvaluationFinished, model.ProblemId, WebhookModelData{
+		ModelId:   model.Id,
+		ProblemId: model.ProblemId,
+		Name:      model.Name,
+		Status:    evaluate.Status,
+		Tags:      model.Tags,
+		BuildId:   buildId.Hex(),
+		Metrics:   evaluate.Metrics,
+	})
+}
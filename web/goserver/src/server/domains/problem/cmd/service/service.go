@@ -9,14 +9,18 @@ import (
 
 	n "server/common/names"
 	"server/domains/problem/pkg/endpoint"
+	addClasses "server/domains/problem/pkg/handler/add_classes"
 	"server/domains/problem/pkg/handler/create"
 	"server/domains/problem/pkg/handler/delete"
 	"server/domains/problem/pkg/handler/details"
 	"server/domains/problem/pkg/handler/list"
+	"server/domains/problem/pkg/handler/update"
 	updateFromLocal "server/domains/problem/pkg/handler/update_from_local"
+	"server/domains/problem/pkg/handler/usage"
 	"server/domains/problem/pkg/service"
 	"server/kit/encode_decode"
 	longendpoint "server/kit/endpoint"
+	"server/kit/health"
 	kitutils "server/kit/utils"
 )
 
@@ -26,6 +30,7 @@ func Run(serviceQueueName, amqpAddr, amqpUser, amqpPass, trainPath, problemPath
 	if err != nil {
 		log.Panic(err)
 	}
+	health.Set(health.CheckAmqp, health.Amqp(conn))
 	defer conn.Close()
 	ch, err := conn.Channel()
 	if err != nil {
@@ -60,6 +65,8 @@ func Run(serviceQueueName, amqpAddr, amqpUser, amqpPass, trainPath, problemPath
 			fmt.Println("Request:", req)
 			// Event from UI
 			switch req.Event {
+			case addClasses.Event:
+				go addClasses.Handle(eps, conn, msg)
 			case create.Event:
 				go create.Handle(eps, conn, msg)
 			case delete.Event:
@@ -68,6 +75,10 @@ func Run(serviceQueueName, amqpAddr, amqpUser, amqpPass, trainPath, problemPath
 				go details.Handle(eps, conn, msg)
 			case list.Event:
 				go list.Handle(eps, conn, msg)
+			case update.Event:
+				go update.Handle(eps, conn, msg)
+			case usage.Event:
+				go usage.Handle(eps, conn, msg)
 			}
 
 			// Request from another service
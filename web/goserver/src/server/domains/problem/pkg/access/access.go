@@ -0,0 +1,98 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	problemFindOne "server/db/pkg/handler/problem/find_one"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+)
+
+var roleRank = map[string]int{
+	role.Viewer: 1,
+	role.Editor: 2,
+	role.Owner:  3,
+}
+
+func IsRole(r string) bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// Check returns an error with code ErrCodeForbidden when the caller of ctx
+// has less than required access to the problem.
+func Check(ctx context.Context, conn *rabbitmq.Connection, problemId primitive.ObjectID, required string) error {
+	if _, ok := auth.FromContext(ctx); !ok {
+		return nil
+	}
+	problemFindOneResp := <-problemFindOne.Send(ctx, conn, problemFindOne.RequestData{Id: problemId})
+	problem := problemFindOneResp.Data.(problemFindOne.ResponseData)
+	if problem.Id.IsZero() {
+		return fmt.Errorf("problem %s not found", problemId.Hex())
+	}
+	return CheckProblem(ctx, problem, required)
+}
+
+// CheckProblem is Check for an already loaded problem. Requests without an
+// identity come from the services themselves or from a gateway running
+// without authentication and are always allowed, as are admins. A problem
+// without an access list, created before the access lists or without
+// authentication, is for the admins only until one of them grants access.
+func CheckProblem(ctx context.Context, problem t.Problem, required string) error {
+	identity, ok := auth.FromContext(ctx)
+	if !ok || identity.HasRole(auth.RoleAdmin) {
+		return nil
+	}
+	granted := problem.Access[identity.User]
+	if roleRank[granted] >= roleRank[required] {
+		return nil
+	}
+	return &Error{
+		User:      identity.User,
+		ProblemId: problem.Id,
+		Title:     problem.Title,
+		Required:  required,
+		Granted:   granted,
+	}
+}
+
+// Error describes a rejected request. Granted is empty when the user has no
+// access to the problem at all.
+type Error struct {
+	User      string
+	ProblemId primitive.ObjectID
+	Title     string
+	Required  string
+	Granted   string
+}
+
+func (e *Error) Error() string {
+	granted := "no"
+	if e.Granted != "" {
+		granted = e.Granted
+	}
+	return fmt.Sprintf(
+		"user %q has %s access to problem %q, %s access required",
+		e.User,
+		granted,
+		e.Title,
+		e.Required,
+	)
+}
+
+// ErrCode maps err to the response error code, so authorization failures can
+// be told apart by the client.
+func ErrCode(err error) int {
+	var accessErr *Error
+	if errors.As(err, &accessErr) {
+		return kitendpoint.ErrCodeForbidden
+	}
+	return kitendpoint.ErrCodeUnknown
+}
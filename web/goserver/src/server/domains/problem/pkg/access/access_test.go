@@ -0,0 +1,45 @@
+package access
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+)
+
+func TestCheckProblem(test *testing.T) {
+	shared := t.Problem{Id: primitive.NewObjectID(), Title: "shared", Access: map[string]string{"alice": role.Editor, "bob": role.Viewer}}
+	legacy := t.Problem{Id: primitive.NewObjectID(), Title: "legacy"}
+	user := func(name string, roles ...string) context.Context {
+		return auth.WithIdentity(context.Background(), auth.Identity{User: name, Roles: roles})
+	}
+	cases := []struct {
+		name     string
+		ctx      context.Context
+		problem  t.Problem
+		required string
+		allowed  bool
+	}{
+		{"service", context.Background(), legacy, role.Owner, true},
+		{"admin without access list", user("root", auth.RoleAdmin), legacy, role.Owner, true},
+		{"user without access list", user("alice"), legacy, role.Viewer, false},
+		{"editor edits", user("alice"), shared, role.Editor, true},
+		{"editor owns", user("alice"), shared, role.Owner, false},
+		{"viewer edits", user("bob"), shared, role.Editor, false},
+		{"stranger views", user("eve"), shared, role.Viewer, false},
+	}
+	for _, c := range cases {
+		err := CheckProblem(c.ctx, c.problem, c.required)
+		if (err == nil) != c.allowed {
+			test.Errorf("%s: got %v, allowed %v", c.name, err, c.allowed)
+		}
+		if err != nil && ErrCode(err) != kitendpoint.ErrCodeForbidden {
+			test.Errorf("%s: code %d, want forbidden", c.name, ErrCode(err))
+		}
+	}
+}
@@ -8,22 +8,28 @@ import (
 )
 
 type Endpoints struct {
+	AddClasses      kitendpoint.Endpoint
 	Create          kitendpoint.Endpoint
 	Delete          kitendpoint.Endpoint
 	Details         kitendpoint.Endpoint
 	List            kitendpoint.Endpoint
+	Update          kitendpoint.Endpoint
 	UpdateFromLocal kitendpoint.Endpoint
+	Usage           kitendpoint.Endpoint
 }
 
 // New returns a Endpoints struct that wraps the provided service, and wires in all of the
 // expected endpoint middlewares
 func New(s service.ProblemService, mdw map[string][]kitendpoint.Middleware) Endpoints {
 	eps := Endpoints{
+		AddClasses:      MakeAddClassesEndpoint(s),
 		Create:          MakeCreateEndpoint(s),
 		Delete:          MakeDeleteEndpoint(s),
 		Details:         MakeDetailsEndpoint(s),
 		List:            MakeListEndpoint(s),
+		Update:          MakeUpdateEndpoint(s),
 		UpdateFromLocal: MakeUpdateFromLocalEndpoint(s),
+		Usage:           MakeUsageEndpoint(s),
 	}
 
 	// for _, m := range mdw["WebSocket"] {
@@ -32,6 +38,15 @@ func New(s service.ProblemService, mdw map[string][]kitendpoint.Middleware) Endp
 	return eps
 }
 
+func MakeAddClassesEndpoint(s service.ProblemService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		req := request.(service.AddClassesRequestData)
+		responseChan := make(chan kitendpoint.Response)
+		go s.AddClasses(ctx, req, responseChan)
+		return responseChan
+	}
+}
+
 func MakeCreateEndpoint(s service.ProblemService) kitendpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
 		req := request.(service.CreateRequestData)
@@ -68,6 +83,15 @@ func MakeListEndpoint(s service.ProblemService) kitendpoint.Endpoint {
 	}
 }
 
+func MakeUpdateEndpoint(s service.ProblemService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		req := request.(service.UpdateRequestData)
+		responseChan := make(chan kitendpoint.Response)
+		go s.Update(ctx, req, responseChan)
+		return responseChan
+	}
+}
+
 func MakeUpdateFromLocalEndpoint(s service.ProblemService) kitendpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
 		req := request.(service.UpdateFromLocalRequestData)
@@ -76,3 +100,12 @@ func MakeUpdateFromLocalEndpoint(s service.ProblemService) kitendpoint.Endpoint
 		return responseChan
 	}
 }
+
+func MakeUsageEndpoint(s service.ProblemService) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+		req := request.(service.UsageRequestData)
+		responseChan := make(chan kitendpoint.Response)
+		go s.Usage(ctx, req, responseChan)
+		return responseChan
+	}
+}
@@ -0,0 +1,106 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	problemFindOne "server/db/pkg/handler/problem/find_one"
+	problemUsageAdd "server/db/pkg/handler/problem_usage/add"
+	problemUsageFind "server/db/pkg/handler/problem_usage/find"
+	t "server/db/pkg/types"
+	kitendpoint "server/kit/endpoint"
+)
+
+// Usage is the disk usage of a problem versus its quota. A zero QuotaBytes
+// means the problem is unlimited and FreeBytes is not meaningful.
+type Usage struct {
+	ProblemId  primitive.ObjectID `json:"problemId"`
+	Title      string             `json:"title"`
+	QuotaBytes int64              `json:"quotaBytes"`
+	UsageBytes int64              `json:"usageBytes"`
+	FreeBytes  int64              `json:"freeBytes"`
+}
+
+func NewUsage(problem t.Problem, usageBytes int64) Usage {
+	usage := Usage{
+		ProblemId:  problem.Id,
+		Title:      problem.Title,
+		QuotaBytes: problem.QuotaBytes,
+		UsageBytes: usageBytes,
+	}
+	if problem.QuotaBytes > 0 {
+		usage.FreeBytes = problem.QuotaBytes - usageBytes
+		if usage.FreeBytes < 0 {
+			usage.FreeBytes = 0
+		}
+	}
+	return usage
+}
+
+// Find returns the tracked usage by problem, for all problems when
+// problemIds is empty.
+func Find(ctx context.Context, conn *rabbitmq.Connection, problemIds []primitive.ObjectID) map[primitive.ObjectID]int64 {
+	result := make(map[primitive.ObjectID]int64)
+	problemUsageFindResp := <-problemUsageFind.Send(ctx, conn, problemUsageFind.RequestData{ProblemIds: problemIds})
+	for _, usage := range problemUsageFindResp.Data.(problemUsageFind.ResponseData).Items {
+		result[usage.ProblemId] = usage.UsageBytes
+	}
+	return result
+}
+
+// Check returns an error with code ErrCodeQuotaExceeded when incoming more
+// bytes do not fit in the quota of the problem.
+func Check(ctx context.Context, conn *rabbitmq.Connection, problemId primitive.ObjectID, incoming int64) error {
+	problemFindOneResp := <-problemFindOne.Send(ctx, conn, problemFindOne.RequestData{Id: problemId})
+	problem := problemFindOneResp.Data.(problemFindOne.ResponseData)
+	if problem.Id.IsZero() {
+		return fmt.Errorf("problem %s not found", problemId.Hex())
+	}
+	if problem.QuotaBytes <= 0 {
+		return nil
+	}
+	usage := NewUsage(problem, Find(ctx, conn, []primitive.ObjectID{problem.Id})[problem.Id])
+	if incoming <= usage.FreeBytes {
+		return nil
+	}
+	return &Error{Usage: usage, Incoming: incoming}
+}
+
+// Report records the bytes an operation added to (or removed from, when
+// negative) the problem directory.
+func Report(ctx context.Context, conn *rabbitmq.Connection, problemId primitive.ObjectID, delta int64) {
+	if delta == 0 || problemId.IsZero() {
+		return
+	}
+	<-problemUsageAdd.Send(ctx, conn, problemUsageAdd.RequestData{ProblemId: problemId, Delta: delta})
+}
+
+type Error struct {
+	Usage    Usage
+	Incoming int64
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf(
+		"problem %q quota exceeded: %d of %d bytes used, %d bytes requested, %d bytes short",
+		e.Usage.Title,
+		e.Usage.UsageBytes,
+		e.Usage.QuotaBytes,
+		e.Incoming,
+		e.Incoming-e.Usage.FreeBytes,
+	)
+}
+
+// ErrCode maps err to the response error code, so quota rejections can be
+// told apart by the client.
+func ErrCode(err error) int {
+	var quotaErr *Error
+	if errors.As(err, &quotaErr) {
+		return kitendpoint.ErrCodeQuotaExceeded
+	}
+	return kitendpoint.ErrCodeUnknown
+}
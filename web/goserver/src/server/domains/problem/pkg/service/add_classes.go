@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+)
+
+type AddClassesRequestData struct {
+	Id      primitive.ObjectID `json:"id"`
+	Classes []ClassData        `json:"classes"`
+}
+
+func (s *basicProblemService) AddClasses(ctx context.Context, req AddClassesRequestData, responseChan chan kitendpoint.Response) {
+	problem, err := s.addClasses(ctx, req)
+	if err != nil {
+		log.Println("domains.problem.pkg.service.add_classes.AddClasses", err)
+		responseChan <- kitendpoint.Response{Data: nil, IsLast: true, Err: kitendpoint.Error{Code: errCode(err), Message: err.Error()}}
+		return
+	}
+	responseChan <- kitendpoint.Response{Data: problem, IsLast: true, Err: kitendpoint.Error{Code: 0}}
+}
+
+func (s *basicProblemService) addClasses(ctx context.Context, req AddClassesRequestData) (t.Problem, error) {
+	problem, err := s.getProblem(ctx, req.Id)
+	if err != nil {
+		return problem, err
+	}
+	if err := access.CheckProblem(ctx, problem, role.Editor); err != nil {
+		return problem, err
+	}
+	for _, class := range req.Classes {
+		if findLabel(problem.Labels, class.Name) != nil {
+			return problem, invalidRequest(fmt.Errorf("class %q already exists", class.Name))
+		}
+		problem.Labels = append(problem.Labels, classToLabel(class, nil))
+	}
+	if err := validateLabels(problem.Labels); err != nil {
+		return problem, invalidRequest(err)
+	}
+	return s.updateProblem(ctx, problem)
+}
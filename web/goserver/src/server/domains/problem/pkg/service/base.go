@@ -9,11 +9,14 @@ import (
 )
 
 type ProblemService interface {
+	AddClasses(ctx context.Context, req AddClassesRequestData, responseChan chan kitendpoint.Response)
 	Create(ctx context.Context, req CreateRequestData, responseChan chan kitendpoint.Response)
 	Delete(ctx context.Context, req DeleteRequestData, responseChan chan kitendpoint.Response)
 	Details(ctx context.Context, req DetailsRequestData, responseChan chan kitendpoint.Response)
 	List(ctx context.Context, req ListRequestData, responseChan chan kitendpoint.Response)
+	Update(ctx context.Context, req UpdateRequestData, responseChan chan kitendpoint.Response)
 	UpdateFromLocal(ctx context.Context, req UpdateFromLocalRequestData, responseChan chan kitendpoint.Response)
+	Usage(ctx context.Context, req UsageRequestData, responseChan chan kitendpoint.Response)
 }
 
 type basicProblemService struct {
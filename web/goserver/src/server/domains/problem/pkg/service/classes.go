@@ -0,0 +1,78 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var colorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// defaultColors are given in turn to classes created without a color.
+var defaultColors = []string{
+	"#e6194b", "#3cb44b", "#ffe119", "#4363d8", "#f58231",
+	"#911eb4", "#46f0f0", "#f032e6", "#bcf60c", "#fabebe",
+}
+
+// ClassData describes a class of the problem. OldName is set on update when
+// the class is renamed.
+type ClassData struct {
+	Name    string `json:"name"`
+	Color   string `json:"color"`
+	OldName string `json:"oldName"`
+}
+
+func labelName(label map[string]interface{}) string {
+	name, _ := label["name"].(string)
+	return name
+}
+
+func findLabel(labels []map[string]interface{}, name string) map[string]interface{} {
+	for _, label := range labels {
+		if labelName(label) == name {
+			return label
+		}
+	}
+	return nil
+}
+
+// validateLabels checks the class names are set and unique and gives a
+// color to the classes missing one.
+func validateLabels(labels []map[string]interface{}) error {
+	seen := make(map[string]bool)
+	for i, label := range labels {
+		name := strings.TrimSpace(labelName(label))
+		if name == "" {
+			return fmt.Errorf("class %d has no name", i)
+		}
+		if seen[name] {
+			return fmt.Errorf("class %q is duplicated", name)
+		}
+		seen[name] = true
+		label["name"] = name
+		color, _ := label["color"].(string)
+		if color == "" {
+			label["color"] = defaultColors[i%len(defaultColors)]
+		} else if !colorRegexp.MatchString(color) {
+			return fmt.Errorf("class %q has invalid color %q", name, color)
+		}
+		if _, ok := label["attributes"]; !ok {
+			label["attributes"] = []interface{}{}
+		}
+	}
+	return nil
+}
+
+// classToLabel keeps the extra keys of the existing label, like the CVAT
+// attributes.
+func classToLabel(class ClassData, existing map[string]interface{}) map[string]interface{} {
+	label := make(map[string]interface{})
+	for k, v := range existing {
+		label[k] = v
+	}
+	label["name"] = class.Name
+	if class.Color != "" {
+		label["color"] = class.Color
+	}
+	return label
+}
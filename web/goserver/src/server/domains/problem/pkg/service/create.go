@@ -16,8 +16,10 @@ import (
 	problemFindOne "server/db/pkg/handler/problem/find_one"
 	problemUpdateUpsert "server/db/pkg/handler/problem/update_upsert"
 	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
 	problemType "server/db/pkg/types/problem/types"
 	modelCreateFromGeneric "server/domains/model/pkg/handler/create_from_generic"
+	"server/kit/auth"
 	kitendpoint "server/kit/endpoint"
 	u "server/kit/utils"
 )
@@ -32,10 +34,17 @@ type CreateRequestData struct {
 }
 
 func (s *basicProblemService) Create(ctx context.Context, req CreateRequestData, responseChan chan kitendpoint.Response) {
+	if err := validateLabels(req.Labels); err != nil {
+		responseChan <- kitendpoint.Response{Data: nil, IsLast: true, Err: kitendpoint.Error{Code: 1, Message: err.Error()}}
+		return
+	}
 	genericProblem := s.getGenericProblem(req.Class)
 	imageUrl := saveImage(req.Image)
 	problemDir := s.createProblemDir(genericProblem.Class, req.Title)
 	problemCreateRequest := getNewProblemCreateRequest(genericProblem, imageUrl, req.Title, req.Subtitle, req.Description, problemDir, genericProblem.Class, req.Labels)
+	if identity, ok := auth.FromContext(ctx); ok {
+		problemCreateRequest.Access = map[string]string{identity.User: role.Owner}
+	}
 	problem := s.createProblem(problemCreateRequest)
 	responseChan <- kitendpoint.Response{Data: problem, IsLast: true, Err: kitendpoint.Error{Code: 0}}
 
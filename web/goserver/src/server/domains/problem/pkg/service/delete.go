@@ -6,6 +6,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	problemDelete "server/db/pkg/handler/problem/delete"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
 	kitendpoint "server/kit/endpoint"
 )
 
@@ -14,6 +16,10 @@ type DeleteRequestData struct {
 }
 
 func (s *basicProblemService) Delete(ctx context.Context, req DeleteRequestData, responseChan chan kitendpoint.Response) {
+	if err := access.Check(ctx, s.Conn, req.Id, role.Owner); err != nil {
+		responseChan <- kitendpoint.Response{Data: nil, IsLast: true, Err: kitendpoint.Error{Code: access.ErrCode(err), Message: err.Error()}}
+		return
+	}
 	problemDeleteResp := <-problemDelete.Send(
 		ctx,
 		s.Conn,
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	problemFind "server/db/pkg/handler/problem/find"
+	problemUsageSet "server/db/pkg/handler/problem_usage/set"
+	t "server/db/pkg/types"
+	"server/domains/problem/pkg/quota"
+	kitendpoint "server/kit/endpoint"
+	uFiles "server/kit/utils/basic/files"
+)
+
+// UsageRequestData lists usage versus quota for all problems. Refresh
+// recomputes the usage from disk instead of the tracked byte deltas.
+type UsageRequestData struct {
+	Refresh bool `json:"refresh"`
+}
+
+func (s *basicProblemService) Usage(ctx context.Context, req UsageRequestData, responseChan chan kitendpoint.Response) {
+	problemFindResp := <-problemFind.Send(ctx, s.Conn, problemFind.RequestData{Page: 1})
+	problems := problemFindResp.Data.(problemFind.ResponseData).Items
+	var usages map[primitive.ObjectID]int64
+	if req.Refresh {
+		usages = s.refreshUsage(ctx, problems)
+	} else {
+		usages = quota.Find(ctx, s.Conn, nil)
+	}
+	result := make([]quota.Usage, 0, len(problems))
+	for _, problem := range problems {
+		result = append(result, quota.NewUsage(problem, usages[problem.Id]))
+	}
+	responseChan <- kitendpoint.Response{Data: result, IsLast: true, Err: kitendpoint.Error{Code: 0}}
+}
+
+func (s *basicProblemService) refreshUsage(ctx context.Context, problems []t.Problem) map[primitive.ObjectID]int64 {
+	result := make(map[primitive.ObjectID]int64)
+	for _, problem := range problems {
+		size, err := uFiles.DirSize(problem.Dir)
+		if err != nil {
+			log.Println("domains.problem.pkg.service.quota.refreshUsage", err)
+			continue
+		}
+		<-problemUsageSet.Send(ctx, s.Conn, problemUsageSet.RequestData{ProblemId: problem.Id, UsageBytes: size})
+		result[problem.Id] = size
+	}
+	return result
+}
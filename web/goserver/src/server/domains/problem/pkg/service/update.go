@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	annotationFind "server/db/pkg/handler/annotation/find"
+	annotationRenameLabel "server/db/pkg/handler/annotation/rename_label"
+	problemFindOne "server/db/pkg/handler/problem/find_one"
+	problemUpdateOne "server/db/pkg/handler/problem/update_one"
+	t "server/db/pkg/types"
+	"server/db/pkg/types/problem/role"
+	"server/domains/problem/pkg/access"
+	kitendpoint "server/kit/endpoint"
+)
+
+// UpdateRequestData changes the problem fields that are set, leaving the
+// others as they are. Classes replaces the class list: classes missing from
+// it are removed, which is refused while annotations use them unless Remap
+// names the class taking over their objects. A zero QuotaBytes lifts the
+// disk quota of the problem. Access, mapping users to their role, replaces
+// the access list and needs owner access. AutoReEvaluate has the stale
+// evaluations of the models run again in the off-peak window of the model
+// service.
+type UpdateRequestData struct {
+	Id          primitive.ObjectID `json:"id"`
+	Class       *string            `json:"class"`
+	Description *string            `json:"description"`
+	Subtitle    *string            `json:"subtitle"`
+	Title       *string            `json:"title"`
+	QuotaBytes  *int64             `json:"quotaBytes"`
+	Defaults    *t.HyperParameters `json:"defaultHyperParameters"`
+	Classes     *[]ClassData       `json:"classes"`
+	Remap       map[string]string  `json:"remap"`
+	Access      map[string]string  `json:"access"`
+
+	AutoReEvaluate *bool `json:"autoReEvaluate"`
+}
+
+// relabel moves the annotations of the class from to the class to, through
+// the temporary class tmp that no other class uses.
+type relabel struct {
+	from, tmp, to string
+}
+
+// errNotFound is the error of the problems that do not exist.
+var errNotFound = errors.New("not found")
+
+// requestError is an error of the request itself, not worth retrying as is.
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+func (e *requestError) Unwrap() error { return e.err }
+
+func invalidRequest(err error) error {
+	return &requestError{err}
+}
+
+// errCode maps err to the response error code.
+func errCode(err error) int {
+	var invalid *requestError
+	switch {
+	case access.ErrCode(err) != kitendpoint.ErrCodeUnknown:
+		return access.ErrCode(err)
+	case errors.Is(err, errNotFound):
+		return kitendpoint.ErrCodeNotFound
+	case errors.As(err, &invalid):
+		return kitendpoint.ErrCodeInvalidArgument
+	}
+	return kitendpoint.ErrCodeUnknown
+}
+
+func (s *basicProblemService) Update(ctx context.Context, req UpdateRequestData, responseChan chan kitendpoint.Response) {
+	problem, err := s.update(ctx, req)
+	if err != nil {
+		log.Println("domains.problem.pkg.service.update.Update", err)
+		responseChan <- kitendpoint.Response{Data: nil, IsLast: true, Err: kitendpoint.Error{Code: errCode(err), Message: err.Error()}}
+		return
+	}
+	responseChan <- kitendpoint.Response{Data: problem, IsLast: true, Err: kitendpoint.Error{Code: 0}}
+}
+
+func (s *basicProblemService) update(ctx context.Context, req UpdateRequestData) (t.Problem, error) {
+	problem, err := s.getProblem(ctx, req.Id)
+	if err != nil {
+		return problem, err
+	}
+	if err := access.CheckProblem(ctx, problem, role.Editor); err != nil {
+		return problem, err
+	}
+	if req.Access != nil {
+		if err := access.CheckProblem(ctx, problem, role.Owner); err != nil {
+			return problem, err
+		}
+		for user, r := range req.Access {
+			if !access.IsRole(r) {
+				return problem, invalidRequest(fmt.Errorf("unknown role %q of user %q", r, user))
+			}
+		}
+	}
+
+	if req.Class != nil {
+		problem.Class = *req.Class
+	}
+	if req.Description != nil {
+		problem.Description = *req.Description
+	}
+	if req.Subtitle != nil {
+		problem.Subtitle = *req.Subtitle
+	}
+	if req.Title != nil {
+		problem.Title = *req.Title
+	}
+	if req.QuotaBytes != nil {
+		problem.QuotaBytes = *req.QuotaBytes
+	}
+	if req.Defaults != nil {
+		problem.DefaultHyperParameters = *req.Defaults
+	}
+	if req.AutoReEvaluate != nil {
+		problem.AutoReEvaluate = *req.AutoReEvaluate
+	}
+	if req.Access != nil {
+		problem.Access = req.Access
+	}
+	if req.Classes == nil {
+		return s.updateProblem(ctx, problem)
+	}
+	moves, err := s.updateLabels(ctx, &problem, *req.Classes, req.Remap)
+	if err != nil {
+		return problem, err
+	}
+
+	// The annotations of every class moved are first set apart under a
+	// temporary class, so that a swap of two names or a rename to the former
+	// name of a class remapped does not merge the classes. They reach their
+	// new classes once the problem is saved, and get their classes back when
+	// it is not.
+	if err := s.stageLabels(ctx, problem.Id, moves); err != nil {
+		return problem, err
+	}
+	saved, err := s.updateProblem(ctx, problem)
+	if err != nil {
+		s.unstageLabels(problem.Id, moves)
+		return problem, err
+	}
+	for _, m := range moves {
+		if err := s.renameLabel(ctx, problem.Id, m.tmp, m.to); err != nil {
+			return saved, fmt.Errorf("annotations of class %q left under the temporary class %q: %w", m.from, m.tmp, err)
+		}
+	}
+	return saved, nil
+}
+
+// updateLabels sets the class list of problem to classes and returns the
+// moves of the annotations of the classes renamed and of the classes removed
+// that remap names a class taking over for, sorted by source class.
+func (s *basicProblemService) updateLabels(ctx context.Context, problem *t.Problem, classes []ClassData, remap map[string]string) ([]relabel, error) {
+	labels := make([]map[string]interface{}, 0, len(classes))
+	renames := make(map[string]string)
+	kept := make(map[string]bool)
+	for _, class := range classes {
+		oldName := class.OldName
+		if oldName == "" {
+			oldName = class.Name
+		}
+		existing := findLabel(problem.Labels, oldName)
+		if existing != nil {
+			kept[oldName] = true
+			if oldName != class.Name {
+				renames[oldName] = class.Name
+			}
+		}
+		labels = append(labels, classToLabel(class, existing))
+	}
+	if err := validateLabels(labels); err != nil {
+		return nil, invalidRequest(err)
+	}
+
+	for _, label := range problem.Labels {
+		name := labelName(label)
+		if kept[name] {
+			continue
+		}
+		target, ok := remap[name]
+		if ok {
+			if findLabel(labels, target) == nil {
+				return nil, invalidRequest(fmt.Errorf("remap target %q of class %q is not in the class list", target, name))
+			}
+			renames[name] = target
+			continue
+		}
+		if s.isLabelUsed(ctx, problem.Id, name) {
+			return nil, invalidRequest(fmt.Errorf("class %q is used by annotations, give a remap target to remove it", name))
+		}
+	}
+
+	problem.Labels = labels
+
+	moves := make([]relabel, 0, len(renames))
+	batch := primitive.NewObjectID().Hex()
+	for from, to := range renames {
+		moves = append(moves, relabel{from: from, to: to})
+	}
+	sort.Slice(moves, func(i, j int) bool { return moves[i].from < moves[j].from })
+	for i := range moves {
+		moves[i].tmp = fmt.Sprintf("relabel-%s-%d", batch, i)
+	}
+	return moves, nil
+}
+
+// stageLabels moves the annotations of the source class of every move to its
+// temporary class, giving them their classes back when a move fails.
+func (s *basicProblemService) stageLabels(ctx context.Context, problemId primitive.ObjectID, moves []relabel) error {
+	for i, m := range moves {
+		if err := s.renameLabel(ctx, problemId, m.from, m.tmp); err != nil {
+			s.unstageLabels(problemId, moves[:i+1])
+			return err
+		}
+	}
+	return nil
+}
+
+// unstageLabels undoes stageLabels. It does not use the context of the
+// request, which may be the reason of the failure.
+func (s *basicProblemService) unstageLabels(problemId primitive.ObjectID, moves []relabel) {
+	for _, m := range moves {
+		if err := s.renameLabel(context.Background(), problemId, m.tmp, m.from); err != nil {
+			log.Println("domains.problem.pkg.service.update.unstageLabels", m.tmp, m.from, err)
+		}
+	}
+}
+
+func (s *basicProblemService) getProblem(ctx context.Context, id primitive.ObjectID) (t.Problem, error) {
+	problemFindOneResp := <-problemFindOne.Send(ctx, s.Conn, problemFindOne.RequestData{Id: id})
+	problem := problemFindOneResp.Data.(problemFindOne.ResponseData)
+	if problem.Id.IsZero() {
+		return problem, fmt.Errorf("problem %s %w", id.Hex(), errNotFound)
+	}
+	return problem, nil
+}
+
+func (s *basicProblemService) updateProblem(ctx context.Context, problem t.Problem) (t.Problem, error) {
+	if err := os.MkdirAll(problem.Dir, 0777); err != nil {
+		return problem, err
+	}
+	problemUpdateOneResp := <-problemUpdateOne.Send(ctx, s.Conn, problem)
+	if problemUpdateOneResp.Err.Code > 0 {
+		return problem, errors.New(problemUpdateOneResp.Err.Message)
+	}
+	return problemUpdateOneResp.Data.(problemUpdateOne.ResponseData), nil
+}
+
+func (s *basicProblemService) isLabelUsed(ctx context.Context, problemId primitive.ObjectID, name string) bool {
+	annotationFindResp := <-annotationFind.Send(ctx, s.Conn, annotationFind.RequestData{ProblemId: problemId, Label: name})
+	return annotationFindResp.Data.(annotationFind.ResponseData).Total > 0
+}
+
+func (s *basicProblemService) renameLabel(ctx context.Context, problemId primitive.ObjectID, from, to string) error {
+	resp := <-annotationRenameLabel.Send(ctx, s.Conn, annotationRenameLabel.RequestData{ProblemId: problemId, From: from, To: to})
+	if resp.Err.Code > 0 {
+		return errors.New(resp.Err.Message)
+	}
+	return nil
+}
@@ -98,6 +98,9 @@ func (s *basicProblemService) createOrUpdateProblem(ctx context.Context, problem
 		Type:        problemData.Type,
 		WorkingDir:  workingDir,
 	}
+	if problemData.DefaultHyperParameters != (t.HyperParameters{}) {
+		requestData.DefaultHyperParameters = &problemData.DefaultHyperParameters
+	}
 
 	problemUpdateUpsertResp := <-problemUpdateUpsert.Send(
 		ctx,
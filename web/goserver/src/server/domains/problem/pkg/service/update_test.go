@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	t "server/db/pkg/types"
+)
+
+// relabelObjects applies the moves to the labels of objects the way the
+// annotations are renamed, one class at a time.
+func relabelObjects(objects []string, moves []relabel) []string {
+	rename := func(from, to string) {
+		for i, label := range objects {
+			if label == from {
+				objects[i] = to
+			}
+		}
+	}
+	for _, m := range moves {
+		rename(m.from, m.tmp)
+	}
+	for _, m := range moves {
+		rename(m.tmp, m.to)
+	}
+	return objects
+}
+
+func TestUpdateLabelsKeepsTheClassesApart(test *testing.T) {
+	s := &basicProblemService{}
+	labels := func(names ...string) []map[string]interface{} {
+		var labels []map[string]interface{}
+		for _, name := range names {
+			labels = append(labels, map[string]interface{}{"name": name})
+		}
+		return labels
+	}
+	for _, c := range []struct {
+		name    string
+		classes []ClassData
+		remap   map[string]string
+		want    []string
+	}{
+		{
+			"swap",
+			[]ClassData{{Name: "car", OldName: "truck"}, {Name: "truck", OldName: "car"}, {Name: "bus"}},
+			nil,
+			[]string{"truck", "car", "bus"},
+		},
+		{
+			"rename to the name of a class remapped",
+			[]ClassData{{Name: "truck", OldName: "car"}, {Name: "bus"}},
+			map[string]string{"truck": "bus"},
+			[]string{"truck", "bus", "bus"},
+		},
+	} {
+		test.Run(c.name, func(test *testing.T) {
+			problem := t.Problem{Labels: labels("car", "truck", "bus")}
+			moves, err := s.updateLabels(context.Background(), &problem, c.classes, c.remap)
+			if err != nil {
+				test.Fatal(err)
+			}
+			if got := relabelObjects([]string{"car", "truck", "bus"}, moves); !reflect.DeepEqual(got, c.want) {
+				test.Errorf("objects relabelled %v, want %v", got, c.want)
+			}
+		})
+	}
+}
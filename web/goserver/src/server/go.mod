@@ -12,5 +12,7 @@ require (
 	github.com/sirius1024/go-amqp-reconnect v1.0.0
 	github.com/streadway/amqp v0.0.0-20200108173154-1c71cc93ed71
 	go.mongodb.org/mongo-driver v1.3.2
+	golang.org/x/text v0.3.2
 	gopkg.in/yaml.v2 v2.2.2
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,167 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	t "server/db/pkg/types"
+	"server/kit/auth"
+	kitendpoint "server/kit/endpoint"
+	"server/kit/redact"
+)
+
+// maxRequestSummary caps the stored request, large payloads are cut.
+const maxRequestSummary = 2048
+
+// Sink stores an entry. It may block, the Log calls it from its own goroutine.
+type Sink func(ctx context.Context, entry t.AuditEntry) error
+
+// Log writes audit entries through a buffer, so a slow sink never delays the
+// audited endpoints. Entries that do not fit in the buffer are dropped and
+// counted; the count is stored with the next entry that gets written.
+type Log struct {
+	sink         Sink
+	entries      chan t.AuditEntry
+	dropped      int64
+	droppedTotal int64
+}
+
+func NewLog(sink Sink, bufferSize int) *Log {
+	l := &Log{
+		sink:    sink,
+		entries: make(chan t.AuditEntry, bufferSize),
+	}
+	go l.run()
+	return l
+}
+
+// Record queues entry without blocking. The credentials found in its message
+// are redacted first, the request is expected to come from Summary.
+func (l *Log) Record(entry t.AuditEntry) {
+	entry.Message = redact.String(entry.Message)
+	select {
+	case l.entries <- entry:
+	default:
+		atomic.AddInt64(&l.dropped, 1)
+		if total := atomic.AddInt64(&l.droppedTotal, 1); total == 1 || total%100 == 0 {
+			log.Println("kit.audit.Log.Record: buffer is full, entries dropped so far", total)
+		}
+	}
+}
+
+// Dropped is the number of entries lost since the start.
+func (l *Log) Dropped() int64 {
+	return atomic.LoadInt64(&l.droppedTotal)
+}
+
+func (l *Log) run() {
+	for entry := range l.entries {
+		entry.Dropped = atomic.SwapInt64(&l.dropped, 0)
+		if err := l.sink(context.Background(), entry); err != nil {
+			log.Println("kit.audit.Log.run.l.sink", err)
+		}
+	}
+}
+
+// Middleware records every call of the endpoint as action once its last
+// response is out, with the caller, the redacted request, the result code and
+// the operation the call started, if any.
+func Middleware(l *Log, action string) kitendpoint.Middleware {
+	return func(next kitendpoint.Endpoint) kitendpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+			start := time.Now()
+			identity, _ := auth.FromContext(ctx)
+			entry := t.AuditEntry{
+				Action:    action,
+				CreatedAt: start,
+				ProblemId: problemIdOf(request),
+				Request:   Summary(request),
+				User:      identity.User,
+			}
+			record := func(code int, message string) {
+				entry.Code = code
+				entry.Message = message
+				entry.DurationMs = int64(time.Since(start) / time.Millisecond)
+				l.Record(entry)
+			}
+			in := next(ctx, request)
+			out := make(chan kitendpoint.Response)
+			go func() {
+				defer close(out)
+				for {
+					select {
+					case resp, ok := <-in:
+						if !ok {
+							record(kitendpoint.ErrCodeUnknown, "no last response")
+							return
+						}
+						if resp.OperationId != "" {
+							entry.OperationId = resp.OperationId
+						}
+						if resp.IsLast {
+							if entry.ProblemId.IsZero() {
+								entry.ProblemId = problemIdOf(resp.Data)
+							}
+							record(resp.Err.Code, resp.Err.Message)
+						}
+						select {
+						case out <- resp:
+						case <-ctx.Done():
+							if !resp.IsLast {
+								record(kitendpoint.ErrCodeUnknown, ctx.Err().Error())
+							}
+							return
+						}
+						if resp.IsLast {
+							return
+						}
+					case <-ctx.Done():
+						record(kitendpoint.ErrCodeUnknown, ctx.Err().Error())
+						return
+					}
+				}
+			}()
+			return out
+		}
+	}
+}
+
+// Summary renders request as JSON without the values of sensitive keys and
+// without the credentials of urls.
+func Summary(request interface{}) string {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return ""
+	}
+	if b, err = json.Marshal(redact.Value(v)); err != nil {
+		return ""
+	}
+	if len(b) > maxRequestSummary {
+		return string(b[:maxRequestSummary]) + "..."
+	}
+	return string(b)
+}
+
+func problemIdOf(v interface{}) primitive.ObjectID {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return primitive.NilObjectID
+	}
+	var data struct {
+		ProblemId string `json:"problemId"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return primitive.NilObjectID
+	}
+	id, _ := primitive.ObjectIDFromHex(data.ProblemId)
+	return id
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/streadway/amqp"
+
+	kittransportamqp "server/kit/transport/amqp"
+)
+
+// RoleAdmin is the user role that bypasses the per-problem access control.
+const RoleAdmin = "admin"
+
+// headerIdentity is the AMQP header carrying the caller identity between the
+// services, so it follows a request through every hop.
+const headerIdentity = "identity"
+
+var ErrUnauthenticated = errors.New("missing or invalid api token")
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	User  string   `json:"user" yaml:"user"`
+	Roles []string `json:"roles" yaml:"roles"`
+}
+
+func (i Identity) HasRole(role string) bool {
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey int
+
+const contextKeyIdentity contextKey = iota
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, contextKeyIdentity, identity)
+}
+
+// FromContext returns the caller identity. Requests started by the services
+// themselves, or served while authentication is disabled, have none.
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(contextKeyIdentity).(Identity)
+	return identity, ok
+}
+
+// PublishIdentity copies the identity of the context into the headers of the
+// outgoing request.
+func PublishIdentity() kittransportamqp.RequestFunc {
+	return func(ctx context.Context, pub *amqp.Publishing, _ *amqp.Delivery) context.Context {
+		identity, ok := FromContext(ctx)
+		if !ok {
+			return ctx
+		}
+		b, err := json.Marshal(identity)
+		if err != nil {
+			log.Println("kit.auth.PublishIdentity.json.Marshal", err)
+			return ctx
+		}
+		if pub.Headers == nil {
+			pub.Headers = amqp.Table{}
+		}
+		pub.Headers[headerIdentity] = string(b)
+		return ctx
+	}
+}
+
+// DeliveryIdentity puts the identity found in the headers of the incoming
+// request into the context.
+func DeliveryIdentity() kittransportamqp.RequestFunc {
+	return func(ctx context.Context, _ *amqp.Publishing, deliv *amqp.Delivery) context.Context {
+		if deliv == nil {
+			return ctx
+		}
+		value, ok := deliv.Headers[headerIdentity].(string)
+		if !ok {
+			return ctx
+		}
+		var identity Identity
+		if err := json.Unmarshal([]byte(value), &identity); err != nil {
+			log.Println("kit.auth.DeliveryIdentity.json.Unmarshal", err)
+			return ctx
+		}
+		return WithIdentity(ctx, identity)
+	}
+}
+
+// Authenticator resolves a bearer token to the identity of its owner.
+type Authenticator interface {
+	Authenticate(token string) (Identity, error)
+}
+
+// StaticTokens authenticates the api tokens listed in a JSON file:
+// [{"token": "...", "user": "alice", "roles": ["admin"]}].
+type StaticTokens map[string]Identity
+
+func LoadStaticTokens(path string) (StaticTokens, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []struct {
+		Token string `json:"token"`
+		Identity
+	}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	tokens := make(StaticTokens)
+	for _, e := range entries {
+		if e.Token == "" || e.User == "" {
+			return nil, errors.New("every api token needs a token and a user")
+		}
+		tokens[e.Token] = e.Identity
+	}
+	return tokens, nil
+}
+
+func (t StaticTokens) Authenticate(token string) (Identity, error) {
+	identity, ok := t[token]
+	if !ok {
+		return Identity{}, ErrUnauthenticated
+	}
+	return identity, nil
+}
+
+// BearerToken returns the token of an "Authorization: Bearer <token>" header.
+func BearerToken(header string) string {
+	const prefix = "bearer "
+	if len(header) > len(prefix) && strings.ToLower(header[:len(prefix)]) == prefix {
+		return strings.TrimSpace(header[len(prefix):])
+	}
+	return ""
+}
@@ -0,0 +1,330 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"gopkg.in/yaml.v2"
+)
+
+const redacted = "[REDACTED]"
+
+// Load fills cfg, a pointer to a struct, from the sources below, each one
+// overriding the previous ones, and validates the result:
+//
+//   - the defaults of the flags of fs,
+//   - the YAML file at path, when path is set,
+//   - the environment variables named by the env tags of the fields,
+//   - the flags of fs set on the command line.
+//
+// Flags match the fields whose yaml tag is the flag name. Fields are checked
+// according to their validate tag, a comma separated list of:
+//
+//   - required: the field is not empty,
+//   - min=N: the number is at least N,
+//   - dir: the folder exists and files can be created in it,
+//   - url: the value, when set, parses as an absolute url.
+//
+// Structs and fields having a Validate() error method are validated by it as
+// well.
+func Load(path string, cfg interface{}, fs *flag.FlagSet) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a pointer to a struct, got %T", cfg)
+	}
+	var err error
+	if fs != nil {
+		fs.VisitAll(func(f *flag.Flag) {
+			if err == nil {
+				err = setByName(v.Elem(), f.Name, f.DefValue)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if err := setFromEnv(v.Elem()); err != nil {
+		return err
+	}
+	if fs != nil {
+		fs.Visit(func(f *flag.Flag) {
+			if err == nil {
+				err = setByName(v.Elem(), f.Name, f.Value.String())
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return Validate(cfg)
+}
+
+// setByName sets the field of v whose yaml tag is name, flags without a field
+// are left alone.
+func setByName(v reflect.Value, name, value string) error {
+	for i := 0; i < v.NumField(); i++ {
+		if yamlName(v.Type().Field(i)) == name {
+			if err := setString(v.Field(i), value); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func setFromEnv(v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if name := field.Tag.Get("env"); name != "" {
+			if value, ok := os.LookupEnv(name); ok {
+				if err := setString(v.Field(i), value); err != nil {
+					return fmt.Errorf("%s: %v", name, err)
+				}
+				continue
+			}
+		}
+		if v.Field(i).Kind() == reflect.Struct {
+			if err := setFromEnv(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setString parses value into f. Lists are comma separated, structs are given
+// in their YAML or JSON form.
+func setString(f reflect.Value, value string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list of %s", f.Type().Elem())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.Set(reflect.ValueOf(items))
+	case reflect.Struct, reflect.Map:
+		if strings.TrimSpace(value) == "" {
+			return nil
+		}
+		return yaml.Unmarshal([]byte(value), f.Addr().Interface())
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
+
+type validator interface {
+	Validate() error
+}
+
+// Validate checks cfg against the validate tags of its fields.
+func Validate(cfg interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(cfg))
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a struct, got %T", cfg)
+	}
+	var problems []string
+	validateStruct(v, "", &problems)
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func validateStruct(v reflect.Value, prefix string, problems *[]string) {
+	if val, ok := v.Interface().(validator); ok {
+		if err := val.Validate(); err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s%v", prefix, err))
+		}
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := prefix + yamlName(field)
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "" {
+				continue
+			}
+			if err := check(v.Field(i), rule); err != nil {
+				*problems = append(*problems, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+		if v.Field(i).Kind() == reflect.Struct {
+			validateStruct(v.Field(i), name+".", problems)
+		} else if val, ok := v.Field(i).Interface().(validator); ok {
+			if err := val.Validate(); err != nil {
+				*problems = append(*problems, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+	}
+}
+
+func check(f reflect.Value, rule string) error {
+	switch {
+	case rule == "required":
+		if isZero(f) {
+			return fmt.Errorf("is required")
+		}
+	case strings.HasPrefix(rule, "min="):
+		min, err := strconv.ParseFloat(strings.TrimPrefix(rule, "min="), 64)
+		if err != nil {
+			return fmt.Errorf("bad rule %q", rule)
+		}
+		var n float64
+		switch f.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(f.Int())
+		case reflect.Float32, reflect.Float64:
+			n = f.Float()
+		default:
+			return fmt.Errorf("min applies to numbers only")
+		}
+		if n < min {
+			return fmt.Errorf("must be at least %v", min)
+		}
+	case rule == "dir":
+		return checkDir(f.String())
+	case rule == "url":
+		if f.String() == "" {
+			return nil
+		}
+		u, err := url.Parse(f.String())
+		if err != nil {
+			return err
+		}
+		if !u.IsAbs() || u.Host == "" {
+			return fmt.Errorf("%q is not an absolute url", f.String())
+		}
+	default:
+		return fmt.Errorf("unknown rule %q", rule)
+	}
+	return nil
+}
+
+func checkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a folder", dir)
+	}
+	f, err := ioutil.TempFile(dir, ".config-")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %v", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func isZero(f reflect.Value) bool {
+	return reflect.DeepEqual(f.Interface(), reflect.Zero(f.Type()).Interface())
+}
+
+func yamlName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// Redacted renders cfg as YAML with the fields tagged secret:"true" hidden.
+func Redacted(cfg interface{}) string {
+	v := reflect.Indirect(reflect.ValueOf(cfg))
+	copied := reflect.New(v.Type()).Elem()
+	copied.Set(v)
+	redact(copied)
+	b, err := yaml.Marshal(copied.Interface())
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+func redact(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		f := v.Field(i)
+		if field.Tag.Get("secret") == "true" {
+			if f.Kind() == reflect.String && f.String() != "" {
+				f.SetString(redacted)
+			} else if f.Kind() != reflect.String {
+				f.Set(reflect.Zero(f.Type()))
+			}
+			continue
+		}
+		if f.Kind() == reflect.Struct {
+			redact(f)
+		}
+	}
+}
+
+// Print logs the effective configuration of the service without its secrets.
+func Print(service string, cfg interface{}) {
+	log.Printf("%s configuration:\n%s", service, Redacted(cfg))
+}
+
+// OnReload calls reload every time the process receives SIGHUP.
+func OnReload(reload func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Println("kit.config.OnReload: SIGHUP received, reloading the configuration")
+			reload()
+		}
+	}()
+}
@@ -4,17 +4,64 @@ import (
 	"context"
 )
 
+// Error codes carried in Error.Code. Zero means success.
+const (
+	ErrCodeOk = iota
+	ErrCodeUnknown
+	ErrCodeBusy
+	ErrCodeQuotaExceeded
+	ErrCodeNotFound
+	ErrCodeInvalidArgument
+	ErrCodeUnauthenticated
+	ErrCodeForbidden
+	// ErrCodePathViolation is a file operation refused for leaving the
+	// folders the service may use.
+	ErrCodePathViolation
+	// ErrCodeRateLimited is a request refused because its client sent too
+	// many of them.
+	ErrCodeRateLimited
+	// ErrCodeCancelled is an operation stopped by CancelOperation.
+	ErrCodeCancelled
+	// ErrCodeConflict is a request refused for clashing with what another
+	// request made, such as a model of the same name.
+	ErrCodeConflict
+)
+
+// Error is the failure of a request. Fields, when set, tells which fields of
+// the request were invalid, so a client can point at each of them.
 type Error struct {
-	Code    int    `json:"code"`
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is what is wrong with the field of a request, by its dotted
+// path.
+type FieldError struct {
+	Field   string `json:"field"`
 	Message string `json:"message"`
 }
 
+// Response is one message of an endpoint stream. Long-running endpoints set
+// OperationId, starting with their first response, so the operation can be
+// looked up after the stream is lost. TraceId names the trace of the request,
+// for reference in bug reports and in the tracing backend.
 type Response struct {
-	Data   interface{} `json:"data"`
-	Err    Error       `json:"err"`
-	IsLast bool        `json:"isLast"`
+	Data        interface{} `json:"data"`
+	Err         Error       `json:"err"`
+	IsLast      bool        `json:"isLast"`
+	OperationId string      `json:"operationId,omitempty"`
+	TraceId     string      `json:"traceId,omitempty"`
 }
 
 type Middleware func(Endpoint) Endpoint
 
+// Chain wraps e in mdw, the first middleware being the outermost.
+func Chain(e Endpoint, mdw []Middleware) Endpoint {
+	for i := len(mdw) - 1; i >= 0; i-- {
+		e = mdw[i](e)
+	}
+	return e
+}
+
 type Endpoint func(context.Context, interface{}) chan Response
@@ -0,0 +1,168 @@
+// Package faults fails the file system and the downloads on purpose, to try
+// how the copies, the atomic writes and the downloads recover from a full
+// disk, a rename across devices or a dropped connection. files.SetFS and
+// u.SetDownloadDoer put its FS and Doer in place.
+package faults
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+
+	u "server/kit/utils"
+	"server/kit/utils/basic/files"
+)
+
+// ErrInjected fails the requests Doer is told to fail.
+var ErrInjected = errors.New("faults: injected failure")
+
+// FS is a files.FS failing as told, Base doing the rest.
+type FS struct {
+	// Base is the file system the operations go to, files.OS when nil.
+	Base files.FS
+	// FailWrite fails the FailWrite-th write to the files FS opened,
+	// counted from 1 across them, and the ones after it. None fails when
+	// zero.
+	FailWrite int64
+	// WriteErr is the error of the failed writes, ENOSPC when nil.
+	WriteErr error
+	// ShortRead makes the reads return ShortRead bytes at most, as a pipe
+	// or a network file system may. The reads are whole when zero.
+	ShortRead int
+	// RenameErr fails the renames, EXDEV making them look across devices.
+	RenameErr error
+
+	writes int64
+}
+
+func (f *FS) base() files.FS {
+	if f.Base == nil {
+		return files.OS
+	}
+	return f.Base
+}
+
+// Writes is the number of writes made to the files FS opened, failed ones
+// included.
+func (f *FS) Writes() int64 {
+	return atomic.LoadInt64(&f.writes)
+}
+
+func (f *FS) wrap(file files.File, err error) (files.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{File: file, fs: f}, nil
+}
+
+func (f *FS) Open(name string) (files.File, error) {
+	return f.wrap(f.base().Open(name))
+}
+
+func (f *FS) Create(name string) (files.File, error) {
+	return f.wrap(f.base().Create(name))
+}
+
+func (f *FS) TempFile(dir, pattern string) (files.File, error) {
+	return f.wrap(f.base().TempFile(dir, pattern))
+}
+
+func (f *FS) Remove(name string) error {
+	return f.base().Remove(name)
+}
+
+func (f *FS) Rename(oldpath, newpath string) error {
+	if f.RenameErr != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: f.RenameErr}
+	}
+	return f.base().Rename(oldpath, newpath)
+}
+
+func (f *FS) MkdirAll(path string, perm os.FileMode) error {
+	return f.base().MkdirAll(path, perm)
+}
+
+func (f *FS) Chmod(name string, mode os.FileMode) error {
+	return f.base().Chmod(name, mode)
+}
+
+type faultyFile struct {
+	files.File
+	fs *FS
+}
+
+func (f *faultyFile) Read(p []byte) (int, error) {
+	if f.fs.ShortRead > 0 && len(p) > f.fs.ShortRead {
+		p = p[:f.fs.ShortRead]
+	}
+	return f.File.Read(p)
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	n := atomic.AddInt64(&f.fs.writes, 1)
+	if f.fs.FailWrite > 0 && n >= f.fs.FailWrite {
+		err := f.fs.WriteErr
+		if err == nil {
+			err = syscall.ENOSPC
+		}
+		return 0, &os.PathError{Op: "write", Path: f.Name(), Err: err}
+	}
+	return f.File.Write(p)
+}
+
+// Doer is a u.HTTPDoer failing as told, Base doing the rest.
+type Doer struct {
+	// Base sends the requests, u.DownloadClient when nil.
+	Base u.HTTPDoer
+	// FailRequests fails the first FailRequests requests with ErrInjected,
+	// as a refused connection.
+	FailRequests int64
+	// DropAfter cuts the bodies after DropAfter bytes with
+	// io.ErrUnexpectedEOF, as a dropped connection. The bodies are whole
+	// when zero.
+	DropAfter int64
+
+	requests int64
+}
+
+// Requests is the number of requests Doer was given, failed ones included.
+func (d *Doer) Requests() int64 {
+	return atomic.LoadInt64(&d.requests)
+}
+
+func (d *Doer) Do(req *http.Request) (*http.Response, error) {
+	if n := atomic.AddInt64(&d.requests, 1); n <= d.FailRequests {
+		return nil, ErrInjected
+	}
+	base := d.Base
+	if base == nil {
+		base = u.DownloadClient
+	}
+	resp, err := base.Do(req)
+	if err != nil || d.DropAfter <= 0 {
+		return resp, err
+	}
+	resp.Body = &droppedBody{ReadCloser: resp.Body, left: d.DropAfter}
+	return resp, nil
+}
+
+// droppedBody fails once left bytes were read.
+type droppedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *droppedBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
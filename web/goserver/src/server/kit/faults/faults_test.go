@@ -0,0 +1,153 @@
+package faults_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	fp "path/filepath"
+	"syscall"
+	"testing"
+
+	"server/kit/faults"
+	u "server/kit/utils"
+	"server/kit/utils/basic/files"
+)
+
+func tempDir(test *testing.T) string {
+	dir, err := ioutil.TempDir("", "faults")
+	if err != nil {
+		test.Fatal(err)
+	}
+	test.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// setFS puts fs in place for the time of the test.
+func setFS(test *testing.T, fs files.FS) {
+	test.Cleanup(files.SetFS(fs))
+}
+
+// names lists the files of dir.
+func names(test *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names
+}
+
+func TestWriteFileAtomicRollsBackAFailedWrite(test *testing.T) {
+	dir := tempDir(test)
+	path := fp.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte("old"), 0666); err != nil {
+		test.Fatal(err)
+	}
+	fs := &faults.FS{FailWrite: 1}
+	setFS(test, fs)
+
+	err := files.WriteFileAtomic(path, []byte("new"), 0666)
+	if !errors.Is(err, syscall.ENOSPC) {
+		test.Fatalf("error %v, want ENOSPC", err)
+	}
+	if fs.Writes() != 1 {
+		test.Errorf("%d writes, want 1", fs.Writes())
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "old" {
+		test.Errorf("content %q, want the old one", b)
+	}
+	if got := names(test, dir); len(got) != 1 {
+		test.Errorf("files %v, the temporary file was left", got)
+	}
+}
+
+func TestWriteFileAtomicAcrossDevices(test *testing.T) {
+	dir := tempDir(test)
+	path := fp.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte("old"), 0666); err != nil {
+		test.Fatal(err)
+	}
+	setFS(test, &faults.FS{RenameErr: syscall.EXDEV})
+
+	err := files.WriteFileAtomic(path, []byte("new"), 0666)
+	if !errors.Is(err, syscall.EXDEV) {
+		test.Fatalf("error %v, want EXDEV", err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "old" {
+		test.Errorf("content %q, want the old one", b)
+	}
+	if got := names(test, dir); len(got) != 1 {
+		test.Errorf("files %v, the temporary file was left", got)
+	}
+}
+
+func TestCopyShortReads(test *testing.T) {
+	dir := tempDir(test)
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	src := fp.Join(dir, "src")
+	if err := ioutil.WriteFile(src, content, 0666); err != nil {
+		test.Fatal(err)
+	}
+	setFS(test, &faults.FS{ShortRead: 7})
+
+	dst := fp.Join(dir, "dst")
+	n, err := files.Copy(src, dst)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		test.Errorf("copied %d bytes, want %d", n, len(content))
+	}
+	if b, _ := ioutil.ReadFile(dst); !bytes.Equal(b, content) {
+		test.Errorf("copy of %d bytes differs from the source", len(b))
+	}
+}
+
+func TestCopyFailedWrite(test *testing.T) {
+	dir := tempDir(test)
+	src := fp.Join(dir, "src")
+	if err := ioutil.WriteFile(src, bytes.Repeat([]byte("x"), 1<<20), 0666); err != nil {
+		test.Fatal(err)
+	}
+	setFS(test, &faults.FS{FailWrite: 2, ShortRead: 1 << 10})
+
+	if _, err := files.Copy(src, fp.Join(dir, "dst")); !errors.Is(err, syscall.ENOSPC) {
+		test.Fatalf("error %v, want ENOSPC", err)
+	}
+}
+
+func TestDoer(test *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 100))
+	}))
+	defer server.Close()
+	d := &faults.Doer{Base: server.Client(), FailRequests: 2, DropAfter: 10}
+	test.Cleanup(u.SetDownloadDoer(d))
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		if _, err := u.DoDownload(req); err != faults.ErrInjected {
+			test.Fatalf("request %d: error %v, want ErrInjected", i+1, err)
+		}
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err := u.DoDownload(req)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != io.ErrUnexpectedEOF || len(b) != 10 {
+		test.Errorf("read %d bytes, %v, want 10 and ErrUnexpectedEOF", len(b), err)
+	}
+	if d.Requests() != 3 {
+		test.Errorf("%d requests, want 3", d.Requests())
+	}
+}
@@ -2,15 +2,21 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"reflect"
 
 	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
 	"github.com/streadway/amqp"
 
 	kittransportamqp "server/kit/transport/amqp"
 
+	"server/kit/auth"
+	"server/kit/encode_decode"
 	"server/kit/endpoint"
+	"server/kit/metrics"
+	"server/kit/trace"
 )
 
 func SendRequest(
@@ -35,6 +41,9 @@ func SendRequest(
 		log.Println("Qos", err)
 	}
 	ctx = context.WithValue(ctx, kittransportamqp.ContextKeyAutoAck, isAutoAsk)
+	name := requestName(req)
+	ctx, span := trace.Start(ctx, "send "+name)
+	span.SetAttribute("messaging.destination", queueName)
 	q, err := ch.QueueDeclare(
 		"",    // name
 		false, // durable
@@ -54,9 +63,38 @@ func SendRequest(
 		dec,
 		kittransportamqp.PublisherBefore(
 			kittransportamqp.SetPublishKey(queueName),
+			auth.PublishIdentity(),
+			trace.PublishTrace(),
 		),
 	)
-	return pub.Endpoint()(ctx, req)
+	return metrics.ObserveRoundTrip(queueName, name, trace.EndOnLast(span, pub.Endpoint()(ctx, req)))
+}
+
+// requestName is the Request or Event field of req, the name the receiving
+// service dispatches on.
+func requestName(req interface{}) string {
+	v := reflect.Indirect(reflect.ValueOf(req))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	for _, field := range []string{"Request", "Event"} {
+		if f := v.FieldByName(field); f.IsValid() && f.Kind() == reflect.String && f.String() != "" {
+			return f.String()
+		}
+	}
+	return ""
+}
+
+// deliveryName is the request or event name of msg.
+func deliveryName(msg amqp.Delivery) string {
+	var req encode_decode.BaseAmqpRequest
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return ""
+	}
+	if req.Request != "" {
+		return req.Request
+	}
+	return req.Event
 }
 
 func HandleRequest(
@@ -75,10 +113,12 @@ func HandleRequest(
 	if err != nil {
 		log.Println("Qos", err)
 	}
+	name := deliveryName(msg)
 	kittransportamqp.NewSubscriber(
-		e,
+		endpoint.Chain(e, []endpoint.Middleware{metrics.Middleware(name), trace.Middleware(name)}),
 		decodeRequest,
 		encodeResponse,
+		kittransportamqp.SubscriberBefore(auth.DeliveryIdentity(), trace.DeliveryTrace()),
 	).ServeDelivery(ch)(&msg)
 
 }
@@ -0,0 +1,77 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
+)
+
+// Amqp checks the message bus connection. A connection being reestablished
+// is degraded, a connection that can not open channels failed.
+func Amqp(conn *rabbitmq.Connection) Check {
+	return func(context.Context) error {
+		if conn.Connection.IsClosed() {
+			return Degraded(errors.New("connection is closed, reconnecting"))
+		}
+		ch, err := conn.Connection.Channel()
+		if err != nil {
+			return err
+		}
+		return ch.Close()
+	}
+}
+
+// Writable checks that files can be created in dir.
+func Writable(dir string) Check {
+	return func(context.Context) error {
+		f, err := ioutil.TempFile(dir, ".readyz-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.Write([]byte("ok")); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+// Downstream checks the /readyz of another service at url. Its problems only
+// degrade the caller, which keeps serving what does not need the service.
+func Downstream(url string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return Degraded(err)
+		}
+		defer resp.Body.Close()
+		var report Report
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			return Degraded(fmt.Errorf("%s: %v", resp.Status, err))
+		}
+		if report.Status == StatusOk {
+			return nil
+		}
+		var problems []string
+		for name, result := range report.Checks {
+			if result.Status != StatusOk {
+				problems = append(problems, fmt.Sprintf("%s %s: %s", name, result.Status, result.Message))
+			}
+		}
+		sort.Strings(problems)
+		return Degraded(fmt.Errorf("%s: %s", report.Status, strings.Join(problems, "; ")))
+	}
+}
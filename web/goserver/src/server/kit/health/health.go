@@ -0,0 +1,190 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"server/kit/metrics"
+)
+
+// Statuses of a check and of the whole service. Degraded services are alive
+// and expected to recover by themselves, failed ones need attention.
+const (
+	StatusOk       = "ok"
+	StatusDegraded = "degraded"
+	StatusFailed   = "failed"
+)
+
+// Names of the checks shared by the services.
+const (
+	CheckAmqp  = "amqp"
+	CheckMongo = "mongo"
+)
+
+// checkTimeout bounds every check, a check that takes longer fails.
+const checkTimeout = 2 * time.Second
+
+// Check returns nil when the dependency it checks is usable. Errors wrapped by
+// Degraded mark the dependency as degraded rather than failed.
+type Check func(ctx context.Context) error
+
+type degradedError struct {
+	error
+}
+
+// Degraded marks err as a state the service recovers from by itself, such as a
+// connection being reestablished.
+func Degraded(err error) error {
+	return degradedError{err}
+}
+
+type CheckResult struct {
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Report is the body of /readyz. Status is the worst status of the checks.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Registry holds the readiness checks of the service. Checks are replaced by
+// name, so a service restarted after a crash registers them again safely.
+type Registry struct {
+	mu     sync.Mutex
+	checks map[string]Check
+}
+
+func NewRegistry() *Registry {
+	return &Registry{checks: map[string]Check{}}
+}
+
+// Default is the registry of the process, served by Handle.
+var Default = NewRegistry()
+
+func Set(name string, check Check) {
+	Default.Set(name, check)
+}
+
+func (r *Registry) Set(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check
+}
+
+// Require makes the named checks fail until they are set, so a service is not
+// ready before it has connected to its dependencies.
+func (r *Registry) Require(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range names {
+		if _, ok := r.checks[name]; !ok {
+			r.checks[name] = func(context.Context) error {
+				return errors.New("not connected yet")
+			}
+		}
+	}
+}
+
+// Ready runs all checks at once and reports their results.
+func (r *Registry) Ready(ctx context.Context) Report {
+	r.mu.Lock()
+	checks := make(map[string]Check, len(r.checks))
+	for name, check := range r.checks {
+		checks[name] = check
+	}
+	r.mu.Unlock()
+
+	report := Report{Status: StatusOk, Checks: make(map[string]CheckResult, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			result := run(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			report.Status = worst(report.Status, result.Status)
+		}(name, check)
+	}
+	wg.Wait()
+	return report
+}
+
+func run(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := CheckResult{Status: StatusOk, DurationMs: int64(time.Since(start) / time.Millisecond)}
+	if err != nil {
+		result.Status = StatusFailed
+		if _, ok := err.(degradedError); ok {
+			result.Status = StatusDegraded
+		}
+		result.Message = err.Error()
+	}
+	return result
+}
+
+func worst(a, b string) string {
+	rank := map[string]int{StatusOk: 0, StatusDegraded: 1, StatusFailed: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// Handle serves /healthz, /readyz and /metrics on mux. /healthz answers as long
+// as the process runs. /readyz answers 200 when the service is ok or degraded
+// and 503 when a check failed, with the results of the checks in the body.
+func Handle(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeJson(w, http.StatusOK, Report{Status: StatusOk})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		report := Default.Ready(r.Context())
+		code := http.StatusOK
+		if report.Status == StatusFailed {
+			code = http.StatusServiceUnavailable
+		}
+		writeJson(w, code, report)
+	})
+	mux.Handle("/metrics", metrics.Handler())
+}
+
+// ListenAndServe serves Handle on addr, doing nothing when addr is empty. The
+// required checks fail until the service sets them.
+func ListenAndServe(addr string, required ...string) {
+	Default.Require(required...)
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	Handle(mux)
+	log.Println("kit.health.ListenAndServe", http.ListenAndServe(addr, mux))
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("kit.health.writeJson", err)
+	}
+}
@@ -0,0 +1,200 @@
+// Package markdown renders the Markdown of model READMEs to HTML that is
+// safe to serve as is: raw HTML is escaped rather than passed through, and
+// links and images keep only http, https, mailto and relative urls.
+//
+// The subset covers what READMEs mostly use: ATX headings, paragraphs,
+// fenced code blocks, block quotes, bullet and numbered lists, rules, code
+// spans, links, images, and ** and * emphasis.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	headingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	bulletPattern  = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	numberPattern  = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	rulePattern    = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	schemePattern  = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.\-]*):`)
+)
+
+// safeSchemes are the only url schemes links and images keep.
+var safeSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// ToHTML renders src.
+func ToHTML(src []byte) string {
+	lines := strings.Split(strings.Replace(string(src), "\r\n", "\n", -1), "\n")
+	var b strings.Builder
+	var paragraph []string
+	list := ""
+	flush := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>")
+			inline(&b, strings.Join(paragraph, "\n"))
+			b.WriteString("</p>\n")
+			paragraph = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			b.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	openList := func(tag string) {
+		if list != tag {
+			closeList()
+			b.WriteString("<" + tag + ">\n")
+			list = tag
+		}
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			closeList()
+			fence := trimmed[:3]
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>")
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
+		case trimmed == "":
+			flush()
+			closeList()
+		case rulePattern.MatchString(line):
+			flush()
+			closeList()
+			b.WriteString("<hr>\n")
+		case headingPattern.MatchString(trimmed):
+			flush()
+			closeList()
+			m := headingPattern.FindStringSubmatch(trimmed)
+			level := string('0' + rune(len(m[1])))
+			b.WriteString("<h" + level + ">")
+			inline(&b, m[2])
+			b.WriteString("</h" + level + ">\n")
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			closeList()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote = append(quote, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")))
+			}
+			i--
+			b.WriteString("<blockquote><p>")
+			inline(&b, strings.Join(quote, "\n"))
+			b.WriteString("</p></blockquote>\n")
+		case bulletPattern.MatchString(line) && len(paragraph) == 0:
+			openList("ul")
+			item(&b, bulletPattern.FindStringSubmatch(line)[1])
+		case numberPattern.MatchString(line) && len(paragraph) == 0:
+			openList("ol")
+			item(&b, numberPattern.FindStringSubmatch(line)[1])
+		default:
+			closeList()
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flush()
+	closeList()
+	return b.String()
+}
+
+func item(b *strings.Builder, text string) {
+	b.WriteString("<li>")
+	inline(b, text)
+	b.WriteString("</li>\n")
+}
+
+// inline renders the spans of text, escaping everything else.
+func inline(b *strings.Builder, text string) {
+	for len(text) > 0 {
+		switch {
+		case text[0] == '`':
+			if end := strings.IndexByte(text[1:], '`'); end >= 0 {
+				b.WriteString("<code>" + html.EscapeString(text[1:1+end]) + "</code>")
+				text = text[end+2:]
+				continue
+			}
+		case strings.HasPrefix(text, "!["):
+			if label, url, rest, ok := link(text[1:]); ok {
+				if isSafeUrl(url) {
+					b.WriteString(`<img src="` + html.EscapeString(url) + `" alt="` + html.EscapeString(label) + `">`)
+				} else {
+					b.WriteString(html.EscapeString(label))
+				}
+				text = rest
+				continue
+			}
+		case text[0] == '[':
+			if label, url, rest, ok := link(text); ok {
+				if isSafeUrl(url) {
+					b.WriteString(`<a href="` + html.EscapeString(url) + `" rel="nofollow noopener noreferrer">`)
+					inline(b, label)
+					b.WriteString("</a>")
+				} else {
+					inline(b, label)
+				}
+				text = rest
+				continue
+			}
+		case strings.HasPrefix(text, "**"):
+			if end := strings.Index(text[2:], "**"); end > 0 {
+				b.WriteString("<strong>")
+				inline(b, text[2:2+end])
+				b.WriteString("</strong>")
+				text = text[end+4:]
+				continue
+			}
+		case text[0] == '*':
+			if end := strings.IndexByte(text[1:], '*'); end > 0 {
+				b.WriteString("<em>")
+				inline(b, text[1:1+end])
+				b.WriteString("</em>")
+				text = text[end+2:]
+				continue
+			}
+		}
+		b.WriteString(html.EscapeString(text[:1]))
+		text = text[1:]
+	}
+}
+
+// link splits text, starting with [label](url), into its label, its url
+// without title, and what follows.
+func link(text string) (label, url, rest string, ok bool) {
+	end := strings.Index(text, "](")
+	if !strings.HasPrefix(text, "[") || end < 0 {
+		return "", "", "", false
+	}
+	close := strings.IndexByte(text[end+2:], ')')
+	if close < 0 {
+		return "", "", "", false
+	}
+	label = text[1:end]
+	fields := strings.Fields(text[end+2 : end+2+close])
+	if len(fields) > 0 {
+		url = strings.Trim(fields[0], "<>")
+	}
+	return label, url, text[end+3+close:], true
+}
+
+// isSafeUrl refuses the urls with control characters as well, which browsers
+// drop before reading the scheme.
+func isSafeUrl(url string) bool {
+	for _, r := range url {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	m := schemePattern.FindStringSubmatch(url)
+	return m == nil || safeSchemes[strings.ToLower(m[1])]
+}
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	kitendpoint "server/kit/endpoint"
+)
+
+var (
+	endpointRequests = NewCounter(
+		"endpoint_requests_total",
+		"Requests served by the endpoints of the service, by endpoint and error class.",
+		"endpoint", "error",
+	)
+	endpointDuration = NewHistogram(
+		"endpoint_request_duration_seconds",
+		"Time from the request to the last response of the endpoint.",
+		DefBuckets,
+		"endpoint",
+	)
+	roundTrips = NewCounter(
+		"amqp_round_trips_total",
+		"Requests sent to other services, by queue, request and error class.",
+		"queue", "request", "error",
+	)
+	roundTripDuration = NewHistogram(
+		"amqp_round_trip_duration_seconds",
+		"Time from sending a request to another service to its last response.",
+		DefBuckets,
+		"queue", "request",
+	)
+)
+
+// ErrorClass names an error code of kit/endpoint for use as a label.
+func ErrorClass(code int) string {
+	switch code {
+	case kitendpoint.ErrCodeOk:
+		return "ok"
+	case kitendpoint.ErrCodeUnknown:
+		return "unknown"
+	case kitendpoint.ErrCodeBusy:
+		return "busy"
+	case kitendpoint.ErrCodeQuotaExceeded:
+		return "quota_exceeded"
+	case kitendpoint.ErrCodeNotFound:
+		return "not_found"
+	case kitendpoint.ErrCodeInvalidArgument:
+		return "invalid_argument"
+	case kitendpoint.ErrCodeUnauthenticated:
+		return "unauthenticated"
+	case kitendpoint.ErrCodeForbidden:
+		return "forbidden"
+	case kitendpoint.ErrCodePathViolation:
+		return "path_violation"
+	case kitendpoint.ErrCodeRateLimited:
+		return "rate_limited"
+	case kitendpoint.ErrCodeCancelled:
+		return "cancelled"
+	case kitendpoint.ErrCodeConflict:
+		return "conflict"
+	}
+	return "other"
+}
+
+// Middleware counts the calls of the endpoint called name and times them up to
+// their last response. name must come from a fixed set, such as the event
+// names of common/names.
+func Middleware(name string) kitendpoint.Middleware {
+	return func(next kitendpoint.Endpoint) kitendpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+			start := time.Now()
+			return observe(next(ctx, request), func(code int) {
+				endpointRequests.Inc(name, ErrorClass(code))
+				endpointDuration.Observe(time.Since(start).Seconds(), name)
+			})
+		}
+	}
+}
+
+// ObserveRoundTrip times the responses to request sent to queue, as returned
+// by a publisher, up to the last one.
+func ObserveRoundTrip(queue, request string, in chan kitendpoint.Response) chan kitendpoint.Response {
+	start := time.Now()
+	return observe(in, func(code int) {
+		roundTrips.Inc(queue, request, ErrorClass(code))
+		roundTripDuration.Observe(time.Since(start).Seconds(), queue, request)
+	})
+}
+
+// observe forwards in and calls done with the code of the last response, or
+// with ErrCodeUnknown when in closes without one.
+func observe(in chan kitendpoint.Response, done func(code int)) chan kitendpoint.Response {
+	out := make(chan kitendpoint.Response)
+	go func() {
+		defer close(out)
+		for resp := range in {
+			if resp.IsLast {
+				done(resp.Err.Code)
+			}
+			out <- resp
+			if resp.IsLast {
+				return
+			}
+		}
+		done(kitendpoint.ErrCodeUnknown)
+	}()
+	return out
+}
@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxSeries bounds the label combinations of a metric. Labels only ever take
+// values from a fixed set, endpoint names or error classes, the cap guards
+// against a label fed with anything else by mistake.
+const maxSeries = 1000
+
+// DefBuckets are the upper bounds, in seconds, of the duration histograms.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Registry holds metrics and writes them in the Prometheus text format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// Default is the registry of the process, served by Handler.
+var Default = NewRegistry()
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+}
+
+// register returns the family called name, creating it on first use so
+// metrics declared again after a service restart keep their values.
+func (r *Registry) register(name, help, typ string, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		return f
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.families[name] = f
+	return f
+}
+
+// with calls update with the series of labelValues under the family lock.
+func (f *family) with(labelValues []string, update func(s *series)) {
+	if len(labelValues) != len(f.labels) {
+		log.Println("kit.metrics.family.with: wrong number of label values for", f.name, labelValues)
+		return
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		if len(f.series) >= maxSeries {
+			return
+		}
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.typ == typeHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	update(s)
+}
+
+// Counter is a value that only goes up.
+type Counter struct{ f *family }
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, typeCounter, nil, labels)}
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.with(labelValues, func(s *series) { s.value += v })
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct{ f *family }
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, typeGauge, nil, labels)}
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.with(labelValues, func(s *series) { s.value = v })
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.with(labelValues, func(s *series) { s.value += v })
+}
+
+// Histogram counts observations in buckets of increasing upper bounds.
+type Histogram struct{ f *family }
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r.register(name, help, typeHistogram, buckets, labels)}
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.with(labelValues, func(s *series) {
+		for i, bound := range h.f.buckets {
+			if v <= bound {
+				s.counts[i]++
+			}
+		}
+		s.count++
+		s.value += v
+	})
+}
+
+// Handler serves the metrics of the Default registry.
+func Handler() http.Handler {
+	return Default
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		r.mu.Lock()
+		f := r.families[name]
+		r.mu.Unlock()
+		f.write(bw)
+	}
+	if err := bw.Flush(); err != nil {
+		log.Println("kit.metrics.Registry.ServeHTTP", err)
+	}
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.typ != typeHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelPairs(f.labels, s.labelValues, "", 0), formatFloat(s.value))
+			continue
+		}
+		for i, bound := range f.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelPairs(f.labels, s.labelValues, "le", bound), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelPairs(f.labels, s.labelValues, "le", math.Inf(1)), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelPairs(f.labels, s.labelValues, "", 0), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelPairs(f.labels, s.labelValues, "", 0), s.count)
+	}
+}
+
+// labelPairs renders {name="value",...}, with the le label of a bucket when
+// le is set.
+func labelPairs(names, values []string, le string, bound float64) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabel(values[i])))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", le, formatFloat(bound)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,332 @@
+// Package notify tells the users that a long-running operation ended, by a
+// mail sent through an SMTP relay or by a message posted to a Slack incoming
+// webhook. A notification is made once, the outcome of each channel being
+// returned to be kept with the operation rather than retried.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	t "server/db/pkg/types"
+	"server/kit/metrics"
+	"server/kit/redact"
+)
+
+// Outcomes of an operation a subscription may ask to be notified on.
+const (
+	OnSuccess = "success"
+	OnFailure = "failure"
+)
+
+// Channels of the notifications.
+const (
+	ChannelEmail = "email"
+	ChannelSlack = "slack"
+)
+
+// DefaultSlackHost is the only host Slack webhooks may point to when the
+// settings allow none.
+const DefaultSlackHost = "hooks.slack.com"
+
+var notifications = metrics.NewCounter(
+	"notifications_total",
+	"Operation notifications, by channel and result: sent or failed.",
+	"channel", "result",
+)
+
+// Subscription tells when and where to notify. On holds OnSuccess and
+// OnFailure, nothing is notified when it is empty.
+type Subscription struct {
+	On              []string `json:"on" yaml:"on"`
+	Email           string   `json:"email" yaml:"email"`
+	SlackWebhookUrl string   `json:"slackWebhookUrl" yaml:"slackWebhookUrl"`
+}
+
+// Wants tells whether the subscription asks for outcome.
+func (s Subscription) Wants(outcome string) bool {
+	for _, on := range s.On {
+		if on == outcome {
+			return true
+		}
+	}
+	return false
+}
+
+// Settings tell how the notifications are sent. Mails are only sent when
+// SmtpAddr is set, Users are the subscriptions of the users, by name, used
+// by the requests that do not give theirs. BaseUrl prefixes the links to the
+// operations.
+type Settings struct {
+	SmtpAddr          string
+	SmtpUser          string
+	SmtpPassword      string
+	From              string
+	SlackAllowedHosts []string
+	Timeout           time.Duration
+	BaseUrl           string
+	Users             map[string]Subscription
+}
+
+// Message is a notification, Link leading to what it is about.
+type Message struct {
+	Subject string
+	Text    string
+	Link    string
+}
+
+// Notifier sends the notifications. A nil Notifier sends none.
+type Notifier struct {
+	settings Settings
+	client   *http.Client
+}
+
+func New(settings Settings) *Notifier {
+	if len(settings.SlackAllowedHosts) == 0 {
+		settings.SlackAllowedHosts = []string{DefaultSlackHost}
+	}
+	return &Notifier{
+		settings: settings,
+		client: &http.Client{
+			Timeout: settings.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Subscription merges the subscription of a request into the one of user,
+// the fields req sets taking over, and checks the result.
+func (n *Notifier) Subscription(user string, req Subscription) (Subscription, error) {
+	var sub Subscription
+	if n != nil {
+		sub = n.settings.Users[user]
+	}
+	if len(req.On) > 0 {
+		sub.On = req.On
+	}
+	if req.Email != "" {
+		sub.Email = req.Email
+	}
+	if req.SlackWebhookUrl != "" {
+		sub.SlackWebhookUrl = req.SlackWebhookUrl
+	}
+	if len(sub.On) == 0 {
+		return Subscription{}, nil
+	}
+	return sub, n.Check(sub)
+}
+
+// Check refuses the subscriptions with an unknown outcome, no channel or a
+// channel the notifier cannot serve.
+func (n *Notifier) Check(sub Subscription) error {
+	for _, on := range sub.On {
+		if on != OnSuccess && on != OnFailure {
+			return fmt.Errorf("notify on %q is not one of %q and %q", on, OnSuccess, OnFailure)
+		}
+	}
+	if len(sub.On) == 0 {
+		return nil
+	}
+	if n == nil {
+		return errors.New("notifications are not enabled")
+	}
+	if sub.Email == "" && sub.SlackWebhookUrl == "" {
+		return errors.New("no email nor slack webhook url to notify")
+	}
+	if sub.Email != "" {
+		if n.settings.SmtpAddr == "" {
+			return errors.New("email notifications are not enabled")
+		}
+		if _, err := mail.ParseAddress(sub.Email); err != nil {
+			return fmt.Errorf("notification email %q: %v", sub.Email, err)
+		}
+	}
+	if sub.SlackWebhookUrl != "" {
+		u, err := url.Parse(sub.SlackWebhookUrl)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "https" || u.User != nil {
+			return fmt.Errorf("slack webhook url %q must be https without credentials", slackTarget(sub.SlackWebhookUrl))
+		}
+		allowed := false
+		for _, host := range n.settings.SlackAllowedHosts {
+			allowed = allowed || strings.EqualFold(host, u.Hostname())
+		}
+		if !allowed {
+			return fmt.Errorf("slack webhook host %q is not allowed", u.Hostname())
+		}
+	}
+	return nil
+}
+
+// Link is the url of path under the base url, empty when there is none.
+func (n *Notifier) Link(path string) string {
+	if n == nil || n.settings.BaseUrl == "" {
+		return ""
+	}
+	return strings.TrimRight(n.settings.BaseUrl, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// Send notifies msg on each channel of sub and returns how it went for each
+// of them. It does not check sub, which Subscription did.
+func (n *Notifier) Send(ctx context.Context, sub Subscription, msg Message) []t.OperationNotification {
+	if n == nil {
+		return nil
+	}
+	var sent []t.OperationNotification
+	result := func(channel, target string, err error) {
+		notification := t.OperationNotification{Channel: channel, Target: target, SentAt: time.Now()}
+		if err != nil {
+			redact.Println("kit.notify.Notifier.Send", channel, target, err)
+			notification.Error = redact.String(err.Error())
+			notifications.Inc(channel, "failed")
+		} else {
+			notifications.Inc(channel, "sent")
+		}
+		sent = append(sent, notification)
+	}
+	if sub.Email != "" {
+		result(ChannelEmail, sub.Email, n.mail(ctx, sub.Email, msg))
+	}
+	if sub.SlackWebhookUrl != "" {
+		result(ChannelSlack, slackTarget(sub.SlackWebhookUrl), n.slack(ctx, sub.SlackWebhookUrl, msg))
+	}
+	return sent
+}
+
+// mail sends msg to the address to, upgrading the connection to TLS when
+// the relay offers it.
+func (n *Notifier) mail(ctx context.Context, to string, msg Message) error {
+	from, err := mail.ParseAddress(n.settings.From)
+	if err != nil {
+		return fmt.Errorf("notification sender %q: %v", n.settings.From, err)
+	}
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(n.settings.SmtpAddr)
+	if err != nil {
+		return err
+	}
+	dialer := net.Dialer{Timeout: n.settings.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", n.settings.SmtpAddr)
+	if err != nil {
+		return err
+	}
+	if n.settings.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(n.settings.Timeout))
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.settings.SmtpUser != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.settings.SmtpUser, n.settings.SmtpPassword, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(rcpt.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(mailBody(from, rcpt, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func mailBody(from, to *mail.Address, msg Message) []byte {
+	var b bytes.Buffer
+	header := func(key, value string) {
+		b.WriteString(key + ": " + value + "\r\n")
+	}
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	b.WriteString("\r\n")
+	text := msg.Text
+	if msg.Link != "" {
+		text += "\n\n" + msg.Link
+	}
+	b.WriteString(strings.Replace(strings.TrimRight(text, "\n"), "\n", "\r\n", -1) + "\r\n")
+	return b.Bytes()
+}
+
+// slack posts msg to the incoming webhook rawUrl.
+func (n *Notifier) slack(ctx context.Context, rawUrl string, msg Message) error {
+	text := "*" + msg.Subject + "*\n" + msg.Text
+	if msg.Link != "" {
+		text += "\n<" + msg.Link + "|Open the operation>"
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, rawUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		// The error would hold the url, whose path is the secret.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("slack webhook answered " + resp.Status)
+	}
+	return nil
+}
+
+// slackTarget is the url of a Slack webhook without its path, which is the
+// secret of the webhook.
+func slackTarget(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Host == "" {
+		return redact.Redacted
+	}
+	return u.Scheme + "://" + u.Host
+}
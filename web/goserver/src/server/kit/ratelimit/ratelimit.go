@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the buckets that filled up again are dropped.
+const sweepInterval = time.Minute
+
+// Limiter keeps a token bucket per key, refilled at rate tokens per second up
+// to burst tokens. Every request takes one token.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter allowing rate requests per second per key, with
+// bursts of up to burst requests. rate has to be positive, a burst below one
+// is taken for one.
+func New(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// Burst is the number of requests a key may send at once.
+func (l *Limiter) Burst() int {
+	return int(l.burst)
+}
+
+// Allow takes a token from the bucket of key. It returns whether there was
+// one, the whole tokens left and, when there are none, how long until the
+// next one.
+func (l *Limiter) Allow(key string, now time.Time) (ok bool, remaining int, wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed.Seconds()*l.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	}
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	return ok, int(b.tokens), wait
+}
+
+// sweep drops the buckets that are full again, they are the same as new ones.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
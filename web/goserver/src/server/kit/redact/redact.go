@@ -0,0 +1,150 @@
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are matched case-insensitively against keys, the values of
+// matching keys are masked.
+var sensitiveKeys = []string{"authorization", "credential", "password", "secret", "signature", "token"}
+
+var (
+	urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.\-]*://[^\s"'<>]+`)
+	// pairPattern matches key=value and key: value pairs whose key holds one
+	// of sensitiveKeys, with an optional bearer scheme before the value.
+	pairPattern = regexp.MustCompile(`(?i)([\w.\-]*(?:` + strings.Join(sensitiveKeys, "|") + `)[\w.\-]*"?\s*[=:]\s*)((?:bearer\s+)?(?:"[^"]*"|[^\s&,;"]+))`)
+)
+
+// minSecretLength is the length under which values are not registered as
+// secrets, masking them would garble the text around.
+const minSecretLength = 6
+
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// AddSecret has s masked wherever String finds it, for the values that are
+// secret whatever key they come with, such as credentials.
+func AddSecret(s string) {
+	if len(s) < minSecretLength {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, v := range secrets.values {
+		if v == s {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, s)
+}
+
+// IsSensitive tells whether the values of key are masked.
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Url strips the user info of s and masks the values of its sensitive query
+// parameters. s is returned as is when it is not an url.
+func Url(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	if u.User == nil && u.RawQuery == "" {
+		return s
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, param := range params {
+			key := param
+			if j := strings.Index(param, "="); j >= 0 {
+				key = param[:j]
+			}
+			if unescaped, err := url.QueryUnescape(key); err == nil && IsSensitive(unescaped) {
+				params[i] = key + "=" + Redacted
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	return u.String()
+}
+
+// String redacts the urls found in the text s and masks the values of the
+// sensitive key=value and key: value pairs and the secrets.
+func String(s string) string {
+	secrets.RLock()
+	for _, v := range secrets.values {
+		s = strings.Replace(s, v, Redacted, -1)
+	}
+	secrets.RUnlock()
+	s = urlPattern.ReplaceAllStringFunc(s, Url)
+	return pairPattern.ReplaceAllStringFunc(s, func(pair string) string {
+		m := pairPattern.FindStringSubmatch(pair)
+		if strings.HasSuffix(m[2], `"`) {
+			return m[1] + `"` + Redacted + `"`
+		}
+		return m[1] + Redacted
+	})
+}
+
+// Value redacts v, a value decoded from JSON, in place: the values of the
+// sensitive keys of its objects are masked and its strings are redacted.
+func Value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if IsSensitive(key) {
+				v[key] = Redacted
+			} else {
+				v[key] = Value(value)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = Value(value)
+		}
+		return v
+	case string:
+		return String(v)
+	}
+	return v
+}
+
+// Hash identifies s without revealing it, so that redacted values can still
+// be compared.
+func Hash(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// Println logs its operands like log.Println once they are redacted.
+func Println(v ...interface{}) {
+	log.Output(2, String(fmt.Sprintln(v...)))
+}
+
+// Printf logs like log.Printf once the message is redacted.
+func Printf(format string, v ...interface{}) {
+	log.Output(2, String(fmt.Sprintf(format, v...)))
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"strings"
+
+	"server/kit/utils/basic/files"
+)
+
+// Local keeps the files of key at Root/key on the local file system.
+type Local struct {
+	Root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{Root: root}
+}
+
+func (l *Local) Scheme() string {
+	return SchemeFile
+}
+
+// LocalPath is always the path of the file of key.
+func (l *Local) LocalPath(key string) (string, bool) {
+	return fp.Join(l.Root, fp.FromSlash(key)), true
+}
+
+func (l *Local) path(key string) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	path, _ := l.LocalPath(key)
+	return path, nil
+}
+
+func (l *Local) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Create writes to a temporary file next to the file of key, renamed over
+// it on Commit.
+func (l *Local) Create(ctx context.Context, key string) (Writer, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(fp.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(fp.Dir(path), "."+fp.Base(path)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	files.RegisterTemp(ctx, tmp.Name())
+	return &localWriter{File: tmp, path: path}, nil
+}
+
+type localWriter struct {
+	*os.File
+	path string
+	done bool
+}
+
+func (w *localWriter) Commit() error {
+	if err := w.File.Sync(); err != nil {
+		return err
+	}
+	if err := w.File.Close(); err != nil {
+		return err
+	}
+	w.done = true
+	if err := os.Chmod(w.File.Name(), 0666); err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+	if err := os.Rename(w.File.Name(), w.path); err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+	return nil
+}
+
+func (w *localWriter) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
+
+func (l *Local) Stat(_ context.Context, key string) (Info, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Walk lists the regular files under the folder of prefix, symbolic links
+// are not followed.
+func (l *Local) Walk(ctx context.Context, prefix string, fn func(Info) error) error {
+	root := l.Root
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		var err error
+		if root, err = l.path(prefix); err != nil {
+			return err
+		}
+	}
+	err := fp.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := fp.Rel(l.Root, path)
+		if err != nil {
+			return err
+		}
+		return fn(Info{Key: fp.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) Remove(_ context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (l *Local) Move(_ context.Context, from, to string) error {
+	fromPath, err := l.path(from)
+	if err != nil {
+		return err
+	}
+	toPath, err := l.path(to)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(fp.Dir(toPath), 0777); err != nil {
+		return err
+	}
+	return os.Rename(fromPath, toPath)
+}
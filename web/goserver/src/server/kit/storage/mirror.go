@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// Mirror keeps the files in Remote, the storage of record, and a working
+// copy of them in Local for the tools that need them on the file system.
+// The files written through the mirror go to both, those read come from the
+// working copy when it has them. Sync publishes the files written to the
+// working copy directly.
+type Mirror struct {
+	Local  *Local
+	Remote Storage
+}
+
+func (m *Mirror) Scheme() string {
+	return m.Remote.Scheme()
+}
+
+func (m *Mirror) LocalPath(key string) (string, bool) {
+	return m.Local.LocalPath(key)
+}
+
+func (m *Mirror) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := m.Local.Open(ctx, key)
+	if IsNotExist(err) {
+		return m.Remote.Open(ctx, key)
+	}
+	return r, err
+}
+
+// Create writes the working copy, uploaded to Remote on Commit.
+func (m *Mirror) Create(ctx context.Context, key string) (Writer, error) {
+	w, err := m.Local.Create(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &mirrorWriter{Writer: w, mirror: m, ctx: ctx, key: key}, nil
+}
+
+type mirrorWriter struct {
+	Writer
+	mirror *Mirror
+	ctx    context.Context
+	key    string
+}
+
+func (w *mirrorWriter) Commit() error {
+	if err := w.Writer.Commit(); err != nil {
+		return err
+	}
+	return w.mirror.upload(w.ctx, w.key)
+}
+
+func (m *Mirror) upload(ctx context.Context, key string) error {
+	r, err := m.Local.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := m.Remote.Create(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Commit()
+}
+
+func (m *Mirror) Stat(ctx context.Context, key string) (Info, error) {
+	info, err := m.Local.Stat(ctx, key)
+	if IsNotExist(err) {
+		return m.Remote.Stat(ctx, key)
+	}
+	return info, err
+}
+
+// Walk lists the files of Remote, the working copy possibly missing some
+// and holding others not published yet.
+func (m *Mirror) Walk(ctx context.Context, prefix string, fn func(Info) error) error {
+	return m.Remote.Walk(ctx, prefix, fn)
+}
+
+func (m *Mirror) Remove(ctx context.Context, key string) error {
+	if err := m.Local.Remove(ctx, key); err != nil && !IsNotExist(err) {
+		return err
+	}
+	return m.Remote.Remove(ctx, key)
+}
+
+func (m *Mirror) Move(ctx context.Context, from, to string) error {
+	if err := m.Local.Move(ctx, from, to); err != nil && !IsNotExist(err) {
+		return err
+	}
+	return m.Remote.Move(ctx, from, to)
+}
+
+// SyncResult counts what Sync uploaded and removed.
+type SyncResult struct {
+	Uploaded     int
+	UploadedSize int64
+	Unchanged    int
+	Removed      int
+}
+
+// Sync publishes the working copy of the folder prefix: its files missing
+// from Remote or different there are uploaded, the files of Remote it no
+// longer has are removed. hashes are the sha256 of the files known already,
+// by key, the others are hashed. Nothing is done for the storages without a
+// working copy.
+func Sync(ctx context.Context, s Storage, prefix string, hashes map[string]string) (SyncResult, error) {
+	var result SyncResult
+	m, ok := s.(*Mirror)
+	if !ok {
+		return result, nil
+	}
+	local := make(map[string]bool)
+	err := m.Local.Walk(ctx, prefix, func(info Info) error {
+		local[info.Key] = true
+		sha, ok := hashes[info.Key]
+		if !ok {
+			var err error
+			if sha, err = m.Local.sha256(info.Key); err != nil {
+				return err
+			}
+		}
+		remote, err := m.Remote.Stat(ctx, info.Key)
+		if err == nil && remote.Size == info.Size && remote.Sha256 == sha {
+			result.Unchanged++
+			return nil
+		}
+		if err != nil && !IsNotExist(err) {
+			return err
+		}
+		if err := m.upload(ctx, info.Key); err != nil {
+			return err
+		}
+		result.Uploaded++
+		result.UploadedSize += info.Size
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	var stale []string
+	err = m.Remote.Walk(ctx, prefix, func(info Info) error {
+		if !local[info.Key] {
+			stale = append(stale, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	for _, key := range stale {
+		if err := m.Remote.Remove(ctx, key); err != nil {
+			return result, err
+		}
+		result.Removed++
+	}
+	return result, nil
+}
+
+func (l *Local) sha256(key string) (string, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"server/kit/redact"
+)
+
+// SchemeGs is the scheme of the urls of the objects of Google Cloud
+// Storage, SchemeS3 that of the objects of S3.
+const SchemeGs = "gs"
+
+// gsEndpoint serves the interoperability API of Google Cloud Storage, which
+// takes requests signed as those of S3 with HMAC keys.
+const gsEndpoint = "https://storage.googleapis.com"
+
+// ObjectSettings are the credentials object urls are fetched with, S3 for
+// s3://bucket/key and Gs, HMAC keys, for gs://bucket/object. A scheme
+// without an access key is disabled. Bucket and Prefix are not used, the
+// endpoint of Gs defaults to that of Google Cloud Storage.
+type ObjectSettings struct {
+	S3 S3Settings
+	Gs S3Settings
+}
+
+var objectSources struct {
+	sync.RWMutex
+	byScheme map[string]S3Settings
+}
+
+// SetObjectSources makes the object urls of the schemes of settings that
+// have credentials fetchable.
+func SetObjectSources(settings ObjectSettings) error {
+	byScheme := make(map[string]S3Settings)
+	if settings.S3.AccessKey != "" {
+		if settings.S3.Endpoint == "" {
+			region := settings.S3.Region
+			if region == "" {
+				region = defaultS3Region
+			}
+			settings.S3.Endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+		byScheme[SchemeS3] = settings.S3
+	}
+	if settings.Gs.AccessKey != "" {
+		if settings.Gs.Endpoint == "" {
+			settings.Gs.Endpoint = gsEndpoint
+		}
+		if settings.Gs.Region == "" {
+			settings.Gs.Region = "auto"
+		}
+		byScheme[SchemeGs] = settings.Gs
+	}
+	for scheme, s := range byScheme {
+		if _, err := NewS3(s); err != nil {
+			return fmt.Errorf("%s objects: %v", scheme, err)
+		}
+		redact.AddSecret(s.SecretKey)
+	}
+	objectSources.Lock()
+	defer objectSources.Unlock()
+	objectSources.byScheme = byScheme
+	return nil
+}
+
+// IsObjectUrl tells whether rawUrl names an object of a cloud storage,
+// rather than a file served over http.
+func IsObjectUrl(rawUrl string) bool {
+	u, err := url.Parse(rawUrl)
+	return err == nil && (u.Scheme == SchemeS3 || u.Scheme == SchemeGs)
+}
+
+// ObjectsDisabledError is the error of an object url whose scheme has no
+// credentials configured.
+type ObjectsDisabledError struct {
+	Scheme string
+}
+
+func (e *ObjectsDisabledError) Error() string {
+	return fmt.Sprintf("%s:// sources are not enabled, their credentials are not configured", e.Scheme)
+}
+
+// objectOf returns the client of the bucket of the object url rawUrl and the
+// key of the object in it.
+func objectOf(rawUrl string, timeout bool) (*S3, string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, "", err
+	}
+	objectSources.RLock()
+	settings, ok := objectSources.byScheme[u.Scheme]
+	objectSources.RUnlock()
+	if !ok {
+		return nil, "", &ObjectsDisabledError{Scheme: u.Scheme}
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, "", fmt.Errorf("%s is not of the form %s://bucket/key", rawUrl, u.Scheme)
+	}
+	settings.Bucket, settings.Prefix = u.Host, ""
+	if !timeout {
+		// The downloads are bounded by their context, as the other ones.
+		settings.Timeout = 0
+	}
+	s, err := NewS3(settings)
+	return s, key, err
+}
+
+// OpenObject opens the object of the url rawUrl, along with its size.
+func OpenObject(ctx context.Context, rawUrl string) (io.ReadCloser, int64, error) {
+	s, key, err := objectOf(rawUrl, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	object, err := s.object(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, object, nil, nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// StatObject tells the size of the object of the url rawUrl.
+func StatObject(ctx context.Context, rawUrl string) (Info, error) {
+	s, key, err := objectOf(rawUrl, true)
+	if err != nil {
+		return Info{}, err
+	}
+	return s.Stat(ctx, key)
+}
+
+// CheckObjectUrl checks the object url rawUrl can be fetched, its scheme
+// being enabled, without fetching it.
+func CheckObjectUrl(rawUrl string) error {
+	_, _, err := objectOf(rawUrl, true)
+	return err
+}
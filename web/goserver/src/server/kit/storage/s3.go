@@ -0,0 +1,516 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/kit/utils/basic/files"
+)
+
+const (
+	// s3Sha256Header keeps the sha256 of an object with it, S3 only keeping
+	// its md5.
+	s3Sha256Header = "X-Amz-Meta-Sha256"
+	// s3ErrorBodySize bounds the error messages read from S3.
+	s3ErrorBodySize = 4 << 10
+	defaultS3Region = "us-east-1"
+	// s3MaxPutSize is the largest object S3 takes in a single PUT, the larger
+	// ones are uploaded in parts of s3PartSize, up to the 10000 parts S3
+	// takes.
+	s3MaxPutSize = 5 << 30
+	s3PartSize   = 512 << 20
+)
+
+// S3Settings tell where the bucket is. Endpoint is the base url of the S3
+// API, as "https://s3.eu-west-1.amazonaws.com" or that of a MinIO server,
+// the bucket being in the path of the requests. Keys are under Prefix.
+type S3Settings struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Timeout   time.Duration
+}
+
+// S3 keeps the files of key as the objects Prefix/key of a bucket. Its
+// requests are signed with AWS Signature Version 4, their payloads being
+// left unsigned as they go over TLS.
+type S3 struct {
+	settings   S3Settings
+	endpoint   *url.URL
+	client     *http.Client
+	maxPutSize int64
+	partSize   int64
+}
+
+func NewS3(settings S3Settings) (*S3, error) {
+	if settings.Endpoint == "" {
+		return nil, errors.New("the S3 storage needs an endpoint")
+	}
+	endpoint, err := url.Parse(settings.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "https" && endpoint.Scheme != "http" {
+		return nil, fmt.Errorf("S3 endpoint %q is not an http url", settings.Endpoint)
+	}
+	if settings.Region == "" {
+		settings.Region = defaultS3Region
+	}
+	settings.Prefix = strings.Trim(settings.Prefix, "/")
+	return &S3{
+		settings:   settings,
+		endpoint:   endpoint,
+		client:     &http.Client{Timeout: settings.Timeout},
+		maxPutSize: s3MaxPutSize,
+		partSize:   s3PartSize,
+	}, nil
+}
+
+func (s *S3) Scheme() string {
+	return SchemeS3
+}
+
+func (s *S3) LocalPath(string) (string, bool) {
+	return "", false
+}
+
+func (s *S3) object(key string) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	if s.settings.Prefix == "" {
+		return key, nil
+	}
+	return s.settings.Prefix + "/" + key, nil
+}
+
+func (s *S3) key(object string) string {
+	if s.settings.Prefix == "" {
+		return object
+	}
+	return strings.TrimPrefix(object, s.settings.Prefix+"/")
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.object(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, object, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Create spools the file to a temporary file, hashing it, then uploads it
+// on Commit with its sha256, in parts when it is larger than a single PUT
+// takes.
+func (s *S3) Create(ctx context.Context, key string) (Writer, error) {
+	object, err := s.object(key)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile("", "s3-upload-")
+	if err != nil {
+		return nil, err
+	}
+	files.RegisterTemp(ctx, tmp.Name())
+	return &s3Writer{s3: s, ctx: ctx, object: object, tmp: tmp, hash: sha256.New()}, nil
+}
+
+type s3Writer struct {
+	s3     *S3
+	ctx    context.Context
+	object string
+	tmp    *os.File
+	hash   hash.Hash
+	size   int64
+	done   bool
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	n, err := w.tmp.Write(p)
+	w.hash.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *s3Writer) Commit() error {
+	defer w.Close()
+	if _, err := w.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := http.Header{s3Sha256Header: {hex.EncodeToString(w.hash.Sum(nil))}}
+	if w.size > w.s3.maxPutSize {
+		return w.s3.uploadParts(w.ctx, w.object, header, w.tmp, w.size)
+	}
+	resp, err := w.s3.do(w.ctx, http.MethodPut, w.object, nil, header, &sizedReader{Reader: w.tmp, size: w.size})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (w *s3Writer) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.tmp.Close()
+	return os.Remove(w.tmp.Name())
+}
+
+// sizedReader is a request body of known length.
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// uploadParts uploads the size bytes of r as object with a multipart
+// upload, its metadata in header. A failed upload is aborted, so that S3
+// does not keep its parts.
+func (s *S3) uploadParts(ctx context.Context, object string, header http.Header, r io.ReaderAt, size int64) error {
+	resp, err := s.do(ctx, http.MethodPost, object, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadId string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(io.LimitReader(resp.Body, s3ErrorBodySize)).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("S3 upload of %s: %v", object, err)
+	}
+	if err := s.putParts(ctx, object, initiated.UploadId, r, size); err != nil {
+		// The context of the upload may be the reason of the failure.
+		if resp, abortErr := s.do(context.Background(), http.MethodDelete, object, url.Values{"uploadId": {initiated.UploadId}}, nil, nil); abortErr != nil {
+			log.Println("kit.storage.s3.uploadParts.abort", object, abortErr)
+		} else {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *S3) putParts(ctx context.Context, object, uploadId string, r io.ReaderAt, size int64) error {
+	var complete completeMultipartUpload
+	for offset := int64(0); offset < size; offset += s.partSize {
+		n := size - offset
+		if n > s.partSize {
+			n = s.partSize
+		}
+		number := len(complete.Parts) + 1
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadId}}
+		resp, err := s.do(ctx, http.MethodPut, object, query, nil, &sizedReader{Reader: io.NewSectionReader(r, offset, n), size: n})
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPost, object, url.Values{"uploadId": {uploadId}}, nil, &sizedReader{Reader: bytes.NewReader(body), size: int64(len(body))})
+	if err != nil {
+		return err
+	}
+	return bodyError(resp, "S3 upload of "+object)
+}
+
+// bodyError closes the body of resp, returning the error S3 may have put
+// in it after answering 200.
+func bodyError(resp *http.Response, operation string) error {
+	defer resp.Body.Close()
+	var s3Error struct {
+		XMLName xml.Name
+		Message string `xml:"Message"`
+	}
+	err := xml.NewDecoder(io.LimitReader(resp.Body, s3ErrorBodySize)).Decode(&s3Error)
+	if err == nil && s3Error.XMLName.Local == "Error" {
+		return fmt.Errorf("%s: %s", operation, s3Error.Message)
+	}
+	return nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Info, error) {
+	object, err := s.object(key)
+	if err != nil {
+		return Info{}, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, object, nil, nil, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	info := Info{Key: key, Size: resp.ContentLength, Sha256: resp.Header.Get(s3Sha256Header)}
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Walk lists the objects page by page, S3 listing them in the order of
+// their keys.
+func (s *S3) Walk(ctx context.Context, prefix string, fn func(Info) error) error {
+	listed := s.settings.Prefix
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		object, err := s.object(prefix)
+		if err != nil {
+			return err
+		}
+		listed = object
+	}
+	if listed != "" {
+		listed += "/"
+	}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {listed}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, c := range result.Contents {
+			if strings.HasSuffix(c.Key, "/") {
+				continue
+			}
+			if err := fn(Info{Key: s.key(c.Key), Size: c.Size, ModTime: c.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Remove deletes the object of key, S3 answering the same whether it was
+// there or not.
+func (s *S3) Remove(ctx context.Context, key string) error {
+	object, err := s.object(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, object, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Move copies the object server side, its metadata with it, then deletes
+// the original. S3 copies objects of up to 5GB this way.
+func (s *S3) Move(ctx context.Context, from, to string) error {
+	fromObject, err := s.object(from)
+	if err != nil {
+		return err
+	}
+	toObject, err := s.object(to)
+	if err != nil {
+		return err
+	}
+	header := http.Header{
+		"X-Amz-Copy-Source":        {"/" + s.settings.Bucket + "/" + encodePath(fromObject)},
+		"X-Amz-Metadata-Directive": {"COPY"},
+	}
+	resp, err := s.do(ctx, http.MethodPut, toObject, nil, header, nil)
+	if err != nil {
+		return err
+	}
+	// A copy may fail after S3 answered 200, the error being in the body.
+	if err := bodyError(resp, "S3 copy of "+from); err != nil {
+		return err
+	}
+	return s.Remove(ctx, from)
+}
+
+// do sends the signed request for object, or for the bucket when object is
+// empty. A 404 is an error os.ErrNotExist, any other status than 2xx an
+// error with the message of S3.
+func (s *S3) do(ctx context.Context, method, object string, query url.Values, header http.Header, body *sizedReader) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.settings.Bucket
+	if object != "" {
+		u.Path += "/" + object
+	}
+	u.RawPath = strings.TrimSuffix(s.endpoint.EscapedPath(), "/") + "/" + encodePath(s.settings.Bucket)
+	if object != "" {
+		u.RawPath += "/" + encodePath(object)
+	}
+	u.RawQuery = canonicalQuery(query)
+	// An empty body is sent as none, a reader of length 0 being taken for
+	// one of unknown length.
+	var reader io.Reader
+	if body != nil && body.size > 0 {
+		reader = body
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if reader != nil {
+		req.ContentLength = body.size
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.sign(req, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("S3 object %q: %w", object, os.ErrNotExist)
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, s3ErrorBodySize))
+	return nil, fmt.Errorf("S3 %s %q: %s: %s", method, object, resp.Status, strings.TrimSpace(string(message)))
+}
+
+// sign adds the AWS Signature Version 4 of req at now. The payload is left
+// unsigned unless req has its sha256 in X-Amz-Content-Sha256 already.
+func (s *S3) sign(req *http.Request, now time.Time) {
+	const algorithm = "AWS4-HMAC-SHA256"
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = "UNSIGNED-PAYLOAD"
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	names := []string{"host"}
+	canonical := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower != "content-type" && !strings.HasPrefix(lower, "x-amz-") {
+			continue
+		}
+		names = append(names, lower)
+		canonical[lower] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + canonical[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.settings.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := hmacSha256([]byte("AWS4"+s.settings.SecretKey), date)
+	key = hmacSha256(key, s.settings.Region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+	req.Header.Set("Authorization", algorithm+" Credential="+s.settings.AccessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// encodePath escapes the segments of path as Signature Version 4 does,
+// everything but the unreserved characters.
+func encodePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = encodeS3(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func encodeS3(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + strings.ToUpper(strconv.FormatUint(uint64(c)|0x100, 16)[1:]))
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes query sorted by name, as Signature Version 4 signs
+// it.
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, encodeS3(name)+"="+encodeS3(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
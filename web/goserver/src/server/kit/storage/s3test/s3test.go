@@ -0,0 +1,376 @@
+// Package s3test serves a bucket of S3 from memory for the tests of the S3
+// storage, checking the signature of every request.
+package s3test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AccessKey = "AKIDEXAMPLE"
+	SecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	Region    = "us-east-1"
+)
+
+// Object is an object of the bucket.
+type Object struct {
+	Body     []byte
+	Metadata http.Header
+	Modified time.Time
+}
+
+// Server is a bucket of S3 served over http with path-style requests, signed
+// with AccessKey and SecretKey. PageSize bounds the keys of a listing, 1000
+// by default. A copy fails after the answer 200, as S3 may, while
+// FailCopies is set, and the uploads of the part FailPart fail.
+type Server struct {
+	*httptest.Server
+	Bucket     string
+	PageSize   int
+	FailCopies bool
+	FailPart   int
+
+	mu       sync.Mutex
+	objects  map[string]Object
+	uploads  map[string]map[int][]byte
+	requests []string
+}
+
+func NewServer(bucket string) *Server {
+	s := &Server{Bucket: bucket, objects: make(map[string]Object), uploads: make(map[string]map[int][]byte)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Object is the object of key, ok being false when there is none.
+func (s *Server) Object(key string) (object Object, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok = s.objects[key]
+	return object, ok
+}
+
+// Keys are the keys of the objects, sorted.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Requests are the method and the query of the requests served, as
+// "PUT partNumber=1&uploadId=1".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if err := verify(r); err != nil {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket := path
+	key := ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		bucket, key = path[:i], path[i+1:]
+	}
+	if bucket != s.Bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+	query := r.URL.Query()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, strings.TrimSpace(r.Method+" "+r.URL.RawQuery))
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, query)
+	case r.Method == http.MethodPost && query["uploads"] != nil:
+		id := strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[id] = map[int][]byte{}
+		s.objects[uploadKey(id)] = Object{Metadata: metadata(r.Header)}
+		writeXml(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Key      string   `xml:"Key"`
+			UploadId string   `xml:"UploadId"`
+		}{Key: key, UploadId: id})
+	case r.Method == http.MethodPut && query.Get("uploadId") != "":
+		parts, ok := s.uploads[query.Get("uploadId")]
+		number, err := strconv.Atoi(query.Get("partNumber"))
+		if !ok || err != nil {
+			writeError(w, http.StatusNotFound, "NoSuchUpload", query.Get("uploadId"))
+			return
+		}
+		if number == s.FailPart {
+			writeError(w, http.StatusInternalServerError, "InternalError", "part "+strconv.Itoa(number))
+			return
+		}
+		parts[number] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodPost && query.Get("uploadId") != "":
+		s.complete(w, key, query.Get("uploadId"), body)
+	case r.Method == http.MethodDelete && query.Get("uploadId") != "":
+		delete(s.uploads, query.Get("uploadId"))
+		delete(s.objects, uploadKey(query.Get("uploadId")))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copy(w, key, r.Header.Get("X-Amz-Copy-Source"))
+	case r.Method == http.MethodPut:
+		s.objects[key] = Object{Body: body, Metadata: metadata(r.Header), Modified: time.Now().UTC()}
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := s.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", key)
+			return
+		}
+		for name, values := range object.Metadata {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.Body)))
+		w.Header().Set("Last-Modified", object.Modified.Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(object.Body)
+		}
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// uploadKey keeps the metadata of an upload until it completes, under a key
+// no listing returns.
+func uploadKey(id string) string {
+	return "\x00upload/" + id
+}
+
+func (s *Server) list(w http.ResponseWriter, query url.Values) {
+	prefix := query.Get("prefix")
+	start := query.Get("continuation-token")
+	keys := []string{}
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) && key > start && !strings.HasPrefix(key, "\x00") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	type content struct {
+		Key          string    `xml:"Key"`
+		Size         int       `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	}
+	result := struct {
+		XMLName               xml.Name  `xml:"ListBucketResult"`
+		Contents              []content `xml:"Contents"`
+		IsTruncated           bool      `xml:"IsTruncated"`
+		NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+	}{}
+	size := s.PageSize
+	if size <= 0 {
+		size = 1000
+	}
+	if len(keys) > size {
+		keys = keys[:size]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[size-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, content{Key: key, Size: len(s.objects[key].Body), LastModified: s.objects[key].Modified})
+	}
+	writeXml(w, result)
+}
+
+func (s *Server) complete(w http.ResponseWriter, key, id string, body []byte) {
+	parts, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", id)
+		return
+	}
+	var complete struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &complete); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+	var object bytes.Buffer
+	for i, part := range complete.Parts {
+		content, ok := parts[part.PartNumber]
+		if !ok || part.PartNumber != i+1 || part.ETag != etag(content) {
+			writeError(w, http.StatusBadRequest, "InvalidPart", strconv.Itoa(part.PartNumber))
+			return
+		}
+		object.Write(content)
+	}
+	s.objects[key] = Object{Body: object.Bytes(), Metadata: s.objects[uploadKey(id)].Metadata, Modified: time.Now().UTC()}
+	delete(s.objects, uploadKey(id))
+	delete(s.uploads, id)
+	writeXml(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Key     string   `xml:"Key"`
+	}{Key: key})
+}
+
+func (s *Server) copy(w http.ResponseWriter, key, source string) {
+	source, err := url.PathUnescape(source)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	from := strings.TrimPrefix(source, "/"+s.Bucket+"/")
+	object, ok := s.objects[from]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", from)
+		return
+	}
+	if s.FailCopies {
+		writeError(w, http.StatusOK, "InternalError", "We encountered an internal error. Please try again.")
+		return
+	}
+	object.Modified = time.Now().UTC()
+	s.objects[key] = object
+	writeXml(w, struct {
+		XMLName xml.Name `xml:"CopyObjectResult"`
+	}{})
+}
+
+func metadata(header http.Header) http.Header {
+	kept := http.Header{}
+	for name, values := range header {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			kept[name] = values
+		}
+	}
+	return kept
+}
+
+func etag(body []byte) string {
+	h := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+func writeXml(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
+
+// verify checks the Signature Version 4 of r, computing it from the request
+// as received rather than as the client meant it.
+func verify(r *http.Request) error {
+	fields := map[string]string{}
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	for _, field := range strings.Split(auth, ", ") {
+		if i := strings.Index(field, "="); i > 0 {
+			fields[field[:i]] = field[i+1:]
+		}
+	}
+	credential := strings.SplitN(fields["Credential"], "/", 2)
+	if len(credential) != 2 || credential[0] != AccessKey {
+		return fmt.Errorf("credential %q", fields["Credential"])
+	}
+	scope := credential[1]
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) < 8 || scope != amzDate[:8]+"/"+Region+"/s3/aws4_request" {
+		return fmt.Errorf("scope %q at %q", scope, amzDate)
+	}
+
+	segments := strings.Split(r.URL.Path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	var pairs []string
+	for name, values := range r.URL.Query() {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name)+"="+uriEncode(value))
+		}
+	}
+	sort.Strings(pairs)
+	var headers strings.Builder
+	for _, name := range strings.Split(fields["SignedHeaders"], ";") {
+		value := strings.Join(r.Header[http.CanonicalHeaderKey(name)], ",")
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		strings.Join(segments, "/"),
+		strings.Join(pairs, "&"),
+		headers.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + SecretKey)
+	for _, part := range []string{amzDate[:8], Region, "s3", "aws4_request"} {
+		key = sign(key, part)
+	}
+	if want := hex.EncodeToString(sign(key, stringToSign)); fields["Signature"] != want {
+		return fmt.Errorf("signature %q of the canonical request %q, want %q", fields["Signature"], canonicalRequest, want)
+	}
+	return nil
+}
+
+func sign(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode is the UriEncode of Signature Version 4, which escapes every
+// byte but the unreserved characters.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
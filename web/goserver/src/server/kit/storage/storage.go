@@ -0,0 +1,166 @@
+// Package storage keeps files by key, a slash separated path relative to the
+// root of the storage, on the local file system or in an S3 bucket. The
+// paths the services store refer to a file of a storage by a ref, its key
+// prefixed with the scheme of the storage, as "s3://problems/a/config.py",
+// so they outlive a move of the data volume.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Schemes of the refs.
+const (
+	SchemeFile = "file"
+	SchemeS3   = "s3"
+)
+
+// Info describes a stored file. Sha256 is only known when the storage keeps
+// it, it is empty otherwise.
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	Sha256  string
+}
+
+// Writer writes a file of a storage, which only appears under its key once
+// committed. Close without Commit drops what was written.
+type Writer interface {
+	io.Writer
+	Commit() error
+	Close() error
+}
+
+// Storage keeps files by key. A missing file makes Open and Stat fail with
+// an error errors.Is takes for os.ErrNotExist, Remove may fail so too.
+type Storage interface {
+	Scheme() string
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Create(ctx context.Context, key string) (Writer, error)
+	Stat(ctx context.Context, key string) (Info, error)
+	// Walk calls fn with the files whose key starts with the folder prefix,
+	// in the lexical order of their keys.
+	Walk(ctx context.Context, prefix string, fn func(Info) error) error
+	Remove(ctx context.Context, key string) error
+	// Move renames the file from to to, or copies then removes it where
+	// the storage can not rename.
+	Move(ctx context.Context, from, to string) error
+	// LocalPath is the path of the file of key on the local file system,
+	// when the storage keeps it there.
+	LocalPath(key string) (string, bool)
+}
+
+// Settings select the storage. Url is empty, or "file://", for the local
+// file system under Root, and "s3://bucket/prefix" for the objects of
+// bucket under prefix, reached at Endpoint with the keys AccessKey and
+// SecretKey. An S3 storage keeps its working copy under Root.
+type Settings struct {
+	Url       string
+	Root      string
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Timeout   time.Duration
+}
+
+// New returns the storage the settings select.
+func New(settings Settings) (Storage, error) {
+	if settings.Url == "" || settings.Url == SchemeFile+"://" {
+		return NewLocal(settings.Root), nil
+	}
+	u, err := url.Parse(settings.Url)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != SchemeS3 {
+		return nil, fmt.Errorf("storage url %q: unknown scheme %q", settings.Url, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("storage url %q has no bucket", settings.Url)
+	}
+	remote, err := NewS3(S3Settings{
+		Endpoint:  settings.Endpoint,
+		Region:    settings.Region,
+		Bucket:    u.Host,
+		Prefix:    strings.Trim(u.Path, "/"),
+		AccessKey: settings.AccessKey,
+		SecretKey: settings.SecretKey,
+		Timeout:   settings.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Mirror{Local: NewLocal(settings.Root), Remote: remote}, nil
+}
+
+// Ref is the ref of the file of key in s.
+func Ref(s Storage, key string) string {
+	return s.Scheme() + "://" + key
+}
+
+// ParseRef splits ref into its scheme and key, ok being false when ref is
+// not one, as the absolute paths stored before the storages.
+func ParseRef(ref string) (scheme, key string, ok bool) {
+	i := strings.Index(ref, "://")
+	if i <= 0 {
+		return "", "", false
+	}
+	return ref[:i], ref[i+3:], true
+}
+
+// CheckKey checks key names a file within the storage, as a clean relative
+// path.
+func CheckKey(key string) error {
+	if key == "" || path.Clean("/" + key)[1:] != key {
+		return fmt.Errorf("key %q is not a clean relative path", key)
+	}
+	return nil
+}
+
+// ReadFile reads the whole file of key.
+func ReadFile(ctx context.Context, s Storage, key string) ([]byte, error) {
+	r, err := s.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// WriteFile writes data to the file of key, replacing it as a whole.
+func WriteFile(ctx context.Context, s Storage, key string, data []byte) error {
+	return Write(ctx, s, key, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Write streams the file of key through write, the file being replaced
+// only when write succeeds.
+func Write(ctx context.Context, s Storage, key string, write func(w io.Writer) error) error {
+	w, err := s.Create(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := write(w); err != nil {
+		return err
+	}
+	return w.Commit()
+}
+
+// IsNotExist tells whether err is the one of a missing file.
+func IsNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,233 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	batchSize     = 512
+	bufferSize    = 4096
+	flushInterval = 5 * time.Second
+	exportTimeout = 10 * time.Second
+)
+
+// Tracer samples new traces and exports the spans of the sampled ones to an
+// OTLP/HTTP collector. Spans that do not fit in its buffer are dropped.
+type Tracer struct {
+	service  string
+	endpoint string
+	ratio    float64
+	spans    chan *Span
+	client   *http.Client
+	dropped  int64
+}
+
+var (
+	mu      sync.RWMutex
+	current = &Tracer{}
+)
+
+func tracer() *Tracer {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Init sets up the tracing of the process: traces are named after service,
+// sampleRatio of the traces started here are sampled and the spans of sampled
+// traces go to the OTLP/HTTP collector at endpoint, e.g.
+// http://otel-collector:4318. Without an endpoint nothing is exported, trace
+// ids are still propagated and returned.
+func Init(service, endpoint string, sampleRatio float64) {
+	t := &Tracer{
+		service:  service,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		ratio:    sampleRatio,
+		spans:    make(chan *Span, bufferSize),
+		client:   &http.Client{Timeout: exportTimeout},
+	}
+	if t.endpoint == "" {
+		t.ratio = 0
+	} else {
+		go t.run()
+	}
+	mu.Lock()
+	current = t
+	mu.Unlock()
+}
+
+func (t *Tracer) sample(traceId string) bool {
+	return sampleBelow(traceId, t.ratio)
+}
+
+func (t *Tracer) export(span *Span) {
+	if t.spans == nil || t.endpoint == "" {
+		return
+	}
+	select {
+	case t.spans <- span:
+	default:
+		if n := atomic.AddInt64(&t.dropped, 1); n == 1 || n%1000 == 0 {
+			log.Println("kit.trace.Tracer.export: buffer is full, spans dropped so far", n)
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, batchSize)
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := t.post(batch); err != nil {
+			log.Println("kit.trace.Tracer.run.t.post", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+func (t *Tracer) post(batch []*Span) error {
+	b, err := json.Marshal(t.payload(batch))
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(t.endpoint+"/v1/traces", "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// The types below are the parts of the OTLP/JSON trace format in use.
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpPayload struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+const (
+	spanKindInternal = 1
+	statusOk         = 1
+	statusError      = 2
+)
+
+func (t *Tracer) payload(batch []*Span) otlpPayload {
+	var scope otlpScopeSpans
+	scope.Scope.Name = "server/kit/trace"
+	for _, span := range batch {
+		scope.Spans = append(scope.Spans, span.otlp())
+	}
+	var resource otlpResourceSpans
+	resource.Resource.Attributes = []otlpAttribute{attribute("service.name", t.service)}
+	resource.ScopeSpans = []otlpScopeSpans{scope}
+	return otlpPayload{ResourceSpans: []otlpResourceSpans{resource}}
+}
+
+func (s *Span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	span := otlpSpan{
+		TraceId:           s.traceId,
+		SpanId:            s.spanId,
+		ParentSpanId:      s.parentId,
+		Name:              s.name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Status:            otlpStatus{Code: statusOk},
+	}
+	for key, value := range s.attributes {
+		span.Attributes = append(span.Attributes, attribute(key, value))
+	}
+	if s.errMessage != "" {
+		span.Status = otlpStatus{Code: statusError, Message: s.errMessage}
+	}
+	return span
+}
+
+func attribute(key string, value interface{}) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	switch v := value.(type) {
+	case string:
+		a.Value.StringValue = &v
+	case bool:
+		a.Value.BoolValue = &v
+	case int:
+		s := strconv.Itoa(v)
+		a.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		a.Value.IntValue = &s
+	case float64:
+		a.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		a.Value.StringValue = &s
+	}
+	return a
+}
@@ -0,0 +1,98 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/streadway/amqp"
+
+	kitendpoint "server/kit/endpoint"
+	kittransportamqp "server/kit/transport/amqp"
+)
+
+// headerTraceparent carries the W3C trace context, in message and http
+// headers alike.
+const headerTraceparent = "traceparent"
+
+// HeaderTraceId is the http response header the gateway returns the trace id
+// in, so users can reference the trace of a request.
+const HeaderTraceId = "X-Trace-Id"
+
+// PublishTrace copies the current span of the context into the headers of the
+// outgoing request.
+func PublishTrace() kittransportamqp.RequestFunc {
+	return func(ctx context.Context, pub *amqp.Publishing, _ *amqp.Delivery) context.Context {
+		span := FromContext(ctx)
+		if span == nil {
+			return ctx
+		}
+		if pub.Headers == nil {
+			pub.Headers = amqp.Table{}
+		}
+		pub.Headers[headerTraceparent] = span.Traceparent()
+		return ctx
+	}
+}
+
+// DeliveryTrace continues the trace found in the headers of the incoming
+// request.
+func DeliveryTrace() kittransportamqp.RequestFunc {
+	return func(ctx context.Context, _ *amqp.Publishing, deliv *amqp.Delivery) context.Context {
+		if deliv == nil {
+			return ctx
+		}
+		if value, ok := deliv.Headers[headerTraceparent].(string); ok {
+			return WithTraceparent(ctx, value)
+		}
+		return ctx
+	}
+}
+
+// Middleware runs the endpoint in a span called name, ended by the last
+// response, and sets the trace id on every response.
+func Middleware(name string) kitendpoint.Middleware {
+	return func(next kitendpoint.Endpoint) kitendpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) chan kitendpoint.Response {
+			ctx, span := Start(ctx, name)
+			return EndOnLast(span, next(ctx, request))
+		}
+	}
+}
+
+// EndOnLast forwards in, setting the trace id of span on the responses, and
+// ends span with the last response, failed when its code is not ErrCodeOk.
+func EndOnLast(span *Span, in chan kitendpoint.Response) chan kitendpoint.Response {
+	out := make(chan kitendpoint.Response)
+	go func() {
+		defer close(out)
+		defer span.End()
+		for resp := range in {
+			resp.TraceId = span.TraceId()
+			if resp.IsLast && resp.Err.Code != kitendpoint.ErrCodeOk {
+				span.SetAttribute("error.code", resp.Err.Code)
+				span.SetError(fmt.Errorf("%s", resp.Err.Message))
+			}
+			out <- resp
+			if resp.IsLast {
+				return
+			}
+		}
+		span.SetError(fmt.Errorf("no last response"))
+	}()
+	return out
+}
+
+// Handler serves every http request in a span called name, continuing the
+// trace of the traceparent header, and returns the trace id in HeaderTraceId.
+func Handler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithTraceparent(r.Context(), r.Header.Get(headerTraceparent))
+		ctx, span := Start(ctx, name)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		w.Header().Set(HeaderTraceId, span.TraceId())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,178 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span is a timed operation of a trace. Spans of sampled traces are exported
+// when they end, the others only carry their ids along.
+type Span struct {
+	name     string
+	traceId  string
+	spanId   string
+	parentId string
+	sampled  bool
+	start    time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes map[string]interface{}
+	errMessage string
+	ended      bool
+}
+
+type contextKey struct{}
+
+// remote is the span of another service the trace came from.
+type remote struct {
+	traceId string
+	spanId  string
+	sampled bool
+}
+
+type remoteKey struct{}
+
+// Start begins a span called name, the child of the span of ctx, or of the
+// remote span ctx carries, or the root of a new trace.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{name: name, spanId: newId(8), start: time.Now()}
+	if parent := FromContext(ctx); parent != nil {
+		span.traceId = parent.traceId
+		span.parentId = parent.spanId
+		span.sampled = parent.sampled
+	} else if r, ok := ctx.Value(remoteKey{}).(remote); ok {
+		span.traceId = r.traceId
+		span.parentId = r.spanId
+		span.sampled = r.sampled
+	} else {
+		span.traceId = newId(16)
+		span.sampled = tracer().sample(span.traceId)
+	}
+	return context.WithValue(ctx, contextKey{}, span), span
+}
+
+// FromContext returns the current span of ctx, nil when there is none.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(contextKey{}).(*Span)
+	return span
+}
+
+// TraceId returns the id of the trace of ctx, empty when ctx is not traced.
+func TraceId(ctx context.Context) string {
+	if span := FromContext(ctx); span != nil {
+		return span.traceId
+	}
+	if r, ok := ctx.Value(remoteKey{}).(remote); ok {
+		return r.traceId
+	}
+	return ""
+}
+
+func (s *Span) TraceId() string {
+	return s.traceId
+}
+
+// SetAttribute records a string, bool, integer or float value on the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = map[string]interface{}{}
+	}
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed, a nil err leaves it alone.
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errMessage = err.Error()
+}
+
+// End closes the span, only the first call counts.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.sampled {
+		tracer().export(s)
+	}
+}
+
+// Traceparent renders the span as a W3C traceparent header value.
+func (s *Span) Traceparent() string {
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", s.traceId, s.spanId, flags)
+}
+
+// WithTraceparent returns ctx continuing the trace of the W3C traceparent
+// header value, ctx itself when the value is malformed.
+func WithTraceparent(ctx context.Context, traceparent string) context.Context {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	if !isHex(parts[1]) || !isHex(parts[2]) || parts[1] == strings.Repeat("0", 32) {
+		return ctx
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, remote{
+		traceId: parts[1],
+		spanId:  parts[2],
+		sampled: flags[0]&1 == 1,
+	})
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func newId(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strings.Repeat("0", 2*n-1) + "1"
+	}
+	return hex.EncodeToString(b)
+}
+
+// sampleBelow turns the first 8 bytes of a trace id into a uniform value in
+// [0, 1), so every service takes the same decision for a trace.
+func sampleBelow(traceId string, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	b, err := hex.DecodeString(traceId[:16])
+	if err != nil {
+		return false
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return float64(n)/math.MaxUint64 < ratio
+}
@@ -1,67 +1,213 @@
 package files
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	fp "path/filepath"
+	"sync"
+	"time"
 )
 
+// DefaultCopyBufferSize is the buffer size of the copies made with the zero
+// CopyOptions. Network file systems want far larger reads and writes than
+// the io.Copy default.
+const DefaultCopyBufferSize = 1 << 20
+
+// CopyOptions tunes how Copy and CopyDir move the data.
+type CopyOptions struct {
+	// BufferSize is the size of the buffer the data goes through,
+	// DefaultCopyBufferSize when zero.
+	BufferSize int
+	// ReaderFrom lets the destination read a regular source file itself,
+	// with sendfile or copy_file_range where the system has them. BufferSize
+	// is then not used.
+	ReaderFrom bool
+}
+
+// CopyStats tells how much a copy moved and how long it took.
+type CopyStats struct {
+	Bytes    int64
+	Duration time.Duration
+}
+
+// BytesPerSecond is the throughput of the copy, zero when it took no time.
+func (s CopyStats) BytesPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+var (
+	copyOptionsMu sync.RWMutex
+	copyOptions   CopyOptions
+	// bufferPools holds a *sync.Pool of buffers per buffer size.
+	bufferPools sync.Map
+)
+
+// SetCopyOptions sets the options of Copy and CopyDir.
+func SetCopyOptions(opts CopyOptions) {
+	copyOptionsMu.Lock()
+	defer copyOptionsMu.Unlock()
+	copyOptions = opts
+}
+
+// GetCopyOptions returns the options of Copy and CopyDir.
+func GetCopyOptions() CopyOptions {
+	copyOptionsMu.RLock()
+	defer copyOptionsMu.RUnlock()
+	return copyOptions
+}
+
 func Copy(src, dst string) (int64, error) {
+	stats, err := CopyWith(src, dst, GetCopyOptions())
+	return stats.Bytes, err
+}
+
+// CopyWith copies the file src to dst following opts. dst is given the size
+// of src up front, so that the file system can lay it out in one piece. An
+// existing dst is replaced rather than written over, the files hardlinked to
+// it keep their content.
+func CopyWith(src, dst string, opts CopyOptions) (stats CopyStats, err error) {
+	return CopyContext(context.Background(), src, dst, opts)
+}
+
+// CopyContext is CopyWith stopping with the error of ctx once it is done,
+// dst being left partly written. It waits for its two files to fit in
+// MaxOpenFiles first.
+func CopyContext(ctx context.Context, src, dst string, opts CopyOptions) (stats CopyStats, err error) {
+	start := time.Now()
+	defer func() {
+		stats.Duration = time.Since(start)
+	}()
 	src = fp.Clean(src)
 	dst = fp.Clean(dst)
 
-	in, err := os.Open(src)
+	release, err := AcquireFiles(ctx, 2)
 	if err != nil {
-		log.Println("files.Copy.os.Open(src)", err)
-		return 0, err
+		return stats, err
+	}
+	defer release()
+
+	fs := GetFS()
+	in, err := fs.Open(src)
+	if err != nil {
+		log.Println("files.Copy.fs.Open(src)", err)
+		return stats, err
 	}
 	defer in.Close()
 
-	err = os.MkdirAll(fp.Dir(dst), 0777)
+	si, err := in.Stat()
 	if err != nil {
-		log.Println("files.Copy.os.MkdirAll(fp.Dir(dst)", err)
-		return 0, err
+		log.Println("files.Copy.in.Stat()", err)
+		return stats, err
 	}
 
-	out, err := os.Create(dst)
+	err = fs.MkdirAll(fp.Dir(dst), 0777)
 	if err != nil {
-		log.Println("files.Copy.os.Create(dst)", err)
-		return 0, err
+		log.Println("files.Copy.fs.MkdirAll(fp.Dir(dst)", err)
+		return stats, err
+	}
+
+	if err := fs.Remove(dst); err != nil && !os.IsNotExist(err) {
+		log.Println("files.Copy.fs.Remove(dst)", err)
+		return stats, err
+	}
+	out, err := fs.Create(dst)
+	if err != nil {
+		log.Println("files.Copy.fs.Create(dst)", err)
+		return stats, err
 	}
 	defer func() {
 		if e := out.Close(); e != nil {
-			log.Println("files.Copy.out.Close()", err)
-			err = e
+			log.Println("files.Copy.out.Close()", e)
+			if err == nil {
+				err = e
+			}
 		}
 	}()
 
-	nBytes, err := io.Copy(out, in)
+	regular := si.Mode().IsRegular()
+	if regular && si.Size() > 0 {
+		if err := out.Truncate(si.Size()); err != nil {
+			log.Println("files.Copy.out.Truncate(si.Size())", err)
+		}
+	}
+
+	switch {
+	case ctx.Done() != nil:
+		// ReadFrom can not be stopped midway, a copy that may be stopped
+		// goes through the buffer.
+		stats.Bytes, err = copyBuffered(out, contextReader{ctx: ctx, r: in}, opts.BufferSize)
+	case opts.ReaderFrom && regular && isReaderFrom(out):
+		stats.Bytes, err = out.(io.ReaderFrom).ReadFrom(in)
+	default:
+		stats.Bytes, err = copyBuffered(out, in, opts.BufferSize)
+	}
 	if err != nil {
-		log.Println("files.Copy.io.Copy(out, in)", err)
-		return nBytes, err
+		log.Println("files.Copy.copy(out, in)", err)
+		return stats, err
+	}
+	if regular && stats.Bytes != si.Size() {
+		// The source changed size while it was copied.
+		if err = out.Truncate(stats.Bytes); err != nil {
+			log.Println("files.Copy.out.Truncate(stats.Bytes)", err)
+			return stats, err
+		}
 	}
 
 	err = out.Sync()
 	if err != nil {
 		log.Println("files.Copy.out.Sync()", err)
-		return nBytes, err
+		return stats, err
 	}
 
-	si, err := os.Stat(src)
+	err = fs.Chmod(dst, si.Mode())
 	if err != nil {
-		log.Println("files.Copy.os.Stat(src)", err)
-		return nBytes, err
+		log.Println("files.Copy.fs.Chmod(dst, si.Mode())", err)
+		return stats, err
 	}
-	err = os.Chmod(dst, si.Mode())
-	if err != nil {
-		log.Println("files.Copy.os.Chmod(dst, si.Mode())", err)
-		return nBytes, err
+
+	return stats, err
+}
+
+// isReaderFrom tells whether f reads from a reader itself, as *os.File.
+func isReaderFrom(f File) bool {
+	_, ok := f.(io.ReaderFrom)
+	return ok
+}
+
+// contextReader fails with the error of ctx once it is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
 	}
+	return r.r.Read(p)
+}
 
-	return nBytes, err
+// copyBuffered copies through a pooled buffer of size bytes. The ends are
+// wrapped so that io.CopyBuffer does not hand the copy over to ReadFrom or
+// WriteTo, which would use a buffer of their own.
+func copyBuffered(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if size <= 0 {
+		size = DefaultCopyBufferSize
+	}
+	pool, _ := bufferPools.LoadOrStore(size, &sync.Pool{New: func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}})
+	buf := pool.(*sync.Pool).Get().(*[]byte)
+	defer pool.(*sync.Pool).Put(buf)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
 }
 
 func CopyDir(src string, dst string) (err error) {
@@ -84,12 +230,12 @@ func CopyDir(src string, dst string) (err error) {
 		return fmt.Errorf("destination already exists")
 	}
 
-	err = os.MkdirAll(dst, si.Mode())
+	err = GetFS().MkdirAll(dst, si.Mode())
 	if err != nil {
 		return
 	}
 
-	entries, err := ioutil.ReadDir(src)
+	entries, err := readDir(src)
 	if err != nil {
 		return
 	}
@@ -118,3 +264,84 @@ func CopyDir(src string, dst string) (err error) {
 
 	return
 }
+
+// readDir is ioutil.ReadDir counting the folder among the open files.
+func readDir(dir string) ([]os.FileInfo, error) {
+	release, err := AcquireFiles(context.Background(), 1)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return ioutil.ReadDir(dir)
+}
+
+// WriteFileAtomic writes data to a temporary file next to path, syncs it and
+// renames it over path, so readers never see a partially written file.
+func WriteFileAtomic(path string, data []byte, mode os.FileMode) error {
+	return WriteFileAtomicContext(context.Background(), path, data, mode)
+}
+
+// WriteFileAtomicContext is WriteFileAtomic with the temporary file
+// registered in the temp paths of ctx, removed by them even if the write
+// panics.
+func WriteFileAtomicContext(ctx context.Context, path string, data []byte, mode os.FileMode) error {
+	return WriteAtomicContext(ctx, path, mode, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// WriteAtomic is WriteFileAtomic with the content streamed by write, which
+// is not buffered.
+func WriteAtomic(path string, mode os.FileMode, write func(w io.Writer) error) error {
+	return WriteAtomicContext(context.Background(), path, mode, write)
+}
+
+// WriteAtomicContext is WriteAtomic with the temporary file registered in
+// the temp paths of ctx, as WriteFileAtomicContext.
+func WriteAtomicContext(ctx context.Context, path string, mode os.FileMode, write func(w io.Writer) error) (err error) {
+	fs := GetFS()
+	if err = fs.MkdirAll(fp.Dir(path), 0777); err != nil {
+		return err
+	}
+	tmp, err := fs.TempFile(fp.Dir(path), "."+fp.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	RegisterTemp(ctx, tmp.Name())
+	defer func() {
+		if err != nil {
+			fs.Remove(tmp.Name())
+		}
+	}()
+	if err = write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = fs.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return fs.Rename(tmp.Name(), path)
+}
+
+// DirSize returns the total size in bytes of the regular files under path.
+func DirSize(path string) (int64, error) {
+	var size int64
+	err := fp.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,79 @@
+package files
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+)
+
+// benchDir is a temporary folder on tmpfs where there is one, so that the
+// copies measure the buffering rather than the disk.
+func benchDir(b *testing.B) string {
+	parent := ""
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		parent = "/dev/shm"
+	}
+	dir, err := ioutil.TempDir(parent, "files")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// ioCopy is Copy as it was, through the io.Copy default buffer.
+func ioCopy(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	n, err := io.Copy(struct{ io.Writer }{out}, struct{ io.Reader }{in})
+	if err != nil {
+		return n, err
+	}
+	return n, out.Sync()
+}
+
+// BenchmarkCopy copies a file of 64 MB the former way and with the
+// CopyOptions.
+func BenchmarkCopy(b *testing.B) {
+	const size = 64 << 20
+	dir := benchDir(b)
+	src := fp.Join(dir, "src")
+	if err := ioutil.WriteFile(src, bytes.Repeat([]byte("weights "), size/8), 0666); err != nil {
+		b.Fatal(err)
+	}
+	dst := fp.Join(dir, "dst")
+	for _, bench := range []struct {
+		name string
+		copy func(src, dst string) (int64, error)
+	}{
+		{"io.Copy", ioCopy},
+		{"buffered", func(src, dst string) (int64, error) {
+			stats, err := CopyWith(src, dst, CopyOptions{})
+			return stats.Bytes, err
+		}},
+		{"ReaderFrom", func(src, dst string) (int64, error) {
+			stats, err := CopyWith(src, dst, CopyOptions{ReaderFrom: true})
+			return stats.Bytes, err
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if _, err := bench.copy(src, dst); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
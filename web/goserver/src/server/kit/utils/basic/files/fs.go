@@ -0,0 +1,107 @@
+package files
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// File is an open file of an FS, *os.File for OS.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// FS is the file system the copies, the atomic writes and the downloads
+// go through, OS unless SetFS swapped it, for instance for one failing on
+// purpose to try how they recover.
+type FS interface {
+	Open(name string) (File, error)
+	Create(name string) (File, error)
+	TempFile(dir, pattern string) (File, error)
+	Remove(name string) error
+	Rename(oldpath, newpath string) error
+	MkdirAll(path string, perm os.FileMode) error
+	Chmod(name string, mode os.FileMode) error
+}
+
+// OS is the file system of the os package.
+var OS FS = osFS{}
+
+var (
+	fsMu      sync.RWMutex
+	currentFS = OS
+)
+
+// SetFS makes the copies, the atomic writes and the downloads go through fs,
+// OS when nil, and returns the func putting the previous one back.
+func SetFS(fs FS) (restore func()) {
+	if fs == nil {
+		fs = OS
+	}
+	fsMu.Lock()
+	defer fsMu.Unlock()
+	previous := currentFS
+	currentFS = fs
+	return func() {
+		fsMu.Lock()
+		defer fsMu.Unlock()
+		currentFS = previous
+	}
+}
+
+// GetFS returns the file system the copies, the atomic writes and the
+// downloads go through.
+func GetFS() FS {
+	fsMu.RLock()
+	defer fsMu.RUnlock()
+	return currentFS
+}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Create(name string) (File, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) TempFile(dir, pattern string) (File, error) {
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
@@ -0,0 +1,157 @@
+package files
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	fp "path/filepath"
+	"runtime"
+	"sync"
+)
+
+// hashChunkSize is the size of the reads of HashTree, cancellation and
+// progress are checked between them.
+const hashChunkSize = 1 << 20
+
+var hashBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, hashChunkSize)
+		return &b
+	},
+}
+
+// FileHash is the sha256 of a file of a tree, Path being relative to the
+// root of the tree and slash separated.
+type FileHash struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// HashProgress tells how much of a tree HashTree hashed so far.
+type HashProgress struct {
+	Files      int   `json:"files"`
+	TotalFiles int   `json:"totalFiles"`
+	Bytes      int64 `json:"bytes"`
+	TotalBytes int64 `json:"totalBytes"`
+}
+
+// HashOptions tunes HashTree. Workers files are hashed at once, no more than
+// the number of CPUs, and all of these when Workers is not set. Progress, if
+// set, is called once every file is hashed, from one goroutine at a time.
+type HashOptions struct {
+	Workers  int
+	Progress func(HashProgress)
+}
+
+// HashTree hashes the regular files under root, symbolic links are not
+// followed. The hashes are in the lexical order of the paths whatever the
+// order the files were hashed in. The first error, or the end of ctx, stops
+// the hashing.
+func HashTree(ctx context.Context, root string, opts HashOptions) ([]FileHash, error) {
+	var hashes []FileHash
+	var progress HashProgress
+	err := fp.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := fp.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, FileHash{Path: fp.ToSlash(rel), Size: info.Size()})
+		progress.TotalFiles++
+		progress.TotalBytes += info.Size()
+		return nil
+	})
+	if err != nil || len(hashes) == 0 {
+		return hashes, err
+	}
+
+	workers := runtime.NumCPU()
+	if opts.Workers > 0 && opts.Workers < workers {
+		workers = opts.Workers
+	}
+	if workers > len(hashes) {
+		workers = len(hashes)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	indexes := make(chan int)
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				sum, err := hashFile(ctx, fp.Join(root, fp.FromSlash(hashes[i].Path)))
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					cancel()
+				} else {
+					hashes[i].Sha256 = sum
+					progress.Files++
+					progress.Bytes += hashes[i].Size
+					if opts.Progress != nil {
+						opts.Progress(progress)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range hashes {
+		select {
+		case indexes <- i:
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+	close(indexes)
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return hashes, nil
+}
+
+func hashFile(ctx context.Context, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b := hashBuffers.Get().(*[]byte)
+	defer hashBuffers.Put(b)
+	h := sha256.New()
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		n, err := f.Read(*b)
+		h.Write((*b)[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
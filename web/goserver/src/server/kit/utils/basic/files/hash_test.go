@@ -0,0 +1,73 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTree writes files files of size bytes each under root, spread over
+// a few folders.
+func writeTree(tb testing.TB, root string, files, size int) {
+	for i := 0; i < files; i++ {
+		path := fp.Join(root, fmt.Sprintf("dir%d", i%4), fmt.Sprintf("file%03d.bin", i))
+		if err := os.MkdirAll(fp.Dir(path), 0777); err != nil {
+			tb.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, bytes.Repeat([]byte{byte(i)}, size), 0666); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func TestHashTreeOrder(test *testing.T) {
+	root, err := ioutil.TempDir("", "hash")
+	if err != nil {
+		test.Fatal(err)
+	}
+	test.Cleanup(func() { os.RemoveAll(root) })
+	writeTree(test, root, 32, 1000)
+
+	one, err := HashTree(context.Background(), root, HashOptions{Workers: 1})
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(one) != 32 {
+		test.Fatalf("%d hashes, want 32", len(one))
+	}
+	for i := 1; i < len(one); i++ {
+		if one[i-1].Path >= one[i].Path {
+			test.Errorf("%s listed before %s", one[i-1].Path, one[i].Path)
+		}
+	}
+	many, err := HashTree(context.Background(), root, HashOptions{Workers: 8})
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !reflect.DeepEqual(one, many) {
+		test.Error("the hashes depend on the number of workers")
+	}
+}
+
+// BenchmarkHashTree hashes a tree of 64 files of 4 MB with 1, 2 and 4
+// workers, HashTree using no more of them than there are CPUs.
+func BenchmarkHashTree(b *testing.B) {
+	const files, size = 64, 4 << 20
+	root := benchDir(b)
+	writeTree(b, root, files, size)
+	for _, workers := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(files * size)
+			for i := 0; i < b.N; i++ {
+				if _, err := HashTree(context.Background(), root, HashOptions{Workers: workers}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package files
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// DefaultMaxOpenFiles is the number of files the copies and the downloads
+// may hold open at once when SetMaxOpenFiles was not given one. It stays
+// below the soft limit of 1024 descriptors processes usually start with,
+// leaving the rest to the connections.
+const DefaultMaxOpenFiles = 512
+
+// openFiles bounds the files held open by the copies and the downloads, the
+// ones over the limit waiting in turn instead of failing with EMFILE.
+var openFiles = &fileSemaphore{max: DefaultMaxOpenFiles}
+
+// SetMaxOpenFiles sets the number of files the copies and the downloads may
+// hold open at once, DefaultMaxOpenFiles when n is not positive. The files
+// already open stay so.
+func SetMaxOpenFiles(n int) {
+	if n <= 0 {
+		n = DefaultMaxOpenFiles
+	}
+	openFiles.setMax(n)
+}
+
+// MaxOpenFiles returns the number of files the copies and the downloads may
+// hold open at once.
+func MaxOpenFiles() int {
+	openFiles.mu.Lock()
+	defer openFiles.mu.Unlock()
+	return openFiles.max
+}
+
+// AcquireFiles waits until n more files may be opened and returns the func
+// telling they are closed, to be called once. It fails with the error of
+// ctx when ctx is done first. n is cut down to the limit, so that it can
+// always be met.
+func AcquireFiles(ctx context.Context, n int) (release func(), err error) {
+	return openFiles.acquire(ctx, n)
+}
+
+// fileSemaphore is a weighted semaphore serving the waiters in order, a
+// copy needing two files not being passed over by the ones needing one.
+type fileSemaphore struct {
+	mu      sync.Mutex
+	max     int
+	used    int
+	waiters list.List
+}
+
+type fileWaiter struct {
+	n     int
+	ready chan struct{}
+}
+
+func (s *fileSemaphore) acquire(ctx context.Context, n int) (func(), error) {
+	s.mu.Lock()
+	if n > s.max {
+		n = s.max
+	}
+	if s.used+n <= s.max && s.waiters.Len() == 0 {
+		s.used += n
+		s.mu.Unlock()
+		return s.releaser(n), nil
+	}
+	w := fileWaiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return s.releaser(n), nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// Served while ctx was done, the files go to the next ones.
+			s.used -= n
+		default:
+			s.waiters.Remove(elem)
+		}
+		s.notify()
+		s.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *fileSemaphore) releaser(n int) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.used -= n
+			s.notify()
+			s.mu.Unlock()
+		})
+	}
+}
+
+func (s *fileSemaphore) setMax(n int) {
+	s.mu.Lock()
+	s.max = n
+	s.notify()
+	s.mu.Unlock()
+}
+
+// notify serves the waiters in order while their files fit. It is called
+// with mu held.
+func (s *fileSemaphore) notify() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(fileWaiter)
+		if s.used+w.n > s.max && s.used > 0 {
+			return
+		}
+		s.used += w.n
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
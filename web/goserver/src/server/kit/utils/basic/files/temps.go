@@ -0,0 +1,49 @@
+package files
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+)
+
+type tempsKey struct{}
+
+// Temps collects the temp files and folders created for the work of a
+// context, an import for instance, so that they are removed whichever way
+// it ends, a panic included.
+type Temps struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+// WithTemps returns a context the temp paths of a work are registered in.
+// The caller removes them with RemoveAll once the work is over.
+func WithTemps(ctx context.Context) (context.Context, *Temps) {
+	temps := &Temps{}
+	return context.WithValue(ctx, tempsKey{}, temps), temps
+}
+
+// RegisterTemp adds path to the temp paths of the work of ctx. Paths created
+// outside of such a work are left to their creator.
+func RegisterTemp(ctx context.Context, path string) {
+	temps, ok := ctx.Value(tempsKey{}).(*Temps)
+	if !ok {
+		return
+	}
+	temps.mu.Lock()
+	defer temps.mu.Unlock()
+	temps.paths = append(temps.paths, path)
+}
+
+// RemoveAll removes the registered paths that are still there.
+func (temps *Temps) RemoveAll() {
+	temps.mu.Lock()
+	defer temps.mu.Unlock()
+	for _, path := range temps.paths {
+		if err := os.RemoveAll(path); err != nil {
+			log.Println("files.Temps.RemoveAll", path, err)
+		}
+	}
+	temps.paths = nil
+}
@@ -0,0 +1,152 @@
+package u
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"server/kit/redact"
+)
+
+// Credential authenticates the downloads from a host, either with Header set
+// to Value, e.g. a private token, or with basic auth.
+type Credential struct {
+	Header   string `json:"header" yaml:"header"`
+	Value    string `json:"value" yaml:"value"`
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+}
+
+// Credentials are the credentials of the hosts they are keyed by, a host
+// name or a host:port.
+type Credentials map[string]Credential
+
+// Validate checks that every credential is either a header or basic auth.
+func (c Credentials) Validate() error {
+	for host, cred := range c {
+		header := cred.Header != "" || cred.Value != ""
+		basic := cred.Username != "" || cred.Password != ""
+		switch {
+		case host == "":
+			return errors.New("credentials need a host")
+		case header && basic:
+			return fmt.Errorf("credential of %s: a header or basic auth, not both", host)
+		case header && (cred.Header == "" || cred.Value == ""):
+			return fmt.Errorf("credential of %s: header and value are required", host)
+		case !header && (cred.Username == "" || cred.Password == ""):
+			return fmt.Errorf("credential of %s: username and password are required", host)
+		}
+	}
+	return nil
+}
+
+var credentials struct {
+	sync.RWMutex
+	byHost Credentials
+	// headers are the headers any credential sets, removed from the
+	// requests to the other hosts.
+	headers []string
+}
+
+// SetCredentials makes the downloads authenticate with c, their secrets are
+// redacted from the logs from then on.
+func SetCredentials(c Credentials) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	byHost := make(Credentials, len(c))
+	headers := []string{"Authorization"}
+	for host, cred := range c {
+		byHost[strings.ToLower(host)] = cred
+		if cred.Header != "" {
+			headers = append(headers, cred.Header)
+			redact.AddSecret(cred.Value)
+		} else {
+			redact.AddSecret(cred.Password)
+			redact.AddSecret(base64.StdEncoding.EncodeToString([]byte(cred.Username + ":" + cred.Password)))
+		}
+	}
+	credentials.Lock()
+	defer credentials.Unlock()
+	credentials.byHost = byHost
+	credentials.headers = headers
+	return nil
+}
+
+// Authorize sets the credential of the host of req, if any, and removes the
+// credential headers of the other hosts.
+func Authorize(req *http.Request) {
+	credentials.RLock()
+	defer credentials.RUnlock()
+	if len(credentials.byHost) == 0 {
+		return
+	}
+	for _, header := range credentials.headers {
+		req.Header.Del(header)
+	}
+	cred, ok := credentials.byHost[strings.ToLower(req.URL.Host)]
+	if !ok {
+		cred, ok = credentials.byHost[strings.ToLower(req.URL.Hostname())]
+	}
+	if !ok {
+		return
+	}
+	if cred.Header != "" {
+		req.Header.Set(cred.Header, cred.Value)
+	} else {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+}
+
+// DownloadClient is the client of the downloads. Redirects are authorized
+// for the host they lead to, a credential never follows a redirect to
+// another host.
+var DownloadClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		Authorize(req)
+		return nil
+	},
+}
+
+// HTTPDoer sends the requests of the downloads, as *http.Client does.
+type HTTPDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+var (
+	downloadDoerMu sync.RWMutex
+	downloadDoer   HTTPDoer = DownloadClient
+)
+
+// SetDownloadDoer makes the downloads send their requests through d,
+// DownloadClient when nil, for instance through one failing on purpose to
+// try how they recover. It returns the func putting the previous one back.
+func SetDownloadDoer(d HTTPDoer) (restore func()) {
+	if d == nil {
+		d = DownloadClient
+	}
+	downloadDoerMu.Lock()
+	defer downloadDoerMu.Unlock()
+	previous := downloadDoer
+	downloadDoer = d
+	return func() {
+		downloadDoerMu.Lock()
+		defer downloadDoerMu.Unlock()
+		downloadDoer = previous
+	}
+}
+
+// DoDownload sends the download request req through DownloadClient, or the
+// HTTPDoer given to SetDownloadDoer.
+func DoDownload(req *http.Request) (*http.Response, error) {
+	downloadDoerMu.RLock()
+	d := downloadDoer
+	downloadDoerMu.RUnlock()
+	return d.Do(req)
+}
@@ -1,6 +1,8 @@
 package u
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
@@ -8,11 +10,22 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"unicode"
 
 	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
 	"github.com/streadway/amqp"
+	"golang.org/x/text/unicode/norm"
 )
 
+// Version of the server, set when building with
+// -ldflags "-X server/kit/utils.Version=<version>".
+var Version = "dev"
+
+// DefaultUserAgent identifies the server to the hosts it downloads from.
+func DefaultUserAgent() string {
+	return "idlp-training-server/" + Version
+}
+
 func DownloadFile(url, dst string) (int64, error) {
 	out, err := os.Create(dst)
 	if err != nil {
@@ -20,7 +33,14 @@ func DownloadFile(url, dst string) (int64, error) {
 		return 0, err
 	}
 	defer out.Close()
-	resp, err := http.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		log.Println("NewRequest", err)
+		return 0, err
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent())
+	Authorize(req)
+	resp, err := DoDownload(req)
 	if err != nil {
 		log.Println("Get", err)
 		return 0, err
@@ -71,7 +91,18 @@ func FilterInt(ss []int, test func(int) bool) (ret []int) {
 	return
 }
 
+// StringToFolderName turns s into a folder name, the same on the common
+// filesystems: s is normalized to NFKC, the characters illegal in folder
+// names, the dots and the punctuation dropped, and the spaces left joined by
+// underscores. The names Windows reserves get an underscore.
 func StringToFolderName(s string) string {
+	s = norm.NFKC.String(s)
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Zs, r) {
+			return ' '
+		}
+		return r
+	}, s)
 	replacer := strings.NewReplacer(
 		"/", " ",
 		"<", " ",
@@ -81,15 +112,27 @@ func StringToFolderName(s string) string {
 		"'", " ",
 		"`", " ",
 		"\\", " ",
+		"|", " ",
+		"?", " ",
+		"*", " ",
 		",", " ",
 		".", " ",
-		"\t", " ",
-		"\n", " ",
-		" ", " ",
 	)
 	s = replacer.Replace(s)
 	s = strings.TrimSpace(s)
 	underscores := regexp.MustCompile(`\s+`)
 	s = underscores.ReplaceAllString(s, "_")
+	if reservedFolderNames.MatchString(s) {
+		s += "_"
+	}
 	return s
 }
+
+var reservedFolderNames = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[1-9]|lpt[1-9])$`)
+
+// SuffixedFolderName is the folder name of s followed by a short hash of s,
+// for the names whose folder is taken by another.
+func SuffixedFolderName(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return StringToFolderName(s) + "_" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrAddressNotAllowed is the error of the deliveries to a loopback,
+// link-local, private or otherwise internal address of a host the admins did
+// not allow one for.
+var ErrAddressNotAllowed = errors.New("webhook address is not allowed")
+
+// internalAddress tells the addresses of the server itself and of its
+// networks, the cloud metadata endpoint among them.
+func internalAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() ||
+		isPrivate(ip)
+}
+
+// isPrivate is net.IP.IsPrivate, which go 1.14 lacks: the RFC 1918 and
+// RFC 4193 ranges.
+func isPrivate(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4[0] == 10 ||
+			(ip4[0] == 172 && ip4[1]&0xf0 == 16) ||
+			(ip4[0] == 192 && ip4[1] == 168) ||
+			// Shared address space of the carrier-grade NATs.
+			(ip4[0] == 100 && ip4[1]&0xc0 == 64)
+	}
+	return len(ip) == net.IPv6len && ip[0]&0xfe == 0xfc
+}
+
+// privateAllowed tells whether the admins let host resolve to an internal
+// address.
+func (d *Dispatcher) privateAllowed(host string) bool {
+	if d == nil {
+		return false
+	}
+	for _, allowed := range d.settings.PrivateHosts {
+		if strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAddresses resolves host and refuses it when one of its addresses is
+// internal. A host that does not resolve is left to the delivery, which
+// checks the address it connects to again.
+func (d *Dispatcher) checkAddresses(ctx context.Context, host string) error {
+	if d.privateAllowed(host) {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if internalAddress(ip) {
+			return fmt.Errorf("%w: %s", ErrAddressNotAllowed, host)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if internalAddress(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrAddressNotAllowed, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// dialContext dials the deliveries, refusing the internal addresses at the
+// time of the connection, so that a host resolving to another address since
+// it was checked does not reach the server's networks.
+func (d *Dispatcher) dialContext(timeout time.Duration) func(ctx context.Context, network, address string) (net.Conn, error) {
+	allowed := &net.Dialer{Timeout: timeout}
+	guarded := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || internalAddress(ip) {
+				return fmt.Errorf("%w: %s", ErrAddressNotAllowed, host)
+			}
+			return nil
+		},
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if d.privateAllowed(host) {
+			return allowed.DialContext(ctx, network, address)
+		}
+		return guarded.DialContext(ctx, network, address)
+	}
+}
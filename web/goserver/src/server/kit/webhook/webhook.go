@@ -0,0 +1,344 @@
+// Package webhook delivers the model events to the webhooks registered for
+// them. A delivery is a POST of the JSON payload, signed with the secret of
+// the webhook:
+//
+//	X-Webhook-Event: modelImported
+//	X-Webhook-Delivery: <id of the payload>
+//	X-Webhook-Timestamp: <unix seconds>
+//	X-Webhook-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+//
+// Receivers should check the signature and refuse old timestamps. Any 2xx
+// status takes the delivery, the others are retried with an exponential
+// backoff, except the 4xx other than 408 and 429. The payloads still refused
+// after the last attempt are kept as dead letters.
+//
+// Webhooks never reach the loopback, link-local or private addresses, those
+// of the server and its networks, unless the admins allow their host.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	t "server/db/pkg/types"
+	typeWebhook "server/db/pkg/types/type/webhook"
+	"server/kit/metrics"
+	"server/kit/redact"
+)
+
+var deliveries = metrics.NewCounter(
+	"webhook_deliveries_total",
+	"Webhook deliveries, by event and result: delivered, failed for the attempts to be retried, dead_letter and dropped.",
+	"event", "result",
+)
+
+// Finder returns the webhooks receiving event for the models of the problem.
+type Finder func(ctx context.Context, problemId primitive.ObjectID, event string) ([]t.Webhook, error)
+
+// DeadLetterSink stores the payloads no attempt delivered.
+type DeadLetterSink func(ctx context.Context, letter t.WebhookDeadLetter) error
+
+// Payload is the body of a delivery, Data depending on the event.
+type Payload struct {
+	Id        string             `json:"id"`
+	Event     string             `json:"event"`
+	ProblemId primitive.ObjectID `json:"problemId"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Data      interface{}        `json:"data"`
+}
+
+// Settings tell how the events are delivered. AllowedHosts, when set, are the
+// only hosts webhooks may point to. PrivateHosts are the hosts they may point
+// to though these resolve to internal addresses.
+type Settings struct {
+	Attempts     int
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+	Timeout      time.Duration
+	BufferSize   int
+	Workers      int
+	AllowedHosts []string
+	PrivateHosts []string
+}
+
+// Dispatcher sends the events through a buffer, so a slow receiver never
+// delays the model operations. Events that do not fit in the buffer are
+// dropped and counted. The attempts after a failed one are handed back to
+// the workers once their backoff is over, a receiver that is down never
+// holds a worker. A nil Dispatcher drops every event.
+type Dispatcher struct {
+	find        Finder
+	deadLetters DeadLetterSink
+	settings    Settings
+	client      *http.Client
+	payloads    chan Payload
+	retries     chan *delivery
+	dropped     int64
+}
+
+// delivery is a payload on its way to one webhook.
+type delivery struct {
+	hook     t.Webhook
+	payload  Payload
+	body     []byte
+	attempts int
+	backoff  time.Duration
+}
+
+func NewDispatcher(find Finder, deadLetters DeadLetterSink, settings Settings) *Dispatcher {
+	if settings.Attempts < 1 {
+		settings.Attempts = 1
+	}
+	if settings.Workers < 1 {
+		settings.Workers = 1
+	}
+	d := &Dispatcher{
+		find:        find,
+		deadLetters: deadLetters,
+		settings:    settings,
+		payloads:    make(chan Payload, settings.BufferSize),
+		retries:     make(chan *delivery),
+	}
+	d.client = &http.Client{
+		Timeout: settings.Timeout,
+		// No proxy, the addresses connected to are the receivers'.
+		Transport: &http.Transport{
+			DialContext:         d.dialContext(settings.Timeout),
+			TLSHandshakeTimeout: settings.Timeout,
+			MaxIdleConns:        16,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		// A redirect could lead the delivery to a host that is not
+		// allowed, the receivers have to answer at their url.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for i := 0; i < settings.Workers; i++ {
+		go d.run()
+	}
+	return d
+}
+
+// Emit queues event for the webhooks of the problem without blocking.
+func (d *Dispatcher) Emit(event string, problemId primitive.ObjectID, data interface{}) {
+	if d == nil {
+		return
+	}
+	payload := Payload{
+		Id:        primitive.NewObjectID().Hex(),
+		Event:     event,
+		ProblemId: problemId,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	select {
+	case d.payloads <- payload:
+	default:
+		deliveries.Inc(event, "dropped")
+		if total := atomic.AddInt64(&d.dropped, 1); total == 1 || total%100 == 0 {
+			log.Println("kit.webhook.Dispatcher.Emit: buffer is full, events dropped so far", total)
+		}
+	}
+}
+
+// Dropped is the number of events lost since the start.
+func (d *Dispatcher) Dropped() int64 {
+	return atomic.LoadInt64(&d.dropped)
+}
+
+// CheckUrl refuses the urls that are not http or https, those with
+// credentials and those of a host that is not allowed or that resolves to an
+// internal address.
+func (d *Dispatcher) CheckUrl(ctx context.Context, rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("webhook url %q must be http or https", redact.Url(rawUrl))
+	}
+	if u.Host == "" {
+		return fmt.Errorf("webhook url %q has no host", redact.Url(rawUrl))
+	}
+	if u.User != nil {
+		return fmt.Errorf("webhook url %q must not hold credentials, the payloads are signed with the secret", redact.Url(rawUrl))
+	}
+	if d != nil && len(d.settings.AllowedHosts) > 0 {
+		allowed := false
+		for _, host := range d.settings.AllowedHosts {
+			allowed = allowed || strings.EqualFold(host, u.Hostname())
+		}
+		if !allowed {
+			return fmt.Errorf("webhook host %q is not allowed", u.Hostname())
+		}
+	}
+	return d.checkAddresses(ctx, u.Hostname())
+}
+
+// Test sends a payload of the event test to the webhook once, without retry
+// nor dead letter, and returns the status the receiver answered.
+func (d *Dispatcher) Test(ctx context.Context, hook t.Webhook, data interface{}) (int, error) {
+	if err := d.CheckUrl(ctx, hook.Url); err != nil {
+		return 0, err
+	}
+	payload := Payload{
+		Id:        primitive.NewObjectID().Hex(),
+		Event:     typeWebhook.Test,
+		ProblemId: hook.ProblemId,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	status, err := d.post(ctx, hook, payload, body)
+	if err != nil {
+		deliveries.Inc(payload.Event, "failed")
+	} else {
+		deliveries.Inc(payload.Event, "delivered")
+	}
+	return status, err
+}
+
+func (d *Dispatcher) run() {
+	for {
+		select {
+		case payload := <-d.payloads:
+			d.dispatch(payload)
+		case next := <-d.retries:
+			d.attempt(next)
+		}
+	}
+}
+
+// dispatch makes the first attempt of payload for each of its webhooks.
+func (d *Dispatcher) dispatch(payload Payload) {
+	hooks, err := d.find(context.Background(), payload.ProblemId, payload.Event)
+	if err != nil {
+		log.Println("kit.webhook.Dispatcher.dispatch.d.find", payload.Event, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("kit.webhook.Dispatcher.dispatch.json.Marshal", payload.Event, err)
+		return
+	}
+	for _, hook := range hooks {
+		d.attempt(&delivery{hook: hook, payload: payload, body: body, backoff: d.settings.Backoff})
+	}
+}
+
+// attempt makes one attempt of next. A failed one worth another is handed
+// back to the workers after its backoff, the last one is kept as a dead
+// letter.
+func (d *Dispatcher) attempt(next *delivery) {
+	ctx := context.Background()
+	next.attempts++
+	err := d.CheckUrl(ctx, next.hook.Url)
+	if err == nil {
+		var status int
+		if status, err = d.post(ctx, next.hook, next.payload, next.body); err == nil {
+			deliveries.Inc(next.payload.Event, "delivered")
+			return
+		}
+		deliveries.Inc(next.payload.Event, "failed")
+		if retryable(status) && !errors.Is(err, ErrAddressNotAllowed) && next.attempts < d.settings.Attempts {
+			d.retry(next)
+			return
+		}
+	}
+	redact.Println("kit.webhook.Dispatcher.attempt", next.hook.Id.Hex(), next.payload.Event, next.attempts, err)
+	deliveries.Inc(next.payload.Event, "dead_letter")
+	letter := t.WebhookDeadLetter{
+		WebhookId: next.hook.Id,
+		ProblemId: next.payload.ProblemId,
+		Event:     next.payload.Event,
+		Url:       redact.Url(next.hook.Url),
+		Payload:   string(next.body),
+		Attempts:  next.attempts,
+		LastError: redact.String(err.Error()),
+	}
+	if err := d.deadLetters(ctx, letter); err != nil {
+		log.Println("kit.webhook.Dispatcher.attempt.d.deadLetters", err)
+	}
+}
+
+// retry hands next back to the workers once its backoff is over, doubling
+// the backoff of the attempt after.
+func (d *Dispatcher) retry(next *delivery) {
+	backoff := next.backoff
+	if next.backoff *= 2; d.settings.MaxBackoff > 0 && next.backoff > d.settings.MaxBackoff {
+		next.backoff = d.settings.MaxBackoff
+	}
+	time.AfterFunc(backoff, func() { d.retries <- next })
+}
+
+// post makes one attempt, an error being returned for the statuses other
+// than 2xx as well. The status is zero when there was no answer.
+func (d *Dispatcher) post(ctx context.Context, hook t.Webhook, payload Payload, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "openvino-training-extension-webhook")
+	req.Header.Set("X-Webhook-Event", payload.Event)
+	req.Header.Set("X-Webhook-Delivery", payload.Id)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(hook.Secret, timestamp, body))
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New("webhook answered " + resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign is the hex HMAC-SHA256, keyed with secret, of the timestamp and the
+// body joined by a dot.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryable tells the failed attempts worth another one, status being zero
+// when the receiver did not answer.
+func retryable(status int) bool {
+	switch {
+	case status == 0, status >= 500:
+		return true
+	case status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
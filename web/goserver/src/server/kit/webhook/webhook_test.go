@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	t "server/db/pkg/types"
+)
+
+func noDeadLetters(context.Context, t.WebhookDeadLetter) error { return nil }
+
+func TestCheckUrlRefusesInternalAddresses(test *testing.T) {
+	d := NewDispatcher(nil, noDeadLetters, Settings{PrivateHosts: []string{"10.1.2.3"}})
+	cases := []struct {
+		url     string
+		allowed bool
+	}{
+		{"http://127.0.0.1:5672/", false},
+		{"http://localhost:27017/", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://10.0.0.1/", false},
+		{"http://172.16.5.4/", false},
+		{"http://192.168.1.1/", false},
+		{"http://[::1]/", false},
+		{"http://[fd00::1]/", false},
+		{"http://0.0.0.0/", false},
+		{"http://10.1.2.3/hook", true},
+		{"https://8.8.8.8/hook", true},
+		{"http://172.32.0.1/hook", true},
+	}
+	for _, c := range cases {
+		err := d.CheckUrl(context.Background(), c.url)
+		if c.allowed && err != nil {
+			test.Errorf("%s: %v", c.url, err)
+		}
+		if !c.allowed && !errors.Is(err, ErrAddressNotAllowed) {
+			test.Errorf("%s: error %v, want ErrAddressNotAllowed", c.url, err)
+		}
+	}
+}
+
+// A host checked when the webhook was registered may resolve to an internal
+// address later on, the delivery refuses the address it connects to.
+func TestDeliveryRefusesInternalAddresses(test *testing.T) {
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+	}))
+	defer server.Close()
+	d := NewDispatcher(nil, noDeadLetters, Settings{Timeout: time.Second})
+
+	hook := t.Webhook{Url: server.URL}
+	if _, err := d.post(context.Background(), hook, Payload{Event: "test"}, []byte("{}")); !errors.Is(err, ErrAddressNotAllowed) {
+		test.Fatalf("error %v, want ErrAddressNotAllowed", err)
+	}
+	if n := atomic.LoadInt64(&requests); n != 0 {
+		test.Errorf("%d requests reached the server", n)
+	}
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		test.Fatal(err)
+	}
+	host, _, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		test.Fatal(err)
+	}
+	d = NewDispatcher(nil, noDeadLetters, Settings{Timeout: time.Second, PrivateHosts: []string{host}})
+	if _, err := d.post(context.Background(), hook, Payload{Event: "test"}, []byte("{}")); err != nil {
+		test.Fatal(err)
+	}
+}
+
+// A receiver that is down waits for its next attempt without holding the
+// only worker, the events of the other problems are delivered meanwhile.
+func TestRetriesDoNotBlockTheWorkers(test *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	delivered := make(chan string, 1)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- r.Header.Get("X-Webhook-Event")
+	}))
+	defer up.Close()
+
+	downProblem, upProblem := primitive.NewObjectID(), primitive.NewObjectID()
+	find := func(_ context.Context, problemId primitive.ObjectID, _ string) ([]t.Webhook, error) {
+		if problemId == downProblem {
+			return []t.Webhook{{Url: down.URL}}, nil
+		}
+		return []t.Webhook{{Url: up.URL}}, nil
+	}
+	d := NewDispatcher(find, noDeadLetters, Settings{
+		Attempts:     5,
+		Backoff:      time.Hour,
+		Timeout:      time.Second,
+		BufferSize:   2,
+		Workers:      1,
+		PrivateHosts: []string{"127.0.0.1"},
+	})
+	d.Emit("modelImported", downProblem, nil)
+	d.Emit("modelDeleted", upProblem, nil)
+	select {
+	case event := <-delivered:
+		if event != "modelDeleted" {
+			test.Errorf("event %q delivered", event)
+		}
+	case <-time.After(5 * time.Second):
+		test.Fatal("the retry of the receiver down held the worker")
+	}
+}
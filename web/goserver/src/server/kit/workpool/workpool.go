@@ -0,0 +1,260 @@
+// Package workpool runs tasks on a fixed number of workers fed by a bounded
+// queue, for the services that fan work out: the downloads of the
+// dependencies of a model, the templates of a batch import.
+package workpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"server/kit/metrics"
+)
+
+var (
+	// ErrFull is returned by TrySubmit when the queue has no room left.
+	ErrFull = errors.New("workpool: queue is full")
+	// ErrClosed is returned when submitting to a pool being closed.
+	ErrClosed = errors.New("workpool: pool is closed")
+)
+
+// Task is the work submitted to a pool. Its result is taken from its Future,
+// the callers asserting its type.
+type Task func(ctx context.Context) (interface{}, error)
+
+// PanicError is the error of a task that panicked, the worker recovering.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("workpool: task panicked: %v", e.Value)
+}
+
+// Hooks are told about the tasks of a pool, for metrics. Each may be nil.
+// Queued gets the changes of the queue depth, Started the time a task waited
+// for a worker and Finished the time it ran.
+type Hooks struct {
+	Queued   func(delta int)
+	Started  func(wait time.Duration)
+	Finished func(run time.Duration, err error)
+}
+
+// Options of a pool. Workers below one is taken for one. QueueSize is the
+// number of tasks waiting for a worker the pool holds, Submit blocking when
+// it is reached; with none, a task is only taken when a worker is free.
+type Options struct {
+	Workers   int
+	QueueSize int
+	Hooks     Hooks
+}
+
+// Pool runs the submitted tasks on its workers until it is closed.
+type Pool struct {
+	hooks Hooks
+	tasks chan job
+	wg    sync.WaitGroup
+
+	// mu guards closed and the sends on tasks, so that Close does not close
+	// tasks under a Submit.
+	mu     sync.RWMutex
+	closed bool
+}
+
+type job struct {
+	ctx       context.Context
+	task      Task
+	future    *Future
+	submitted time.Time
+}
+
+// New starts the workers of a pool.
+func New(opts Options) *Pool {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.QueueSize < 0 {
+		opts.QueueSize = 0
+	}
+	p := &Pool{hooks: opts.Hooks, tasks: make(chan job, opts.QueueSize)}
+	p.wg.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Submit queues task, blocking while the queue is full until ctx is done.
+// The task is run with ctx, even when ctx is done by the time a worker takes
+// it: the task finds out and tells how it ends.
+func (p *Pool) Submit(ctx context.Context, task Task) (*Future, error) {
+	j := job{ctx: ctx, task: task, future: &Future{done: make(chan struct{})}}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	j.submitted = time.Now()
+	p.queued(1)
+	select {
+	case p.tasks <- j:
+	case <-ctx.Done():
+		p.queued(-1)
+		return nil, ctx.Err()
+	}
+	return j.future, nil
+}
+
+// TrySubmit queues task like Submit, but returns ErrFull instead of waiting
+// for room in the queue.
+func (p *Pool) TrySubmit(ctx context.Context, task Task) (*Future, error) {
+	j := job{ctx: ctx, task: task, future: &Future{done: make(chan struct{})}, submitted: time.Now()}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	p.queued(1)
+	select {
+	case p.tasks <- j:
+	default:
+		p.queued(-1)
+		return nil, ErrFull
+	}
+	return j.future, nil
+}
+
+// Go submits f, whose end nobody waits for but Close.
+func (p *Pool) Go(ctx context.Context, f func(ctx context.Context)) error {
+	_, err := p.Submit(ctx, func(ctx context.Context) (interface{}, error) {
+		f(ctx)
+		return nil, nil
+	})
+	return err
+}
+
+// Close stops the pool taking tasks and waits for those queued and running
+// to finish, or for ctx to be done, whose error it then returns. The workers
+// go on with the tasks left in the latter case.
+func (p *Pool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+	p.mu.Unlock()
+	drained := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for j := range p.tasks {
+		start := time.Now()
+		p.queued(-1)
+		if p.hooks.Started != nil {
+			p.hooks.Started(start.Sub(j.submitted))
+		}
+		result, err := run(j)
+		if p.hooks.Finished != nil {
+			p.hooks.Finished(time.Since(start), err)
+		}
+		j.future.result, j.future.err = result, err
+		close(j.future.done)
+	}
+}
+
+func (p *Pool) queued(delta int) {
+	if p.hooks.Queued != nil {
+		p.hooks.Queued(delta)
+	}
+}
+
+// run runs the task of j, turning a panic into a PanicError so that the
+// worker survives it.
+func run(j job) (result interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			stack := debug.Stack()
+			log.Printf("kit.workpool.run: task panicked: %v\n%s", v, stack)
+			result, err = nil, &PanicError{Value: v, Stack: stack}
+		}
+	}()
+	return j.task(j.ctx)
+}
+
+// Future is the end of a submitted task.
+type Future struct {
+	done   chan struct{}
+	result interface{}
+	err    error
+}
+
+// Done is closed when the task finished.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait returns the result of the task once it finished, or the error of ctx
+// when it is done first.
+func (f *Future) Wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+var (
+	queueDepth = metrics.NewGauge(
+		"workpool_queue_depth",
+		"Tasks waiting for a worker, by pool.",
+		"pool",
+	)
+	taskDuration = metrics.NewHistogram(
+		"workpool_task_duration_seconds",
+		"Time tasks waited for a worker and ran, by pool and stage.",
+		metrics.DefBuckets,
+		"pool", "stage",
+	)
+	taskPanics = metrics.NewCounter(
+		"workpool_task_panics_total",
+		"Tasks that panicked, by pool.",
+		"pool",
+	)
+)
+
+// Metrics are the hooks exporting the queue depth and the task latency of
+// the pools called name. Pools may share a name, their queues adding up.
+func Metrics(name string) Hooks {
+	return Hooks{
+		Queued: func(delta int) { queueDepth.Add(float64(delta), name) },
+		Started: func(wait time.Duration) {
+			taskDuration.Observe(wait.Seconds(), name, "wait")
+		},
+		Finished: func(run time.Duration, err error) {
+			taskDuration.Observe(run.Seconds(), name, "run")
+			if _, ok := err.(*PanicError); ok {
+				taskPanics.Add(1, name)
+			}
+		},
+	}
+}
@@ -6,15 +6,43 @@ import (
 	"time"
 
 	n "server/common/names"
+	"server/kit/health"
+	"server/kit/trace"
 	"server/workers/train/cmd/service"
+	trainService "server/workers/train/pkg/service"
 )
 
 var amqpAddr = flag.String("amqpAddr", "idlp_rabbitmq:5672", "amqp service address")
 var amqpUser = flag.String("amqpUser", "guest", "amqp service user")
 var amqpPass = flag.String("amqpPass", "guest", "amqp service password")
+var adminAddr = flag.String("adminAddr", ":2112", "address serving /healthz, /readyz and /metrics, disabled when empty")
+var otlpEndpoint = flag.String("otlpEndpoint", "", "OTLP/HTTP collector receiving the traces, e.g. http://otel-collector:4318, disabled when empty")
+var traceSampleRatio = flag.Float64("traceSampleRatio", 0.1, "share of the traces started here that are recorded")
+var backend = flag.String("backend", "exec", "how the trainings and evaluations run: exec runs their commands, simulate fakes them without gpu or frameworks, for the end-to-end tests")
+var simulateEpochMillis = flag.Int("simulateEpochMillis", 500, "milliseconds a simulated epoch lasts")
+var simulatePatience = flag.Int("simulatePatience", 0, "epochs without improvement after which a simulated training stops early, never when 0")
+var simulateSeed = flag.Int64("simulateSeed", 1, "seed of the simulated losses and metrics")
+var simulateGpus = flag.Int("simulateGpus", 1, "gpus the simulated worker tells it has")
+
+// simulation fakes the trainings and evaluations, nil when they are run.
+var simulation *trainService.SimulationSettings
 
 func main() {
 	flag.Parse()
+	switch *backend {
+	case "exec":
+	case "simulate":
+		simulation = &trainService.SimulationSettings{
+			EpochInterval: time.Duration(*simulateEpochMillis) * time.Millisecond,
+			Patience:      *simulatePatience,
+			Seed:          *simulateSeed,
+			Gpus:          *simulateGpus,
+		}
+	default:
+		log.Fatalf("unknown backend %q, exec or simulate", *backend)
+	}
+	trace.Init("train_worker", *otlpEndpoint, *traceSampleRatio)
+	go health.ListenAndServe(*adminAddr, health.CheckAmqp)
 	go NeverExit("TRAIN WORKER")
 	select {}
 }
@@ -28,5 +56,5 @@ func NeverExit(serviceName string) {
 			go NeverExit(serviceName) // restart
 		}
 	}()
-	service.Run(n.QTrainModel, amqpAddr, amqpUser, amqpPass)
+	service.Run(n.QTrainModel, amqpAddr, amqpUser, amqpPass, simulation)
 }
@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
 
 	n "server/common/names"
 	t "server/common/types"
+	"server/kit/health"
 	kitutils "server/kit/utils"
 	"server/workers/train/pkg/endpoint"
 	getGpuAmount "server/workers/train/pkg/handler/get_gpu_amount"
@@ -16,13 +18,19 @@ import (
 	"server/workers/train/pkg/service"
 )
 
-func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass *string) {
+// metricsInterval is how often the queue depth and gpu gauges are refreshed.
+const metricsInterval = 15 * time.Second
+
+// Run serves the requests of serviceQueueName, simulating the trainings and
+// evaluations with simulation unless it is nil.
+func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass *string, simulation *service.SimulationSettings) {
 	fmt.Println(*amqpAddr, *amqpUser, *amqpPass, serviceQueueName)
 	amqpUrl := fmt.Sprintf("amqp://%s:%s@%s/", *amqpUser, *amqpPass, *amqpAddr)
 	conn, err := rabbitmq.Dial(amqpUrl)
 	if err != nil {
 		log.Panic(err)
 	}
+	health.Set(health.CheckAmqp, health.Amqp(conn))
 	ch, err := conn.Channel()
 	if err != nil {
 		log.Panic(err)
@@ -46,7 +54,10 @@ func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass *string) {
 	if err != nil {
 		log.Panicln("Qos", err)
 	}
-	svc := service.New(conn, getServiceMiddleware())
+	stop := make(chan struct{})
+	defer close(stop)
+	go service.ReportMetrics(conn, serviceQueueName, metricsInterval, stop)
+	svc := service.New(conn, getServiceMiddleware(simulation))
 	eps := endpoint.New(svc)
 	go func() {
 
@@ -70,9 +81,13 @@ func Run(serviceQueueName string, amqpAddr, amqpUser, amqpPass *string) {
 	select {}
 }
 
-func getServiceMiddleware() (mw []service.Middleware) {
+func getServiceMiddleware(simulation *service.SimulationSettings) (mw []service.Middleware) {
 	mw = []service.Middleware{}
 	// Append your middleware here
+	if simulation != nil {
+		log.Println("workers.train.cmd.service.Run: trainings and evaluations are simulated")
+		mw = append(mw, service.Simulate(*simulation))
+	}
 
 	return
 }
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
+
+	"server/kit/metrics"
+)
+
+var (
+	runningJobs = metrics.NewGauge(
+		"training_jobs_running",
+		"Training jobs the worker is running.",
+	)
+	queueDepth = metrics.NewGauge(
+		"training_queue_depth",
+		"Training jobs waiting in the queue of the worker.",
+	)
+	gpuUtilization = metrics.NewGauge(
+		"gpu_utilization_percent",
+		"Utilization of each gpu of the worker as reported by nvidia-smi.",
+		"gpu",
+	)
+)
+
+// ReportMetrics refreshes the queue depth and gpu utilization gauges every
+// interval until stop is closed.
+func ReportMetrics(conn *rabbitmq.Connection, queue string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	gpus := true
+	for {
+		reportQueueDepth(conn, queue)
+		if gpus {
+			if err := reportGpuUtilization(); err != nil {
+				log.Println("workers.train.pkg.service.metrics.ReportMetrics: gpu utilization is not reported", err)
+				gpus = false
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func reportQueueDepth(conn *rabbitmq.Connection, queue string) {
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Println("workers.train.pkg.service.metrics.reportQueueDepth", err)
+		return
+	}
+	defer ch.Close()
+	q, err := ch.QueueInspect(queue)
+	if err != nil {
+		log.Println("workers.train.pkg.service.metrics.reportQueueDepth", err)
+		return
+	}
+	queueDepth.Set(float64(q.Messages))
+}
+
+func reportGpuUtilization() error {
+	out, err := exec.Command("nvidia-smi", "--query-gpu=index,utilization.gpu", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil {
+			continue
+		}
+		gpuUtilization.Set(value, strings.TrimSpace(fields[0]))
+	}
+	return nil
+}
@@ -19,6 +19,8 @@ type RunCommandsRequestData struct {
 func (s *basicTrainModelService) RunCommands(ctx context.Context, req RunCommandsRequestData) (interface{}, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	runningJobs.Add(1)
+	defer runningJobs.Add(-1)
 
 	f, err := os.Create(req.OutputLog)
 	if err != nil {
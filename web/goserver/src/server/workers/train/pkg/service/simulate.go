@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	fp "path/filepath"
+	"time"
+
+	"github.com/mattn/go-shellwords"
+	"gopkg.in/yaml.v2"
+)
+
+// SimulationSettings tell how the simulated backend fakes the trainings and
+// the evaluations.
+type SimulationSettings struct {
+	// EpochInterval is how long a simulated epoch lasts, a millisecond
+	// when not positive.
+	EpochInterval time.Duration
+	// Patience stops a training once its validation accuracy did not
+	// improve for Patience epochs, never when zero.
+	Patience int
+	// Seed seeds the losses and the metrics, which depend on it and on the
+	// model folder or weights only.
+	Seed int64
+	// Gpus is the number of gpus the worker tells it has.
+	Gpus int
+}
+
+// Simulate replaces the training scripts run by RunCommands with a
+// simulation, for the tests of the import, train, evaluate and export flow
+// on machines without gpu or frameworks. The pip installs are skipped, a
+// training, told by --save-checkpoints-to, logs synthetic epochs every
+// EpochInterval and writes a dummy latest.pth after each, and an
+// evaluation, told by --save-metrics-to, writes deterministic metrics. Any
+// other command fails. The simulation stops with the error of ctx once it
+// is done.
+func Simulate(settings SimulationSettings) Middleware {
+	if settings.EpochInterval <= 0 {
+		settings.EpochInterval = time.Millisecond
+	}
+	return func(next TrainModelService) TrainModelService {
+		return &simulatedService{TrainModelService: next, settings: settings}
+	}
+}
+
+type simulatedService struct {
+	TrainModelService
+	settings SimulationSettings
+}
+
+func (s *simulatedService) GetGpuAmount(ctx context.Context, req GetGpuAmountRequestData) (interface{}, error) {
+	return GetGpuAmountResponseData{Amount: s.settings.Gpus}, nil
+}
+
+func (s *simulatedService) RunCommands(ctx context.Context, req RunCommandsRequestData) (interface{}, error) {
+	runningJobs.Add(1)
+	defer runningJobs.Add(-1)
+
+	out := ioutil.Discard
+	if f, err := os.Create(req.OutputLog); err != nil {
+		log.Println("workers.train.pkg.service.simulate.RunCommands.os.Create(req.OutputLog)", err)
+	} else {
+		defer f.Close()
+		out = f
+	}
+	for _, command := range req.Commands {
+		args, err := shellwords.Parse(command)
+		if err != nil || len(args) == 0 {
+			return nil, fmt.Errorf("command %q can not be parsed: %v", command, err)
+		}
+		if args[0] == "pip" {
+			fmt.Fprintf(out, "simulation: skipped %s\n", command)
+			continue
+		}
+		flags := simulatedFlags(args)
+		switch {
+		case flags["--save-checkpoints-to"] != "":
+			err = s.train(ctx, out, flags)
+		case flags["--save-metrics-to"] != "":
+			err = s.evaluate(out, flags)
+		default:
+			err = fmt.Errorf("command %q is neither a training nor an evaluation", command)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "simulation: %s failed: %v\n", command, err)
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// simulatedFlags are the --flag value pairs of args.
+func simulatedFlags(args []string) map[string]string {
+	flags := make(map[string]string)
+	for i := 0; i+1 < len(args); i++ {
+		if len(args[i]) > 2 && args[i][:2] == "--" {
+			flags[args[i]] = args[i+1]
+			i++
+		}
+	}
+	return flags
+}
+
+// random is the source of the synthetic values of key, the same for the
+// same seed and key.
+func (s *simulatedService) random(key string) *rand.Rand {
+	h := fnv.New64a()
+	io.WriteString(h, key)
+	return rand.New(rand.NewSource(s.settings.Seed ^ int64(h.Sum64())))
+}
+
+func (s *simulatedService) train(ctx context.Context, out io.Writer, flags map[string]string) error {
+	dir := flags["--save-checkpoints-to"]
+	epochs := 1
+	if _, err := fmt.Sscan(flags["--epochs"], &epochs); err != nil || epochs < 1 {
+		return fmt.Errorf("--epochs %q is not a positive number", flags["--epochs"])
+	}
+	r := s.random(dir)
+	best, stale := 0.0, 0
+	ticker := time.NewTicker(s.settings.EpochInterval)
+	defer ticker.Stop()
+	for epoch := 1; epoch <= epochs; epoch++ {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			fmt.Fprintf(out, "simulation: stopped at epoch %d: %v\n", epoch, ctx.Err())
+			return ctx.Err()
+		}
+		progress := float64(epoch) / float64(epochs)
+		loss := 2*math.Exp(-3*progress) + 0.05*r.Float64()
+		accuracy := 0.9*(1-math.Exp(-4*progress)) + 0.02*r.Float64()
+		fmt.Fprintf(out, "Epoch [%d/%d] loss: %.4f val_accuracy: %.4f\n", epoch, epochs, loss, accuracy)
+		checkpoint := fmt.Sprintf("simulated checkpoint, epoch %d of %d\n", epoch, epochs)
+		if err := ioutil.WriteFile(fp.Join(dir, "latest.pth"), []byte(checkpoint), 0666); err != nil {
+			return err
+		}
+		if accuracy > best+0.001 {
+			best, stale = accuracy, 0
+			continue
+		}
+		stale++
+		if s.settings.Patience > 0 && stale >= s.settings.Patience {
+			fmt.Fprintf(out, "simulation: early stopping at epoch %d, no improvement for %d epochs\n", epoch, stale)
+			return nil
+		}
+	}
+	return nil
+}
+
+// simulatedMetric is a metric as the evaluation scripts write it.
+type simulatedMetric struct {
+	DisplayName string `yaml:"display_name"`
+	Key         string `yaml:"key"`
+	Value       string `yaml:"value"`
+	Unit        string `yaml:"unit"`
+}
+
+func (s *simulatedService) evaluate(out io.Writer, flags map[string]string) error {
+	path := flags["--save-metrics-to"]
+	r := s.random(flags["--load-weights"])
+	doc := struct {
+		Metrics []simulatedMetric `yaml:"metrics"`
+	}{
+		Metrics: []simulatedMetric{
+			{DisplayName: "AP @ [IoU=0.50:0.95]", Key: "ap", Value: fmt.Sprintf("%.1f", 20+30*r.Float64()), Unit: "%"},
+			{DisplayName: "Accuracy", Key: "accuracy", Value: fmt.Sprintf("%.1f", 60+35*r.Float64()), Unit: "%"},
+		},
+	}
+	content, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(fp.Dir(path), 0777); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, content, 0666); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "simulation: metrics written to %s\n", path)
+	return nil
+}